@@ -5,24 +5,26 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"sort"
+	"time"
 	//"github.com/petar/GoGauge/gauge"
 	"github.com/petar/GoDCCP/dccp"
 	dccp_gauge "github.com/petar/GoDCCP/dccp/gauge"
 )
 
 var (
-	flagReport *string = flag.String("report", "basic", "Report types: basic, trip")
+	flagReport *string = flag.String("report", "basic", "Report types: basic, trip, xplot, seq, rate")
 	flagEmits  *bool = flag.Bool("emits", true, "Include emits with stack trace logs")
+	flagHTTP   *string = flag.String("http", "", "Serve an interactive web UI on this address, e.g. localhost:8080, instead of a report")
+	flagFlow   *string = flag.String("flow", "client", "Endpoint whose sent packets the xplot, seq and rate reports follow")
+	flagBin    *time.Duration = flag.Duration("bin", 100*time.Millisecond, "Width of the time bins of the rate report")
 )
 
 func usage() {
-	fmt.Printf("%s [optional_flags] log_file\n", os.Args[0])
+	fmt.Printf("%s [optional_flags] log_file|trace_file\n", os.Args[0])
 	flag.PrintDefaults()
 	os.Exit(1)
 }
@@ -43,35 +45,38 @@ func main() {
 		os.Exit(1)
 	}
 	defer logFile.Close()
-	logDec := json.NewDecoder(logFile)
 
 	// Raw log entries will go into emits
-	var emits []*dccp.LogRecord = make([]*dccp.LogRecord, 0)
-	for {
-		rec := &dccp.LogRecord{}
-		if err = logDec.Decode(rec); err != nil {
-			break
-		}
-		emits = append(emits, rec)
-	}
+	emits, err := readTraces(logFile)
 	fmt.Fprintf(os.Stderr, "Read %d records.\n", len(emits))
-	if err != io.EOF {
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Terminated unexpectedly (%s).\n", err)
 	}
 
+	// The web UI replaces the reports
+	if *flagHTTP != "" {
+		if err = serve(*flagHTTP, newTimeline(emits)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving (%s)\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Fork to desired reducer
 	switch *flagReport {
 	case "basic":
 		htmlBasic(emits, *flagEmits)
 	case "trip":
 		printTrip(emits)
+	case "xplot", "seq", "rate":
+		printTimeSeq(emits, *flagReport, *flagFlow, *flagBin)
 	}
 
 	printStats(emits)
 }
 
-func printStats(emits []*dccp.LogRecord) {
-	sort.Sort(LogRecordTimeSort(emits))
+func printStats(emits []*dccp.Trace) {
+	sort.Sort(TraceTimeSort(emits))
 	reducer := dccp_gauge.NewLogReducer()
 	for _, rec := range emits {
 		reducer.Write(rec)
@@ -82,23 +87,23 @@ func printStats(emits []*dccp.LogRecord) {
 	fmt.Fprintf(os.Stderr, "Send rate: %g pkt/sec, Receive rate: %g pkt/sec\n", sr, rr)
 }
 
-// LogRecordTimeSort sorts LogRecord records by timestamp
-type LogRecordTimeSort []*dccp.LogRecord
+// TraceTimeSort sorts LogRecord records by timestamp
+type TraceTimeSort []*dccp.Trace
 
-func (t LogRecordTimeSort) Len() int {
+func (t TraceTimeSort) Len() int {
 	return len(t)
 }
 
-func (t LogRecordTimeSort) Less(i, j int) bool {
+func (t TraceTimeSort) Less(i, j int) bool {
 	return t[i].Time < t[j].Time
 }
 
-func (t LogRecordTimeSort) Swap(i, j int) {
+func (t TraceTimeSort) Swap(i, j int) {
 	t[i], t[j] = t[j], t[i]
 }
 
 // TODO: Not used any more; Remove
-func printBasic(emits []*dccp.LogRecord) {
+func printBasic(emits []*dccp.Trace) {
 	prints := make([]*PrintRecord, 0)
 	for _, t := range emits {
 		var p *PrintRecord = printRecord(t)
@@ -109,7 +114,7 @@ func printBasic(emits []*dccp.LogRecord) {
 	Print(prints, true)
 }
 
-func htmlBasic(emits []*dccp.LogRecord, includeEmits bool) {
+func htmlBasic(emits []*dccp.Trace, includeEmits bool) {
 	lps := make([]*logPipe, 0)
 	for _, t := range emits {
 		p := pipeEmit(t)
@@ -0,0 +1,50 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// readTraces reads the traces of a log file, which holds either the JSON of a
+// dccp.FileTraceWriter or the binary format of a dccp.BinaryTraceWriter. On error, it returns
+// the traces that it read up to it.
+func readTraces(r io.Reader) ([]*dccp.Trace, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(dccp.BinaryTraceMagic)); string(magic) == dccp.BinaryTraceMagic {
+		return dccp.NewTraceReader(br).ReadAll()
+	}
+	dec := json.NewDecoder(br)
+	traces := make([]*dccp.Trace, 0)
+	for {
+		rec := &dccp.Trace{}
+		if err := dec.Decode(rec); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return traces, err
+		}
+		decodeSample(rec)
+		traces = append(traces, rec)
+	}
+}
+
+// decodeSample replaces the JSON object of the sample of r, if it has one, with a dccp.Sample,
+// so that r.Sample works as it does for the traces of a running Env
+func decodeSample(r *dccp.Trace) {
+	m, ok := r.Args[dccp.SampleType].(map[string]interface{})
+	if !ok {
+		return
+	}
+	var s dccp.Sample
+	s.Series, _ = m["Series"].(string)
+	s.Value, _ = m["Value"].(float64)
+	s.Unit, _ = m["Unit"].(string)
+	r.Args[dccp.SampleType] = s
+}
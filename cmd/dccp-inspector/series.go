@@ -31,19 +31,18 @@ func (x *SeriesSweeper) Init() {
 
 // Add adds a new log record to the series. It assumes that records are added
 // in increasing chronological order
-func (x *SeriesSweeper) Add(r *dccp.LogRecord) {
+func (x *SeriesSweeper) Add(r *dccp.Trace) {
 	if !r.IsHighlighted() {
 		return
 	}
 	// Check that the argument is a sample
-	m_, ok := r.Args[dccp.SampleType]
+	m, ok := r.Sample()
 	if !ok {
 		return
 	}
 	// Read sample data
-	m := m_.(map[string]interface{})
-	value := m["Value"].(float64)
-	series := r.LabelString() + m["Series"].(string)
+	value := m.Value
+	series := r.LabelString() + m.Series
 	for _, u := range x.series {
 		if u == series {
 			goto __SeriesSaved
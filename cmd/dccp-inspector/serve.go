@@ -0,0 +1,63 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// serve serves the web UI over the timeline t on addr. It returns only if the server fails.
+//
+// The page at / fetches the timeline from /timeline, which takes comma-separated lists of
+// packet types and subsystems to show in the parameters types and subsystems, and the detail
+// of a packet that is clicked from /packet?id=N. A parameter that is left out passes all.
+func serve(addr string, t *timeline) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(servePage))
+	})
+	mux.HandleFunc("/timeline", func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		serveJSON(w, t.view(splitParam(q, "types"), splitParam(q, "subsystems")))
+	})
+	mux.HandleFunc("/packet", func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(req.URL.Query().Get("id"))
+		d := t.detail(id)
+		if err != nil || d == nil {
+			http.Error(w, "no such packet", http.StatusNotFound)
+			return
+		}
+		serveJSON(w, d)
+	})
+	fmt.Fprintf(os.Stderr, "Serving the inspector on http://%s/\n", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+func serveJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding response (%s)\n", err)
+	}
+}
+
+// splitParam splits the comma-separated list of the parameter name, which is nil if q does not
+// have the parameter. The subsystem of the Conn itself is the empty string.
+func splitParam(q url.Values, name string) []string {
+	if _, ok := q[name]; !ok {
+		return nil
+	}
+	return strings.Split(q.Get(name), ",")
+}
@@ -0,0 +1,262 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package main
+
+/*
+  UI behavior of the served page:
+	o The time-sequence view has a lane for each endpoint. Each packet is a line from the
+	lane of its sender, at the time it was written, to the lane of its receiver, at the time
+	it was read. Lost packets end halfway, in a cross.
+	o The checked series are drawn over the lanes, each scaled to its own range, which the
+	legend shows.
+	o The wheel zooms in and out of time around the pointer, and dragging pans. Reset shows
+	the whole trace again.
+	o Clicking a packet shows its header, its options and its traces below the view.
+	o The checkboxes of packet types and subsystems filter the packets and the series.
+*/
+
+const servePage = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>DCCP Inspector</title>
+<style>
+body { font-family: 'Droid Sans Mono', monospace; font-size: 12px; margin: 8px; }
+fieldset { display: inline-block; vertical-align: top; border: 1px solid #ccc; margin: 0 8px 8px 0; }
+label { margin-right: 8px; white-space: nowrap; }
+#series label { display: block; }
+svg { border: 1px solid #ccc; background: #fdfdfd; cursor: crosshair; user-select: none; }
+.lane { stroke: #999; }
+.lane-name { font-weight: bold; fill: #333; }
+.flight { stroke-width: 1.5; cursor: pointer; }
+.flight:hover, .flight.selected { stroke-width: 4; }
+.axis { fill: #666; }
+.tick { stroke: #eee; }
+table { border-collapse: collapse; margin: 8px 16px 8px 0; display: inline-table; vertical-align: top; }
+td, th { border-top: 1px dotted #ccc; padding: 1px 6px; text-align: left; }
+</style>
+</head>
+<body>
+<div>
+<fieldset><legend>Packet types</legend><div id="types"></div></fieldset>
+<fieldset><legend>Subsystems</legend><div id="subsystems"></div></fieldset>
+<fieldset><legend>Series</legend><div id="series"></div></fieldset>
+<button id="reset">Reset zoom</button>
+</div>
+<svg id="view" width="1200" height="480"></svg>
+<div id="detail"></div>
+<script type="text/javascript">
+"use strict";
+var SVG = "http://www.w3.org/2000/svg";
+var colors = ["#cc0000", "#00aa00", "#0000cc", "#00aaaa", "#cc00cc", "#aa8800", "#666666", "#ff6600", "#6600ff", "#008866"];
+var tl = null;           // Current view of the timeline
+var win = null;          // Time window shown, in ms
+var known = null;        // All types and subsystems, once fetched
+var checkedSeries = {};  // Names of the series that are drawn
+var selected = -1;       // Id of the packet whose detail is shown
+
+function el(name, attrs, parent) {
+	var e = document.createElementNS(SVG, name);
+	for (var k in attrs) { e.setAttribute(k, attrs[k]); }
+	if (parent) { parent.appendChild(e); }
+	return e;
+}
+
+function esc(s) {
+	return String(s).replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;");
+}
+
+function colorOf(list, name) {
+	var i = list.indexOf(name);
+	return colors[(i < 0 ? 0 : i) % colors.length];
+}
+
+function checked(id) {
+	var r = [];
+	document.querySelectorAll("#" + id + " input:checked").forEach(function(c) { r.push(c.value); });
+	return r;
+}
+
+function checkboxes(id, names, isChecked, onchange) {
+	var box = document.getElementById(id);
+	box.innerHTML = "";
+	names.forEach(function(name) {
+		var l = document.createElement("label");
+		var c = document.createElement("input");
+		c.type = "checkbox";
+		c.value = name;
+		c.checked = isChecked(name);
+		c.onchange = onchange;
+		l.appendChild(c);
+		l.appendChild(document.createTextNode(name || "(none)"));
+		box.appendChild(l);
+	});
+}
+
+// param returns the filter parameter of the checked names; "-" matches none
+function param(id) {
+	var c = checked(id);
+	return encodeURIComponent(c.length == 0 ? "-" : c.join(","));
+}
+
+function load() {
+	var url = "/timeline";
+	if (known) {
+		url += "?types=" + param("types") + "&subsystems=" + param("subsystems");
+	}
+	fetch(url).then(function(r) { return r.json(); }).then(function(v) {
+		tl = v;
+		if (!known) {
+			known = { types: v.types, subsystems: v.subsystems };
+			checkboxes("types", v.types, function() { return true; }, load);
+			checkboxes("subsystems", v.subsystems, function() { return true; }, load);
+			// Rates and windows of the CCIDs are drawn at first, or else the first two series
+			var any = false;
+			v.series.forEach(function(s) {
+				checkedSeries[s.name] = /rate|cwnd/i.test(s.name);
+				any = any || checkedSeries[s.name];
+			});
+			v.series.slice(0, any ? 0 : 2).forEach(function(s) { checkedSeries[s.name] = true; });
+		}
+		if (!win) {
+			win = [v.start, Math.max(v.end, v.start + 1)];
+		}
+		checkboxes("series", v.series.map(function(s) { return s.name; }),
+			function(name) { return checkedSeries[name]; },
+			function() { checkedSeries[this.value] = this.checked; draw(); });
+		draw();
+	});
+}
+
+function draw() {
+	var svg = document.getElementById("view");
+	var W = svg.clientWidth || 1200, H = svg.clientHeight || 480;
+	var left = 80, right = 20, top = 30, bottom = H - 40;
+	while (svg.firstChild) { svg.removeChild(svg.firstChild); }
+	var x = function(t) { return left + (t - win[0]) / (win[1] - win[0]) * (W - left - right); };
+	var n = tl.endpoints.length;
+	var laneY = {};
+	tl.endpoints.forEach(function(e, i) {
+		laneY[e] = n == 1 ? (top + bottom) / 2 : top + i * (bottom - top) / (n - 1);
+	});
+
+	// Time axis
+	var span = win[1] - win[0];
+	var step = Math.pow(10, Math.floor(Math.log10(span / 5)));
+	if (span / step > 12) { step *= 2; }
+	if (span / step > 12) { step *= 2.5; }
+	for (var t = Math.ceil(win[0] / step) * step; t <= win[1]; t += step) {
+		el("line", { x1: x(t), x2: x(t), y1: top - 10, y2: bottom + 10, "class": "tick" }, svg);
+		el("text", { x: x(t), y: H - 12, "text-anchor": "middle", "class": "axis" }, svg).textContent = +t.toFixed(3) + "ms";
+	}
+
+	// Lanes
+	tl.endpoints.forEach(function(e) {
+		el("line", { x1: left, x2: W - right, y1: laneY[e], y2: laneY[e], "class": "lane" }, svg);
+		el("text", { x: 4, y: laneY[e] + 4, "class": "lane-name" }, svg).textContent = e;
+	});
+
+	// Series, each scaled to its range over the whole trace
+	var legend = 0;
+	tl.series.forEach(function(s, i) {
+		if (!checkedSeries[s.name] || s.points.length == 0) { return; }
+		var lo = Infinity, hi = -Infinity;
+		s.points.forEach(function(p) { lo = Math.min(lo, p[1]); hi = Math.max(hi, p[1]); });
+		var y = function(v) { return hi == lo ? (top + bottom) / 2 : bottom - (v - lo) / (hi - lo) * (bottom - top); };
+		var d = "";
+		s.points.forEach(function(p, j) {
+			d += j == 0 ? "M" + x(p[0]) + "," + y(p[1]) : "H" + x(p[0]) + "V" + y(p[1]);
+		});
+		var c = colors[(i + 3) % colors.length];
+		el("path", { d: d, fill: "none", stroke: c, "stroke-width": 1, "stroke-opacity": 0.7 }, svg);
+		el("text", { x: W - right - 4, y: 12 + 12 * legend++, "text-anchor": "end", fill: c }, svg).textContent =
+			s.name + " [" + +lo.toFixed(3) + " .. " + +hi.toFixed(3) + (s.unit ? " " + s.unit : "") + "]";
+	});
+
+	// Packets
+	tl.flights.forEach(function(f) {
+		var to = f.to || f.from, received = f.received || 0;
+		if (f.sent > win[1] || (!f.lost && received < win[0])) { return; }
+		if (!(f.from in laneY) || !(to in laneY)) { return; }
+		var x1 = x(f.sent), y1 = laneY[f.from], x2, y2;
+		if (f.lost) {
+			x2 = x1 + 20;
+			y2 = (laneY[f.from] + laneY[to]) / 2;
+			if (y2 == y1) { y2 += 20; }
+		} else {
+			x2 = x(received);
+			y2 = laneY[to];
+		}
+		var c = colorOf(known.types, f.type);
+		var g = el("g", {}, svg);
+		el("line", { x1: x1, y1: y1, x2: x2, y2: y2, stroke: c, "class": "flight" + (f.id == selected ? " selected" : "") }, g);
+		if (f.lost) {
+			el("path", { d: "M" + (x2 - 4) + "," + (y2 - 4) + "l8,8m0,-8l-8,8", stroke: "#cc0000", "stroke-width": 2 }, g);
+		}
+		el("title", {}, g).textContent = f.type + " SeqNo=" + f.seqno + " AckNo=" + f.ackno +
+			(f.lost ? " lost" : "") + (f.cause ? " (" + f.cause + ")" : "");
+		g.onclick = function(ev) { ev.stopPropagation(); showPacket(f.id); };
+	});
+}
+
+function showPacket(id) {
+	selected = id;
+	draw();
+	fetch("/packet?id=" + id).then(function(r) { return r.json(); }).then(function(d) {
+		var f = d.flight, h = d.header;
+		var s = "<table><tr><th colspan=2>Packet</th></tr>";
+		[["Type", f.type], ["SeqNo", f.seqno], ["AckNo", f.ackno], ["From", f.from], ["To", f.to || ""],
+			["Sent", f.sent + "ms"], ["Received", f.lost ? "lost" : (f.received || 0) + "ms"], ["Cause", f.cause || ""],
+			["Subsystem", f.subsystem]].forEach(function(r) {
+			s += "<tr><td>" + esc(r[0]) + "</td><td>" + esc(r[1]) + "</td></tr>";
+		});
+		s += "</table>";
+		if (h) {
+			s += "<table><tr><th colspan=2>Header</th></tr>";
+			["SourcePort", "DestPort", "CCVal", "CsCov", "ServiceCode", "ResetCode", "ECN", "DataLen"].forEach(function(k) {
+				if (h[k] !== undefined) { s += "<tr><td>" + k + "</td><td>" + esc(h[k]) + "</td></tr>"; }
+			});
+			s += "</table><table><tr><th>Option</th><th>Type</th><th>Mandatory</th><th>Data</th></tr>";
+			(h.Options || []).forEach(function(o) {
+				var data = o.Data ? Array.from(atob(o.Data), function(c) { return ("0" + c.charCodeAt(0).toString(16)).slice(-2); }).join(" ") : "";
+				s += "<tr><td>" + esc(o.Name) + "</td><td>" + o.Type + "</td><td>" + (o.Mandatory ? "yes" : "") + "</td><td>" + data + "</td></tr>";
+			});
+			s += "</table>";
+		}
+		s += "<table><tr><th>Time</th><th>Labels</th><th>Event</th><th>State</th><th>Comment</th></tr>";
+		d.traces.forEach(function(r) {
+			s += "<tr><td>" + (r.time / 1e6) + "ms</td><td>" + esc(r.labels.join("/")) + "</td><td>" + esc(r.event) +
+				"</td><td>" + esc(r.state || "") + "</td><td>" + esc(r.comment || "") + "</td></tr>";
+		});
+		document.getElementById("detail").innerHTML = s + "</table>";
+	});
+}
+
+// Zoom with the wheel around the pointer, pan by dragging
+var view = document.getElementById("view");
+view.addEventListener("wheel", function(ev) {
+	ev.preventDefault();
+	var W = view.clientWidth || 1200, left = 80, right = 20;
+	var at = win[0] + (ev.offsetX - left) / (W - left - right) * (win[1] - win[0]);
+	var k = ev.deltaY > 0 ? 1.25 : 0.8;
+	win = [at - (at - win[0]) * k, at + (win[1] - at) * k];
+	draw();
+});
+var drag = null;
+view.addEventListener("mousedown", function(ev) { drag = { x: ev.clientX, win: win.slice() }; });
+window.addEventListener("mouseup", function() { drag = null; });
+window.addEventListener("mousemove", function(ev) {
+	if (!drag) { return; }
+	var W = view.clientWidth || 1200;
+	var dt = (ev.clientX - drag.x) / (W - 100) * (drag.win[1] - drag.win[0]);
+	win = [drag.win[0] - dt, drag.win[1] - dt];
+	draw();
+});
+document.getElementById("reset").onclick = function() { win = [tl.start, Math.max(tl.end, tl.start + 1)]; draw(); };
+load();
+</script>
+</body>
+</html>
+`
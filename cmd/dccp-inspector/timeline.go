@@ -0,0 +1,273 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// timeline is what the web UI shows of a trace: the flights of the packets between the
+// endpoints, and the time series of the samples that the endpoints emit, like the rate of
+// CCID3 or the cwnd of CCID2. Times are in milliseconds, for the UI.
+type timeline struct {
+	traces     []*dccp.Trace
+	endpoints  []string
+	start, end float64
+	flights    []*flight
+	series     []*series
+}
+
+// flight is a packet on its way from one endpoint to another
+type flight struct {
+	ID        int     `json:"id"`
+	Type      string  `json:"type"`
+	SeqNo     int64   `json:"seqno"`
+	AckNo     int64   `json:"ackno"`
+	From      string  `json:"from"`
+	To        string  `json:"to,omitempty"`
+	Subsystem string  `json:"subsystem"`
+	Sent      float64 `json:"sent"`
+	Received  float64 `json:"received,omitempty"`
+	Lost      bool    `json:"lost,omitempty"`  // Whether the packet was not read
+	Cause     string  `json:"cause,omitempty"` // Comment of the drop, if the packet was dropped
+	read      bool
+	traces    []int // Indices of the traces of the packet
+}
+
+// series is a time series of samples of one subsystem of an endpoint
+type series struct {
+	Name      string       `json:"name"`
+	Endpoint  string       `json:"endpoint"`
+	Subsystem string       `json:"subsystem"`
+	Unit      string       `json:"unit"`
+	Points    [][2]float64 `json:"points"`
+}
+
+// Labels of the traces of the sandbox that are not endpoints
+const (
+	lineLabel = "line"
+	envLabel  = "env"
+)
+
+// newTimeline builds the timeline of the traces, which it sorts by time
+func newTimeline(traces []*dccp.Trace) *timeline {
+	sort.Stable(TraceTimeSort(traces))
+	t := &timeline{traces: traces}
+	if len(traces) > 0 {
+		t.start, t.end = ms(traces[0].Time), ms(traces[len(traces)-1].Time)
+	}
+	for _, r := range traces {
+		if r.Type != "" && (r.Event == dccp.EventWrite || r.Event == dccp.EventRead) && len(r.Labels) > 0 {
+			t.addEndpoint(r.Labels[0])
+		}
+	}
+
+	// Packets are matched by sender, type and sequence number, which DCCP does not reuse
+	type key struct {
+		from, typ string
+		seqNo     int64
+	}
+	pending := make(map[key]*flight)
+	serieses := make(map[string]*series)
+	for i, r := range traces {
+		if len(r.Labels) == 0 {
+			continue
+		}
+		endpoint, subsystem := r.Labels[0], strings.Join(r.Labels[1:], "/")
+		if s, ok := r.Sample(); ok {
+			name := endpoint + "/" + subsystem + " " + s.Series
+			ss, ok := serieses[name]
+			if !ok {
+				ss = &series{Name: name, Endpoint: endpoint, Subsystem: subsystem, Unit: s.Unit}
+				serieses[name] = ss
+				t.series = append(t.series, ss)
+			}
+			ss.Points = append(ss.Points, [2]float64{ms(r.Time), s.Value})
+			continue
+		}
+		if r.Type == "" {
+			continue
+		}
+		switch {
+		case endpoint == lineLabel && len(r.Labels) > 1:
+			// The line of the sandbox is labeled by the endpoint that writes to it
+			f := pending[key{r.Labels[1], r.Type, r.SeqNo}]
+			if f == nil {
+				continue
+			}
+			f.traces = append(f.traces, i)
+			if r.Event == dccp.EventDrop {
+				f.Lost, f.Cause = true, r.Comment
+			}
+		case !t.isEndpoint(endpoint):
+		case r.Event == dccp.EventWrite:
+			f := pending[key{endpoint, r.Type, r.SeqNo}]
+			if f == nil {
+				f = &flight{
+					ID:        len(t.flights),
+					Type:      r.Type,
+					SeqNo:     r.SeqNo,
+					AckNo:     r.AckNo,
+					From:      endpoint,
+					Subsystem: subsystem,
+					Sent:      ms(r.Time),
+				}
+				if len(t.endpoints) == 2 {
+					f.To = t.peer(endpoint)
+				}
+				t.flights = append(t.flights, f)
+				pending[key{endpoint, r.Type, r.SeqNo}] = f
+			}
+			f.traces = append(f.traces, i)
+		case r.Event == dccp.EventRead:
+			for _, from := range t.endpoints {
+				if from == endpoint {
+					continue
+				}
+				if f := pending[key{from, r.Type, r.SeqNo}]; f != nil {
+					if !f.read {
+						f.To, f.Received, f.read = endpoint, ms(r.Time), true
+					}
+					f.traces = append(f.traces, i)
+					break
+				}
+			}
+		case r.Event == dccp.EventDrop:
+			// Drops at the sender come before the packet leaves it, drops at the receiver
+			// after the packet is read
+			for _, from := range t.endpoints {
+				if f := pending[key{from, r.Type, r.SeqNo}]; f != nil {
+					f.Cause = r.Comment
+					f.Lost = !f.read
+					f.traces = append(f.traces, i)
+					break
+				}
+			}
+		}
+	}
+	for _, f := range t.flights {
+		f.Lost = f.Lost || !f.read
+	}
+	return t
+}
+
+func ms(ns int64) float64 { return float64(ns) / 1e6 }
+
+// addEndpoint adds endpoint to the endpoints of the timeline, unless it is there already or is
+// not an endpoint. The client comes first, so that the UI draws it on top.
+func (t *timeline) addEndpoint(endpoint string) {
+	if endpoint == lineLabel || endpoint == envLabel || t.isEndpoint(endpoint) {
+		return
+	}
+	t.endpoints = append(t.endpoints, endpoint)
+	if endpoint == "client" {
+		copy(t.endpoints[1:], t.endpoints)
+		t.endpoints[0] = endpoint
+	}
+}
+
+func (t *timeline) isEndpoint(endpoint string) bool {
+	for _, e := range t.endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// peer returns the other of the two endpoints
+func (t *timeline) peer(endpoint string) string {
+	if t.endpoints[0] == endpoint {
+		return t.endpoints[1]
+	}
+	return t.endpoints[0]
+}
+
+// timelineView is the part of a timeline that passes the filters of the UI
+type timelineView struct {
+	Endpoints  []string  `json:"endpoints"`
+	Start      float64   `json:"start"`
+	End        float64   `json:"end"`
+	Types      []string  `json:"types"`      // All packet types of the timeline
+	Subsystems []string  `json:"subsystems"` // All subsystems of the timeline
+	Flights    []*flight `json:"flights"`
+	Series     []*series `json:"series"`
+}
+
+// view returns the flights of the given packet types, and the flights and series of the given
+// subsystems. A nil list of either passes all.
+func (t *timeline) view(types, subsystems []string) *timelineView {
+	v := &timelineView{
+		Endpoints: t.endpoints,
+		Start:     t.start,
+		End:       t.end,
+		Flights:   make([]*flight, 0),
+		Series:    make([]*series, 0),
+	}
+	allTypes, allSubsystems := make(map[string]bool), make(map[string]bool)
+	for _, f := range t.flights {
+		allTypes[f.Type], allSubsystems[f.Subsystem] = true, true
+		if passes(types, f.Type) && passes(subsystems, f.Subsystem) {
+			v.Flights = append(v.Flights, f)
+		}
+	}
+	for _, s := range t.series {
+		allSubsystems[s.Subsystem] = true
+		if passes(subsystems, s.Subsystem) {
+			v.Series = append(v.Series, s)
+		}
+	}
+	v.Types, v.Subsystems = sortedKeys(allTypes), sortedKeys(allSubsystems)
+	return v
+}
+
+func passes(filter []string, s string) bool {
+	if filter == nil {
+		return true
+	}
+	for _, f := range filter {
+		if f == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// flightDetail is what the UI shows of a packet that is clicked: its decoded header and
+// options, and the traces of its way from one endpoint to the other
+type flightDetail struct {
+	Flight *flight           `json:"flight"`
+	Header *dccp.HeaderInfo  `json:"header,omitempty"`
+	Traces []*dccp.JSONTrace `json:"traces"`
+}
+
+// detail returns the detail of the flight with the given id, or nil if there is none
+func (t *timeline) detail(id int) *flightDetail {
+	if id < 0 || id >= len(t.flights) {
+		return nil
+	}
+	f := t.flights[id]
+	d := &flightDetail{Flight: f, Traces: make([]*dccp.JSONTrace, 0, len(f.traces))}
+	for _, i := range f.traces {
+		r := t.traces[i]
+		if d.Header == nil {
+			d.Header, _ = r.HeaderInfo()
+		}
+		d.Traces = append(d.Traces, dccp.NewJSONTrace(r))
+	}
+	return d
+}
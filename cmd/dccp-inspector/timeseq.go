@@ -0,0 +1,49 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	dccp_gauge "github.com/petar/GoDCCP/dccp/gauge"
+)
+
+// printTimeSeq prints the time-sequence graph of the flow of packets that endpoint sends, in
+// the format of xplot for report "xplot", or its data or rates as columns for gnuplot and the
+// like for reports "seq" and "rate", with rates tallied over bins of length bin
+func printTimeSeq(emits []*dccp.Trace, report, endpoint string, bin time.Duration) {
+	sort.Sort(TraceTimeSort(emits))
+	ts := dccp_gauge.NewTimeSeq(bin)
+	for _, rec := range emits {
+		ts.Write(rec)
+	}
+	var flow *dccp_gauge.Flow
+	for _, f := range ts.Flows() {
+		if f.Endpoint == endpoint {
+			flow = f
+		}
+	}
+	if flow == nil {
+		fmt.Fprintf(os.Stderr, "No packets sent by %q\n", endpoint)
+		os.Exit(1)
+	}
+	var err error
+	switch report {
+	case "xplot":
+		err = flow.WriteXplot(os.Stdout)
+	case "seq":
+		err = flow.WriteSeqData(os.Stdout)
+	case "rate":
+		err = flow.WriteRates(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report (%s)\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+// dccp-sim runs the simulations described in JSON files, as in sandbox.Simulation, and prints
+// a summary of each. The traces of a simulation go to an emit file named after it, which
+// dccp-inspector reads. It exits with status 1 if a simulation does not meet its assertions.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/petar/GoDCCP/dccp/sandbox"
+)
+
+var (
+	flagLog  *string = flag.String("log", "", "Directory of the emit files")
+	flagSeed *int64  = flag.Int64("seed", 0, "Seed of the random choices, in place of that of the simulation")
+)
+
+func usage() {
+	fmt.Printf("%s [optional_flags] simulation_file ...\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+	if *flagLog != "" {
+		os.Setenv("DCCPLOG", *flagLog)
+	}
+
+	failed := false
+	for _, name := range flag.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening simulation (%s)\n", err)
+			os.Exit(1)
+		}
+		s, err := sandbox.ReadSimulation(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading simulation %s (%s)\n", name, err)
+			os.Exit(1)
+		}
+		if *flagSeed != 0 {
+			s.Seed = *flagSeed
+		}
+		r := s.Run()
+		r.Print(os.Stdout)
+		failed = failed || len(r.Failures) > 0
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
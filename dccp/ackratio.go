@@ -0,0 +1,55 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "fmt"
+
+// The Ack Ratio feature is located at the HC-Sender and tells the HC-Receiver how many data
+// packets it may receive before it must send an acknowledgement, Section 11.3. Hence the local
+// Ack Ratio applies to acknowledgements of our data, and the remote Ack Ratio governs the
+// acknowledgements we send.
+
+// syncWithAckRatio negotiates a new local Ack Ratio if the sender CCID asks for one
+func (c *Conn) syncWithAckRatio() {
+	ars, ok := c.scc.(AckRatioSender)
+	if !ok {
+		return
+	}
+	r := ars.GetAckRatio()
+	if r == 0 || r == c.ackRatio {
+		return
+	}
+	if err := c.features.ChangeLocal(FeatureAckRatio, uint64(r)); err != nil {
+		c.amb.E(EventWarn, fmt.Sprintf("Invalid Ack Ratio %d", r))
+		return
+	}
+	c.ackRatio = r
+	c.amb.E(EventInfo, fmt.Sprintf("Requesting Ack Ratio %d", r))
+}
+
+// countAckRatio accounts for the received packet h, and sends an Ack if the number of data
+// packets received since the last acknowledgement has reached the remote Ack Ratio. Only
+// receiver CCIDs that implement AckRatioReceiver are governed by the Ack Ratio.
+func (c *Conn) countAckRatio(h *Header) {
+	if h.Type != Data && h.Type != DataAck {
+		return
+	}
+	if arr, ok := c.rcc.(AckRatioReceiver); !ok || !arr.UsesAckRatio() {
+		return
+	}
+	c.ackRatioCount++
+	if uint64(c.ackRatioCount) < c.features.Remote(FeatureAckRatio) {
+		return
+	}
+	c.inject(c.generateAck())
+	c.ackRatioCount = 0
+}
+
+// WriteAckRatio restarts the count of unacknowledged data packets, if h carries an acknowledgement
+func (c *Conn) WriteAckRatio(h *Header) {
+	if h.Type == Ack || h.Type == DataAck {
+		c.ackRatioCount = 0
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+// Ack Vector packet states, Section 11.4
+const (
+	AckVectorReceived    = 0 // Received
+	AckVectorECNMarked   = 1 // Received ECN Marked
+	AckVectorNotReceived = 3 // Not Yet Received
+)
+
+// AckVectorRun is a run-length encoded entry of an Ack Vector. It describes Len consecutive
+// packets, all in the same State.
+type AckVectorRun struct {
+	State byte // One of AckVectorReceived, AckVectorECNMarked or AckVectorNotReceived
+	Len   int  // Number of packets in the run, between 1 and 64
+}
+
+// AckVectorOption, Section 11.4
+// The runs describe consecutive packets in decreasing order of sequence number, starting from
+// the Acknowledgement Number of the packet carrying the option.
+type AckVectorOption struct {
+	Nonce bool // ECN Nonce Echo; selects between option types 38 and 39
+	Runs  []AckVectorRun
+}
+
+// AckVectorMaxRuns is the maximum number of runs that fit in a single Ack Vector option
+const AckVectorMaxRuns = 253
+
+func (opt *AckVectorOption) Encode() (*Option, error) {
+	if len(opt.Runs) > AckVectorMaxRuns {
+		return nil, ErrOverflow
+	}
+	d := make([]byte, len(opt.Runs))
+	for i, r := range opt.Runs {
+		if r.Len < 1 || r.Len > 64 || r.State > 3 {
+			return nil, ErrOption
+		}
+		d[i] = (r.State << 6) | byte(r.Len-1)
+	}
+	t := byte(OptionAckVectorNonce0)
+	if opt.Nonce {
+		t = OptionAckVectorNonce1
+	}
+	return &Option{
+		Type:      t,
+		Data:      d,
+		Mandatory: false,
+	}, nil
+}
+
+func DecodeAckVectorOption(opt *Option) *AckVectorOption {
+	if opt.Type != OptionAckVectorNonce0 && opt.Type != OptionAckVectorNonce1 {
+		return nil
+	}
+	runs := make([]AckVectorRun, len(opt.Data))
+	for i, b := range opt.Data {
+		runs[i] = AckVectorRun{State: b >> 6, Len: int(b&0x3f) + 1}
+	}
+	return &AckVectorOption{Nonce: opt.Type == OptionAckVectorNonce1, Runs: runs}
+}
+
+// State returns the state of packet seqNo as reported by an Ack Vector carried on a packet with
+// Acknowledgement Number ackNo. It returns false if seqNo is not covered by the vector.
+func (opt *AckVectorOption) State(ackNo, seqNo int64) (state byte, ok bool) {
+	k := ackNo - seqNo
+	if k < 0 {
+		return 0, false
+	}
+	for _, r := range opt.Runs {
+		if k < int64(r.Len) {
+			return r.State, true
+		}
+		k -= int64(r.Len)
+	}
+	return 0, false
+}
+
+// ackVectorHistory is the maximum number of packets whose state is remembered by ackVector
+const ackVectorHistory = 1024
+
+// ackVector records the receive state of recent packets at the HC-Receiver, so that it can
+// report them in Ack Vector options. It is only kept while the Send Ack Vector feature is on.
+type ackVector struct {
+	base   int64  // Sequence number of the packet described by states[0]
+	states []byte // states[i] is the state of packet base+i
+}
+
+// Record marks packet seqNo as received, carried by an IP packet with ECN codepoint ecn
+func (t *ackVector) Record(seqNo int64, ecn byte) {
+	state := byte(AckVectorReceived)
+	if ecn == ECNCE {
+		state = AckVectorECNMarked
+	}
+	if len(t.states) == 0 {
+		t.base = seqNo
+		t.states = append(t.states, state)
+		return
+	}
+	k := seqNo - t.base
+	if k < 0 {
+		return
+	}
+	// Forget the packets that fall out of the history before making room for seqNo, so that a
+	// sequence number far ahead does not grow the history beyond ackVectorHistory
+	if n := k - (ackVectorHistory - 1); n > 0 {
+		if n < int64(len(t.states)) {
+			t.states = t.states[n:]
+		} else {
+			t.states = t.states[:0]
+		}
+		t.base += n
+		k -= n
+	}
+	for int64(len(t.states)) <= k {
+		t.states = append(t.states, AckVectorNotReceived)
+	}
+	if t.states[k] == AckVectorNotReceived {
+		t.states[k] = state
+	}
+}
+
+// Option returns an Ack Vector option, describing the packets up to and including ackNo, or
+// nil if no packets have been recorded
+func (t *ackVector) Option(ackNo int64) *Option {
+	if len(t.states) == 0 || ackNo < t.base {
+		return nil
+	}
+	var runs []AckVectorRun
+	for q := ackNo; q >= t.base && len(runs) < AckVectorMaxRuns; q-- {
+		state := byte(AckVectorNotReceived)
+		if k := q - t.base; k < int64(len(t.states)) {
+			state = t.states[k]
+		}
+		if n := len(runs); n > 0 && runs[n-1].State == state && runs[n-1].Len < 64 {
+			runs[n-1].Len++
+		} else {
+			runs = append(runs, AckVectorRun{State: state, Len: 1})
+		}
+	}
+	opt, err := (&AckVectorOption{Runs: runs}).Encode()
+	if err != nil {
+		panic("problem encoding ack vector")
+	}
+	return opt
+}
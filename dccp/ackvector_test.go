@@ -0,0 +1,62 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "testing"
+
+func TestAckVector(t *testing.T) {
+	var av ackVector
+	for _, q := range []int64{100, 101, 102, 105, 103} {
+		av.Record(q, ECNNotECT)
+	}
+	av.Record(106, ECNCE)
+	opt := DecodeAckVectorOption(av.Option(106))
+	if opt == nil {
+		t.Fatalf("decoding ack vector")
+	}
+	expect := map[int64]byte{
+		106: AckVectorECNMarked,
+		105: AckVectorReceived,
+		104: AckVectorNotReceived,
+		103: AckVectorReceived,
+		100: AckVectorReceived,
+	}
+	for q, s := range expect {
+		state, ok := opt.State(106, q)
+		if !ok || state != s {
+			t.Errorf("packet %d: expecting state %d, encountered %d (%v)", q, s, state, ok)
+		}
+	}
+	if _, ok := opt.State(106, 99); ok {
+		t.Errorf("ack vector covers unrecorded packet")
+	}
+}
+
+// TestAckVectorGap checks that a sequence number far ahead of the packets recorded so far does
+// not grow the history beyond ackVectorHistory
+func TestAckVectorGap(t *testing.T) {
+	var av ackVector
+	av.Record(100, ECNNotECT)
+	av.Record(101, ECNNotECT)
+	av.Record(100+1<<40, ECNNotECT)
+	if len(av.states) != ackVectorHistory || cap(av.states) > 2*ackVectorHistory {
+		t.Fatalf("history of %d (capacity %d), expecting %d", len(av.states), cap(av.states), ackVectorHistory)
+	}
+	opt := DecodeAckVectorOption(av.Option(100 + 1<<40))
+	if state, ok := opt.State(100+1<<40, 100+1<<40); !ok || state != AckVectorReceived {
+		t.Errorf("expecting packet received, encountered state %d (%v)", state, ok)
+	}
+	if state, ok := opt.State(100+1<<40, 99+1<<40); !ok || state != AckVectorNotReceived {
+		t.Errorf("expecting packet not received, encountered state %d (%v)", state, ok)
+	}
+	// The history shifts forward when a packet is only just beyond it
+	av.Record(101+1<<40+ackVectorHistory/2, ECNNotECT)
+	if len(av.states) != ackVectorHistory {
+		t.Errorf("history of %d, expecting %d", len(av.states), ackVectorHistory)
+	}
+	if k := 100 + 1<<40 - av.base; av.states[k] != AckVectorReceived {
+		t.Errorf("shifted history lost packet")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+// AllowedRate returns the rate, in bytes per second, at which the sender CCID currently lets
+// the connection send, or zero if the CCID does not report its congestion state, see
+// StatsSender, or the connection is not open. A rate-based CCID, like CCID3, reports the rate
+// itself. The rate of a window-based CCID, like CCID2, is a congestion window of
+// full-sized packets per round-trip time. Adaptive encoders can pick a bitrate that fits.
+func (c *Conn) AllowedRate() int64 {
+	ss, ok := c.scc.(StatsSender)
+	if !ok {
+		return 0
+	}
+	s := ss.GetStats()
+	var rate int64
+	c.do(func() { rate = c.allowedRate(s) })
+	return rate
+}
+
+// allowedRate returns the rate that the sender statistics s allow, see AllowedRate
+func (c *Conn) allowedRate(s SenderStats) int64 {
+	if state := c.socket.GetState(); state != OPEN && state != PARTOPEN {
+		return 0
+	}
+	if s.Rate > 0 {
+		return s.Rate
+	}
+	if s.Cwnd > 0 {
+		rtt := max64(c.socket.GetRTT(), RoundtripMin)
+		return s.Cwnd * int64(c.socket.GetMPS()) * 1e9 / rtt
+	}
+	return 0
+}
+
+// SetRateHandler sets a function that is called with the new value of AllowedRate whenever it
+// differs by more than the fraction change from the value last reported. The calls are made
+// in order, one at a time, in a goroutine other than those of the connection, so the rate
+// that the handler last receives is the current one. The connection checks about once per
+// round-trip time. A nil f removes the handler.
+func (c *Conn) SetRateHandler(f func(rate int64), change float64) error {
+	if change < 0 {
+		return ErrInvalid
+	}
+	c.do(func() {
+		c.rateHandler = f
+		c.rateChange = change
+		c.rateReported = 0
+	})
+	return nil
+}
+
+// pollRate reports a significant change of AllowedRate to the handler set by SetRateHandler.
+// The idle polls call it, on loop, about once per round-trip time.
+func (c *Conn) pollRate() {
+	ss, ok := c.scc.(StatsSender)
+	if !ok {
+		return
+	}
+	if c.rateHandler == nil {
+		return
+	}
+	rate := c.allowedRate(ss.GetStats())
+	d := rate - c.rateReported
+	if d < 0 {
+		d = -d
+	}
+	if rate == c.rateReported || float64(d) <= c.rateChange*float64(c.rateReported) {
+		return
+	}
+	c.rateReported = rate
+	c.rateLk.Lock()
+	defer c.rateLk.Unlock()
+	c.rates = append(c.rates, rateCall{c.rateHandler, rate})
+	if !c.ratesRunning {
+		c.ratesRunning = true
+		c.env.Go(c.deliverRates, "Conn·deliverRates")
+	}
+}
+
+// rateCall is a rate queued for delivery, along with the function it is for
+type rateCall struct {
+	f    func(int64)
+	rate int64
+}
+
+// deliverRates makes the calls that pollRate queues, until there are none left
+func (c *Conn) deliverRates() {
+	for {
+		c.rateLk.Lock()
+		if len(c.rates) == 0 {
+			c.ratesRunning = false
+			c.rateLk.Unlock()
+			return
+		}
+		call := c.rates[0]
+		c.rates = c.rates[1:]
+		c.rateLk.Unlock()
+		call.f(call.rate)
+	}
+}
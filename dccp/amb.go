@@ -100,7 +100,7 @@ func (t *Amb) E(event Event, comment string, args ...interface{}) {
 }
 
 func (t *Amb) EC(skip int, event Event, comment string, args ...interface{}) {
-	if t.env == nil {
+	if t.env == nil || !t.env.traceAllowed(t.labels) {
 		return
 	}
 	sinceZero, _ := t.env.Snap()
@@ -108,6 +108,7 @@ func (t *Amb) EC(skip int, event Event, comment string, args ...interface{}) {
 	// Extract header information
 	var hType string = ""
 	var hSeqNo, hAckNo int64
+	var packet *Header
 	logargs := make(map[string]interface{})
 	for _, a := range args {
 		switch t := a.(type) {
@@ -115,11 +116,13 @@ func (t *Amb) EC(skip int, event Event, comment string, args ...interface{}) {
 			if t != nil {
 				hSeqNo, hAckNo = t.SeqNo, t.AckNo
 				hType = typeString(t.Type)
+				packet = t
 			}
 		case *writeHeader:
 			if t != nil {
 				hSeqNo, hAckNo = t.SeqNo, t.AckNo
 				hType = typeString(t.Type)
+				packet = &t.Header
 			}
 		case *PreHeader:
 			if t != nil {
@@ -147,8 +150,12 @@ func (t *Amb) EC(skip int, event Event, comment string, args ...interface{}) {
 	sfile, sline := FetchCaller(1+skip)
 
 	if t.env.TraceWriter() != nil {
+		// The packets that are read, written or dropped carry their options, for the inspector
+		if packet != nil && (event == EventRead || event == EventWrite || event == EventDrop) {
+			logargs[HeaderInfoType] = NewHeaderInfo(packet)
+		}
 		r := &Trace{
-			Time:       sinceZero,
+			Time:       int64(sinceZero),
 			Labels:     t.labels,
 			Event:      event,
 			State:      t.GetState(),
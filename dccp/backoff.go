@@ -8,7 +8,6 @@ import "io"
 
 // backOff{}
 type backOff struct {
-	env         *Env
 	sleep       int64 // Duration of next sleep interval
 	lifetime    int64 // Total lifetime so far
 	timeout     int64 // Maximum time the backoff mechanism stays alive
@@ -20,9 +19,8 @@ type backOff struct {
 // nanoseconds. Approximately every backoffFreq nanoseconds, the sleep timers backs off
 // (increases by a factor of 4/3).  The lifetime of the backoff sleep intervals does not
 // exceed timeout.
-func newBackOff(env *Env, firstSleep, timeout, backoffFreq int64) *backOff {
+func newBackOff(firstSleep, timeout, backoffFreq int64) *backOff {
 	return &backOff{
-		env:         env,
 		sleep:       firstSleep,
 		lifetime:    0,
 		timeout:     timeout,
@@ -34,19 +32,17 @@ func newBackOff(env *Env, firstSleep, timeout, backoffFreq int64) *backOff {
 // BackoffMin is the minimum time before two firings of the backoff timers
 const BackoffMin = 100e6
 
-// Sleep() blocks for the duration of the next sleep interval in the back-off 
-// sequence and return nil. If the maximum total sleep time has been reached,
-// Sleep() returns os.EOF without sleeping.
-func (b *backOff) Sleep() (error, int64) {
+// Next() returns the duration of the next sleep interval in the back-off sequence, and
+// moves past it. If the maximum total sleep time has been reached, Next() returns io.EOF.
+func (b *backOff) Next() (int64, error) {
 	if b.lifetime >= b.timeout {
-		return io.EOF, 0
+		return 0, io.EOF
 	}
 	effectiveSleep := max64(BackoffMin, b.sleep)
-	b.env.Sleep(effectiveSleep)
 	b.lifetime += effectiveSleep
 	if b.lifetime-b.lastBackoff >= b.backoffFreq {
 		b.sleep = (4 * b.sleep) / 3
 		b.lastBackoff = b.lifetime
 	}
-	return nil, b.env.Now()
+	return effectiveSleep, nil
 }
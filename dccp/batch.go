@@ -0,0 +1,166 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// At high packet rates, the cost of a system call per datagram dominates. On Linux, UDP sockets
+// read and write batches of datagrams per system call, with recvmmsg and sendmmsg. Elsewhere,
+// the same calls fall back to one datagram at a time.
+
+// batchLen is the most datagrams that are read or written in one system call
+const batchLen = 32
+
+// BatchLink is implemented by Links that receive and send several packets in one call, like
+// UDPLink. A Mux reads from a BatchLink in batches, and sends the packets that its connections
+// write at the same time together.
+type BatchLink interface {
+	Link
+
+	// ReadBatch receives at least one and at most len(msgs) packets into the buffers of msgs,
+	// filling in their lengths, source addresses and, if the link is an ECNCarrier, ECN
+	// codepoints, and returns the number of packets
+	ReadBatch(msgs []LinkMsg) (n int, err error)
+
+	// WriteBatch sends the packets of msgs in order, and returns the number of packets sent. If
+	// err is not nil, it is the error of the packet msgs[n]. Like WriteTo, WriteBatch must not
+	// retain the buffers once it returns.
+	WriteBatch(msgs []LinkMsg) (n int, err error)
+}
+
+// LinkMsg is a packet that a BatchLink receives or sends
+type LinkMsg struct {
+	Buf  []byte   // Buffer of the packet
+	N    int      // Length of a received packet
+	Addr net.Addr // Source of a received packet, or destination of a sent one
+	DSCP byte     // DSCP of a sent packet, see DSCPCarrier
+	ECN  byte     // ECN codepoint of the packet, if the link is an ECNCarrier
+}
+
+// readOne receives one datagram on c into msgs[0], for sockets that cannot receive batches
+func readOne(c *net.UDPConn, msgs []LinkMsg) (int, error) {
+	n, addr, err := c.ReadFromUDP(msgs[0].Buf)
+	if err != nil {
+		return 0, err
+	}
+	msgs[0].N, msgs[0].Addr = n, addr
+	return 1, nil
+}
+
+// writeEach sends the datagrams of msgs on c one at a time, for sockets that cannot send batches
+func writeEach(c *net.UDPConn, msgs []LinkMsg) (int, error) {
+	for i, m := range msgs {
+		if _, err := c.WriteTo(m.Buf, m.Addr); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// batchWriter sends the packets that several goroutines write at the same time with as few
+// calls of send as possible. A writer that finds no send in progress leads: it sends its packet
+// along with those queued meanwhile, and then passes the lead on to the next writer in the
+// queue, if there is one. The other writers wait until their packets are sent.
+type batchWriter struct {
+	send func(msgs []LinkMsg) (int, error)
+
+	Mutex
+	busy  bool          // True while a writer leads
+	queue []*batchWrite // Packets waiting for the next send
+	spare []*batchWrite // Queue of the previous send, for reuse
+	msgs  []LinkMsg     // Packets of the current send, used by the leader only
+}
+
+// batchWrite is a packet waiting in the queue of a batchWriter
+type batchWrite struct {
+	msg  LinkMsg
+	err  error
+	done chan bool // Receives true when the writer is to lead, and false once its packet is sent
+}
+
+var batchWritePool = sync.Pool{
+	New: func() interface{} { return &batchWrite{done: make(chan bool, 1)} },
+}
+
+func newBatchWriter(send func(msgs []LinkMsg) (int, error)) *batchWriter {
+	return &batchWriter{send: send}
+}
+
+// write sends the packet buf to addr, marked with dscp and the ECN codepoint ecn, and returns
+// its error. Like Link.WriteTo, it does not retain buf once it returns.
+func (w *batchWriter) write(buf []byte, addr net.Addr, dscp, ecn byte) error {
+	bw := batchWritePool.Get().(*batchWrite)
+	bw.msg = LinkMsg{Buf: buf, Addr: addr, DSCP: dscp, ECN: ecn}
+	w.Lock()
+	w.queue = append(w.queue, bw)
+	lead := !w.busy
+	w.busy = true
+	w.Unlock()
+	if lead || <-bw.done {
+		w.flush(bw)
+	}
+	err := bw.err
+	bw.msg, bw.err = LinkMsg{}, nil
+	batchWritePool.Put(bw)
+	return err
+}
+
+// flush sends the queued packets, among them that of the leader self, and passes the lead on
+func (w *batchWriter) flush(self *batchWrite) {
+	w.Lock()
+	batch := w.queue
+	w.queue = w.spare
+	w.spare = nil
+	w.Unlock()
+
+	w.msgs = w.msgs[:0]
+	for _, bw := range batch {
+		w.msgs = append(w.msgs, bw.msg)
+	}
+	for i := 0; i < len(w.msgs); {
+		end := i + batchLen
+		if end > len(w.msgs) {
+			end = len(w.msgs)
+		}
+		n, err := w.send(w.msgs[i:end])
+		if err == nil && n == 0 {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			// The failed packet is dropped, as it would be by the network
+			batch[i+n].err = err
+			n++
+		}
+		i += n
+	}
+	for i := range w.msgs {
+		w.msgs[i] = LinkMsg{}
+	}
+
+	for _, bw := range batch {
+		if bw != self {
+			bw.done <- false
+		}
+	}
+	for i := range batch {
+		batch[i] = nil
+	}
+	w.Lock()
+	w.spare = batch[:0]
+	var next *batchWrite
+	if len(w.queue) > 0 {
+		next = w.queue[0]
+	} else {
+		w.busy = false
+	}
+	w.Unlock()
+	if next != nil {
+		next.done <- true
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+// System call numbers of recvmmsg and sendmmsg, the latter of which package syscall lacks
+const (
+	sysRECVMMSG = 299
+	sysSENDMMSG = 307
+)
@@ -0,0 +1,11 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+// System call numbers of recvmmsg and sendmmsg
+const (
+	sysRECVMMSG = 243
+	sysSENDMMSG = 269
+)
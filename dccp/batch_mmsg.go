@@ -0,0 +1,243 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build linux && (amd64 || arm64)
+
+package dccp
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// mmsghdr is the struct mmsghdr of recvmmsg(2) and sendmmsg(2)
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// mmsgScratch holds the arguments of a recvmmsg or sendmmsg call
+type mmsgScratch struct {
+	hdrs  [batchLen]mmsghdr
+	iovs  [batchLen]syscall.Iovec
+	names [batchLen]syscall.RawSockaddrInet6 // Large enough for IPv4 addresses as well
+	oobs  [batchLen][6]uint64                // Control messages, aligned for syscall.Cmsghdr
+}
+
+// udpBatch reads and writes batches of datagrams on a UDP socket with recvmmsg and sendmmsg
+type udpBatch struct {
+	c     *net.UDPConn
+	rc    syscall.RawConn // Raw socket, or nil if the batches fall back to single datagrams
+	inet6 bool            // True if the socket is of family AF_INET6
+	ecn   bool            // True if the socket receives the TOS byte of datagrams, see enableRecvTOS
+
+	rlk sync.Mutex
+	r   mmsgScratch
+	wlk sync.Mutex
+	w   mmsgScratch
+}
+
+func newUDPBatch(c *net.UDPConn) *udpBatch {
+	b := &udpBatch{c: c}
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return b
+	}
+	var sa syscall.Sockaddr
+	rc.Control(func(fd uintptr) {
+		sa, err = syscall.Getsockname(int(fd))
+	})
+	if err != nil {
+		return b
+	}
+	_, b.inet6 = sa.(*syscall.SockaddrInet6)
+	b.rc = rc
+	b.ecn = enableRecvTOS(c) == nil
+	return b
+}
+
+// read receives at least one and at most len(msgs) datagrams, see BatchLink.ReadBatch
+func (b *udpBatch) read(msgs []LinkMsg) (int, error) {
+	if b.rc == nil {
+		return readOne(b.c, msgs)
+	}
+	b.rlk.Lock()
+	defer b.rlk.Unlock()
+	if len(msgs) > batchLen {
+		msgs = msgs[:batchLen]
+	}
+	s := &b.r
+	for i := range msgs {
+		s.setMsg(i, msgs[i].Buf, syscall.SizeofSockaddrInet6)
+		if b.ecn {
+			s.hdrs[i].hdr.Control = &s.oob(i)[0]
+			s.hdrs[i].hdr.SetControllen(len(s.oob(i)))
+		}
+	}
+	n, err := s.call(b.rc, "recvmmsg", sysRECVMMSG, len(msgs))
+	if err != nil {
+		return 0, &net.OpError{Op: "read", Net: "udp", Source: b.c.LocalAddr(), Err: err}
+	}
+	for i := 0; i < n; i++ {
+		msgs[i].N = int(s.hdrs[i].len)
+		msgs[i].Addr = sockaddrToUDP(&s.names[i])
+		msgs[i].ECN = 0
+		if b.ecn {
+			if tos, ok := parseTOS(s.oob(i)[:s.hdrs[i].hdr.Controllen]); ok {
+				msgs[i].ECN = tos & 3
+			}
+		}
+	}
+	return n, nil
+}
+
+// write sends the datagrams of msgs in order, see BatchLink.WriteBatch
+func (b *udpBatch) write(msgs []LinkMsg) (int, error) {
+	if b.rc == nil {
+		return writeEach(b.c, msgs)
+	}
+	b.wlk.Lock()
+	defer b.wlk.Unlock()
+	if len(msgs) > batchLen {
+		msgs = msgs[:batchLen]
+	}
+	s := &b.w
+	for i, m := range msgs {
+		namelen, ok := b.putSockaddr(&s.names[i], m.Addr)
+		if !ok {
+			// The datagrams before the one of the wrong address are sent on their own
+			if i == 0 {
+				return 0, &net.AddrError{Err: "unsupported address", Addr: m.Addr.String()}
+			}
+			msgs = msgs[:i]
+			break
+		}
+		s.setMsg(i, m.Buf, namelen)
+		if tos := m.DSCP<<2 | m.ECN&3; tos != 0 {
+			s.setTOS(i, !b.inet6 || m.Addr.(*net.UDPAddr).IP.To4() != nil, tos)
+		}
+	}
+	n, err := s.call(b.rc, "sendmmsg", sysSENDMMSG, len(msgs))
+	if err != nil {
+		return 0, &net.OpError{Op: "write", Net: "udp", Source: b.c.LocalAddr(), Addr: msgs[0].Addr, Err: err}
+	}
+	return n, nil
+}
+
+// setMsg points the message i at the datagram buffer buf and an address of namelen bytes
+func (s *mmsgScratch) setMsg(i int, buf []byte, namelen int) {
+	s.iovs[i] = syscall.Iovec{}
+	if len(buf) > 0 {
+		s.iovs[i].Base = &buf[0]
+	}
+	s.iovs[i].SetLen(len(buf))
+	s.hdrs[i] = mmsghdr{}
+	s.hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&s.names[i]))
+	s.hdrs[i].hdr.Namelen = uint32(namelen)
+	s.hdrs[i].hdr.Iov = &s.iovs[i]
+	s.hdrs[i].hdr.Iovlen = 1
+}
+
+// setTOS adds a control message to the message i that sets the TOS byte of the IPv4 datagram,
+// or the traffic class of the IPv6 one, to tos
+func (s *mmsgScratch) setTOS(i int, ip4 bool, tos byte) {
+	oob := s.oob(i)
+	s.hdrs[i].hdr.Control = &oob[0]
+	s.hdrs[i].hdr.SetControllen(putTOS(oob, ip4, tos))
+}
+
+// oob returns the control message buffer of the message i
+func (s *mmsgScratch) oob(i int) []byte {
+	return (*[unsafe.Sizeof(s.oobs[0])]byte)(unsafe.Pointer(&s.oobs[i]))[:]
+}
+
+// carriesDSCP returns true if write marks the datagrams with LinkMsg.DSCP
+func (b *udpBatch) carriesDSCP() bool { return b.rc != nil }
+
+// carriesECN returns true if read fills in LinkMsg.ECN, and write sets the ECN codepoints of
+// the datagrams to it
+func (b *udpBatch) carriesECN() bool { return b.rc != nil && b.ecn }
+
+// call makes the system call trap on the first n messages of s, waiting until the socket is
+// ready for it, and returns the number of messages processed
+func (s *mmsgScratch) call(rc syscall.RawConn, name string, trap uintptr, n int) (int, error) {
+	var r uintptr
+	var errno syscall.Errno
+	f := func(fd uintptr) bool {
+		for {
+			r, _, errno = syscall.Syscall6(trap, fd, uintptr(unsafe.Pointer(&s.hdrs[0])), uintptr(n),
+				syscall.MSG_DONTWAIT, 0, 0)
+			if errno != syscall.EINTR {
+				return errno != syscall.EAGAIN
+			}
+		}
+	}
+	var err error
+	if trap == sysRECVMMSG {
+		err = rc.Read(f)
+	} else {
+		err = rc.Write(f)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, os.NewSyscallError(name, errno)
+	}
+	return int(r), nil
+}
+
+// putSockaddr places the address addr, for the family of the socket, in sa and returns its
+// length. It returns false if addr is not a UDP address of that family.
+func (b *udpBatch) putSockaddr(sa *syscall.RawSockaddrInet6, addr net.Addr) (int, bool) {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, false
+	}
+	port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+	port[0], port[1] = byte(ua.Port>>8), byte(ua.Port)
+	if !b.inet6 {
+		ip4 := ua.IP.To4()
+		if ip4 == nil {
+			return 0, false
+		}
+		sa4 := (*syscall.RawSockaddrInet4)(unsafe.Pointer(sa))
+		sa4.Family = syscall.AF_INET
+		copy(sa4.Addr[:], ip4)
+		sa4.Zero = [8]uint8{}
+		return syscall.SizeofSockaddrInet4, true
+	}
+	ip16 := ua.IP.To16()
+	if ip16 == nil {
+		return 0, false
+	}
+	sa.Family = syscall.AF_INET6
+	sa.Flowinfo = 0
+	copy(sa.Addr[:], ip16)
+	sa.Scope_id = 0
+	if ua.Zone != "" {
+		if ifi, err := net.InterfaceByName(ua.Zone); err == nil {
+			sa.Scope_id = uint32(ifi.Index)
+		}
+	}
+	return syscall.SizeofSockaddrInet6, true
+}
+
+// sockaddrToUDP returns the UDP address of the socket address sa, of either family
+func sockaddrToUDP(sa *syscall.RawSockaddrInet6) *net.UDPAddr {
+	port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+	ua := &net.UDPAddr{Port: int(port[0])<<8 | int(port[1])}
+	switch sa.Family {
+	case syscall.AF_INET:
+		sa4 := (*syscall.RawSockaddrInet4)(unsafe.Pointer(sa))
+		ua.IP = net.IP(append([]byte{}, sa4.Addr[:]...))
+	case syscall.AF_INET6:
+		ua.IP = net.IP(append([]byte{}, sa.Addr[:]...))
+		ua.Zone = zoneName(sa.Scope_id)
+	}
+	return ua
+}
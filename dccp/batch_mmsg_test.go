@@ -0,0 +1,154 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build linux && (amd64 || arm64)
+
+package dccp
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestDSCP checks that the packets of a UDPLink, and of a flow of a Mux over it, leave with the
+// TOS byte, or traffic class, of their DSCP
+func TestDSCP(t *testing.T) {
+	testDSCP(t, "udp4", net.IPv4(127, 0, 0, 1), syscall.IPPROTO_IP, syscall.IP_RECVTOS, syscall.IP_TOS)
+	if c, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback}); err == nil {
+		c.Close()
+		testDSCP(t, "udp6", net.IPv6loopback, syscall.IPPROTO_IPV6, syscall.IPV6_RECVTCLASS, syscall.IPV6_TCLASS)
+	}
+}
+
+func testDSCP(t *testing.T, network string, ip net.IP, level, recvOpt, typ int) {
+	r, err := net.ListenUDP(network, &net.UDPAddr{IP: ip})
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer r.Close()
+	rc, _ := r.SyscallConn()
+	rc.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), level, recvOpt, 1)
+	})
+	if err != nil {
+		t.Fatalf("%s receive TOS: %s", network, err)
+	}
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// readTOS returns the TOS byte of the next datagram
+	readTOS := func() int {
+		buf, oob := make([]byte, 1500), make([]byte, 64)
+		_, oobn, _, _, err := r.ReadMsgUDP(buf, oob)
+		if err != nil {
+			t.Fatalf("%s read: %s", network, err)
+		}
+		cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			t.Fatalf("%s control message: %s", network, err)
+		}
+		for _, m := range cmsgs {
+			if int(m.Header.Level) == level && int(m.Header.Type) == typ && len(m.Data) > 0 {
+				return int(m.Data[0])
+			}
+		}
+		t.Fatalf("%s datagram without TOS", network)
+		return 0
+	}
+
+	link, err := BindUDPLink(network, &net.UDPAddr{IP: ip})
+	if err != nil {
+		t.Fatalf("bind: %s", err)
+	}
+	if !link.CarriesDSCP() {
+		t.Fatalf("%s link does not carry DSCP", network)
+	}
+	msgs := []LinkMsg{
+		{Buf: []byte{1}, Addr: r.LocalAddr(), DSCP: DSCPEF},
+		{Buf: []byte{2}, Addr: r.LocalAddr()},
+	}
+	if n, err := link.WriteBatch(msgs); n != len(msgs) || err != nil {
+		t.Fatalf("%s write batch: %d, %v", network, n, err)
+	}
+	if tos := readTOS(); tos != DSCPEF<<2 {
+		t.Errorf("%s TOS %#x, expecting %#x", network, tos, DSCPEF<<2)
+	}
+	if tos := readTOS(); tos != 0 {
+		t.Errorf("%s TOS %#x of unmarked datagram", network, tos)
+	}
+
+	m := NewMux(link)
+	defer m.Close()
+	f, err := m.Dial(r.LocalAddr())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	hc := NewHeaderConn(f).(dscpMarker)
+	if !hc.CarriesDSCP() {
+		t.Fatalf("%s flow does not carry DSCP", network)
+	}
+	if err := hc.SetDSCP(DSCPAF41); err != nil {
+		t.Fatalf("%s set DSCP: %s", network, err)
+	}
+	if err := f.Write([]byte{3}); err != nil {
+		t.Fatalf("%s write: %s", network, err)
+	}
+	if tos := readTOS(); tos != DSCPAF41<<2 {
+		t.Errorf("%s flow TOS %#x, expecting %#x", network, tos, DSCPAF41<<2)
+	}
+}
+
+// TestECN checks that the ECN codepoints of the packets that a UDPLink writes in a batch reach
+// the UDPLink that reads them, alongside their DSCP
+func TestECN(t *testing.T) {
+	testECN(t, "udp4", net.IPv4(127, 0, 0, 1))
+	if c, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback}); err == nil {
+		c.Close()
+		testECN(t, "udp6", net.IPv6loopback)
+	}
+}
+
+func testECN(t *testing.T, network string, ip net.IP) {
+	a, err := BindUDPLink(network, &net.UDPAddr{IP: ip})
+	if err != nil {
+		t.Fatalf("bind: %s", err)
+	}
+	defer a.Close()
+	b, err := BindUDPLink(network, &net.UDPAddr{IP: ip})
+	if err != nil {
+		t.Fatalf("bind: %s", err)
+	}
+	defer b.Close()
+	if !a.CarriesECN() || !b.CarriesECN() {
+		t.Fatalf("%s link does not carry ECN", network)
+	}
+	sent := []LinkMsg{
+		{Buf: []byte{1}, Addr: b.LocalAddr(), ECN: ECNECT0},
+		{Buf: []byte{2}, Addr: b.LocalAddr(), ECN: ECNCE, DSCP: DSCPEF},
+		{Buf: []byte{3}, Addr: b.LocalAddr()},
+	}
+	if n, err := a.WriteBatch(sent); n != len(sent) || err != nil {
+		t.Fatalf("%s write batch: %d, %v", network, n, err)
+	}
+	b.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < len(sent); {
+		msgs := make([]LinkMsg, len(sent)-i)
+		for j := range msgs {
+			msgs[j].Buf = make([]byte, 1500)
+		}
+		n, err := b.ReadBatch(msgs)
+		if err != nil {
+			t.Fatalf("%s read batch: %s", network, err)
+		}
+		for _, m := range msgs[:n] {
+			if m.N != 1 || m.Buf[0] != sent[i].Buf[0] {
+				t.Fatalf("%s read %v, expecting %v", network, m.Buf[:m.N], sent[i].Buf)
+			}
+			if m.ECN != sent[i].ECN {
+				t.Errorf("%s datagram %d: ECN %d, expecting %d", network, i, m.ECN, sent[i].ECN)
+			}
+			i++
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !linux || !(amd64 || arm64)
+
+package dccp
+
+import "net"
+
+// udpBatch reads and writes the datagrams of a batch one at a time, as recvmmsg and sendmmsg
+// are only used on Linux
+type udpBatch struct {
+	c *net.UDPConn
+}
+
+func newUDPBatch(c *net.UDPConn) *udpBatch { return &udpBatch{c} }
+
+// read receives one datagram into msgs[0], see BatchLink.ReadBatch
+func (b *udpBatch) read(msgs []LinkMsg) (int, error) { return readOne(b.c, msgs) }
+
+// write sends the datagrams of msgs one at a time, unmarked, see BatchLink.WriteBatch
+func (b *udpBatch) write(msgs []LinkMsg) (int, error) { return writeEach(b.c, msgs) }
+
+// carriesDSCP returns false, as the datagrams are not marked
+func (b *udpBatch) carriesDSCP() bool { return false }
+
+// carriesECN returns false, as the ECN codepoints of the datagrams are not passed on
+func (b *udpBatch) carriesECN() bool { return false }
@@ -0,0 +1,147 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestUDPLinkBatch sends a batch of datagrams between two UDPLinks, over IPv4 and IPv6, and
+// checks that they arrive whole, in order and from the right address
+func TestUDPLinkBatch(t *testing.T) {
+	testUDPLinkBatch(t, "udp4", net.IPv4(127, 0, 0, 1))
+	if c, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback}); err == nil {
+		c.Close()
+		testUDPLinkBatch(t, "udp6", net.IPv6loopback)
+	}
+}
+
+func testUDPLinkBatch(t *testing.T, network string, ip net.IP) {
+	a, err := BindUDPLink(network, &net.UDPAddr{IP: ip})
+	if err != nil {
+		t.Fatalf("bind: %s", err)
+	}
+	defer a.Close()
+	b, err := BindUDPLink(network, &net.UDPAddr{IP: ip})
+	if err != nil {
+		t.Fatalf("bind: %s", err)
+	}
+	defer b.Close()
+
+	const count = batchLen + 5
+	out := make([]LinkMsg, count)
+	for i := range out {
+		out[i] = LinkMsg{Buf: make([]byte, 10+i), Addr: b.LocalAddr()}
+		for j := range out[i].Buf {
+			out[i].Buf[j] = byte(i)
+		}
+	}
+	for sent := 0; sent < count; {
+		n, err := a.WriteBatch(out[sent:])
+		if err != nil {
+			t.Fatalf("%s write batch: %s", network, err)
+		}
+		sent += n
+	}
+
+	b.SetReadDeadline(time.Now().Add(5 * time.Second))
+	in := make([]LinkMsg, 8)
+	for i := range in {
+		in[i].Buf = make([]byte, 100)
+	}
+	for received := 0; received < count; {
+		n, err := b.ReadBatch(in)
+		if err != nil {
+			t.Fatalf("%s read batch: %s", network, err)
+		}
+		if n < 1 || n > len(in) {
+			t.Fatalf("%s read batch of %d", network, n)
+		}
+		for _, m := range in[:n] {
+			if m.N != 10+received || m.Buf[0] != byte(received) || m.Buf[m.N-1] != byte(received) {
+				t.Errorf("%s datagram %d: %d bytes of %d", network, received, m.N, m.Buf[0])
+			}
+			if ua, ok := m.Addr.(*net.UDPAddr); !ok || ua.Port != a.LocalAddr().(*net.UDPAddr).Port || !ua.IP.Equal(ip) {
+				t.Errorf("%s datagram %d from %s", network, received, m.Addr)
+			}
+			received++
+		}
+	}
+}
+
+// TestBatchWriter checks that a batchWriter sends the packets of concurrent writers, combining
+// them into batches, and returns the error of each packet to its writer
+func TestBatchWriter(t *testing.T) {
+	errBad := errors.New("bad packet")
+	var lk sync.Mutex
+	var sends, sent int
+	seen := make(map[byte]bool)
+	release := make(chan bool)
+	w := newBatchWriter(func(msgs []LinkMsg) (int, error) {
+		lk.Lock()
+		first := sends == 0
+		sends++
+		lk.Unlock()
+		// The first send is held back, so that the other writers queue meanwhile
+		if first {
+			<-release
+		}
+		if len(msgs) > batchLen {
+			t.Errorf("batch of %d", len(msgs))
+		}
+		lk.Lock()
+		defer lk.Unlock()
+		for i, m := range msgs {
+			if m.Buf[0]%10 == 9 {
+				return i, errBad
+			}
+			if seen[m.Buf[0]] {
+				t.Errorf("packet %d sent twice", m.Buf[0])
+			}
+			seen[m.Buf[0]] = true
+			sent++
+		}
+		return len(msgs), nil
+	})
+
+	const count = 100
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := w.write([]byte{byte(i)}, nil, 0, 0)
+			if (i%10 == 9) != (err == errBad) {
+				t.Errorf("packet %d: error %v", i, err)
+			}
+		}(i)
+		if i == 0 {
+			// Wait until the first writer leads
+			for {
+				lk.Lock()
+				n := sends
+				lk.Unlock()
+				if n > 0 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if sent != count-count/10 {
+		t.Errorf("sent %d packets, expecting %d", sent, count-count/10)
+	}
+	if sends >= count {
+		t.Errorf("%d sends for %d packets", sends, count)
+	}
+}
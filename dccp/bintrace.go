@@ -0,0 +1,467 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"sync"
+)
+
+// The binary trace format is a magic string followed by records. Each record is the uvarint
+// length of its body, then the body:
+//
+//	flags        byte, see binFlag constants
+//	time         varint, difference from the time of the previous record, in ns
+//	event        uvarint
+//	labels       uvarint count, then a string reference for each label
+//	state        string reference
+//	comment      uvarint length, then bytes
+//	header       if binFlagHeader: string reference for the type, varint SeqNo and AckNo
+//	source       if binFlagSource: string reference for the file, uvarint line
+//	stack        if binFlagStack: string reference for the stack trace
+//	sample       if binFlagSample: string references for series and unit, 8 bytes of value
+//	header info  if binFlagHeaderInfo: uvarint ports, varint CCVal, uvarint CsCov, ServiceCode,
+//	             ResetCode, ECN and DataLen, then a uvarint count of options, each a type,
+//	             a mandatory byte and length-prefixed data
+//	args         if binFlagArgs: uvarint length, then the other arguments in JSON
+//
+// A string reference is the uvarint index of the string in a table that both ends build as
+// they go. An index equal to the size of the table introduces a new string, whose uvarint
+// length and bytes follow, and which takes that index. Labels, states, source files and stack
+// traces repeat, so each costs its bytes once per file.
+
+// BinaryTraceMagic starts every binary trace, so that readers can tell it from the JSON of
+// FileTraceWriter
+const BinaryTraceMagic = "DCCPTRC1"
+
+const (
+	binFlagHeader = 1 << iota
+	binFlagSource
+	binFlagStack
+	binFlagSample
+	binFlagArgs
+	binFlagHighlight
+	binFlagHeaderInfo
+)
+
+// ErrTraceFormat is returned by TraceReader for input that is not a binary trace
+var ErrTraceFormat = NewError("trace format")
+
+// BinaryTraceWriter is a TraceWriter that saves traces in a compact binary format, an order of
+// magnitude smaller than the JSON of FileTraceWriter, which TraceReader reads back.
+type BinaryTraceWriter struct {
+	sync.Mutex
+	dst     io.Writer
+	w       *bufio.Writer
+	started bool
+	last    int64          // Time of the last record
+	strings map[string]int // Table of strings written so far
+	body    []byte         // Body of the record being encoded
+	err     error          // First error of the underlying writer
+}
+
+// NewBinaryTraceWriter creates a BinaryTraceWriter that writes to w. Sync and Close pass on to
+// w, after flushing, if it has methods of that name, like *os.File does.
+func NewBinaryTraceWriter(w io.Writer) *BinaryTraceWriter {
+	return &BinaryTraceWriter{dst: w, w: bufio.NewWriter(w), strings: make(map[string]int)}
+}
+
+// Write implements TraceWriter.Write. Once the underlying writer fails, traces are dropped and
+// the error is reported by Sync and Close.
+func (t *BinaryTraceWriter) Write(r *Trace) {
+	t.Lock()
+	defer t.Unlock()
+	if t.err != nil {
+		return
+	}
+	if !t.started {
+		t.started = true
+		if _, t.err = t.w.WriteString(BinaryTraceMagic); t.err != nil {
+			return
+		}
+	}
+	t.encode(r)
+	var n [binary.MaxVarintLen64]byte
+	if _, t.err = t.w.Write(n[:binary.PutUvarint(n[:], uint64(len(t.body)))]); t.err != nil {
+		return
+	}
+	_, t.err = t.w.Write(t.body)
+}
+
+// encode encodes the record of r into t.body
+func (t *BinaryTraceWriter) encode(r *Trace) {
+	var flags byte
+	if r.Type != "" {
+		flags |= binFlagHeader
+	}
+	if r.SourceFile != "" {
+		flags |= binFlagSource
+	}
+	if r.Trace != "" {
+		flags |= binFlagStack
+	}
+	sample, hasSample := r.Sample()
+	if hasSample {
+		flags |= binFlagSample
+	}
+	info, hasInfo := r.HeaderInfo()
+	if hasInfo {
+		flags |= binFlagHeaderInfo
+	}
+	var args []byte
+	other := make(map[string]interface{}, len(r.Args))
+	for k, v := range r.Args {
+		if k != SampleType && k != HeaderInfoType {
+			other[k] = v
+		}
+	}
+	if len(other) > 0 {
+		var err error
+		if args, err = json.Marshal(other); err == nil {
+			flags |= binFlagArgs
+		}
+	}
+	if r.Highlight {
+		flags |= binFlagHighlight
+	}
+
+	b := append(t.body[:0], flags)
+	b = binary.AppendVarint(b, r.Time-t.last)
+	t.last = r.Time
+	b = binary.AppendUvarint(b, uint64(r.Event))
+	b = binary.AppendUvarint(b, uint64(len(r.Labels)))
+	for _, l := range r.Labels {
+		b = t.appendRef(b, l)
+	}
+	b = t.appendRef(b, r.State)
+	b = appendBytes(b, r.Comment)
+	if flags&binFlagHeader != 0 {
+		b = t.appendRef(b, r.Type)
+		b = binary.AppendVarint(b, r.SeqNo)
+		b = binary.AppendVarint(b, r.AckNo)
+	}
+	if flags&binFlagSource != 0 {
+		b = t.appendRef(b, r.SourceFile)
+		b = binary.AppendUvarint(b, uint64(r.SourceLine))
+	}
+	if flags&binFlagStack != 0 {
+		b = t.appendRef(b, r.Trace)
+	}
+	if flags&binFlagSample != 0 {
+		b = t.appendRef(b, sample.Series)
+		b = t.appendRef(b, sample.Unit)
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(sample.Value))
+	}
+	if flags&binFlagHeaderInfo != 0 {
+		b = appendHeaderInfo(b, info)
+	}
+	if flags&binFlagArgs != 0 {
+		b = appendBytes(b, string(args))
+	}
+	t.body = b
+}
+
+// appendRef appends a reference to the string s to b, adding s to the table if it is new
+func (t *BinaryTraceWriter) appendRef(b []byte, s string) []byte {
+	if i, ok := t.strings[s]; ok {
+		return binary.AppendUvarint(b, uint64(i))
+	}
+	i := len(t.strings)
+	t.strings[s] = i
+	b = binary.AppendUvarint(b, uint64(i))
+	return appendBytes(b, s)
+}
+
+func appendHeaderInfo(b []byte, x *HeaderInfo) []byte {
+	b = binary.AppendUvarint(b, uint64(x.SourcePort))
+	b = binary.AppendUvarint(b, uint64(x.DestPort))
+	b = binary.AppendVarint(b, int64(x.CCVal))
+	b = binary.AppendUvarint(b, uint64(x.CsCov))
+	b = binary.AppendUvarint(b, uint64(x.ServiceCode))
+	b = binary.AppendUvarint(b, uint64(x.ResetCode))
+	b = binary.AppendUvarint(b, uint64(x.ECN))
+	b = binary.AppendUvarint(b, uint64(x.DataLen))
+	b = binary.AppendUvarint(b, uint64(len(x.Options)))
+	for _, o := range x.Options {
+		var mandatory byte
+		if o.Mandatory {
+			mandatory = 1
+		}
+		b = append(b, o.Type, mandatory)
+		b = appendBytes(b, string(o.Data))
+	}
+	return b
+}
+
+func appendBytes(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// Sync implements TraceWriter.Sync
+func (t *BinaryTraceWriter) Sync() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	if s, ok := t.dst.(interface{ Sync() error }); ok && t.err == nil {
+		t.err = s.Sync()
+	}
+	return t.err
+}
+
+// Close implements TraceWriter.Close
+func (t *BinaryTraceWriter) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	if c, ok := t.dst.(io.Closer); ok {
+		if err := c.Close(); t.err == nil {
+			t.err = err
+		}
+	}
+	return t.err
+}
+
+// TraceReader reads back the traces that BinaryTraceWriter saves
+type TraceReader struct {
+	r       *bufio.Reader
+	started bool
+	last    int64
+	strings []string
+	body    []byte
+}
+
+// NewTraceReader creates a TraceReader that reads from r
+func NewTraceReader(r io.Reader) *TraceReader {
+	return &TraceReader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next trace. At the end of the input, it returns io.EOF. Input that ends in
+// the middle of a record gives io.ErrUnexpectedEOF, and input that is not a binary trace gives
+// ErrTraceFormat. Other arguments than the Sample come back as they would from JSON.
+func (t *TraceReader) Read() (*Trace, error) {
+	if !t.started {
+		var magic [len(BinaryTraceMagic)]byte
+		if _, err := io.ReadFull(t.r, magic[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, ErrTraceFormat
+			}
+			return nil, err
+		}
+		if string(magic[:]) != BinaryTraceMagic {
+			return nil, ErrTraceFormat
+		}
+		t.started = true
+	}
+	n, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > math.MaxInt32 {
+		return nil, ErrTraceFormat
+	}
+	if cap(t.body) < int(n) {
+		t.body = make([]byte, n)
+	}
+	t.body = t.body[:n]
+	if _, err = io.ReadFull(t.r, t.body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	d := traceDecoder{t: t, b: t.body}
+	r := d.decode()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return r, nil
+}
+
+// ReadAll reads the remaining traces, up to the end of the input
+func (t *TraceReader) ReadAll() ([]*Trace, error) {
+	var traces []*Trace
+	for {
+		r, err := t.Read()
+		if err == io.EOF {
+			return traces, nil
+		}
+		if err != nil {
+			return traces, err
+		}
+		traces = append(traces, r)
+	}
+}
+
+// traceDecoder decodes the body b of a record
+type traceDecoder struct {
+	t   *TraceReader
+	b   []byte
+	err error
+}
+
+func (d *traceDecoder) decode() *Trace {
+	r := &Trace{}
+	flags := d.byte()
+	r.Time = d.t.last + d.varint()
+	d.t.last = r.Time
+	r.Event = Event(d.uvarint())
+	if n := d.uvarint(); n <= uint64(len(d.b)) {
+		r.Labels = make([]string, n)
+		for i := range r.Labels {
+			r.Labels[i] = d.ref()
+		}
+	} else {
+		d.fail()
+	}
+	r.State = d.ref()
+	r.Comment = d.bytes()
+	if flags&binFlagHeader != 0 {
+		r.Type = d.ref()
+		r.SeqNo = d.varint()
+		r.AckNo = d.varint()
+	}
+	if flags&binFlagSource != 0 {
+		r.SourceFile = d.ref()
+		r.SourceLine = int(d.uvarint())
+	}
+	if flags&binFlagStack != 0 {
+		r.Trace = d.ref()
+	}
+	r.Args = make(map[string]interface{})
+	var sample *Sample
+	if flags&binFlagSample != 0 {
+		s := Sample{Series: d.ref(), Unit: d.ref()}
+		if len(d.b) < 8 {
+			d.fail()
+			return nil
+		}
+		s.Value = math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+		d.b = d.b[8:]
+		sample = &s
+	}
+	var info *HeaderInfo
+	if flags&binFlagHeaderInfo != 0 {
+		info = d.headerInfo()
+	}
+	if flags&binFlagArgs != 0 {
+		if err := json.Unmarshal([]byte(d.bytes()), &r.Args); err != nil {
+			d.fail()
+		}
+	}
+	if sample != nil {
+		r.Args[SampleType] = *sample
+	}
+	if info != nil {
+		r.Args[HeaderInfoType] = info
+	}
+	r.Highlight = flags&binFlagHighlight != 0
+	if len(d.b) != 0 {
+		d.fail()
+	}
+	return r
+}
+
+func (d *traceDecoder) headerInfo() *HeaderInfo {
+	x := &HeaderInfo{
+		SourcePort:  uint16(d.uvarint()),
+		DestPort:    uint16(d.uvarint()),
+		CCVal:       int8(d.varint()),
+		CsCov:       byte(d.uvarint()),
+		ServiceCode: ServiceCode(d.uvarint()),
+		ResetCode:   byte(d.uvarint()),
+		ECN:         byte(d.uvarint()),
+		DataLen:     int(d.uvarint()),
+	}
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return nil
+	}
+	for i := uint64(0); i < n; i++ {
+		o := OptionInfo{Type: d.byte(), Mandatory: d.byte() != 0}
+		o.Name = OptionTypeString(o.Type)
+		if data := d.bytes(); len(data) > 0 {
+			o.Data = []byte(data)
+		}
+		x.Options = append(x.Options, o)
+	}
+	return x
+}
+
+func (d *traceDecoder) fail() {
+	if d.err == nil {
+		d.err = ErrTraceFormat
+	}
+	d.b = nil
+}
+
+func (d *traceDecoder) byte() byte {
+	if len(d.b) == 0 {
+		d.fail()
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *traceDecoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *traceDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *traceDecoder) bytes() string {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+// ref decodes a string reference, adding a new string to the table of the reader
+func (d *traceDecoder) ref() string {
+	i := d.uvarint()
+	switch {
+	case d.err != nil:
+		return ""
+	case i < uint64(len(d.t.strings)):
+		return d.t.strings[i]
+	case i == uint64(len(d.t.strings)):
+		s := d.bytes()
+		if d.err == nil {
+			d.t.strings = append(d.t.strings, s)
+		}
+		return s
+	}
+	d.fail()
+	return ""
+}
@@ -0,0 +1,72 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestBinaryTrace(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewBinaryTraceWriter(&buf)
+	l := &traceList{}
+	env := NewEnv(&teeTraceWriter{w, l})
+	amb := NewAmb("client", env).Refine("conn")
+	for i := int64(0); i < 100; i++ {
+		amb.E(EventWrite, "Write to header link", &Header{Type: DataAck, SeqNo: 1000 + i, AckNo: 500 + i,
+			Options: []*Option{{Type: OptionTimestamp, Data: []byte{0, 0, 1, byte(i)}}}})
+		amb.E(EventInfo, "rtt", NewSample("rtt", float64(i)/2, "ms"), "extra")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close (%s)", err)
+	}
+	var jsonSize int
+	for _, r := range l.traces {
+		b, _ := json.Marshal(r)
+		jsonSize += len(b)
+	}
+	if buf.Len()*5 > jsonSize {
+		t.Errorf("binary trace of %d bytes, JSON of %d", buf.Len(), jsonSize)
+	}
+	data := buf.Bytes()
+
+	traces, err := NewTraceReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("read (%s)", err)
+	}
+	if len(traces) != len(l.traces) {
+		t.Fatalf("read %d traces, wrote %d", len(traces), len(l.traces))
+	}
+	for i, r := range traces {
+		x := *l.traces[i]
+		if x.Args["string"] != nil {
+			// Arguments other than the Sample come back as JSON values
+			x.Args = map[string]interface{}{"string": "extra", SampleType: x.Args[SampleType]}
+		}
+		if !reflect.DeepEqual(r, &x) {
+			t.Fatalf("trace %d: read %+v, wrote %+v", i, r, &x)
+		}
+	}
+
+	if _, err := NewTraceReader(bytes.NewReader(data[:len(data)-1])).ReadAll(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated trace: expecting %s, encountered %v", io.ErrUnexpectedEOF, err)
+	}
+	if _, err := NewTraceReader(bytes.NewReader([]byte(`{"t":1}`))).Read(); err != ErrTraceFormat {
+		t.Errorf("JSON trace: expecting %s, encountered %v", ErrTraceFormat, err)
+	}
+}
+
+// teeTraceWriter writes traces to two TraceWriters
+type teeTraceWriter struct {
+	a, b TraceWriter
+}
+
+func (x *teeTraceWriter) Write(r *Trace) { x.a.Write(r); x.b.Write(r) }
+func (x *teeTraceWriter) Sync() error    { return x.a.Sync() }
+func (x *teeTraceWriter) Close() error   { return x.a.Close() }
@@ -0,0 +1,96 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "sync"
+
+// At tens of thousands of packets per second, a buffer allocated for every packet that is
+// encoded, sent or received keeps the garbage collector busy. The links and HeaderConns of
+// this package take their packet buffers from pools instead, and return them once the
+// packet is sent, or once the application is done with the data of a received packet.
+
+// packetBufSizes are the capacities of the pooled packet buffers. The smaller one holds the
+// packets of common MTUs, and the larger one any UDP or IP datagram.
+var packetBufSizes = [...]int{2048, 64 * 1024}
+
+// packetBufPools holds the released packet buffers of each size
+var packetBufPools [len(packetBufSizes)]sync.Pool
+
+// packetBuf is a pooled buffer that holds one packet. A received packet stays in its buffer
+// while the headers decoded from it refer to it, so the buffer of a packet with application
+// data is only reused once the application has copied the data out, see Conn.Read and
+// Conn.ReadMsg, or releases it, see Conn.ReadBorrow. Buffers that are never released, like
+// those of ReadSegment, are left to the garbage collector.
+type packetBuf struct {
+	b     []byte
+	class int  // Index of the size of the buffer in packetBufSizes, or -1 if not pooled
+	ecn   byte // ECN codepoint of a received datagram, for the packetFlow that reads it
+}
+
+// newPacketBuf returns a packet buffer of n bytes, reusing a released one if possible
+func newPacketBuf(n int) *packetBuf {
+	for i, size := range packetBufSizes {
+		if n > size {
+			continue
+		}
+		if pb, ok := packetBufPools[i].Get().(*packetBuf); ok {
+			pb.b, pb.ecn = pb.b[:n], 0
+			return pb
+		}
+		return &packetBuf{b: make([]byte, n, size), class: i}
+	}
+	return &packetBuf{b: make([]byte, n), class: -1}
+}
+
+// release returns pb for reuse. Nothing may refer to the buffer afterwards. It is a no-op
+// for a nil pb, which stands for a buffer that the link does not lend.
+func (pb *packetBuf) release() {
+	if pb == nil || pb.class < 0 {
+		return
+	}
+	packetBufPools[pb.class].Put(pb)
+}
+
+// bufSegmentConn is implemented by SegmentConns that read blocks into packet buffers, whose
+// reuse they leave to the reader
+type bufSegmentConn interface {
+	readBuf() (block []byte, ecn byte, pb *packetBuf, err error)
+}
+
+// headerEncoder is implemented by HeaderConns that encode the headers they write into the
+// wire format and keep no reference to them once Write returns. The connection reuses the
+// option slices of the headers that it writes to a headerEncoder.
+type headerEncoder interface {
+	encodesHeaders()
+}
+
+// optionsLen is the capacity of the pooled option slices, which is enough for the options
+// that the connection and its CCIDs place on a packet
+const optionsLen = 16
+
+// optionsPool holds the released option slices of outgoing headers
+var optionsPool = sync.Pool{
+	New: func() interface{} { return new([]*Option) },
+}
+
+// newOptions returns an empty option slice from the pool
+func newOptions() *[]*Option {
+	opts := optionsPool.Get().(*[]*Option)
+	if cap(*opts) < optionsLen {
+		*opts = make([]*Option, 0, optionsLen)
+	}
+	return opts
+}
+
+// releaseOptions clears the option slice opts and returns it to the pool. Options appended
+// beyond its capacity live in a new array, which is left to the garbage collector.
+func releaseOptions(opts *[]*Option) {
+	s := (*opts)[:cap(*opts)]
+	for i := range s {
+		s[i] = nil
+	}
+	*opts = s[:0]
+	optionsPool.Put(opts)
+}
@@ -0,0 +1,165 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestPacketBufLend checks that the headers read over a Mux keep the packet buffer that their
+// data lies in, so that the buffer can be released for reuse
+func TestPacketBufLend(t *testing.T) {
+	alink, dlink := NewChanPipe()
+	am, dm := NewMux(alink), NewMux(dlink)
+	defer am.Close()
+	defer dm.Close()
+	bc, err := dm.Dial(nil)
+	if err != nil {
+		t.Fatalf("dial (%s)", err)
+	}
+	h := &Header{Type: Request, X: true, SeqNo: 1, Data: []byte("hello")}
+	if err := NewHeaderConn(bc).Write(h); err != nil {
+		t.Fatalf("write (%s)", err)
+	}
+	ac, err := am.Accept()
+	if err != nil {
+		t.Fatalf("accept (%s)", err)
+	}
+	g, err := NewHeaderConn(ac).Read()
+	if err != nil || string(g.Data) != "hello" {
+		t.Fatalf("read %v (%v)", g, err)
+	}
+	if g.buf == nil {
+		t.Fatalf("header without packet buffer")
+	}
+	// Both slices end where the buffer ends
+	b, d := g.buf.b[:cap(g.buf.b)], g.Data[:cap(g.Data)]
+	if &b[len(b)-1] != &d[len(d)-1] {
+		t.Errorf("data does not lie in the packet buffer")
+	}
+	g.buf.release()
+	(*packetBuf)(nil).release()
+}
+
+func TestPacketBuf(t *testing.T) {
+	for _, c := range []struct{ n, cap int }{{100, 2048}, {2048, 2048}, {5000, 64 * 1024}, {70000, 70000}} {
+		pb := newPacketBuf(c.n)
+		if len(pb.b) != c.n || cap(pb.b) != c.cap {
+			t.Errorf("buffer of %d bytes has length %d, capacity %d", c.n, len(pb.b), cap(pb.b))
+		}
+		pb.release()
+	}
+}
+
+// TestAppend checks that a packet appended to a used buffer comes out as it does from Write,
+// and that appending into a buffer of enough capacity does not allocate
+func TestAppend(t *testing.T) {
+	gh := testHeaders[0]
+	srcIP, dstIP := []byte{1, 2, 3, 4}, []byte{5, 6, 7, 8}
+	want, _ := gh.Write(srcIP, dstIP, 34, false)
+	buf := make([]byte, 3, 2048)
+	for i := range buf[:cap(buf)] {
+		buf[:cap(buf)][i] = 0xff
+	}
+	have, err := gh.Append(buf, srcIP, dstIP, 34, false)
+	if err != nil || !bytes.Equal(have[3:], want) || !bytes.Equal(have[:3], buf) || &have[0] != &buf[0] {
+		t.Errorf("appended %s (%v), expecting %s", dumpBytes(have), err, dumpBytes(want))
+	}
+	if have, _ = gh.Append(buf[:3:10], srcIP, dstIP, 34, false); !bytes.Equal(have[3:], want) || !bytes.Equal(have[:3], buf) {
+		t.Errorf("appended beyond capacity %s, expecting %s", dumpBytes(have), dumpBytes(want))
+	}
+
+	ts, _ := (&TimestampOption{Timestamp: 12345}).Encode()
+	h := &Header{Type: DataAck, X: true, SeqNo: 7, AckNo: 5, CsCov: CsCov8,
+		Options: []*Option{ts, &Option{OptionSlowReceiver, nil, true}}, Data: make([]byte, 1001)}
+	allocs := testing.AllocsPerRun(100, func() {
+		h.Append(buf[:0], srcIP, dstIP, 34, false)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per append", allocs)
+	}
+}
+
+// BenchmarkAppend encodes a data packet with options into a preallocated buffer
+func BenchmarkAppend(b *testing.B) {
+	ts, _ := (&TimestampOption{Timestamp: 12345}).Encode()
+	h := &Header{Type: DataAck, X: true, SeqNo: 7, AckNo: 5,
+		Options: []*Option{ts, &Option{OptionSlowReceiver, nil, true}}, Data: make([]byte, 1000)}
+	buf := make([]byte, 0, 1500)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.Append(buf, LabelZero.Bytes(), LabelZero.Bytes(), AnyProto, false)
+	}
+}
+
+// BenchmarkHeaderWrite encodes a data packet into a new buffer
+func BenchmarkHeaderWrite(b *testing.B) {
+	h := &Header{Type: DataAck, X: true, SeqNo: 7, AckNo: 5, Data: make([]byte, 1000)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.Write(LabelZero.Bytes(), LabelZero.Bytes(), AnyProto, false)
+	}
+}
+
+// BenchmarkHeaderWritePooled appends a data packet to a pooled buffer, as a HeaderConn does
+func BenchmarkHeaderWritePooled(b *testing.B) {
+	h := &Header{Type: DataAck, X: true, SeqNo: 7, AckNo: 5, Data: make([]byte, 1000)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pb := newPacketBuf(1500)
+		h.Append(pb.b[:0], LabelZero.Bytes(), LabelZero.Bytes(), AnyProto, false)
+		pb.release()
+	}
+}
+
+func TestReleaseOptions(t *testing.T) {
+	opts := newOptions()
+	ts, _ := (&TimestampOption{Timestamp: 1}).Encode()
+	*opts = append(*opts, ts, ts)
+	releaseOptions(opts)
+	if s := (*opts)[:cap(*opts)]; len(*opts) != 0 || s[0] != nil || s[1] != nil {
+		t.Errorf("released options %v", s)
+	}
+}
+
+// BenchmarkMux sends data packets over a Mux and reads them on the other side, releasing the
+// read buffers as an application that borrows them does
+func BenchmarkMux(b *testing.B) {
+	alink, dlink := NewChanPipe()
+	am, dm := NewMux(alink), NewMux(dlink)
+	defer am.Close()
+	defer dm.Close()
+	bc, err := dm.Dial(nil)
+	if err != nil {
+		b.Fatalf("dial (%s)", err)
+	}
+	dhc := NewHeaderConn(bc)
+	h := &Header{Type: Request, X: true, SeqNo: 1}
+	if err := dhc.Write(h); err != nil {
+		b.Fatalf("write (%s)", err)
+	}
+	ac, err := am.Accept()
+	if err != nil {
+		b.Fatalf("accept (%s)", err)
+	}
+	ahc := NewHeaderConn(ac)
+	if _, err := ahc.Read(); err != nil {
+		b.Fatalf("read (%s)", err)
+	}
+	h = &Header{Type: DataAck, X: true, SeqNo: 2, AckNo: 1, Data: make([]byte, 1000)}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := dhc.Write(h); err != nil {
+			b.Fatalf("write (%s)", err)
+		}
+		g, err := ahc.Read()
+		if err != nil {
+			b.Fatalf("read (%s)", err)
+		}
+		g.buf.release()
+	}
+}
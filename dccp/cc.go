@@ -63,8 +63,8 @@ type SenderCongestionControl interface {
 	// NOTE: If the CC is not active, OnRead MUST return nil.
 	OnRead(fb *FeedbackHeader) error
 
-	// Strobe blocks until a new packet can be sent without violating the
-	// congestion control rate limit. 
+	// Strobe blocks until a new packet of application data can be sent without
+	// violating the congestion control rate limit. Packets without data are not strobed.
 	// NOTE: If the CC is not active, Strobe MUST return immediately.
 	Strobe()
 
@@ -111,6 +111,70 @@ type ReceiverCongestionControl interface {
 	Close()
 }
 
+// AckRatioSender is optionally implemented by sender CCIDs, like CCID2, that adjust the Ack
+// Ratio of the connection, Section 11.3. Conn polls GetAckRatio whenever it synchronizes with
+// the congestion control, and negotiates a new Ack Ratio when the returned value changes.
+type AckRatioSender interface {
+
+	// GetAckRatio returns the desired Ack Ratio. A return value of zero leaves the Ack Ratio
+	// unchanged.
+	GetAckRatio() uint16
+}
+
+// AckVectorSender is optionally implemented by sender CCIDs, like CCID2, that rely on Ack
+// Vectors. If WantsAckVector returns true when the connection is created, Conn asks the
+// HC-Receiver to enable the Send Ack Vector feature, Section 11.5. Ack Vector options reach
+// the sender CCID through FeedbackHeader.Options.
+type AckVectorSender interface {
+	WantsAckVector() bool
+}
+
+// NDPCountReceiver is optionally implemented by receiver CCIDs that make use of NDP Count
+// options. If WantsNDPCount returns true when the connection is created, Conn asks the other
+// endpoint to enable the Send NDP Count feature, Section 7.7.2. The received counts are
+// passed in FeedforwardHeader.NDPCount.
+type NDPCountReceiver interface {
+	WantsNDPCount() bool
+}
+
+// MPSSender is optionally implemented by sender CCIDs whose segment size follows the Maximum
+// Packet Size of the connection, Section 14. Conn calls SetMPS whenever the MPS changes after
+// the connection has started, as it does when path MTU discovery finds a smaller path MTU.
+type MPSSender interface {
+	SetMPS(mps int32)
+}
+
+// StatsSender is optionally implemented by sender CCIDs that report on their congestion state.
+// Conn.Stats calls GetStats from the goroutine of the caller, rather than the event loop of the
+// connection.
+type StatsSender interface {
+	GetStats() SenderStats
+}
+
+// SenderStats is the congestion state of a sender CCID, see StatsSender. Fields that a CCID
+// does not keep are zero.
+type SenderStats struct {
+	RTTVar     int64 // Variation of the round-trip time, in ns
+	LossEvents int64 // Congestion events, of loss or ECN marks, since the CCID was opened
+	Cwnd       int64 // Congestion window, in packets, of a window-based CCID
+	Rate       int64 // Allowed sending rate, in bytes per second, of a rate-based CCID
+}
+
+// StateReporter is optionally implemented by sender and receiver CCIDs that expose a snapshot
+// of their internals, for monitoring and experiments. The snapshot is a value of a type that the
+// CCID defines, like ccid2.SenderState. Conn.CCIDState calls CCIDState from the goroutine of the
+// caller.
+type StateReporter interface {
+	CCIDState() interface{}
+}
+
+// AckRatioReceiver is optionally implemented by receiver CCIDs whose acknowledgement rate is
+// governed by the Ack Ratio feature. If UsesAckRatio returns true, Conn sends an Ack whenever
+// Ack Ratio data packets have been received without one.
+type AckRatioReceiver interface {
+	UsesAckRatio() bool
+}
+
 // PreHeader contains information that is shown to the 
 // sender and receiver congesion controls before a packet is sent.
 // PreHeader contains the parts of the DCCP header than are fixed before the
@@ -122,8 +186,8 @@ type PreHeader struct {
 	AckNo int64
 
 	// TimeInject is the time when the packet was injected into the write
-	// queue. This is either in the readLoop in response to a received
-	// packet, in the idleLoop in response to idleness, or in the user
+	// queue. This is either in the event loop in response to a received
+	// packet, in the idle polls in response to idleness, or in the user
 	// facing Write method. TimeInject is currently commented out,
 	// since it is not used by the CC logic.
 	// TimeInject int64
@@ -136,6 +200,9 @@ type PreHeader struct {
 	// the roundtrip time without factoring rate-related wait times in
 	// endpoint queues.
 	TimeWrite int64
+
+	// Length of application data in bytes
+	DataLen int
 }
 
 // FeedbackHeader contains information that is shown to the 
@@ -171,15 +238,32 @@ type FeedforwardHeader struct {
 
 	// Length of application data in bytes
 	DataLen int
+
+	// ECN codepoint of the IP packet carrying the header
+	ECN byte
+
+	// NDP Count carried by the header, or zero if none, Section 7.7
+	NDPCount uint64
 }
 
-// CCID is a factory type that creates instances of sender and receiver CCIDs
+// CCID is a factory type that creates instances of sender and receiver CCIDs. CCIDs are made
+// available for negotiation with RegisterCCID.
 type CCID interface {
-	NewSender(env *Env, amb *Amb, args ...interface{}) SenderCongestionControl
-	NewReceiver(env *Env, amb *Amb, args ...interface{}) ReceiverCongestionControl
+	NewSender(env *Env, amb *Amb) SenderCongestionControl
+	NewReceiver(env *Env, amb *Amb) ReceiverCongestionControl
 }
 
 const (
 	CCID2 = 2 // TCP-like Congestion Control, RFC 4341
 	CCID3 = 3 // TCP-Friendly Rate Control (TFRC), RFC 4342
+	CCID4 = 4 // TCP-Friendly Rate Control for Small Packets (TFRC-SP), RFC 5622
 )
+
+// knownCCID returns true if id is the CCID of a congestion control implemented in this tree,
+// or one registered with RegisterCCID
+func knownCCID(id uint64) bool {
+	if id == CCID2 || id == CCID3 || id == CCID4 || id == CCID_FIXED {
+		return true
+	}
+	return id <= 0xff && LookupCCID(byte(id)) != nil
+}
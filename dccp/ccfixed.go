@@ -4,6 +4,8 @@
 
 package dccp
 
+import "time"
+
 type CCFixed struct {
 
 }
@@ -19,29 +21,36 @@ func (CCFixed) NewReceiver(env *Env, amb *Amb) ReceiverCongestionControl {
 // ---> Fixed-rate HC-Sender Congestion Control
 
 type fixedRateSenderControl struct {
-	env         *Env
+	env *Env
 	Mutex
-	every       int64 // Strobe every every nanoseconds
-	strobeRead  chan int
-	strobeWrite chan int
+	every  int64 // Strobe every every nanoseconds
+	strobe chan int
+	done   chan struct{} // Closed by Close
+
+	// Account for strobe and done on virtual time, and for the calls of Strobe that wait
+	strobeT, doneT, wantT Track
 }
 
 func newFixedRateSenderControl(env *Env, every int64) *fixedRateSenderControl {
-	strobe := make(chan int)
-	return &fixedRateSenderControl{env: env, every: every, strobeRead: strobe, strobeWrite: strobe}
+	return &fixedRateSenderControl{env: env, every: every, strobe: make(chan int), done: make(chan struct{})}
 }
 
+// Open starts the strobes. Only packets with application data wait for them, so the strober
+// must not hold the lock while it waits for one to take its strobe.
 func (scc *fixedRateSenderControl) Open() {
 	scc.env.Go(func() {
 		for {
-			scc.Lock()
-			if scc.strobeWrite == nil {
-				scc.Unlock()
-				break
+			scc.env.Wait(&scc.wantT, &scc.doneT)
+			select {
+			case scc.strobe <- 1:
+				scc.env.Woken(&scc.wantT, &scc.doneT)
+				scc.env.Received(&scc.wantT)
+				scc.env.Sent(&scc.strobeT)
+			case <-scc.done:
+				scc.env.Woken(&scc.wantT, &scc.doneT)
+				return
 			}
-			scc.strobeWrite <- 1
-			scc.Unlock()
-			scc.env.Sleep(scc.every)
+			scc.env.Sleep(time.Duration(scc.every))
 		}
 	}, "fixedRateSenderControl")
 }
@@ -63,7 +72,16 @@ func (scc *fixedRateSenderControl) OnRead(fb *FeedbackHeader) error { return nil
 func (scc *fixedRateSenderControl) OnIdle(now int64) error { return nil }
 
 func (scc *fixedRateSenderControl) Strobe() {
-	<-scc.strobeRead
+	// The strober waits for a call of Strobe to take its strobe
+	scc.env.Sent(&scc.wantT)
+	scc.env.Wait(&scc.strobeT, &scc.doneT)
+	select {
+	case <-scc.strobe:
+		scc.env.Woken(&scc.strobeT, &scc.doneT)
+		scc.env.Received(&scc.strobeT)
+	case <-scc.done:
+		scc.env.Woken(&scc.strobeT, &scc.doneT)
+	}
 }
 
 func (scc *fixedRateSenderControl) SetHeartbeat(interval int64) {
@@ -72,9 +90,9 @@ func (scc *fixedRateSenderControl) SetHeartbeat(interval int64) {
 func (scc *fixedRateSenderControl) Close() {
 	scc.Lock()
 	defer scc.Unlock()
-	if scc.strobeWrite != nil {
-		close(scc.strobeWrite)
-		scc.strobeWrite = nil
+	if !isClosed(scc.done) {
+		close(scc.done)
+		scc.env.Closed(&scc.doneT)
 	}
 }
 
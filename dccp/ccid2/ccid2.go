@@ -0,0 +1,24 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+// Package ccid2 implements CCID2, TCP-like Congestion Control, RFC 4341
+package ccid2
+
+import (
+	"github.com/petar/GoDCCP/dccp"
+)
+
+func init() {
+	dccp.RegisterCCID(dccp.CCID2, CCID2{})
+}
+
+type CCID2 struct{}
+
+func (CCID2) NewSender(env *dccp.Env, amb *dccp.Amb) dccp.SenderCongestionControl {
+	return newSender(env, amb)
+}
+
+func (CCID2) NewReceiver(env *dccp.Env, amb *dccp.Amb) dccp.ReceiverCongestionControl {
+	return newReceiver(env, amb)
+}
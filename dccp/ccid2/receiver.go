@@ -0,0 +1,89 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid2
+
+import (
+	"github.com/petar/GoDCCP/dccp"
+)
+
+func newReceiver(env *dccp.Env, amb *dccp.Amb) *receiver {
+	return &receiver{env: env, amb: amb.Refine("receiver")}
+}
+
+// receiver implements the CCID2 HC-Receiver and it conforms to dccp.ReceiverCongestionControl.
+// The receiver sends no CCID-specific options. Acknowledgements are clocked by the Ack Ratio,
+// which Conn enforces since receiver implements dccp.AckRatioReceiver, and they carry Ack
+// Vectors, which Conn adds since the sender asks for them, RFC 4341, Section 6.
+type receiver struct {
+	env *dccp.Env
+	amb *dccp.Amb
+	dccp.Mutex
+	open         bool // Whether the CC is active
+	dataSinceAck bool // True if data packets have been received since the last Ack
+}
+
+// GetID() returns the CCID of this congestion control algorithm
+func (r *receiver) GetID() byte { return dccp.CCID2 }
+
+// UsesAckRatio implements dccp.AckRatioReceiver
+func (r *receiver) UsesAckRatio() bool { return true }
+
+// Open tells the Congestion Control that the connection has entered
+// OPEN or PARTOPEN state and that the CC can now kick in.
+func (r *receiver) Open() {
+	r.Lock()
+	defer r.Unlock()
+	if r.open {
+		panic("opening an open ccid2 receiver")
+	}
+	r.open = true
+	r.dataSinceAck = false
+}
+
+// Conn calls OnWrite before a packet is sent to give CongestionControl
+// an opportunity to add CCVal and options to an outgoing packet
+func (r *receiver) OnWrite(ph *dccp.PreHeader) (options []*dccp.Option) {
+	r.Lock()
+	defer r.Unlock()
+	if ph.Type == dccp.Ack || ph.Type == dccp.DataAck {
+		r.dataSinceAck = false
+	}
+	return nil
+}
+
+// Conn calls OnRead after a packet has been accepted and validated
+func (r *receiver) OnRead(ff *dccp.FeedforwardHeader) error {
+	r.Lock()
+	defer r.Unlock()
+	if !r.open {
+		return nil
+	}
+	if ff.Type == dccp.Data || ff.Type == dccp.DataAck {
+		r.dataSinceAck = true
+	}
+	return nil
+}
+
+// OnIdle acknowledges data that has been waiting for an acknowledgement for about a round-trip
+// time, since the Ack Ratio alone would leave the tail of a flight unacknowledged. This plays
+// the role of TCP's delayed acknowledgement timer.
+func (r *receiver) OnIdle(now int64) error {
+	r.Lock()
+	defer r.Unlock()
+	if !r.open {
+		return nil
+	}
+	if r.dataSinceAck {
+		return dccp.CongestionAck
+	}
+	return nil
+}
+
+// Close terminates the half-connection congestion control when it is not needed any longer
+func (r *receiver) Close() {
+	r.Lock()
+	defer r.Unlock()
+	r.open = false
+}
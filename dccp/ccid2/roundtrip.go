@@ -0,0 +1,82 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid2
+
+import (
+	"github.com/petar/GoDCCP/dccp"
+)
+
+const (
+	InitialRTO = 1e9  // Retransmission timeout before the first RTT sample, RFC 6298
+	MinRTO     = 2e8  // Lower bound on the retransmission timeout
+	MaxRTO     = 64e9 // Upper bound on the retransmission timeout, reached by exponential backoff
+)
+
+// senderRoundtripEstimator maintains the smoothed round-trip time and the retransmission timeout
+// of a CCID2 sender, as TCP does, RFC 4341, Section 5 and RFC 6298
+type senderRoundtripEstimator struct {
+	srtt   int64 // Smoothed round-trip time, or zero if there is no sample yet
+	rttvar int64 // Round-trip time variation
+	rto    int64 // Current retransmission timeout, including any backoff
+}
+
+// Init resets the estimator for new use
+func (t *senderRoundtripEstimator) Init() {
+	t.srtt = 0
+	t.rttvar = 0
+	t.rto = InitialRTO
+}
+
+// Sample incorporates a new round-trip time measurement and clears any timeout backoff
+func (t *senderRoundtripEstimator) Sample(rtt int64) {
+	if rtt <= 0 {
+		return
+	}
+	if t.srtt == 0 {
+		t.srtt = rtt
+		t.rttvar = rtt / 2
+	} else {
+		t.rttvar = (3*t.rttvar + abs64(t.srtt-rtt)) / 4
+		t.srtt = (7*t.srtt + rtt) / 8
+	}
+	t.rto = min64(max64(t.srtt+4*t.rttvar, MinRTO), MaxRTO)
+}
+
+// Backoff doubles the retransmission timeout, after it has expired
+func (t *senderRoundtripEstimator) Backoff() {
+	t.rto = min64(2*t.rto, MaxRTO)
+}
+
+// RTT returns the smoothed round-trip time, and false if it is a default value
+func (t *senderRoundtripEstimator) RTT() (rtt int64, estimated bool) {
+	if t.srtt == 0 {
+		return dccp.RoundtripDefault, false
+	}
+	return t.srtt, true
+}
+
+// RTO returns the current retransmission timeout
+func (t *senderRoundtripEstimator) RTO() int64 { return t.rto }
+
+func abs64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func min64(x, y int64) int64 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func max64(x, y int64) int64 {
+	if x > y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,289 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid2
+
+import (
+	"fmt"
+	"github.com/petar/GoDCCP/dccp"
+)
+
+const (
+	FixedSegmentSize = 1500 // Congestion Control Maximum Packet Size
+	InitialWindow    = 3    // Initial congestion window in packets, RFC 4341, Section 5
+	MinSSThresh      = 2    // Lower bound on the slow-start threshold, in packets
+	NumDupAck        = 3    // Packets acknowledged after a packet that declare it lost, RFC 4341, Section 5
+	DefaultAckRatio  = 2    // Ack Ratio requested while the congestion window allows it
+)
+
+func newSender(env *dccp.Env, amb *dccp.Amb) *sender {
+	return &sender{env: env, amb: amb.Refine("sender"), wake: make(chan int, 1)}
+}
+
+// sentPacket is a data packet that has been sent but neither acknowledged nor declared lost
+type sentPacket struct {
+	SeqNo   int64
+	Time    int64 // Time of sending
+	DupAcks int   // Number of later packets that have been acknowledged
+}
+
+// sender implements the CCID2 HC-Sender and it conforms to dccp.SenderCongestionControl.
+// It maintains a congestion window of data packets, which grows in slow start and congestion
+// avoidance as the Ack Vectors sent by the receiver acknowledge packets, and which is halved
+// once per window of data on loss or ECN marks, RFC 4341, Section 5.
+type sender struct {
+	env   *dccp.Env
+	amb   *dccp.Amb
+	wake  chan int   // Signals Strobe that the window may have opened up
+	wakeT dccp.Track // Accounts for wake on virtual time
+	dccp.Mutex    // Locks all fields below
+	senderRoundtripEstimator
+	open         bool         // Whether the CC is active
+	cwnd         int64        // Congestion window, in packets
+	ssthresh     int64        // Slow-start threshold, in packets
+	acked        int64        // Packets acknowledged since cwnd last grew in congestion avoidance
+	pipe         []sentPacket // Outstanding data packets, in increasing order of SeqNo
+	gss          int64        // Greatest sequence number sent
+	recoverSeqNo int64        // Losses up to this SeqNo belong to the last congestion event
+	progress     int64        // Time of the last acknowledgement of new data, or of sending into an empty pipe
+	lossEvents   int64        // Number of times the congestion window was halved
+}
+
+// GetID() returns the CCID of this congestion control algorithm
+func (s *sender) GetID() byte { return dccp.CCID2 }
+
+// GetCCMPS returns the Congestion Control Maximum Packet Size, CCMPS
+func (s *sender) GetCCMPS() int32 { return FixedSegmentSize }
+
+// GetRTT returns the Round-Trip Time as measured by this CCID
+func (s *sender) GetRTT() int64 {
+	s.Lock()
+	defer s.Unlock()
+	rtt, _ := s.senderRoundtripEstimator.RTT()
+	return rtt
+}
+
+// WantsAckVector implements dccp.AckVectorSender. CCID2 relies on Ack Vectors, RFC 4341, Section 3.
+func (s *sender) WantsAckVector() bool { return true }
+
+// GetAckRatio implements dccp.AckRatioSender. The Ack Ratio must not exceed half the congestion
+// window, rounded up, RFC 4341, Section 6.1.2.
+func (s *sender) GetAckRatio() uint16 {
+	s.Lock()
+	defer s.Unlock()
+	if !s.open {
+		return 0
+	}
+	return uint16(min64(DefaultAckRatio, (s.cwnd+1)/2))
+}
+
+// GetStats implements dccp.StatsSender
+func (s *sender) GetStats() dccp.SenderStats {
+	s.Lock()
+	defer s.Unlock()
+	return dccp.SenderStats{RTTVar: s.rttvar, LossEvents: s.lossEvents, Cwnd: s.cwnd}
+}
+
+// Open tells the Congestion Control that the connection has entered
+// OPEN or PARTOPEN state and that the CC can now kick in.
+func (s *sender) Open() {
+	s.Lock()
+	defer s.Unlock()
+	if s.open {
+		panic("opening an open ccid2 sender")
+	}
+	s.senderRoundtripEstimator.Init()
+	s.cwnd = InitialWindow
+	s.ssthresh = 1<<31 - 1
+	s.acked = 0
+	s.pipe = nil
+	s.gss = 0
+	s.recoverSeqNo = 0
+	s.progress = 0
+	s.lossEvents = 0
+	s.open = true
+}
+
+// Conn calls OnWrite before a packet is sent to give CongestionControl
+// an opportunity to add CCVal and options to an outgoing packet
+func (s *sender) OnWrite(ph *dccp.PreHeader) (ccval int8, options []*dccp.Option) {
+	s.Lock()
+	defer s.Unlock()
+	if !s.open {
+		return 0, nil
+	}
+	s.gss = max64(s.gss, ph.SeqNo)
+	if ph.Type != dccp.Data && ph.Type != dccp.DataAck {
+		return 0, nil
+	}
+	if len(s.pipe) == 0 {
+		s.progress = ph.TimeWrite
+	}
+	s.pipe = append(s.pipe, sentPacket{SeqNo: ph.SeqNo, Time: ph.TimeWrite})
+	return 0, nil
+}
+
+// findAckVector returns the Ack Vector carried in opts, or nil if there is none
+func findAckVector(opts []*dccp.Option) *dccp.AckVectorOption {
+	for _, opt := range opts {
+		if av := dccp.DecodeAckVectorOption(opt); av != nil {
+			return av
+		}
+	}
+	return nil
+}
+
+// Conn calls OnRead after a packet has been accepted and validated
+func (s *sender) OnRead(fb *dccp.FeedbackHeader) error {
+	s.Lock()
+	defer s.Unlock()
+	if !s.open {
+		return nil
+	}
+	if fb.Type != dccp.Ack && fb.Type != dccp.DataAck {
+		return nil
+	}
+	av := findAckVector(fb.Options)
+
+	// Partition the pipe into acknowledged and outstanding packets. Without an Ack Vector,
+	// only the packet named by the Acknowledgement Number is known to have arrived.
+	var acked, marked int
+	pipe := s.pipe[:0]
+	for _, p := range s.pipe {
+		state, ok := byte(dccp.AckVectorReceived), p.SeqNo == fb.AckNo
+		if av != nil {
+			state, ok = av.State(fb.AckNo, p.SeqNo)
+		}
+		if !ok || state == dccp.AckVectorNotReceived {
+			pipe = append(pipe, p)
+			continue
+		}
+		if p.SeqNo == fb.AckNo {
+			s.senderRoundtripEstimator.Sample(fb.Time - p.Time)
+		}
+		// Every packet acknowledged counts against the outstanding packets sent before it
+		for i := range pipe {
+			pipe[i].DupAcks++
+		}
+		acked++
+		// Like losses, marks on packets sent before the last reduction of the window belong
+		// to the congestion event that caused it
+		if state == dccp.AckVectorECNMarked && p.SeqNo > s.recoverSeqNo {
+			marked++
+		}
+	}
+	s.pipe = pipe
+	if acked == 0 {
+		return nil
+	}
+	s.progress = fb.Time
+
+	// Declare lost the packets after which NumDupAck packets have been acknowledged
+	var lost []int64
+	pipe = s.pipe[:0]
+	for _, p := range s.pipe {
+		if p.DupAcks >= NumDupAck {
+			lost = append(lost, p.SeqNo)
+			continue
+		}
+		pipe = append(pipe, p)
+	}
+	s.pipe = pipe
+
+	congested := marked > 0
+	for _, seqNo := range lost {
+		if seqNo > s.recoverSeqNo {
+			congested = true
+		}
+	}
+	if congested {
+		s.onCongestion(fmt.Sprintf("Lost %d, marked %d", len(lost), marked))
+	} else {
+		s.grow(int64(acked))
+	}
+	s.signal()
+	return nil
+}
+
+// grow opens the congestion window for n newly acknowledged packets, RFC 4341, Section 5
+func (s *sender) grow(n int64) {
+	for ; n > 0; n-- {
+		if s.cwnd < s.ssthresh {
+			s.cwnd++
+			continue
+		}
+		s.acked++
+		if s.acked >= s.cwnd {
+			s.cwnd++
+			s.acked = 0
+		}
+	}
+}
+
+// onCongestion halves the congestion window, at most once per window of data
+func (s *sender) onCongestion(reason string) {
+	s.ssthresh = max64(s.cwnd/2, MinSSThresh)
+	s.cwnd = s.ssthresh
+	s.acked = 0
+	s.recoverSeqNo = s.gss
+	s.lossEvents++
+	s.amb.E(dccp.EventInfo, fmt.Sprintf("Congestion (%s), cwnd=%d", reason, s.cwnd))
+}
+
+// signal wakes up a pending Strobe, without blocking
+func (s *sender) signal() {
+	select {
+	case s.wake <- 1:
+		s.env.Sent(&s.wakeT)
+	default:
+	}
+}
+
+// Strobe blocks until the congestion window allows another packet to be sent
+func (s *sender) Strobe() {
+	for {
+		s.Lock()
+		if !s.open || int64(len(s.pipe)) < s.cwnd {
+			s.Unlock()
+			return
+		}
+		s.Unlock()
+		s.env.Wait(&s.wakeT)
+		<-s.wake
+		s.env.Woken(&s.wakeT)
+		s.env.Received(&s.wakeT)
+	}
+}
+
+// OnIdle checks the retransmission timer. If no new data has been acknowledged for an RTO while
+// packets are outstanding, they are all declared lost and the sender restarts from a window of
+// one packet, backing off the timer, RFC 4341, Section 5.
+func (s *sender) OnIdle(now int64) error {
+	s.Lock()
+	defer s.Unlock()
+	if !s.open || len(s.pipe) == 0 || now-s.progress < s.RTO() {
+		return nil
+	}
+	s.ssthresh = max64(s.cwnd/2, MinSSThresh)
+	s.cwnd = 1
+	s.acked = 0
+	s.pipe = nil
+	s.recoverSeqNo = s.gss
+	s.progress = now
+	s.senderRoundtripEstimator.Backoff()
+	s.amb.E(dccp.EventInfo, fmt.Sprintf("Timeout, RTO=%d", s.RTO()))
+	s.signal()
+	return nil
+}
+
+// SetHeartbeat advices the CCID of the desired frequency of heartbeat packets
+func (s *sender) SetHeartbeat(interval int64) {}
+
+// Close terminates the half-connection congestion control when it is not needed any longer
+func (s *sender) Close() {
+	s.Lock()
+	defer s.Unlock()
+	s.open = false
+	s.signal()
+}
@@ -0,0 +1,103 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid2
+
+import (
+	"testing"
+	"github.com/petar/GoDCCP/dccp"
+)
+
+type nullTraceWriter struct{}
+
+func (nullTraceWriter) Write(*dccp.Trace) {}
+func (nullTraceWriter) Sync() error       { return nil }
+func (nullTraceWriter) Close() error      { return nil }
+
+func newTestSender() *sender {
+	env := dccp.NewEnv(nullTraceWriter{})
+	s := newSender(env, dccp.NewAmb("test", env))
+	s.Open()
+	return s
+}
+
+// ack feeds the sender an Ack whose Ack Vector describes the given runs, starting at ackNo
+func ack(t *testing.T, s *sender, now, ackNo int64, runs ...dccp.AckVectorRun) {
+	opt, err := (&dccp.AckVectorOption{Runs: runs}).Encode()
+	if err != nil {
+		t.Fatalf("encoding ack vector (%s)", err)
+	}
+	s.OnRead(&dccp.FeedbackHeader{Type: dccp.Ack, X: true, AckNo: ackNo, Options: []*dccp.Option{opt}, Time: now})
+}
+
+func send(s *sender, now int64, seqNos ...int64) {
+	for _, q := range seqNos {
+		s.OnWrite(&dccp.PreHeader{Type: dccp.Data, X: true, SeqNo: q, TimeWrite: now})
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	s := newTestSender()
+	send(s, 0, 1, 2, 3)
+	ack(t, s, 1e8, 3, dccp.AckVectorRun{State: dccp.AckVectorReceived, Len: 3})
+	if s.cwnd != InitialWindow+3 || len(s.pipe) != 0 {
+		t.Errorf("expecting cwnd %d and empty pipe, encountered %d and %d", InitialWindow+3, s.cwnd, len(s.pipe))
+	}
+	if rtt, ok := s.RTT(); !ok || rtt != 1e8 {
+		t.Errorf("expecting rtt %d, encountered %d", int64(1e8), rtt)
+	}
+}
+
+func TestLoss(t *testing.T) {
+	s := newTestSender()
+	send(s, 0, 1, 2, 3, 4, 5)
+	// Packet 2 is missing, while the three packets after it have arrived
+	ack(t, s, 1e8, 5,
+		dccp.AckVectorRun{State: dccp.AckVectorReceived, Len: 3},
+		dccp.AckVectorRun{State: dccp.AckVectorNotReceived, Len: 1},
+		dccp.AckVectorRun{State: dccp.AckVectorReceived, Len: 1},
+	)
+	if s.cwnd != MinSSThresh || len(s.pipe) != 0 {
+		t.Errorf("expecting cwnd %d and empty pipe, encountered %d and %d", MinSSThresh, s.cwnd, len(s.pipe))
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s := newTestSender()
+	send(s, 0, 1, 2)
+	s.OnIdle(InitialRTO / 2)
+	if len(s.pipe) != 2 {
+		t.Fatalf("premature timeout")
+	}
+	s.OnIdle(InitialRTO)
+	if s.cwnd != 1 || len(s.pipe) != 0 || s.RTO() != 2*InitialRTO {
+		t.Errorf("timeout: cwnd=%d pipe=%d rto=%d", s.cwnd, len(s.pipe), s.RTO())
+	}
+}
+
+// TestECNMarks checks that ECN marks on the packets of one window halve the congestion window
+// once, like losses
+func TestECNMarks(t *testing.T) {
+	s := newTestSender()
+	send(s, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	ack(t, s, 1e8, 10, dccp.AckVectorRun{State: dccp.AckVectorReceived, Len: 10})
+	send(s, 1e8, 11, 12, 13, 14)
+	ack(t, s, 2e8, 11, dccp.AckVectorRun{State: dccp.AckVectorECNMarked, Len: 1})
+	cwnd := s.cwnd
+	if s.lossEvents != 1 {
+		t.Fatalf("expecting 1 congestion event, encountered %d", s.lossEvents)
+	}
+	// Further marks from the same window do not halve it again
+	ack(t, s, 2e8, 12, dccp.AckVectorRun{State: dccp.AckVectorECNMarked, Len: 2})
+	ack(t, s, 2e8, 13, dccp.AckVectorRun{State: dccp.AckVectorECNMarked, Len: 3})
+	if s.lossEvents != 1 || s.cwnd != cwnd {
+		t.Errorf("expecting 1 congestion event and cwnd %d, encountered %d and %d", cwnd, s.lossEvents, s.cwnd)
+	}
+	// A mark on a packet sent after the reduction is a new congestion event
+	send(s, 2e8, 15)
+	ack(t, s, 3e8, 15, dccp.AckVectorRun{State: dccp.AckVectorECNMarked, Len: 1})
+	if s.lossEvents != 2 {
+		t.Errorf("expecting 2 congestion events, encountered %d", s.lossEvents)
+	}
+}
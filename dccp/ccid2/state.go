@@ -0,0 +1,68 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid2
+
+import (
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// SenderState is a snapshot of the internals of a CCID2 sender, see dccp.StateReporter
+type SenderState struct {
+	Open       bool  // Whether the CC is active; the other fields are zero before it is
+	Cwnd       int64 // Congestion window, in packets
+	Ssthresh   int64 // Slow-start threshold, in packets
+	Pipe       int64 // Data packets sent but neither acknowledged nor declared lost
+	RTT        int64 // Smoothed round-trip time in ns, or dccp.RoundtripDefault before a sample
+	RTTVar     int64 // Round-trip time variation, in ns
+	RTO        int64 // Retransmission timeout, including any backoff, in ns
+	LossEvents int64 // Number of times the congestion window was halved
+}
+
+// ReceiverState is a snapshot of the internals of a CCID2 receiver, see dccp.StateReporter
+type ReceiverState struct {
+	Open         bool // Whether the CC is active
+	DataSinceAck bool // Whether data packets have been received since the last Ack
+}
+
+// CCIDState implements dccp.StateReporter
+func (s *sender) CCIDState() interface{} {
+	s.Lock()
+	defer s.Unlock()
+	if !s.open {
+		return SenderState{}
+	}
+	rtt, _ := s.senderRoundtripEstimator.RTT()
+	return SenderState{
+		Open:       true,
+		Cwnd:       s.cwnd,
+		Ssthresh:   s.ssthresh,
+		Pipe:       int64(len(s.pipe)),
+		RTT:        rtt,
+		RTTVar:     s.rttvar,
+		RTO:        s.rto,
+		LossEvents: s.lossEvents,
+	}
+}
+
+// CCIDState implements dccp.StateReporter
+func (r *receiver) CCIDState() interface{} {
+	r.Lock()
+	defer r.Unlock()
+	return ReceiverState{Open: r.open, DataSinceAck: r.dataSinceAck}
+}
+
+// GetSenderState returns the state of the sender CCID of c, and false if it is not CCID2
+func GetSenderState(c *dccp.Conn) (SenderState, bool) {
+	s, _ := c.CCIDState()
+	state, ok := s.(SenderState)
+	return state, ok
+}
+
+// GetReceiverState returns the state of the receiver CCID of c, and false if it is not CCID2
+func GetReceiverState(c *dccp.Conn) (ReceiverState, bool) {
+	_, r := c.CCIDState()
+	state, ok := r.(ReceiverState)
+	return state, ok
+}
@@ -8,12 +8,31 @@ import (
 	"github.com/petar/GoDCCP/dccp"
 )
 
-type CCID3 struct {}
+func init() {
+	dccp.RegisterCCID(dccp.CCID3, CCID3{})
+	dccp.RegisterCCID(dccp.CCID4, CCID4{})
+}
+
+// CCID3 is TCP-Friendly Rate Control (TFRC), RFC 4342. Its fields turn on experimental
+// behaviours of the senders it creates, and the zero value is plain RFC 5348.
+type CCID3 struct {
+	// FasterRestart lets a sender that resumes after an idle or data-limited period regain
+	// the rate it last achieved faster, quadrupling rather than doubling the receive limit
+	// each round-trip time, draft-ietf-dccp-tfrc-faster-restart
+	FasterRestart bool
+
+	// ReduceOscillations spaces the packets of a sender by the instantaneous rate of RFC 5348,
+	// Section 4.5, which falls below the allowed sending rate as queues build up along the
+	// path and the RTT grows, and rises above it as they drain
+	ReduceOscillations bool
+}
 
-func (CCID3) NewSender(env *dccp.Env, amb *dccp.Amb) dccp.SenderCongestionControl { 
-	return newSender(env, amb)
+func (c CCID3) NewSender(env *dccp.Env, amb *dccp.Amb) dccp.SenderCongestionControl { 
+	s := newSender(env, amb, dccp.CCID3)
+	s.fasterRestart, s.reduceOscillations = c.FasterRestart, c.ReduceOscillations
+	return s
 }
 
 func (CCID3) NewReceiver(env *dccp.Env, amb *dccp.Amb) dccp.ReceiverCongestionControl { 
-	return newReceiver(env, amb)
+	return newReceiver(env, amb, dccp.CCID3)
 }
@@ -0,0 +1,101 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid3
+
+import (
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// CCID4 is TCP-Friendly Rate Control for Small Packets (TFRC-SP), RFC 5622. It is meant for
+// applications, like VoIP, that send small packets at a high rate. CCID4 shares the TFRC
+// machinery of CCID3 and differs only at the sender, which (a) computes the throughput equation
+// with a nominal segment size, (b) discounts the packet header from the allowed rate, and
+// (c) never sends packets closer together than MinPacketInterval.
+type CCID4 struct{}
+
+func (CCID4) NewSender(env *dccp.Env, amb *dccp.Amb) dccp.SenderCongestionControl {
+	return newSender(env, amb, dccp.CCID4)
+}
+
+func (CCID4) NewReceiver(env *dccp.Env, amb *dccp.Amb) dccp.ReceiverCongestionControl {
+	return newReceiver(env, amb, dccp.CCID4)
+}
+
+const (
+	// NominalSegmentSize is the segment size used by CCID4 in the throughput equation,
+	// irrespective of the actual packet size, RFC 5622, Section 5
+	NominalSegmentSize = 1460
+
+	// HeaderSize is the packet header size, in bytes, by which CCID4 discounts the allowed
+	// sending rate, RFC 5622, Section 5
+	HeaderSize = 36
+
+	// MinPacketInterval is the minimum time interval between two CCID4 packets, in
+	// nanoseconds, which limits the sender to 100 packets per second, RFC 5622, Section 5
+	MinPacketInterval = 10e6
+)
+
+// eqSegmentSize returns the segment size that enters the throughput equation
+func (s *sender) eqSegmentSize() uint32 {
+	if s.id == dccp.CCID4 {
+		return NominalSegmentSize
+	}
+	return s.segmentSize()
+}
+
+// allowedRate converts the rate x, computed by the throughput equation, into the rate at which
+// the sender may transmit. CCID4 reduces x by the factor ss/(ss+HeaderSize), where ss is the
+// mean size of the packets sent, to account for the overhead of headers on small packets.
+// Until the first data packet, ss is the segment size.
+func (s *sender) allowedRate(x uint32) uint32 {
+	if s.id != dccp.CCID4 {
+		return x
+	}
+	ss := uint64(s.senderPacketSize.Mean())
+	if ss == 0 {
+		ss = uint64(s.segmentSize())
+	}
+	r := uint64(x) * ss / (ss + HeaderSize)
+	if r == 0 {
+		return 1
+	}
+	return uint32(r)
+}
+
+// Weights of the size of a new packet and of the mean size of the packets before it, in the
+// mean packet size of CCID4
+const (
+	PacketSizeWeightNew = 1
+	PacketSizeWeightOld = 9
+)
+
+// senderPacketSize keeps the mean size of the application data of the packets sent, by which
+// CCID4 discounts the packet header from the allowed rate
+type senderPacketSize struct {
+	mean float64 // Mean size in bytes, or zero before the first data packet
+}
+
+// Init resets the object for new use
+func (t *senderPacketSize) Init() {
+	t.mean = 0
+}
+
+// OnWrite adds the packet of ph to the mean, if it carries application data
+func (t *senderPacketSize) OnWrite(ph *dccp.PreHeader) {
+	if ph.Type != dccp.Data && ph.Type != dccp.DataAck || ph.DataLen == 0 {
+		return
+	}
+	if t.mean == 0 {
+		t.mean = float64(ph.DataLen)
+		return
+	}
+	t.mean = (float64(ph.DataLen)*PacketSizeWeightNew + t.mean*PacketSizeWeightOld) /
+		(PacketSizeWeightNew + PacketSizeWeightOld)
+}
+
+// Mean returns the mean packet size, in bytes, or zero if no data packet has been sent
+func (t *senderPacketSize) Mean() uint32 {
+	return uint32(t.mean + 0.5)
+}
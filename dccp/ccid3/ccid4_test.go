@@ -0,0 +1,36 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid3
+
+import (
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+func TestAllowedRateCCID4(t *testing.T) {
+	s := &sender{id: dccp.CCID4}
+	s.senderSegmentSize.SetMPS(FixedSegmentSize)
+	s.senderPacketSize.Init()
+	// Before any data is sent, the header is discounted from segments of the MPS
+	if x := s.allowedRate(30360); x != 30000 {
+		t.Errorf("no data: expecting 30000, encountered %d", x)
+	}
+	// Packets of 100 bytes pay for a header of 36 bytes. Acks do not count.
+	for i := 0; i < 50; i++ {
+		s.senderPacketSize.OnWrite(&dccp.PreHeader{Type: dccp.DataAck, DataLen: 100})
+		s.senderPacketSize.OnWrite(&dccp.PreHeader{Type: dccp.Ack})
+	}
+	if x := s.allowedRate(13600); x != 10000 {
+		t.Errorf("small packets: expecting 10000, encountered %d", x)
+	}
+	// The mean follows the size of the packets sent
+	for i := 0; i < 100; i++ {
+		s.senderPacketSize.OnWrite(&dccp.PreHeader{Type: dccp.DataAck, DataLen: 200})
+	}
+	if x := s.allowedRate(23600); x != 20000 {
+		t.Errorf("larger packets: expecting 20000, encountered %d", x)
+	}
+}
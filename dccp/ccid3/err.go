@@ -10,4 +10,5 @@ import "errors"
 var (
 	ErrMissingOption = errors.New("missing option")
 	ErrNoAck         = errors.New("packet is not an ack")
+	ErrInconsistent  = errors.New("loss intervals inconsistent with ack vector")
 )
@@ -27,14 +27,15 @@ type lossRateCalculator struct {
 	h         []float64
 }
 
-// Init resets the calculator for new use with the given nInterval parameter
+// Init resets the calculator for new use with the given nInterval parameter. The calculation
+// uses the nInterval most recent closed loss intervals, as well as the open interval I_0.
 func (t *lossRateCalculator) Init(nInterval int) {
 	t.nInterval = nInterval
 	t.w = make([]float64, nInterval)
 	for i, _ := range t.w {
 		t.w[i] = intervalWeight(i, nInterval)
 	}
-	t.h = make([]float64, nInterval)
+	t.h = make([]float64, nInterval+1)
 }
 
 func intervalWeight(i, nInterval int) float64 {
@@ -52,7 +53,7 @@ func intervalWeight(i, nInterval int) float64 {
 func (t *lossRateCalculator) CalcLossEventRateInv(history []*LossIntervalDetail) uint32 {
 
 	// Prepare a slice with interval lengths
-	k := min(len(history), t.nInterval)
+	k := min(len(history), t.nInterval+1)
 	if k < 2 {
 		// Too few loss events are reported as UnknownLossEventRateInv which signifies 'no loss'
 		return UnknownLossEventRateInv
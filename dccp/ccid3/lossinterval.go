@@ -105,6 +105,11 @@ func (t *evolveInterval) OnRead(ff *dccp.FeedforwardHeader, rtt int64) {
 			ff)
 		return
 	}
+	// Packets marked Congestion Experienced are treated as lost, RFC 4342, Section 9. Skipping
+	// them here makes them appear as a gap when the next packet is received.
+	if ff.ECN == dccp.ECNCE {
+		return
+	}
 
 	// Keep a separate count of non-Data packets
 	if ff.Type != dccp.Data && ff.Type != dccp.DataAck {
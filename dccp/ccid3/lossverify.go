@@ -0,0 +1,75 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid3
+
+import (
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// lossIntervalsVerifier keeps the packet states reported by the receiver's Ack Vectors and
+// checks the receiver's Loss Intervals options against them, RFC 4342, Section 6.1. A receiver
+// that under-reports losses in order to obtain a higher sending rate has to report consistent
+// Ack Vectors as well, which makes such misbehaviour harder.
+type lossIntervalsVerifier struct {
+	state map[int64]byte // Most recently reported Ack Vector state of each sequence number
+	hi    int64          // Greatest sequence number with a known state
+}
+
+// lossVerifyHistory is the number of sequence numbers whose Ack Vector state is remembered
+const lossVerifyHistory = 2048
+
+// Init resets the verifier for new use
+func (t *lossIntervalsVerifier) Init() {
+	t.state = make(map[int64]byte)
+	t.hi = 0
+}
+
+// OnRead records the packet states of the Ack Vector carried by the feedback packet fb, if any
+func (t *lossIntervalsVerifier) OnRead(fb *dccp.FeedbackHeader) {
+	var av *dccp.AckVectorOption
+	for _, opt := range fb.Options {
+		if av = dccp.DecodeAckVectorOption(opt); av != nil {
+			break
+		}
+	}
+	if av == nil {
+		return
+	}
+	seqNo := fb.AckNo
+	for _, run := range av.Runs {
+		for i := 0; i < run.Len; i++ {
+			t.state[seqNo] = run.State
+			seqNo--
+		}
+	}
+	// XXX: Must use circular arithmetic here
+	if fb.AckNo <= t.hi {
+		return
+	}
+	t.hi = fb.AckNo
+	for s := range t.state {
+		if s <= t.hi-lossVerifyHistory {
+			delete(t.state, s)
+		}
+	}
+}
+
+// Verify returns ErrInconsistent if a loss interval contradicts the recorded Ack Vector states.
+// The lossless part of each interval must consist of packets that were received unmarked.
+// The lossy part is not checked, since a lost packet that arrives late legitimately appears as
+// received in later Ack Vectors. Packets whose state is unknown are not checked either.
+func (t *lossIntervalsVerifier) Verify(details []*LossIntervalDetail) error {
+	lo := t.hi - lossVerifyHistory
+	for _, d := range details {
+		from := max64(d.StartSeqNo+int64(d.LossLength), lo)
+		to := min64(d.StartSeqNo+int64(d.SeqLen()), t.hi+1)
+		for s := from; s < to; s++ {
+			if state, ok := t.state[s]; ok && state != dccp.AckVectorReceived {
+				return ErrInconsistent
+			}
+		}
+	}
+	return nil
+}
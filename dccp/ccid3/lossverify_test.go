@@ -0,0 +1,44 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid3
+
+import (
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+func TestLossIntervalsVerifier(t *testing.T) {
+	// Packets 101 to 120 were sent; 105 and 106 were lost
+	av := &dccp.AckVectorOption{
+		Runs: []dccp.AckVectorRun{
+			{State: dccp.AckVectorReceived, Len: 14},
+			{State: dccp.AckVectorNotReceived, Len: 2},
+			{State: dccp.AckVectorReceived, Len: 4},
+		},
+	}
+	opt, err := av.Encode()
+	if err != nil {
+		t.Fatalf("encoding ack vector (%s)", err)
+	}
+	var v lossIntervalsVerifier
+	v.Init()
+	v.OnRead(&dccp.FeedbackHeader{Type: dccp.Ack, AckNo: 120, Options: []*dccp.Option{opt}})
+
+	honest := recoverIntervalDetails(120, 0, []*LossInterval{
+		&LossInterval{LossLength: 2, LosslessLength: 14},
+	})
+	if err := v.Verify(honest); err != nil {
+		t.Errorf("honest loss intervals rejected (%s)", err)
+	}
+	// A receiver that hides the loss reports an interval that only started after it
+	hiding := recoverIntervalDetails(120, 0, []*LossInterval{
+		&LossInterval{LossLength: 1, LosslessLength: 5},
+		&LossInterval{LossLength: 1, LosslessLength: 13},
+	})
+	if v.Verify(hiding) != ErrInconsistent {
+		t.Errorf("hidden loss not detected")
+	}
+}
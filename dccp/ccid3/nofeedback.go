@@ -13,14 +13,11 @@ import (
 type senderNoFeedbackTimer struct {
 	resetTime    int64 // Last time we got feedback; ns since UTC
 	idleSince    int64 // Time last packet of any type was sent
-	lastDataSent int64 // Time last data packet was sent, or zero otherwise; ns since UTC
-	dataInvFreq  int64 // Interval between data packets, or zero if unknown; ns
+	sendInterval int64 // Time to send one segment at the allowed sending rate, s/X, or zero if unknown; ns
 	rtt          int64 // Current known round-trip time estimate, or zero if none; ns
 }
 
 const (
-	NoFeedbackWeightNew      = 1
-	NoFeedbackWeightOld      = 2
 	NoFeedbackTimeoutWithoutRoundtrip = 2e9 // nofeedback timer expiration before RTT estimate, 2 sec
 )
 
@@ -28,11 +25,20 @@ const (
 func (t *senderNoFeedbackTimer) Init() {
 	t.resetTime = 0
 	t.idleSince = 0
-	t.lastDataSent = 0
-	t.dataInvFreq = 0
+	t.sendInterval = 0
 	t.rtt = 0
 }
 
+// SetRate informs the timer of the allowed sending rate x, in bytes per second, and the segment
+// size ss, which determine the timeout together with the RTT
+func (t *senderNoFeedbackTimer) SetRate(x uint32, ss uint32) {
+	if x == 0 {
+		t.sendInterval = 0
+		return
+	}
+	t.sendInterval = (1e9 * int64(ss)) / int64(x)
+}
+
 func (t *senderNoFeedbackTimer) GetIdleSinceAndReset() (idleSince int64, nofeedbackSet int64) {
 	return t.idleSince, t.resetTime
 }
@@ -52,7 +58,6 @@ func (t *senderNoFeedbackTimer) OnRead(rtt int64, rttEstimated bool, fb *dccp.Fe
 }
 
 // Sender calls OnWrite each time a packet is sent out to the receiver.
-// OnWrite is used to calculate timing between data packet sends.
 func (t *senderNoFeedbackTimer) OnWrite(ph *dccp.PreHeader) {
 	// The very first time resetTime is set to equal the time when the first packet goes out,
 	// since we are waiting for a feedback since that starting time. Afterwards, resetTime
@@ -61,25 +66,6 @@ func (t *senderNoFeedbackTimer) OnWrite(ph *dccp.PreHeader) {
 		t.resetTime = ph.TimeWrite
 	}
 	t.idleSince = ph.TimeWrite
-
-	// Update inverse frequency of data packets estimate
-	if ph.Type != dccp.Data && ph.Type != dccp.DataAck {
-		return
-	}
-	if t.lastDataSent == 0 {
-		t.lastDataSent = ph.TimeWrite
-		return
-	}
-	d := ph.TimeWrite - t.lastDataSent
-	if d <= 0 {
-		return
-	}
-	if t.dataInvFreq == 0 {
-		t.dataInvFreq = d
-		return
-	}
-	t.dataInvFreq = (d*NoFeedbackWeightNew + t.dataInvFreq*NoFeedbackWeightOld) / 
-		(NoFeedbackWeightNew + NoFeedbackWeightOld)
 }
 
 // Sender calls OnIdle every time the idle clock ticks. OnIdle returns true if the
@@ -95,13 +81,11 @@ func (t *senderNoFeedbackTimer) Reset(now int64) {
 	t.resetTime = now
 }
 
-// timeout returns the current duration of the nofeedback timer in ns
+// timeout returns the current duration of the nofeedback timer in ns, which is
+// max(4*R, 2*s/X), RFC 5348, Section 4.3
 func (t *senderNoFeedbackTimer) timeout() int64 {
 	if t.rtt <= 0 {
 		return NoFeedbackTimeoutWithoutRoundtrip
 	}
-	if t.dataInvFreq <= 0 {
-		return 4*t.rtt
-	}
-	return max64(4*t.rtt, 2*t.dataInvFreq)
+	return max64(4*t.rtt, 2*t.sendInterval)
 }
@@ -95,7 +95,10 @@ func DecodeLossIntervalsOption(opt *dccp.Option) *LossIntervalsOption {
 		return nil
 	}
 	skip := dccp.DecodeUint8(opt.Data[0:1])
-	intervals := make([]*LossInterval, k)
+	var intervals []*LossInterval
+	if k > 0 {
+		intervals = make([]*LossInterval, k)
+	}
 	for i := 0; i < k; i++ {
 		start := 1 + lossIntervalFootprint*i
 		intervals[i] = decodeLossInterval(opt.Data[start : start+lossIntervalFootprint])
@@ -120,8 +123,8 @@ func (opt *LossIntervalsOption) Encode() (*dccp.Option, error) {
 	dccp.EncodeUint8(opt.SkipLength, d[0:1])
 	for i, lossInterval := range opt.LossIntervals {
 		j := 1 + i*lossIntervalFootprint
-		if lossInterval.encode(d[j:j+lossIntervalFootprint]) != nil {
-			return nil, nil
+		if err := lossInterval.encode(d[j:j+lossIntervalFootprint]); err != nil {
+			return nil, err
 		}
 	}
 	return &dccp.Option{
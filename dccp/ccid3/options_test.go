@@ -40,8 +40,15 @@ var lossIntervalsOptions = []*LossIntervalsOption{
 		LossIntervals: nil,
 	},
 	&LossIntervalsOption{
-		SkipLength:    0,
-		LossIntervals: []*LossInterval{},
+		SkipLength:    1,
+		LossIntervals: []*LossInterval{
+			&LossInterval{
+				LosslessLength: 1<<24 - 1,
+				LossLength:     1<<23 - 1,
+				DataLength:     1<<24 - 1,
+				ECNNonceEcho:   false,
+			},
+		},
 	},
 	&LossIntervalsOption{
 		SkipLength:    0,
@@ -0,0 +1,55 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid3
+
+import (
+	"math"
+)
+
+// senderOscillationReducer implements the optional oscillation prevention of RFC 5348,
+// Section 4.5. It keeps R_sqmean, a moving average of the square root of the RTT samples, and
+// scales the sending rate by R_sqmean/sqrt(R_sample). The sender thus slows down when queues
+// build up along the path and the RTT grows, and speeds up as they drain. This is useful for
+// long-lived flows over paths with small buffers.
+type senderOscillationReducer struct {
+	sqmean float64 // R_sqmean, in square root nanoseconds, or zero before the first sample
+	sample int64   // The most recent RTT sample, R_sample, in nanoseconds
+}
+
+// OscillationQ2 is the weight of the history in the R_sqmean average, RFC 5348, Section 4.5
+const OscillationQ2 = 0.9
+
+// Init resets the reducer for new use
+func (t *senderOscillationReducer) Init() {
+	t.sqmean = 0
+	t.sample = 0
+}
+
+// OnSample updates R_sqmean with a new RTT sample
+func (t *senderOscillationReducer) OnSample(sample int64) {
+	if sample <= 0 {
+		return
+	}
+	t.sample = sample
+	sq := math.Sqrt(float64(sample))
+	if t.sqmean == 0 {
+		t.sqmean = sq
+		return
+	}
+	t.sqmean = OscillationQ2*t.sqmean + (1-OscillationQ2)*sq
+}
+
+// Rate returns the instantaneous allowed sending rate X_inst, corresponding to the allowed
+// sending rate x
+func (t *senderOscillationReducer) Rate(x uint32) uint32 {
+	if t.sample == 0 {
+		return x
+	}
+	xinst := float64(x) * t.sqmean / math.Sqrt(float64(t.sample))
+	if xinst >= X_RECV_MAX {
+		return X_RECV_MAX
+	}
+	return maxu32(uint32(xinst), 1)
+}
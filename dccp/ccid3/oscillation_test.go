@@ -0,0 +1,28 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid3
+
+import (
+	"testing"
+)
+
+func TestOscillationReducer(t *testing.T) {
+	var r senderOscillationReducer
+	r.Init()
+	if x := r.Rate(1000); x != 1000 {
+		t.Errorf("no samples: expecting 1000, encountered %d", x)
+	}
+	for i := 0; i < 50; i++ {
+		r.OnSample(100e6)
+	}
+	if x := r.Rate(1000); x != 1000 {
+		t.Errorf("stable path: expecting 1000, encountered %d", x)
+	}
+	// A sudden fourfold increase of the RTT, due to queueing, reduces the rate by about half
+	r.OnSample(400e6)
+	if x := r.Rate(1000); x < 500 || x > 600 {
+		t.Errorf("queueing: expecting about 550, encountered %d", x)
+	}
+}
@@ -9,14 +9,15 @@ import (
 	"github.com/petar/GoDCCP/dccp"
 )
 
-func newReceiver(env *dccp.Env, amb *dccp.Amb) *receiver {
-	return &receiver{ env: env, amb: amb.Refine("receiver") }
+func newReceiver(env *dccp.Env, amb *dccp.Amb, id byte) *receiver {
+	return &receiver{ env: env, amb: amb.Refine("receiver"), id: id }
 }
 
 // receiver implements CCID3 congestion control and it conforms to dccp.ReceiverCongestionControl
 type receiver struct {
 	env *dccp.Env
 	amb *dccp.Amb
+	id  byte // dccp.CCID3, or dccp.CCID4 whose receiver behaves identically
 	dccp.Mutex
 	receiverRoundtripEstimator
 	receiverRateCalculator
@@ -41,7 +42,7 @@ type receiver struct {
 
 // GetID() returns the CCID of this congestion control algorithm
 func (r *receiver) GetID() byte {
-	return dccp.CCID3
+	return r.id
 }
 
 // Open tells the Congestion Control that the connection has entered
@@ -105,7 +106,7 @@ func (r *receiver) OnWrite(ph *dccp.PreHeader) (options []*dccp.Option) {
 		// Prepare feedback options, if we've seen packets before
 		// XXX: Maybe gsr = 0 should not indicate not seen packets, use something else
 		if r.gsr > 0 {
-			opts := make([]*dccp.Option, 3)
+			opts := make([]*dccp.Option, 4)
 			opts[0] = encodeOption(r.makeElapsedTimeOption(ph.AckNo, ph.TimeWrite))
 			if opts[0] == nil {
 				r.amb.E(dccp.EventWarn, "ElapsedTime option encoding == nil", ph)
@@ -118,6 +119,7 @@ func (r *receiver) OnWrite(ph *dccp.PreHeader) (options []*dccp.Option) {
 			if opts[2] == nil {
 				r.amb.E(dccp.EventWarn, "LossIntervals option encoding == nil", ph)
 			}
+			opts[3] = encodeOption(&LossEventRateOption{r.lastLossEventRateInv})
 			r.amb.E(dccp.EventInfo, fmt.Sprintf("Placed %d receiver opts", len(opts)), ph)
 			return opts
 		}
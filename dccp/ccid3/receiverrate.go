@@ -52,7 +52,9 @@ func (r *receiverRateCalculator) Flush(rtt int64, timeWrite int64) *ReceiveRateO
 		panic("receive rate period")
 	}
 	if d1 < rtt {
-		return &ReceiveRateOption{rate(r.data0, d0)}
+		// Measuring over less than a round-trip time overestimates the rate of a bursty
+		// sender, and is impossible if all data arrived at timeWrite, RFC 5348, Section 6.2
+		return &ReceiveRateOption{rate(r.data0, max64(max64(d0, rtt), 1))}
 	}
 	rval := rate(r.data0, d0)
 	r.data0, r.data1 = r.data1, 0
@@ -0,0 +1,31 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid3
+
+import (
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+func TestReceiverRateCalculator(t *testing.T) {
+	const rtt = 100e6
+	var r receiverRateCalculator
+	r.Init()
+
+	// Data arriving at the time of the feedback is measured over one round-trip time
+	r.OnRead(&dccp.FeedforwardHeader{Type: dccp.Data, Time: 1e9, DataLen: 1000})
+	if x := r.Flush(rtt, 1e9).Rate; x != 10000 {
+		t.Errorf("burst: expecting 10000, encountered %d", x)
+	}
+
+	// Data spread over two round-trip times is measured over the period it arrived in
+	for i := int64(1); i <= 20; i++ {
+		r.OnRead(&dccp.FeedforwardHeader{Type: dccp.Data, Time: 1e9 + i*10e6, DataLen: 1000})
+	}
+	if x := r.Flush(rtt, 1e9+200e6).Rate; x != 21000*5 {
+		t.Errorf("steady: expecting %d, encountered %d", 21000*5, x)
+	}
+}
@@ -6,11 +6,12 @@ package ccid3
 
 import (
 	"fmt"
+	"time"
 	"github.com/petar/GoDCCP/dccp"
 )
 
-// RoundtripSample converts a roundtrip time in nanosecond to a floating-point time in milliseconds
-func RoundtripSample(series string, rtt int64) dccp.Sample {
+// RoundtripSample converts a roundtrip time to a floating-point time in milliseconds
+func RoundtripSample(series string, rtt time.Duration) dccp.Sample {
 	return dccp.NewSample(series, float64(rtt) / 1e6, "ms")
 }
 
@@ -50,6 +51,7 @@ func (t *senderRoundtripReporter) OnWrite(rtt int64, timeWrite int64) *dccp.Opti
 type senderRoundtripEstimator struct {
 	amb   *dccp.Amb
 	estimate int64
+	sample   int64					// The most recent RTT sample, or zero if none
 	k        int					// The index of the next history cell to write in
 	history  [SenderRoundtripHistoryLen]sendTime	// Circular array, recording departure times of last few packets
 }
@@ -69,6 +71,7 @@ const (
 func (t *senderRoundtripEstimator) Init(amb *dccp.Amb) {
 	t.amb = amb.Refine("senderRoundtripEstimator")
 	t.estimate = 0
+	t.sample = 0
 	t.k = 0
 	for i, _ := range t.history {
 		t.history[i] = sendTime{} // Zero Time indicates no data
@@ -130,6 +133,7 @@ func (t *senderRoundtripEstimator) OnRead(fb *dccp.FeedbackHeader) bool {
 		t.amb.E(dccp.EventWarn, "Invalid elapsed opt", fb)
 		return false
 	}
+	t.sample = est
 	est_old := t.estimate
 	if est_old == 0 {
 		t.estimate = est
@@ -138,7 +142,7 @@ func (t *senderRoundtripEstimator) OnRead(fb *dccp.FeedbackHeader) bool {
 			(SenderRoundtripWeightNew + SenderRoundtripWeightOld)
 	}
 	t.amb.E(dccp.EventMatch, fmt.Sprintf("Elapsed —> RTT=%s", dccp.Nstoa(t.estimate)), fb, 
-		RoundtripSample(RoundtripElapsedSample, t.estimate), RoundtripElapsedCheckpoint)
+		RoundtripSample(RoundtripElapsedSample, time.Duration(t.estimate)), RoundtripElapsedCheckpoint)
 
 	return true
 }
@@ -153,6 +157,11 @@ func (t *senderRoundtripEstimator) RTT() (rtt int64, estimated bool) {
 	return t.estimate, true
 }
 
+// Sample returns the most recent RTT sample in ns, or zero if none has been taken
+func (t *senderRoundtripEstimator) Sample() int64 {
+	return t.sample
+}
+
 // HasRTT returns true if senderRoundtripEstimator has enough sample data for an estimate
 func (t *senderRoundtripEstimator) HasRTT() bool {
 	return t.estimate > 0
@@ -205,7 +214,7 @@ func (t *receiverRoundtripEstimator) OnRead(ff *dccp.FeedforwardHeader) bool {
 	// Update RTT estimate
 	t.rtt, t.rttTime = rtt, ff.Time
 	t.amb.E(dccp.EventMatch, fmt.Sprintf("Report —> RTT=%s", dccp.Nstoa(t.rtt)), ff, 
-		RoundtripSample(RoundtripReportSample, t.rtt), RoundtripReportCheckpoint)
+		RoundtripSample(RoundtripReportSample, time.Duration(t.rtt)), RoundtripReportCheckpoint)
 
 	return true
 }
@@ -9,8 +9,8 @@ import (
 	"github.com/petar/GoDCCP/dccp"
 )
 
-func newSender(env *dccp.Env, amb *dccp.Amb) *sender {
-	return &sender{ env: env, amb: amb.Refine("sender") }
+func newSender(env *dccp.Env, amb *dccp.Amb, id byte) *sender {
+	return &sender{ env: env, amb: amb.Refine("sender"), id: id }
 }
 
 // sender implements a CCID3 congestion control sender.
@@ -18,6 +18,7 @@ func newSender(env *dccp.Env, amb *dccp.Amb) *sender {
 type sender struct {
 	env *dccp.Env
 	amb *dccp.Amb
+	id  byte // dccp.CCID3, or dccp.CCID4 for the small-packet variant
 	senderStrober
 	dccp.Mutex // Locks all fields below
 	senderRoundtripEstimator
@@ -25,13 +26,24 @@ type sender struct {
 	senderWindowCounter
 	senderNoFeedbackTimer
 	senderSegmentSize
+	senderPacketSize
 	senderLossTracker
 	senderRateCalculator
-	open bool // Whether the CC is active
+	senderOscillationReducer
+	open       bool  // Whether the CC is active
+	mps        int32 // Maximum Packet Size of the connection, as last set by SetMPS, or zero
+	lossEvents int64 // Loss events reported by the receiver since the CC was opened
+
+	fasterRestart      bool // Whether Faster Restart is on, see CCID3.FasterRestart
+	reduceOscillations bool // Whether packets are spaced by the instantaneous rate, see CCID3.ReduceOscillations
 }
 
 // GetID() returns the CCID of this congestion control algorithm
-func (s *sender) GetID() byte { return dccp.CCID3 }
+func (s *sender) GetID() byte { return s.id }
+
+// WantsAckVector implements dccp.AckVectorSender. The sender verifies the loss intervals
+// reported by the receiver against its Ack Vectors, RFC 4342, Section 6.1.
+func (s *sender) WantsAckVector() bool { return true }
 
 // GetCCMPS returns the Congestion Control Maximum Packet Size, CCMPS. Generally, PMTU <= CCMPS
 // TODO: For the time being we use a fixed CCMPS
@@ -45,6 +57,13 @@ func (s *sender) GetRTT() int64 {
 	return rtt
 }
 
+// GetStats implements dccp.StatsSender
+func (s *sender) GetStats() dccp.SenderStats {
+	s.Lock()
+	defer s.Unlock()
+	return dccp.SenderStats{LossEvents: s.lossEvents, Rate: int64(s.senderRateCalculator.X())}
+}
+
 // Open tells the Congestion Control that the connection has entered
 // OPEN or PARTOPEN state and that the CC can now kick in. Before the
 // call to Open and after the call to Close, the Strobe function is
@@ -61,13 +80,43 @@ func (s *sender) Open() {
 	s.senderRoundtripReporter.Init()
 	s.senderNoFeedbackTimer.Init()
 	s.senderSegmentSize.Init()
-	s.senderSegmentSize.SetMPS(FixedSegmentSize)
+	s.senderSegmentSize.SetMPS(s.mpsSegmentSize())
+	s.senderPacketSize.Init()
 	s.senderLossTracker.Init(s.amb)
-	s.senderRateCalculator.Init(s.amb, FixedSegmentSize, rtt)
-	s.senderStrober.Init(s.env, s.amb, s.senderRateCalculator.X(), FixedSegmentSize)
+	s.senderRateCalculator.Init(s.amb, s.eqSegmentSize(), rtt)
+	s.senderRateCalculator.SetFasterRestart(s.fasterRestart)
+	s.senderOscillationReducer.Init()
+	s.lossEvents = 0
+	s.senderStrober.Init(s.env, s.amb, s.allowedRate(s.senderRateCalculator.X()), s.segmentSize())
+	if s.id == dccp.CCID4 {
+		s.senderStrober.SetMinInterval(MinPacketInterval)
+	}
 	s.open = true
 }
 
+// SetMPS implements dccp.MPSSender. A path MTU that shrinks below FixedSegmentSize shrinks the
+// segment size of the sender along with it, so that the allowed rate in packets keeps up with
+// the allowed rate in bytes.
+func (s *sender) SetMPS(mps int32) {
+	s.Lock()
+	defer s.Unlock()
+	s.mps = mps
+	if s.open {
+		s.senderSegmentSize.SetMPS(s.mpsSegmentSize())
+	}
+}
+
+// mpsSegmentSize returns the segment size that the Maximum Packet Size of the connection allows
+func (s *sender) mpsSegmentSize() int {
+	if s.mps > 0 && s.mps < FixedSegmentSize {
+		return int(s.mps)
+	}
+	return FixedSegmentSize
+}
+
+// segmentSize returns the current segment size of the sender
+func (s *sender) segmentSize() uint32 { return uint32(s.senderSegmentSize.SS()) }
+
 // Conn calls OnWrite before a packet is sent to give CongestionControl
 // an opportunity to add CCVal and options to an outgoing packet
 // If the CC is not active, OnWrite should return 0, nil.
@@ -80,6 +129,7 @@ func (s *sender) OnWrite(ph *dccp.PreHeader) (ccval int8, options []*dccp.Option
 	}
 
 	s.senderNoFeedbackTimer.OnWrite(ph)
+	s.senderPacketSize.OnWrite(ph)
 
 	s.senderRoundtripEstimator.OnWrite(ph.SeqNo, ph.TimeWrite)
 	rtt, _ := s.senderRoundtripEstimator.RTT()
@@ -112,7 +162,9 @@ func (s *sender) OnRead(fb *dccp.FeedbackHeader) error {
 	}
 
 	// Update the round-trip estimate
-	s.senderRoundtripEstimator.OnRead(fb)
+	if s.senderRoundtripEstimator.OnRead(fb) {
+		s.senderOscillationReducer.OnSample(s.senderRoundtripEstimator.Sample())
+	}
 	rtt, rttEstimated := s.senderRoundtripEstimator.RTT()
 
 	// Update the nofeedback timeout interval and reset the timer
@@ -121,32 +173,41 @@ func (s *sender) OnRead(fb *dccp.FeedbackHeader) error {
 	// Window counter update
 	s.senderWindowCounter.OnRead(fb.AckNo)
 
-	// Update loss estimates
-	lossFeedback, err := s.senderLossTracker.OnRead(fb)
+	xrecv, err := readReceiveRate(fb)
 	if err != nil {
+		s.amb.E(dccp.EventWarn, "Feedback packet with corrupt receive rate option", fb)
 		return nil
 	}
 
-	// Update allowed sending rate
-	xrecv, err := readReceiveRate(fb)
+	// Update loss estimates
+	lossFeedback, err := s.senderLossTracker.OnRead(fb, xrecv, s.eqSegmentSize(), rtt)
 	if err != nil {
-		s.amb.E(dccp.EventWarn, "Feedback packet with corrupt receive rate option", fb)
 		return nil
 	}
+	s.lossEvents += int64(lossFeedback.NewLossCount)
+
+	// Update allowed sending rate
 	xf := &XFeedback{
 		Now:          fb.Time,
-		SS:           FixedSegmentSize,
+		SS:           s.eqSegmentSize(),
 		XRecv:        xrecv,
 		RTT:          rtt,
 		LossFeedback: lossFeedback,
+		DataLimited:  s.senderStrober.LastWait() < fb.Time - rtt,
 	}
 	x := s.senderRateCalculator.OnRead(xf)
+	s.senderNoFeedbackTimer.SetRate(x, s.segmentSize())
+	// The instantaneous rate of RFC 5348, Section 4.5, spaces the packets, while x itself
+	// remains unchanged
+	if s.reduceOscillations {
+		x = s.senderOscillationReducer.Rate(x)
+	}
 	// Flag "FixRate", if present, enforces a fixed send rate given in packets per second
 	flagFixRate, flagFixRatePresent := s.amb.Flags().GetUint32("FixRate")
 	if flagFixRatePresent {
 		s.senderStrober.SetRatePPS(flagFixRate)
 	} else {
-		s.senderStrober.SetRate(x, FixedSegmentSize)
+		s.senderStrober.SetRate(s.allowedRate(x), s.segmentSize())
 	}
 
 	return nil
@@ -197,12 +258,13 @@ func (s *sender) OnIdle(now int64) error {
 		_, hasRTT := s.senderRoundtripEstimator.RTT()
 
 		x := s.senderRateCalculator.OnNoFeedback(now, hasRTT, idleSince, nofeedbackSet)
+		s.senderNoFeedbackTimer.SetRate(x, s.segmentSize())
 		// Flag "FixRate" described above
 		flagFixRate, flagFixRatePresent := s.amb.Flags().GetUint32("FixRate")
 		if flagFixRatePresent {
 			s.senderStrober.SetRatePPS(flagFixRate)
 		} else {
-			s.senderStrober.SetRate(x, FixedSegmentSize)
+			s.senderStrober.SetRate(s.allowedRate(x), s.segmentSize())
 		}
 
 		s.senderNoFeedbackTimer.Reset(now)
@@ -16,6 +16,9 @@ type senderLossTracker struct {
 	amb *dccp.Amb
 	lastAckNo   int64  // SeqNo of the last ack'd segment; equals the AckNo of the last feedback
 	lastRateInv uint32 // Last known value of loss event rate inverse
+	firstStart  int64  // StartSeqNo of the first loss interval, or zero before the first loss event
+	firstLen    uint32 // Length of the first loss interval, as seeded from the receive rate
+	verifier    lossIntervalsVerifier
 	lossRateCalculator
 }
 
@@ -24,11 +27,35 @@ func (t *senderLossTracker) Init(amb *dccp.Amb) {
 	t.amb = amb.Refine("senderLossTracker")
 	t.lastAckNo = 0
 	t.lastRateInv = UnknownLossEventRateInv
+	t.firstStart = 0
+	t.firstLen = 0
+	t.verifier.Init()
 	t.lossRateCalculator.Init(NINTERVAL)
 }
 
-// calcRateInv computes the loss event rate inverse encoded in the loss intervals
-func (t *senderLossTracker) calcRateInv(details []*LossIntervalDetail) uint32 {
+// calcRateInv computes the loss event rate inverse encoded in the loss intervals.
+//
+// The first loss interval spans the slow-start phase, where few packets are sent, and would
+// grossly overestimate the loss event rate. Instead, when the first loss event is reported, the
+// first interval is set to the inverse of the loss event rate at which the throughput equation
+// yields the receive rate xrecv, RFC 5348, Section 6.3.1.
+func (t *senderLossTracker) calcRateInv(details []*LossIntervalDetail, xrecv, ss uint32, rtt int64) uint32 {
+	if t.firstStart == 0 {
+		if t.lossRateCalculator.CalcLossEventRateInv(details) == UnknownLossEventRateInv {
+			return UnknownLossEventRateInv
+		}
+		t.firstStart = details[len(details)-1].StartSeqNo
+		t.firstLen = lossRateInvForRate(xrecv, ss, rtt)
+		t.amb.E(dccp.EventInfo, fmt.Sprintf("First loss interval seeded with %d", t.firstLen))
+	}
+	for i, d := range details {
+		if d.StartSeqNo != t.firstStart || t.firstLen <= d.LossLength {
+			continue
+		}
+		seeded := *d
+		seeded.LosslessLength = t.firstLen - d.LossLength
+		details[i] = &seeded
+	}
 	return t.lossRateCalculator.CalcLossEventRateInv(details)
 }
 
@@ -39,13 +66,16 @@ type LossFeedback struct {
 	RateInc      bool   // Has the loss rate increased since the last feedback packet
 }
 
-// Sender calls OnRead whenever a new feedback packet arrives
-func (t *senderLossTracker) OnRead(fb *dccp.FeedbackHeader) (LossFeedback, error) {
+// Sender calls OnRead whenever a new feedback packet arrives. The receive rate xrecv reported
+// by the same packet, the segment size ss and the round-trip time rtt are used to seed the
+// first loss interval.
+func (t *senderLossTracker) OnRead(fb *dccp.FeedbackHeader, xrecv, ss uint32, rtt int64) (LossFeedback, error) {
 
 	// Read the loss options
 	if fb.Type != dccp.Ack && fb.Type != dccp.DataAck {
 		return LossFeedback{}, ErrNoAck
 	}
+	t.verifier.OnRead(fb)
 	var lossIntervals *LossIntervalsOption
 	t.amb.E(dccp.EventInfo, fmt.Sprintf("Encoded option count = %d", len(fb.Options)), fb)
 	for i, opt := range fb.Options {
@@ -62,13 +92,24 @@ func (t *senderLossTracker) OnRead(fb *dccp.FeedbackHeader) (LossFeedback, error
 	// Calcuate new loss count
 	var r LossFeedback
 	details := recoverIntervalDetails(fb.AckNo, lossIntervals.SkipLength, lossIntervals.LossIntervals)
+	if err := t.verifier.Verify(details); err != nil {
+		t.amb.E(dccp.EventWarn, "Loss intervals contradict ack vector", fb)
+		return LossFeedback{}, err
+	}
 	r.NewLossCount = calcNewLossCount(details, t.lastAckNo)
 
 	// Calculate new rate inverse
-	rateInv := t.calcRateInv(details)
+	rateInv := t.calcRateInv(details, xrecv, ss, rtt)
+	for _, opt := range fb.Options {
+		if reported := DecodeLossEventRateOption(opt); reported != nil {
+			rateInv = t.crossCheck(rateInv, reported.RateInv, details)
+			break
+		}
+	}
 	r.RateInv = rateInv
 	if rateInv < t.lastRateInv {
 		r.RateInc = true
+		t.amb.E(dccp.EventInfo, "Loss event rate up")
 	}
 	t.lastRateInv = rateInv
 	t.amb.E(dccp.EventMatch, fmt.Sprintf("Loss rate inv = %0.4g", 1 / float64(rateInv)))
@@ -79,6 +120,26 @@ func (t *senderLossTracker) OnRead(fb *dccp.FeedbackHeader) (LossFeedback, error
 	return r, nil
 }
 
+// crossCheck compares the loss event rate inverse computed from the loss intervals with the one
+// reported by the receiver in a Loss Event Rate option, RFC 4342, Section 8.5, and returns the
+// more conservative of the two. The receiver does not seed the first loss interval from the
+// receive rate, so its report is ignored while the first interval takes part in the calculation.
+func (t *senderLossTracker) crossCheck(rateInv, reportedInv uint32, details []*LossIntervalDetail) uint32 {
+	if reportedInv >= rateInv {
+		return rateInv
+	}
+	for i, d := range details {
+		if i > NINTERVAL {
+			break
+		}
+		if d.StartSeqNo == t.firstStart {
+			return rateInv
+		}
+	}
+	t.amb.E(dccp.EventWarn, fmt.Sprintf("Receiver reports loss rate inv %d, intervals give %d", reportedInv, rateInv))
+	return reportedInv
+}
+
 // recoverIntervalDetails returns a slice containing the estimated details of the loss intervals
 func recoverIntervalDetails(ackno int64, skip byte, lis []*LossInterval) []*LossIntervalDetail {
 	r := make([]*LossIntervalDetail, len(lis))
@@ -0,0 +1,40 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid3
+
+import (
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+type nullTraceWriter struct{}
+
+func (nullTraceWriter) Write(*dccp.Trace) {}
+func (nullTraceWriter) Sync() error       { return nil }
+func (nullTraceWriter) Close() error      { return nil }
+
+func TestLossEventRateCrossCheck(t *testing.T) {
+	var s senderLossTracker
+	s.Init(dccp.NewAmb("test", dccp.NewEnv(nullTraceWriter{})))
+	details := recoverIntervalDetails(1000, 0, []*LossInterval{
+		&LossInterval{LossLength: 1, LosslessLength: 99},
+		&LossInterval{LossLength: 1, LosslessLength: 899},
+	})
+	s.firstStart = details[1].StartSeqNo
+
+	// The receiver's report is not trusted while the first interval counts
+	if inv := s.crossCheck(200, 100, details); inv != 200 {
+		t.Errorf("first interval: expecting 200, encountered %d", inv)
+	}
+	// Once the first interval has left the history, the more conservative value wins
+	s.firstStart = -1
+	if inv := s.crossCheck(200, 100, details); inv != 100 {
+		t.Errorf("higher reported loss: expecting 100, encountered %d", inv)
+	}
+	if inv := s.crossCheck(200, 300, details); inv != 200 {
+		t.Errorf("lower reported loss: expecting 200, encountered %d", inv)
+	}
+}
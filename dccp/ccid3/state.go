@@ -0,0 +1,101 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid3
+
+import (
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// SenderState is a snapshot of the internals of a CCID3 (or CCID4) sender, in the terms of
+// RFC 5348, see dccp.StateReporter
+type SenderState struct {
+	Open             bool   // Whether the CC is active; the other fields are zero before it is
+	X                uint32 // Allowed sending rate, in bytes per second
+	XRecv            uint32 // Highest rate in X_recv_set, in bytes per second, or X_RECV_MAX if unknown
+	RecvLimit        uint32 // Limit that the receive rate puts on X, in bytes per second
+	LossEventRateInv uint32 // Inverse of the loss event rate p, or UnknownLossEventRateInv
+	RTT              int64  // Round-trip time estimate in ns, or dccp.RoundtripDefault before one
+	SS               uint32 // Segment size used in the throughput equation, in bytes
+	HasFeedback      bool   // Whether any feedback has arrived from the receiver
+	LossEvents       int64  // Loss events reported by the receiver
+}
+
+// P returns the loss event rate p, or zero if no loss events have been reported
+func (s SenderState) P() float64 {
+	return lossEventRate(s.LossEventRateInv)
+}
+
+// ReceiverState is a snapshot of the internals of a CCID3 (or CCID4) receiver, see
+// dccp.StateReporter
+type ReceiverState struct {
+	Open             bool   // Whether the CC is active
+	RTT              int64  // RTT reported by the sender in ns, or dccp.RoundtripDefault if stale
+	LossEventRateInv uint32 // Inverse of the loss event rate last sent to the sender
+	DataSinceAck     bool   // Whether data packets have been received since the last Ack
+}
+
+// P returns the loss event rate p last sent to the sender, or zero if there was no loss
+func (r ReceiverState) P() float64 {
+	return lossEventRate(r.LossEventRateInv)
+}
+
+func lossEventRate(rateInv uint32) float64 {
+	if rateInv == 0 || rateInv == UnknownLossEventRateInv {
+		return 0
+	}
+	return 1 / float64(rateInv)
+}
+
+// CCIDState implements dccp.StateReporter
+func (s *sender) CCIDState() interface{} {
+	s.Lock()
+	defer s.Unlock()
+	if !s.open {
+		return SenderState{}
+	}
+	rtt, _ := s.senderRoundtripEstimator.RTT()
+	return SenderState{
+		Open:             true,
+		X:                s.senderRateCalculator.X(),
+		XRecv:            s.senderRateCalculator.xRecvSet.Max(),
+		RecvLimit:        s.senderRateCalculator.recvLimit,
+		LossEventRateInv: s.senderRateCalculator.lossRateInv,
+		RTT:              rtt,
+		SS:               s.segmentSize(),
+		HasFeedback:      s.senderRateCalculator.hasFeedback,
+		LossEvents:       s.lossEvents,
+	}
+}
+
+// CCIDState implements dccp.StateReporter
+func (r *receiver) CCIDState() interface{} {
+	r.Lock()
+	defer r.Unlock()
+	if !r.open {
+		return ReceiverState{}
+	}
+	rtt, _ := r.receiverRoundtripEstimator.RTT(r.env.Now().UnixNano())
+	return ReceiverState{
+		Open:             true,
+		RTT:              rtt,
+		LossEventRateInv: r.lastLossEventRateInv,
+		DataSinceAck:     r.dataSinceAck,
+	}
+}
+
+// GetSenderState returns the state of the sender CCID of c, and false if it is not CCID3 or CCID4
+func GetSenderState(c *dccp.Conn) (SenderState, bool) {
+	s, _ := c.CCIDState()
+	state, ok := s.(SenderState)
+	return state, ok
+}
+
+// GetReceiverState returns the state of the receiver CCID of c, and false if it is not CCID3 or
+// CCID4
+func GetReceiverState(c *dccp.Conn) (ReceiverState, bool) {
+	_, r := c.CCIDState()
+	state, ok := r.(ReceiverState)
+	return state, ok
+}
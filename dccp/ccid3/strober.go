@@ -6,6 +6,7 @@ package ccid3
 
 import (
 	"fmt"
+	"time"
 	"github.com/petar/GoDCCP/dccp"
 )
 
@@ -15,8 +16,10 @@ type senderStrober struct {
 	env *dccp.Env
 	amb *dccp.Amb
 	dccp.Mutex
-	interval int64		// Maximum average time interval between packets, in nanoseconds
-	last     int64
+	interval    int64		// Maximum average time interval between packets, in nanoseconds
+	minInterval int64		// Lower bound on interval, or zero for none
+	last        int64
+	lastWait    int64		// Time of the last Strobe that had to wait, or zero if none
 }
 
 // BytesPerSecondToPacketsPer64Sec converts a rate in byter per second to
@@ -29,9 +32,19 @@ func BytesPerSecondToPacketsPer64Sec(bps uint32, ss uint32) int64 {
 func (s *senderStrober) Init(env *dccp.Env, amb *dccp.Amb, bps uint32, ss uint32) {
 	s.env = env
 	s.amb = amb.Refine("strober")
+	s.minInterval = 0
+	s.lastWait = 0
 	s.SetRate(bps, ss)
 }
 
+// SetMinInterval sets a lower bound on the time interval between two strobes in nanoseconds.
+// It takes effect with the next call to SetRate or SetRatePPS.
+func (s *senderStrober) SetMinInterval(minInterval int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.minInterval = minInterval
+}
+
 // SetWait sets the strobing rate by setting the time interval between two strobes in nanoseconds
 func (s *senderStrober) SetInterval(interval int64) {
 	s.Lock()
@@ -48,10 +61,15 @@ func (s *senderStrober) SetInterval(interval int64) {
 func (s *senderStrober) SetRate(bps uint32, ss uint32) {
 	s.Lock()
 	defer s.Unlock()
-	s.interval = 64e9 / BytesPerSecondToPacketsPer64Sec(bps, ss)
+	pp64 := BytesPerSecondToPacketsPer64Sec(bps, ss)
+	if pp64 < 1 {
+		pp64 = 1
+	}
+	s.interval = 64e9 / pp64
 	if s.interval == 0 {
 		panic("strobe rate infinity")
 	}
+	s.interval = max64(s.interval, s.minInterval)
 	// This is high frequency. Consider calling it only when rate changes.
 	// s.amb.E(dccp.EventInfo, fmt.Sprintf("Set strobe rate %d pps", 1e9 / s.interval))
 }
@@ -62,7 +80,7 @@ func (s *senderStrober) SetRatePPS(pps uint32) {
 	if pps == 0 {
 		panic("strobe rate zero pps")
 	}
-	s.interval = 1e9 / int64(pps)
+	s.interval = max64(1e9 / int64(pps), s.minInterval)
 	// This is high frequency. Consider calling it only when rate changes.
 	// s.amb.E(dccp.EventInfo, fmt.Sprintf("Set strobe rate %d pps", 1e9 / s.interval))
 }
@@ -77,15 +95,26 @@ func (s *senderStrober) SetRatePPS(pps uint32) {
 // XXX: This routine should be optimized
 func (s *senderStrober) Strobe() {
 	s.Lock()
-	now := s.env.Now()
+	now := s.env.Now().UnixNano()
 	delta := s.interval - (now - s.last)
 	_interval := s.interval
 	s.Unlock()
 	defer s.amb.E(dccp.EventInfo, fmt.Sprintf("Strobe at %d pps", 1e9 / _interval), nil)
 	if delta > 0 {
-		s.env.Sleep(delta)
+		s.Lock()
+		s.lastWait = now
+		s.Unlock()
+		s.env.Sleep(time.Duration(delta))
 	}
 	s.Lock()
-	s.last = s.env.Now()
+	s.last = s.env.Now().UnixNano()
 	s.Unlock()
 }
+
+// LastWait returns the time of the last call to Strobe that was delayed by the rate limit, or
+// zero if there has been none. Past that time, the sender has been data-limited.
+func (s *senderStrober) LastWait() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.lastWait
+}
@@ -0,0 +1,26 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid3
+
+import (
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// TestStroberMinRate checks that a rate below one packet per 64 seconds, as when the packets
+// are larger than the bytes allowed in 64 seconds, strobes once per 64 seconds, RFC 4342
+func TestStroberMinRate(t *testing.T) {
+	env := dccp.NewEnv(nullTraceWriter{})
+	var s senderStrober
+	s.Init(env, dccp.NewAmb("test", env), 1, 1500)
+	if s.interval != 64e9 {
+		t.Errorf("interval %d ns, expecting %d", s.interval, int64(64e9))
+	}
+	s.SetRate(100, 1500)
+	if s.interval != 64e9/4 {
+		t.Errorf("interval %d ns, expecting %d", s.interval, int64(64e9/4))
+	}
+}
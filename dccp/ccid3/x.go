@@ -25,6 +25,12 @@ type senderRateCalculator struct {
 	rtt         int64  // Last known value of round-trip time estimate
 
 	xRecvSet           // Data structure for x_recv_set (see RFC 5348)
+
+	// The following fields implement the experimental TFRC Faster Restart of
+	// draft-ietf-dccp-tfrc-faster-restart. They are only used if fasterRestart is set.
+	fasterRestart bool   // True if Faster Restart is enabled
+	xActiveRecv   uint32 // Receive rate reported for the last interval that was not data-limited
+	tActive       int64  // Time when xActiveRecv was last updated, or zero if unset
 }
 
 const (
@@ -32,6 +38,7 @@ const (
 	X_MAX_BACKOFF_INTERVAL  = 64e9           // Maximum backoff interval in ns (See RFC 5348, Section 4.3)
 	X_RECV_MAX              = math.MaxInt32  // Maximum receive rate, in bytes per second
 	X_RECV_SET_SIZE         = 3              // Size of x_recv_set
+	X_ACTIVE_RECV_LIFETIME  = 30e9           // How long xActiveRecv is remembered, in ns
 )
 
 // Init resets the rate calculator for new use and returns the initial 
@@ -57,6 +64,15 @@ func (t *senderRateCalculator) Init(amb *dccp.Amb, ss uint32, rtt int64) {
 	t.ss = ss
 	t.rtt = rtt
 	t.xRecvSet.Init()
+	t.xActiveRecv = 0
+	t.tActive = 0
+}
+
+// SetFasterRestart enables or disables Faster Restart. When enabled, a sender that resumes after
+// an idle or data-limited period quadruples its receive limit every round-trip time, instead of
+// doubling it, until it reaches the receive rate it had before the pause.
+func (t *senderRateCalculator) SetFasterRestart(enabled bool) {
+	t.fasterRestart = enabled
 }
 
 // X returns the allowed sending rate in bytes per second
@@ -66,8 +82,10 @@ func (t *senderRateCalculator) X() uint32 { return t.x }
 func (t *senderRateCalculator) onFirstRead(now int64) uint32 {
 	t.tld = now
 	t.x = initRate(t.ss, t.rtt)
+	// The nofeedback timer does not halve the rate below the initial rate, unless
+	// losses have been observed, RFC 5348, Section 4.4
+	t.recoverRate = t.x
 	t.amb.E(dccp.EventInfo, fmt.Sprintf("Init rate = %d bps", t.x))
-	// XXX panic("a")
 	return t.x
 }
 
@@ -88,6 +106,10 @@ type XFeedback struct {
 	XRecv uint32  // Receive rate
 	RTT   int64   // Round-trip time
 	LossFeedback  // Loss-related feedback
+
+	// DataLimited is set if the sender was data-limited throughout the interval covered by
+	// the feedback packet, RFC 5348, Section 8.2
+	DataLimited bool
 }
 
 // Sender calls OnRead each time a new feedback packet (i.e. Ack or DataAck) arrives.
@@ -104,20 +126,25 @@ func (t *senderRateCalculator) OnRead(f *XFeedback) uint32 {
 	if t.tld <= 0 {
 		return t.onFirstRead(now)
 	}
-	// TODO: We currently don't honor data-limited periods
-	if false /* the entire interval covered by the feedback packet was a data-limited interval */ {
+	// During data-limited intervals, X_recv reflects the application's rate rather than the
+	// path's, so it may only raise the receive limit, RFC 5348, Section 4.3, Step 4
+	if f.DataLimited {
 		if f.LossFeedback.RateInc || f.LossFeedback.NewLossCount > 0 {
 			t.xRecvSet.Halve()
 			f.XRecv = (85 * f.XRecv) / 100
 			t.xRecvSet.Maximize(now, f.XRecv)
 			t.recvLimit = t.xRecvSet.Max()
+			// Faster Restart does not resume a rate at which losses occur
+			t.xActiveRecv = 0
 		} else {
 			t.xRecvSet.Maximize(now, f.XRecv)
 			t.recvLimit = 2 * t.xRecvSet.Max()
+			t.recvLimit = maxu32(t.recvLimit, t.restartLimit(now))
 		}
 	} else {
 		t.xRecvSet.Update(now, f.XRecv, t.rtt)
 		t.recvLimit = 2 * t.xRecvSet.Max()
+		t.xActiveRecv, t.tActive = f.XRecv, now
 	}
 	return t.recalculate(now)
 }
@@ -132,7 +159,6 @@ func (t *senderRateCalculator) recalculate(now int64) uint32 {
 		t.x = maxu32(minu32(2*t.x, t.recvLimit), initRate(t.ss, t.rtt))
 		t.tld = now
 	}
-	// TODO: Place oscillation reduction code here (see RFC 5348, Section 4.3)
 	return t.x
 }
 
@@ -156,18 +182,42 @@ func (t *senderRateCalculator) OnNoFeedback(now int64, hasRTT bool, idleSince in
 		// We do not have X_Bps yet.
 		// Halve the allowed sending rate.
 		t.x = maxu32(t.x/2, minRate(t.ss));
-	} else if t.x > 2*xRecv {
+	} else if xBps := t.thruEq(); xBps > 2*xRecv {
 		// 2*X_recv was already limiting the sending rate.
 		// Halve the allowed sending rate.
-		t.updateLimits(now, xRecv)
+		t.updateLimits(now, t.idleLimit(xRecv, idleSince, nofeedbackSet))
 	} else {
 		// The sending rate was limited by X_Bps, not by X_recv.
 		// Halve the allowed sending rate.
-		t.updateLimits(now, t.x/2)
+		t.updateLimits(now, t.idleLimit(xBps/2, idleSince, nofeedbackSet))
 	}
 	return t.x
 }
 
+// idleLimit bounds the timer limit from below by recover_rate if the sender has been idle
+// since the nofeedback timer was set. Feedback stops arriving when the application stops
+// sending, and the allowed sending rate must not decay to nothing during the silence, while
+// it is still bounded by the recover rate when the application resumes, RFC 5348, Section 4.4.
+func (t *senderRateCalculator) idleLimit(timerLimit uint32, idleSince int64, nofeedbackSet int64) uint32 {
+	if idleSince > nofeedbackSet {
+		return timerLimit
+	}
+	return maxu32(timerLimit, t.recoverRate)
+}
+
+// restartLimit returns the receive limit allowed by Faster Restart, or zero if Faster Restart
+// does not apply
+func (t *senderRateCalculator) restartLimit(now int64) uint32 {
+	if !t.fasterRestart || t.tActive <= 0 || now - t.tActive > X_ACTIVE_RECV_LIFETIME {
+		return 0
+	}
+	xRecv := t.xRecvSet.Max()
+	if xRecv >= X_RECV_MAX/4 {
+		return t.xActiveRecv
+	}
+	return minu32(4*xRecv, t.xActiveRecv)
+}
+
 // See RFC 5348, Section 4.4
 func (t *senderRateCalculator) updateLimits(now int64, timerLimit uint32) uint32 {
 	xMin := minRate(t.ss)
@@ -187,11 +237,42 @@ func minRate(ss uint32) uint32 {
 
 // thruEq returns the allowed sending rate, in bytes per second, according to the TCP
 // throughput equation, for the regime b=1 and t_RTO=4*RTT (See RFC 5348, Section 3.1).
+// Loss event rates covered by qTable are looked up, while smaller ones are computed directly.
 func (t *senderRateCalculator) thruEq() uint32 {
+	if int(t.lossRateInv) > len(qTable) {
+		return thruEqExact(t.ss, t.rtt, t.lossRateInv)
+	}
 	bps := (1e3*1e9*int64(t.ss)) / (t.rtt * thruEqQ(t.lossRateInv))
 	return uint32(bps)
 }
 
+// thruEqExact evaluates the throughput equation in floating point, in bytes per second
+func thruEqExact(ss uint32, rtt int64, lossRateInv uint32) uint32 {
+	p := 1 / float64(lossRateInv)
+	q := math.Sqrt(2*p/3) + 12*math.Sqrt(3*p/8)*p*(1+32*p*p)
+	bps := 1e9 * float64(ss) / (float64(rtt) * q)
+	if bps >= X_RECV_MAX {
+		return X_RECV_MAX
+	}
+	return uint32(bps)
+}
+
+// lossRateInvForRate inverts the throughput equation. It returns the smallest loss event rate
+// inverse for which the equation allows a sending rate of at least x bytes per second. It is used
+// to seed the first loss interval, RFC 5348, Section 6.3.1.
+func lossRateInvForRate(x uint32, ss uint32, rtt int64) uint32 {
+	var lo, hi uint32 = 1, UnknownLossEventRateInv - 1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if thruEqExact(ss, rtt, mid) >= x {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo
+}
+
 // thruEqDenom computes the quantity 1e3*(sqrt(2*p/3) + 12*sqrt(3*p/8)*p*(1+32*p^2)).
 func thruEqQ(lossRateInv uint32) int64 {
 	j := min(int(lossRateInv), len(qTable))
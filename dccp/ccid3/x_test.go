@@ -0,0 +1,63 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package ccid3
+
+import (
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+func TestLossRateInvForRate(t *testing.T) {
+	const rtt = 100e6
+	for _, inv := range []uint32{2, 10, 100, 1000, 100000} {
+		x := thruEqExact(FixedSegmentSize, rtt, inv)
+		got := lossRateInvForRate(x, FixedSegmentSize, rtt)
+		if got > inv || thruEqExact(FixedSegmentSize, rtt, got) < x {
+			t.Errorf("rate %d: expecting inverse at most %d, encountered %d", x, inv, got)
+		}
+	}
+}
+
+func TestDataLimitedFeedback(t *testing.T) {
+	const rtt = 100e6
+	var c senderRateCalculator
+	c.Init(dccp.NewAmb("test", dccp.NewEnv(nullTraceWriter{})), FixedSegmentSize, rtt)
+	noLoss := LossFeedback{RateInv: UnknownLossEventRateInv}
+	c.OnRead(&XFeedback{Now: 1e9, SS: FixedSegmentSize, XRecv: 0, RTT: rtt, LossFeedback: noLoss})
+	c.OnRead(&XFeedback{Now: 1e9 + rtt, SS: FixedSegmentSize, XRecv: 100000, RTT: rtt, LossFeedback: noLoss})
+
+	// A pause in the application must not lower the receive limit
+	c.OnRead(&XFeedback{Now: 1e9 + 5*rtt, SS: FixedSegmentSize, XRecv: 1000, RTT: rtt, LossFeedback: noLoss, DataLimited: true})
+	if c.recvLimit != 2*100000 {
+		t.Errorf("data-limited: expecting receive limit %d, encountered %d", 2*100000, c.recvLimit)
+	}
+	// Once the path has been probed for two round-trip times, the low receive rate counts
+	c.OnRead(&XFeedback{Now: 1e9 + 8*rtt, SS: FixedSegmentSize, XRecv: 1000, RTT: rtt, LossFeedback: noLoss})
+	if c.recvLimit != 2*1000 {
+		t.Errorf("rate-limited: expecting receive limit %d, encountered %d", 2*1000, c.recvLimit)
+	}
+}
+
+func TestFasterRestart(t *testing.T) {
+	const rtt = 100e6
+	var c senderRateCalculator
+	c.Init(dccp.NewAmb("test", dccp.NewEnv(nullTraceWriter{})), FixedSegmentSize, rtt)
+	c.SetFasterRestart(true)
+	noLoss := LossFeedback{RateInv: UnknownLossEventRateInv}
+	c.OnRead(&XFeedback{Now: 1e9, SS: FixedSegmentSize, XRecv: 0, RTT: rtt, LossFeedback: noLoss})
+	c.OnRead(&XFeedback{Now: 1e9 + rtt, SS: FixedSegmentSize, XRecv: 100000, RTT: rtt, LossFeedback: noLoss})
+
+	// After a long pause, the receive limit grows fourfold per feedback, up to the active rate
+	now := int64(10e9)
+	c.xRecvSet.Reduce(now, 1000)
+	for _, expect := range []uint32{4000, 16000, 64000, 100000} {
+		c.OnRead(&XFeedback{Now: now, SS: FixedSegmentSize, XRecv: c.recvLimit, RTT: rtt, LossFeedback: noLoss, DataLimited: true})
+		if c.recvLimit < expect {
+			t.Errorf("expecting receive limit of at least %d, encountered %d", expect, c.recvLimit)
+		}
+		now += rtt
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"sync"
+)
+
+// ccidRegistry holds the CCID factories registered with RegisterCCID
+var ccidRegistry struct {
+	sync.Mutex
+	ccids map[byte]CCID
+}
+
+// RegisterCCID makes the congestion control ccid available under the identifier id. Once
+// registered, id is acceptable during the negotiation of the CCID feature, Section 10, and
+// LookupCCID returns ccid. Congestion control packages usually register themselves from an init
+// function, so that importing them suffices. Experimental congestion controls should use
+// identifiers outside of the ones assigned by IANA.
+//
+// A registered CCID exchanges CCID-specific options with its peer through the Options fields
+// of PreHeader, FeedbackHeader and FeedforwardHeader: the sender CCID receives options 192 to
+// 255 and the receiver CCID receives options 128 to 191, Section 10.3. Either can reset the
+// connection with a CCID-specific Reset Code by returning a CongestionReset, and learns about
+// CCID-specific Reset Codes sent by the peer by implementing ResetReader.
+//
+// RegisterCCID panics if ccid is nil, if id is reserved, or if id is already registered.
+func RegisterCCID(id byte, ccid CCID) {
+	if ccid == nil {
+		panic("registering nil ccid")
+	}
+	// CCIDs 0, 1 and 255 are reserved, Section 10
+	if id == 0 || id == 1 || id == 255 {
+		panic("registering reserved ccid")
+	}
+	ccidRegistry.Lock()
+	defer ccidRegistry.Unlock()
+	if ccidRegistry.ccids == nil {
+		ccidRegistry.ccids = make(map[byte]CCID)
+	}
+	if _, dup := ccidRegistry.ccids[id]; dup {
+		panic("registering ccid twice")
+	}
+	ccidRegistry.ccids[id] = ccid
+}
+
+// LookupCCID returns the congestion control registered under id, or nil if there is none
+func LookupCCID(id byte) CCID {
+	ccidRegistry.Lock()
+	defer ccidRegistry.Unlock()
+	return ccidRegistry.ccids[id]
+}
+
+// unregisterCCID removes the congestion control registered under id, if any, for tests that
+// register CCIDs of their own
+func unregisterCCID(id byte) {
+	ccidRegistry.Lock()
+	defer ccidRegistry.Unlock()
+	delete(ccidRegistry.ccids, id)
+}
+
+// ResetReader is optionally implemented by sender and receiver CCIDs that want to learn about
+// CCID-specific Reset Codes received from the other endpoint. Reset Codes 128 to 191, sent by
+// the other HC-Sender, are passed to the receiver CCID, while Reset Codes 192 to 255, sent by
+// the other HC-Receiver, are passed to the sender CCID, Section 10.3.
+type ResetReader interface {
+	OnReset(resetCode byte, resetData []byte)
+}
+
+// readCCIDReset passes a received CCID-specific Reset Code to the CCID it concerns
+func (c *Conn) readCCIDReset(h *Header) {
+	var cc interface{}
+	switch {
+	case h.ResetCode >= 192:
+		cc = c.scc
+	case h.ResetCode >= 128:
+		cc = c.rcc
+	default:
+		return
+	}
+	if rr, ok := cc.(ResetReader); ok {
+		rr.OnReset(h.ResetCode, h.ResetData)
+	}
+}
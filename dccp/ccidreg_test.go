@@ -0,0 +1,37 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"testing"
+)
+
+type testCCID struct{}
+
+func (testCCID) NewSender(env *Env, amb *Amb) SenderCongestionControl {
+	return newFixedRateSenderControl(env, 1e9)
+}
+
+func (testCCID) NewReceiver(env *Env, amb *Amb) ReceiverCongestionControl {
+	return newFixedRateReceiverControl(env)
+}
+
+func TestRegisterCCID(t *testing.T) {
+	const id = 200
+	if knownCCID(id) {
+		t.Fatalf("unregistered ccid is known")
+	}
+	RegisterCCID(id, testCCID{})
+	t.Cleanup(func() { unregisterCCID(id) })
+	if LookupCCID(id) == nil || !knownCCID(id) {
+		t.Errorf("registered ccid not found")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registered ccid twice")
+		}
+	}()
+	RegisterCCID(id, testCCID{})
+}
@@ -39,11 +39,8 @@ func csumSum(buf []byte) uint16 {
 	for i := 0; i < l16; i++ {
 		sum = csumAdd(sum, csumBytesToUint16(buf[2*i:2*i+2]))
 	}
-	if (l16 << 2) < len(buf) {
-		two := make([]byte, 2)
-		two[0] = buf[len(buf)-1]
-		two[1] = 0
-		sum = csumAdd(sum, csumBytesToUint16(two))
+	if (l16 << 1) < len(buf) {
+		sum = csumAdd(sum, uint16(buf[len(buf)-1])<<8)
 	}
 	return sum
 }
@@ -69,7 +66,7 @@ func csumPseudoIPv4(sourceIP, destIP []byte, protoNo byte, dccpLen int) uint16 {
 	if uint(protoNo)>>8 != 0 {
 		panic("proto no")
 	}
-	if uint16(dccpLen)>>16 != 0 {
+	if dccpLen>>16 != 0 {
 		panic("len")
 	}
 	sum := csumSum(sourceIP)
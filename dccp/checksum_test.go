@@ -35,5 +35,8 @@ func TestChecksum(t *testing.T) {
 	if csumDone(csumSum(buf)) != 0 {
 		t.Errorf("csum")
 	}
-
+	buf[len(buf)-1]++
+	if csumDone(csumSum(buf)) == 0 {
+		t.Errorf("csum misses the last odd byte")
+	}
 }
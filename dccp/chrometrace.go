@@ -0,0 +1,183 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ChromeTraceWriter is a TraceWriter that saves traces in the Chrome trace-event format, which
+// chrome://tracing and Perfetto open. Each endpoint, the top label of an Amb like "client", is
+// a process, and each subsystem below it, like "conn" or the "sender" and "receiver" of the
+// two half-connections, is a track of its own. Packets are instant events, named after the
+// event and the packet type, like "Write DataAck", and so are other events. Samples are counter
+// tracks, and the states of a connection are durations on its "state" track.
+type ChromeTraceWriter struct {
+	sync.Mutex
+	dst    io.Writer
+	enc    *json.Encoder
+	n      int               // Number of events written
+	pids   map[string]int    // Process ids of endpoints
+	tids   map[string]int    // Thread ids of tracks, keyed by endpoint and track name
+	states map[string]string // Current state of each endpoint, as a duration that is open
+	last   int64             // Time of the last trace, which ends the open states on Close
+	err    error
+}
+
+// chromeEvent is an event of the Chrome trace-event format
+type chromeEvent struct {
+	Name  string                 `json:"name"`
+	Phase string                 `json:"ph"`
+	Time  float64                `json:"ts"` // In microseconds
+	Pid   int                    `json:"pid"`
+	Tid   int                    `json:"tid"`
+	Scope string                 `json:"s,omitempty"`
+	Cat   string                 `json:"cat,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+// NewChromeTraceWriter creates a ChromeTraceWriter that writes to w. The output is a JSON array
+// that Close terminates. Sync and Close pass on to w, if it has methods of that name.
+func NewChromeTraceWriter(w io.Writer) *ChromeTraceWriter {
+	return &ChromeTraceWriter{
+		dst:    w,
+		enc:    json.NewEncoder(w),
+		pids:   make(map[string]int),
+		tids:   make(map[string]int),
+		states: make(map[string]string),
+	}
+}
+
+// emit writes the event e
+func (t *ChromeTraceWriter) emit(e *chromeEvent) {
+	if t.err != nil {
+		return
+	}
+	sep := ","
+	if t.n == 0 {
+		sep = "["
+	}
+	t.n++
+	if _, t.err = io.WriteString(t.dst, sep); t.err != nil {
+		return
+	}
+	t.err = t.enc.Encode(e)
+}
+
+// pid returns the process id of endpoint, naming the process on first use
+func (t *ChromeTraceWriter) pid(endpoint string) int {
+	pid, ok := t.pids[endpoint]
+	if !ok {
+		pid = len(t.pids) + 1
+		t.pids[endpoint] = pid
+		t.emit(&chromeEvent{Name: "process_name", Phase: "M", Pid: pid, Args: map[string]interface{}{"name": endpoint}})
+	}
+	return pid
+}
+
+// tid returns the thread id of the track of endpoint, naming the thread on first use
+func (t *ChromeTraceWriter) tid(endpoint, track string) (pid, tid int) {
+	pid = t.pid(endpoint)
+	key := endpoint + "\x00" + track
+	tid, ok := t.tids[key]
+	if !ok {
+		tid = len(t.tids) + 1
+		t.tids[key] = tid
+		t.emit(&chromeEvent{Name: "thread_name", Phase: "M", Pid: pid, Tid: tid, Args: map[string]interface{}{"name": track}})
+	}
+	return pid, tid
+}
+
+// Write implements TraceWriter.Write
+func (t *ChromeTraceWriter) Write(r *Trace) {
+	t.Lock()
+	defer t.Unlock()
+	if len(r.Labels) == 0 {
+		return
+	}
+	t.last = r.Time
+	ts := float64(r.Time) / 1e3
+	endpoint, track := r.Labels[0], strings.Join(r.Labels[1:], "/")
+	if track == "" {
+		track = endpoint
+	}
+
+	if r.State != "" && r.State != t.states[endpoint] {
+		pid, tid := t.tid(endpoint, "state")
+		if s, ok := t.states[endpoint]; ok {
+			t.emit(&chromeEvent{Name: s, Phase: "E", Time: ts, Pid: pid, Tid: tid})
+		}
+		t.states[endpoint] = r.State
+		t.emit(&chromeEvent{Name: r.State, Phase: "B", Time: ts, Pid: pid, Tid: tid, Cat: "state"})
+	}
+
+	pid, tid := t.tid(endpoint, track)
+	if s, ok := r.Sample(); ok {
+		args := map[string]interface{}{"value": s.Value}
+		name := s.Series
+		if s.Unit != "" {
+			name += " (" + s.Unit + ")"
+		}
+		t.emit(&chromeEvent{Name: name, Phase: "C", Time: ts, Pid: pid, Tid: tid, Args: args})
+		return
+	}
+	e := &chromeEvent{Name: r.Event.String(), Phase: "i", Time: ts, Pid: pid, Tid: tid, Scope: "t", Cat: r.Event.String()}
+	args := make(map[string]interface{})
+	if r.Type != "" {
+		e.Name += " " + r.Type
+		e.Cat = "packet"
+		args["seqno"], args["ackno"] = r.SeqNo, r.AckNo
+	}
+	if r.Comment != "" {
+		args["comment"] = r.Comment
+	}
+	if len(args) > 0 {
+		e.Args = args
+	}
+	t.emit(e)
+}
+
+// Sync implements TraceWriter.Sync
+func (t *ChromeTraceWriter) Sync() error {
+	t.Lock()
+	defer t.Unlock()
+	if s, ok := t.dst.(interface{ Sync() error }); ok && t.err == nil {
+		t.err = s.Sync()
+	}
+	return t.err
+}
+
+// Close implements TraceWriter.Close. It ends the states that are still open, at the time of
+// the last trace, and terminates the JSON array.
+func (t *ChromeTraceWriter) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	endpoints := make([]string, 0, len(t.states))
+	for endpoint := range t.states {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		pid, tid := t.tid(endpoint, "state")
+		t.emit(&chromeEvent{Name: t.states[endpoint], Phase: "E", Time: float64(t.last) / 1e3, Pid: pid, Tid: tid})
+	}
+	if t.err == nil {
+		end := "]\n"
+		if t.n == 0 {
+			end = "[]\n"
+		}
+		_, t.err = io.WriteString(t.dst, end)
+	}
+	if c, ok := t.dst.(io.Closer); ok {
+		if err := c.Close(); t.err == nil {
+			t.err = err
+		}
+	}
+	return t.err
+}
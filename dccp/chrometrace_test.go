@@ -0,0 +1,69 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestChromeTraceWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewChromeTraceWriter(&buf)
+	w.Write(&Trace{Time: 1000, Labels: []string{"client", "conn"}, State: "REQUEST", Event: EventWrite, Type: "Request", SeqNo: 5})
+	w.Write(&Trace{Time: 3000, Labels: []string{"server", "conn"}, State: "RESPOND", Event: EventRead, Type: "Request", SeqNo: 5})
+	w.Write(&Trace{Time: 5000, Labels: []string{"client", "conn"}, State: "PARTOPEN", Event: EventRead, Type: "Response", SeqNo: 9, AckNo: 5})
+	w.Write(&Trace{Time: 6000, Labels: []string{"client", "sender"}, State: "PARTOPEN", Event: EventInfo, Args: map[string]interface{}{SampleType: Sample{Series: "rate", Value: 2, Unit: "pps"}}})
+	w.Write(&Trace{Time: 7000, Labels: []string{"client", "sender"}, State: "PARTOPEN", Event: EventWarn, Comment: "slow"})
+	if err := w.Close(); err != nil {
+		t.Fatalf("close (%s)", err)
+	}
+
+	var events []chromeEvent
+	if err := json.Unmarshal(buf.Bytes(), &events); err != nil {
+		t.Fatalf("decode (%s)\n%s", err, buf.Bytes())
+	}
+	var names, threads, processes []string
+	var begins, ends int
+	for _, e := range events {
+		switch e.Phase {
+		case "M":
+			if e.Name == "process_name" {
+				processes = append(processes, e.Args["name"].(string))
+			} else {
+				threads = append(threads, e.Args["name"].(string))
+			}
+		case "B":
+			begins++
+		case "E":
+			ends++
+		default:
+			names = append(names, e.Name)
+		}
+	}
+	if len(processes) != 2 || len(threads) != 5 {
+		t.Errorf("processes %v, threads %v", processes, threads)
+	}
+	if begins != 3 || ends != 3 {
+		t.Errorf("%d state durations begin, %d end", begins, ends)
+	}
+	expect := []string{"Write Request", "Read Request", "Read Response", "rate (pps)", "Warn"}
+	if len(names) != len(expect) {
+		t.Fatalf("expecting %v, encountered %v", expect, names)
+	}
+	for i := range expect {
+		if names[i] != expect[i] {
+			t.Errorf("expecting %v, encountered %v", expect, names)
+			break
+		}
+	}
+
+	buf.Reset()
+	NewChromeTraceWriter(&buf).Close()
+	if err := json.Unmarshal(buf.Bytes(), &events); err != nil || len(events) != 0 {
+		t.Errorf("empty trace %q", buf.String())
+	}
+}
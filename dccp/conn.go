@@ -13,17 +13,88 @@ type Conn struct {
 	scc   SenderCongestionControl
 	rcc   ReceiverCongestionControl
 
-	Mutex                       // Protects access to socket, ccidOpen and err
+	in             chan readResult // readLoop passes what it reads to loop
+	calls          chan *connCall // do hands functions to loop
+	dueLk          Mutex
+	due            []func()     // Timers that have fired, waiting for loop to run them
+	dueReady       chan struct{} // Holds a token while due is not empty
+	loopDone       chan struct{} // Closed when loop exits, once the connection is CLOSED
+	exitLk         Mutex        // Serializes the calls of do once loop has exited
+	readRTT        int64        // Copy of the RTT for readLoop, accessed atomically
+
+	// Account for the channels of the connection on virtual time, see Env.Wait
+	inT, callsT, dueT, handshakeT, readAppT, readDoneT, nonDataT, dataOpenT Track
+
+	// The fields below belong to loop, see do
 	socket
+	features       featureSet   // Feature values and negotiation state, Section 6
+	ecn            bool         // True if the HeaderConn carries ECN codepoints
+	serviceCodes   []ServiceCode    // Client: candidate service codes; server: provided service codes, or nil for any
+	requestRetry   RequestRetry // Client: schedule of Request retransmissions, Section 8.1.1
+	respondTimeout int64        // Server: maximum time spent in RESPOND state
+	timewait       int64        // Time spent in TIMEWAIT state
+	backlog        *Backlog     // Server: limit on half-open connections, or nil for none
+	requestFilter  RequestFilter // Server of NewConnServer: decides on Requests received in LISTEN, or nil to accept all
+	halfOpen       bool         // Server: true if this connection holds a place in backlog
+	initCookies    []*Option    // Server: Init Cookie placed on Responses; client: Init Cookies echoed in PARTOPEN
 	ccidOpen       bool         // True if the sender and receiver CCID's have been opened
+	handshake      chan struct{} // Closed when the handshake is over
+	open           bool         // True if the handshake brought the connection to OPEN state
 	err            error        // Reason for connection tear down
-
-	readAppLk      Mutex
-	readApp        chan []byte  // readLoop() sends application data to Read()
-	writeDataLk    Mutex
-	writeData      chan []byte  // Write() sends application data to writeLoop()
-	writeNonDataLk Mutex
+	softErr        error        // Last error that the connection survived, see SoftError
+	mtuHandler     func(mtu int) // Called when GetMTU changes, see SetMTUHandler
+	eventHandler   func(ConnEvent) // Called with the lifecycle events, see OnEvent
+	rateHandler    func(rate int64) // Called when AllowedRate changes, see SetRateHandler
+	rateChange     float64      // Fraction by which AllowedRate changes before rateHandler is called
+	rateReported   int64        // AllowedRate last passed to rateHandler
+	eventState     int          // State last reported to eventHandler
+	mtuOverride    int32        // Path MTU set by SetMTU, or zero
+	mtuProbe       *mtuProber   // State of MTU probing, or nil if it is off
+	keepalive      int64        // Silence after which a keepalive Sync is sent, or zero for none
+	lastWrite      int64        // Time the last packet was written
+	idleTimeout    int64        // Silence from the other side after which the connection is reset, or zero
+	lastRead       int64        // Time the last sequence-valid packet was read
+	peerProbe      PeerProbe    // Schedule of the probes of a silent peer, see SetPeerProbe
+	probeCount     int          // Probes sent since the peer fell silent
+	probeTime      int64        // Time the last probe was sent
+	pcapWriter     *PcapWriter  // Where sent and received packets are saved, or nil, see SetPcap
+	pcap           *pcapCapture // Framing of the saved packets, set up on the first one
+	stats          ConnStats    // Counters of the connection, see Stats
+	active         bool         // Whether the connection counts in Counters.ActiveConns
+	wheel          *timerWheel  // Where the timers of the connection are kept, see setTimer
+	timer          *wheelTimer  // Protocol timer of the current state, or nil
+	idleTimer      wheelTimer   // Timer of the idle polls, see idle
+
+	ackVector      ackVector    // Receive history, kept while the Send Ack Vector feature is on
+	csCov          byte         // Checksum coverage requested by the application for outgoing data
+	dataDropped    dataDropped  // Received packets whose data was dropped, pending a Data Dropped report
+	ndpCount       uint64       // Consecutive non-data packets sent since the last data packet
+	ackRatio       uint16       // Ack Ratio last requested by the sender CCID, or zero
+	ackRatioCount  int          // Data packets received since we last sent an acknowledgement
+
+	syncTime       int64        // Start of the current Sync rate limiting period
+	syncCount      int          // Syncs sent in response to sequence-invalid packets since syncTime
+
+	readApp        chan *appMsg // loop sends application data to ReadSegment()
+	readDone       chan struct{} // Closed when the connection stops delivering data, see teardownUser
+	readRestLk     Mutex
+	readRest       []byte       // Unread part of the packet last returned by ReadSegment() to Read()
+	readRestInfo   *MsgInfo     // Metadata of the packet of readRest
+	readRestBuf    *packetBuf   // Packet buffer that readRest lies in, released once it is read
+	readDeadline   *deadline
+	writeData      *sendQueue   // WriteMsg() queues application data for writeLoop()
+	writeDeadline  *deadline
 	writeNonData   chan *writeHeader // inject() sends wire-format non-Data packets (higher priority) to writeLoop()
+	writeNonDataClosed bool     // Whether teardownWriteLoop has closed writeNonData
+	dataOpen       chan struct{} // Closed once the connection is PARTOPEN or OPEN, see writeLoop
+
+	eventLk        Mutex
+	events         []connEventCall // Events that await delivery to eventHandler
+	eventsRunning  bool         // Whether deliverEvents is running
+
+	rateLk         Mutex
+	rates          []rateCall   // Rates that await delivery to rateHandler
+	ratesRunning   bool         // Whether deliverRates is running
 
 	writeTime      monotoneTime
 }
@@ -41,61 +112,103 @@ func (c *Conn) Amb() *Amb {
 
 func newConn(env *Env, amb *Amb, hc HeaderConn, scc SenderCongestionControl, rcc ReceiverCongestionControl) *Conn {
 	c := &Conn{
-		env:          env,
-		amb:          amb,
-		hc:           hc,
-		scc:          scc,
-		rcc:          rcc,
-		ccidOpen:     false,
-		readApp:      make(chan []byte, 5),
-		writeData:    make(chan []byte),
-		writeNonData: make(chan *writeHeader, 5),
+		env:            env,
+		amb:            amb,
+		hc:             hc,
+		ecn:            carriesECN(hc),
+		scc:            scc,
+		rcc:            rcc,
+		ccidOpen:       false,
+		requestRetry:   DefaultRequestRetry,
+		respondTimeout: RESPOND_TIMEOUT,
+		timewait:       TIMEWAIT_TIMEOUT,
+		keepalive:      defaultKeepalive(hc),
+		lastWrite:      env.nowNano(),
+		lastRead:       env.nowNano(),
+		pcapWriter:     env.Pcap(),
+		in:             make(chan readResult, 5),
+		calls:          make(chan *connCall),
+		dueReady:       make(chan struct{}, 1),
+		loopDone:       make(chan struct{}),
+		handshake:      make(chan struct{}),
+		wheel:          env.timerWheel(),
+		readApp:        make(chan *appMsg, 5),
+		readDone:       make(chan struct{}),
+		readDeadline:   newDeadline(env, env.timerWheel()),
+		writeData:      newSendQueue(env, amb),
+		writeDeadline:  newDeadline(env, env.timerWheel()),
+		writeNonData:   make(chan *writeHeader, 5),
+		dataOpen:       make(chan struct{}),
 	}
 	c.writeTime.Init(env)
+	c.idleTimer.fire = func() { c.post(c.idle) }
 
-	c.Lock()
-	// Currently, CCID is not negotiated, rather both sides use the same
+	c.countActive(true)
 	c.socket.SetCCIDA(scc.GetID())
 	c.socket.SetCCIDB(rcc.GetID())
 
-	// REMARK: SWAF/SWBF are currently not implemented. 
-	// Instead, we use wide enough fixed-size windows
-	c.socket.SetSWAF(SEQWIN_FIXED)
-	c.socket.SetSWBF(SEQWIN_FIXED)
+	// Both sides start with the default Sequence Window and announce a wider one
+	c.features.Init()
+	// Each side proposes the CCIDs it was created with, for both half-connections
+	c.features.ChangeLocal(FeatureCCID, uint64(scc.GetID()))
+	c.features.ChangeRemote(FeatureCCID, uint64(rcc.GetID()))
+	c.features.ChangeLocal(FeatureSequenceWindow, SEQWIN_FIXED)
+	if avs, ok := scc.(AckVectorSender); ok && avs.WantsAckVector() {
+		c.features.ChangeRemote(FeatureSendAckVector, 1)
+	}
+	if ndr, ok := rcc.(NDPCountReceiver); ok && ndr.WantsNDPCount() {
+		c.features.ChangeRemote(FeatureSendNDPCount, 1)
+	}
+	// If ECN codepoints cannot be read, ask the other side not to send ECN-capable packets
+	if !c.ecn {
+		c.features.ChangeLocal(FeatureECNIncapable, 1)
+	}
 
+	c.syncWithFeatures()
 	c.syncWithLink()
 	c.syncWithCongestionControl()
-	c.Unlock()
 
 	return c
 }
 
+// NewConnServer creates a server connection that waits for a Request on hc. If serviceCodes are
+// given, the server only accepts Requests for one of them, RFC 5595, Section 2. Otherwise it
+// accepts any service code.
 func NewConnServer(env *Env, amb *Amb, hc HeaderConn, 
-	scc SenderCongestionControl, rcc ReceiverCongestionControl) *Conn {
+	scc SenderCongestionControl, rcc ReceiverCongestionControl, serviceCodes ...ServiceCode) *Conn {
 
-	c := newConn(env, amb, hc, scc, rcc)
+	return newConnServer(env, amb, hc, scc, rcc, serviceCodes, nil, nil)
+}
 
-	c.Lock()
-	c.gotoLISTEN()
-	c.Unlock()
+// newConnServer creates a server connection like NewConnServer. If configure is not nil, it is
+// called before the connection starts, from the goroutine of the caller, so that it may set
+// up the connection without racing with its first Request. If request is not nil, it is the
+// Request that has been read from hc already, and the connection processes it first.
+func newConnServer(env *Env, amb *Amb, hc HeaderConn, scc SenderCongestionControl,
+	rcc ReceiverCongestionControl, serviceCodes []ServiceCode, configure func(*Conn), request *Header) *Conn {
 
-	c.env.Go(func() { c.writeLoop(c.writeNonData, c.writeData) }, "ConnServer·writLoop")
-	c.env.Go(func() { c.readLoop() }, "ConnServer·readLoop")
-	c.env.Go(func() { c.idleLoop() }, "ConnServer·idleLoop")
+	c := newConn(env, amb, hc, scc, rcc)
+	c.serviceCodes = serviceCodes
+	c.gotoLISTEN()
+	if configure != nil {
+		configure(c)
+	}
+	c.start(request)
 	return c
 }
 
+// NewConnClient creates a client connection that sends a Request on hc. The client offers the
+// given service codes, in order of preference, and the server picks the first one it provides.
+// The chosen code is returned by ServiceCode once the connection is established. Codes beyond
+// the first are only sent once EnableServiceCodesOption turns that extension on.
 func NewConnClient(env *Env, amb *Amb, hc HeaderConn, 
-	scc SenderCongestionControl, rcc ReceiverCongestionControl, serviceCode uint32) *Conn {
+	scc SenderCongestionControl, rcc ReceiverCongestionControl, serviceCodes ...ServiceCode) *Conn {
 
+	if len(serviceCodes) == 0 {
+		serviceCodes = []ServiceCode{0}
+	}
 	c := newConn(env, amb, hc, scc, rcc)
-
-	c.Lock()
-	c.gotoREQUEST(serviceCode)
-	c.Unlock()
-
-	c.env.Go(func() { c.writeLoop(c.writeNonData, c.writeData) }, "ConnClient·writeLoop")
-	c.env.Go(func() { c.readLoop() }, "ConnClient·readLoop")
-	c.env.Go(func() { c.idleLoop() }, "ConnClient·idleLoop")
+	c.gotoREQUEST(serviceCodes)
+	c.start(nil)
 	return c
 }
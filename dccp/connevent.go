@@ -0,0 +1,86 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "time"
+
+// ConnEventKind tells what a ConnEvent is about
+type ConnEventKind int
+
+const (
+	ConnStateChange ConnEventKind = iota // The connection entered State
+	ConnReset                            // The other side reset the connection, see ConnEvent.Reset
+)
+
+// ConnEvent is a change in the life of a connection, which OnEvent reports
+type ConnEvent struct {
+	Kind  ConnEventKind
+	State int         // State of the connection once the event happened, like OPEN or CLOSED
+	Time  time.Time   // Time of the event, by the Env of the connection
+	Reset *ResetError // For ConnReset, the Reset that was received
+	Err   error       // Once the connection is torn down, the reason why, as Error returns it
+}
+
+// OnEvent sets a function that is called with each change in the life of the connection from
+// then on: every state transition, like reaching OPEN or entering CLOSING, and the Reset that the
+// other side tears the connection down with. The calls are made in a goroutine of their own, in
+// the order of the events, so that f may block or call methods of the connection. A nil f
+// removes the function.
+func (c *Conn) OnEvent(f func(ConnEvent)) {
+	c.do(func() {
+		c.eventHandler = f
+		c.eventState = c.socket.GetState()
+	})
+}
+
+// postEvent queues the event e for the function set by OnEvent, if any
+func (c *Conn) postEvent(e ConnEvent) {
+	if c.eventHandler == nil {
+		return
+	}
+	e.Time = c.env.Now()
+	e.Err = c.err
+	c.eventLk.Lock()
+	defer c.eventLk.Unlock()
+	c.events = append(c.events, connEventCall{c.eventHandler, e})
+	if !c.eventsRunning {
+		c.eventsRunning = true
+		c.env.Go(c.deliverEvents, "Conn·deliverEvents")
+	}
+}
+
+// postStateEvent reports the state of the connection to the function set by OnEvent, unless
+// it has been reported already
+func (c *Conn) postStateEvent() {
+	state := c.socket.GetState()
+	if state == c.eventState {
+		return
+	}
+	c.eventState = state
+	c.postEvent(ConnEvent{Kind: ConnStateChange, State: state})
+}
+
+// connEventCall is an event queued for delivery, along with the function it is for
+type connEventCall struct {
+	f func(ConnEvent)
+	e ConnEvent
+}
+
+// deliverEvents makes the calls that postEvent queues, until there are none left
+func (c *Conn) deliverEvents() {
+	for {
+		c.eventLk.Lock()
+		if len(c.events) == 0 {
+			c.eventsRunning = false
+			c.eventLk.Unlock()
+			return
+		}
+		call := c.events[0]
+		c.events[0] = connEventCall{}
+		c.events = c.events[1:]
+		c.eventLk.Unlock()
+		call.f(call.e)
+	}
+}
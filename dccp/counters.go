@@ -0,0 +1,106 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"sync/atomic"
+)
+
+// NumTypes is the number of packet types that this implementation understands, Request to
+// SyncAck
+const NumTypes = SyncAck + 1
+
+// Counters are totals over all the connections of the process since it started, as returned by
+// ReadCounters. Unlike Conn.Stats, they outlive the connections they count.
+type Counters struct {
+	PacketsSent     [NumTypes]int64 // Packets written to a HeaderConn, by packet type
+	PacketsReceived [NumTypes]int64 // Packets read from a HeaderConn, by packet type
+	ResetsSent      [256]int64      // Reset packets written, by Reset Code
+	ResetsReceived  [256]int64      // Reset packets processed, by Reset Code
+	Retransmits     int64           // Requests, Responses and PARTOPEN Acks sent again
+
+	ActiveConns      int64 // Connections created and not yet CLOSED
+	Handshakes       int64 // Handshakes that brought a connection to OPEN
+	OptionsParsed    int64 // Options read from the headers of received packets
+	ChecksumFailures int64 // Received packets dropped for a wrong checksum
+}
+
+// counters are updated atomically, field by field
+var counters Counters
+
+// ReadCounters returns a snapshot of the process-wide counters
+func ReadCounters() Counters {
+	var s Counters
+	for i := range s.PacketsSent {
+		s.PacketsSent[i] = atomic.LoadInt64(&counters.PacketsSent[i])
+		s.PacketsReceived[i] = atomic.LoadInt64(&counters.PacketsReceived[i])
+	}
+	for i := range s.ResetsSent {
+		s.ResetsSent[i] = atomic.LoadInt64(&counters.ResetsSent[i])
+		s.ResetsReceived[i] = atomic.LoadInt64(&counters.ResetsReceived[i])
+	}
+	s.Retransmits = atomic.LoadInt64(&counters.Retransmits)
+	s.ActiveConns = atomic.LoadInt64(&counters.ActiveConns)
+	s.Handshakes = atomic.LoadInt64(&counters.Handshakes)
+	s.OptionsParsed = atomic.LoadInt64(&counters.OptionsParsed)
+	s.ChecksumFailures = atomic.LoadInt64(&counters.ChecksumFailures)
+	return s
+}
+
+// countPacket counts a packet of type typ, sent or received
+func countPacket(typ byte, sent bool) {
+	if typ >= NumTypes {
+		return
+	}
+	if sent {
+		atomic.AddInt64(&counters.PacketsSent[typ], 1)
+	} else {
+		atomic.AddInt64(&counters.PacketsReceived[typ], 1)
+	}
+}
+
+// countResetCode counts a Reset packet with Reset Code code, sent or processed
+func countResetCode(code byte, sent bool) {
+	if sent {
+		atomic.AddInt64(&counters.ResetsSent[code], 1)
+	} else {
+		atomic.AddInt64(&counters.ResetsReceived[code], 1)
+	}
+}
+
+// countRetransmit counts a handshake packet that is sent again
+func (c *Conn) countRetransmit() {
+	c.stats.Retransmits++
+	atomic.AddInt64(&counters.Retransmits, 1)
+}
+
+// countActive counts c as active, if active is true, or no longer active otherwise. It MUST be
+// idempotent.
+func (c *Conn) countActive(active bool) {
+	if c.active == active {
+		return
+	}
+	c.active = active
+	if active {
+		atomic.AddInt64(&counters.ActiveConns, 1)
+	} else {
+		atomic.AddInt64(&counters.ActiveConns, -1)
+	}
+}
+
+// countHandshake counts a handshake that brought a connection to OPEN
+func countHandshake() {
+	atomic.AddInt64(&counters.Handshakes, 1)
+}
+
+// countOptions counts n options read from a received header
+func countOptions(n int) {
+	atomic.AddInt64(&counters.OptionsParsed, int64(n))
+}
+
+// countChecksumFailure counts a received packet with a wrong checksum
+func countChecksumFailure() {
+	atomic.AddInt64(&counters.ChecksumFailures, 1)
+}
@@ -0,0 +1,82 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+// The Minimum Checksum Coverage feature is located at the receiver. A value of zero, the
+// default, means that only packets with full checksum coverage are acceptable. A value m > 0
+// means that packets with CsCov zero or at least m are acceptable, Section 9.2.1.
+
+// WriteCsCov sets the checksum coverage of h to the coverage requested by the application,
+// raised if necessary to the Minimum Checksum Coverage of the other endpoint
+func (c *Conn) WriteCsCov(h *Header) {
+	h.CsCov = CsCovAllData
+	if h.Type != Data && h.Type != DataAck || c.csCov == CsCovAllData {
+		return
+	}
+	min := byte(c.features.Remote(FeatureMinimumChecksumCoverage))
+	if min == 0 {
+		return
+	}
+	cscov := c.csCov
+	if cscov < min {
+		cscov = min
+	}
+	// Coverage beyond the end of the data is expressed as full coverage
+	if _, err := getChecksumAppCoverage(cscov, h.DataLen()); err != nil {
+		return
+	}
+	h.CsCov = cscov
+}
+
+// acceptCsCov returns true if the checksum coverage of h meets our Minimum Checksum Coverage
+func (c *Conn) acceptCsCov(h *Header) bool {
+	if h.CsCov == CsCovAllData {
+		return true
+	}
+	min := c.features.Local(FeatureMinimumChecksumCoverage)
+	return min != 0 && uint64(h.CsCov) >= min
+}
+
+// WriteDataDropped places a Data Dropped option on h, reporting the packets whose data was
+// dropped since the last report, Section 11.7
+func (c *Conn) WriteDataDropped(h *Header) {
+	if !h.HasAckNo() || h.Type == Reset {
+		return
+	}
+	if opt := c.dataDropped.Option(h.AckNo); opt != nil {
+		h.Options = append(h.Options, opt)
+	}
+}
+
+// The Check Data Checksum feature is located at the receiver. If it is one, the receiver checks
+// Data Checksum options, and the sender places one on every packet that carries application
+// data, Section 9.3.1.
+
+// WriteDataChecksum places a Data Checksum option on h, if h carries application data and the
+// other endpoint checks data checksums
+func (c *Conn) WriteDataChecksum(h *Header) {
+	if h.Type != Data && h.Type != DataAck || c.features.Remote(FeatureCheckDataChecksum) == 0 {
+		return
+	}
+	opt, _ := (&DataChecksumOption{dataChecksum(h.Data, h.DataVec...)}).Encode()
+	h.Options = append(h.Options, opt)
+}
+
+// checkDataChecksum returns the Drop Code with which the data of h must be dropped, and false
+// if the data of h passes the Data Checksum check. Data Checksum options are verified whenever
+// present. If we have enabled Check Data Checksum, they are also required.
+func (c *Conn) checkDataChecksum(h *Header) (dropCode byte, drop bool) {
+	dc := findDataChecksum(h.Options)
+	if dc == nil {
+		if c.features.Local(FeatureCheckDataChecksum) != 0 {
+			return DropProtocolConstraints, true
+		}
+		return 0, false
+	}
+	if dc.Checksum != dataChecksum(h.Data) {
+		return DropCorruptedData, true
+	}
+	return 0, false
+}
@@ -13,15 +13,15 @@ import (
 	"time"
 )
 
-// InstallTimeout panics the current process in ns time
-func InstallTimeout(ns int64) {
+// InstallTimeout panics the current process once d has passed
+func InstallTimeout(d time.Duration) {
 	go func() {
-		k := int(ns / 1e9)
+		k := int(d / time.Second)
 		for i := 0; i < k; i++ {
 			time.Sleep(time.Second)
 			fmt.Printf("•%d/%d•\n", i, k)
 		}
-		//time.Sleep(time.Duration(ns))
+		//time.Sleep(d)
 		panic("process timeout")
 	}()
 }
@@ -22,7 +22,7 @@ func NewStack(link Link, ccid CCID) *Stack {
 }
 
 // Dial initiates a new connection to the specified Link-layer address.
-func (s *Stack) Dial(addr net.Addr, serviceCode uint32) (c SegmentConn, err error) {
+func (s *Stack) Dial(addr net.Addr, serviceCode ServiceCode) (c *Conn, err error) {
 	bc, err := s.mux.Dial(addr)
 	if err != nil {
 		return nil, err
@@ -38,7 +38,7 @@ func (s *Stack) Dial(addr net.Addr, serviceCode uint32) (c SegmentConn, err erro
 
 // Accept blocks until a new connecion is established. It then
 // returns the connection.
-func (s *Stack) Accept() (c SegmentConn, err error) {
+func (s *Stack) Accept() (c *Conn, err error) {
 	bc, err := s.mux.Accept()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,147 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+// Package dccplayer decodes and encodes DCCP packets as gopacket layers, so that the packets
+// of GoDCCP can be built and inspected with the tools of github.com/google/gopacket. Importing
+// the package makes gopacket decode IP protocol 33 as a DCCP layer:
+//
+//	packet := gopacket.NewPacket(data, layers.LayerTypeIPv4, gopacket.Default)
+//	if l, ok := packet.Layer(dccplayer.LayerTypeDCCP).(*dccplayer.DCCP); ok {
+//		fmt.Println(l.Type, l.SeqNo, l.AckNo)
+//	}
+//
+// The layer covers native DCCP packets, RFC 4340, such as those of a RawIP. DCCP-UDP and the
+// label-multiplexed UDP links of GoDCCP carry other wire formats.
+package dccplayer
+
+import (
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// dccpProtoNo is the IP protocol number of DCCP
+const dccpProtoNo = 33
+
+var (
+	// LayerTypeDCCP is the gopacket layer type of DCCP packets
+	LayerTypeDCCP = gopacket.RegisterLayerType(1033, gopacket.LayerTypeMetadata{
+		Name:    "DCCP",
+		Decoder: gopacket.DecodeFunc(decodeDCCP),
+	})
+
+	// EndpointDCCPPort is the gopacket endpoint type of DCCP ports
+	EndpointDCCPPort = gopacket.RegisterEndpointType(1033, gopacket.EndpointTypeMetadata{
+		Name: "DCCP",
+		Formatter: func(b []byte) string {
+			return fmt.Sprintf("%d", dccp.DecodeUint16(b))
+		},
+	})
+)
+
+func init() {
+	layers.IPProtocolMetadata[dccpProtoNo] = layers.EnumMetadata{
+		DecodeWith: LayerTypeDCCP,
+		Name:       "DCCP",
+		LayerType:  LayerTypeDCCP,
+	}
+}
+
+// DCCP is a DCCP packet as a gopacket layer. Its header is a dccp.Header, whose Data is the
+// application data of the packet, which is also the layer's payload.
+type DCCP struct {
+	layers.BaseLayer
+	dccp.Header
+
+	network gopacket.NetworkLayer // Source of the IP addresses of the checksum, or nil
+}
+
+// LayerType implements gopacket.Layer.LayerType
+func (d *DCCP) LayerType() gopacket.LayerType { return LayerTypeDCCP }
+
+// CanDecode implements gopacket.DecodingLayer.CanDecode
+func (d *DCCP) CanDecode() gopacket.LayerClass { return LayerTypeDCCP }
+
+// NextLayerType implements gopacket.DecodingLayer.NextLayerType
+func (d *DCCP) NextLayerType() gopacket.LayerType { return gopacket.LayerTypePayload }
+
+// DecodeFromBytes implements gopacket.DecodingLayer.DecodeFromBytes. The checksum is not
+// verified, since the IP addresses that it covers are not known to the layer.
+func (d *DCCP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	h, err := dccp.ParseHeader(data)
+	if err != nil {
+		df.SetTruncated()
+		return err
+	}
+	d.Header = *h
+	d.Contents = data[:len(data)-len(h.Data)]
+	d.Payload = h.Data
+	return nil
+}
+
+// TransportFlow implements gopacket.TransportLayer.TransportFlow
+func (d *DCCP) TransportFlow() gopacket.Flow {
+	var src, dst [2]byte
+	dccp.EncodeUint16(d.SourcePort, src[:])
+	dccp.EncodeUint16(d.DestPort, dst[:])
+	return gopacket.NewFlow(EndpointDCCPPort, src[:], dst[:])
+}
+
+// SetNetworkLayerForChecksum sets the IPv4 or IPv6 layer whose addresses the checksum covers,
+// as for the TCP and UDP layers of gopacket
+func (d *DCCP) SetNetworkLayerForChecksum(l gopacket.NetworkLayer) error {
+	switch l.(type) {
+	case *layers.IPv4, *layers.IPv6:
+		d.network = l
+		return nil
+	}
+	return fmt.Errorf("cannot use layer type %v for DCCP checksum network layer", l.LayerType())
+}
+
+// SerializeTo implements gopacket.SerializableLayer.SerializeTo. The header goes in front of
+// the bytes already in b, which are the application data; the Data of the header is ignored.
+// The checksum is always computed, over a zero pseudo-header if there is no network layer.
+// ComputeChecksums asks for the network layer, and FixLengths has no effect, since the Data
+// Offset is always computed.
+func (d *DCCP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	src, dst := make([]byte, 4), make([]byte, 4)
+	switch l := d.network.(type) {
+	case *layers.IPv4:
+		src, dst = l.SrcIP.To4(), l.DstIP.To4()
+	case *layers.IPv6:
+		src, dst = l.SrcIP.To16(), l.DstIP.To16()
+	default:
+		if opts.ComputeChecksums {
+			return fmt.Errorf("DCCP checksum needs a network layer, see SetNetworkLayerForChecksum")
+		}
+	}
+	h := d.Header
+	h.Data = b.Bytes()
+	p, err := h.Write(src, dst, dccpProtoNo, true)
+	if err != nil {
+		return err
+	}
+	n := len(p) - len(h.Data)
+	buf, err := b.PrependBytes(n)
+	if err != nil {
+		return err
+	}
+	copy(buf, p[:n])
+	return nil
+}
+
+func decodeDCCP(data []byte, p gopacket.PacketBuilder) error {
+	d := &DCCP{}
+	if err := d.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(d)
+	p.SetTransportLayer(d)
+	if len(d.Payload) == 0 {
+		return nil
+	}
+	return p.NextDecoder(gopacket.LayerTypePayload)
+}
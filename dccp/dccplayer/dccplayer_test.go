@@ -0,0 +1,66 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccplayer
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/petar/GoDCCP/dccp"
+)
+
+func TestLayer(t *testing.T) {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: dccpProtoNo,
+		SrcIP:    net.IPv4(10, 0, 0, 1),
+		DstIP:    net.IPv4(10, 0, 0, 2),
+	}
+	d := &DCCP{}
+	d.InitDataAckHeader(nil)
+	d.SourcePort, d.DestPort = 5001, 6001
+	d.SeqNo, d.AckNo, d.X = 0x123456, 0x654321, true
+	d.Options = []*dccp.Option{{Type: dccp.OptionNDPCount, Data: []byte{3}}}
+	if err := d.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatalf("network layer (%s)", err)
+	}
+	payload := []byte("hello")
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, d, gopacket.Payload(payload)); err != nil {
+		t.Fatalf("serialize (%s)", err)
+	}
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	if err := packet.ErrorLayer(); err != nil {
+		t.Fatalf("decode (%s)", err.Error())
+	}
+	e, ok := packet.Layer(LayerTypeDCCP).(*DCCP)
+	if !ok {
+		t.Fatalf("no DCCP layer in %s", packet)
+	}
+	if e.Type != dccp.DataAck || e.SeqNo != d.SeqNo || e.AckNo != d.AckNo || e.SourcePort != 5001 || e.DestPort != 6001 {
+		t.Errorf("decoded %v", &e.Header)
+	}
+	if len(e.Options) != 1 || e.Options[0].Type != dccp.OptionNDPCount {
+		t.Errorf("decoded options %v", e.Options)
+	}
+	if app := packet.ApplicationLayer(); app == nil || !bytes.Equal(app.Payload(), payload) {
+		t.Errorf("payload %v", app)
+	}
+	if flow := packet.TransportLayer().TransportFlow(); flow.String() != "5001->6001" {
+		t.Errorf("flow %s", flow)
+	}
+
+	// GoDCCP accepts the checksum
+	p := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4).Payload
+	if _, err := dccp.ReadHeader(p, ip.SrcIP.To4(), ip.DstIP.To4(), dccpProtoNo, false); err != nil {
+		t.Errorf("checksum (%s)", err)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "hash/crc32"
+
+// DataChecksumOption, Section 9.3
+// The option carries the CRC-32c of the application data of the packet, allowing the receiver
+// to tell corrupted data apart from a corrupted header. It is only meaningful on packets that
+// carry application data.
+type DataChecksumOption struct {
+	Checksum uint32
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// dataChecksum computes the CRC-32c of the application data data, followed by the fragments vec
+func dataChecksum(data []byte, vec ...[]byte) uint32 {
+	sum := crc32.Checksum(data, crc32c)
+	for _, f := range vec {
+		sum = crc32.Update(sum, crc32c, f)
+	}
+	return sum
+}
+
+func (opt *DataChecksumOption) Encode() (*Option, error) {
+	d := make([]byte, 4)
+	EncodeUint32(opt.Checksum, d)
+	return &Option{
+		Type:      OptionDataChecksum,
+		Data:      d,
+		Mandatory: false,
+	}, nil
+}
+
+func DecodeDataChecksumOption(opt *Option) *DataChecksumOption {
+	if opt.Type != OptionDataChecksum || len(opt.Data) != 4 {
+		return nil
+	}
+	return &DataChecksumOption{Checksum: DecodeUint32(opt.Data)}
+}
+
+// findDataChecksum returns the Data Checksum option carried in opts, or nil if there is none
+func findDataChecksum(opts []*Option) *DataChecksumOption {
+	for _, o := range opts {
+		if dc := DecodeDataChecksumOption(o); dc != nil {
+			return dc
+		}
+	}
+	return nil
+}
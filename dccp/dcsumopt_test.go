@@ -0,0 +1,20 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "testing"
+
+func TestDataChecksumOption(t *testing.T) {
+	// CRC-32c check value, RFC 3720, Appendix B.4
+	if c := dataChecksum(make([]byte, 32)); c != 0x8a9136aa {
+		t.Errorf("expecting crc32c %08x, encountered %08x", 0x8a9136aa, c)
+	}
+	data := []byte("datagram congestion control protocol")
+	opt, _ := (&DataChecksumOption{dataChecksum(data)}).Encode()
+	dc := findDataChecksum([]*Option{opt})
+	if dc == nil || dc.Checksum != dataChecksum(data) {
+		t.Errorf("data checksum option round trip")
+	}
+}
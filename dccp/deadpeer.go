@@ -0,0 +1,66 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "time"
+
+// PeerProbe controls how an OPEN connection finds out that the other side is gone. Once nothing
+// has been received for Silence, the connection sends a Sync every Interval, Section 7.5. Any
+// packet from the other side, like the SyncAck that answers a Sync, ends the probing. After
+// Probes unanswered Syncs, the connection is reset and fails with ErrPeerUnreachable. A dead
+// connection thus lingers for at most about Silence+Probes*Interval.
+type PeerProbe struct {
+	Silence  time.Duration // Silence from the other side after which probing starts
+	Interval time.Duration // Wait between probes, and for the answer to the last one
+	Probes   int           // Unanswered probes after which the other side is declared unreachable
+}
+
+// Valid returns true if p is a probing schedule, or the zero PeerProbe that turns probing off
+func (p PeerProbe) Valid() bool {
+	if p == (PeerProbe{}) {
+		return true
+	}
+	return p.Silence > 0 && p.Interval >= BackoffMin && p.Probes > 0
+}
+
+// SetPeerProbe sets the schedule on which the connection probes a silent peer. The zero
+// PeerProbe, which connections start with, turns probing off. SetPeerProbe returns ErrInvalid
+// if p is not valid.
+func (c *Conn) SetPeerProbe(p PeerProbe) error {
+	if !p.Valid() {
+		return ErrInvalid
+	}
+	c.do(func() {
+		c.peerProbe = p
+		c.probeCount = 0
+	})
+	return nil
+}
+
+// pollPeerProbe sends the next probe to a silent peer, or resets the connection once the
+// probes have gone unanswered. The idle loop calls it about once per round-trip time.
+func (c *Conn) pollPeerProbe() {
+	p := c.peerProbe
+	if p.Probes <= 0 || c.socket.GetState() != OPEN {
+		return
+	}
+	now := c.env.nowNano()
+	if now-c.lastRead < int64(p.Silence) {
+		c.probeCount = 0
+		return
+	}
+	if c.probeCount > 0 && now-c.probeTime < int64(p.Interval) {
+		return
+	}
+	if c.probeCount >= p.Probes {
+		c.amb.E(EventWarn, "Peer unreachable")
+		c.reset(ResetAborted, ErrPeerUnreachable)
+		return
+	}
+	c.probeCount++
+	c.probeTime = now
+	c.amb.E(EventInfo, "Peer probe")
+	c.inject(c.generateSync())
+}
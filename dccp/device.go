@@ -0,0 +1,43 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"io"
+	"syscall"
+)
+
+// On a multi-homed host, the routing table picks the interface that packets leave through,
+// and any interface may receive packets for a wildcard address. A transport bound to an
+// interface sends and receives through it alone, see Dialer.Interface. The packetSockets,
+// which choose the source addresses of their flows themselves, take those of the interface.
+
+// bindConnToDevice binds the socket c to the network interface ifname
+func bindConnToDevice(c syscall.Conn, ifname string) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return bindDevice(rc, ifname)
+}
+
+// deviceBinder is implemented by the transports that can be bound to a network interface
+type deviceBinder interface {
+	io.Closer
+	BindToDevice(ifname string) error
+}
+
+// bindTransport binds the transport x to the network interface ifname, unless ifname is
+// empty, and closes x if that fails
+func bindTransport(x deviceBinder, ifname string) error {
+	if ifname == "" {
+		return nil
+	}
+	if err := x.BindToDevice(ifname); err != nil {
+		x.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "syscall"
+
+// bindDevice binds the socket rc to the network interface ifname with SO_BINDTODEVICE, see
+// socket(7). Before Linux 5.7, this needs CAP_NET_RAW.
+func bindDevice(rc syscall.RawConn, ifname string) error {
+	var err error
+	if cerr := rc.Control(func(fd uintptr) {
+		err = syscall.BindToDevice(int(fd), ifname)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
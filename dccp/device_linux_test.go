@@ -0,0 +1,79 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestBindToDevice checks that transports bound to the loopback interface reach loopback
+// addresses, that flows take the addresses of the interface, and that binding to an unknown
+// interface fails
+func TestBindToDevice(t *testing.T) {
+	lo := loopbackName(t)
+	a, err := BindUDPLink("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("bind: %s", err)
+	}
+	defer a.Close()
+	if err := a.BindToDevice(lo); err != nil {
+		t.Skipf("binding to %s: %s", lo, err)
+	}
+	b, err := BindUDPLink("udp4", nil)
+	if err != nil {
+		t.Fatalf("bind: %s", err)
+	}
+	defer b.Close()
+	if err := b.BindToDevice(lo); err != nil {
+		t.Fatalf("binding to %s: %s", lo, err)
+	}
+	if _, err := b.WriteTo([]byte{1}, a.LocalAddr()); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	a.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, _, err := a.ReadFrom(make([]byte, 10)); n != 1 || err != nil {
+		t.Fatalf("read %d, %v", n, err)
+	}
+
+	e, err := BindUDPEncap("udp4", nil)
+	if err != nil {
+		t.Fatalf("bind: %s", err)
+	}
+	defer e.Close()
+	if err := e.BindToDevice(lo); err != nil {
+		t.Fatalf("binding to %s: %s", lo, err)
+	}
+	hc, err := e.Dial(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: EncapPort})
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	if ip := hc.(*packetFlow).local.IP; !ip.IsLoopback() {
+		t.Errorf("flow from %s", ip)
+	}
+
+	if err := b.BindToDevice("nonexistent0"); err == nil {
+		t.Errorf("bound to unknown interface")
+	}
+	if _, err := (&Dialer{Interface: "nonexistent0"}).Dial("udp4", "127.0.0.1:1", 0); err == nil {
+		t.Errorf("dialed through unknown interface")
+	}
+}
+
+// loopbackName returns the name of the loopback interface
+func loopbackName(t *testing.T) string {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("interfaces: %s", err)
+	}
+	for _, ifi := range ifs {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			return ifi.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
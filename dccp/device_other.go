@@ -0,0 +1,14 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin
+
+package dccp
+
+import "syscall"
+
+// bindDevice returns ErrUnsupported, as sockets are bound to interfaces on Linux and macOS only
+func bindDevice(rc syscall.RawConn, ifname string) error {
+	return ErrUnsupported
+}
@@ -1,7 +1,276 @@
 // Copyright 2011-2013 GoDCCP Authors. All rights reserved.
-// Use of this source code is governed by a 
+// Use of this source code is governed by a
 // license that can be found in the LICENSE file.
 
 package dccp
 
-import ()
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultCCID is the congestion control that Dial and Listen use. It must be registered with
+// RegisterCCID, usually by importing its package, e.g. github.com/petar/GoDCCP/dccp/ccid3.
+var DefaultCCID byte = CCID3
+
+// Dialer holds options for starting connections. The zero Dialer is that of Dial.
+type Dialer struct {
+	// Interface, if not empty, is the name of the network interface, like "eth1", that the
+	// connection sends and receives through, whatever the routing table says. It takes
+	// SO_BINDTODEVICE on Linux, which needs CAP_NET_RAW before Linux 5.7, or IP_BOUND_IF on
+	// macOS; elsewhere dialing fails with ErrUnsupported.
+	Interface string
+
+	// CCID, if not zero, is the CCID of the connection, like CCID2, in place of DefaultCCID.
+	// It must be registered, see RegisterCCID.
+	CCID byte
+}
+
+// ListenConfig holds options for listening. The zero ListenConfig is that of Listen and
+// ListenPort.
+type ListenConfig struct {
+	// Interface, if not empty, is the name of the network interface that the connections are
+	// accepted and started through, as for Dialer.Interface
+	Interface string
+
+	// CCID, if not zero, is the CCID of the connections in place of DefaultCCID, as for
+	// Dialer.CCID
+	CCID byte
+}
+
+// Dial connects to the DCCP server at address raddr on the named network, asking for the
+// service code serviceCode, and returns the connection once the handshake completes. The
+// networks "udp", "udp4" and "udp6" carry DCCP inside UDP datagrams, along with the labels
+// that GoDCCP multiplexes connections by, which needs no special privileges; raddr is then
+// a UDP address, like "example.com:5001". The networks "dccp-udp", "dccp-udp4" and
+// "dccp-udp6" use the standard DCCP-UDP encapsulation of RFC 6773 instead, which other
+// DCCP implementations understand; the port of raddr defaults to EncapPort. The networks
+// "dccp", "dccp4" and "dccp6" send native DCCP packets over IP, which needs the privileges of
+// raw sockets, see ListenRawIP; raddr is then "host:port", with a DCCP port.
+func Dial(network, raddr string, serviceCode ServiceCode) (*Conn, error) {
+	return new(Dialer).DialContext(context.Background(), network, raddr, serviceCode)
+}
+
+// DialContext is like Dial, except that it gives up if ctx is done before the handshake
+// completes, in which case the connection is aborted and the error of ctx is returned.
+func DialContext(ctx context.Context, network, raddr string, serviceCode ServiceCode) (*Conn, error) {
+	return new(Dialer).DialContext(ctx, network, raddr, serviceCode)
+}
+
+// Dial is like the function Dial, with the options of d
+func (d *Dialer) Dial(network, raddr string, serviceCode ServiceCode) (*Conn, error) {
+	return d.DialContext(context.Background(), network, raddr, serviceCode)
+}
+
+// DialContext is like the function DialContext, with the options of d
+func (d *Dialer) DialContext(ctx context.Context, network, raddr string, serviceCode ServiceCode) (*Conn, error) {
+	ccid, err := lookupCCID(d.CCID)
+	if err != nil {
+		return nil, err
+	}
+	hc, flows, err := dialHeaderConn(network, raddr, d.Interface)
+	if err != nil {
+		return nil, err
+	}
+	env := NewEnv(nil)
+	c := NewConnClient(env, NoLogging, hc,
+		ccid.NewSender(env, NoLogging),
+		ccid.NewReceiver(env, NoLogging),
+		serviceCode)
+	// The flows, and their link, belong to this connection alone
+	go func() {
+		c.Joiner().Join()
+		flows.Close()
+	}()
+	if err := c.waitOpen(ctx); err != nil {
+		c.Abort()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Listen announces the DCCP service with service code serviceCode at the local address laddr
+// on the named network, and returns a Listener for its connections. Networks are as for Dial.
+func Listen(network, laddr string, serviceCode ServiceCode) (*Listener, error) {
+	return new(ListenConfig).Listen(network, laddr, serviceCode)
+}
+
+// Listen is like the function Listen, with the options of lc
+func (lc *ListenConfig) Listen(network, laddr string, serviceCode ServiceCode) (*Listener, error) {
+	ccid, err := lookupCCID(lc.CCID)
+	if err != nil {
+		return nil, err
+	}
+	flows, err := listenFlows(network, laddr, lc.Interface)
+	if err != nil {
+		return nil, err
+	}
+	return newPort(flows, ccid).ownListener(serviceCode), nil
+}
+
+// ListenPort opens a Port at the local address laddr on the named network, on which
+// Port.Listen can announce several services and Port.Dial can start connections, all over the
+// same socket. Networks are as for Dial.
+func ListenPort(network, laddr string) (*Port, error) {
+	return new(ListenConfig).ListenPort(network, laddr)
+}
+
+// ListenPort is like the function ListenPort, with the options of lc
+func (lc *ListenConfig) ListenPort(network, laddr string) (*Port, error) {
+	ccid, err := lookupCCID(lc.CCID)
+	if err != nil {
+		return nil, err
+	}
+	flows, err := listenFlows(network, laddr, lc.Interface)
+	if err != nil {
+		return nil, err
+	}
+	return newPort(flows, ccid), nil
+}
+
+// lookupCCID returns the registered CCID id, or DefaultCCID if id is zero
+func lookupCCID(id byte) (CCID, error) {
+	if id == 0 {
+		id = DefaultCCID
+	}
+	ccid := LookupCCID(id)
+	if ccid == nil {
+		return nil, ErrUnsupported
+	}
+	return ccid, nil
+}
+
+// dialHeaderConn opens a connection to the remote address raddr on network, through the
+// network interface ifname if it is not empty. It returns the HeaderConn of the connection,
+// along with the flows that it belongs to.
+func dialHeaderConn(network, raddr, ifname string) (HeaderConn, io.Closer, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+		addr, err := net.ResolveUDPAddr(network, raddr)
+		if err != nil {
+			return nil, nil, err
+		}
+		link, err := BindUDPLink(network, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := bindTransport(link, ifname); err != nil {
+			return nil, nil, err
+		}
+		mux := NewMux(link)
+		bc, err := mux.Dial(addr)
+		if err != nil {
+			mux.Close()
+			return nil, nil, err
+		}
+		return NewHeaderConn(bc), mux, nil
+	case "dccp-udp", "dccp-udp4", "dccp-udp6":
+		network = encapNetwork(network)
+		addr, err := net.ResolveUDPAddr(network, encapHostPort(raddr))
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := BindUDPEncap(network, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := bindTransport(e, ifname); err != nil {
+			return nil, nil, err
+		}
+		hc, err := e.Dial(addr)
+		if err != nil {
+			e.Close()
+			return nil, nil, err
+		}
+		return hc, e, nil
+	case "dccp", "dccp4", "dccp6":
+		addr, err := ResolveIPAddr(network, raddr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if network == "dccp" {
+			network = "dccp4"
+			if addr.IP.To4() == nil {
+				network = "dccp6"
+			}
+		}
+		r, err := ListenRawIP(network, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := bindTransport(r, ifname); err != nil {
+			return nil, nil, err
+		}
+		hc, err := r.Dial(addr)
+		if err != nil {
+			r.Close()
+			return nil, nil, err
+		}
+		return hc, r, nil
+	}
+	return nil, nil, net.UnknownNetworkError(network)
+}
+
+// listenFlows opens the flows of network, bound to the local address laddr and, if ifname is
+// not empty, to the network interface ifname
+func listenFlows(network, laddr, ifname string) (flowAcceptor, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+		addr, err := net.ResolveUDPAddr(network, laddr)
+		if err != nil {
+			return nil, err
+		}
+		link, err := BindUDPLink(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := bindTransport(link, ifname); err != nil {
+			return nil, err
+		}
+		return muxAcceptor{NewMux(link), link}, nil
+	case "dccp-udp", "dccp-udp4", "dccp-udp6":
+		network = encapNetwork(network)
+		addr, err := net.ResolveUDPAddr(network, encapHostPort(laddr))
+		if err != nil {
+			return nil, err
+		}
+		e, err := BindUDPEncap(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := bindTransport(e, ifname); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case "dccp", "dccp4", "dccp6":
+		addr, err := ResolveIPAddr(network, laddr)
+		if err != nil {
+			return nil, err
+		}
+		r, err := ListenRawIP(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := bindTransport(r, ifname); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	return nil, net.UnknownNetworkError(network)
+}
+
+// encapNetwork returns the UDP network that carries the DCCP-UDP network
+func encapNetwork(network string) string {
+	return strings.TrimPrefix(network, "dccp-")
+}
+
+// encapHostPort adds EncapPort to addr, if it has no port
+func encapHostPort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(EncapPort))
+}
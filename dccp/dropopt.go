@@ -0,0 +1,118 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+// Drop Codes of the Data Dropped option, Section 11.7
+const (
+	DropProtocolConstraints     = 0
+	DropApplicationNotListening = 1
+	DropReceiveBuffer           = 2
+	DropCorruptedData           = 3
+	DropDeliveredCorrupted      = 7
+)
+
+// DataDroppedBlock is an entry of a Data Dropped option. It describes Len consecutive packets.
+// If Dropped is false, the application data of these packets was not dropped (or the packets
+// carried no data, or were not received). Otherwise the data was dropped for reason DropCode.
+type DataDroppedBlock struct {
+	Dropped  bool
+	DropCode byte // One of the Drop* constants; meaningful only if Dropped
+	Len      int  // 1 to 128 packets for normal blocks, 1 to 16 packets for drop blocks
+}
+
+// DataDroppedOption, Section 11.7
+// Like an Ack Vector, the blocks describe consecutive packets in decreasing order of sequence
+// number, starting from the Acknowledgement Number of the packet carrying the option.
+type DataDroppedOption struct {
+	Blocks []DataDroppedBlock
+}
+
+func (opt *DataDroppedOption) Encode() (*Option, error) {
+	if len(opt.Blocks) > 253 {
+		return nil, ErrOverflow
+	}
+	d := make([]byte, len(opt.Blocks))
+	for i, b := range opt.Blocks {
+		if b.Dropped {
+			if b.Len < 1 || b.Len > 16 || b.DropCode > 7 {
+				return nil, ErrOption
+			}
+			d[i] = 0x80 | (b.DropCode << 4) | byte(b.Len-1)
+		} else {
+			if b.Len < 1 || b.Len > 128 {
+				return nil, ErrOption
+			}
+			d[i] = byte(b.Len - 1)
+		}
+	}
+	return &Option{
+		Type:      OptionDataDropped,
+		Data:      d,
+		Mandatory: false,
+	}, nil
+}
+
+func DecodeDataDroppedOption(opt *Option) *DataDroppedOption {
+	if opt.Type != OptionDataDropped {
+		return nil
+	}
+	blocks := make([]DataDroppedBlock, len(opt.Data))
+	for i, b := range opt.Data {
+		if b&0x80 != 0 {
+			blocks[i] = DataDroppedBlock{Dropped: true, DropCode: (b >> 4) & 0x7, Len: int(b&0xf) + 1}
+		} else {
+			blocks[i] = DataDroppedBlock{Len: int(b&0x7f) + 1}
+		}
+	}
+	return &DataDroppedOption{Blocks: blocks}
+}
+
+// dataDropped accumulates the packets whose application data was dropped since the last Data
+// Dropped option was sent
+type dataDropped struct {
+	seqNo    []int64 // Sequence numbers of the dropped packets, in no particular order
+	dropCode []byte
+}
+
+// Record notes that the data of packet seqNo was dropped for reason dropCode
+func (t *dataDropped) Record(seqNo int64, dropCode byte) {
+	t.seqNo = append(t.seqNo, seqNo)
+	t.dropCode = append(t.dropCode, dropCode)
+}
+
+// Option returns a Data Dropped option describing the recorded drops, relative to ackNo, and
+// forgets them. It returns nil if there is nothing to report.
+func (t *dataDropped) Option(ackNo int64) *Option {
+	drops := make(map[int64]byte)
+	low := ackNo + 1
+	for i, q := range t.seqNo {
+		if q <= ackNo {
+			drops[q] = t.dropCode[i]
+			low = min64(low, q)
+		}
+	}
+	t.seqNo, t.dropCode = nil, nil
+	if len(drops) == 0 {
+		return nil
+	}
+	var blocks []DataDroppedBlock
+	for q := ackNo; q >= low && len(blocks) < 253; q-- {
+		code, dropped := drops[q]
+		n := len(blocks)
+		switch {
+		case n > 0 && dropped && blocks[n-1].Dropped && blocks[n-1].DropCode == code && blocks[n-1].Len < 16:
+			blocks[n-1].Len++
+		case n > 0 && !dropped && !blocks[n-1].Dropped && blocks[n-1].Len < 128:
+			blocks[n-1].Len++
+		default:
+			blocks = append(blocks, DataDroppedBlock{Dropped: dropped, DropCode: code, Len: 1})
+		}
+	}
+	opt, err := (&DataDroppedOption{blocks}).Encode()
+	if err != nil {
+		panic("problem encoding data dropped")
+	}
+	return opt
+}
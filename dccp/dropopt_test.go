@@ -0,0 +1,33 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDataDropped(t *testing.T) {
+	var dd dataDropped
+	dd.Record(98, DropReceiveBuffer)
+	dd.Record(97, DropReceiveBuffer)
+	dd.Record(95, DropProtocolConstraints)
+	opt := DecodeDataDroppedOption(dd.Option(100))
+	if opt == nil {
+		t.Fatalf("decoding data dropped")
+	}
+	expect := []DataDroppedBlock{
+		{Len: 2},
+		{Dropped: true, DropCode: DropReceiveBuffer, Len: 2},
+		{Len: 1},
+		{Dropped: true, DropCode: DropProtocolConstraints, Len: 1},
+	}
+	if !reflect.DeepEqual(opt.Blocks, expect) {
+		t.Errorf("expecting %v, encountered %v", expect, opt.Blocks)
+	}
+	if dd.Option(101) != nil {
+		t.Errorf("drops reported twice")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+// Differentiated Services codepoints of the IP header, RFC 2474, that suit DCCP applications,
+// see RFC 4594 and, for real-time media, RFC 8837
+const (
+	DSCPDefault = 0  // Best effort
+	DSCPCS1     = 8  // Lower effort than best effort, RFC 8622
+	DSCPAF41    = 34 // Interactive video
+	DSCPEF      = 46 // Expedited Forwarding, for interactive audio, RFC 3246
+)
+
+// DSCPCarrier is implemented by Links, SegmentConns and HeaderConns that may be able to mark
+// the packets they send with a DSCP. BatchLinks that carry DSCPs honor LinkMsg.DSCP on
+// WriteBatch.
+type DSCPCarrier interface {
+	CarriesDSCP() bool
+}
+
+// dscpMarker is implemented by SegmentConns and HeaderConns that mark the packets they write
+// with the DSCP of their connection
+type dscpMarker interface {
+	DSCPCarrier
+	SetDSCP(dscp byte) error
+}
+
+// carriesDSCP returns true if x implements DSCPCarrier and carries DSCPs
+func carriesDSCP(x interface{}) bool {
+	d, ok := x.(DSCPCarrier)
+	return ok && d.CarriesDSCP()
+}
+
+// SetDSCP marks the packets that the connection sends with the Differentiated Services
+// codepoint dscp, in the TOS byte of IPv4 or the traffic class of IPv6, so that networks that
+// honor it can give the connection, say, Expedited Forwarding. Marking takes a UDPEncap, or a
+// Mux over a UDPLink, on Linux. Elsewhere SetDSCP returns ErrUnsupported.
+func (c *Conn) SetDSCP(dscp byte) error {
+	if dscp > 63 {
+		return ErrInvalid
+	}
+	m, ok := c.hc.(dscpMarker)
+	if !ok || !m.CarriesDSCP() {
+		return ErrUnsupported
+	}
+	return m.SetDSCP(dscp)
+}
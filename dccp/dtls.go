@@ -0,0 +1,162 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// DTLS over DCCP, RFC 5238, places one or more DTLS records in the application data of each
+// DCCP packet and never splits a record across packets. A DTLS library therefore needs a
+// datagram view of the connection, in which every read returns the data of one packet and
+// every write sends one packet, along with an MTU that its records fit in. ReadMsg, WriteMsg
+// and MsgConn provide that view. A MsgConn satisfies both net.Conn and net.PacketConn, which
+// are what DTLS libraries take in place of a UDP socket. With github.com/pion/dtls, for
+// example, a client runs
+//
+//	c, err := dccp.Dial("dccp-udp", "example.com:4433", serviceCode)
+//	...
+//	mc := dccp.NewMsgConn(c)
+//	d, err := dtls.Client(mc, mc.RemoteAddr(), &dtls.Config{MTU: mc.MTU(), ...})
+//
+// and a server passes the connections that its Listener accepts to dtls.Server in the same
+// way. Since DCCP delivers packets unreliably and out of order, the retransmission and replay
+// protection of DTLS work as they do over UDP.
+
+// ReadMsg reads the application data of the next packet into b, whatever Read left of the
+// previous one first, and returns the metadata of the packet in info. If b is too short, the
+// rest of the packet is discarded and ReadMsg returns ErrOverflow along with the part that
+// fits. Other errors are as for Read.
+func (c *Conn) ReadMsg(b []byte) (n int, info *MsgInfo, err error) {
+	c.readRestLk.Lock()
+	defer c.readRestLk.Unlock()
+	p, info, pb := c.readRest, c.readRestInfo, c.readRestBuf
+	c.readRest, c.readRestInfo, c.readRestBuf = nil, nil, nil
+	if len(p) == 0 {
+		m, err := c.readSegment()
+		if errors.Is(err, ErrEOF) {
+			return 0, nil, io.EOF
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		p, info, pb = m.data, m.info, m.buf
+	}
+	n = copy(b, p)
+	pb.release()
+	if n < len(p) {
+		return n, info, ErrOverflow
+	}
+	return n, info, nil
+}
+
+// ReadBorrow is ReadMsg without the copy. It returns the application data of the next packet,
+// whatever Read left of the previous one first, in the buffer that the link read the packet
+// into. Once done with the data, the caller should Release it, so that links which lend their
+// buffers, like the flows of a Mux, reuse the buffer for later packets rather than allocate a
+// new one per packet. Errors are as for Read.
+func (c *Conn) ReadBorrow() (*Borrowed, error) {
+	c.readRestLk.Lock()
+	defer c.readRestLk.Unlock()
+	if len(c.readRest) > 0 {
+		b := &Borrowed{Data: c.readRest, Info: c.readRestInfo, buf: c.readRestBuf}
+		c.readRest, c.readRestInfo, c.readRestBuf = nil, nil, nil
+		return b, nil
+	}
+	m, err := c.readSegment()
+	if errors.Is(err, ErrEOF) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Borrowed{Data: m.data, Info: m.info, buf: m.buf}, nil
+}
+
+// WriteMsg sends b as the application data of a single packet. Unlike Write, it does not
+// split b; it returns ErrTooBig instead if b is longer than GetMTU. The options opts, or the
+// defaults if opts is nil, decide how b fares in the send queue when the application writes
+// faster than the CCID allows.
+func (c *Conn) WriteMsg(b []byte, opts *MsgOptions) (n int, err error) {
+	return c.writeMsg(&outMsg{data: b}, opts, true)
+}
+
+// TryWrite is WriteMsg for event loops: it never blocks, and returns ErrWouldBlock instead
+// where WriteMsg would wait for room in the send queue. Writable tells when to try again.
+func (c *Conn) TryWrite(b []byte, opts *MsgOptions) (n int, err error) {
+	return c.writeMsg(&outMsg{data: b}, opts, false)
+}
+
+// WriteBuffers is WriteMsg for a message in fragments. It sends the fragments of bufs as the
+// application data of a single packet, without concatenating them first. The fragments must
+// not change until the packet is sent.
+func (c *Conn) WriteBuffers(bufs net.Buffers, opts *MsgOptions) (n int, err error) {
+	return c.writeMsg(&outMsg{vec: append([][]byte(nil), bufs...)}, opts, true)
+}
+
+func (c *Conn) writeMsg(m *outMsg, opts *MsgOptions, block bool) (n int, err error) {
+	if m.size() > c.GetMTU() {
+		return 0, ErrTooBig
+	}
+	if err = c.writeSegment(m, opts, block); err != nil {
+		return 0, err
+	}
+	return m.size(), nil
+}
+
+// MsgConn is the datagram view of a Conn, for DTLS libraries and other users that exchange
+// messages rather than a byte stream. Read and ReadFrom behave like ReadMsg, and Write and
+// WriteTo like WriteMsg. The address of ReadFrom is always the remote address of the Conn,
+// and WriteTo ignores its address.
+type MsgConn struct {
+	*Conn
+
+	mtuLk Mutex
+	mtu   int // Least GetMTU of the Conn so far
+}
+
+var (
+	_ net.Conn       = (*MsgConn)(nil)
+	_ net.PacketConn = (*MsgConn)(nil)
+)
+
+// NewMsgConn returns the datagram view of c
+func NewMsgConn(c *Conn) *MsgConn {
+	return &MsgConn{Conn: c, mtu: c.GetMTU()}
+}
+
+// MTU returns the size that messages should not exceed: the least value of GetMTU since the
+// MsgConn was created. Unlike GetMTU, it never grows, so that records sized by an earlier
+// value, such as the MTU that a DTLS library was configured with, keep fitting into packets
+// while MTU probing tries larger ones. It does shrink along with the path MTU.
+func (m *MsgConn) MTU() int {
+	mtu := m.Conn.GetMTU()
+	m.mtuLk.Lock()
+	defer m.mtuLk.Unlock()
+	if mtu < m.mtu {
+		m.mtu = mtu
+	}
+	return m.mtu
+}
+
+// Read implements net.Conn.Read, see ReadMsg
+func (m *MsgConn) Read(b []byte) (int, error) {
+	n, _, err := m.ReadMsg(b)
+	return n, err
+}
+
+// Write implements net.Conn.Write, see WriteMsg
+func (m *MsgConn) Write(b []byte) (int, error) { return m.WriteMsg(b, nil) }
+
+// ReadFrom implements net.PacketConn.ReadFrom
+func (m *MsgConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, _, err := m.ReadMsg(b)
+	return n, m.RemoteAddr(), err
+}
+
+// WriteTo implements net.PacketConn.WriteTo
+func (m *MsgConn) WriteTo(b []byte, addr net.Addr) (int, error) { return m.Write(b) }
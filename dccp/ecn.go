@@ -0,0 +1,48 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "net"
+
+// ECN codepoints of the IP header, RFC 3168. They are passed to and from the transport in
+// Header.ECN.
+const (
+	ECNNotECT = 0 // Not ECN-Capable Transport
+	ECNECT1   = 1 // ECN-Capable Transport, ECT(1)
+	ECNECT0   = 2 // ECN-Capable Transport, ECT(0)
+	ECNCE     = 3 // Congestion Experienced
+)
+
+// ECNLink is implemented by Links that can read and write the ECN codepoint of the IP header
+type ECNLink interface {
+	Link
+
+	// ReadFromECN behaves like ReadFrom, and additionally returns the ECN codepoint of the packet
+	ReadFromECN(buf []byte) (n int, addr net.Addr, ecn byte, err error)
+
+	// WriteToECN behaves like WriteTo, and additionally sets the ECN codepoint of the packet
+	WriteToECN(buf []byte, addr net.Addr, ecn byte) (n int, err error)
+}
+
+// ECNCarrier is implemented by SegmentConns and HeaderConns that may be able to carry the ECN
+// codepoint of the IP header. HeaderConns that carry ECN honor Header.ECN on Write and fill it
+// in on Read. If the transport underlying a connection does not carry ECN, the connection
+// negotiates the ECN Incapable feature, Section 12.1.
+type ECNCarrier interface {
+	CarriesECN() bool
+}
+
+// ecnSegmentConn is implemented by SegmentConns that can pass ECN codepoints alongside blocks
+type ecnSegmentConn interface {
+	ECNCarrier
+	ReadECN() (block []byte, ecn byte, err error)
+	WriteECN(block []byte, ecn byte) error
+}
+
+// carriesECN returns true if x implements ECNCarrier and carries ECN codepoints
+func carriesECN(x interface{}) bool {
+	e, ok := x.(ECNCarrier)
+	return ok && e.CarriesECN()
+}
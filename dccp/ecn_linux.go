@@ -0,0 +1,74 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// enableRecvTOS asks the kernel to pass the TOS byte, or the traffic class, of the packets
+// that c receives in a control message, see IP_RECVTOS in ip(7), so that parseTOS can recover
+// their ECN codepoints
+func enableRecvTOS(c syscall.Conn) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var err4, err6 error
+	err = rc.Control(func(fd uintptr) {
+		// An IPv6 socket takes both, the first for IPv4-mapped addresses
+		err4 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTOS, 1)
+		err6 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVTCLASS, 1)
+	})
+	if err != nil {
+		return err
+	}
+	if err4 != nil && err6 != nil {
+		return err4
+	}
+	return nil
+}
+
+// parseTOS returns the TOS byte, or the traffic class, in the control messages oob of a
+// received packet. It returns false if there is none.
+func parseTOS(oob []byte) (byte, bool) {
+	hlen := syscall.CmsgLen(0)
+	for len(oob) >= hlen {
+		h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+		n := int(h.Len)
+		if n <= hlen || n > len(oob) {
+			break
+		}
+		switch {
+		case h.Level == syscall.IPPROTO_IP && h.Type == syscall.IP_TOS:
+			// IP_TOS comes as a single byte
+			return oob[hlen], true
+		case h.Level == syscall.IPPROTO_IPV6 && h.Type == syscall.IPV6_TCLASS && n >= hlen+4:
+			return byte(*(*int32)(unsafe.Pointer(&oob[hlen]))), true
+		}
+		if next := syscall.CmsgSpace(n - hlen); next < len(oob) {
+			oob = oob[next:]
+		} else {
+			break
+		}
+	}
+	return 0, false
+}
+
+// putTOS places in oob a control message that sets the TOS byte of an IPv4 packet, or the
+// traffic class of an IPv6 one, to tos, and returns its length. oob must hold at least
+// syscall.CmsgSpace(4) bytes, aligned for a syscall.Cmsghdr.
+func putTOS(oob []byte, ip4 bool, tos byte) int {
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level, h.Type = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+	if ip4 {
+		// Sockets of family AF_INET6 take IP_TOS for IPv4-mapped destinations
+		h.Level, h.Type = syscall.IPPROTO_IP, syscall.IP_TOS
+	}
+	h.SetLen(syscall.CmsgLen(4))
+	*(*int32)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])) = int32(tos)
+	return syscall.CmsgSpace(4)
+}
@@ -0,0 +1,18 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package dccp
+
+import "syscall"
+
+// enableRecvTOS fails, as the ECN codepoints of received packets are only available on Linux
+func enableRecvTOS(c syscall.Conn) error { return ErrUnsupported }
+
+// parseTOS returns false, as there are no control messages with the TOS byte
+func parseTOS(oob []byte) (byte, bool) { return 0, false }
+
+// putTOS places no control message in oob and returns zero
+func putTOS(oob []byte, ip4 bool, tos byte) int { return 0 }
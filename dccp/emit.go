@@ -23,7 +23,9 @@ func (c *Conn) emitCatchSeqNo(h *Header, seqNos ...int64) {
 	}
 }
 
+// emitSetState records the new state of the connection in its traces, and reports it to the
+// function set by OnEvent
 func (c *Conn) emitSetState() {
-	c.AssertLocked()
 	c.amb.SetState(c.socket.GetState())
+	c.postStateEvent()
 }
@@ -5,7 +5,9 @@
 package dccp
 
 import (
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 	"github.com/petar/GoGauge/filter"
 )
@@ -18,11 +20,20 @@ type Env struct {
 	filter  *filter.Filter
 	gojoin  *GoJoin
 
+	traceFilter atomic.Value // Holds the *TraceFilter of SetTraceFilter, by pointer
+
 	sync.Mutex
+	pcap     *PcapWriter // Where the connections of the Env save their packets, or nil
 	timeZero int64 // Time when execution started
 	timeLast int64 // Time of last log message
+	seed     int64      // Seed of rand
+	rand     *rand.Rand // Source of the random choices of the connections of the Env
+
+	virtual *virtualClock // Time of the Env, if virtual, or nil
+	wheel   *timerWheel   // Timer wheel of a virtual Env, created on first use
 }
 
+// NewEnv returns an Env whose random choices are seeded from the current time
 func NewEnv(guzzle TraceWriter) *Env {
 	now := time.Now().UnixNano()
 	r := &Env{
@@ -32,20 +43,95 @@ func NewEnv(guzzle TraceWriter) *Env {
 		timeZero: now,
 		timeLast: now,
 	}
+	r.SetSeed(now)
+	return r
+}
+
+// NewVirtualEnv returns an Env, like NewEnv, whose time is virtual rather than real. Virtual
+// time starts at the current real time, and advances only once all the goroutines of the Env
+// are waiting on it: at once to the earliest time that one of them sleeps until, through Sleep,
+// SleepOrDone, Expire, a deadline of a Conn or a read deadline of the sandbox. Simulations that
+// would take seconds of real time run in as long as it takes to process their packets. The
+// absolute times of Conn.SetReadDeadline and the like are read on the clock of the Env, see Now.
+//
+// The goroutines of the Env are those that Go starts and the goroutine that calls
+// NewVirtualEnv, see virtualClock. They wait on the Env when they sleep on it, Join its
+// goroutines, or wait in the methods of its connections; channels of their own they wait on
+// through Wait and Woken. A goroutine of the Env that blocks otherwise, for instance on a
+// channel that the Env does not know about, holds the clock still until it runs again.
+func NewVirtualEnv(guzzle TraceWriter) *Env {
+	r := NewEnv(guzzle)
+	r.virtual = newVirtualClock(r.timeZero)
+	r.gojoin = newGoJoin(r, 1, "Env")
 	return r
 }
 
-// Go runs f in a new GoRoutine. The GoRoutine is also added to the GoJoin of the Env.
-func (t *Env) Go(f func(), fmt_ string, args_ ...interface{}) {
-	t.gojoin.Go(f, fmt_, args_...)
+// Virtual returns true if the time of the Env is virtual, see NewVirtualEnv
+func (t *Env) Virtual() bool {
+	return t.virtual != nil
+}
+
+// SetSeed restarts the source of the random choices of the Env, such as the initial
+// sequence numbers of its connections and the jitter of their Request retransmissions, from
+// seed. Simulations that set the same seed make the same choices in the same order.
+func (t *Env) SetSeed(seed int64) {
+	t.Lock()
+	defer t.Unlock()
+	t.seed = seed
+	t.rand = rand.New(rand.NewSource(seed))
+}
+
+// Seed returns the seed of the random choices of the Env, see SetSeed
+func (t *Env) Seed() int64 {
+	t.Lock()
+	defer t.Unlock()
+	return t.seed
+}
+
+// Int63n returns a random number in [0,n) from the source of the Env
+func (t *Env) Int63n(n int64) int64 {
+	t.Lock()
+	defer t.Unlock()
+	return t.rand.Int63n(n)
+}
+
+// Float64 returns a random number in [0,1) from the source of the Env
+func (t *Env) Float64() float64 {
+	t.Lock()
+	defer t.Unlock()
+	return t.rand.Float64()
+}
+
+// Go runs f in a new GoRoutine, which it returns. The GoRoutine is also added to the GoJoin of
+// the Env.
+func (t *Env) Go(f func(), fmt_ string, args_ ...interface{}) *GoRoutine {
+	g := goCaller(t, f, 1, fmt_, args_...)
+	t.gojoin.Add(g)
+	return g
+}
+
+// timerWheel returns the timer wheel that fires the protocol timers of the connections of the
+// Env. All Envs of real time share one wheel. A virtual Env has a wheel of its own, which runs
+// on its clock, and whose goroutine is among those of the Env while timers are pending.
+func (t *Env) timerWheel() *timerWheel {
+	if t.virtual == nil {
+		return realWheel
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.wheel == nil {
+		t.wheel = newTimerWheel(t)
+	}
+	return t.wheel
 }
 
 func (t *Env) Joiner() Joiner {
 	return t.gojoin
 }
 
+// NewGoJoin returns a GoJoin of group, whose Join waits on the Env, see NewVirtualEnv
 func (t *Env) NewGoJoin(annotation string, group ...Joiner) *GoJoin {
-	return NewGoJoin(annotation, group...)
+	return newGoJoin(t, 1, annotation, group...)
 }
 
 func (t *Env) TraceWriter() TraceWriter {
@@ -60,34 +146,157 @@ func (t *Env) Sync() error {
 	return t.guzzle.Sync()
 }
 
+// SetPcap makes the connections created from now on save the packets that they send and
+// receive to w, see Conn.SetPcap. Close closes w.
+func (t *Env) SetPcap(w *PcapWriter) {
+	t.Lock()
+	defer t.Unlock()
+	t.pcap = w
+}
+
+// Pcap returns the PcapWriter set by SetPcap, or nil
+func (t *Env) Pcap() *PcapWriter {
+	t.Lock()
+	defer t.Unlock()
+	return t.pcap
+}
+
 func (t *Env) Close() error {
+	if t.virtual != nil {
+		t.virtual.stop()
+	}
+	if w := t.Pcap(); w != nil {
+		w.Close()
+	}
 	return t.guzzle.Close()
 }
 
-func (t *Env) Now() int64 {
+// Now returns the current time of the Env, which is virtual in an Env of NewVirtualEnv
+func (t *Env) Now() time.Time {
+	return time.Unix(0, t.nowNano())
+}
+
+// nowNano returns the current time of the Env in nanoseconds since the Unix epoch, which the
+// arithmetic of the protocol uses
+func (t *Env) nowNano() int64 {
+	if t.virtual != nil {
+		return t.virtual.Now()
+	}
 	return time.Now().UnixNano()
 }
 
-func (t *Env) Sleep(ns int64) {
-	time.Sleep(time.Duration(ns))
+// Sleep sleeps for d, on the time of the Env
+func (t *Env) Sleep(d time.Duration) {
+	if t.virtual != nil {
+		if d > 0 {
+			s := t.virtual.Sleep(int64(d))
+			t.Wait(&s.track)
+			<-s.ch
+			t.Woken(&s.track)
+		}
+		return
+	}
+	time.Sleep(d)
+}
+
+// SleepOrDone sleeps for d, or until done is closed, whichever comes first. It returns false
+// if it was cut short by done. On virtual time, k accounts for done, see Wait.
+func (t *Env) SleepOrDone(d time.Duration, done <-chan struct{}, k *Track) bool {
+	if t.virtual != nil {
+		s := t.virtual.Sleep(int64(d))
+		t.Wait(&s.track, k)
+		defer t.Woken(&s.track, k)
+		select {
+		case <-s.ch:
+			return true
+		case <-done:
+			t.virtual.Cancel(s)
+			return false
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Wait tells the clock of a virtual Env that the calling goroutine is about to block on the
+// channels that ks account for, and on nothing else. Once the goroutine stops blocking, it
+// calls Woken with the same ks, and then Received for the message that it took, if any. The
+// senders call Sent after each send, and Closed after the close. On real time, the methods do
+// nothing. Nil Tracks are skipped.
+func (t *Env) Wait(ks ...*Track) {
+	if t != nil && t.virtual != nil {
+		t.virtual.Wait(ks)
+	}
+}
+
+// Woken ends a wait on the channels that ks account for, see Wait
+func (t *Env) Woken(ks ...*Track) {
+	if t != nil && t.virtual != nil {
+		t.virtual.Woken(ks)
+	}
+}
+
+// Sent records that a message was sent on the channel that k accounts for, see Wait
+func (t *Env) Sent(k *Track) {
+	if t != nil && t.virtual != nil {
+		t.virtual.Sent(k)
+	}
+}
+
+// Received records that a message was received from the channel that k accounts for, see Wait
+func (t *Env) Received(k *Track) {
+	if t != nil && t.virtual != nil {
+		t.virtual.Received(k)
+	}
+}
+
+// Closed records that the channel that k accounts for was closed, see Wait
+func (t *Env) Closed(k *Track) {
+	if t != nil && t.virtual != nil {
+		t.virtual.Closed(k)
+	}
+}
+
+// release records the close of the channel that k accounts for, and ends the waits on it of
+// goroutines that do not call Woken, like those of Conn.Writable
+func (t *Env) release(k *Track) {
+	if t != nil && t.virtual != nil {
+		t.virtual.Release(k)
+	}
+}
+
+// wakeup places a token on ch, which k accounts for, unless one is there already
+func (t *Env) wakeup(ch chan struct{}, k *Track) {
+	select {
+	case ch <- struct{}{}:
+		t.Sent(k)
+	default:
+	}
 }
 
-func (t *Env) Snap() (sinceZero int64, sinceLast int64) {
+// Snap returns the time since the Env was created, and since the last call to Snap
+func (t *Env) Snap() (sinceZero time.Duration, sinceLast time.Duration) {
 	t.Lock()
 	defer t.Unlock()
 
-	logTime := t.Now()
+	logTime := t.nowNano()
 	timeLast := t.timeLast
 	t.timeLast = logTime
-	return logTime - t.timeZero, logTime - timeLast
+	return time.Duration(logTime - t.timeZero), time.Duration(logTime - timeLast)
 }
 
 // Expire periodically, on every interval duration, checks if the test condition has been met. If
 // the condition is met within the timeout period, no further action is taken. Otherwise, the
 // onexpire function is invoked.
-func (t *Env) Expire(test func()bool, onexpire func(), timeout, interval int64, fmt_ string, args_ ...interface{}) {
+func (t *Env) Expire(test func()bool, onexpire func(), timeout, interval time.Duration, fmt_ string, args_ ...interface{}) {
 	t.Go(func() {
-		k := timeout / interval
+		k := int64(timeout / interval)
 		if k <= 0 {
 			panic("frequency too small")
 		}
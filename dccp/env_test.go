@@ -0,0 +1,94 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"testing"
+	"time"
+)
+
+// TestEnvSeed checks that Envs with the same seed make the same random choices, and that
+// Envs with different seeds do not
+func TestEnvSeed(t *testing.T) {
+	draw := func(seed int64) []float64 {
+		env := NewEnv(nullTraceWriter{})
+		env.SetSeed(seed)
+		if env.Seed() != seed {
+			t.Errorf("seed %d, expected %d", env.Seed(), seed)
+		}
+		var x []float64
+		for i := 0; i < 10; i++ {
+			x = append(x, float64(env.Int63n(1e6)), env.Float64())
+		}
+		return x
+	}
+	a, b, c := draw(7), draw(7), draw(8)
+	same := true
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("draw %d is %g and %g with the same seed", i, a[i], b[i])
+		}
+		same = same && a[i] == c[i]
+	}
+	if same {
+		t.Errorf("different seeds make the same draws")
+	}
+}
+
+// TestVirtualEnv checks that the time of a virtual Env advances to the wake-up times of its
+// sleepers, in order, without waiting for them in real time
+func TestVirtualEnv(t *testing.T) {
+	env := NewVirtualEnv(nullTraceWriter{})
+	defer env.Close()
+	start, t0 := time.Now(), env.Now()
+	woken := make(chan time.Duration, 3)
+	var wokenT Track
+	for _, d := range []time.Duration{3 * time.Second, time.Second, 2 * time.Second} {
+		d := d
+		env.Go(func() {
+			env.Sleep(d)
+			woken <- env.Now().Sub(t0)
+			env.Sent(&wokenT)
+		}, "sleeper")
+	}
+	for _, expect := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		env.Wait(&wokenT)
+		got := <-woken
+		env.Woken(&wokenT)
+		env.Received(&wokenT)
+		if got != expect {
+			t.Errorf("woken at %s, expected %s", got, expect)
+		}
+	}
+	if done := make(chan struct{}); env.SleepOrDone(time.Second, done, nil) != true || env.Now().Sub(t0) != 4*time.Second {
+		t.Errorf("slept until %s, expected %s", env.Now().Sub(t0), 4*time.Second)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("4 seconds of virtual time took %s", elapsed)
+	}
+}
+
+// TestVirtualEnvBusy checks that the clock of a virtual Env stands still while a goroutine of
+// the Env is busy, however long it takes, and moves on once the goroutine waits
+func TestVirtualEnvBusy(t *testing.T) {
+	env := NewVirtualEnv(nullTraceWriter{})
+	defer env.Close()
+	t0 := env.Now()
+	env.Go(func() { env.Sleep(time.Second) }, "sleeper")
+	var busy time.Duration
+	env.Go(func() {
+		// Work, rather than wait, for a while of real time
+		for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+		}
+		busy = env.Now().Sub(t0)
+	}, "busy")
+	env.Joiner().Join()
+	if busy != 0 {
+		t.Errorf("clock moved by %s while a goroutine was busy", busy)
+	}
+	if d := env.Now().Sub(t0); d != time.Second {
+		t.Errorf("clock at %s after the sleep, expected %s", d, time.Second)
+	}
+}
@@ -4,12 +4,22 @@
 
 package dccp
 
+import "fmt"
+
 // ProtoError is a type that wraps all DCCP-specific errors.
-// It is utilized to distinguish these errors from others, using type checks.
+// It is utilized to distinguish these errors from others, with errors.As, which finds a
+// ProtoError also inside the errors that wrap one, like PacketError.
 type ProtoError string
 
 func (e ProtoError) Error() string { return string(e) }
 
+// Timeout returns true for ErrTimeout, so that it satisfies net.Error
+func (e ProtoError) Timeout() bool { return e == ErrTimeout }
+
+// Temporary returns true for ErrTimeout, ErrFull and ErrWouldBlock, so that it satisfies
+// net.Error
+func (e ProtoError) Temporary() bool { return e == ErrTimeout || e == ErrFull || e == ErrWouldBlock }
+
 func NewError(s string) error { return ProtoError(s) }
 
 // TODO: Annotate each error with the circumstances that can cause it
@@ -33,17 +43,89 @@ var (
 	ErrReset         = NewError("reset")
 	ErrTooBig        = NewError("too big")
 	ErrOverflow      = NewError("overflow")
+	ErrInUse         = NewError("in use")	// A service code is already served by another Listener
 )
 
+// PacketError is the error of a packet that cannot be read. It carries the Type of the packet,
+// and its Sequence Number if it was read before the failure, and it wraps the error that tells
+// the failure, like ErrChecksum or ErrOption, so that errors.Is(err, ErrChecksum) holds.
+type PacketError struct {
+	Type  byte  // Packet Type
+	SeqNo int64 // Sequence Number, or -1 if the failure came before it was read
+	Err   error
+}
+
+func newPacketError(h *Header, seqno bool, err error) *PacketError {
+	e := &PacketError{Type: h.Type, SeqNo: -1, Err: err}
+	if seqno {
+		e.SeqNo = h.SeqNo
+	}
+	return e
+}
+
+func (e *PacketError) Error() string {
+	if e.SeqNo < 0 {
+		return fmt.Sprintf("%s packet: %s", TypeString(e.Type), e.Err)
+	}
+	return fmt.Sprintf("%s packet %d: %s", TypeString(e.Type), e.SeqNo, e.Err)
+}
+
+func (e *PacketError) Unwrap() error { return e.Err }
+
 // Connection errors
 var (
-	ErrEOF     = NewError("i/o eof")
-	ErrAbort   = NewError("i/o aborted")
-	ErrTimeout = NewError("i/o timeout")
-	ErrBad     = NewError("i/o bad connection")
-	ErrIO      = NewError("i/o error")
+	ErrEOF             = NewError("i/o eof")
+	ErrAbort           = NewError("i/o aborted")
+	ErrTimeout         = NewError("i/o timeout")
+	ErrBad             = NewError("i/o bad connection")
+	ErrIO              = NewError("i/o error")
+	ErrFull            = NewError("i/o send queue full")  // Under SendError, see SetSendQueue
+	ErrWouldBlock      = NewError("i/o would block")      // See TryWrite
+	ErrPeerUnreachable = NewError("i/o peer unreachable") // Probes went unanswered, see SetPeerProbe
+	ErrIdleTimeout     = NewError("i/o idle timeout")     // Nothing was received for too long, see SetIdleTimeout
 )
 
+// ResetError is the error of a connection that was torn down by a Reset from the other side,
+// Section 5.6. It matches ErrReset and ErrAbort under errors.Is, as well as any *ResetError
+// with the same Code, so that applications can tell, say, a refused connection from an
+// aborted one with errors.Is(err, &ResetError{Code: ResetConnectionRefused}).
+type ResetError struct {
+	Code   byte   // Reset Code
+	Data   []byte // Reset Data, the three bytes that qualify the Reset Code
+	Reason string // Application data of the Reset, if any
+	SeqNo  int64  // Sequence Number of the Reset
+	AckNo  int64  // Acknowledgement Number of the Reset
+}
+
+func newResetError(h *Header) *ResetError {
+	return &ResetError{
+		Code:   h.ResetCode,
+		Data:   append([]byte(nil), h.ResetData...),
+		Reason: string(h.Data),
+		SeqNo:  h.SeqNo,
+		AckNo:  h.AckNo,
+	}
+}
+
+func (e *ResetError) Error() string {
+	s := "i/o reset (" + resetCodeString(e.Code) + ")"
+	if e.Reason != "" {
+		s += ": " + e.Reason
+	}
+	return s
+}
+
+// Is makes errors.Is(e, target) true for ErrReset, ErrAbort and Reset errors with the same Code
+func (e *ResetError) Is(target error) bool {
+	switch t := target.(type) {
+	case ProtoError:
+		return t == ErrReset || t == ErrAbort
+	case *ResetError:
+		return t.Code == e.Code
+	}
+	return false
+}
+
 // Congestion Control errors/events
 
 // CongestionReset is sent from Congestion Control to Conn to indicate that
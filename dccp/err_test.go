@@ -0,0 +1,73 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestResetError(t *testing.T) {
+	h := &Header{Type: Reset, SeqNo: 7, AckNo: 5, ResetCode: ResetConnectionRefused, ResetData: []byte{0, 0, 0}, Data: []byte("no")}
+	var err error = newResetError(h)
+	if !errors.Is(err, ErrReset) || !errors.Is(err, ErrAbort) {
+		t.Errorf("reset error does not match ErrReset and ErrAbort")
+	}
+	if errors.Is(err, ErrEOF) {
+		t.Errorf("reset error matches ErrEOF")
+	}
+	if !errors.Is(err, &ResetError{Code: ResetConnectionRefused}) {
+		t.Errorf("reset error does not match its code")
+	}
+	if errors.Is(err, &ResetError{Code: ResetBadServiceCode}) {
+		t.Errorf("reset error matches another code")
+	}
+	var re *ResetError
+	if !errors.As(fmt.Errorf("dial: %w", err), &re) {
+		t.Fatalf("wrapped reset error not found")
+	}
+	if re.Reason != "no" || re.SeqNo != 7 || re.AckNo != 5 {
+		t.Errorf("unexpected reset error %+v", re)
+	}
+}
+
+func TestPacketError(t *testing.T) {
+	src, dst := []byte{1, 2, 3, 4}, []byte{5, 6, 7, 8}
+	h := &Header{Type: Ack, X: true, SeqNo: 7, AckNo: 5}
+	buf, err := h.Write(src, dst, 34, false)
+	if err != nil {
+		t.Fatalf("write (%s)", err)
+	}
+
+	// A checksum failure comes before the Sequence Number is read
+	buf[len(buf)-1]++
+	_, err = ReadHeader(buf, src, dst, 34, false)
+	var pe *PacketError
+	if !errors.As(err, &pe) || pe.Type != Ack || pe.SeqNo != -1 || !errors.Is(err, ErrChecksum) {
+		t.Errorf("unexpected checksum error %#v", err)
+	}
+	var proto ProtoError
+	if !errors.As(err, &proto) || proto != ErrChecksum {
+		t.Errorf("checksum error does not hold a ProtoError")
+	}
+
+	// An option failure comes after; a Mandatory option that ends the options is made by
+	// overwriting the last of four single-byte options, which fills the four bytes after the
+	// 24 of the header
+	slow := &Option{Type: OptionSlowReceiver, Data: []byte{}}
+	h.Options = []*Option{slow, slow, slow, slow}
+	if buf, err = h.Write(src, dst, 34, false); err != nil {
+		t.Fatalf("write (%s)", err)
+	}
+	buf[27] = OptionMandatory
+	_, err = ParseHeader(buf)
+	if !errors.As(err, &pe) || pe.SeqNo != 7 || !errors.Is(err, ErrOption) {
+		t.Errorf("unexpected option error %#v", err)
+	}
+	if s := err.Error(); s != "Ack packet 7: mandatory option at end of options: option" {
+		t.Errorf("unexpected option error message %q", s)
+	}
+}
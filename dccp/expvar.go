@@ -0,0 +1,58 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"expvar"
+	"sync"
+)
+
+var publishOnce sync.Once
+
+// PublishExpvar publishes the process-wide counters, see ReadCounters, as the expvar map
+// "godccp", which services that import net/http/pprof or expvar serve at /debug/vars. Nothing
+// is published until PublishExpvar is called; calling it again has no effect.
+func PublishExpvar() {
+	publishOnce.Do(func() {
+		m := expvar.NewMap("godccp")
+		m.Set("ActiveConns", expvar.Func(func() interface{} { return ReadCounters().ActiveConns }))
+		m.Set("Handshakes", expvar.Func(func() interface{} { return ReadCounters().Handshakes }))
+		m.Set("Retransmits", expvar.Func(func() interface{} { return ReadCounters().Retransmits }))
+		m.Set("OptionsParsed", expvar.Func(func() interface{} { return ReadCounters().OptionsParsed }))
+		m.Set("ChecksumFailures", expvar.Func(func() interface{} { return ReadCounters().ChecksumFailures }))
+		m.Set("PacketsSent", expvar.Func(func() interface{} {
+			return typeCounts(ReadCounters().PacketsSent)
+		}))
+		m.Set("PacketsReceived", expvar.Func(func() interface{} {
+			return typeCounts(ReadCounters().PacketsReceived)
+		}))
+		m.Set("ResetsSent", expvar.Func(func() interface{} {
+			return resetCodeCounts(ReadCounters().ResetsSent)
+		}))
+		m.Set("ResetsReceived", expvar.Func(func() interface{} {
+			return resetCodeCounts(ReadCounters().ResetsReceived)
+		}))
+	})
+}
+
+// typeCounts maps the names of packet types to their counts
+func typeCounts(n [NumTypes]int64) map[string]int64 {
+	r := make(map[string]int64, NumTypes)
+	for t, v := range n {
+		r[TypeString(byte(t))] = v
+	}
+	return r
+}
+
+// resetCodeCounts maps the names of the Reset Codes that have been counted to their counts
+func resetCodeCounts(n [256]int64) map[string]int64 {
+	r := make(map[string]int64)
+	for code, v := range n {
+		if v != 0 {
+			r[ResetCodeString(byte(code))] = v
+		}
+	}
+	return r
+}
@@ -0,0 +1,51 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"testing"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	PublishExpvar()
+	PublishExpvar()
+	v := expvar.Get("godccp")
+	if v == nil {
+		t.Fatalf("godccp not published")
+	}
+
+	src, dst := []byte{1, 2, 3, 4}, []byte{5, 6, 7, 8}
+	h := &Header{Type: Ack, X: true, SeqNo: 7, AckNo: 5, Options: []*Option{{Type: OptionSlowReceiver, Data: []byte{}}}}
+	buf, err := h.Write(src, dst, 34, false)
+	if err != nil {
+		t.Fatalf("write (%s)", err)
+	}
+	before := ReadCounters()
+	if _, err := ReadHeader(buf, src, dst, 34, false); err != nil {
+		t.Fatalf("read (%s)", err)
+	}
+	buf[len(buf)-1]++
+	if _, err := ReadHeader(buf, src, dst, 34, false); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expecting %s, encountered %v", ErrChecksum, err)
+	}
+	after := ReadCounters()
+	if after.OptionsParsed-before.OptionsParsed < 1 || after.ChecksumFailures-before.ChecksumFailures != 1 {
+		t.Errorf("before %+v, after %+v", before, after)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(v.String()), &m); err != nil {
+		t.Fatalf("godccp is not JSON (%s): %s", err, v.String())
+	}
+	if n, _ := m["ChecksumFailures"].(float64); n < 1 {
+		t.Errorf("godccp %s", v.String())
+	}
+	if _, ok := m["PacketsSent"].(map[string]interface{})["DataAck"]; !ok {
+		t.Errorf("godccp %s", v.String())
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "fmt"
+
+// FeatureInfo is the negotiation state of a feature at both endpoints, see Conn.GetFeature.
+// Each endpoint is the location of a feature of the same number, Section 6.
+type FeatureInfo struct {
+	Feature       byte   // Feature number, like FeatureSequenceWindow
+	Local         uint64 // Value of the feature located at this endpoint
+	Remote        uint64 // Value of the feature located at the other endpoint
+	LocalPending  bool   // Whether a Change of the local feature awaits its Confirm
+	RemotePending bool   // Whether a Change of the remote feature awaits its Confirm
+}
+
+func (f FeatureInfo) String() string {
+	s := fmt.Sprintf("%s: local %d", featureString(f.Feature), f.Local)
+	if f.LocalPending {
+		s += " (changing)"
+	}
+	s += fmt.Sprintf(", remote %d", f.Remote)
+	if f.RemotePending {
+		s += " (changing)"
+	}
+	return s
+}
+
+// GetFeature returns the negotiation state of feature n. It returns ErrUnsupported for
+// features that this implementation does not know, whose values are always the defaults.
+func (c *Conn) GetFeature(n byte) (FeatureInfo, error) {
+	if featureSpecs[n] == nil {
+		return FeatureInfo{}, ErrUnsupported
+	}
+	var fi FeatureInfo
+	c.do(func() { fi = c.featureInfo(n) })
+	return fi, nil
+}
+
+// Features returns the negotiation state of all features that this implementation knows, in
+// order of feature number. Printed one per line, it makes a dump of the negotiated values.
+func (c *Conn) Features() []FeatureInfo {
+	var r []FeatureInfo
+	c.do(func() {
+		for n, spec := range featureSpecs {
+			if spec != nil {
+				r = append(r, c.featureInfo(byte(n)))
+			}
+		}
+	})
+	return r
+}
+
+func (c *Conn) featureInfo(n byte) FeatureInfo {
+	return FeatureInfo{
+		Feature:       n,
+		Local:         c.features.Local(n),
+		Remote:        c.features.Remote(n),
+		LocalPending:  c.features.Pending(true, n),
+		RemotePending: c.features.Pending(false, n),
+	}
+}
+
+// ChangeFeature starts the negotiation of feature n, located at this endpoint if local is true
+// and at the other endpoint otherwise, Section 6.6. For a non-negotiable feature, which only its
+// location can change, values holds the new value. For a server-priority feature, it is the
+// preference list, most preferred value first. Called before the handshake completes,
+// ChangeFeature sets the values that the handshake negotiates in place of the defaults;
+// called later, it renegotiates the feature, as the protocol allows at any time.
+//
+// A connection cannot switch CCIDs, so the CCID is chosen by the CCID it is created with, see
+// Dialer.CCID, and ChangeFeature returns ErrUnsupported for FeatureCCID. It does the same for
+// an Allow Short Seqnos of one, and for unknown features. Invalid values give ErrInvalid.
+func (c *Conn) ChangeFeature(n byte, local bool, values ...uint64) error {
+	switch n {
+	case FeatureCCID:
+		return ErrUnsupported
+	case FeatureAllowShortSeqNos:
+		for _, v := range values {
+			if v != 0 {
+				return ErrUnsupported
+			}
+		}
+	}
+	if featureSpecs[n] == nil {
+		return ErrUnsupported
+	}
+	if len(values) == 0 {
+		return ErrInvalid
+	}
+	var err error
+	c.do(func() {
+		switch {
+		case c.socket.GetState() == CLOSED:
+			err = ErrBad
+		case n == FeatureECNIncapable && local && !c.ecn && values[0] == 0:
+			// A link that cannot carry ECN codepoints keeps ECN off, see newConn
+			err = ErrInvalid
+		default:
+			err = c.features.change(local, n, values)
+		}
+	})
+	return err
+}
+
+// SetAckRatio asks the other side to acknowledge every r data packets of ours, Section 11.3.
+// Sender CCIDs that adjust the Ack Ratio themselves, like CCID2, replace it with their own
+// choice when they next change it.
+func (c *Conn) SetAckRatio(r uint16) error {
+	if r == 0 {
+		return ErrInvalid
+	}
+	return c.ChangeFeature(FeatureAckRatio, true, uint64(r))
+}
+
+// FeatureString returns the name of feature n, like "Sequence Window"
+func FeatureString(n byte) string { return featureString(n) }
+
+func featureString(n byte) string {
+	switch n {
+	case FeatureCCID:
+		return "CCID"
+	case FeatureAllowShortSeqNos:
+		return "Allow Short Seqnos"
+	case FeatureSequenceWindow:
+		return "Sequence Window"
+	case FeatureECNIncapable:
+		return "ECN Incapable"
+	case FeatureAckRatio:
+		return "Ack Ratio"
+	case FeatureSendAckVector:
+		return "Send Ack Vector"
+	case FeatureSendNDPCount:
+		return "Send NDP Count"
+	case FeatureMinimumChecksumCoverage:
+		return "Minimum Checksum Coverage"
+	case FeatureCheckDataChecksum:
+		return "Check Data Checksum"
+	}
+	return fmt.Sprintf("Feature %d", n)
+}
@@ -0,0 +1,76 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+// FeatureOption represents the Change L, Confirm L, Change R and Confirm R options, Section 6.1
+// The option data consists of a feature number, followed by zero or more feature values, whose
+// encoding depends on the feature.
+type FeatureOption struct {
+	Type    byte   // One of OptionChangeL, OptionConfirmL, OptionChangeR or OptionConfirmR
+	Feature byte   // Feature number
+	Values  []byte // Encoded feature value(s)
+}
+
+func isFeatureOption(optionType byte) bool {
+	return optionType >= OptionChangeL && optionType <= OptionConfirmR
+}
+
+func isChangeOption(optionType byte) bool {
+	return optionType == OptionChangeL || optionType == OptionChangeR
+}
+
+func (opt *FeatureOption) Encode() (*Option, error) {
+	if !isFeatureOption(opt.Type) {
+		return nil, ErrOption
+	}
+	if len(opt.Values) > 255-3 {
+		return nil, ErrOverflow
+	}
+	d := make([]byte, 1+len(opt.Values))
+	d[0] = opt.Feature
+	copy(d[1:], opt.Values)
+	return &Option{
+		Type:      opt.Type,
+		Data:      d,
+		Mandatory: false,
+	}, nil
+}
+
+func DecodeFeatureOption(opt *Option) *FeatureOption {
+	if !isFeatureOption(opt.Type) || len(opt.Data) < 1 {
+		return nil
+	}
+	// A Change option with no values is invalid, Section 6.6.8
+	if isChangeOption(opt.Type) && len(opt.Data) < 2 {
+		return nil
+	}
+	return &FeatureOption{
+		Type:    opt.Type,
+		Feature: opt.Data[0],
+		Values:  opt.Data[1:],
+	}
+}
+
+// encodeNNValue encodes the non-negotiable value v in a big-endian field of length l bytes
+func encodeNNValue(v uint64, l int) []byte {
+	d := make([]byte, l)
+	for i := l - 1; i >= 0; i-- {
+		d[i] = byte(v & 0xff)
+		v >>= 8
+	}
+	return d
+}
+
+// decodeNNValue decodes a non-negotiable value. It returns ErrSize if d is not l bytes long.
+func decodeNNValue(d []byte, l int) (uint64, error) {
+	if len(d) != l {
+		return 0, ErrSize
+	}
+	var v uint64
+	for _, b := range d {
+		v = (v << 8) | uint64(b)
+	}
+	return v, nil
+}
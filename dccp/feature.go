@@ -0,0 +1,374 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "bytes"
+
+// Feature numbers, Section 6.4
+const (
+	FeatureCCID                    = 1
+	FeatureAllowShortSeqNos        = 2
+	FeatureSequenceWindow          = 3
+	FeatureECNIncapable            = 4
+	FeatureAckRatio                = 5
+	FeatureSendAckVector           = 6
+	FeatureSendNDPCount            = 7
+	FeatureMinimumChecksumCoverage = 8
+	FeatureCheckDataChecksum       = 9
+	// Reserved 10 to 127
+	// CCID-specific 128 to 255
+)
+
+// Reconciliation rules, Section 6.3
+const (
+	featureNN = iota // Non-negotiable: the feature location decides, the remote confirms
+	featureSP        // Server-priority: the first server preference supported by the client wins
+)
+
+// featureSpec describes a feature that is understood by this implementation
+type featureSpec struct {
+	Kind    int               // featureNN or featureSP
+	Len     int               // Length in bytes of an encoded value of an NN feature
+	Default uint64            // Initial value of the feature, Section 6.4
+	Valid   func(uint64) bool // Valid returns true if the argument is an acceptable value
+	Prefs   []byte            // Initial preference list of a server-priority feature, or nil
+}
+
+var featureSpecs = [256]*featureSpec{
+	// The CCID feature located at an endpoint selects the CCID of the half-connection on
+	// which that endpoint is the HC-Sender, Section 10
+	FeatureCCID: &featureSpec{
+		Kind:    featureSP,
+		Default: CCID2,
+		Valid:   knownCCID,
+	},
+	FeatureSequenceWindow: &featureSpec{
+		Kind:    featureNN,
+		Len:     6,
+		Default: SEQWIN_INIT,
+		Valid:   func(v uint64) bool { return v >= SEQWIN_MIN && v <= SEQWIN_MAX },
+	},
+	// Short sequence numbers are neither sent nor accepted, so zero is the only value offered,
+	// Section 7.6.1
+	FeatureAllowShortSeqNos: &featureSpec{
+		Kind:    featureSP,
+		Default: 0,
+		Valid:   func(v uint64) bool { return v <= 1 },
+		Prefs:   []byte{0},
+	},
+	FeatureAckRatio: &featureSpec{
+		Kind:    featureNN,
+		Len:     2,
+		Default: 2,
+		Valid:   func(v uint64) bool { return v >= 1 && v <= 0xffff },
+	},
+	// Both values are acceptable, so that a peer that cannot carry ECN can always
+	// switch it off, Section 12.1
+	FeatureECNIncapable: &featureSpec{
+		Kind:    featureSP,
+		Default: 0,
+		Valid:   func(v uint64) bool { return v <= 1 },
+		Prefs:   []byte{0, 1},
+	},
+	// Any coverage that the receiver asks for is acceptable to the sender, Section 9.2.1
+	FeatureMinimumChecksumCoverage: &featureSpec{
+		Kind:    featureSP,
+		Default: 0,
+		Valid:   func(v uint64) bool { return v <= 15 },
+		Prefs:   []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	},
+	// Data Checksum options are sent whenever the receiver asks for them, Section 9.3.1
+	FeatureCheckDataChecksum: &featureSpec{
+		Kind:    featureSP,
+		Default: 0,
+		Valid:   func(v uint64) bool { return v <= 1 },
+		Prefs:   []byte{0, 1},
+	},
+	// NDP Count options are sent whenever the other endpoint asks for them, Section 7.7.2
+	FeatureSendNDPCount: &featureSpec{
+		Kind:    featureSP,
+		Default: 0,
+		Valid:   func(v uint64) bool { return v <= 1 },
+		Prefs:   []byte{0, 1},
+	},
+	// Ack Vectors are sent whenever the HC-Sender asks for them, Section 11.5
+	FeatureSendAckVector: &featureSpec{
+		Kind:    featureSP,
+		Default: 0,
+		Valid:   func(v uint64) bool { return v <= 1 },
+		Prefs:   []byte{0, 1},
+	},
+}
+
+// featureState holds the negotiation state of a feature at one of the two locations
+type featureState struct {
+	value  uint64 // Current value
+	prefs  []byte // Preference list of a server-priority feature, or nil for the current value only
+	change []byte // Values carried by the outstanding Change option, or nil if none is outstanding
+}
+
+// featureSet holds the feature values of a connection and drives their negotiation, Section 6.
+// Change options are resent on every outgoing packet until they are confirmed, while Confirm
+// options are sent once in response to each received Change, unless they are held. featureSet's
+// methods are not re-entrant.
+type featureSet struct {
+	local        [256]*featureState // Features located at this endpoint
+	remote       [256]*featureState // Features located at the other endpoint
+	confirms     []*FeatureOption   // Confirm options waiting to be sent
+	holdConfirms bool               // If set, Confirm options are sent on every packet
+}
+
+// Init resets all features to their default values
+func (t *featureSet) Init() {
+	for n, spec := range featureSpecs {
+		if spec == nil {
+			continue
+		}
+		t.local[n] = &featureState{value: spec.Default, prefs: spec.Prefs}
+		t.remote[n] = &featureState{value: spec.Default, prefs: spec.Prefs}
+	}
+	t.confirms = nil
+}
+
+// HoldConfirms controls whether Confirm options are repeated on every outgoing packet, rather
+// than sent once. A client holds its Confirms while in PARTOPEN, since a lost Confirm cannot
+// be recovered by the server before the handshake completes, Section 6.6.3.
+func (t *featureSet) HoldConfirms(hold bool) {
+	t.holdConfirms = hold
+}
+
+func (t *featureSet) state(local bool, n byte) *featureState {
+	if local {
+		return t.local[n]
+	}
+	return t.remote[n]
+}
+
+// Local returns the current value of feature n, located at this endpoint
+func (t *featureSet) Local(n byte) uint64 { return t.local[n].value }
+
+// Remote returns the current value of feature n, located at the other endpoint
+func (t *featureSet) Remote(n byte) uint64 { return t.remote[n].value }
+
+// ChangeLocal starts the negotiation of feature n, located at this endpoint. For
+// non-negotiable features, only the first value is used. For server-priority features,
+// values is the preference list, most preferred value first.
+func (t *featureSet) ChangeLocal(n byte, values ...uint64) error {
+	return t.change(true, n, values)
+}
+
+// ChangeRemote starts the negotiation of feature n, located at the other endpoint. It is
+// only applicable to server-priority features.
+func (t *featureSet) ChangeRemote(n byte, values ...uint64) error {
+	return t.change(false, n, values)
+}
+
+func (t *featureSet) change(local bool, n byte, values []uint64) error {
+	spec := featureSpecs[n]
+	if spec == nil || len(values) == 0 {
+		return ErrInvalid
+	}
+	for _, v := range values {
+		if !spec.Valid(v) {
+			return ErrInvalid
+		}
+	}
+	s := t.state(local, n)
+	switch spec.Kind {
+	case featureNN:
+		// Only the feature location can change a non-negotiable feature, Section 6.3.2
+		if !local {
+			return ErrInvalid
+		}
+		s.change = encodeNNValue(values[0], spec.Len)
+	case featureSP:
+		s.prefs = make([]byte, len(values))
+		for i, v := range values {
+			s.prefs[i] = byte(v)
+		}
+		s.change = s.prefs
+	}
+	return nil
+}
+
+// Pending returns true if a Change for feature n at the given location awaits confirmation
+func (t *featureSet) Pending(local bool, n byte) bool {
+	s := t.state(local, n)
+	return s != nil && s.change != nil
+}
+
+// Options returns the feature negotiation options that should be placed on an outgoing packet
+// of type Type. Feature negotiation options are not permitted on Data packets, Section 6.
+func (t *featureSet) Options(Type byte) []*Option {
+	if Type == Data || Type == Reset {
+		return nil
+	}
+	var r []*Option
+	for n, s := range t.local {
+		if s != nil && s.change != nil {
+			r = append(r, encodeFeatureOption(&FeatureOption{OptionChangeL, byte(n), s.change}))
+		}
+	}
+	for n, s := range t.remote {
+		if s != nil && s.change != nil {
+			r = append(r, encodeFeatureOption(&FeatureOption{OptionChangeR, byte(n), s.change}))
+		}
+	}
+	for _, c := range t.confirms {
+		r = append(r, encodeFeatureOption(c))
+	}
+	if !t.holdConfirms {
+		t.confirms = nil
+	}
+	return r
+}
+
+func encodeFeatureOption(fo *FeatureOption) *Option {
+	opt, err := fo.Encode()
+	if err != nil {
+		panic("problem encoding feature option")
+	}
+	return opt
+}
+
+// OnRead processes the feature negotiation options received from the other endpoint. The
+// argument server indicates whether this endpoint is the server, which matters for the
+// reconciliation of server-priority features. OnRead returns ErrOption if the other endpoint
+// sent a malformed option or an invalid value, in which case the connection must be reset
+// with Reset Code Option Error, Section 6.6.8.
+func (t *featureSet) OnRead(opts []*Option, server bool) error {
+	for _, o := range opts {
+		if !isFeatureOption(o.Type) {
+			continue
+		}
+		fo := DecodeFeatureOption(o)
+		if fo == nil {
+			return ErrOption
+		}
+		var err error
+		switch fo.Type {
+		case OptionChangeL:
+			err = t.onChange(false, fo, server)
+		case OptionChangeR:
+			err = t.onChange(true, fo, server)
+		case OptionConfirmL:
+			err = t.onConfirm(false, fo)
+		case OptionConfirmR:
+			err = t.onConfirm(true, fo)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onChange processes a Change option. The argument local indicates whether the feature is
+// located at this endpoint, i.e. whether the option received was a Change R.
+func (t *featureSet) onChange(local bool, fo *FeatureOption, server bool) error {
+	confirmType := byte(OptionConfirmR)
+	if local {
+		confirmType = OptionConfirmL
+	}
+	spec := featureSpecs[fo.Feature]
+	// Unknown features, as well as Change R options for non-negotiable features, are answered
+	// with an empty Confirm, Sections 6.6.7 and 6.3.2
+	if spec == nil || (spec.Kind == featureNN && local) {
+		t.queueConfirm(&FeatureOption{Type: confirmType, Feature: fo.Feature})
+		return nil
+	}
+	s := t.state(local, fo.Feature)
+	switch spec.Kind {
+	case featureNN:
+		v, err := decodeNNValue(fo.Values, spec.Len)
+		if err != nil || !spec.Valid(v) {
+			return ErrOption
+		}
+		s.value = v
+		t.queueConfirm(&FeatureOption{confirmType, fo.Feature, fo.Values})
+	case featureSP:
+		prefs := s.prefs
+		if prefs == nil {
+			prefs = []byte{byte(s.value)}
+		}
+		var chosen byte
+		var ok bool
+		if server {
+			chosen, ok = reconcileSP(prefs, fo.Values, spec)
+		} else {
+			chosen, ok = reconcileSP(fo.Values, prefs, spec)
+		}
+		// If there is no shared value, the feature keeps its previous value, Section 6.3.1
+		if !ok {
+			chosen = byte(s.value)
+		}
+		s.value = uint64(chosen)
+		// A received Change supersedes our own outstanding Change for the same feature
+		s.change = nil
+		t.queueConfirm(&FeatureOption{confirmType, fo.Feature, append([]byte{chosen}, prefs...)})
+	}
+	return nil
+}
+
+// reconcileSP returns the first value in the server's preference list that also appears in the
+// client's preference list, Section 6.3.1
+func reconcileSP(serverPrefs, clientPrefs []byte, spec *featureSpec) (byte, bool) {
+	for _, v := range serverPrefs {
+		if spec.Valid(uint64(v)) && bytes.IndexByte(clientPrefs, v) >= 0 {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// onConfirm processes a Confirm option. The argument local indicates whether the feature is
+// located at this endpoint, i.e. whether the option received was a Confirm R.
+func (t *featureSet) onConfirm(local bool, fo *FeatureOption) error {
+	spec := featureSpecs[fo.Feature]
+	if spec == nil {
+		return nil
+	}
+	s := t.state(local, fo.Feature)
+	// Confirm options for features that are not being negotiated are ignored, Section 6.6.4
+	if s.change == nil {
+		return nil
+	}
+	// An empty Confirm means that the other endpoint does not understand the feature
+	if len(fo.Values) == 0 {
+		s.change = nil
+		return nil
+	}
+	switch spec.Kind {
+	case featureNN:
+		v, err := decodeNNValue(fo.Values, spec.Len)
+		if err != nil {
+			return ErrOption
+		}
+		// A Confirm for a previous Change does not complete the negotiation
+		if !bytes.Equal(fo.Values, s.change) {
+			return nil
+		}
+		s.value = v
+	case featureSP:
+		v := uint64(fo.Values[0])
+		if !spec.Valid(v) {
+			return ErrOption
+		}
+		s.value = v
+	}
+	s.change = nil
+	return nil
+}
+
+// queueConfirm schedules fo for sending, replacing any queued Confirm for the same feature
+func (t *featureSet) queueConfirm(fo *FeatureOption) {
+	for i, c := range t.confirms {
+		if c.Type == fo.Type && c.Feature == fo.Feature {
+			t.confirms[i] = fo
+			return
+		}
+	}
+	t.confirms = append(t.confirms, fo)
+}
@@ -0,0 +1,191 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFeatureOption(t *testing.T) {
+	original := &FeatureOption{OptionChangeL, FeatureSequenceWindow, encodeNNValue(SEQWIN_FIXED, 6)}
+	encoded, err := original.Encode()
+	if err != nil {
+		t.Fatalf("encoding option (%s)", err)
+	}
+	decoded := DecodeFeatureOption(encoded)
+	if !reflect.DeepEqual(original, decoded) {
+		t.Fatalf("expecting %v, encountered %v", original, decoded)
+	}
+	if v, _ := decodeNNValue(decoded.Values, 6); v != SEQWIN_FIXED {
+		t.Errorf("expecting %d, encountered %d", SEQWIN_FIXED, v)
+	}
+}
+
+// exchangeFeatures delivers the feature options of a to b, and returns true if any were sent
+func exchangeFeatures(t *testing.T, a, b *featureSet, bServer bool) bool {
+	opts := a.Options(Ack)
+	if err := b.OnRead(opts, bServer); err != nil {
+		t.Fatalf("feature negotiation (%s)", err)
+	}
+	return len(opts) > 0
+}
+
+func TestSequenceWindowNegotiation(t *testing.T) {
+	var client, server featureSet
+	client.Init()
+	server.Init()
+	if err := client.ChangeLocal(FeatureSequenceWindow, 500); err != nil {
+		t.Fatalf("change (%s)", err)
+	}
+	if client.ChangeLocal(FeatureSequenceWindow, 5) == nil {
+		t.Errorf("accepted out-of-range sequence window")
+	}
+	for i := 0; i < 3; i++ {
+		exchangeFeatures(t, &client, &server, true)
+		exchangeFeatures(t, &server, &client, false)
+	}
+	if client.Pending(true, FeatureSequenceWindow) {
+		t.Errorf("change not confirmed")
+	}
+	if v := client.Local(FeatureSequenceWindow); v != 500 {
+		t.Errorf("client local: expecting 500, encountered %d", v)
+	}
+	if v := server.Remote(FeatureSequenceWindow); v != 500 {
+		t.Errorf("server remote: expecting 500, encountered %d", v)
+	}
+	if v := server.Local(FeatureSequenceWindow); v != SEQWIN_INIT {
+		t.Errorf("server local: expecting %d, encountered %d", SEQWIN_INIT, v)
+	}
+}
+
+func TestFeatureUnknown(t *testing.T) {
+	var a featureSet
+	a.Init()
+	change, _ := (&FeatureOption{OptionChangeL, 200, []byte{1}}).Encode()
+	if err := a.OnRead([]*Option{change}, true); err != nil {
+		t.Fatalf("unknown feature (%s)", err)
+	}
+	opts := a.Options(Ack)
+	if len(opts) != 1 {
+		t.Fatalf("expecting one confirm, encountered %d options", len(opts))
+	}
+	confirm := DecodeFeatureOption(opts[0])
+	if confirm.Type != OptionConfirmR || confirm.Feature != 200 || len(confirm.Values) != 0 {
+		t.Errorf("expecting empty Confirm R, encountered %v", confirm)
+	}
+}
+
+func TestECNIncapableNegotiation(t *testing.T) {
+	var client, server featureSet
+	client.Init()
+	server.Init()
+	if err := client.ChangeLocal(FeatureECNIncapable, 1); err != nil {
+		t.Fatalf("change (%s)", err)
+	}
+	for i := 0; i < 3; i++ {
+		exchangeFeatures(t, &client, &server, true)
+		exchangeFeatures(t, &server, &client, false)
+	}
+	if client.Pending(true, FeatureECNIncapable) {
+		t.Errorf("change not confirmed")
+	}
+	if v := client.Local(FeatureECNIncapable); v != 1 {
+		t.Errorf("client local: expecting 1, encountered %d", v)
+	}
+	if v := server.Remote(FeatureECNIncapable); v != 1 {
+		t.Errorf("server remote: expecting 1, encountered %d", v)
+	}
+	if v := server.Local(FeatureECNIncapable); v != 0 {
+		t.Errorf("server local: expecting 0, encountered %d", v)
+	}
+}
+
+func TestAckRatioNegotiation(t *testing.T) {
+	var client, server featureSet
+	client.Init()
+	server.Init()
+	if client.ChangeLocal(FeatureAckRatio, 0) == nil {
+		t.Errorf("accepted zero ack ratio")
+	}
+	if err := client.ChangeLocal(FeatureAckRatio, 7); err != nil {
+		t.Fatalf("change (%s)", err)
+	}
+	for i := 0; i < 3; i++ {
+		exchangeFeatures(t, &client, &server, true)
+		exchangeFeatures(t, &server, &client, false)
+	}
+	if v := client.Local(FeatureAckRatio); v != 7 {
+		t.Errorf("client local: expecting 7, encountered %d", v)
+	}
+	if v := server.Remote(FeatureAckRatio); v != 7 {
+		t.Errorf("server remote: expecting 7, encountered %d", v)
+	}
+	if v := server.Local(FeatureAckRatio); v != 2 {
+		t.Errorf("server local: expecting 2, encountered %d", v)
+	}
+}
+
+func TestCCIDNegotiation(t *testing.T) {
+	var client, server featureSet
+	client.Init()
+	server.Init()
+	client.ChangeLocal(FeatureCCID, CCID3)
+	client.ChangeRemote(FeatureCCID, CCID4)
+	server.ChangeLocal(FeatureCCID, CCID4)
+	server.ChangeRemote(FeatureCCID, CCID2)
+	for i := 0; i < 3; i++ {
+		exchangeFeatures(t, &client, &server, true)
+		exchangeFeatures(t, &server, &client, false)
+	}
+	// The server's preference wins where the client did not propose the same CCID
+	if v, w := client.Local(FeatureCCID), server.Remote(FeatureCCID); v != w {
+		t.Errorf("client-to-server CCID: client has %d, server has %d", v, w)
+	}
+	if v, w := client.Remote(FeatureCCID), server.Local(FeatureCCID); v != CCID4 || w != CCID4 {
+		t.Errorf("server-to-client CCID: expecting %d, encountered %d and %d", CCID4, v, w)
+	}
+	if client.ChangeLocal(FeatureCCID, 100) == nil {
+		t.Errorf("accepted unknown CCID")
+	}
+}
+
+func TestAllowShortSeqNosNegotiation(t *testing.T) {
+	var client, server featureSet
+	client.Init()
+	server.Init()
+	// The other side would rather use short sequence numbers, which we do not
+	change, _ := (&FeatureOption{OptionChangeL, FeatureAllowShortSeqNos, []byte{1, 0}}).Encode()
+	if err := server.OnRead([]*Option{change}, true); err != nil {
+		t.Fatalf("change (%s)", err)
+	}
+	exchangeFeatures(t, &server, &client, false)
+	if v := server.Remote(FeatureAllowShortSeqNos); v != 0 {
+		t.Errorf("server remote: expecting 0, encountered %d", v)
+	}
+	if v := client.Local(FeatureAllowShortSeqNos); v != 0 {
+		t.Errorf("client local: expecting 0, encountered %d", v)
+	}
+}
+
+func TestHoldConfirms(t *testing.T) {
+	var a featureSet
+	a.Init()
+	a.HoldConfirms(true)
+	change, _ := (&FeatureOption{OptionChangeL, 200, []byte{1}}).Encode()
+	if err := a.OnRead([]*Option{change}, false); err != nil {
+		t.Fatalf("change (%s)", err)
+	}
+	for i := 0; i < 2; i++ {
+		if n := len(a.Options(Ack)); n != 1 {
+			t.Errorf("held confirm: expecting one option, encountered %d", n)
+		}
+	}
+	a.HoldConfirms(false)
+	a.Options(Ack)
+	if n := len(a.Options(Ack)); n != 0 {
+		t.Errorf("released confirm: expecting no options, encountered %d", n)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "net"
+
+// RequestInfo describes an incoming Request to a RequestFilter
+type RequestInfo struct {
+	RemoteAddr  net.Addr  // Address of the client, as returned by Conn.RemoteAddr
+	LinkAddr    net.Addr  // Address of the client on the underlying link, e.g. a UDP address, or nil if unknown
+	ServiceCode ServiceCode // Service code that the server chose among those the client offered
+	Options     []*Option // Options of the Request
+}
+
+// FilterAction is the decision of a RequestFilter about a Request
+type FilterAction int
+
+const (
+	FilterAccept FilterAction = iota // Proceed with the handshake
+	FilterDrop                       // Ignore the Request silently
+	FilterReject                     // Answer the Request with a Reset
+)
+
+// RequestFilter decides on the Requests that open new connections. A Listener consults its
+// filter on the Request of every incoming flow, before a connection is created for it, and a
+// server connection of NewConnServer consults its filter on every Request it receives in
+// LISTEN state. If the filter returns FilterReject, the Request is answered with a Reset with
+// Reset Code resetCode. A RequestFilter must not block, since it holds up the Requests that
+// follow.
+type RequestFilter func(r *RequestInfo) (action FilterAction, resetCode byte)
+
+// SetRequestFilter makes a server connection of NewConnServer pass Requests through f. It must
+// be called before the connection receives its first Request.
+func (c *Conn) SetRequestFilter(f RequestFilter) error {
+	err := ErrInvalid
+	c.do(func() {
+		if c.socket.IsServer() {
+			c.requestFilter = f
+			err = nil
+		}
+	})
+	return err
+}
+
+// SetRequestFilter makes the Listener pass the Requests of incoming flows through f, before
+// their connections are created. It affects the Requests that arrive after the call.
+func (l *Listener) SetRequestFilter(f RequestFilter) {
+	l.Lock()
+	defer l.Unlock()
+	l.requestFilter = f
+}
+
+// filterRequest applies the request filter to the Request h, for which serviceCode was
+// chosen. It returns ErrDrop if the Request is not to be processed further.
+func (c *Conn) filterRequest(h *Header, serviceCode ServiceCode) error {
+	if c.requestFilter == nil {
+		return nil
+	}
+	action, resetCode := c.requestFilter(newRequestInfo(c.hc, h, serviceCode))
+	switch action {
+	case FilterAccept:
+		return nil
+	case FilterReject:
+		c.amb.E(EventWarn, "Request rejected by filter", h)
+		c.inject(c.generateAbnormalReset(resetCode, h))
+	default:
+		c.amb.E(EventDrop, "Request dropped by filter", h)
+	}
+	return ErrDrop
+}
+
+// newRequestInfo describes the Request h, for which serviceCode was chosen, arriving on hc
+func newRequestInfo(hc HeaderConn, h *Header, serviceCode ServiceCode) *RequestInfo {
+	return &RequestInfo{
+		RemoteAddr:  remoteAddr(hc),
+		LinkAddr:    linkAddr(hc),
+		ServiceCode: serviceCode,
+		Options:     h.Options,
+	}
+}
+
+// linkAddresser is implemented by SegmentConns and HeaderConns that know the link address
+// of the other side
+type linkAddresser interface {
+	LinkAddr() net.Addr
+}
+
+func linkAddr(x interface{}) net.Addr {
+	if la, ok := x.(linkAddresser); ok {
+		return la.LinkAddr()
+	}
+	return nil
+}
+
+// LinkAddr implements linkAddresser.LinkAddr
+func (f *flow) LinkAddr() net.Addr { return f.addr }
+
+// LinkAddr implements linkAddresser.LinkAddr
+func (hc *headerConn) LinkAddr() net.Addr { return linkAddr(hc.bc) }
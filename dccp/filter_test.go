@@ -0,0 +1,50 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestPortFilter checks that a Port creates no connection for the Requests that its filter
+// drops or rejects
+func TestPortFilter(t *testing.T) {
+	alink, dlink := NewChanPipe()
+	l := NewListener(alink, CCFixed{})
+	defer l.Close()
+	filtered := make(chan ServiceCode, 10)
+	l.SetRequestFilter(func(r *RequestInfo) (FilterAction, byte) {
+		filtered <- r.ServiceCode
+		if r.ServiceCode == 1 {
+			return FilterReject, ResetConnectionRefused
+		}
+		return FilterDrop, 0
+	})
+	s := NewStack(dlink, CCFixed{})
+	rejected, _ := s.Dial(nil, 1)
+	dropped, _ := s.Dial(nil, 2)
+	defer dropped.Abort()
+
+	refused := &ResetError{Code: ResetConnectionRefused}
+	if err := rejected.WaitOpen(); !errors.Is(err, refused) {
+		t.Errorf("rejected: expecting %s, encountered %v", refused, err)
+	}
+	// The filter sees the first Request of the dropped client, and its retransmission
+	for i := 0; i < 3; i++ {
+		select {
+		case <-filtered:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%d requests filtered, expected 3", i)
+		}
+	}
+	l.port.Lock()
+	n := len(l.port.conns)
+	l.port.Unlock()
+	if n != 0 {
+		t.Errorf("%d connections for filtered requests", n)
+	}
+}
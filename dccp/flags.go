@@ -9,7 +9,8 @@ import (
 )
 
 // Flags is a general purpose key-value map, which is used inside Amb to allow
-// an Amb and all of its refinements to share a collection of debug flags.
+// an Amb and all of its refinements to share a collection of debug flags. A nil Flags, like
+// that of NoLogging, reads as empty.
 type Flags struct {
 	sync.Mutex
 	flags map[string]interface{}
@@ -42,6 +43,9 @@ func (x *Flags) SetUint32(key string, value uint32) {
 }
 
 func (x *Flags) Has(key string) bool {
+	if x == nil {
+		return false
+	}
 	x.Lock()
 	defer x.Unlock()
 	_, ok := x.flags[key]
@@ -49,6 +53,9 @@ func (x *Flags) Has(key string) bool {
 }
 
 func (x *Flags) GetInt64(key string) (value int64, present bool) {
+	if x == nil {
+		return 0, false
+	}
 	x.Lock()
 	defer x.Unlock()
 	v, ok := x.flags[key]
@@ -59,6 +66,9 @@ func (x *Flags) GetInt64(key string) (value int64, present bool) {
 }
 
 func (x *Flags) GetUint32(key string) (value uint32, present bool) {
+	if x == nil {
+		return 0, false
+	}
 	x.Lock()
 	defer x.Unlock()
 	v, ok := x.flags[key]
@@ -14,6 +14,7 @@ type flow struct {
 	addr net.Addr
 	m    *Mux
 	ch   chan muxHeader
+	done chan struct{} // Closed when the flow is closed; ch itself is never closed
 	mtu  int
 
 	Mutex        // protects the variables below
@@ -22,6 +23,7 @@ type flow struct {
 	lastRead     time.Time
 	lastWrite    time.Time
 	readDeadline time.Time
+	dscp         byte
 
 	rlk Mutex // synchronizes calls to Read()
 }
@@ -41,6 +43,7 @@ func newFlow(addr net.Addr, m *Mux, ch chan muxHeader, mtu int, local, remote *L
 		readDeadline: time.Now().Add(-time.Second),	// time in the past
 		m:            m,
 		ch:           ch,
+		done:         make(chan struct{}),
 		mtu:          mtu,
 	}
 }
@@ -49,13 +52,13 @@ func newFlow(addr net.Addr, m *Mux, ch chan muxHeader, mtu int, local, remote *L
 func (f *flow) GetMTU() int { return f.mtu }
 
 // SetReadExpire implements SegmentConn.SetReadExpire
-func (f *flow) SetReadExpire(nsec int64) error {
-	if nsec < 0 {
+func (f *flow) SetReadExpire(d time.Duration) error {
+	if d < 0 {
 		return ErrInvalid
 	}
 	f.Lock()
 	defer f.Unlock()
-	f.readDeadline = time.Now().Add(time.Duration(nsec))
+	f.readDeadline = time.Now().Add(d)
 	return nil
 }
 
@@ -106,13 +109,18 @@ func (f *flow) String() string {
 
 // Write implements SegmentConn.Write
 func (f *flow) Write(block []byte) error {
+	return f.WriteECN(block, ECNNotECT)
+}
+
+// WriteECN writes block, setting the ECN codepoint of the carrying IP packet to ecn
+func (f *flow) WriteECN(block []byte, ecn byte) error {
 	f.Lock()
-	m := f.m
+	m, dscp := f.m, f.dscp
 	f.Unlock()
 	if m == nil {
 		return ErrBad
 	}
-	err := m.write(&muxMsg{f.getLocal(), f.getRemote()}, block, f.addr)
+	err := m.write(&muxMsg{f.getLocal(), f.getRemote()}, block, ecn, dscp, f.addr)
 	if err != nil {
 		f.Lock()
 		f.lastWrite = time.Now()
@@ -121,18 +129,53 @@ func (f *flow) Write(block []byte) error {
 	return err
 }
 
+// CarriesECN implements ECNCarrier.CarriesECN
+func (f *flow) CarriesECN() bool {
+	f.Lock()
+	m := f.m
+	f.Unlock()
+	return m != nil && m.carriesECN()
+}
+
+// CarriesDSCP implements DSCPCarrier.CarriesDSCP
+func (f *flow) CarriesDSCP() bool {
+	f.Lock()
+	m := f.m
+	f.Unlock()
+	return m != nil && m.carriesDSCP()
+}
+
+// SetDSCP marks the packets that the flow writes with dscp
+func (f *flow) SetDSCP(dscp byte) error {
+	f.Lock()
+	defer f.Unlock()
+	f.dscp = dscp
+	return nil
+}
+
 // Read implements SegmentConn.Read
 func (f *flow) Read() (block []byte, err error) {
+	block, _, err = f.ReadECN()
+	return block, err
+}
+
+// ReadECN reads the next block, along with the ECN codepoint of the carrying IP packet
+func (f *flow) ReadECN() (block []byte, ecn byte, err error) {
+	block, ecn, _, err = f.readBuf()
+	return block, ecn, err
+}
+
+// readBuf implements bufSegmentConn.readBuf
+func (f *flow) readBuf() (block []byte, ecn byte, rb *packetBuf, err error) {
 	f.rlk.Lock()
 	defer f.rlk.Unlock()
 
 	f.Lock()
-	ch := f.ch
 	readDeadline := f.readDeadline
 	f.Unlock()
 	readTimeout := readDeadline.Sub(time.Now())
-	if ch == nil {
-		return nil, ErrBad
+	if isClosed(f.done) {
+		return nil, 0, nil, ErrBad
 	}
 
 	var timer *time.Timer
@@ -144,40 +187,48 @@ func (f *flow) Read() (block []byte, err error) {
 	}
 
 	var header muxHeader
-	var ok bool
 	select {
-	case header, ok = <-ch:
-		if !ok {
-			return nil, ErrIO
-		}
+	case header = <-f.ch:
+	case <-f.done:
+		return nil, 0, nil, ErrIO
 	case <-tmoch:
-		return nil, ErrTimeout
+		return nil, 0, nil, ErrTimeout
 	}
 
 	f.Lock()
 	f.lastRead = time.Now()
 	f.Unlock()
 
-	return header.Cargo, nil
+	return header.Cargo, header.ECN, header.Buf, nil
 }
 
 func (f *flow) foreclose() {
 	f.Lock()
 	defer f.Unlock()
 
-	if f.ch != nil {
-		close(f.ch)
-		f.ch = nil
+	f.shut()
+}
+
+// shut closes the done channel, unless it is already closed. f must be locked.
+func (f *flow) shut() {
+	if !isClosed(f.done) {
+		close(f.done)
+	}
+}
+
+// deliver passes h on to the reader of the flow. It returns without delivering, rather than
+// block forever, if the flow is closed.
+func (f *flow) deliver(h muxHeader) {
+	select {
+	case f.ch <- h:
+	case <-f.done:
 	}
 }
 
 // Close implements SegmentConn.Close
 func (f *flow) Close() error {
 	f.Lock()
-	if f.ch != nil {
-		close(f.ch)
-		f.ch = nil
-	}
+	f.shut()
 	m := f.m
 	f.m = nil
 	f.Unlock()
@@ -187,3 +238,18 @@ func (f *flow) Close() error {
 	m.del(f.getLocal(), f.getRemote())
 	return nil
 }
+
+// Reclaim implements Reclaimer.Reclaim. It closes the flow and lets the mux forget its labels
+// without lingering. A flow that has already been closed cannot be reclaimed.
+func (f *flow) Reclaim() error {
+	f.Lock()
+	f.shut()
+	m := f.m
+	f.m = nil
+	f.Unlock()
+	if m == nil {
+		return ErrBad
+	}
+	m.reclaim(f.getLocal(), f.getRemote())
+	return nil
+}
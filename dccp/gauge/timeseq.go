@@ -0,0 +1,279 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package gauge
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// TimeSeq is a dccp.TraceWriter that reduces the packet traces of a run to the time-sequence
+// data of each flow, in the manner of tcptrace: when the packets of the flow are sent, when
+// they are lost on the line, and how far the acknowledgements of the other side reach, against
+// time. It also tallies the rates at which each flow sends and delivers, over bins of time.
+//
+// A flow is the packets sent by one endpoint, as named by the first label of its traces. Line
+// traces are labeled by the side that writes to the line, and they only count as losses.
+type TimeSeq struct {
+	sync.Mutex
+	bin    int64 // Length of the rate bins in nanoseconds
+	flows  map[string]*Flow
+	sender map[int64]string // SeqNo —> Endpoint that sent it
+}
+
+// Flow is the time-sequence data of the packets that one endpoint sends. DCCP sequence numbers
+// count packets, not bytes, so the sequence numbers of a Flow are in packets, offset from the
+// first packet that the flow sends.
+type Flow struct {
+	Endpoint string
+	Peer     string // Endpoint that reads the flow, once it reads a packet
+	ISN      int64  // Sequence number of the first packet sent
+	Sent     []SeqPoint
+	Acked    []SeqPoint // The acknowledgement number each time that it grows
+	Lost     []SeqPoint
+	bin      int64
+	rates    map[int64]*RatePoint
+}
+
+// SeqPoint is a packet of a Flow, or an acknowledgement of one, at a point in time
+type SeqPoint struct {
+	Time  int64  // Time in nanoseconds
+	SeqNo int64  // Offset from the ISN of the flow
+	Type  string // Type of the packet
+}
+
+// RatePoint counts the packets, and the bytes of application data, that a Flow sends and
+// delivers in the bin of time that starts at Time
+type RatePoint struct {
+	Time            int64
+	SentPackets     int64
+	SentBytes       int64
+	ReceivedPackets int64
+	ReceivedBytes   int64
+}
+
+// NewTimeSeq creates a TimeSeq that tallies rates over bins of length bin
+func NewTimeSeq(bin time.Duration) *TimeSeq {
+	if bin <= 0 {
+		panic("non-positive rate bin")
+	}
+	return &TimeSeq{
+		bin:    int64(bin),
+		flows:  make(map[string]*Flow),
+		sender: make(map[int64]string),
+	}
+}
+
+func (t *TimeSeq) Write(r *dccp.Trace) {
+	if r.Type == "" || len(r.Labels) == 0 {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	if r.Labels[0] == "line" {
+		if r.Event != dccp.EventDrop || len(r.Labels) < 2 {
+			return
+		}
+		if f, ok := t.flows[r.Labels[1]]; ok {
+			f.Lost = append(f.Lost, SeqPoint{r.Time, f.offset(r.SeqNo), r.Type})
+		}
+		return
+	}
+	if len(r.Labels) > 1 {
+		return
+	}
+	endpoint := r.Labels[0]
+	var dataLen int64
+	if info, ok := r.HeaderInfo(); ok {
+		dataLen = int64(info.DataLen)
+	}
+	switch r.Event {
+	case dccp.EventWrite:
+		f, ok := t.flows[endpoint]
+		if !ok {
+			f = &Flow{Endpoint: endpoint, ISN: r.SeqNo, bin: t.bin, rates: make(map[int64]*RatePoint)}
+			t.flows[endpoint] = f
+		}
+		t.sender[r.SeqNo] = endpoint
+		f.Sent = append(f.Sent, SeqPoint{r.Time, f.offset(r.SeqNo), r.Type})
+		p := f.ratePoint(r.Time)
+		p.SentPackets++
+		p.SentBytes += dataLen
+	case dccp.EventRead:
+		if sender, ok := t.sender[r.SeqNo]; ok && sender != endpoint {
+			f := t.flows[sender]
+			f.Peer = endpoint
+			p := f.ratePoint(r.Time)
+			p.ReceivedPackets++
+			p.ReceivedBytes += dataLen
+		}
+		// The acknowledgements that an endpoint reads are of the flow that it sends
+		if f, ok := t.flows[endpoint]; ok && r.AckNo != 0 {
+			ack := f.offset(r.AckNo)
+			if len(f.Acked) == 0 || ack > f.Acked[len(f.Acked)-1].SeqNo {
+				f.Acked = append(f.Acked, SeqPoint{r.Time, ack, r.Type})
+			}
+		}
+	}
+}
+
+func (t *TimeSeq) Sync() error { return nil }
+
+func (t *TimeSeq) Close() error { return nil }
+
+// Flows returns the flows of the run, sorted by endpoint. It is meant to be called once the
+// run is over.
+func (t *TimeSeq) Flows() []*Flow {
+	t.Lock()
+	defer t.Unlock()
+	flows := make([]*Flow, 0, len(t.flows))
+	for _, f := range t.flows {
+		flows = append(flows, f)
+	}
+	sort.Sort(flowSort(flows))
+	return flows
+}
+
+// offset returns the offset of seqno from the ISN of the flow, modulo the 48-bit wraparound
+func (f *Flow) offset(seqno int64) int64 {
+	return (seqno - f.ISN) & (1<<48 - 1)
+}
+
+func (f *Flow) ratePoint(now int64) *RatePoint {
+	i := now / f.bin
+	p, ok := f.rates[i]
+	if !ok {
+		p = &RatePoint{Time: i * f.bin}
+		f.rates[i] = p
+	}
+	return p
+}
+
+// Rates returns the tallies of the flow in chronological order, for every bin from the first
+// in which the flow sends to the last in which it sends or delivers, including empty ones
+func (f *Flow) Rates() []RatePoint {
+	if len(f.rates) == 0 {
+		return nil
+	}
+	var first, last int64 = -1, -1
+	for i := range f.rates {
+		if first < 0 || i < first {
+			first = i
+		}
+		if i > last {
+			last = i
+		}
+	}
+	rates := make([]RatePoint, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		if p, ok := f.rates[i]; ok {
+			rates = append(rates, *p)
+		} else {
+			rates = append(rates, RatePoint{Time: i * f.bin})
+		}
+	}
+	return rates
+}
+
+// WriteXplot writes the time-sequence graph of the flow in the format of xplot, as plotted by
+// tcptrace: a white dot for each packet sent, a red cross for each packet lost and a green
+// step line along the acknowledgements.
+func (f *Flow) WriteXplot(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	peer := f.Peer
+	if peer == "" {
+		peer = "?"
+	}
+	fmt.Fprintf(b, "timeval unsigned\ntitle\n%s ==> %s\nxlabel\ntime\nylabel\nsequence offset (packets)\n", f.Endpoint, peer)
+	fmt.Fprintf(b, "white\n")
+	for _, p := range f.Sent {
+		fmt.Fprintf(b, "dot %s %d\n", timeval(p.Time), p.SeqNo)
+	}
+	if len(f.Acked) > 0 {
+		fmt.Fprintf(b, "green\n")
+		for i, p := range f.Acked {
+			if i > 0 {
+				q := f.Acked[i-1]
+				fmt.Fprintf(b, "line %s %d %s %d\n", timeval(q.Time), q.SeqNo, timeval(p.Time), q.SeqNo)
+				fmt.Fprintf(b, "line %s %d %s %d\n", timeval(p.Time), q.SeqNo, timeval(p.Time), p.SeqNo)
+			}
+		}
+		fmt.Fprintf(b, "diamond %s %d\n", timeval(f.Acked[0].Time), f.Acked[0].SeqNo)
+	}
+	if len(f.Lost) > 0 {
+		fmt.Fprintf(b, "red\n")
+		for _, p := range f.Lost {
+			fmt.Fprintf(b, "x %s %d\n", timeval(p.Time), p.SeqNo)
+		}
+	}
+	fmt.Fprintf(b, "go\n")
+	return b.Flush()
+}
+
+// WriteSeqData writes the time-sequence data of the flow as whitespace-separated columns of
+// time in seconds, sequence offset, event (sent, acked or lost) and packet type, for gnuplot
+// and the like
+func (f *Flow) WriteSeqData(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "# %s ==> %s\n# time seqno event type\n", f.Endpoint, f.Peer)
+	points := make([]seqEvent, 0, len(f.Sent)+len(f.Acked)+len(f.Lost))
+	for _, p := range f.Sent {
+		points = append(points, seqEvent{p, "sent"})
+	}
+	for _, p := range f.Acked {
+		points = append(points, seqEvent{p, "acked"})
+	}
+	for _, p := range f.Lost {
+		points = append(points, seqEvent{p, "lost"})
+	}
+	sort.Stable(seqEventSort(points))
+	for _, p := range points {
+		fmt.Fprintf(b, "%s %d %s %s\n", timeval(p.Time), p.SeqNo, p.event, p.Type)
+	}
+	return b.Flush()
+}
+
+// WriteRates writes the rates of the flow as whitespace-separated columns of time in seconds,
+// and the packets and bytes per second that the flow sends and delivers, for gnuplot and the like
+func (f *Flow) WriteRates(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "# %s ==> %s\n# time sent(pkt/s) sent(B/s) received(pkt/s) received(B/s)\n", f.Endpoint, f.Peer)
+	perSec := 1e9 / float64(f.bin)
+	for _, p := range f.Rates() {
+		fmt.Fprintf(b, "%s %g %g %g %g\n", timeval(p.Time),
+			float64(p.SentPackets)*perSec, float64(p.SentBytes)*perSec,
+			float64(p.ReceivedPackets)*perSec, float64(p.ReceivedBytes)*perSec)
+	}
+	return b.Flush()
+}
+
+// timeval formats a time in nanoseconds as seconds with microsecond precision, as xplot reads it
+func timeval(ns int64) string {
+	return fmt.Sprintf("%d.%06d", ns/1e9, (ns%1e9)/1e3)
+}
+
+type seqEvent struct {
+	SeqPoint
+	event string
+}
+
+type seqEventSort []seqEvent
+
+func (t seqEventSort) Len() int           { return len(t) }
+func (t seqEventSort) Less(i, j int) bool { return t[i].Time < t[j].Time }
+func (t seqEventSort) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+type flowSort []*Flow
+
+func (t flowSort) Len() int           { return len(t) }
+func (t flowSort) Less(i, j int) bool { return t[i].Endpoint < t[j].Endpoint }
+func (t flowSort) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
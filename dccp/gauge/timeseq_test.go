@@ -0,0 +1,82 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package gauge
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+func TestTimeSeq(t *testing.T) {
+	ts := NewTimeSeq(time.Second)
+	data := map[string]interface{}{dccp.HeaderInfoType: &dccp.HeaderInfo{DataLen: 100}}
+	// The client sends three packets, of which the line loses the second
+	ts.Write(&dccp.Trace{Time: 1e9, Labels: []string{"client"}, Event: dccp.EventWrite, Type: "Request", SeqNo: 100})
+	ts.Write(&dccp.Trace{Time: 1.1e9, Labels: []string{"server"}, Event: dccp.EventRead, Type: "Request", SeqNo: 100})
+	ts.Write(&dccp.Trace{Time: 1.2e9, Labels: []string{"server"}, Event: dccp.EventWrite, Type: "Response", SeqNo: 500, AckNo: 100})
+	ts.Write(&dccp.Trace{Time: 1.3e9, Labels: []string{"client"}, Event: dccp.EventRead, Type: "Response", SeqNo: 500, AckNo: 100})
+	ts.Write(&dccp.Trace{Time: 2.0e9, Labels: []string{"client"}, Event: dccp.EventWrite, Type: "DataAck", SeqNo: 101, AckNo: 500, Args: data})
+	ts.Write(&dccp.Trace{Time: 2.0e9, Labels: []string{"line", "client"}, Event: dccp.EventDrop, Type: "DataAck", SeqNo: 101})
+	ts.Write(&dccp.Trace{Time: 2.5e9, Labels: []string{"client"}, Event: dccp.EventWrite, Type: "Data", SeqNo: 102, Args: data})
+	ts.Write(&dccp.Trace{Time: 3.6e9, Labels: []string{"server"}, Event: dccp.EventRead, Type: "Data", SeqNo: 102, Args: data})
+	ts.Write(&dccp.Trace{Time: 3.7e9, Labels: []string{"server"}, Event: dccp.EventWrite, Type: "Ack", SeqNo: 501, AckNo: 102})
+	ts.Write(&dccp.Trace{Time: 3.8e9, Labels: []string{"client"}, Event: dccp.EventRead, Type: "Ack", SeqNo: 501, AckNo: 102})
+
+	flows := ts.Flows()
+	if len(flows) != 2 || flows[0].Endpoint != "client" || flows[1].Endpoint != "server" {
+		t.Fatalf("expected the flows of client and server, got %v", flows)
+	}
+	c := flows[0]
+	if c.Peer != "server" || len(c.Sent) != 3 || c.Sent[2].SeqNo != 2 {
+		t.Errorf("client sent %v to %q", c.Sent, c.Peer)
+	}
+	if len(c.Lost) != 1 || c.Lost[0].SeqNo != 1 {
+		t.Errorf("client lost %v, expected offset 1", c.Lost)
+	}
+	if len(c.Acked) != 2 || c.Acked[0].SeqNo != 0 || c.Acked[1].SeqNo != 2 {
+		t.Errorf("client acked %v, expected offsets 0 and 2", c.Acked)
+	}
+
+	rates := c.Rates()
+	expect := []RatePoint{
+		{Time: 1e9, SentPackets: 1, ReceivedPackets: 1},
+		{Time: 2e9, SentPackets: 2, SentBytes: 200},
+		{Time: 3e9, ReceivedPackets: 1, ReceivedBytes: 100},
+	}
+	if len(rates) != len(expect) {
+		t.Fatalf("client rates %v, expected %v", rates, expect)
+	}
+	for i := range expect {
+		if rates[i] != expect[i] {
+			t.Errorf("client rate %d is %v, expected %v", i, rates[i], expect[i])
+		}
+	}
+
+	var w bytes.Buffer
+	if err := c.WriteXplot(&w); err != nil {
+		t.Fatalf("xplot (%s)", err)
+	}
+	xpl := w.String()
+	for _, line := range []string{"client ==> server", "dot 2.500000 2", "x 2.000000 1", "line 3.800000 0 3.800000 2"} {
+		if !strings.Contains(xpl, line+"\n") {
+			t.Errorf("xplot misses %q:\n%s", line, xpl)
+		}
+	}
+	if !strings.HasPrefix(xpl, "timeval unsigned\n") || !strings.HasSuffix(xpl, "go\n") {
+		t.Errorf("xplot is not framed:\n%s", xpl)
+	}
+
+	w.Reset()
+	if err := c.WriteRates(&w); err != nil {
+		t.Fatalf("rates (%s)", err)
+	}
+	if !strings.Contains(w.String(), "2.000000 2 200 0 0\n") {
+		t.Errorf("unexpected rates:\n%s", w.String())
+	}
+}
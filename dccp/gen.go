@@ -39,20 +39,46 @@ func (c *Conn) generateSync() *writeHeader {
 	return h
 }
 
-func (c *Conn) generateRequest(serviceCode uint32) *writeHeader {
+// generateSyncFor generates a Sync in response to the sequence-invalid packet inResponseTo,
+// Section 7.5.4. The Sync acknowledges inResponseTo, so that the other side can recognize it
+// even if its own sequence numbers have moved far ahead of our GSR. A Sync in response to a
+// Reset acknowledges GSR instead, which invites a Reset with a valid sequence number.
+func (c *Conn) generateSyncFor(inResponseTo *Header) *writeHeader {
 	h := &writeHeader{}
-	h.Header.InitRequestHeader(serviceCode)
+	h.Header.InitSyncHeader()
+	h.SeqAckType = seqAckSync
+	h.InResponseTo = inResponseTo
+	return h
+}
+
+func (c *Conn) generateRequest(serviceCodes []ServiceCode) *writeHeader {
+	h := &writeHeader{}
+	h.Header.InitRequestHeader(serviceCodes[0])
+	if len(serviceCodes) > 1 && serviceCodesOptionEnabled() {
+		opt, err := (&ServiceCodesOption{serviceCodes[1:]}).Encode()
+		if err != nil {
+			panic("problem encoding service codes option")
+		}
+		h.Header.Options = append(h.Header.Options, opt)
+	}
 	h.SeqAckType = seqAckNormal
 	return h
 }
 
-func (c *Conn) generateResponse(serviceCode uint32) *writeHeader {
+func (c *Conn) generateResponse(serviceCode ServiceCode) *writeHeader {
 	h := &writeHeader{}
 	h.Header.InitResponseHeader(serviceCode)
 	h.SeqAckType = seqAckNormal
 	return h
 }
 
+func (c *Conn) generateCloseReq() *writeHeader {
+	h := &writeHeader{}
+	h.Header.InitCloseReqHeader()
+	h.SeqAckType = seqAckNormal
+	return h
+}
+
 func (c *Conn) generateClose() *writeHeader {
 	h := &writeHeader{}
 	h.Header.InitCloseHeader()
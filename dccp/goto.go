@@ -8,7 +8,8 @@ import "fmt"
 
 const (
 	REQUEST_BACKOFF_FIRST      = 1e9      // Initial re-send period for client Request resends is 1 sec, in ns
-	REQUEST_BACKOFF_FREQ       = 10e9     // Back-off Request resend every 10 secs, in ns
+	REQUEST_BACKOFF_MAX        = 8e9      // Request resend period doubles up to 8 sec, in ns
+	REQUEST_BACKOFF_JITTER     = 0.25     // Request resend periods are randomized by up to ±25%
 	REQUEST_BACKOFF_TIMEOUT    = 30e9     // Request re-sends quit after 30 sec, in ns (shorter than RFC recommendation)
 
 	RESPOND_TIMEOUT            = 30e9     // Timeout in RESPOND state, 30 sec in ns
@@ -18,7 +19,7 @@ const (
 	CLOSING_BACKOFF_FREQ       = 64e9     // Backoff frequency of CLOSING timer, 64 seconds, Section 8.3
 	CLOSING_BACKOFF_TIMEOUT    = MSL/4    // Maximum time in CLOSING (RFC recommends MSL, but seems too long)
 
-	TIMEWAIT_TIMEOUT           = MSL/2    // Time to stay in TIMEWAIT, Section 8.3 recommends MSL*2
+	TIMEWAIT_TIMEOUT           = 2*MSL    // Default time to stay in TIMEWAIT, Section 8.3
 
 	PARTOPEN_BACKOFF_FIRST     = 200e6    // 200 miliseconds in ns, Section 8.1.5
 	PARTOPEN_BACKOFF_FREQ      = 200e6    // 200 miliseconds in ns
@@ -28,86 +29,72 @@ const (
 )
 
 func (c *Conn) gotoLISTEN() {
-	c.AssertLocked()
 	c.socket.SetServer(true)
 	c.socket.SetState(LISTEN)
 	c.emitSetState()
-	c.env.Expire(
-		func()bool {
-			c.Lock()
-			state := c.socket.GetState()
-			c.Unlock()
-			// If we've transitioned away from LISTEN, we are in good shape
-			return state != LISTEN
-		}, 
-		func() {
+	c.setTimer(LISTEN_TIMEOUT, func() {
+		// If we've transitioned away from LISTEN, we are in good shape
+		if c.socket.GetState() == LISTEN {
 			// Otherwise abort the connection
 			c.abortQuietly()
-		}, 
-		LISTEN_TIMEOUT, EXPIRE_INTERVAL, "gotoLISTEN")
+		}
+	})
 }
 
-func (c *Conn) gotoRESPOND(hServiceCode uint32, hSeqNo int64) {
-	c.AssertLocked()
+func (c *Conn) gotoRESPOND(hServiceCode ServiceCode, hSeqNo int64) {
 	c.socket.SetState(RESPOND)
 	c.emitSetState()
-	iss := c.socket.ChooseISS()
+	iss := c.socket.ChooseISS(c.env)
 	c.socket.SetGAR(iss)
 	c.socket.SetISR(hSeqNo)
 	c.socket.SetGSR(hSeqNo)
-	// TODO: To be more prudent, set service code only if it is currently 0,
-	// otherwise check that h.ServiceCode matches socket service code
 	c.socket.SetServiceCode(hServiceCode)
 
-	c.env.Expire(
-		func()bool {
-			c.Lock()
-			state := c.socket.GetState()
-			c.Unlock()
-			return state != RESPOND
-		}, 
-		func() {
+	c.setTimer(c.respondTimeout, func() {
+		if c.socket.GetState() == RESPOND {
+			c.amb.E(EventWarn, "RESPOND timeout")
 			c.abortQuietly()
-		}, 
-		RESPOND_TIMEOUT, EXPIRE_INTERVAL, "gotoRESPOND")
+		}
+	})
 }
 
-func (c *Conn) gotoREQUEST(serviceCode uint32) {
-	c.AssertLocked()
+func (c *Conn) gotoREQUEST(serviceCodes []ServiceCode) {
 	c.socket.SetServer(false)
 	c.socket.SetState(REQUEST)
 	c.emitSetState()
-	c.socket.SetServiceCode(serviceCode)
-	iss := c.socket.ChooseISS()
+	c.serviceCodes = serviceCodes
+	c.socket.SetServiceCode(serviceCodes[0])
+	iss := c.socket.ChooseISS(c.env)
 	c.socket.SetGAR(iss)
-	c.inject(c.generateRequest(serviceCode))
+	c.inject(c.generateRequest(serviceCodes))
 
 	// Resend Request using exponential backoff, if no response
-	c.env.Go(func() {
-		b := newBackOff(c.env, REQUEST_BACKOFF_FIRST, REQUEST_BACKOFF_TIMEOUT, REQUEST_BACKOFF_FREQ)
-		for {
-			err, _ := b.Sleep()
-			c.Lock()
-			state := c.socket.GetState()
-			c.Unlock()
-			if state != REQUEST {
-				break
-			}
-			// If the back-off timer has reached maximum wait, quit trying
-			if err != nil {
-				c.abort()
-				break
-			}
-			c.Lock()
-			c.amb.E(EventTurn, "Request resend")
-			c.inject(c.generateRequest(serviceCode))
-			c.Unlock()
+	b := &requestBackOff{start: c.env.nowNano(), random: c.env.Float64}
+	c.setRequestTimer(b, serviceCodes)
+}
+
+// setRequestTimer arranges for the Request to be resent on the schedule b, until the handshake
+// moves on or the schedule runs out
+func (c *Conn) setRequestTimer(b *requestBackOff, serviceCodes []ServiceCode) {
+	wait, resend := b.Next(c.requestRetry, c.env.nowNano())
+	c.setTimer(wait, func() {
+		if c.socket.GetState() != REQUEST {
+			return
 		}
-	}, "gotoREQUEST")
+		// If the retransmission schedule is exhausted, the dial has timed out
+		if !resend {
+			c.amb.E(EventWarn, "Request timeout")
+			c.reset(ResetAborted, ErrTimeout)
+			return
+		}
+		c.amb.E(EventTurn, "Request resend")
+		c.countRetransmit()
+		c.inject(c.generateRequest(serviceCodes))
+		c.setRequestTimer(b, serviceCodes)
+	})
 }
 
 func (c *Conn) openCCID() {
-	c.AssertLocked()
 	if c.ccidOpen {
 		return
 	}
@@ -118,7 +105,6 @@ func (c *Conn) openCCID() {
 }
 
 func (c *Conn) closeCCID() {
-	c.AssertLocked()
 	if !c.ccidOpen {
 		return
 	}
@@ -129,103 +115,144 @@ func (c *Conn) closeCCID() {
 }
 
 func (c *Conn) gotoPARTOPEN() {
-	c.AssertLocked()
 	c.socket.SetState(PARTOPEN)
 	c.emitSetState()
+	// Confirm options are repeated on the Acks we send until the handshake completes
+	c.features.HoldConfirms(true)
 	c.openCCID()
-	c.inject(nil) // Unblocks the writeLoop select, so it can see the state change
+	c.openData()
 
 	// Start PARTOPEN timer, according to Section 8.1.5
-	c.env.Go(func() {
-		b := newBackOff(c.env, PARTOPEN_BACKOFF_FIRST, PARTOPEN_BACKOFF_TIMEOUT, PARTOPEN_BACKOFF_FREQ)
-		c.amb.E(EventInfo, "PARTOPEN backoff start")
-		for {
-			err, btm := b.Sleep()
-			c.Lock()
-			state := c.socket.GetState()
-			c.Unlock()
-			if state != PARTOPEN {
-				c.amb.E(EventInfo, "PARTOPEN backoff EXIT via state change")
-				break
-			}
-			// If the back-off timer has reached maximum wait. End the connection.
-			if err != nil {
-				c.abort()
-				break
-			}
-			c.amb.E(EventInfo, fmt.Sprintf("PARTOPEN backoff %d", btm))
-			c.Lock()
-			c.inject(c.generateAck())
-			c.Unlock()
+	b := newBackOff(PARTOPEN_BACKOFF_FIRST, PARTOPEN_BACKOFF_TIMEOUT, PARTOPEN_BACKOFF_FREQ)
+	c.amb.E(EventInfo, "PARTOPEN backoff start")
+	c.setBackOff(b, func(timeout bool) bool {
+		if c.socket.GetState() != PARTOPEN {
+			c.amb.E(EventInfo, "PARTOPEN backoff EXIT via state change")
+			return false
+		}
+		// If the back-off timer has reached maximum wait, the handshake has timed out
+		if timeout {
+			c.amb.E(EventWarn, "PARTOPEN timeout")
+			c.reset(ResetAborted, ErrTimeout)
+			return false
 		}
-	}, "gotoPARTOPEN")
+		c.amb.E(EventInfo, fmt.Sprintf("PARTOPEN backoff %d", c.env.nowNano()))
+		c.countRetransmit()
+		c.inject(c.generateAck())
+		return true
+	})
 }
 
 func (c *Conn) gotoOPEN(hSeqNo int64) {
-	c.AssertLocked()
+	c.leaveHalfOpen()
+	c.features.HoldConfirms(false)
+	c.initCookies = nil
 	c.socket.SetOSR(hSeqNo)
 	c.socket.SetState(OPEN)
+	if !isClosed(c.handshake) {
+		countHandshake()
+	}
+	c.endHandshake(true)
 	c.emitSetState()
 	c.openCCID()
-	c.inject(nil) // Unblocks the writeLoop select, so it can see the state change
+	c.openData()
+}
+
+// openData lets writeLoop move on to application data, once the connection is PARTOPEN or
+// OPEN. It MUST be idempotent.
+func (c *Conn) openData() {
+	if !isClosed(c.dataOpen) {
+		close(c.dataOpen)
+		c.env.Closed(&c.dataOpenT)
+	}
 }
 
 func (c *Conn) gotoTIMEWAIT() {
-	c.AssertLocked()
 	c.setError(ErrEOF)
 	c.teardownUser()
+	c.endHandshake(false)
 	c.socket.SetState(TIMEWAIT)
 	c.emitSetState()
 	c.closeCCID()
 
-	c.env.Go(func() {
-		c.env.Sleep(TIMEWAIT_TIMEOUT)
-		c.abortQuietly()
-	}, "gotoTIMEWAIT")
+	// TIMEWAIT is cut short if the connection is aborted in the meantime
+	c.setTimer(c.timewait, func() {
+		if c.socket.GetState() == TIMEWAIT {
+			c.expireTIMEWAIT()
+		}
+	})
 }
 
 func (c *Conn) gotoCLOSING() {
-	c.AssertLocked()
 	c.setError(ErrEOF)
 	c.teardownUser()
+	c.endHandshake(false)
 	c.socket.SetState(CLOSING)
 	c.emitSetState()
 	c.closeCCID()
-	c.env.Go(func() {
-		c.Lock()
-		rtt := c.socket.GetRTT()
-		c.Unlock()
-		c.amb.E(EventInfo, fmt.Sprintf("CLOSING RTT=%dns", rtt))
-		b := newBackOff(c.env, 2*rtt, CLOSING_BACKOFF_TIMEOUT, CLOSING_BACKOFF_FREQ)
-		for {
-			err, _ := b.Sleep()
-			c.Lock()
-			state := c.socket.GetState()
-			c.Unlock()
-			if state != CLOSING {
-				break
-			}
-			if err != nil {
-				c.Lock()
-				c.gotoTIMEWAIT()
-				c.Unlock()
-				break
-			}
-			c.amb.E(EventInfo, "Resend Close")
-			c.Lock()
-			c.inject(c.generateClose())
-			c.Unlock()
+	rtt := c.socket.GetRTT()
+	c.amb.E(EventInfo, fmt.Sprintf("CLOSING RTT=%dns", rtt))
+	b := newBackOff(2*rtt, CLOSING_BACKOFF_TIMEOUT, CLOSING_BACKOFF_FREQ)
+	c.setBackOff(b, func(timeout bool) bool {
+		if c.socket.GetState() != CLOSING {
+			return false
+		}
+		if timeout {
+			c.gotoTIMEWAIT()
+			return false
+		}
+		c.amb.E(EventInfo, "Resend Close")
+		c.inject(c.generateClose())
+		return true
+	})
+}
+
+// gotoCLOSEREQ is entered by a server that asks the client to close the connection, so that
+// the client rather than the server holds TIMEWAIT, Section 8.3. CloseReq is resent like Close
+// in CLOSING, until the client's Close arrives.
+func (c *Conn) gotoCLOSEREQ() {
+	c.setError(ErrEOF)
+	c.teardownUser()
+	c.socket.SetState(CLOSEREQ)
+	c.emitSetState()
+	c.closeCCID()
+	b := newBackOff(2*c.socket.GetRTT(), CLOSING_BACKOFF_TIMEOUT, CLOSING_BACKOFF_FREQ)
+	c.setBackOff(b, func(timeout bool) bool {
+		if c.socket.GetState() != CLOSEREQ {
+			return false
+		}
+		// If the client never answers, give up without holding TIMEWAIT
+		if timeout {
+			c.reset(ResetClosed, ErrEOF)
+			return false
 		}
-	}, "gotoCLOSING")
+		c.amb.E(EventInfo, "Resend CloseReq")
+		c.inject(c.generateCloseReq())
+		return true
+	})
 }
 
 // gotoCLOSED MUST be idempotent
 func (c *Conn) gotoCLOSED() {
-	c.AssertLocked()
-	c.emitSetState()
+	c.leaveHalfOpen()
 	c.socket.SetState(CLOSED)
+	c.countActive(false)
 	c.setError(ErrAbort)
+	c.emitSetState()
+	c.endHandshake(false)
 	c.teardownUser()
 	c.teardownWriteLoop()
 	c.closeCCID()
+	c.stopTimers()
+}
+
+// endHandshake records the end of the handshake, which either brought the connection to OPEN
+// state or failed, and wakes up WaitOpen. It MUST be idempotent.
+func (c *Conn) endHandshake(open bool) {
+	if isClosed(c.handshake) {
+		return
+	}
+	c.open = open
+	close(c.handshake)
+	c.env.Closed(&c.handshakeT)
 }
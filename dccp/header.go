@@ -24,13 +24,26 @@ type Header struct {
 	X           bool      // Extended seq numbers: generally always true (for us)
 	SeqNo       int64     // 48-bit if X=1
 	AckNo       int64     // 48-bit if X=1
-	ServiceCode uint32    // ServiceCode: Applicaton level service (in Req,Resp pkts)
+	ServiceCode ServiceCode // ServiceCode: Applicaton level service (in Req,Resp pkts)
 	ResetCode   byte      // ResetCode: Reason for reset (in Reset pkts)
 	ResetData   []byte    // ResetData: Additional reset info (in Reset pkts)
 	Options     []*Option // Used for feature negotiation, padding, mandatory flags
 	Data        []byte    // Application data (in Req, Resp, Data, DataAck pkts) 
 	// Ignored (in Ack, Close, CloseReq, Sync, SyncAck pkts)
 	// Error text (in Reset pkts)
+
+	// DataVec holds application data in fragments, which follows Data on the wire. Write
+	// gathers the fragments straight into the packet, so that vectored writes need not
+	// concatenate them. Links that carry headers without encoding them call GatherData.
+	DataVec     [][]byte
+
+	// ECN is the ECN codepoint of the IP packet carrying this header. It is not part of the
+	// DCCP wire format and is only meaningful if the HeaderConn is an ECNCarrier.
+	ECN         byte
+
+	// buf is the packet buffer that the slices of a received header lie in, if the link lends
+	// it, see Conn.ReadBorrow
+	buf         *packetBuf
 }
 
 const (
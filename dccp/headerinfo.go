@@ -0,0 +1,136 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// HeaderInfo is the decoded content of a packet header, besides the type and numbers that a
+// Trace has fields for. Traces of packets that are read, written or dropped carry it among
+// their Args, so that the inspector can show the options of each packet.
+type HeaderInfo struct {
+	SourcePort  uint16
+	DestPort    uint16
+	CCVal       int8
+	CsCov       byte
+	ServiceCode ServiceCode `json:",omitempty"`
+	ResetCode   byte        `json:",omitempty"`
+	ECN         byte        `json:",omitempty"`
+	DataLen     int
+	Options     []OptionInfo `json:",omitempty"`
+}
+
+// OptionInfo is a decoded option of a HeaderInfo
+type OptionInfo struct {
+	Type      byte
+	Name      string
+	Data      []byte `json:",omitempty"`
+	Mandatory bool   `json:",omitempty"`
+}
+
+// HeaderInfoType is the key of the HeaderInfo in the Args of a Trace
+var HeaderInfoType = TypeOf(HeaderInfo{})
+
+// NewHeaderInfo returns the HeaderInfo of h
+func NewHeaderInfo(h *Header) *HeaderInfo {
+	x := &HeaderInfo{
+		SourcePort: h.SourcePort,
+		DestPort:   h.DestPort,
+		CCVal:      h.CCVal,
+		CsCov:      h.CsCov,
+		ECN:        h.ECN,
+		DataLen:    h.DataLen(),
+	}
+	switch h.Type {
+	case Request, Response:
+		x.ServiceCode = h.ServiceCode
+	case Reset:
+		x.ResetCode = h.ResetCode
+	}
+	for _, o := range h.Options {
+		if o.Type == OptionPadding {
+			continue
+		}
+		x.Options = append(x.Options, OptionInfo{
+			Type:      o.Type,
+			Name:      OptionTypeString(o.Type),
+			Data:      append([]byte(nil), o.Data...),
+			Mandatory: o.Mandatory,
+		})
+	}
+	return x
+}
+
+// HeaderInfo returns the HeaderInfo of the packet of this trace, if it has one. Traces read
+// back from JSON carry it as a JSON object, which is decoded.
+func (x *Trace) HeaderInfo() (info *HeaderInfo, present bool) {
+	switch a := x.Args[HeaderInfoType].(type) {
+	case *HeaderInfo:
+		return a, true
+	case HeaderInfo:
+		return &a, true
+	case map[string]interface{}:
+		b, err := json.Marshal(a)
+		if err != nil {
+			return nil, false
+		}
+		info = &HeaderInfo{}
+		if json.Unmarshal(b, info) != nil {
+			return nil, false
+		}
+		return info, true
+	}
+	return nil, false
+}
+
+// OptionTypeString returns the name of the option type optionType, like "Ack Vector [Nonce 0]"
+func OptionTypeString(optionType byte) string {
+	switch optionType {
+	case OptionPadding:
+		return "Padding"
+	case OptionMandatory:
+		return "Mandatory"
+	case OptionSlowReceiver:
+		return "Slow Receiver"
+	case OptionChangeL:
+		return "Change L"
+	case OptionConfirmL:
+		return "Confirm L"
+	case OptionChangeR:
+		return "Change R"
+	case OptionConfirmR:
+		return "Confirm R"
+	case OptionInitCookie:
+		return "Init Cookie"
+	case OptionNDPCount:
+		return "NDP Count"
+	case OptionAckVectorNonce0:
+		return "Ack Vector [Nonce 0]"
+	case OptionAckVectorNonce1:
+		return "Ack Vector [Nonce 1]"
+	case OptionDataDropped:
+		return "Data Dropped"
+	case OptionTimestamp:
+		return "Timestamp"
+	case OptionTimestampEcho:
+		return "Timestamp Echo"
+	case OptionElapsedTime:
+		return "Elapsed Time"
+	case OptionDataChecksum:
+		return "Data Checksum"
+	case OptionServiceCodes:
+		return "Service Codes"
+	}
+	// CCID-specific options, Section 10.3
+	if optionType >= 192 {
+		return "CCID Receiver " + strconv.Itoa(int(optionType))
+	}
+	if optionType >= 128 {
+		return "CCID Sender " + strconv.Itoa(int(optionType))
+	}
+	return "Reserved " + strconv.Itoa(int(optionType))
+}
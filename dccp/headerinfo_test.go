@@ -0,0 +1,59 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderInfo(t *testing.T) {
+	l := &traceList{}
+	amb := NewAmb("client", NewEnv(l)).Refine("conn")
+	h := &Header{
+		Type:        Request,
+		SourcePort:  5,
+		DestPort:    7,
+		ServiceCode: 42,
+		Options: []*Option{
+			{Type: OptionPadding},
+			{Type: OptionChangeL, Data: []byte{1, 2}, Mandatory: true},
+			{Type: 193, Data: []byte{3}},
+		},
+	}
+	amb.E(EventWrite, "Write to header link", h)
+	amb.E(EventInfo, "Not a packet event", h)
+
+	info, ok := l.traces[0].HeaderInfo()
+	if !ok {
+		t.Fatalf("no header info")
+	}
+	expect := &HeaderInfo{
+		SourcePort:  5,
+		DestPort:    7,
+		ServiceCode: 42,
+		Options: []OptionInfo{
+			{Type: OptionChangeL, Name: "Change L", Data: []byte{1, 2}, Mandatory: true},
+			{Type: 193, Name: "CCID Receiver 193", Data: []byte{3}},
+		},
+	}
+	if !reflect.DeepEqual(info, expect) {
+		t.Errorf("expecting %+v, encountered %+v", expect, info)
+	}
+	if _, ok := l.traces[1].HeaderInfo(); ok {
+		t.Errorf("header info on an Info trace")
+	}
+
+	// Read back from JSON
+	b, _ := json.Marshal(l.traces[0])
+	var r Trace
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("decode (%s)", err)
+	}
+	if info, ok = r.HeaderInfo(); !ok || !reflect.DeepEqual(info, expect) {
+		t.Errorf("JSON: expecting %+v, encountered %+v", expect, info)
+	}
+}
@@ -6,6 +6,38 @@ package dccp
 
 func (h *Header) HasAckNo() bool { return getAckNoSubheaderSize(h.Type, h.X) > 0 }
 
+// Footprint returns the length of the wire format of h, header and application data, as
+// written by Write
+func (h *Header) Footprint() (int, error) {
+	n, err := h.getHeaderFootprint(false)
+	if err != nil {
+		return 0, err
+	}
+	return n + h.DataLen(), nil
+}
+
+// DataLen returns the length of the application data of h, Data and DataVec together
+func (h *Header) DataLen() int {
+	n := len(h.Data)
+	for _, f := range h.DataVec {
+		n += len(f)
+	}
+	return n
+}
+
+// GatherData moves the fragments of DataVec, if any, into Data
+func (h *Header) GatherData() {
+	if h.DataVec == nil {
+		return
+	}
+	data := make([]byte, 0, h.DataLen())
+	data = append(data, h.Data...)
+	for _, f := range h.DataVec {
+		data = append(data, f...)
+	}
+	h.Data, h.DataVec = data, nil
+}
+
 // InitResetHeader() creates a new Reset header
 func (h *Header) InitResetHeader(resetCode byte) {
 	h.Type      = Reset
@@ -13,6 +45,12 @@ func (h *Header) InitResetHeader(resetCode byte) {
 	h.ResetCode = resetCode
 }
 
+// InitCloseReqHeader() creates a new CloseReq header
+func (h *Header) InitCloseReqHeader() {
+	h.Type = CloseReq
+	h.X    = true
+}
+
 // InitCloseHeader() creates a new Close header
 func (h *Header) InitCloseHeader() {
 	h.Type = Close
@@ -52,14 +90,14 @@ func (h *Header) InitSyncAckHeader() {
 }
 
 // InitRequestHeader() creates a new Request header
-func (h *Header) InitRequestHeader(serviceCode uint32) {
+func (h *Header) InitRequestHeader(serviceCode ServiceCode) {
 	h.Type        = Request
 	h.X           = true
 	h.ServiceCode = serviceCode
 }
 
 // InitResponseHeader() creates a new Response header
-func (h *Header) InitResponseHeader(serviceCode uint32) {
+func (h *Header) InitResponseHeader(serviceCode ServiceCode) {
 	h.Type        = Response
 	h.X           = true
 	h.ServiceCode = serviceCode
@@ -0,0 +1,137 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"fmt"
+	"net"
+)
+
+// ErrUnreachable matches, under errors.Is, the ICMPErrors that report a destination as
+// unreachable
+var ErrUnreachable = NewError("i/o unreachable")
+
+// ICMPError is an ICMP or ICMPv6 error message about a packet of a connection, Section 14.
+// Conns that run over a UDPEncap or a RawIP learn of these from the operating system, where it
+// supports it.
+//
+// A Port Unreachable or an Administratively Prohibited message that arrives while the client
+// waits for a Response fails the connection attempt at once, with the ICMPError as the error of
+// Dial. Fragmentation Needed and Packet Too Big lower the MTU of the connection. All other
+// messages, and those that arrive later on, are soft errors, which the connection survives and
+// which Conn.SoftError reports. Protocol Unreachable in particular is a soft error, since hosts
+// without a DCCP implementation of their own send it for every packet of a DCCP stack that runs
+// over raw sockets.
+type ICMPError struct {
+	IPv6 bool   // True for an ICMPv6 message
+	Type byte   // ICMP or ICMPv6 type
+	Code byte   // ICMP or ICMPv6 code
+	MTU  int    // Next-hop MTU of a Fragmentation Needed or Packet Too Big message, or zero
+	From net.IP // Sender of the message, if known
+}
+
+// ICMP and ICMPv6 types and codes of the errors that a connection acts on
+const (
+	icmpUnreachable       = 3
+	icmpPortUnreachable   = 3
+	icmpFragNeeded        = 4
+	icmpNetProhibited     = 9
+	icmpHostProhibited    = 10
+	icmpCommProhibited    = 13
+	icmpv6Unreachable     = 1
+	icmpv6CommProhibited  = 1
+	icmpv6PortUnreachable = 4
+	icmpv6PacketTooBig    = 2
+)
+
+func (e *ICMPError) Error() string {
+	s := fmt.Sprintf("icmp type %d code %d", e.Type, e.Code)
+	if e.IPv6 {
+		s = fmt.Sprintf("icmpv6 type %d code %d", e.Type, e.Code)
+	}
+	switch {
+	case e.tooBig():
+		s += fmt.Sprintf(" (packet too big, mtu %d)", e.MTU)
+	case e.hard():
+		s += " (destination unreachable)"
+	}
+	if e.From != nil {
+		s += " from " + e.From.String()
+	}
+	return s
+}
+
+// Is makes an ICMPError about an unreachable destination match ErrUnreachable under errors.Is
+func (e *ICMPError) Is(target error) bool {
+	return target == ErrUnreachable && e.unreachable()
+}
+
+// unreachable returns true for Destination Unreachable messages
+func (e *ICMPError) unreachable() bool {
+	if e.IPv6 {
+		return e.Type == icmpv6Unreachable
+	}
+	return e.Type == icmpUnreachable
+}
+
+// hard returns true for the messages that fail a connection attempt: Port Unreachable and
+// Administratively Prohibited
+func (e *ICMPError) hard() bool {
+	if !e.unreachable() {
+		return false
+	}
+	if e.IPv6 {
+		return e.Code == icmpv6PortUnreachable || e.Code == icmpv6CommProhibited
+	}
+	switch e.Code {
+	case icmpPortUnreachable, icmpNetProhibited, icmpHostProhibited, icmpCommProhibited:
+		return true
+	}
+	return false
+}
+
+// tooBig returns true for Fragmentation Needed and Packet Too Big messages
+func (e *ICMPError) tooBig() bool {
+	if e.IPv6 {
+		return e.Type == icmpv6PacketTooBig
+	}
+	return e.Type == icmpUnreachable && e.Code == icmpFragNeeded
+}
+
+// icmpReport is an ICMP error that the operating system queued on a socket
+type icmpReport struct {
+	err     *ICMPError
+	dst     endpoint // Destination of the packet that the error is about
+	payload []byte   // Start of that packet, past the IP header, as far as it was kept
+}
+
+// processICMP acts on the ICMP error e that hc.Read returned
+func (c *Conn) processICMP(e *ICMPError) {
+	c.amb.E(EventWarn, "ICMP: "+e.Error())
+	if c.socket.GetState() == CLOSED {
+		return
+	}
+	if e.tooBig() {
+		// The HeaderConn has lowered its MTU already
+		c.syncWithLink()
+	}
+	if e.hard() && c.socket.GetState() == REQUEST {
+		c.setError(e)
+		c.gotoCLOSED()
+		return
+	}
+	c.softErr = e
+}
+
+// SoftError returns the last soft error of the connection, such as an ICMP error that it
+// survived, and clears it, much like SO_ERROR does for sockets. It returns nil if there is none.
+func (c *Conn) SoftError() error {
+	var err error
+	c.do(func() {
+		err = c.softErr
+		c.softErr = nil
+	})
+	return err
+}
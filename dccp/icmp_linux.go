@@ -0,0 +1,163 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Origins of the errors in the error queue of a socket, see SO_EE_ORIGIN_* in linux/errqueue.h
+const (
+	soEEOriginLocal = 1
+	soEEOriginICMP  = 2
+	soEEOriginICMP6 = 3
+)
+
+// enableICMP asks the kernel to queue the ICMP errors about the packets that c sends, see
+// IP_RECVERR in ip(7), so that readICMP can fetch them
+func enableICMP(c syscall.Conn) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var err4, err6 error
+	err = rc.Control(func(fd uintptr) {
+		// An IPv6 socket takes both, the first for IPv4-mapped addresses
+		err4 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVERR, 1)
+		err6 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR, 1)
+	})
+	if err != nil {
+		return err
+	}
+	if err4 != nil && err6 != nil {
+		return err4
+	}
+	return nil
+}
+
+// readICMP fetches the ICMP errors queued on c, without waiting for more. The kernel also
+// reports a queued error as the error of the next read from c; the returned bool is true if
+// the read error err is one of those, rather than a failure of the socket.
+func readICMP(c syscall.Conn, err error) ([]icmpReport, bool) {
+	rc, cerr := c.SyscallConn()
+	if cerr != nil {
+		return nil, false
+	}
+	var reports []icmpReport
+	rc.Read(func(fd uintptr) bool {
+		for {
+			buf, oob := make([]byte, 64), make([]byte, 256)
+			n, oobn, _, from, rerr := syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if rerr != nil {
+				return true
+			}
+			if r, ok := parseErrQueue(buf[:n], oob[:oobn], from); ok {
+				reports = append(reports, r)
+			}
+		}
+	})
+	return reports, len(reports) > 0 || isICMPErrno(err)
+}
+
+// parseErrQueue parses a message from the error queue of a socket: the start p of the packet
+// that the error is about, the control message oob that describes the error, and the
+// destination from of the packet
+func parseErrQueue(p, oob []byte, from syscall.Sockaddr) (icmpReport, bool) {
+	var r icmpReport
+	switch sa := from.(type) {
+	case *syscall.SockaddrInet4:
+		r.dst = endpoint{IP: net.IP(append([]byte{}, sa.Addr[:]...)), Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		r.dst = endpoint{IP: net.IP(append([]byte{}, sa.Addr[:]...)), Port: sa.Port, Zone: zoneName(sa.ZoneId)}
+	default:
+		return r, false
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return r, false
+	}
+	for _, m := range msgs {
+		if (m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_RECVERR) ||
+			(m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_RECVERR) {
+			r.err = parseExtendedErr(m.Data, r.dst.IP.To4() == nil)
+		}
+	}
+	r.payload = p
+	return r, r.err != nil
+}
+
+// parseExtendedErr returns the ICMPError that the struct sock_extended_err b describes, or nil
+// if it is not about ICMP. Errors of local origin, of packets that do not fit the MTU of the
+// local interface, are returned as Fragmentation Needed or Packet Too Big, for a packet to an
+// IPv6 destination if v6 is true.
+func parseExtendedErr(b []byte, v6 bool) *ICMPError {
+	if len(b) < 16 {
+		return nil
+	}
+	errno := syscall.Errno(binary.NativeEndian.Uint32(b[0:4]))
+	origin, info := b[4], int(binary.NativeEndian.Uint32(b[8:12]))
+	e := &ICMPError{Type: b[5], Code: b[6]}
+	switch origin {
+	case soEEOriginICMP:
+	case soEEOriginICMP6:
+		e.IPv6 = true
+	case soEEOriginLocal:
+		if errno != syscall.EMSGSIZE {
+			return nil
+		}
+		e.IPv6 = v6
+		e.Type, e.Code = icmpUnreachable, icmpFragNeeded
+		if v6 {
+			e.Type, e.Code = icmpv6PacketTooBig, 0
+		}
+	default:
+		return nil
+	}
+	if e.tooBig() {
+		e.MTU = info
+	}
+	// The address of the sender of the message follows, see SO_EE_OFFENDER
+	if sa := b[16:]; len(sa) >= 8 {
+		switch binary.NativeEndian.Uint16(sa[0:2]) {
+		case syscall.AF_INET:
+			e.From = net.IP(append([]byte{}, sa[4:8]...))
+		case syscall.AF_INET6:
+			if len(sa) >= 24 {
+				e.From = net.IP(append([]byte{}, sa[8:24]...))
+			}
+		}
+	}
+	return e
+}
+
+// isICMPErrno returns true if err is one of the errors that the kernel reports for ICMP
+// messages, see icmp_err_convert in net/ipv4/icmp.c
+func isICMPErrno(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case syscall.ENETUNREACH, syscall.EHOSTUNREACH, syscall.ENOPROTOOPT, syscall.ECONNREFUSED,
+		syscall.EMSGSIZE, syscall.EOPNOTSUPP, syscall.EACCES, syscall.EHOSTDOWN, syscall.ENONET,
+		syscall.EPROTO:
+		return true
+	}
+	return false
+}
+
+// zoneName returns the name of the interface with index id, for the zone of an IPv6 address
+func zoneName(id uint32) string {
+	if id == 0 {
+		return ""
+	}
+	if ifi, err := net.InterfaceByIndex(int(id)); err == nil {
+		return ifi.Name
+	}
+	return ""
+}
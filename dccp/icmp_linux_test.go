@@ -0,0 +1,42 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestParseExtendedErr(t *testing.T) {
+	// sock_extended_err of a Fragmentation Needed from 192.0.2.1, followed by its sockaddr_in
+	b := make([]byte, 16+16)
+	binary.NativeEndian.PutUint32(b[0:4], uint32(syscall.EMSGSIZE))
+	b[4], b[5], b[6] = soEEOriginICMP, 3, 4
+	binary.NativeEndian.PutUint32(b[8:12], 1400)
+	binary.NativeEndian.PutUint16(b[16:18], syscall.AF_INET)
+	copy(b[20:24], []byte{192, 0, 2, 1})
+	e := parseExtendedErr(b, false)
+	if e == nil || e.IPv6 || e.MTU != 1400 || !e.tooBig() || !e.From.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("parsed %v", e)
+	}
+
+	// A packet too big for the local interface, to an IPv6 destination
+	b = make([]byte, 16)
+	binary.NativeEndian.PutUint32(b[0:4], uint32(syscall.EMSGSIZE))
+	b[4] = soEEOriginLocal
+	binary.NativeEndian.PutUint32(b[8:12], 1280)
+	e = parseExtendedErr(b, true)
+	if e == nil || !e.IPv6 || e.MTU != 1280 || !e.tooBig() || e.From != nil {
+		t.Fatalf("parsed %v", e)
+	}
+
+	// Errors of other origins are not ICMP errors
+	b[4] = 4 // SO_EE_ORIGIN_TXSTATUS
+	if e = parseExtendedErr(b, false); e != nil {
+		t.Errorf("parsed %v", e)
+	}
+}
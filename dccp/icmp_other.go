@@ -0,0 +1,15 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package dccp
+
+import "syscall"
+
+// enableICMP fails, as ICMP errors about the packets of a socket are only available on Linux
+func enableICMP(c syscall.Conn) error { return ErrUnsupported }
+
+// readICMP returns no ICMP errors, see enableICMP
+func readICMP(c syscall.Conn, err error) ([]icmpReport, bool) { return nil, false }
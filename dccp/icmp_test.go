@@ -0,0 +1,34 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestICMPError(t *testing.T) {
+	tests := []struct {
+		e                         ICMPError
+		unreachable, hard, tooBig bool
+	}{
+		{ICMPError{Type: 3, Code: 3}, true, true, false},
+		{ICMPError{Type: 3, Code: 2}, true, false, false},
+		{ICMPError{Type: 3, Code: 13}, true, true, false},
+		{ICMPError{Type: 3, Code: 4, MTU: 1280}, true, false, true},
+		{ICMPError{Type: 11, Code: 0}, false, false, false},
+		{ICMPError{IPv6: true, Type: 1, Code: 4}, true, true, false},
+		{ICMPError{IPv6: true, Type: 1, Code: 1}, true, true, false},
+		{ICMPError{IPv6: true, Type: 1, Code: 0}, true, false, false},
+		{ICMPError{IPv6: true, Type: 2, MTU: 1280}, false, false, true},
+		{ICMPError{IPv6: true, Type: 3}, false, false, false},
+	}
+	for _, test := range tests {
+		e := &test.e
+		if errors.Is(e, ErrUnreachable) != test.unreachable || e.hard() != test.hard || e.tooBig() != test.tooBig {
+			t.Errorf("%s: unreachable %v, hard %v, too big %v", e, errors.Is(e, ErrUnreachable), e.hard(), e.tooBig())
+		}
+	}
+}
@@ -4,7 +4,10 @@
 
 package dccp
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // writeHeader annotates a Header with some additional information regarding how
 // its seq and ack numbers should be filled in. This is needed because a writeHeader
@@ -14,27 +17,26 @@ type writeHeader struct {
 	Header
 	SeqAckType   int
 	InResponseTo *Header
+	mtuProbe     bool  // True for a Sync that probes the path MTU, see writeMTUProbe
+	last         bool  // True for the packet that ends the connection, see injectLast
+	expire       int64 // Deadline of the application data, see MsgOptions
 }
 
 // inject adds the packet h to the outgoing non-Data pipeline, without blocking.  The
 // pipeline is flushed continuously respecting the CongestionControl's rate-limiting policy.
 //
-// inject is called at most once (currently) from inside readLoop and inside a lock
-// on Conn, so it must not block, hence writeNonData has buffer space
+// inject is called on loop, which must not block, hence writeNonData has buffer space
 func (c *Conn) inject(h *writeHeader) {
-	c.writeNonDataLk.Lock()
-	defer c.writeNonDataLk.Unlock()
-
-	if c.writeNonData == nil {
+	if c.writeNonDataClosed {
 		return
 	}
 
 	// Catch outgoing non-Data packets for debug purposes here
 	// c.emitCatchSeqNo(h, 161019, 161020, 161021)
 
-	// Dropping a nil is OK, since it happens only if there are other packets in the queue
 	if len(c.writeNonData) < cap(c.writeNonData) {
 		c.writeNonData <- h
+		c.env.Sent(&c.nonDataT)
 	} else {
 		// This first emit is a workaround. The inspector does not recognize drop events,
 		// unless they have been preceeded by a write event.
@@ -45,15 +47,79 @@ func (c *Conn) inject(h *writeHeader) {
 	}
 }
 
+// injectLast discards the non-Data packets that are still waiting to be written and queues
+// the packet h, which ends the connection, in their place. Packets queued before a Reset are
+// moot once the connection is torn down, and they must not crowd the Reset out of a slow link.
+func (c *Conn) injectLast(h *writeHeader) {
+	if c.writeNonDataClosed {
+		return
+	}
+	h.last = true
+	for {
+		select {
+		case g := <-c.writeNonData:
+			c.env.Received(&c.nonDataT)
+			c.amb.E(EventDrop, "Superseded", g)
+			continue
+		default:
+		}
+		break
+	}
+	c.writeNonData <- h
+	c.env.Sent(&c.nonDataT)
+}
+
+// WriteFeatures places any outstanding feature negotiation options on h
+func (c *Conn) WriteFeatures(h *Header) {
+	h.Options = append(h.Options, c.features.Options(h.Type)...)
+}
+
+// WriteAckVector places an Ack Vector option on h, if the Send Ack Vector feature is on and h
+// carries an Acknowledgement Number, Section 11.5
+func (c *Conn) WriteAckVector(h *Header) {
+	if c.features.Local(FeatureSendAckVector) == 0 || !h.HasAckNo() || h.Type == Reset {
+		return
+	}
+	if opt := c.ackVector.Option(h.AckNo); opt != nil {
+		h.Options = append(h.Options, opt)
+	}
+}
+
+// WriteNDPCount places an NDP Count option on h, if the Send NDP Count feature is on and h
+// follows one or more non-data packets, and then updates the count, Section 7.7
+func (c *Conn) WriteNDPCount(h *Header) {
+	if c.features.Local(FeatureSendNDPCount) != 0 && c.ndpCount > 0 {
+		opt, err := (&NDPCountOption{c.ndpCount}).Encode()
+		if err != nil {
+			panic("problem encoding ndp count")
+		}
+		h.Options = append(h.Options, opt)
+	}
+	if h.Type == Data || h.Type == DataAck {
+		c.ndpCount = 0
+	} else {
+		c.ndpCount++
+	}
+}
+
+// WriteECN marks h as ECN-capable, unless either side cannot carry ECN, Section 12.1
+func (c *Conn) WriteECN(h *Header) {
+	if c.ecn && c.features.Remote(FeatureECNIncapable) == 0 {
+		h.ECN = ECNECT0
+	} else {
+		h.ECN = ECNNotECT
+	}
+}
+
 func (c *Conn) WriteCC(h *Header, timeWrite int64) {
 	// HC-Sender CCID
-	ccval, sropts := c.scc.OnWrite(&PreHeader{Type: h.Type, X: h.X, SeqNo: h.SeqNo, AckNo: h.AckNo, TimeWrite: timeWrite})
+	ccval, sropts := c.scc.OnWrite(&PreHeader{Type: h.Type, X: h.X, SeqNo: h.SeqNo, AckNo: h.AckNo, TimeWrite: timeWrite, DataLen: h.DataLen()})
 	if !validateCCIDSenderToReceiver(sropts) {
 		panic("sender congestion control writes disallowed options")
 	}
 	h.CCVal = ccval
 	// HC-Receiver CCID
-	rsopts := c.rcc.OnWrite(&PreHeader{Type: h.Type, X: h.X, SeqNo: h.SeqNo, AckNo: h.AckNo, TimeWrite: timeWrite})
+	rsopts := c.rcc.OnWrite(&PreHeader{Type: h.Type, X: h.X, SeqNo: h.SeqNo, AckNo: h.AckNo, TimeWrite: timeWrite, DataLen: h.DataLen()})
 	if !validateCCIDReceiverToSender(rsopts) {
 		panic("receiver congestion control writes disallowed options")
 	}
@@ -63,7 +129,26 @@ func (c *Conn) WriteCC(h *Header, timeWrite int64) {
 }
 
 func (c *Conn) write(h *writeHeader) error {
-	c.scc.Strobe()
+	// The sender CCID limits the rate of the packets that carry application data. Acks,
+	// Syncs and the like are not held back behind a rate that may have decayed to next to
+	// nothing while the connection was idle.
+	if h.Type == Data || h.Type == DataAck {
+		c.scc.Strobe()
+	}
+
+	// Application data can expire while the CCID holds it back. It is discarded before it
+	// takes up a sequence number.
+	if h.expire != 0 && c.env.nowNano() > h.expire {
+		c.amb.E(EventDrop, "Expired", h)
+		return nil
+	}
+
+	// The options of h are only needed until a HeaderConn that encodes h has written it
+	if _, ok := c.hc.(headerEncoder); ok {
+		opts := newOptions()
+		defer releaseOptions(opts)
+		h.Options = append(*opts, h.Options...)
+	}
 
 	// Tell the CCID about h right before it gets sent, so we can fill in
 	// the nearly exact time of sending.  This way, the roundtrip
@@ -72,75 +157,106 @@ func (c *Conn) write(h *writeHeader) error {
 
 	// XXX: Should the AckNo also be filled in here, right before the packet goes out and
 	// before the CCID gets to see it?
-	c.Lock()
-	c.WriteSeqAck(h)
-	c.WriteCC(&h.Header, c.writeTime.Now())
-	c.Unlock()
+	var pc *pcapCapture
+	c.do(func() {
+		c.WriteSeqAck(h)
+		c.WriteAckVector(&h.Header)
+		c.WriteDataDropped(&h.Header)
+		c.WriteCsCov(&h.Header)
+		c.WriteDataChecksum(&h.Header)
+		c.WriteFeatures(&h.Header)
+		c.WriteInitCookies(&h.Header)
+		c.WriteECN(&h.Header)
+		c.WriteAckRatio(&h.Header)
+		c.WriteNDPCount(&h.Header)
+		c.WriteCC(&h.Header, c.writeTime.Now())
+		if h.mtuProbe {
+			c.writeMTUProbe(h)
+		}
+		c.countWrite(h)
+		c.lastWrite = c.env.nowNano()
+		pc = c.capturer()
+	})
 
 	c.amb.E(EventWrite, "Write to header link", h)
-	return c.hc.Write(&h.Header)
+	err := c.hc.Write(&h.Header)
+	if err == nil {
+		pc.capture(c.env, &h.Header, true)
+	}
+	if errors.Is(err, ErrTooBig) {
+		// A packet beyond the path MTU is lost, as it would be in the network, but the
+		// connection lives on
+		c.amb.E(EventDrop, "Too big", h)
+		if h.mtuProbe {
+			c.do(c.failMTUProbe)
+		}
+		return nil
+	}
+	return err
 }
 
-// writeLoop() sends headers incoming on the writeData and writeNonData channels, while
-// giving priority to writeNonData. It continues to do so until writeNonData is closed.
-func (c *Conn) writeLoop(writeNonData chan *writeHeader, writeData chan []byte) {
+// writeLoop() sends headers incoming on the writeNonData channel and application data from
+// the writeData queue, while giving priority to writeNonData. It continues to do so until
+// writeNonData is closed. It leaves the state of the connection to loop, and only waits for
+// the sender CCID and the HeaderConn, see do.
+func (c *Conn) writeLoop() {
 
 	// The presence of multiple loops below allows user calls to Write to
-	// block in "writeNonData <-" while the connection moves into a state where
+	// block in the writeData queue until the connection moves into a state where
 	// it accepts app data (in _Loop_II)
 
-	// This loop is active until state OPEN or PARTOPEN is observed, when a
+	// This loop is active until state OPEN or PARTOPEN is reached, when a
 	// transition to _Loop II_is made
 	c.amb.E(EventInfo, "Write Loop I")
-_Loop_I:
-
 	for {
-		h, ok := <-writeNonData
-		if !ok {
-			// Closing writeNonData means that the Conn is done and dead
-			goto _Exit
-		}
-		// We'll allow nil headers, since they can be used to trigger unblock
-		// from the above send operator and (without resulting into an actual
-		// send) activate the state check after the "if" statement below
-		if h != nil {
-			err := c.write(h)
-			// If the underlying layer is broken, abort
-			if err != nil {
-				c.abortQuietly()
+		c.env.Wait(&c.nonDataT, &c.dataOpenT)
+		select {
+		case h, ok := <-c.writeNonData:
+			c.env.Woken(&c.nonDataT, &c.dataOpenT)
+			if !ok {
+				// Closing writeNonData means that the Conn is done and dead
 				goto _Exit
 			}
-		}
-		c.Lock()
-		state := c.socket.GetState()
-		c.Unlock()
-		switch state {
-		case OPEN, PARTOPEN:
+			c.env.Received(&c.nonDataT)
+			if err := c.write(h); err != nil {
+				// If the underlying layer is broken, abort
+				c.do(c.abortQuietly)
+				goto _Exit
+			}
+		case <-c.dataOpen:
+			c.env.Woken(&c.nonDataT, &c.dataOpenT)
 			goto _Loop_II
 		}
-		continue _Loop_I
 	}
 
+_Loop_II:
 	// This loop is active until writeData is not closed
 	c.amb.E(EventInfo, "Write Loop II")
-_Loop_II:
-
 	for {
 		var h *writeHeader
 		var ok bool
-		var appData []byte
+		q := c.writeData
+		c.env.Wait(&c.nonDataT, &q.doneT, &q.readyT)
 		select {
 		// Note that non-Data packets take precedence
-		case h, ok = <-writeNonData:
+		case h, ok = <-c.writeNonData:
+			c.env.Woken(&c.nonDataT, &q.doneT, &q.readyT)
 			if !ok {
 				// Closing writeNonData means that the Conn is done and dead
 				goto _Exit
 			}
-		case appData, ok = <-writeData:
-			if !ok {
-				// When writeData is closed, we transition to the 3rd loop,
-				// which accepts only non-Data packets
-				goto _Loop_III
+			c.env.Received(&c.nonDataT)
+		case <-q.done:
+			c.env.Woken(&c.nonDataT, &q.doneT, &q.readyT)
+			// When writeData is closed, we transition to the 3rd loop,
+			// which accepts only non-Data packets
+			goto _Loop_III
+		case <-q.ready:
+			c.env.Woken(&c.nonDataT, &q.doneT, &q.readyT)
+			c.env.Received(&q.readyT)
+			m := q.pop()
+			if m == nil {
+				continue
 			}
 			// By virtue of being in _Loop_II (which implies we have been or are in OPEN
 			// or PARTOPEN), we know that some packets of the other side have been
@@ -151,38 +267,43 @@ _Loop_II:
 			// XXX: I am not sure if Header.Data == nil (rather than
 			// Header.Data = []byte{}) would cause a problem in Header.Write
 			// It should be that it doesn't. Must verify this.
-			c.Lock()
-			h = c.generateDataAck(appData)
-			c.Unlock()
+			c.do(func() {
+				// A block that fit when it was written, but waited in the queue while the
+				// path MTU shrank, would be lost on the path and cost a congestion event
+				if c.syncWithLink(); m.size() <= m.mtu && m.size() > c.getMTU() {
+					c.amb.E(EventDrop, "Beyond MTU")
+					return
+				}
+				h = c.generateDataAck(m.data)
+				h.DataVec = m.vec
+				h.expire = m.expire
+			})
 		}
 		if h != nil {
 			err := c.write(h)
 			if err != nil {
-				c.abortQuietly()
+				c.do(c.abortQuietly)
 				goto _Exit
 			}
 		}
 	}
 
+_Loop_III:
 	// This loop is active until writeNonData is not closed
 	c.amb.E(EventInfo, "Write Loop III")
-_Loop_III:
-
 	for {
-		h, ok := <-writeNonData
+		c.env.Wait(&c.nonDataT)
+		h, ok := <-c.writeNonData
+		c.env.Woken(&c.nonDataT)
 		if !ok {
 			// Closing writeNonData means that the Conn is done and dead
 			goto _Exit
 		}
-		// We'll allow nil headers, since they can be used to trigger unblock
-		// from the above send operator
-		if h != nil {
-			err := c.write(h)
+		c.env.Received(&c.nonDataT)
+		if err := c.write(h); err != nil {
 			// If the underlying layer is broken, abort
-			if err != nil {
-				c.abortQuietly()
-				goto _Exit
-			}
+			c.do(c.abortQuietly)
+			goto _Exit
 		}
 	}
 
@@ -24,6 +24,8 @@ type Joiner interface {
 // GoRoutine represents a running goroutine.
 type GoRoutine struct {
 	ch   chan int
+	exit Track // Accounts for ch on the clock of env
+	env  *Env  // Env of a goroutine of Env.Go on virtual time, or nil
 	file string
 	line int
 	anno string
@@ -32,6 +34,11 @@ type GoRoutine struct {
 // Go runs f in a new goroutine and returns a handle object, which can
 // then be used for various synchronization mechanisms.
 func GoCaller(f func(), skip int, fmt_ string, args_ ...interface{}) *GoRoutine {
+	return goCaller(nil, f, 1+skip, fmt_, args_...)
+}
+
+// goCaller is GoCaller for a goroutine that env counts, if env is virtual, see virtualClock
+func goCaller(env *Env, f func(), skip int, fmt_ string, args_ ...interface{}) *GoRoutine {
 	sfile, sline := FetchCaller(1 + skip)
 	ch := make(chan int)
 	g := &GoRoutine{ 
@@ -40,9 +47,20 @@ func GoCaller(f func(), skip int, fmt_ string, args_ ...interface{}) *GoRoutine
 		line: sline,
 		anno: fmt.Sprintf(fmt_, args_...),
 	}
+	if env == nil || env.virtual == nil {
+		go func() {
+			f()
+			close(ch)
+		}()
+		return g
+	}
+	g.env = env
+	env.virtual.Start()
 	go func() {
 		f()
+		env.Closed(&g.exit)
 		close(ch)
+		env.virtual.Exit()
 	}()
 	return g
 }
@@ -55,7 +73,9 @@ func Go(f func(), fmt_ string, args_ ...interface{}) *GoRoutine {
 // if the goroutine has completed, it returns immediately.
 // Join can be called concurrently.
 func (g *GoRoutine) Join() {
+	g.env.Wait(&g.exit)
 	_, _ = <-g.ch
+	g.env.Woken(&g.exit)
 }
 
 // Source returns the file and line where the goroutine was forked.
@@ -111,6 +131,8 @@ type GoJoin struct {
 	srcLine    int
 	annotation string
 
+	env     *Env		// Virtual Env whose clock Join waits on, or nil
+
 	lk      sync.Mutex	// Locks the fields below
 	group   []Joiner	// Slice of joiners included in this conjunction sync
 	kdone   int		// Counts the number of Joiners that have already completed
@@ -120,8 +142,19 @@ type GoJoin struct {
 
 // NewGoJoinCaller creates an object capable of waiting until all supplied GoRoutines complete.
 func NewGoJoinCaller(skip int, annotation string, group ...Joiner) *GoJoin {
+	return newGoJoin(nil, 1+skip, annotation, group...)
+}
+
+// newGoJoin is NewGoJoinCaller for a GoJoin whose Join waits on the clock of env, if env is
+// virtual. Such a GoJoin joins its Joiners in turn, on the goroutine that calls Join, as the
+// goroutines of the Env must wait on the Env for its clock to move.
+func newGoJoin(env *Env, skip int, annotation string, group ...Joiner) *GoJoin {
 	sfile, sline := FetchCaller(1 + skip)
+	if env != nil && env.virtual == nil {
+		env = nil
+	}
 	var w *GoJoin = &GoJoin{ 
+		env:        env,
 		srcFile:    sfile,
 		srcLine:    sline,
 		annotation: annotation,
@@ -154,6 +187,9 @@ func (t *GoJoin) Add(u Joiner) {
 		panic("adding joiners after conjunction event")
 	}
 	t.group = append(t.group, u)
+	if t.env != nil {
+		return
+	}
 	ch := t.ch
 	go func(){
 		u.Join()
@@ -183,6 +219,10 @@ func (t *GoJoin) Join() {
 	if n == 0 {
 		panic("waiting on 0 goroutines")
 	}
+	if t.env != nil {
+		t.joinInTurn()
+		return
+	}
 
 	for t.stillRemain() {
 		_, ok := <-ch
@@ -203,6 +243,20 @@ func (t *GoJoin) Join() {
 	}
 }
 
+// joinInTurn joins the Joiners of the group one after the other, including those added meanwhile
+func (t *GoJoin) joinInTurn() {
+	for i := 0; ; i++ {
+		t.lk.Lock()
+		if i == len(t.group) {
+			t.lk.Unlock()
+			return
+		}
+		u := t.group[i]
+		t.lk.Unlock()
+		u.Join()
+	}
+}
+
 func (t* GoJoin) stillRemain() bool {
 	t.lk.Lock()
 	defer t.lk.Unlock()
@@ -0,0 +1,92 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// JSONTrace is the JSON object that JSONTraceWriter emits for a Trace. It keeps the fields that
+// analysis scripts need, under readable names, and leaves out the source positions and stack
+// traces that FileTraceWriter saves for the inspector.
+type JSONTrace struct {
+	Time    int64    `json:"time"`            // DCCP runtime time of the event, in ns
+	Labels  []string `json:"labels"`          // Label stack of the Amb that emitted the event
+	Event   string   `json:"event"`           // Kind of event, like "Write" or "Warn"
+	State   string   `json:"state,omitempty"` // State of the connection, if known
+	Type    string   `json:"type,omitempty"`  // Packet type, if the event is about a packet
+	SeqNo   *int64   `json:"seqno,omitempty"` // Sequence number, if the event is about a packet
+	AckNo   *int64   `json:"ackno,omitempty"` // Acknowledgement number, if the event is about a packet
+	Comment string   `json:"comment,omitempty"`
+	Sample  *Sample  `json:"sample,omitempty"` // Data point of a time series, if the event carries one
+}
+
+// NewJSONTrace returns the JSON object of the trace r
+func NewJSONTrace(r *Trace) *JSONTrace {
+	j := &JSONTrace{
+		Time:    r.Time,
+		Labels:  r.Labels,
+		Event:   r.Event.String(),
+		State:   r.State,
+		Type:    r.Type,
+		Comment: r.Comment,
+	}
+	if r.Type != "" {
+		seqNo, ackNo := r.SeqNo, r.AckNo
+		j.SeqNo, j.AckNo = &seqNo, &ackNo
+	}
+	j.Sample, _ = r.Sample()
+	return j
+}
+
+// JSONTraceWriter is a TraceWriter that writes each trace to an io.Writer as a JSONTrace
+// object on a line of its own, which makes traces easy to parse for analysis scripts.
+type JSONTraceWriter struct {
+	sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+	err error // First error of the underlying writer
+}
+
+// NewJSONTraceWriter creates a JSONTraceWriter that writes to w. Sync and Close pass on to w, if
+// it has methods of that name, like *os.File does.
+func NewJSONTraceWriter(w io.Writer) *JSONTraceWriter {
+	return &JSONTraceWriter{w: w, enc: json.NewEncoder(w)}
+}
+
+// Write implements TraceWriter.Write. Once the underlying writer fails, traces are dropped and
+// the error is reported by Sync and Close.
+func (t *JSONTraceWriter) Write(r *Trace) {
+	t.Lock()
+	defer t.Unlock()
+	if t.err != nil {
+		return
+	}
+	t.err = t.enc.Encode(NewJSONTrace(r))
+}
+
+// Sync implements TraceWriter.Sync
+func (t *JSONTraceWriter) Sync() error {
+	t.Lock()
+	defer t.Unlock()
+	if s, ok := t.w.(interface{ Sync() error }); ok && t.err == nil {
+		t.err = s.Sync()
+	}
+	return t.err
+}
+
+// Close implements TraceWriter.Close
+func (t *JSONTraceWriter) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	if c, ok := t.w.(io.Closer); ok {
+		if err := c.Close(); t.err == nil {
+			t.err = err
+		}
+	}
+	return t.err
+}
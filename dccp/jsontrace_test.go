@@ -0,0 +1,46 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONTraceWriter(t *testing.T) {
+	var buf bytes.Buffer
+	env := NewEnv(NewJSONTraceWriter(&buf))
+	amb := NewAmb("client", env).Refine("conn")
+	amb.E(EventWrite, "Write to header link", &Header{Type: DataAck, SeqNo: 5, AckNo: 3})
+	amb.E(EventInfo, "rtt", NewSample("rtt", 1.5, "ms"))
+	if err := env.TraceWriter().Sync(); err != nil {
+		t.Fatalf("sync (%s)", err)
+	}
+
+	var r []JSONTrace
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		var j JSONTrace
+		if err := json.Unmarshal(s.Bytes(), &j); err != nil {
+			t.Fatalf("line %q is not JSON (%s)", s.Text(), err)
+		}
+		r = append(r, j)
+	}
+	if len(r) != 2 {
+		t.Fatalf("expecting 2 traces, encountered %d", len(r))
+	}
+	w := r[0]
+	if w.Event != "Write" || w.Type != "DataAck" || w.SeqNo == nil || *w.SeqNo != 5 ||
+		w.AckNo == nil || *w.AckNo != 3 || w.Comment != "Write to header link" ||
+		len(w.Labels) != 2 || w.Labels[1] != "conn" || w.Time < 0 {
+		t.Errorf("unexpected trace %+v", w)
+	}
+	i := r[1]
+	if i.Event != "Info" || i.SeqNo != nil || i.Sample == nil || i.Sample.Value != 1.5 {
+		t.Errorf("unexpected trace %+v", i)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"net"
+	"time"
+)
+
+// DefaultKeepalive is the keepalive interval of connections over UDP, see SetKeepalive. It is
+// well within the two minutes after which NATs may drop idle UDP bindings, RFC 4787, Section
+// 4.3, and no shorter than the fifteen seconds that RFC 8085, Section 3.5, allows keepalives.
+const DefaultKeepalive = 15 * time.Second
+
+// defaultKeepalive returns the keepalive interval that suits the HeaderConn hc: DefaultKeepalive
+// for the flows of a UDPEncap or of a Mux over UDP, whose bindings in NATs and firewalls expire
+// when idle, and zero for all others
+func defaultKeepalive(hc HeaderConn) int64 {
+	if _, ok := linkAddr(hc).(*net.UDPAddr); ok {
+		return int64(DefaultKeepalive)
+	}
+	return 0
+}
+
+// SetKeepalive makes the connection send a Sync, Section 7.5, whenever it has sent nothing for
+// interval while OPEN. The Sync and the SyncAck in response keep the bindings of NATs and
+// firewalls on the path alive. A zero interval turns keepalives off. Connections over UDP start
+// with DefaultKeepalive, and others with keepalives off. See also SetIdleTimeout.
+func (c *Conn) SetKeepalive(interval time.Duration) error {
+	if interval < 0 {
+		return ErrInvalid
+	}
+	c.do(func() { c.keepalive = int64(interval) })
+	return nil
+}
+
+// pollKeepalive sends a Sync if the connection has been silent for the keepalive interval. The
+// idle loop calls it about once per round-trip time.
+func (c *Conn) pollKeepalive() {
+	if c.keepalive <= 0 || c.socket.GetState() != OPEN {
+		return
+	}
+	now := c.env.nowNano()
+	if now-c.lastWrite < c.keepalive {
+		return
+	}
+	c.amb.E(EventInfo, "Keepalive")
+	// Another keepalive waits for another interval, even if this one is slow to get written
+	c.lastWrite = now
+	c.inject(c.generateSync())
+}
+
+// SetIdleTimeout makes the connection reset itself, with Reset Code 2, "Aborted", once nothing
+// has been received from the other side for timeout while OPEN. Reads and writes then fail with
+// ErrIdleTimeout, which, unlike ErrTimeout, is not temporary. A server thus lets go of the
+// connections of clients that vanished without closing them. The timeout should be a few
+// keepalive intervals of the other side, as keepalive Syncs are all that an idle but live
+// connection receives. A zero timeout, the default, turns the idle timeout off.
+func (c *Conn) SetIdleTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return ErrInvalid
+	}
+	c.do(func() { c.idleTimeout = int64(timeout) })
+	return nil
+}
+
+// pollIdleTimeout resets the connection if the other side has been silent for the idle
+// timeout. The idle loop calls it about once per round-trip time.
+func (c *Conn) pollIdleTimeout() {
+	if c.idleTimeout <= 0 || c.socket.GetState() != OPEN {
+		return
+	}
+	if c.env.nowNano()-c.lastRead < c.idleTimeout {
+		return
+	}
+	c.amb.E(EventWarn, "Idle timeout")
+	c.reset(ResetAborted, ErrIdleTimeout)
+}
@@ -0,0 +1,36 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDefaultKeepalive(t *testing.T) {
+	e, err := BindUDPEncap("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("bind (%s)", err)
+	}
+	defer e.Close()
+	hc, err := e.Dial(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: EncapPort})
+	if err != nil {
+		t.Fatalf("dial (%s)", err)
+	}
+	if k := defaultKeepalive(hc); k != int64(DefaultKeepalive) {
+		t.Errorf("keepalive over DCCP-UDP %d, expected %d", k, int64(DefaultKeepalive))
+	}
+
+	p, _ := NewChanPipe()
+	m := NewMux(p)
+	defer m.Close()
+	bc, err := m.Dial(nil)
+	if err != nil {
+		t.Fatalf("mux dial (%s)", err)
+	}
+	if k := defaultKeepalive(NewHeaderConn(bc)); k != 0 {
+		t.Errorf("keepalive over a channel %d, expected none", k)
+	}
+}
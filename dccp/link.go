@@ -28,7 +28,8 @@ type Link interface {
 	// SegmentConn.
 	ReadFrom(buf []byte) (n int, addr net.Addr, err error)
 
-	// WriteTo sends a packet of data
+	// WriteTo sends a packet of data. It must not retain buf once it returns, as the Mux
+	// reuses the buffer for later packets.
 	WriteTo(buf []byte, addr net.Addr) (n int, err error)
 
 	// SetReadDeadline has the same meaning as net.Conn.SetReadDeadline
@@ -1,97 +1,116 @@
 // Copyright 2011-2013 GoDCCP Authors. All rights reserved.
-// Use of this source code is governed by a 
+// Use of this source code is governed by a
 // license that can be found in the LICENSE file.
 
 package dccp
 
-/*
 import (
+	"context"
 	"net"
 )
 
+// DefaultListenBacklog is the number of half-open connections that a Port admits
+const DefaultListenBacklog = 64
 
-// Listener{} takes care of listening for incoming connections.
-// It handles the LISTEN state.
+// Listener accepts the DCCP connections to some service codes of a Port. Every incoming flow
+// gets a server Conn of its own, and the handshakes of different flows proceed concurrently.
+// Accept returns the connections whose handshake has completed.
 type Listener struct {
-	m     *Mux
-	conns []*Conn	// List of active connections
-			// TODO: Lookups in a short array should be fine for now. Hashing?
+	port         *Port
+	serviceCodes []ServiceCode
+	ownsPort     bool          // Closing the Listener closes the Port, see NewListener
+	accept       chan *Conn    // Fed by Port.handshake
+	closing      chan struct{} // Closed by shut
+
+	Mutex
+	closed        bool
+	requestFilter RequestFilter
 }
 
-func Listen(m *Mux) net.Listener {
-	l := &Listener{ m: m, }
-	go l.loop()
-	return l
+// NewListener creates a Listener that accepts connections on link, with congestion control
+// ccid. If serviceCodes are given, only Requests for one of them are accepted, RFC 5595,
+// Section 2. The Listener has the link to itself; to serve several Listeners on one link, use
+// NewPort.
+func NewListener(link Link, ccid CCID, serviceCodes ...ServiceCode) *Listener {
+	return NewPort(link, ccid).ownListener(serviceCodes...)
 }
 
-// loop() reads and processes incoming packets
-func (l *Listener) loop() {
-	for {
-		h,err := e.readPacket(l.phy, zeroFlowID)
-		if err != nil {
-			continue XX // no continue
-		}
-		?
+// ownListener returns a Listener for serviceCodes that owns the new Port p
+func (p *Port) ownListener(serviceCodes ...ServiceCode) *Listener {
+	l := newListener(p, serviceCodes)
+	l.ownsPort = true
+	p.Lock()
+	defer p.Unlock()
+	if len(serviceCodes) == 0 {
+		p.wildcard = l
 	}
+	for _, sc := range serviceCodes {
+		p.listeners[sc] = l
+	}
+	return l
 }
 
-// readAndSwitch() reads an incoming packet and sends it over to
-// its Conn{} destination if any, or returns it otherwise
-func (l *listener) readAndSwitch() (h *Header, err os.Error) {
-	h, err = read(l.phy)
-	if err != nil {
-		return 
+func newListener(p *Port, serviceCodes []ServiceCode) *Listener {
+	return &Listener{
+		port:         p,
+		serviceCodes: serviceCodes,
+		accept:       make(chan *Conn),
+		closing:      make(chan struct{}),
 	}
-	?
 }
 
-// read() reads the next buffer of data from the link layer
-// and tries to parse it into a valid Header{}
-func read(r Reader) (*Header, os.Error) {
+// Accept implements net.Listener.Accept
+func (l *Listener) Accept() (net.Conn, error) {
+	return l.AcceptDCCP()
+}
 
-	// Read packet from physical layer
-	buf, phyFlowID, err := r.Read()
-	if err != nil {
-		return nil, err
-	}
-	// Parse generic header
-	h, err := ReadHeader(buf, zeroPhyFlowID.SourceAddr, zeroPhyFlowID.DestAddr, AnyProto, false)
-	if err != nil {
-		return nil, err
-	}
-	// Ensure extended SeqNo's 
-	if !h.X {
-		return nil, ErrUnsupported
+// AcceptDCCP blocks until the handshake of an incoming connection completes and returns the
+// connection. It returns ErrBad once the Listener is closed.
+func (l *Listener) AcceptDCCP() (*Conn, error) {
+	return l.AcceptContext(context.Background())
+}
+
+// AcceptContext is like AcceptDCCP, except that it also returns, with the error of ctx, when
+// ctx is done. Connections that complete their handshake later are left for the next Accept.
+func (l *Listener) AcceptContext(ctx context.Context) (*Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closing:
+		return nil, ErrBad
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	return h, nil
 }
 
-func arrayEqual(a,b []byte) bool {
-	if len(a) != len(b) {
-		return false
+// Close implements net.Listener.Close. It stops accepting connections to the service codes
+// of the Listener, which become available to Port.Listen again, and aborts the connections
+// that have not been returned by Accept yet. The connections returned by Accept are not
+// affected. A Listener created by NewListener also closes its Port.
+func (l *Listener) Close() error {
+	if !l.shut() {
+		return ErrBad
 	}
-	for i := 0; i < len(a); i++ {
-		if a[i] != b[i] {
-			return false
-		}
+	l.port.remove(l)
+	if l.ownsPort {
+		l.port.Close()
 	}
-	return true
+	return nil
 }
 
-func (l *Listener) Accept() (c Conn, err os.Error) {
-	? //XX
-	h,err := e.readPacket()
-	if err != nil {
-		return nil, err
+// shut marks the Listener closed. It returns false if it already was.
+func (l *Listener) shut() bool {
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		return false
 	}
-
+	l.closed = true
+	close(l.closing)
+	return true
 }
 
-func (l *Listener) Close() os.Error {
-	? //XX
-	return l.phy.Close();
+// Addr implements net.Listener.Addr. It returns the address of the Port.
+func (l *Listener) Addr() net.Addr {
+	return l.port.Addr()
 }
-
-func (l *Listener) Addr() net.Addr { return DefaultAddr }
-
-*/
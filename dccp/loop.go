@@ -0,0 +1,207 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The state of a connection belongs to the goroutine of loop, which handles the packets that
+// arrive, the calls of the application and the timers of the connection one at a time, so no
+// lock guards it. Any other goroutine hands loop a function to run, see do. readLoop only reads
+// from the HeaderConn and writeLoop only waits for the sender CCID and writes to the HeaderConn,
+// so that neither a slow read nor a slow write holds up the loop. The channels that feed the
+// loop are bounded: readLoop blocks once in is full, a call blocks until the loop takes it,
+// and a connection has no more than a few timers due at a time.
+
+// readResult is a header read by readLoop, or the error of the read
+type readResult struct {
+	h   *Header
+	err error
+}
+
+// connCall is a function that do hands to loop, and the channel that reports it done
+type connCall struct {
+	f    func()
+	done chan struct{}
+}
+
+var connCalls = sync.Pool{
+	New: func() interface{} { return &connCall{done: make(chan struct{}, 1)} },
+}
+
+// do runs f on the goroutine of loop and returns once f has returned. Once the connection is
+// CLOSED and loop has exited, f runs on the calling goroutine instead, one call at a time.
+// Functions that run on loop, like the steps of packet processing and the timers, must not
+// call do, or methods that use it.
+func (c *Conn) do(f func()) {
+	call := connCalls.Get().(*connCall)
+	call.f = f
+	select {
+	case c.calls <- call:
+		c.env.Sent(&c.callsT)
+		<-call.done
+	case <-c.loopDone:
+		c.exitLk.Lock()
+		f()
+		c.exitLk.Unlock()
+	}
+	call.f = nil
+	connCalls.Put(call)
+}
+
+// post queues f to run on loop. It is called by the timer wheel, and never blocks.
+func (c *Conn) post(f func()) {
+	c.dueLk.Lock()
+	c.due = append(c.due, f)
+	c.dueLk.Unlock()
+	c.env.wakeup(c.dueReady, &c.dueT)
+}
+
+// start starts the goroutines of the connection. The loop processes first, if not nil, before
+// anything else.
+func (c *Conn) start(first *Header) {
+	c.env.Go(func() { c.loop(first) }, "Conn·loop")
+	c.env.Go(c.writeLoop, "Conn·writeLoop")
+	c.env.Go(c.readLoop, "Conn·readLoop")
+	c.startIdle()
+}
+
+// loop runs the connection until it is CLOSED
+func (c *Conn) loop(first *Header) {
+	if first != nil {
+		c.processRead(readResult{h: first})
+	}
+	for c.socket.GetState() != CLOSED {
+		atomic.StoreInt64(&c.readRTT, c.socket.GetRTT())
+		c.env.Wait(&c.inT, &c.callsT, &c.dueT)
+		select {
+		case r := <-c.in:
+			c.env.Woken(&c.inT, &c.callsT, &c.dueT)
+			c.env.Received(&c.inT)
+			c.processRead(r)
+		case call := <-c.calls:
+			c.env.Woken(&c.inT, &c.callsT, &c.dueT)
+			c.env.Received(&c.callsT)
+			call.f()
+			call.done <- struct{}{}
+		case <-c.dueReady:
+			c.env.Woken(&c.inT, &c.callsT, &c.dueT)
+			c.env.Received(&c.dueT)
+			c.dueLk.Lock()
+			due := c.due
+			c.due = nil
+			c.dueLk.Unlock()
+			for _, f := range due {
+				if c.socket.GetState() == CLOSED {
+					break
+				}
+				f()
+			}
+		}
+	}
+	close(c.loopDone)
+	c.amb.E(EventInfo, "Loop EXIT")
+}
+
+// readLoop reads the headers arriving on the HeaderConn and passes them to loop, until loop
+// exits or the HeaderConn fails
+func (c *Conn) readLoop() {
+	defer c.amb.E(EventInfo, "Read loop EXIT")
+	for !isClosed(c.loopDone) {
+		var r readResult
+		if r.err = c.hc.SetReadExpire(time.Duration(5 * atomic.LoadInt64(&c.readRTT))); r.err != nil {
+			c.amb.E(EventError, "SetReadExpire")
+		} else {
+			r.h, r.err = c.readHeader()
+		}
+		// Drop packets that are unsupported. Intended for forward compatibility.
+		var pe ProtoError
+		if errors.As(r.err, &pe) {
+			continue
+		}
+		select {
+		case c.in <- r:
+			c.env.Sent(&c.inT)
+		case <-c.loopDone:
+			return
+		}
+		var ie *ICMPError
+		if r.err != nil && !errors.As(r.err, &ie) && !errors.Is(r.err, ErrTimeout) {
+			return
+		}
+	}
+}
+
+// processRead acts on a header, or a read error, of readLoop
+func (c *Conn) processRead(r readResult) {
+	if r.err != nil {
+		var ie *ICMPError
+		switch {
+		case errors.As(r.err, &ie):
+			c.processICMP(ie)
+		case errors.Is(r.err, ErrTimeout):
+			// In the event of a timeout, poll the congestion controls
+			c.pollCongestionControl()
+		default:
+			// Die if the underlying link is broken
+			c.abortQuietly()
+		}
+		return
+	}
+	h := r.h
+	c.amb.E(EventRead, "", h)
+	c.capture(h, false)
+	c.countRead(h)
+	c.syncWithCongestionControl()
+	c.syncWithLink()
+	if c.step2_ProcessTIMEWAIT(h) != nil {
+		return
+	}
+	if c.step3_ProcessLISTEN(h) != nil {
+		return
+	}
+	if c.step4_PrepSeqNoREQUEST(h) != nil {
+		return
+	}
+	if c.step5_PrepSeqNoForSync(h) != nil {
+		return
+	}
+	if c.step6_CheckSeqNo(h) != nil {
+		return
+	}
+	c.lastRead = c.env.nowNano()
+	if c.step7_CheckUnexpectedTypes(h) != nil {
+		return
+	}
+	if c.step8_OptionsAndMarkAckbl(h) != nil {
+		return
+	}
+	if c.step9_ProcessReset(h) != nil {
+		return
+	}
+	if c.step10_ProcessREQUEST2(h) != nil {
+		return
+	}
+	if c.step11_ProcessRESPOND(h) != nil {
+		return
+	}
+	if c.step12_ProcessPARTOPEN(h) != nil {
+		return
+	}
+	if c.step13_ProcessCloseReq(h) != nil {
+		return
+	}
+	if c.step14_ProcessClose(h) != nil {
+		return
+	}
+	if c.step15_ProcessSync(h) != nil {
+		return
+	}
+	c.step16_ProcessData(h)
+}
@@ -0,0 +1,176 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+// Package metrics exports the counters of the DCCP stack, and the statistics of individual
+// connections, to Prometheus. Hooking a process up takes one line:
+//
+//	c := metrics.Register()
+//
+// after which connections are added to c, under a name of choice, with c.Add.
+package metrics
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector of the process-wide counters of package dccp, see
+// dccp.ReadCounters, and of the statistics of the connections added to it, see dccp.Conn.Stats.
+// The metrics are read when Prometheus scrapes, so the stack itself does no extra work.
+type Collector struct {
+	sync.Mutex
+	conns map[*dccp.Conn]string // Connections to report, mapped to the value of their conn label
+
+	packetsSent, packetsReceived *prometheus.Desc
+	resetsSent, resetsReceived   *prometheus.Desc
+	retransmits, handshakes      *prometheus.Desc
+	activeConns, optionsParsed   *prometheus.Desc
+	checksumFailures             *prometheus.Desc
+
+	connPacketsSent, connPacketsReceived *prometheus.Desc
+	connBytesSent, connBytesReceived     *prometheus.Desc
+	connRetransmits, connLossEvents      *prometheus.Desc
+	connRTT, connRate, connCwnd          *prometheus.Desc
+}
+
+// NewCollector returns a Collector that reports no connections yet
+func NewCollector() *Collector {
+	conn := []string{"conn"}
+	return &Collector{
+		conns: make(map[*dccp.Conn]string),
+
+		packetsSent: prometheus.NewDesc("dccp_packets_sent_total",
+			"Packets sent by all connections, by packet type.", []string{"type"}, nil),
+		packetsReceived: prometheus.NewDesc("dccp_packets_received_total",
+			"Packets received by all connections, by packet type.", []string{"type"}, nil),
+		resetsSent: prometheus.NewDesc("dccp_resets_sent_total",
+			"Reset packets sent by all connections, by Reset Code.", []string{"code"}, nil),
+		resetsReceived: prometheus.NewDesc("dccp_resets_received_total",
+			"Reset packets received by all connections, by Reset Code.", []string{"code"}, nil),
+		retransmits: prometheus.NewDesc("dccp_handshake_retransmits_total",
+			"Requests, Responses and PARTOPEN Acks sent again by all connections.", nil, nil),
+		handshakes: prometheus.NewDesc("dccp_handshakes_total",
+			"Handshakes that brought a connection to OPEN.", nil, nil),
+		activeConns: prometheus.NewDesc("dccp_active_connections",
+			"Connections created and not yet CLOSED.", nil, nil),
+		optionsParsed: prometheus.NewDesc("dccp_options_parsed_total",
+			"Options read from the headers of received packets.", nil, nil),
+		checksumFailures: prometheus.NewDesc("dccp_checksum_failures_total",
+			"Received packets dropped for a wrong checksum.", nil, nil),
+
+		connPacketsSent: prometheus.NewDesc("dccp_conn_packets_sent_total",
+			"Packets sent by the connection.", conn, nil),
+		connPacketsReceived: prometheus.NewDesc("dccp_conn_packets_received_total",
+			"Packets received by the connection.", conn, nil),
+		connBytesSent: prometheus.NewDesc("dccp_conn_bytes_sent_total",
+			"Bytes sent by the connection, headers included.", conn, nil),
+		connBytesReceived: prometheus.NewDesc("dccp_conn_bytes_received_total",
+			"Bytes received by the connection, headers included.", conn, nil),
+		connRetransmits: prometheus.NewDesc("dccp_conn_handshake_retransmits_total",
+			"Requests, Responses and PARTOPEN Acks sent again by the connection.", conn, nil),
+		connLossEvents: prometheus.NewDesc("dccp_conn_loss_events_total",
+			"Congestion events, of loss or ECN marks, seen by the sender CCID of the connection.", conn, nil),
+		connRTT: prometheus.NewDesc("dccp_conn_rtt_seconds",
+			"Smoothed round-trip time of the connection.", conn, nil),
+		connRate: prometheus.NewDesc("dccp_conn_allowed_rate_bytes",
+			"Rate, in bytes per second, at which the sender CCID lets the connection send.", conn, nil),
+		connCwnd: prometheus.NewDesc("dccp_conn_cwnd_packets",
+			"Congestion window of a window-based sender CCID, like CCID2.", conn, nil),
+	}
+}
+
+// Register registers a new Collector with the default Prometheus registerer and returns it.
+// It panics if the metrics of package dccp are registered already.
+func Register() *Collector {
+	c := NewCollector()
+	prometheus.MustRegister(c)
+	return c
+}
+
+// Add makes c report the statistics of conn under the conn label name. Once conn is closed,
+// c reports it one last time and then forgets it.
+func (c *Collector) Add(conn *dccp.Conn, name string) {
+	c.Lock()
+	defer c.Unlock()
+	c.conns[conn] = name
+}
+
+// Remove makes c stop reporting conn
+func (c *Collector) Remove(conn *dccp.Conn) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.conns, conn)
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.packetsSent, c.packetsReceived, c.resetsSent, c.resetsReceived, c.retransmits,
+		c.handshakes, c.activeConns, c.optionsParsed, c.checksumFailures,
+		c.connPacketsSent, c.connPacketsReceived, c.connBytesSent, c.connBytesReceived,
+		c.connRetransmits, c.connLossEvents, c.connRTT, c.connRate, c.connCwnd,
+	} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := dccp.ReadCounters()
+	for t := byte(0); t < dccp.NumTypes; t++ {
+		name := dccp.TypeString(t)
+		ch <- counter(c.packetsSent, s.PacketsSent[t], name)
+		ch <- counter(c.packetsReceived, s.PacketsReceived[t], name)
+	}
+	// Only Reset Codes in use, of the 256 possible, make series
+	for code := range s.ResetsSent {
+		if s.ResetsSent[code] == 0 && s.ResetsReceived[code] == 0 {
+			continue
+		}
+		name := strconv.Itoa(code)
+		ch <- counter(c.resetsSent, s.ResetsSent[code], name)
+		ch <- counter(c.resetsReceived, s.ResetsReceived[code], name)
+	}
+	ch <- counter(c.retransmits, s.Retransmits)
+	ch <- counter(c.handshakes, s.Handshakes)
+	ch <- gauge(c.activeConns, float64(s.ActiveConns))
+	ch <- counter(c.optionsParsed, s.OptionsParsed)
+	ch <- counter(c.checksumFailures, s.ChecksumFailures)
+
+	c.Lock()
+	conns := make(map[*dccp.Conn]string, len(c.conns))
+	for conn, name := range c.conns {
+		conns[conn] = name
+	}
+	c.Unlock()
+
+	for conn, name := range conns {
+		cs := conn.Stats()
+		ch <- counter(c.connPacketsSent, cs.PacketsSent, name)
+		ch <- counter(c.connPacketsReceived, cs.PacketsReceived, name)
+		ch <- counter(c.connBytesSent, cs.BytesSent, name)
+		ch <- counter(c.connBytesReceived, cs.BytesReceived, name)
+		ch <- counter(c.connRetransmits, cs.Retransmits, name)
+		ch <- counter(c.connLossEvents, cs.LossEvents, name)
+		ch <- gauge(c.connRTT, cs.RTT.Seconds(), name)
+		ch <- gauge(c.connRate, float64(conn.AllowedRate()), name)
+		if cs.Cwnd > 0 {
+			ch <- gauge(c.connCwnd, float64(cs.Cwnd), name)
+		}
+		if cs.Err != nil {
+			c.Remove(conn)
+		}
+	}
+}
+
+func counter(d *prometheus.Desc, v int64, labels ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+}
+
+func gauge(d *prometheus.Desc, v float64, labels ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...)
+}
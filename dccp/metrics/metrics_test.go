@@ -0,0 +1,105 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/sandbox"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestCollector checks that a registry gathers the counters of the stack and of a connection,
+// and that the collector forgets the connection once it is closed
+func TestCollector(t *testing.T) {
+	env, _ := sandbox.NewVirtualEnv("metrics")
+	clientConn, serverConn, _, _ := sandbox.NewClientServerPipe(env)
+	c := NewCollector()
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("register (%s)", err)
+	}
+	c.Add(clientConn, "client")
+
+	if err := clientConn.WriteSegment([]byte("hello")); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+	mfs := gather(t, reg)
+	if v := value(mfs, "dccp_packets_sent_total", "type", "Request"); v < 1 {
+		t.Errorf("%v Requests sent", v)
+	}
+	if v := value(mfs, "dccp_packets_received_total", "type", "Response"); v < 1 {
+		t.Errorf("%v Responses received", v)
+	}
+	if v := value(mfs, "dccp_conn_packets_sent_total", "conn", "client"); v < 2 {
+		t.Errorf("client sent %v packets", v)
+	}
+	if v := value(mfs, "dccp_handshakes_total", "", ""); v < 1 {
+		t.Errorf("%v handshakes", v)
+	}
+	if v := value(mfs, "dccp_conn_rtt_seconds", "conn", "client"); v <= 0 {
+		t.Errorf("client RTT %v", v)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+
+	mfs = gather(t, reg)
+	if v := value(mfs, "dccp_resets_sent_total", "code", strconv.Itoa(dccp.ResetAborted)); v < 1 {
+		t.Errorf("%v Aborted Resets sent", v)
+	}
+	if v := value(mfs, "dccp_conn_packets_sent_total", "conn", "client"); v < 0 {
+		t.Errorf("closed client not reported")
+	}
+	if v := value(gather(t, reg), "dccp_conn_packets_sent_total", "conn", "client"); v >= 0 {
+		t.Errorf("closed client still reported")
+	}
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+func gather(t *testing.T, reg *prometheus.Registry) []*dto.MetricFamily {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather (%s)", err)
+	}
+	return mfs
+}
+
+// value returns the value of the metric name with the label l=v, or with no labels if l is
+// empty, or -1 if there is none
+func value(mfs []*dto.MetricFamily, name, l, v string) float64 {
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if l == "" && len(m.GetLabel()) == 0 {
+				return metricValue(m)
+			}
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == l && lp.GetValue() == v {
+					return metricValue(m)
+				}
+			}
+		}
+	}
+	return -1
+}
+
+func metricValue(m *dto.Metric) float64 {
+	if m.Counter != nil {
+		return m.Counter.GetValue()
+	}
+	return m.Gauge.GetValue()
+}
@@ -25,7 +25,7 @@ func (x *monotoneTime) Init(env *Env) {
 func (x *monotoneTime) Now() int64 {
 	x.Lock()
 	defer x.Unlock()
-	now := x.env.Now()
+	now := x.env.nowNano()
 	// TODO: If now - x.last is hugely negative we might want to report some sort of error
 	if now < x.last {
 		panic("negative time in mono")
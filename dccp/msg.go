@@ -0,0 +1,94 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "time"
+
+// MsgInfo is what the connection knows of the packet that carried a block of application data,
+// as returned by ReadMsg. Latency-sensitive applications can use the receive time, the CCVal
+// window counter and the timestamps of the sender to follow one-way delay and jitter, and the
+// ECN codepoint to learn of congestion on the path.
+type MsgInfo struct {
+	Type      byte               // Type of the packet, Data or DataAck
+	SeqNo     int64              // Sequence Number of the packet
+	CCVal     int8               // CCVal that the sender CCID placed on the packet, Section 5.1
+	ECN       byte               // ECN codepoint of the packet, if the HeaderConn carries ECN
+	Timestamp *TimestampOption   // Timestamp option of the packet, Section 13.1, or nil
+	Elapsed   *ElapsedTimeOption // Elapsed Time option of a DataAck, Section 13.2, or nil
+	Time      time.Time          // Time the packet was received, by the Env of the connection
+}
+
+// MsgOptions are the options of a message written with WriteMsg. The send queue of a
+// connection holds the messages that wait for the CCID to allow their sending, and once it is
+// full, writers block, unless SetSendQueue says otherwise. Droppable messages are shed first,
+// so that real-time applications can keep writing fresh data and let stale data go.
+type MsgOptions struct {
+	// Priority orders the queued messages: higher priorities are sent first, and equal ones
+	// in the order they were written
+	Priority int
+	// Droppable messages may be discarded from a full send queue, those of lowest priority
+	// and then the oldest first, to make room for newer messages
+	Droppable bool
+	// Deadline, unless zero, is the time by the Env of the connection after which the
+	// message is discarded rather than sent, as late data is of no use to real-time media
+	Deadline time.Time
+	// TTL, if positive, discards the message once it has waited for TTL. If both Deadline
+	// and TTL are set, the earlier deadline applies.
+	TTL time.Duration
+}
+
+// expire returns the deadline of a message written at time now, or zero if it has none
+func (opts *MsgOptions) expire(now int64) int64 {
+	var expire int64
+	if !opts.Deadline.IsZero() {
+		expire = opts.Deadline.UnixNano()
+	}
+	if ttl := int64(opts.TTL); ttl > 0 && (expire == 0 || now+ttl < expire) {
+		expire = now + ttl
+	}
+	return expire
+}
+
+// newMsgInfo returns the MsgInfo of the data packet h, received at time now
+func newMsgInfo(h *Header, now int64) *MsgInfo {
+	info := &MsgInfo{
+		Type:  h.Type,
+		SeqNo: h.SeqNo,
+		CCVal: h.CCVal,
+		ECN:   h.ECN,
+		Time:  time.Unix(0, now),
+	}
+	for _, opt := range h.Options {
+		if t := DecodeTimestampOption(opt); t != nil {
+			info.Timestamp = t
+		}
+		// Elapsed Time options on packets without an Acknowledgement Number are ignored
+		if e := DecodeElapsedTimeOption(opt); e != nil && h.HasAckNo() {
+			info.Elapsed = e
+		}
+	}
+	return info
+}
+
+// Borrowed is the application data of a packet, as lent by ReadBorrow
+type Borrowed struct {
+	Data []byte   // Application data, valid until Release
+	Info *MsgInfo // Metadata of the packet
+	buf  *packetBuf
+}
+
+// Release hands the buffer of b back to the link for reuse. Data must not be used afterwards.
+// Releasing b again has no effect.
+func (b *Borrowed) Release() {
+	b.buf.release()
+	b.Data, b.buf = nil, nil
+}
+
+// appMsg is the application data of a packet, on its way from the event loop to the application
+type appMsg struct {
+	data []byte
+	info *MsgInfo
+	buf  *packetBuf // Packet buffer that data lies in, if any
+}
@@ -0,0 +1,30 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "testing"
+
+// TestMsgInfo checks that the metadata of a data packet carries its timestamps, and that the
+// Elapsed Time options of packets without an Acknowledgement Number are ignored
+func TestMsgInfo(t *testing.T) {
+	ts, _ := (&TimestampOption{Timestamp: 12345}).Encode()
+	el, _ := (&ElapsedTimeOption{Elapsed: 300}).Encode()
+	h := &Header{Type: DataAck, X: true, SeqNo: 7, CCVal: 5, ECN: ECNCE, Options: []*Option{ts, el}}
+	info := newMsgInfo(h, 1e9)
+	if info.Type != DataAck || info.SeqNo != 7 || info.CCVal != 5 || info.ECN != ECNCE || info.Time.UnixNano() != 1e9 {
+		t.Errorf("info %+v", info)
+	}
+	if info.Timestamp == nil || info.Timestamp.Timestamp != 12345 {
+		t.Errorf("timestamp %v", info.Timestamp)
+	}
+	if info.Elapsed == nil || info.Elapsed.Elapsed != 300 {
+		t.Errorf("elapsed %v", info.Elapsed)
+	}
+
+	h.Type = Data
+	if info = newMsgInfo(h, 1e9); info.Elapsed != nil || info.Timestamp == nil {
+		t.Errorf("data packet: elapsed %v, timestamp %v", info.Elapsed, info.Timestamp)
+	}
+}
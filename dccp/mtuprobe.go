@@ -0,0 +1,168 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "fmt"
+
+// MTU probing, in the manner of Packetization Layer Path MTU Discovery, RFC 4821 and RFC 8899,
+// for paths on which ICMP messages are lost or filtered. A connection that probes starts from a
+// path MTU of MTUProbeBase and searches for a larger one, up to the MTU of its HeaderConn, by
+// sending Syncs padded to the probed size, Section 14.1. A SyncAck in response shows that a
+// packet of that size made it through.
+
+const (
+	// MTUProbeBase is the path MTU, in the sense of HeaderConn.GetMTU, that a connection assumes
+	// when it starts probing, BASE_PLPMTU of RFC 8899
+	MTUProbeBase = 1200
+
+	mtuProbeMaxProbes = 3     // Unanswered probes of one size before the size is given up on
+	mtuProbeStep      = 32    // Search ends when the bounds are closer than this many bytes
+	mtuProbeRaise     = 600e9 // Time between searches, PMTU_RAISE_TIMER of RFC 8899
+	mtuProbeTimeout   = 1e9   // Minimum time to wait for the SyncAck of a probe
+)
+
+// mtuProber keeps the state of the search for a larger path MTU
+type mtuProber struct {
+	pmtu    int32 // Largest packet size known to get through, the PLPMTU
+	high    int32 // Smallest packet size known not to get through
+	size    int32 // Size of the probe in flight, or zero if none
+	seqNo   int64 // Sequence number of the probe in flight, once it is written
+	written bool  // Whether the probe in flight has been written
+	sent    int64 // Time the probe in flight was queued, and then written
+	count   int   // Probes of size sent, without a SyncAck
+	search  int64 // Time the next search starts, or zero if a search is under way
+}
+
+// SetMTU sets the value that GetMTU returns, for applications that know the path MTU better
+// than the connection can learn it. The packets of the connection grow to match, beyond the
+// MTU of the HeaderConn if need be. A value of zero returns to the MTU that the connection
+// discovers.
+func (c *Conn) SetMTU(mtu int) error {
+	if mtu < 0 {
+		return ErrInvalid
+	}
+	c.do(func() {
+		c.mtuOverride = 0
+		if mtu > 0 {
+			c.mtuOverride = int32(mtu + maxDataOptionSize + getFixedHeaderSize(DataAck, true))
+		}
+		c.syncWithLink()
+	})
+	return nil
+}
+
+// SetMTUProbing turns MTU probing on or off. While it is on, GetMTU starts from the value that
+// corresponds to MTUProbeBase and grows as probes get through, and SetMTUHandler reports each
+// step. Probing costs a few padded Syncs every ten minutes.
+func (c *Conn) SetMTUProbing(on bool) {
+	c.do(func() {
+		if !on {
+			c.mtuProbe = nil
+		} else if c.mtuProbe == nil {
+			c.mtuProbe = &mtuProber{pmtu: MTUProbeBase}
+		}
+		c.syncWithLink()
+	})
+}
+
+// linkPMTU returns the path MTU that the connection uses: the one set by SetMTU, if any, or
+// else the MTU of the HeaderConn, limited by what probing has confirmed
+func (c *Conn) linkPMTU() int32 {
+	if c.mtuOverride > 0 {
+		return c.mtuOverride
+	}
+	pmtu := int32(c.hc.GetMTU())
+	if c.mtuProbe != nil && c.mtuProbe.pmtu < pmtu {
+		pmtu = c.mtuProbe.pmtu
+	}
+	return pmtu
+}
+
+// pollMTUProbe sends the next probe, or gives up on the one in flight, as the search calls for.
+// The idle loop calls it about once per round-trip time.
+func (c *Conn) pollMTUProbe() {
+	p := c.mtuProbe
+	if p == nil || c.mtuOverride > 0 || c.socket.GetState() != OPEN {
+		return
+	}
+	now := c.env.nowNano()
+	if p.size > 0 {
+		if now-p.sent < max64(mtuProbeTimeout, 3*c.socket.GetRTT()) {
+			return
+		}
+		if p.count < mtuProbeMaxProbes {
+			c.sendMTUProbe(p.size)
+			return
+		}
+		c.amb.E(EventInfo, fmt.Sprintf("MTU probe of %d bytes lost", p.size))
+		c.failMTUProbe()
+	}
+	if p.search > 0 {
+		if now < p.search {
+			return
+		}
+		p.search, p.high = 0, 0
+	}
+	high := int32(c.hc.GetMTU()) + 1
+	if p.high > 0 && p.high < high {
+		high = p.high
+	}
+	if high-p.pmtu <= mtuProbeStep {
+		// The search is over until the raise timer expires
+		p.search = now + mtuProbeRaise
+		return
+	}
+	c.sendMTUProbe((p.pmtu + high) / 2)
+}
+
+// sendMTUProbe queues a Sync padded to size bytes
+func (c *Conn) sendMTUProbe(size int32) {
+	p := c.mtuProbe
+	if p.size != size {
+		p.size, p.count = size, 0
+	}
+	p.written, p.sent = false, c.env.nowNano()
+	p.count++
+	h := c.generateSync()
+	h.mtuProbe = true
+	c.inject(h)
+}
+
+// writeMTUProbe pads the probe h, whose sequence number and options are in place, to the size
+// of the probe and notes its sequence number
+func (c *Conn) writeMTUProbe(h *writeHeader) {
+	p := c.mtuProbe
+	if p == nil || p.size == 0 {
+		return
+	}
+	n, err := h.Header.Footprint()
+	if err != nil || int(p.size) < n {
+		return
+	}
+	h.Header.Data = make([]byte, int(p.size)-n)
+	p.seqNo, p.written, p.sent = h.SeqNo, true, c.env.nowNano()
+}
+
+// readMTUProbe checks whether the SyncAck h acknowledges the probe in flight, in which case
+// the path MTU grows to its size
+func (c *Conn) readMTUProbe(h *Header) {
+	p := c.mtuProbe
+	if p == nil || p.size == 0 || !p.written || h.AckNo != p.seqNo {
+		return
+	}
+	c.amb.E(EventInfo, fmt.Sprintf("MTU probe of %d bytes acknowledged", p.size))
+	p.pmtu, p.size = p.size, 0
+	c.syncWithLink()
+}
+
+// failMTUProbe gives up on the probe in flight, whose size is then the upper bound of the
+// search. The HeaderConn calls for this directly when a probe is too big for it to send.
+func (c *Conn) failMTUProbe() {
+	p := c.mtuProbe
+	if p == nil || p.size == 0 {
+		return
+	}
+	p.high, p.size = p.size, 0
+}
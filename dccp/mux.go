@@ -20,6 +20,7 @@ import (
 type Mux struct {
 	Mutex
 	link         Link
+	batch        *batchWriter     // Combines the writes of the flows, if link is a BatchLink
 	flowsLocal   map[uint64]*flow // Active flows hashed by local label
 	flowsRemote  map[uint64]*flow
 	lingerLocal  map[uint64]time.Time // Local labels of recently-closed flows mapped to time of closure
@@ -38,6 +39,8 @@ const (
 type muxHeader struct {
 	Msg   *muxMsg
 	Cargo []byte
+	ECN   byte
+	Buf   *packetBuf // Packet buffer that Cargo lies in
 }
 
 // NewMux creates a new Mux object, using the connection-less packet interface link
@@ -50,6 +53,13 @@ func NewMux(link Link) *Mux {
 		lingerRemote: make(map[uint64]time.Time),
 		acceptChan:   make(chan *flow),
 	}
+	// ECNLinks are read and written one packet at a time. BatchLinks that carry ECN codepoints
+	// pass them in LinkMsg.ECN instead.
+	if bl, ok := link.(BatchLink); ok {
+		if _, ok := link.(ECNLink); !ok {
+			m.batch = newBatchWriter(bl.WriteBatch)
+		}
+	}
 	go m.readLoop()
 	go m.expireLingeringLoop()
 	go m.expireLoop()
@@ -98,6 +108,8 @@ func (m *Mux) Close() error {
 }
 
 func (m *Mux) readLoop() {
+	var rbs [batchLen]*packetBuf
+	var msgs [batchLen]LinkMsg
 	for {
 		// Check that mux is still open
 		m.Lock()
@@ -107,25 +119,51 @@ func (m *Mux) readLoop() {
 			break
 		}
 
+		// Read a batch of incoming packets
+		if m.batch != nil {
+			for i := range msgs {
+				if rbs[i] == nil {
+					rbs[i] = newPacketBuf(link.GetMTU() + MuxReadSafety)
+				}
+				msgs[i] = LinkMsg{Buf: rbs[i].b}
+			}
+			n, err := link.(BatchLink).ReadBatch(msgs[:])
+			if err != nil {
+				break
+			}
+			ok := true
+			for i := 0; i < n && ok; i++ {
+				rb := rbs[i]
+				rbs[i] = nil
+				ok = m.receive(rb, msgs[i].N, msgs[i].ECN, msgs[i].Addr)
+			}
+			if !ok {
+				break
+			}
+			continue
+		}
+
 		// Read incoming packet
-		buf := make([]byte, m.link.GetMTU()+MuxReadSafety)
-		n, addr, err := link.ReadFrom(buf)
+		rb := newPacketBuf(link.GetMTU() + MuxReadSafety)
+		buf := rb.b
+		var n int
+		var addr net.Addr
+		var ecn byte
+		var err error
+		if el, ok := link.(ECNLink); ok {
+			n, addr, ecn, err = el.ReadFromECN(buf)
+		} else {
+			n, addr, err = link.ReadFrom(buf)
+		}
 		if err != nil {
 			break
 		}
-
-		// Check that packet is not oversized
-		if len(buf)-n < MuxReadSafety {
+		if !m.receive(rb, n, ecn, addr) {
 			break
 		}
-
-		// Read mux header
-		msg, cargo, err := readMuxHeader(buf[:n])
-		if err != nil {
-			continue
-		}
-
-		m.process(msg, cargo, addr)
+	}
+	for _, rb := range rbs {
+		rb.release()
 	}
 	close(m.acceptChan)
 	m.Lock()
@@ -138,7 +176,28 @@ func (m *Mux) readLoop() {
 	m.Unlock()
 }
 
-func (m *Mux) process(msg *muxMsg, cargo []byte, addr net.Addr) {
+// receive processes the packet of n bytes in rb from addr. It returns false if the packet is
+// oversized, which ends the reading.
+func (m *Mux) receive(rb *packetBuf, n int, ecn byte, addr net.Addr) bool {
+	buf := rb.b
+
+	// Check that packet is not oversized
+	if len(buf)-n < MuxReadSafety {
+		return false
+	}
+
+	// Read mux header
+	msg, cargo, err := readMuxHeader(buf[:n])
+	if err != nil {
+		rb.release()
+		return true
+	}
+
+	m.process(msg, cargo, ecn, rb, addr)
+	return true
+}
+
+func (m *Mux) process(msg *muxMsg, cargo []byte, ecn byte, rb *packetBuf, addr net.Addr) {
 	// REMARK: By design, only one copy of process() can run at a time (*)
 
 	// Every packet must have a source (remote) label
@@ -175,9 +234,12 @@ func (m *Mux) process(msg *muxMsg, cargo []byte, addr net.Addr) {
 		if f == nil {
 			f = m.accept(msg.Source, addr)
 		}
+		if f == nil {
+			return
+		}
 	}
 
-	f.ch <- muxHeader{msg, cargo}
+	f.deliver(muxHeader{msg, cargo, ecn, rb})
 }
 
 func (m *Mux) accept(remote *Label, addr net.Addr) *flow {
@@ -187,9 +249,14 @@ func (m *Mux) accept(remote *Label, addr net.Addr) *flow {
 
 	ch := make(chan muxHeader)
 	local := ChooseLabel()
-	f := newFlow(addr, m, ch, m.cargoMaxLen(), local, remote)
 
 	m.Lock()
+	// A packet that arrives while the mux closes opens no flow
+	if m.link == nil {
+		m.Unlock()
+		return nil
+	}
+	f := newFlow(addr, m, ch, m.cargoMaxLen(), local, remote)
 	m.flowsLocal[local.Hash()] = f
 	m.flowsRemote[remote.Hash()] = f
 	m.Unlock()
@@ -325,9 +392,42 @@ func (m *Mux) del(local *Label, remote *Label) {
 	}
 }
 
+// reclaim() removes the flow with the specified labels, without remembering its labels as
+// lingering. It also forgets the labels, if they are already lingering.
+func (m *Mux) reclaim(local *Label, remote *Label) {
+	m.Lock()
+	defer m.Unlock()
+
+	if local != nil {
+		delete(m.flowsLocal, local.Hash())
+		delete(m.lingerLocal, local.Hash())
+	}
+	if remote != nil {
+		delete(m.flowsRemote, remote.Hash())
+		delete(m.lingerRemote, remote.Hash())
+	}
+}
+
 func (m *Mux) cargoMaxLen() int { return m.link.GetMTU() - muxMsgFootprint }
 
-func (m *Mux) write(msg *muxMsg, block []byte, addr net.Addr) error {
+// carriesECN returns true if the underlying link can carry ECN codepoints
+func (m *Mux) carriesECN() bool {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.link.(ECNLink); ok {
+		return true
+	}
+	return m.batch != nil && carriesECN(m.link)
+}
+
+// carriesDSCP returns true if the underlying link can mark packets with a DSCP
+func (m *Mux) carriesDSCP() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.batch != nil && carriesDSCP(m.link)
+}
+
+func (m *Mux) write(msg *muxMsg, block []byte, ecn, dscp byte, addr net.Addr) error {
 	m.Lock()
 	link := m.link
 	m.Unlock()
@@ -335,11 +435,24 @@ func (m *Mux) write(msg *muxMsg, block []byte, addr net.Addr) error {
 		return ErrBad
 	}
 
-	buf := make([]byte, muxMsgFootprint+len(block))
+	pb := newPacketBuf(muxMsgFootprint + len(block))
+	defer pb.release()
+	buf := pb.b
 	msg.Write(buf)
 	copy(buf[muxMsgFootprint:], block)
 
-	n, err := link.WriteTo(buf, addr)
+	// Packets that flows write at the same time leave in one batch
+	if m.batch != nil {
+		return m.batch.write(buf, addr, dscp, ecn)
+	}
+
+	var n int
+	var err error
+	if el, ok := link.(ECNLink); ok {
+		n, err = el.WriteToECN(buf, addr, ecn)
+	} else {
+		n, err = link.WriteTo(buf, addr)
+	}
 	if n != muxMsgFootprint+len(block) {
 		panic("block divided")
 	}
@@ -166,3 +166,28 @@ func _TestMuxOverUDP(t *testing.T) {
 	ee := newEndToEnd(t, alink, dlink, addr, 10)
 	ee.Run()
 }
+
+func TestMuxReclaim(t *testing.T) {
+	alink, _ := NewChanPipe()
+	m := NewMux(alink)
+	defer m.Close()
+
+	closed, _ := m.Dial(nil)
+	reclaimed, _ := m.Dial(nil)
+	cl, rl := closed.LocalLabel().(*Label), reclaimed.LocalLabel().(*Label)
+	if err := closed.Close(); err != nil {
+		t.Fatalf("close (%s)", err)
+	}
+	if err := reclaimed.(Reclaimer).Reclaim(); err != nil {
+		t.Fatalf("reclaim (%s)", err)
+	}
+	if !m.isLingering(cl, nil) {
+		t.Errorf("closed flow is not lingering")
+	}
+	if m.isLingering(rl, nil) || m.findLocal(rl) != nil {
+		t.Errorf("reclaimed flow is still known")
+	}
+	if reclaimed.(Reclaimer).Reclaim() == nil {
+		t.Errorf("reclaimed a flow twice")
+	}
+}
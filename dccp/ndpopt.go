@@ -0,0 +1,49 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+// NDPCountOption, Section 7.7
+// NDP Count is the number of consecutive non-data packets in the run immediately preceding the
+// packet that carries the option. It lets the receiver tell lost data packets apart from lost
+// non-data packets. The count is encoded in 1 to 6 bytes.
+type NDPCountOption struct {
+	Count uint64
+}
+
+// NDPCountMax is the largest count that fits in an NDP Count option
+const NDPCountMax = 1<<48 - 1
+
+func (opt *NDPCountOption) Encode() (*Option, error) {
+	if opt.Count > NDPCountMax {
+		return nil, ErrOverflow
+	}
+	l := 1
+	for opt.Count>>uint(8*l) != 0 {
+		l++
+	}
+	return &Option{
+		Type:      OptionNDPCount,
+		Data:      encodeNNValue(opt.Count, l),
+		Mandatory: false,
+	}, nil
+}
+
+func DecodeNDPCountOption(opt *Option) *NDPCountOption {
+	if opt.Type != OptionNDPCount || len(opt.Data) < 1 || len(opt.Data) > 6 {
+		return nil
+	}
+	count, _ := decodeNNValue(opt.Data, len(opt.Data))
+	return &NDPCountOption{Count: count}
+}
+
+// findNDPCount returns the NDP Count carried in opts, or zero if there is none
+func findNDPCount(opts []*Option) uint64 {
+	for _, o := range opts {
+		if ndp := DecodeNDPCountOption(o); ndp != nil {
+			return ndp.Count
+		}
+	}
+	return 0
+}
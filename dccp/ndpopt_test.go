@@ -0,0 +1,22 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "testing"
+
+func TestNDPCountOption(t *testing.T) {
+	for _, n := range []uint64{1, 255, 256, 70000, NDPCountMax} {
+		opt, err := (&NDPCountOption{n}).Encode()
+		if err != nil {
+			t.Fatalf("encoding %d (%s)", n, err)
+		}
+		if dec := DecodeNDPCountOption(opt); dec == nil || dec.Count != n {
+			t.Errorf("expecting %d, encountered %v", n, dec)
+		}
+	}
+	if _, err := (&NDPCountOption{NDPCountMax + 1}).Encode(); err == nil {
+		t.Errorf("encoded oversized count")
+	}
+}
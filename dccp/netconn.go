@@ -0,0 +1,201 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// Conn satisfies net.Conn. Read and Write treat the connection as a byte stream, so that it
+// can be used with io.Copy, bufio and the like. Applications that care about the boundaries
+// of DCCP packets should use ReadSegment and WriteSegment instead.
+var _ net.Conn = (*Conn)(nil)
+
+// Read implements net.Conn.Read. It copies the application data of the next packet into b.
+// If b is too short, the rest of the packet is returned by the following calls to Read. Read
+// returns io.EOF after the connection is closed normally, and ErrTimeout, which is a
+// net.Error, if the read deadline passes.
+func (c *Conn) Read(b []byte) (n int, err error) {
+	c.readRestLk.Lock()
+	defer c.readRestLk.Unlock()
+	if len(c.readRest) == 0 {
+		m, err := c.readSegment()
+		if errors.Is(err, ErrEOF) {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		c.readRest, c.readRestInfo, c.readRestBuf = m.data, m.info, m.buf
+	}
+	n = copy(b, c.readRest)
+	c.readRest = c.readRest[n:]
+	if len(c.readRest) == 0 {
+		// The packet has been copied out in full, so its buffer can be reused
+		c.readRestBuf.release()
+		c.readRestBuf = nil
+	}
+	return n, nil
+}
+
+// Write implements net.Conn.Write. Data longer than GetMTU is split across several packets.
+// If the write deadline passes, Write returns ErrTimeout along with the number of bytes
+// written so far.
+func (c *Conn) Write(b []byte) (n int, err error) {
+	mtu := c.GetMTU()
+	for {
+		k := min(len(b)-n, mtu)
+		if err = c.WriteSegment(b[n : n+k]); err != nil {
+			return n, err
+		}
+		n += k
+		if n == len(b) {
+			return n, nil
+		}
+	}
+}
+
+// LocalAddr implements net.Conn.LocalAddr
+func (c *Conn) LocalAddr() net.Addr {
+	if ac, ok := c.hc.(addrConn); ok {
+		return ac.LocalAddr()
+	}
+	return labelAddr(c.hc.LocalLabel())
+}
+
+// RemoteAddr implements net.Conn.RemoteAddr
+func (c *Conn) RemoteAddr() net.Addr {
+	return remoteAddr(c.hc)
+}
+
+// remoteAddr returns the address of the other side of hc, see Conn.RemoteAddr
+func remoteAddr(hc HeaderConn) net.Addr {
+	if ac, ok := hc.(addrConn); ok {
+		return ac.RemoteAddr()
+	}
+	return labelAddr(hc.RemoteLabel())
+}
+
+// addrConn is implemented by HeaderConns whose endpoints have network addresses, like those
+// of a UDPEncap, rather than just labels
+type addrConn interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+// labelAddr returns the Addr of a link label. GoDCCP multiplexes connections by label,
+// rather than by port, so the port of the Addr is always zero.
+func labelAddr(b Bytes) *Addr {
+	if label, ok := b.(*Label); ok {
+		return &Addr{Label: label}
+	}
+	label, _, err := ReadLabel(b.Bytes())
+	if err != nil {
+		return ZeroAddr
+	}
+	return &Addr{Label: label}
+}
+
+// SetDeadline implements net.Conn.SetDeadline. Deadlines are read on the time of the Env of the
+// connection, see Env.Now.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	c.writeDeadline.Set(t)
+	return nil
+}
+
+// SetReadDeadline implements net.Conn.SetReadDeadline
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn.SetWriteDeadline
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Set(t)
+	return nil
+}
+
+// deadline is a point in time, after which the blocked and future calls that wait on it give
+// up. It can be changed at any time, also while calls are waiting on it. It runs on the time of
+// an Env, and its timer on the wheel of the Env.
+type deadline struct {
+	env   *Env
+	wheel *timerWheel
+	Mutex
+	timer   *wheelTimer   // Timer that closes cancel when the deadline passes, or nil
+	cancel  chan struct{} // Closed when the deadline passes
+	cancelT *Track        // Accounts for cancel on virtual time
+}
+
+func newDeadline(env *Env, wheel *timerWheel) *deadline {
+	return &deadline{env: env, wheel: wheel, cancel: make(chan struct{}), cancelT: &Track{}}
+}
+
+// Set moves the deadline to t, on the time of the Env. A zero t means no deadline.
+func (d *deadline) Set(t time.Time) {
+	d.Lock()
+	defer d.Unlock()
+	if d.timer != nil {
+		// A timer that is firing already finds itself replaced and does nothing
+		d.wheel.cancel(d.timer)
+		d.timer = nil
+	}
+	passed := isClosed(d.cancel)
+	if t.IsZero() {
+		if passed {
+			d.cancel, d.cancelT = make(chan struct{}), &Track{}
+		}
+		return
+	}
+	if at := t.UnixNano(); at > d.env.nowNano() {
+		if passed {
+			d.cancel, d.cancelT = make(chan struct{}), &Track{}
+		}
+		timer := &wheelTimer{}
+		timer.fire = func() { d.expire(timer) }
+		d.timer = timer
+		d.wheel.schedule(timer, at)
+		return
+	}
+	if !passed {
+		close(d.cancel)
+		d.env.Closed(d.cancelT)
+	}
+}
+
+// expire closes the cancel channel, if timer is still the timer of the deadline
+func (d *deadline) expire(timer *wheelTimer) {
+	d.Lock()
+	defer d.Unlock()
+	if d.timer != timer {
+		return
+	}
+	d.timer = nil
+	if !isClosed(d.cancel) {
+		close(d.cancel)
+		d.env.Closed(d.cancelT)
+	}
+}
+
+// Wait returns a channel that is closed when the deadline passes, and the Track that accounts
+// for it
+func (d *deadline) Wait() (<-chan struct{}, *Track) {
+	d.Lock()
+	defer d.Unlock()
+	return d.cancel, d.cancelT
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
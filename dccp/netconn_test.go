@@ -0,0 +1,132 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	env := NewEnv(nil)
+	d := newDeadline(env, env.timerWheel())
+	if isClosed(d.cancel) {
+		t.Fatalf("new deadline has passed")
+	}
+	d.Set(env.Now().Add(-time.Second))
+	if !isClosed(d.cancel) {
+		t.Errorf("deadline in the past has not passed")
+	}
+	// Moving a passed deadline into the future releases it again
+	d.Set(env.Now().Add(50 * time.Millisecond))
+	wait, _ := d.Wait()
+	if isClosed(d.cancel) {
+		t.Errorf("deadline in the future has passed")
+	}
+	select {
+	case <-wait:
+	case <-time.After(time.Second):
+		t.Errorf("deadline did not pass")
+	}
+	d.Set(time.Time{})
+	wait, _ = d.Wait()
+	select {
+	case <-wait:
+		t.Errorf("cleared deadline has passed")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestDeadlineVirtual checks that a deadline passes on the time of a virtual Env, which skips
+// ahead to it, rather than on real time
+func TestDeadlineVirtual(t *testing.T) {
+	env := NewVirtualEnv(nil)
+	defer env.virtual.stop()
+	d := newDeadline(env, env.timerWheel())
+	start := env.Now()
+	d.Set(start.Add(time.Hour))
+	wait, k := d.Wait()
+	env.Wait(k)
+	select {
+	case <-wait:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("deadline did not pass on virtual time")
+	}
+	env.Woken(k)
+	if elapsed := env.Now().Sub(start); elapsed < time.Hour {
+		t.Errorf("deadline passed after %v of virtual time", elapsed)
+	}
+}
+
+// fastCCID is CCFixed at a packet per millisecond
+type fastCCID struct{}
+
+func (fastCCID) NewSender(env *Env, amb *Amb) SenderCongestionControl {
+	return newFixedRateSenderControl(env, 1e6)
+}
+
+func (fastCCID) NewReceiver(env *Env, amb *Amb) ReceiverCongestionControl {
+	return newFixedRateReceiverControl(env)
+}
+
+// TestReadRelease checks that Read hands the buffer of a packet back to the pool once the
+// packet has been read in full, and not before
+func TestReadRelease(t *testing.T) {
+	alink, dlink := NewChanPipe()
+	l := NewListener(alink, fastCCID{})
+	defer l.Close()
+	clientConn, err := NewStack(dlink, fastCCID{}).Dial(nil, 0)
+	if err != nil {
+		t.Fatalf("dial (%s)", err)
+	}
+	defer clientConn.Abort()
+	if err := clientConn.WriteSegment([]byte("message")); err != nil {
+		t.Fatalf("write (%s)", err)
+	}
+	serverConn, err := l.AcceptDCCP()
+	if err != nil {
+		t.Fatalf("accept (%s)", err)
+	}
+	defer serverConn.Abort()
+
+	// The pool makes no promises, but a buffer put back is as a rule the next one handed out
+	reused := 0
+	b := make([]byte, 4)
+	for i := 0; i < 20; i++ {
+		if i > 0 {
+			if err := clientConn.WriteSegment([]byte("message")); err != nil {
+				t.Fatalf("write (%s)", err)
+			}
+		}
+		if k, err := serverConn.Read(b); k != 4 || err != nil {
+			t.Fatalf("read %d bytes (%v)", k, err)
+		}
+		pb := serverConn.readRestBuf
+		if pb == nil {
+			t.Fatalf("packet released before it was read in full")
+		}
+		if k, err := serverConn.Read(b); k != 3 || err != nil || string(b[:k]) != "age" {
+			t.Fatalf("read %q (%v)", b[:k], err)
+		}
+		if serverConn.readRestBuf != nil {
+			t.Fatalf("packet read in full is kept")
+		}
+		if q := newPacketBuf(len(pb.b)); q == pb {
+			reused++
+		}
+	}
+	if reused == 0 {
+		t.Errorf("no packet buffer was reused through Read")
+	}
+}
+
+func TestTimeoutIsNetError(t *testing.T) {
+	if !ErrTimeout.(ProtoError).Timeout() {
+		t.Errorf("ErrTimeout is not a timeout")
+	}
+	if ErrEOF.(ProtoError).Timeout() {
+		t.Errorf("ErrEOF is a timeout")
+	}
+}
@@ -28,6 +28,10 @@ const (
 	OptionElapsedTime     = 43
 	OptionDataChecksum    = 44
 	// Reserved 45 to 127
+	// OptionServiceCodes IS NOT part of RFC 4340, nor of RFC 5595. It is a non-standard
+	// extension of this package that takes a reserved option number, so it is only sent and
+	// read once EnableServiceCodesOption turns it on, see ServiceCodesOption.
+	OptionServiceCodes    = 127
 	// CCID-specific 128 to 255
 )
 
@@ -0,0 +1,309 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// packetSocket is a socket whose datagrams carry the DCCP packets of many connections, like a
+// UDPEncap or a RawIP. Each connection reads and writes its packets through a packetFlow.
+type packetSocket interface {
+	// encode returns the wire format of h, sent from local to remote, placing it in scratch if
+	// scratch has the capacity for it
+	encode(h *Header, local, remote endpoint, scratch []byte) ([]byte, error)
+
+	// decode parses the wire format p, received by local from remote. The header does not
+	// refer to p, but to a packet buffer of its own.
+	decode(p []byte, local, remote endpoint) (*Header, error)
+
+	// send transmits the wire format p to remote, marked with dscp if the socket carries DSCPs,
+	// and with the ECN codepoint ecn if it carries ECN
+	send(p []byte, remote endpoint, dscp, ecn byte) error
+
+	// carriesDSCP returns true if send marks the packets with a DSCP
+	carriesDSCP() bool
+
+	// carriesECN returns true if send sets the ECN codepoints of the packets, and the received
+	// datagrams come with theirs in packetBuf.ecn
+	carriesECN() bool
+
+	// addr returns the net.Addr of the endpoint e
+	addr(e endpoint) net.Addr
+
+	// forget stops passing datagrams to the closed flow f
+	forget(f *packetFlow)
+
+	// overhead returns the number of bytes by which the headers between IP and DCCP exceed the
+	// DCCP ports, so that the MTU of a flow, in the sense of HeaderConn.GetMTU, is the path MTU
+	// less the IP header and the overhead
+	overhead() int
+}
+
+// endpoint is an IP address, of either family, and a port. Depending on the packetSocket, the
+// port is a UDP or a DCCP port.
+type endpoint struct {
+	IP   net.IP
+	Port int
+	Zone string // IPv6 scoped addressing zone
+}
+
+func (e endpoint) String() string {
+	ip := e.IP.String()
+	if e.Zone != "" {
+		ip += "%" + e.Zone
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(e.Port))
+}
+
+// Bytes returns the IP address of e, followed by its port, so that endpoints can serve as
+// labels
+func (e endpoint) Bytes() []byte {
+	p := append([]byte{}, e.IP...)
+	return append(p, byte(e.Port>>8), byte(e.Port))
+}
+
+// packetFlow is the HeaderConn of a connection on a packetSocket
+type packetFlow struct {
+	s      packetSocket
+	local  endpoint // Local endpoint, with the IP that the flow's datagrams leave from
+	remote endpoint
+	ch     chan *packetBuf
+	icmp   chan *ICMPError // ICMP errors about the flow's packets, for the reader
+	done   chan struct{}   // Closed when the flow is closed
+
+	Mutex
+	readDeadline time.Time
+	mtu          int  // MTU of the flow, which path MTU discovery lowers
+	dscp         byte // DSCP of the flow's packets, see Conn.SetDSCP
+
+	rlk Mutex // synchronizes calls to Read()
+}
+
+// packetFlowQueueLen is the number of datagrams that wait for the reader of a packetFlow,
+// before further datagrams are dropped
+const packetFlowQueueLen = 16
+
+// newPacketFlow creates a flow on s between the endpoints local and remote. Its MTU starts out
+// as that of the interface with the local IP address.
+func newPacketFlow(s packetSocket, local, remote endpoint) *packetFlow {
+	return &packetFlow{
+		s:            s,
+		local:        local,
+		remote:       remote,
+		ch:           make(chan *packetBuf, packetFlowQueueLen),
+		icmp:         make(chan *ICMPError, 1),
+		done:         make(chan struct{}),
+		readDeadline: time.Now().Add(-time.Second), // time in the past
+		mtu:          interfaceMTU(local.IP) - ipHeaderLen(remote.IP) - s.overhead(),
+	}
+}
+
+// deliver passes the datagram in pb on to the reader of the flow. If the reader is behind,
+// the datagram is dropped, as the network could have.
+func (f *packetFlow) deliver(pb *packetBuf) {
+	select {
+	case f.ch <- pb:
+	default:
+		pb.release()
+	}
+}
+
+// deliverICMP passes the ICMP error e on to the reader of the flow. If e reports a path MTU,
+// the MTU of the flow is lowered to match right away. An error is dropped if the reader has not
+// yet taken the previous one.
+func (f *packetFlow) deliverICMP(e *ICMPError) {
+	if e.tooBig() && e.MTU >= minPathMTU(f.remote.IP) {
+		mtu := e.MTU - ipHeaderLen(f.remote.IP) - f.s.overhead()
+		f.Lock()
+		if mtu < f.mtu {
+			f.mtu = mtu
+		}
+		f.Unlock()
+	}
+	select {
+	case f.icmp <- e:
+	default:
+	}
+}
+
+// GetMTU implements HeaderConn.GetMTU
+func (f *packetFlow) GetMTU() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.mtu
+}
+
+// Read implements HeaderConn.Read
+func (f *packetFlow) Read() (h *Header, err error) {
+	f.rlk.Lock()
+	defer f.rlk.Unlock()
+
+	f.Lock()
+	readDeadline := f.readDeadline
+	f.Unlock()
+	if isClosed(f.done) {
+		return nil, ErrBad
+	}
+	var tmoch <-chan time.Time
+	if readTimeout := readDeadline.Sub(time.Now()); readTimeout > 0 {
+		timer := time.NewTimer(readTimeout)
+		defer timer.Stop()
+		tmoch = timer.C
+	}
+	for {
+		var pb *packetBuf
+		select {
+		case pb = <-f.ch:
+		case e := <-f.icmp:
+			return nil, e
+		case <-f.done:
+			return nil, ErrIO
+		case <-tmoch:
+			return nil, ErrTimeout
+		}
+		// Corrupt datagrams are dropped, as they would be by DCCP over IP
+		h, err = f.s.decode(pb.b, f.local, f.remote)
+		ecn := pb.ecn
+		pb.release()
+		if err == nil {
+			h.ECN = ecn
+			return h, nil
+		}
+	}
+}
+
+// Write implements HeaderConn.Write
+func (f *packetFlow) Write(h *Header) error {
+	if isClosed(f.done) {
+		return ErrBad
+	}
+	pb := newPacketBuf(f.GetMTU())
+	defer pb.release()
+	p, err := f.s.encode(h, f.local, f.remote, pb.b)
+	if err != nil {
+		return err
+	}
+	f.Lock()
+	dscp := f.dscp
+	f.Unlock()
+	return f.s.send(p, f.remote, dscp, h.ECN)
+}
+
+// CarriesDSCP implements DSCPCarrier.CarriesDSCP
+func (f *packetFlow) CarriesDSCP() bool { return f.s.carriesDSCP() }
+
+// CarriesECN implements ECNCarrier.CarriesECN
+func (f *packetFlow) CarriesECN() bool { return f.s.carriesECN() }
+
+// SetDSCP implements dscpMarker.SetDSCP
+func (f *packetFlow) SetDSCP(dscp byte) error {
+	f.Lock()
+	defer f.Unlock()
+	f.dscp = dscp
+	return nil
+}
+
+// encodesHeaders implements headerEncoder.encodesHeaders
+func (f *packetFlow) encodesHeaders() {}
+
+// LocalLabel implements HeaderConn.LocalLabel
+func (f *packetFlow) LocalLabel() Bytes { return f.local }
+
+// RemoteLabel implements HeaderConn.RemoteLabel
+func (f *packetFlow) RemoteLabel() Bytes { return f.remote }
+
+// LocalAddr returns the local address of the flow
+func (f *packetFlow) LocalAddr() net.Addr { return f.s.addr(f.local) }
+
+// RemoteAddr returns the remote address of the flow
+func (f *packetFlow) RemoteAddr() net.Addr { return f.s.addr(f.remote) }
+
+// LinkAddr implements linkAddresser.LinkAddr
+func (f *packetFlow) LinkAddr() net.Addr { return f.RemoteAddr() }
+
+// SetReadExpire implements HeaderConn.SetReadExpire
+func (f *packetFlow) SetReadExpire(d time.Duration) error {
+	if d < 0 {
+		return ErrInvalid
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.readDeadline = time.Now().Add(d)
+	return nil
+}
+
+// Close implements HeaderConn.Close
+func (f *packetFlow) Close() error {
+	if !f.shut() {
+		return ErrBad
+	}
+	f.s.forget(f)
+	return nil
+}
+
+// shut closes the done channel. It returns false if it already was.
+func (f *packetFlow) shut() bool {
+	f.Lock()
+	defer f.Unlock()
+	if isClosed(f.done) {
+		return false
+	}
+	close(f.done)
+	return true
+}
+
+// sendError returns the error of a packetSocket send that failed with err: ErrTooBig if the
+// packet exceeds the path MTU known to the operating system, and ErrIO otherwise
+func sendError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, syscall.EMSGSIZE):
+		return ErrTooBig
+	}
+	return ErrIO
+}
+
+// routeIP returns the local IP address that packets to remote leave from, as chosen by the
+// routing table, or nil if there is no route. If device is not empty, the packets leave
+// through the network interface of that name.
+func routeIP(remote endpoint, device string) net.IP {
+	network := "udp6"
+	if remote.IP.To4() != nil {
+		network = "udp4"
+	}
+	var d net.Dialer
+	if device != "" {
+		d.Control = func(_, _ string, rc syscall.RawConn) error { return bindDevice(rc, device) }
+	}
+	raddr := &net.UDPAddr{IP: remote.IP, Port: 9, Zone: remote.Zone}
+	c, err := d.Dial(network, raddr.String())
+	if err != nil {
+		return nil
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP
+}
+
+// csumIPs returns the forms of the IP addresses a and b for the checksum pseudo-header: four
+// bytes long if both are IPv4 addresses, and sixteen otherwise
+func csumIPs(a, b net.IP) ([]byte, []byte) {
+	if a4, b4 := a.To4(), b.To4(); a4 != nil && b4 != nil {
+		return a4, b4
+	}
+	a16, b16 := a.To16(), b.To16()
+	if a16 == nil {
+		a16 = net.IPv6unspecified
+	}
+	if b16 == nil {
+		b16 = net.IPv6unspecified
+	}
+	return a16, b16
+}
@@ -0,0 +1,75 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "bytes"
+
+// MaxInitCookieLen is the maximum length of the data of an Init Cookie option, Section 8.1.4
+const MaxInitCookieLen = 253
+
+// SetInitCookie makes a server connection place an Init Cookie option with the given data on
+// its Responses, Section 8.1.4. The client must echo the cookie on the Ack or DataAck that
+// completes the handshake, or the server resets the connection with Reset Code "Bad Init
+// Cookie". SetInitCookie must be called before the connection receives its first Request.
+func (c *Conn) SetInitCookie(cookie []byte) error {
+	if len(cookie) == 0 || len(cookie) > MaxInitCookieLen {
+		return ErrInvalid
+	}
+	err := ErrInvalid
+	c.do(func() {
+		if c.socket.IsServer() {
+			c.initCookies = []*Option{{Type: OptionInitCookie, Data: append([]byte(nil), cookie...)}}
+			err = nil
+		}
+	})
+	return err
+}
+
+// readInitCookies remembers the Init Cookie options of the Response h, which the client
+// echoes on every Ack and DataAck it sends in PARTOPEN
+func (c *Conn) readInitCookies(h *Header) {
+	c.initCookies = nil
+	for _, opt := range h.Options {
+		if opt.Type == OptionInitCookie {
+			c.initCookies = append(c.initCookies, opt)
+		}
+	}
+}
+
+// WriteInitCookies places Init Cookie options on h. The server places its cookie on
+// Responses, and the client echoes the server's cookies on Acks and DataAcks in PARTOPEN.
+func (c *Conn) WriteInitCookies(h *Header) {
+	if len(c.initCookies) == 0 {
+		return
+	}
+	switch c.socket.GetState() {
+	case RESPOND:
+		if h.Type != Response {
+			return
+		}
+	case PARTOPEN:
+		if h.Type != Ack && h.Type != DataAck {
+			return
+		}
+	default:
+		return
+	}
+	h.Options = append(h.Options, c.initCookies...)
+}
+
+// checkInitCookies returns true if the Ack or DataAck h, received in RESPOND, echoes the
+// Init Cookie that the server placed on its Responses
+func (c *Conn) checkInitCookies(h *Header) bool {
+	if len(c.initCookies) == 0 || (h.Type != Ack && h.Type != DataAck) {
+		return true
+	}
+	want := c.initCookies[0].Data
+	for _, opt := range h.Options {
+		if opt.Type == OptionInitCookie && bytes.Equal(opt.Data, want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,302 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// PcapWriter saves the packets of connections to a pcapng file, which Wireshark and tcpdump
+// can open. Each connection gets an interface of its own in the file, named after its side and
+// addresses, and the packets that it sends and receives are saved as raw IP packets with
+// nanosecond timestamps. Connections over UDP have their packets framed in IP and UDP headers
+// with the UDP ports, and all others in IP headers of protocol DCCP. Connections between
+// labels, like those of a Mux or of the sandbox, have no IP addresses, so they get 127.0.0.1
+// on the client side and 127.0.0.2 on the server side.
+type PcapWriter struct {
+	sync.Mutex
+	w   io.Writer
+	nif uint32 // Number of interfaces described so far
+	err error  // First error encountered, after which nothing more is written
+}
+
+// pcapng block types and link types, draft-ietf-opsawg-pcapng
+const (
+	pcapngSectionHeader        = 0x0a0d0d0a
+	pcapngInterfaceDescription = 1
+	pcapngEnhancedPacket       = 6
+	pcapngByteOrderMagic       = 0x1a2b3c4d
+	pcapngLinkTypeRaw          = 101 // Raw IPv4 or IPv6 packets, LINKTYPE_RAW
+	pcapngOptEnd               = 0
+	pcapngOptIfName            = 2
+	pcapngOptIfTsresol         = 9
+)
+
+// NewPcapWriter returns a PcapWriter that writes to w, and writes the header of the file
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	p := &PcapWriter{w: w}
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body[0:4], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:6], 1)           // Major version
+	binary.LittleEndian.PutUint16(body[6:8], 0)           // Minor version
+	binary.LittleEndian.PutUint64(body[8:16], ^uint64(0)) // Section length not given
+	if err := p.writeBlock(pcapngSectionHeader, body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// CreatePcapFile creates, or truncates, the file filename and returns a PcapWriter that writes
+// to it. Closing the PcapWriter closes the file.
+func CreatePcapFile(filename string) (*PcapWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewPcapWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close closes the underlying writer, if it is an io.Closer, and returns the first error
+// encountered while writing, if any
+func (p *PcapWriter) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	if errors.Is(p.err, ErrBad) {
+		return ErrBad
+	}
+	err := p.err
+	if c, ok := p.w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	p.err = ErrBad
+	return err
+}
+
+// addInterface describes a new interface called name and returns its number
+func (p *PcapWriter) addInterface(name string) (uint32, error) {
+	p.Lock()
+	defer p.Unlock()
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:2], pcapngLinkTypeRaw)
+	binary.LittleEndian.PutUint32(body[4:8], 0) // No snapshot length limit
+	body = appendPcapngOption(body, pcapngOptIfName, []byte(name))
+	body = appendPcapngOption(body, pcapngOptIfTsresol, []byte{9}) // Nanoseconds
+	body = appendPcapngOption(body, pcapngOptEnd, nil)
+	if err := p.writeBlock(pcapngInterfaceDescription, body); err != nil {
+		return 0, err
+	}
+	p.nif++
+	return p.nif - 1, nil
+}
+
+// writePacket saves the IP packet pkt, seen on interface iface at time t in nanoseconds
+func (p *PcapWriter) writePacket(iface uint32, t int64, pkt []byte) error {
+	p.Lock()
+	defer p.Unlock()
+	body := make([]byte, 20, 20+len(pkt)+3)
+	binary.LittleEndian.PutUint32(body[0:4], iface)
+	binary.LittleEndian.PutUint32(body[4:8], uint32(uint64(t)>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(t))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(pkt))) // Captured length
+	binary.LittleEndian.PutUint32(body[16:20], uint32(len(pkt))) // Original length
+	body = append(body, pkt...)
+	body = padPcapng(body)
+	return p.writeBlock(pcapngEnhancedPacket, body)
+}
+
+// writeBlock writes a block of type typ, whose body is a multiple of four bytes long
+func (p *PcapWriter) writeBlock(typ uint32, body []byte) error {
+	if p.err != nil {
+		return p.err
+	}
+	n := 12 + len(body)
+	buf := make([]byte, n)
+	binary.LittleEndian.PutUint32(buf[0:4], typ)
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(n))
+	copy(buf[8:], body)
+	binary.LittleEndian.PutUint32(buf[n-4:], uint32(n))
+	if _, err := p.w.Write(buf); err != nil {
+		p.err = err
+		return err
+	}
+	return nil
+}
+
+// appendPcapngOption appends the option code with the given value to b, padded to four bytes
+func appendPcapngOption(b []byte, code uint16, value []byte) []byte {
+	var hdr [4]byte
+	binary.LittleEndian.PutUint16(hdr[0:2], code)
+	binary.LittleEndian.PutUint16(hdr[2:4], uint16(len(value)))
+	return padPcapng(append(append(b, hdr[:]...), value...))
+}
+
+// padPcapng pads b with zeros to a multiple of four bytes
+func padPcapng(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// Placeholder addresses of the two sides of connections between labels
+var (
+	pcapClientIP = net.IPv4(127, 0, 0, 1)
+	pcapServerIP = net.IPv4(127, 0, 0, 2)
+)
+
+// pcapCapture is the state of a connection that saves its packets to a PcapWriter
+type pcapCapture struct {
+	w      *PcapWriter
+	iface  uint32
+	local  endpoint
+	remote endpoint
+	udp    bool // True if packets are framed in UDP, as DCCP-UDP, RFC 6773
+}
+
+// SetPcap makes the connection save the packets that it sends and receives to w, from now on.
+// A nil w stops saving. Connections start out saving to the PcapWriter of their Env, if any.
+func (c *Conn) SetPcap(w *PcapWriter) {
+	c.do(func() { c.pcapWriter = w })
+}
+
+// capture saves h, which the connection has sent if out is true or received otherwise, to
+// its PcapWriter, if any. Errors are left for PcapWriter.Close to report.
+func (c *Conn) capture(h *Header, out bool) {
+	c.capturer().capture(c.env, h, out)
+}
+
+// capturer returns the framing of the packets that the connection saves, or nil if it saves
+// none
+func (c *Conn) capturer() *pcapCapture {
+	if c.pcapWriter == nil {
+		return nil
+	}
+	if c.pcap == nil || c.pcap.w != c.pcapWriter {
+		c.pcap = c.newPcapCapture(c.pcapWriter)
+	}
+	return c.pcap
+}
+
+// capture saves h to the PcapWriter of pc, if pc is not nil, at the time of env
+func (pc *pcapCapture) capture(env *Env, h *Header, out bool) {
+	if pc == nil {
+		return
+	}
+	src, dst := pc.local, pc.remote
+	if !out {
+		src, dst = dst, src
+	}
+	pkt, err := pcapPacket(h, src, dst, pc.udp)
+	if err != nil {
+		return
+	}
+	pc.w.writePacket(pc.iface, env.nowNano(), pkt)
+}
+
+// newPcapCapture describes the connection as a new interface of w. It is called on the first
+// packet, once the connection knows which side it is on.
+func (c *Conn) newPcapCapture(w *PcapWriter) *pcapCapture {
+	pc := &pcapCapture{w: w}
+	switch a := c.LocalAddr().(type) {
+	case *net.UDPAddr:
+		pc.local, pc.udp = endpoint{IP: a.IP, Port: a.Port}, true
+	case *IPAddr:
+		pc.local = endpoint{IP: a.IP, Port: a.Port}
+	}
+	switch a := c.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		pc.remote = endpoint{IP: a.IP, Port: a.Port}
+	case *IPAddr:
+		pc.remote = endpoint{IP: a.IP, Port: a.Port}
+	}
+	if pc.local.IP == nil || pc.remote.IP == nil {
+		pc.local.IP, pc.remote.IP = pcapClientIP, pcapServerIP
+		if c.socket.IsServer() {
+			pc.local.IP, pc.remote.IP = pcapServerIP, pcapClientIP
+		}
+	}
+	if pc.local.IP.IsUnspecified() {
+		// A socket bound to a wildcard address does not say which address its packets leave from
+		pc.local.IP = pcapClientIP
+		if pc.remote.IP.To4() == nil {
+			pc.local.IP = net.IPv6loopback
+		}
+	}
+	name := fmt.Sprintf("%s %s-%s", ServerString(c.socket.IsServer()), c.LocalAddr(), c.RemoteAddr())
+	iface, err := w.addInterface(name)
+	if err != nil {
+		return nil
+	}
+	pc.iface = iface
+	return pc
+}
+
+// pcapPacket returns the IP packet that carries h from src to dst, with the ports of the
+// endpoints in place of those of h, if they are known
+func pcapPacket(h *Header, src, dst endpoint, udp bool) ([]byte, error) {
+	var payload []byte
+	var proto byte
+	hh := *h
+	if src.Port != 0 || dst.Port != 0 {
+		hh.SourcePort, hh.DestPort = uint16(src.Port), uint16(dst.Port)
+	}
+	if udp {
+		p, err := encapWrite(&hh, src, dst, nil)
+		if err != nil {
+			return nil, err
+		}
+		payload = make([]byte, udpHeaderLen, udpHeaderLen+len(p))
+		EncodeUint16(hh.SourcePort, payload[0:2])
+		EncodeUint16(hh.DestPort, payload[2:4])
+		EncodeUint16(uint16(udpHeaderLen+len(p)), payload[4:6])
+		// The UDP checksum is zero, as the DCCP checksum covers the UDP header, RFC 6773
+		payload = append(payload, p...)
+		proto = udpProtoNo
+	} else {
+		srcIP, dstIP := csumIPs(src.IP, dst.IP)
+		p, err := hh.Write(srcIP, dstIP, dccpProtoNo, false)
+		if err != nil {
+			return nil, err
+		}
+		payload, proto = p, dccpProtoNo
+	}
+
+	srcIP, dstIP := csumIPs(src.IP, dst.IP)
+	if len(srcIP) == net.IPv4len {
+		ip := make([]byte, 20, 20+len(payload))
+		ip[0] = 0x45
+		ip[1] = h.ECN & 3
+		EncodeUint16(uint16(20+len(payload)), ip[2:4])
+		ip[6] = 0x40 // Don't Fragment
+		ip[8] = 64
+		ip[9] = proto
+		copy(ip[12:16], srcIP)
+		copy(ip[16:20], dstIP)
+		csumUint16ToBytes(csumDone(csumSum(ip)), ip[10:12])
+		return append(ip, payload...), nil
+	}
+	ip := make([]byte, 40, 40+len(payload))
+	ip[0] = 0x60
+	ip[1] = (h.ECN & 3) << 4
+	EncodeUint16(uint16(len(payload)), ip[4:6])
+	ip[6] = proto
+	ip[7] = 64
+	copy(ip[8:24], srcIP)
+	copy(ip[24:40], dstIP)
+	return append(ip, payload...), nil
+}
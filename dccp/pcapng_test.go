@@ -0,0 +1,86 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestPcapWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewPcapWriter(&buf)
+	if err != nil {
+		t.Fatalf("new (%s)", err)
+	}
+	iface, err := w.addInterface("Client test")
+	if err != nil || iface != 0 {
+		t.Fatalf("interface %d (%v)", iface, err)
+	}
+
+	h := &Header{Type: Sync, X: true, SeqNo: 7, AckNo: 5, ECN: ECNECT0}
+	src := endpoint{IP: net.IPv4(10, 0, 0, 1), Port: 5001}
+	dst := endpoint{IP: net.IPv4(10, 0, 0, 2), Port: EncapPort}
+	native, err := pcapPacket(h, src, dst, false)
+	if err != nil {
+		t.Fatalf("native packet (%s)", err)
+	}
+	if native[0] != 0x45 || native[1] != ECNECT0 || native[9] != dccpProtoNo || csumSum(native[:20]) != 0xffff {
+		t.Errorf("native IP header % x", native[:20])
+	}
+	g, err := ReadHeader(native[20:], src.IP.To4(), dst.IP.To4(), dccpProtoNo, false)
+	if err != nil || g.SeqNo != 7 || g.SourcePort != 5001 || g.DestPort != EncapPort {
+		t.Errorf("native DCCP header %v (%v)", g, err)
+	}
+	udp, err := pcapPacket(h, src, dst, true)
+	if err != nil {
+		t.Fatalf("UDP packet (%s)", err)
+	}
+	if udp[9] != udpProtoNo || int(DecodeUint16(udp[20+4:20+6])) != len(udp)-20 {
+		t.Errorf("UDP headers % x", udp[:28])
+	}
+	if g, err = encapRead(udp[28:], src, dst); err != nil || g.SeqNo != 7 || g.AckNo != 5 {
+		t.Errorf("DCCP-UDP header %v (%v)", g, err)
+	}
+	src6 := endpoint{IP: net.ParseIP("fd00::1"), Port: 1}
+	dst6 := endpoint{IP: net.ParseIP("fd00::2"), Port: 2}
+	ip6, err := pcapPacket(h, src6, dst6, false)
+	if err != nil || ip6[0] != 0x60 || ip6[6] != dccpProtoNo || int(DecodeUint16(ip6[4:6])) != len(ip6)-40 {
+		t.Errorf("IPv6 packet % x (%v)", ip6, err)
+	}
+
+	if err = w.writePacket(iface, 1e18+3, native); err != nil {
+		t.Fatalf("write (%s)", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Errorf("close (%s)", err)
+	}
+	if err = w.writePacket(iface, 0, native); err != ErrBad {
+		t.Errorf("write after close (%v)", err)
+	}
+
+	// Walk the blocks: section header, interface description, enhanced packet
+	b := buf.Bytes()
+	var types []uint32
+	var body []byte
+	for len(b) > 0 {
+		n := int(binary.LittleEndian.Uint32(b[4:8]))
+		if n%4 != 0 || n > len(b) || binary.LittleEndian.Uint32(b[n-4:n]) != uint32(n) {
+			t.Fatalf("bad block length %d", n)
+		}
+		types = append(types, binary.LittleEndian.Uint32(b[0:4]))
+		body, b = b[8:n-4], b[n:]
+	}
+	if len(types) != 3 || types[0] != pcapngSectionHeader || types[1] != pcapngInterfaceDescription || types[2] != pcapngEnhancedPacket {
+		t.Fatalf("blocks %x", types)
+	}
+	ts := uint64(binary.LittleEndian.Uint32(body[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:12]))
+	caplen := int(binary.LittleEndian.Uint32(body[12:16]))
+	if ts != 1e18+3 || caplen != len(native) || !bytes.Equal(body[20:20+caplen], native) {
+		t.Errorf("packet block at %d of %d bytes", ts, caplen)
+	}
+}
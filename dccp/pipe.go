@@ -4,10 +4,16 @@
 
 package dccp
 
+import (
+	"errors"
+	"fmt"
+)
+
 func (c *Conn) readHeader() (h *Header, err error) {
 	h, err = c.hc.Read()
 	if err != nil {
-		if err != ErrTimeout {
+		var ie *ICMPError
+		if !errors.As(err, &ie) && !errors.Is(err, ErrTimeout) {
 			c.amb.E(EventDrop, "Bad header", h)
 		}
 		return nil, err
@@ -19,140 +25,48 @@ func (c *Conn) readHeader() (h *Header, err error) {
 	return h, nil
 }
 
-// idleLoop polls the congestion control OnIdle method at regular intervals
-// of approximately one RTT.
-func (c *Conn) idleLoop() {
-	for {
-		c.pollCongestionControl()
-
-		c.Lock()
-		c.syncWithCongestionControl()
-		rtt := c.socket.GetRTT()
-		state := c.socket.GetState()
-		c.Unlock()
-
-		if state == CLOSED {
-			break
-		}
-		// This emit prints very often. Use when really necessary
-		//c.amb.E(EventIdle, "")
-		c.env.Sleep(max64(RoundtripMin, min64(rtt, RoundtripDefault)))
-	}
-}
-
-func (c *Conn) readLoop() {
-	for {
-		c.Lock()
-		state := c.socket.GetState()
-		rtt := c.socket.GetRTT()
-		c.Unlock()
-		if state == CLOSED {
-			break
-		}
-
-		// Adjust read timeout
-		if err := c.hc.SetReadExpire(5 * rtt); err != nil {
-			c.amb.E(EventError, "SetReadExpire")
-			c.abortQuietly()
-			return
-		}
-
-		// Read next header
-		h, err := c.readHeader()
-		if err != nil {
-			_, ok := err.(ProtoError)
-			if ok {
-				// Drop packets that are unsupported. Intended for forward compatibility.
-				continue
-			} else if err == ErrTimeout {
-				// In the even of timeout, poll the congestion controls
-				c.pollCongestionControl()
-				continue
-			} else {
-				// Die if the underlying link is broken
-				c.abortQuietly()
-				return
-			}
-		}
-		c.amb.E(EventRead, "", h)
-
-		c.Lock()
-		c.syncWithCongestionControl()
-		if c.step2_ProcessTIMEWAIT(h) != nil {
-			goto Done
-		}
-		if c.step3_ProcessLISTEN(h) != nil {
-			goto Done
-		}
-		if c.step4_PrepSeqNoREQUEST(h) != nil {
-			goto Done
-		}
-		if c.step5_PrepSeqNoForSync(h) != nil {
-			goto Done
-		}
-		if c.step6_CheckSeqNo(h) != nil {
-			goto Done
-		}
-		if c.step7_CheckUnexpectedTypes(h) != nil {
-			goto Done
-		}
-		if c.step8_OptionsAndMarkAckbl(h) != nil {
-			goto Done
-		}
-		if c.step9_ProcessReset(h) != nil {
-			goto Done
-		}
-		if c.step10_ProcessREQUEST2(h) != nil {
-			goto Done
-		}
-		if c.step11_ProcessRESPOND(h) != nil {
-			goto Done
-		}
-		if c.step12_ProcessPARTOPEN(h) != nil {
-			goto Done
-		}
-		if c.step13_ProcessCloseReq(h) != nil {
-			goto Done
-		}
-		if c.step14_ProcessClose(h) != nil {
-			goto Done
-		}
-		if c.step15_ProcessSync(h) != nil {
-			goto Done
-		}
-		if c.step16_ProcessData(h) != nil {
-			goto Done
-		}
-	Done:
-		c.Unlock()
+// idle polls the congestion control OnIdle method, MTU probing and keepalives. It is fired by
+// the idle timer of the connection at intervals of approximately one RTT, until the connection
+// is CLOSED.
+func (c *Conn) idle() {
+	c.pollCongestionControl()
+	c.pollRate()
+	c.syncWithCongestionControl()
+	c.pollMTUProbe()
+	c.pollKeepalive()
+	c.pollIdleTimeout()
+	c.pollPeerProbe()
+	if c.socket.GetState() == CLOSED {
+		return
 	}
-	c.amb.E(EventInfo, "Read loop EXIT")
+	// This emit prints very often. Use when really necessary
+	//c.amb.E(EventIdle, "")
+	wait := max64(RoundtripMin, min64(c.socket.GetRTT(), RoundtripDefault))
+	c.wheel.schedule(&c.idleTimer, c.env.nowNano()+wait)
 }
 
 func (c *Conn) pollCongestionControl() {
-	now := c.env.Now()
+	now := c.env.nowNano()
 	if e := c.scc.OnIdle(now); e != nil {
-		if re, ok := e.(CongestionReset); ok {
+		var re CongestionReset
+		if errors.As(e, &re) {
 			c.abortWith(re.ResetCode())
 			return
 		}
-		if e == CongestionAck {
-			c.Lock()
+		if errors.Is(e, CongestionAck) {
 			c.inject(c.generateAck())
-			c.Unlock()
 			return
 		}
 		c.amb.E(EventError, "Sender CC unknown idle error")
 	}
 	if e := c.rcc.OnIdle(now); e != nil {
-		if re, ok := e.(CongestionReset); ok {
+		var re CongestionReset
+		if errors.As(e, &re) {
 			c.abortWith(re.ResetCode())
 			return
 		}
-		if e == CongestionAck {
-			c.Lock()
+		if errors.Is(e, CongestionAck) {
 			c.inject(c.generateAck())
-			c.Unlock()
 			return
 		}
 		c.amb.E(EventError, "Receiver CC unknown idle error")
@@ -160,12 +74,52 @@ func (c *Conn) pollCongestionControl() {
 }
 
 func (c *Conn) syncWithCongestionControl() {
-	c.AssertLocked()
 	c.socket.SetRTT(c.scc.GetRTT())
 	c.socket.SetCCMPS(c.scc.GetCCMPS())
+	c.syncWithAckRatio()
+}
+
+// syncWithFeatures updates the socket variables that mirror negotiated feature values
+func (c *Conn) syncWithFeatures() {
+	swaf, swbf := int64(c.features.Local(FeatureSequenceWindow)), int64(c.features.Remote(FeatureSequenceWindow))
+	if swaf != c.socket.GetSWAF() || swbf != c.socket.GetSWBF() {
+		c.amb.E(EventInfo, fmt.Sprintf("Sequence Window A=%d B=%d", swaf, swbf))
+	}
+	c.socket.SetSWAF(swaf)
+	c.socket.SetSWBF(swbf)
+}
+
+// agreesOnCCID returns false if the CCID negotiation has completed with a value other than that
+// of the CCID instance the connection was created with. The connection cannot switch CCIDs, so
+// such a connection must be reset.
+func (c *Conn) agreesOnCCID() bool {
+	if !c.features.Pending(true, FeatureCCID) && c.features.Local(FeatureCCID) != uint64(c.scc.GetID()) {
+		return false
+	}
+	if !c.features.Pending(false, FeatureCCID) && c.features.Remote(FeatureCCID) != uint64(c.rcc.GetID()) {
+		return false
+	}
+	return true
 }
 
+// syncWithLink updates the PMTU from the MTU of the HeaderConn, which path MTU discovery may
+// change at any time, Section 14.1, or from SetMTU and MTU probing. A change after the first
+// call is passed on to the sender CCID and to the MTU handler of the application.
 func (c *Conn) syncWithLink() {
-	c.AssertLocked()
-	c.socket.SetPMTU(int32(c.hc.GetMTU()))
+	pmtu := c.linkPMTU()
+	old := c.socket.GetPMTU()
+	if pmtu == old {
+		return
+	}
+	c.socket.SetPMTU(pmtu)
+	if old == 0 {
+		return
+	}
+	c.amb.E(EventInfo, fmt.Sprintf("PMTU %d, was %d", pmtu, old))
+	if ms, ok := c.scc.(MPSSender); ok {
+		ms.SetMPS(c.socket.GetMPS())
+	}
+	if c.mtuHandler != nil {
+		go c.mtuHandler(c.getMTU())
+	}
 }
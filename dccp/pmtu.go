@@ -0,0 +1,63 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "net"
+
+// Path MTU discovery, Section 14.1, for the flows of a packetSocket. A flow starts out with
+// the MTU of the interface that its packets leave from and shrinks it when Fragmentation
+// Needed or Packet Too Big messages arrive. Raw IP sockets send with the Don't Fragment bit
+// set, so that routers send those messages rather than fragment.
+
+const (
+	defaultIPMTU = 1500
+
+	// Smallest path MTUs that ICMP messages may lower the MTU to. The IPv4 minimum, of RFC 1122,
+	// Section 3.3.3, rather than 68 bytes, guards against forged messages that would have a
+	// connection send tiny packets.
+	minIPv4PathMTU = 576
+	minIPv6PathMTU = 1280
+)
+
+// interfaceMTU returns the MTU of the network interface that has the IP address ip, or
+// defaultIPMTU if no interface has it
+func interfaceMTU(ip net.IP) int {
+	if ip == nil || ip.IsUnspecified() {
+		return defaultIPMTU
+	}
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return defaultIPMTU
+	}
+	for _, ifi := range ifis {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if in, ok := a.(*net.IPNet); ok && in.IP.Equal(ip) && ifi.MTU > 0 {
+				return ifi.MTU
+			}
+		}
+	}
+	return defaultIPMTU
+}
+
+// ipHeaderLen returns the length of the IP header of packets to ip, without options or
+// extension headers
+func ipHeaderLen(ip net.IP) int {
+	if ip.To4() != nil {
+		return 20
+	}
+	return 40
+}
+
+// minPathMTU returns the smallest path MTU that ICMP messages about packets to ip are believed
+func minPathMTU(ip net.IP) int {
+	if ip.To4() != nil {
+		return minIPv4PathMTU
+	}
+	return minIPv6PathMTU
+}
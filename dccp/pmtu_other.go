@@ -0,0 +1,12 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package dccp
+
+import "syscall"
+
+// setDontFragment fails, as only Linux lets DCCP set the Don't Fragment bit of raw IP packets
+func setDontFragment(c syscall.Conn) error { return ErrUnsupported }
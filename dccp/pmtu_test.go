@@ -0,0 +1,36 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPacketFlowMTU(t *testing.T) {
+	e, err := BindUDPEncap("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("bind (%s)", err)
+	}
+	defer e.Close()
+	hc, err := e.Dial(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+	if err != nil {
+		t.Fatalf("dial (%s)", err)
+	}
+	f := hc.(*packetFlow)
+	if mtu := f.GetMTU(); mtu != interfaceMTU(net.IPv4(127, 0, 0, 1))-20-4 {
+		t.Errorf("initial MTU %d", mtu)
+	}
+	f.deliverICMP(&ICMPError{Type: 3, Code: 4, MTU: 1400})
+	if mtu := f.GetMTU(); mtu != 1400-20-4 {
+		t.Errorf("MTU %d after Fragmentation Needed", mtu)
+	}
+	// Messages that would raise the MTU, or lower it below the minimum, are ignored
+	f.deliverICMP(&ICMPError{Type: 3, Code: 4, MTU: 1450})
+	f.deliverICMP(&ICMPError{Type: 3, Code: 4, MTU: 300})
+	if mtu := f.GetMTU(); mtu != 1400-20-4 {
+		t.Errorf("MTU %d after ignored messages", mtu)
+	}
+}
@@ -0,0 +1,399 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Port accepts DCCP connections arriving on a Link and passes each one to the Listener of its
+// service code, so that several services can share one local port, RFC 5595, Section 2.
+// Requests for a service code that no Listener serves are answered with a Reset with Reset
+// Code Bad Service Code. A Port also starts connections of its own, with Dial, so that a
+// client or a server can run many connections over a single socket, which demultiplexes
+// their packets.
+type Port struct {
+	flows   flowAcceptor
+	ccid    CCID
+	backlog *Backlog
+	closing chan struct{} // Closed by Close
+
+	Mutex
+	closed    bool
+	conns     map[*Conn]struct{}    // Connections that have not ended yet
+	listeners map[ServiceCode]*Listener // Listeners by service code
+	wildcard  *Listener            // Listener for the service codes of no other Listener
+}
+
+// NewPort creates a Port that accepts connections on link, with congestion control ccid.
+// At most DefaultListenBacklog connections may be half-open at the same time, across all
+// Listeners of the Port.
+func NewPort(link Link, ccid CCID) *Port {
+	return newPort(muxAcceptor{NewMux(link), link}, ccid)
+}
+
+// NewEncapPort creates a Port that accepts DCCP-UDP connections on e, see NewPort
+func NewEncapPort(e *UDPEncap, ccid CCID) *Port {
+	return newPort(e, ccid)
+}
+
+func newPort(flows flowAcceptor, ccid CCID) *Port {
+	p := &Port{
+		flows:     flows,
+		ccid:      ccid,
+		backlog:   NewBacklog(DefaultListenBacklog),
+		closing:   make(chan struct{}),
+		conns:     make(map[*Conn]struct{}),
+		listeners: make(map[ServiceCode]*Listener),
+	}
+	go p.loop()
+	return p
+}
+
+// Listen returns a Listener for the connections to the given service codes. A Listener
+// without service codes receives the connections to all codes that no other Listener of the
+// Port serves. Listen returns ErrInUse if one of the codes, or the wildcard, is taken.
+func (p *Port) Listen(serviceCodes ...ServiceCode) (*Listener, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return nil, ErrBad
+	}
+	if len(serviceCodes) == 0 && p.wildcard != nil {
+		return nil, ErrInUse
+	}
+	for _, sc := range serviceCodes {
+		if !isValidServiceCode(sc) {
+			return nil, ErrInvalid
+		}
+		if p.listeners[sc] != nil {
+			return nil, ErrInUse
+		}
+	}
+	l := newListener(p, serviceCodes)
+	if len(serviceCodes) == 0 {
+		p.wildcard = l
+	}
+	for _, sc := range serviceCodes {
+		p.listeners[sc] = l
+	}
+	return l, nil
+}
+
+// admitTimeout is how long the Port waits for the first packet of an incoming flow
+const admitTimeout = time.Second
+
+// loop admits every incoming flow, until the flows are closed
+func (p *Port) loop() {
+	for {
+		hc, err := p.flows.acceptHeaderConn()
+		if err != nil {
+			return
+		}
+		go p.admit(hc)
+	}
+}
+
+// admit reads the Request that opens the flow hc and passes it through the filter of the Port.
+// A server connection is only created for a Request that passes. Flows that open with anything
+// else, or whose Request is dropped or rejected, are closed without a connection ever being
+// committed to them, so that a flood of Requests costs no more than reading them.
+func (p *Port) admit(hc HeaderConn) {
+	h, err := p.readRequest(hc)
+	if err != nil {
+		closeFlow(hc)
+		return
+	}
+	p.Lock()
+	codes := p.serviceCodes()
+	p.Unlock()
+	sc, ok := chooseServiceCode(codes, h)
+	action, resetCode := FilterReject, byte(ResetBadServiceCode)
+	if ok {
+		action, resetCode = p.filter(newRequestInfo(hc, h, sc))
+	}
+	if action != FilterAccept {
+		if action == FilterReject {
+			writeAbnormalReset(hc, resetCode, h)
+		}
+		closeFlow(hc)
+		return
+	}
+
+	p.Lock()
+	if p.closed {
+		p.Unlock()
+		hc.Close()
+		return
+	}
+	env := NewEnv(nil)
+	c := newConnServer(env, NoLogging, hc,
+		p.ccid.NewSender(env, NoLogging),
+		p.ccid.NewReceiver(env, NoLogging),
+		codes, p.configure, h)
+	p.conns[c] = struct{}{}
+	p.Unlock()
+
+	go p.handshake(c, c.handshake)
+	go func() {
+		c.Joiner().Join()
+		p.forget(c)
+	}()
+}
+
+// readRequest reads the first packet of the flow hc, which must be a Request. Anything else is
+// answered with a Reset, Section 8.5, Step 3, unless it is a Reset itself.
+func (p *Port) readRequest(hc HeaderConn) (*Header, error) {
+	if err := hc.SetReadExpire(admitTimeout); err != nil {
+		return nil, err
+	}
+	h, err := hc.Read()
+	if err != nil {
+		return nil, err
+	}
+	if !h.X {
+		return nil, ErrUnsupported
+	}
+	if h.Type != Request {
+		if h.Type != Reset {
+			writeAbnormalReset(hc, ResetNoConnection, h)
+		}
+		return nil, ErrDrop
+	}
+	return h, nil
+}
+
+// writeAbnormalReset answers the packet inResponseTo on hc, which has no connection, with a
+// Reset with Reset Code resetCode
+func writeAbnormalReset(hc HeaderConn, resetCode byte, inResponseTo *Header) {
+	h := &Header{}
+	h.InitResetHeader(resetCode)
+	hc.Write(abnormalSeqAck(h, inResponseTo))
+}
+
+// closeFlow closes the flow hc of a Request that was not admitted. Its labels are forgotten
+// at once, rather than kept for a while as those of a connection that ended, so that the
+// retransmissions of the Request open new flows.
+func closeFlow(hc HeaderConn) {
+	if r, ok := hc.(Reclaimer); ok && r.Reclaim() == nil {
+		return
+	}
+	hc.Close()
+}
+
+// Dial connects to the DCCP server at raddr through the socket of the Port, asking for the
+// service code serviceCode, and returns the connection once the handshake completes. The type
+// of raddr is that of the Port's network: a *net.UDPAddr for UDP and DCCP-UDP, and an *IPAddr
+// for DCCP over IP. Over DCCP-UDP, where the UDP ports are the DCCP ports, a Port has at most
+// one connection to each remote address. Connections that Dial returns are not affected when
+// the Port is closed, like those that its Listeners return.
+func (p *Port) Dial(raddr net.Addr, serviceCode ServiceCode) (*Conn, error) {
+	return p.DialContext(context.Background(), raddr, serviceCode)
+}
+
+// DialContext is like Dial, except that it gives up if ctx is done before the handshake
+// completes, in which case the connection is aborted and the error of ctx is returned.
+func (p *Port) DialContext(ctx context.Context, raddr net.Addr, serviceCode ServiceCode) (*Conn, error) {
+	p.Lock()
+	if p.closed {
+		p.Unlock()
+		return nil, ErrBad
+	}
+	hc, err := p.flows.dialHeaderConn(raddr)
+	if err != nil {
+		p.Unlock()
+		return nil, err
+	}
+	env := NewEnv(nil)
+	c := NewConnClient(env, NoLogging, hc,
+		p.ccid.NewSender(env, NoLogging),
+		p.ccid.NewReceiver(env, NoLogging),
+		serviceCode)
+	p.conns[c] = struct{}{}
+	p.Unlock()
+
+	go func() {
+		c.Joiner().Join()
+		p.forget(c)
+	}()
+	if err := c.waitOpen(ctx); err != nil {
+		c.Abort()
+		return nil, err
+	}
+	return c, nil
+}
+
+// serviceCodes returns the service codes that the connections of the Port may choose from,
+// or nil if there is a wildcard Listener. p must be locked.
+func (p *Port) serviceCodes() []ServiceCode {
+	if p.wildcard != nil {
+		return nil
+	}
+	codes := make([]ServiceCode, 0, len(p.listeners))
+	for sc := range p.listeners {
+		codes = append(codes, sc)
+	}
+	return codes
+}
+
+// configure applies the settings of the Port to its new connection c. It is called with p
+// locked, before c starts.
+func (p *Port) configure(c *Conn) {
+	c.backlog = p.backlog
+}
+
+// listener returns the Listener for service code sc, or nil if there is none
+func (p *Port) listener(sc ServiceCode) *Listener {
+	p.Lock()
+	defer p.Unlock()
+	if l := p.listeners[sc]; l != nil {
+		return l
+	}
+	return p.wildcard
+}
+
+// filter is the RequestFilter of all flows of the Port, see admit. It resets Requests for service
+// codes without a Listener, which remain possible when the Port has no Listeners at all, and
+// passes the others on to the filter of their Listener.
+func (p *Port) filter(r *RequestInfo) (FilterAction, byte) {
+	l := p.listener(r.ServiceCode)
+	if l == nil {
+		return FilterReject, ResetBadServiceCode
+	}
+	l.Lock()
+	f := l.requestFilter
+	l.Unlock()
+	if f == nil {
+		return FilterAccept, 0
+	}
+	return f(r)
+}
+
+// handshake passes c on to the Listener of its service code, once its handshake completes
+// successfully
+func (p *Port) handshake(c *Conn, handshake <-chan struct{}) {
+	select {
+	case <-handshake:
+	case <-p.closing:
+		c.Abort()
+		return
+	}
+	if c.WaitOpen() != nil {
+		return
+	}
+	l := p.listener(c.ServiceCode())
+	if l == nil {
+		c.Abort()
+		return
+	}
+	select {
+	case l.accept <- c:
+	case <-l.closing:
+		c.Abort()
+	case <-p.closing:
+		c.Abort()
+	}
+}
+
+// forget removes the ended connection c. The flows are closed once the Port is closed and
+// all of its connections have ended.
+func (p *Port) forget(c *Conn) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.conns, c)
+	if p.closed && len(p.conns) == 0 {
+		p.flows.Close()
+	}
+}
+
+// remove stops passing connections to the Listener l
+func (p *Port) remove(l *Listener) {
+	p.Lock()
+	defer p.Unlock()
+	if p.wildcard == l {
+		p.wildcard = nil
+	}
+	for _, sc := range l.serviceCodes {
+		if p.listeners[sc] == l {
+			delete(p.listeners, sc)
+		}
+	}
+}
+
+// Close closes all Listeners of the Port and stops accepting connections. Connections whose
+// handshake is still in progress, or that have not been returned by Accept yet, are aborted.
+// The connections returned by Accept and Dial are not affected. The underlying Link is
+// closed when the last of them ends.
+func (p *Port) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return ErrBad
+	}
+	p.closed = true
+	close(p.closing)
+	if p.wildcard != nil {
+		p.wildcard.shut()
+		p.wildcard = nil
+	}
+	for sc, l := range p.listeners {
+		l.shut()
+		delete(p.listeners, sc)
+	}
+	if len(p.conns) == 0 {
+		p.flows.Close()
+	}
+	return nil
+}
+
+// Addr returns the local address of the Port, or ZeroAddr if its link does not report one
+func (p *Port) Addr() net.Addr {
+	if la, ok := p.flows.(interface{ LocalAddr() net.Addr }); ok {
+		return la.LocalAddr()
+	}
+	return ZeroAddr
+}
+
+// flowAcceptor splits the packets arriving on a link into the HeaderConns of connections
+type flowAcceptor interface {
+	acceptHeaderConn() (HeaderConn, error)
+
+	// dialHeaderConn returns the HeaderConn of a new connection to raddr
+	dialHeaderConn(raddr net.Addr) (HeaderConn, error)
+
+	Close() error
+}
+
+// muxAcceptor is the flowAcceptor of a Mux over link
+type muxAcceptor struct {
+	*Mux
+	link Link
+}
+
+func (m muxAcceptor) acceptHeaderConn() (HeaderConn, error) {
+	bc, err := m.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewHeaderConn(bc), nil
+}
+
+func (m muxAcceptor) dialHeaderConn(raddr net.Addr) (HeaderConn, error) {
+	bc, err := m.Dial(raddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewHeaderConn(bc), nil
+}
+
+// LocalAddr returns the local address of the link, or ZeroAddr if it does not report one
+func (m muxAcceptor) LocalAddr() net.Addr {
+	if la, ok := m.link.(interface{ LocalAddr() net.Addr }); ok {
+		return la.LocalAddr()
+	}
+	return ZeroAddr
+}
@@ -0,0 +1,462 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+)
+
+// dccpProtoNo is the IP protocol number of DCCP
+const dccpProtoNo = 33
+
+// IPAddr is the address of a DCCP endpoint on an IP network
+type IPAddr struct {
+	IP   net.IP
+	Port int
+	Zone string // IPv6 scoped addressing zone
+}
+
+// Network implements net.Addr.Network
+func (a *IPAddr) Network() string { return "dccp" }
+
+// String implements net.Addr.String
+func (a *IPAddr) String() string {
+	ip := a.IP.String()
+	if a.Zone != "" {
+		ip += "%" + a.Zone
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(a.Port))
+}
+
+// ResolveIPAddr returns the address of the DCCP endpoint address, of the form "host:port", on
+// the network "dccp", "dccp4" or "dccp6"
+func ResolveIPAddr(network, address string) (*IPAddr, error) {
+	unet, err := rawUDPNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	u, err := net.ResolveUDPAddr(unet, address)
+	if err != nil {
+		return nil, err
+	}
+	return &IPAddr{IP: u.IP, Port: u.Port, Zone: u.Zone}, nil
+}
+
+// rawUDPNetwork returns the UDP network of the same address family as the DCCP network
+func rawUDPNetwork(network string) (string, error) {
+	switch network {
+	case "dccp", "dccp4", "dccp6":
+		return "udp" + network[4:], nil
+	}
+	return "", net.UnknownNetworkError(network)
+}
+
+// RawIP sends and receives native DCCP packets, IP protocol 33, through a raw IP socket, and
+// passes those to its local DCCP port on to its connections. Packets are demultiplexed by
+// source port, destination port and remote address, so that the connections that Dial starts
+// may use other local ports, when the port of the RawIP is taken for their remote endpoint.
+// Several RawIPs, with different ports, may run at the same time, since each raw socket
+// receives all DCCP packets. Raw sockets
+// need root privileges, or CAP_NET_RAW on Linux. The DCCP implementation of the operating
+// system, if any, must not be serving the same ports.
+type RawIP struct {
+	c4, c6 *net.IPConn // Raw sockets of each address family; one of them may be nil
+	ecn    bool        // True if the sockets receive the TOS byte of packets, see enableRecvTOS
+	local  endpoint
+	accept chan *packetFlow // Flows started by Requests from unknown endpoints
+
+	Mutex
+	closed bool
+	device string                 // Network interface that the sockets are bound to, if any
+	flows  map[string]*packetFlow // Flows by local port and remote endpoint, see flowKey
+}
+
+// ListenRawIP opens a raw IP socket on network "dccp4" or "dccp6", for the DCCP port of laddr.
+// On network "dccp", it opens a raw socket for the address family of laddr, or, if laddr is nil
+// or unspecified, one for each family that the host supports, so that it serves both IPv4 and
+// IPv6. If laddr is nil or its port is zero, a port from the dynamic range is chosen at random.
+// If raw sockets are not permitted, the returned error matches os.ErrPermission.
+func ListenRawIP(network string, laddr *IPAddr) (*RawIP, error) {
+	if _, err := rawUDPNetwork(network); err != nil {
+		return nil, err
+	}
+	var local endpoint
+	var ipaddr *net.IPAddr
+	if laddr != nil {
+		local = endpoint{laddr.IP, laddr.Port, laddr.Zone}
+		if laddr.IP != nil && !laddr.IP.IsUnspecified() {
+			ipaddr = &net.IPAddr{IP: laddr.IP, Zone: laddr.Zone}
+		}
+	}
+	if local.Port == 0 {
+		local.Port = 49152 + rand.Intn(65536-49152)
+	}
+	v4, v6 := network != "dccp6", network != "dccp4"
+	if network == "dccp" && ipaddr != nil {
+		v4, v6 = ipaddr.IP.To4() != nil, ipaddr.IP.To4() == nil
+	}
+	r := &RawIP{
+		local:  local,
+		accept: make(chan *packetFlow, DefaultListenBacklog),
+		flows:  make(map[string]*packetFlow),
+	}
+	var err error
+	if v4 {
+		if r.c4, err = listenRawIP("ip4", ipaddr); err != nil {
+			return nil, err
+		}
+	}
+	if v6 {
+		if r.c6, err = listenRawIP("ip6", ipaddr); err != nil {
+			if r.c4 == nil || network != "dccp" {
+				if r.c4 != nil {
+					r.c4.Close()
+				}
+				return nil, err
+			}
+			// A dual-stack RawIP makes do with IPv4 on hosts without IPv6
+		}
+	}
+	// Connections negotiate ECN Incapable unless every socket passes the codepoints on
+	r.ecn = r.eachConn(func(c *net.IPConn) error { return enableRecvTOS(c) }) == nil
+	if r.c4 != nil {
+		go r.readLoop(r.c4)
+	}
+	if r.c6 != nil {
+		go r.readLoop(r.c6)
+	}
+	return r, nil
+}
+
+// listenRawIP opens a raw socket for DCCP on the IP network inet
+func listenRawIP(inet string, laddr *net.IPAddr) (*net.IPConn, error) {
+	c, err := net.ListenIP(inet+":"+strconv.Itoa(dccpProtoNo), laddr)
+	if err != nil && errors.Is(err, os.ErrPermission) {
+		return nil, fmt.Errorf("raw IP sockets need root or CAP_NET_RAW: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Path MTU discovery relies on the Don't Fragment bit; where it cannot be set, oversized
+	// packets are fragmented instead
+	setDontFragment(c)
+	enableICMP(c)
+	return c, nil
+}
+
+// Dial returns a HeaderConn for a connection to the DCCP endpoint at raddr, from the port of
+// the RawIP or, if that already has a connection to raddr, from a port chosen at random
+func (r *RawIP) Dial(raddr *IPAddr) (HeaderConn, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.closed {
+		return nil, ErrBad
+	}
+	if r.conn(raddr.IP) == nil {
+		return nil, ErrUnsupported
+	}
+	remote := endpoint{raddr.IP, raddr.Port, raddr.Zone}
+	port := r.local.Port
+	for i := 0; r.flows[flowKey(port, remote)] != nil; i++ {
+		if i == rawIPPortTries {
+			return nil, ErrInUse
+		}
+		port = 49152 + rand.Intn(65536-49152)
+	}
+	f := r.newFlow(port, remote)
+	r.flows[flowKey(port, remote)] = f
+	return f, nil
+}
+
+// rawIPPortTries is the number of random local ports that Dial tries for a remote endpoint that
+// the port of the RawIP is already connected to
+const rawIPPortTries = 64
+
+// flowKey returns the key of the flow between the local port and the remote endpoint
+func flowKey(port int, remote endpoint) string {
+	return strconv.Itoa(port) + " " + remote.String()
+}
+
+// Accept returns a HeaderConn for the next connection that a Request from a new remote
+// endpoint starts, as UDPEncap.Accept does
+func (r *RawIP) Accept() (HeaderConn, error) {
+	f, ok := <-r.accept
+	if !ok {
+		return nil, ErrBad
+	}
+	return f, nil
+}
+
+// acceptHeaderConn implements flowAcceptor.acceptHeaderConn
+func (r *RawIP) acceptHeaderConn() (HeaderConn, error) { return r.Accept() }
+
+// dialHeaderConn implements flowAcceptor.dialHeaderConn
+func (r *RawIP) dialHeaderConn(raddr net.Addr) (HeaderConn, error) {
+	ia, ok := raddr.(*IPAddr)
+	if !ok {
+		return nil, ErrInvalid
+	}
+	return r.Dial(ia)
+}
+
+// LocalAddr returns the local address of the RawIP, with its DCCP port
+func (r *RawIP) LocalAddr() net.Addr {
+	ip := r.local.IP
+	if ip == nil {
+		ip = net.IPv4zero
+		if r.c6 != nil {
+			ip = net.IPv6unspecified
+		}
+	}
+	return &IPAddr{IP: ip, Port: r.local.Port, Zone: r.local.Zone}
+}
+
+// conn returns the raw socket for packets to ip, or nil if there is none of its family
+func (r *RawIP) conn(ip net.IP) *net.IPConn {
+	if ip.To4() != nil {
+		return r.c4
+	}
+	return r.c6
+}
+
+// SetReadBuffer sets the size of the receive buffers of the raw sockets, SO_RCVBUF. As each raw
+// socket receives all DCCP packets of its address family, busy hosts may need more than the
+// default size of the operating system.
+func (r *RawIP) SetReadBuffer(bytes int) error {
+	return r.eachConn(func(c *net.IPConn) error { return c.SetReadBuffer(bytes) })
+}
+
+// SetWriteBuffer sets the size of the send buffers of the raw sockets, SO_SNDBUF
+func (r *RawIP) SetWriteBuffer(bytes int) error {
+	return r.eachConn(func(c *net.IPConn) error { return c.SetWriteBuffer(bytes) })
+}
+
+// BindToDevice binds the raw sockets to the network interface ifname, so that packets are
+// sent and received through it alone, and flows take its addresses, see Dialer.Interface
+func (r *RawIP) BindToDevice(ifname string) error {
+	if err := r.eachConn(func(c *net.IPConn) error { return bindConnToDevice(c, ifname) }); err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.device = ifname
+	return nil
+}
+
+// eachConn calls f on each raw socket and returns the last error
+func (r *RawIP) eachConn(f func(c *net.IPConn) error) error {
+	var err error
+	for _, c := range []*net.IPConn{r.c4, r.c6} {
+		if c != nil {
+			if e := f(c); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// Close closes the raw socket and all connections on it
+func (r *RawIP) Close() error {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return ErrBad
+	}
+	r.closed = true
+	for _, f := range r.flows {
+		f.shut()
+	}
+	r.Unlock()
+	err := r.eachConn((*net.IPConn).Close)
+	close(r.accept)
+	return err
+}
+
+func (r *RawIP) readLoop(c *net.IPConn) {
+	oob := make([]byte, 64)
+	for {
+		pb := newPacketBuf(64 * 1024)
+		n, addr, ecn, err := r.read(c, pb.b, oob)
+		if err != nil {
+			pb.release()
+			r.Lock()
+			closed := r.closed
+			r.Unlock()
+			if closed {
+				return
+			}
+			if reports, ok := readICMP(c, err); ok {
+				for _, rep := range reports {
+					r.processICMP(rep)
+				}
+				continue
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			r.Close()
+			return
+		}
+		pb.b, pb.ecn = pb.b[:n], ecn
+		r.process(pb, addr)
+	}
+}
+
+// read receives the next DCCP packet on c into p and returns its length, its source and, if
+// the RawIP carries ECN, its ECN codepoint. oob is the scratch space for control messages.
+func (r *RawIP) read(c *net.IPConn, p, oob []byte) (int, *net.IPAddr, byte, error) {
+	if !r.ecn {
+		n, addr, err := c.ReadFromIP(p)
+		return n, addr, 0, err
+	}
+	n, oobn, _, addr, err := c.ReadMsgIP(p, oob)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if c == r.c4 {
+		// Unlike ReadFromIP, ReadMsgIP leaves the IPv4 header in place
+		n = stripIPv4Header(p[:n])
+	}
+	tos, _ := parseTOS(oob[:oobn])
+	return n, addr, tos & 3, nil
+}
+
+// stripIPv4Header moves the payload of the IPv4 packet p to its start and returns the length
+// of the payload, or zero if p is malformed
+func stripIPv4Header(p []byte) int {
+	if len(p) < 20 {
+		return 0
+	}
+	l := int(p[0]&0x0f) << 2
+	if l < 20 || l > len(p) {
+		return 0
+	}
+	return copy(p, p[l:])
+}
+
+// process passes the packet in pb from the IP address addr to its flow, or starts a new flow if
+// the packet is a Request to the local port
+func (r *RawIP) process(pb *packetBuf, addr *net.IPAddr) {
+	p := pb.b
+	if len(p) < 12 {
+		return
+	}
+	port := int(DecodeUint16(p[2:4]))
+	remote := endpoint{addr.IP, int(DecodeUint16(p[0:2])), addr.Zone}
+	r.Lock()
+	defer r.Unlock()
+	if r.closed {
+		return
+	}
+	f := r.flows[flowKey(port, remote)]
+	if f == nil {
+		if port != r.local.Port || (p[8]>>1)&0x0f != Request {
+			return
+		}
+		f = r.newFlow(port, remote)
+		select {
+		case r.accept <- f:
+		default:
+			return
+		}
+		r.flows[flowKey(port, remote)] = f
+	}
+	f.deliver(pb)
+}
+
+// processICMP passes the ICMP error rep on to the flow that it is about. The start of the
+// DCCP packet in rep holds the ports of the flow.
+func (r *RawIP) processICMP(rep icmpReport) {
+	p := rep.payload
+	if len(p) < 4 {
+		return
+	}
+	rep.dst.Port = int(DecodeUint16(p[2:4]))
+	r.Lock()
+	f := r.flows[flowKey(int(DecodeUint16(p[0:2])), rep.dst)]
+	r.Unlock()
+	if f != nil {
+		f.deliverICMP(rep.err)
+	}
+}
+
+// newFlow creates a flow from the local port to remote. If the socket is bound to a wildcard
+// address, the route to remote decides the local IP address.
+func (r *RawIP) newFlow(port int, remote endpoint) *packetFlow {
+	local := r.local
+	local.Port = port
+	if local.IP == nil || local.IP.IsUnspecified() {
+		local.IP = routeIP(remote, r.device)
+	}
+	return newPacketFlow(r, local, remote)
+}
+
+// forget implements packetSocket.forget
+func (r *RawIP) forget(f *packetFlow) {
+	r.Lock()
+	defer r.Unlock()
+	if key := flowKey(f.local.Port, f.remote); r.flows[key] == f {
+		delete(r.flows, key)
+	}
+}
+
+// send implements packetSocket.send. The packets are not marked with a DSCP.
+func (r *RawIP) send(p []byte, remote endpoint, dscp, ecn byte) error {
+	c := r.conn(remote.IP)
+	if c == nil {
+		return ErrIO
+	}
+	addr := &net.IPAddr{IP: remote.IP, Zone: remote.Zone}
+	var err error
+	if r.ecn && ecn != 0 {
+		oob := make([]byte, 64)
+		_, _, err = c.WriteMsgIP(p, oob[:putTOS(oob, c == r.c4, ecn&3)], addr)
+	} else {
+		_, err = c.WriteToIP(p, addr)
+	}
+	return sendError(err)
+}
+
+// carriesDSCP implements packetSocket.carriesDSCP
+func (r *RawIP) carriesDSCP() bool { return false }
+
+// carriesECN implements packetSocket.carriesECN
+func (r *RawIP) carriesECN() bool { return r.ecn }
+
+// addr implements packetSocket.addr
+func (r *RawIP) addr(x endpoint) net.Addr { return &IPAddr{IP: x.IP, Port: x.Port, Zone: x.Zone} }
+
+// overhead implements packetSocket.overhead
+func (r *RawIP) overhead() int { return 0 }
+
+// encode implements packetSocket.encode. The packet is the RFC 4340 wire format of h, with the
+// ports of the endpoints, and the checksum over the pseudo-header of their IP addresses.
+func (r *RawIP) encode(h *Header, local, remote endpoint, scratch []byte) ([]byte, error) {
+	hh := *h
+	hh.SourcePort, hh.DestPort = uint16(local.Port), uint16(remote.Port)
+	srcIP, dstIP := csumIPs(local.IP, remote.IP)
+	return hh.Append(scratch[:0], srcIP, dstIP, dccpProtoNo, false)
+}
+
+// decode implements packetSocket.decode. The packet moves from the datagram buffer, which
+// fits any IP packet, to one of its own size.
+func (r *RawIP) decode(p []byte, local, remote endpoint) (*Header, error) {
+	srcIP, dstIP := csumIPs(remote.IP, local.IP)
+	pb := newPacketBuf(len(p))
+	copy(pb.b, p)
+	h, err := ReadHeader(pb.b, srcIP, dstIP, dccpProtoNo, false)
+	if err != nil {
+		pb.release()
+		return nil, err
+	}
+	h.buf = pb
+	return h, nil
+}
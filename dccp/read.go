@@ -4,7 +4,7 @@
 
 package dccp
 
-//"fmt"
+import "fmt"
 
 // verifyIPAndProto() checks that both sourceIP# and destIP# are valid for protoNo#
 func verifyIPAndProto(sourceIP, destIP []byte, protoNo byte) error {
@@ -26,6 +26,23 @@ func ReadHeader(buf []byte,
 	if err != nil {
 		return nil, err
 	}
+	return readHeader(buf, sourceIP, destIP, protoNo, allowShortSeqNoFeature, true)
+}
+
+// ParseHeader reads the header in the RFC 4340 wire format buf, which may use short sequence
+// numbers, without verifying its checksum. It serves tools that inspect packets outside of a
+// connection, such as packet captures, where the IP addresses of the checksum may be unknown.
+func ParseHeader(buf []byte) (header *Header, err error) {
+	return readHeader(buf, nil, nil, 0, true, false)
+}
+
+// readHeader is ReadHeader, except that it skips the verification of the checksum, as well as
+// of the IP addresses it depends on, unless verify is set. It serves encapsulations, like
+// DCCP-UDP, whose checksum covers a different header, and which verify it themselves.
+func readHeader(buf []byte,
+	sourceIP, destIP []byte,
+	protoNo byte,
+	allowShortSeqNoFeature bool, verify bool) (header *Header, err error) {
 
 	if len(buf) < 12 {
 		return nil, ErrSize
@@ -60,7 +77,7 @@ func ReadHeader(buf []byte,
 	// Read Type
 	gh.Type = (buf[k] >> 1) & 0x0f
 	if !isTypeUnderstood(gh.Type) {
-		return nil, ErrUnknownType
+		return nil, newPacketError(gh, false, ErrUnknownType)
 	}
 
 	// Read X
@@ -69,25 +86,28 @@ func ReadHeader(buf []byte,
 
 	// Check that X and Type are compatible
 	if !areTypeAndXCompatible(gh.Type, gh.X, allowShortSeqNoFeature) {
-		return nil, ErrSemantic
+		return nil, newPacketError(gh, false, ErrSemantic)
 	}
 
 	// Check Data Offset bounds
 	if dataOffset < getFixedHeaderSize(gh.Type, gh.X) || dataOffset > len(buf) {
-		return nil, ErrNumeric
+		return nil, newPacketError(gh, false, ErrNumeric)
 	}
 
 	// Verify checksum
 	appCov, err := getChecksumAppCoverage(gh.CsCov, len(buf)-dataOffset)
 	if err != nil {
-		return nil, err
+		return nil, newPacketError(gh, false, err)
 	}
-	csum := csumSum(buf[0:dataOffset])
-	csum = csumAdd(csum, csumPseudoIP(sourceIP, destIP, protoNo, len(buf)))
-	csum = csumAdd(csum, csumSum(buf[dataOffset:dataOffset+appCov]))
-	csum = csumDone(csum)
-	if csum != 0 {
-		return nil, ErrChecksum
+	if verify {
+		csum := csumSum(buf[0:dataOffset])
+		csum = csumAdd(csum, csumPseudoIP(sourceIP, destIP, protoNo, len(buf)))
+		csum = csumAdd(csum, csumSum(buf[dataOffset:dataOffset+appCov]))
+		csum = csumDone(csum)
+		if csum != 0 {
+			countChecksumFailure()
+			return nil, newPacketError(gh, false, ErrChecksum)
+		}
 	}
 
 	// Read SeqNo
@@ -99,7 +119,7 @@ func ReadHeader(buf []byte,
 		padding := DecodeUint8(buf[k : k+1])
 		k += 1
 		if padding != 0 {
-			return nil, ErrNumeric
+			return nil, newPacketError(gh, false, ErrNumeric)
 		}
 		gh.SeqNo = int64(DecodeUint48(buf[k : k+6]))
 		k += 6
@@ -113,7 +133,7 @@ func ReadHeader(buf []byte,
 		padding := DecodeUint8(buf[k : k+1])
 		k += 1
 		if padding != 0 {
-			return nil, ErrNumeric
+			return nil, newPacketError(gh, true, ErrNumeric)
 		}
 		gh.AckNo = int64(DecodeUint24(buf[k : k+3]))
 		k += 3
@@ -121,7 +141,7 @@ func ReadHeader(buf []byte,
 		padding := DecodeUint16(buf[k : k+2])
 		k += 2
 		if padding != 0 {
-			return nil, ErrNumeric
+			return nil, newPacketError(gh, true, ErrNumeric)
 		}
 		gh.AckNo = int64(DecodeUint48(buf[k : k+6]))
 		k += 6
@@ -132,7 +152,7 @@ func ReadHeader(buf []byte,
 	// Read (1c) Code Subheader: Service Code, or Reset Code and Reset Data fields
 	switch gh.Type {
 	case Request, Response:
-		gh.ServiceCode = DecodeUint32(buf[k : k+4])
+		gh.ServiceCode = ServiceCode(DecodeUint32(buf[k : k+4]))
 		k += 4
 	case Reset:
 		gh.ResetCode = buf[k]
@@ -143,11 +163,11 @@ func ReadHeader(buf []byte,
 	// Read (2) Options and Padding
 	opts, err := readOptions(buf[k:dataOffset])
 	if err != nil {
-		return nil, err
+		return nil, newPacketError(gh, true, err)
 	}
 	opts, err = sanitizeOptionsAfterReading(gh.Type, opts)
 	if err != nil {
-		return nil, err
+		return nil, newPacketError(gh, true, err)
 	}
 	gh.Options = opts
 
@@ -198,6 +218,7 @@ func readOptions(buf []byte) ([]*Option, error) {
 		j += 1
 
 	}
+	countOptions(j)
 
 	return opts[0:j], nil
 }
@@ -210,7 +231,7 @@ func sanitizeOptionsAfterReading(Type byte, opts []*Option) ([]*Option, error) {
 	for i := 0; i < len(opts); i++ {
 		if !isOptionValidForType(opts[i].Type, Type) {
 			if nextIsMandatory {
-				return nil, ErrOption
+				return nil, fmt.Errorf("mandatory %s option not valid for %s: %w", OptionTypeString(opts[i].Type), TypeString(Type), ErrOption)
 			}
 			nextIsMandatory = false
 			continue
@@ -218,7 +239,7 @@ func sanitizeOptionsAfterReading(Type byte, opts []*Option) ([]*Option, error) {
 		switch opts[i].Type {
 		case OptionMandatory:
 			if nextIsMandatory {
-				return nil, ErrOption
+				return nil, fmt.Errorf("mandatory option after mandatory option: %w", ErrOption)
 			}
 			nextIsMandatory = true
 		case OptionPadding:
@@ -235,7 +256,7 @@ func sanitizeOptionsAfterReading(Type byte, opts []*Option) ([]*Option, error) {
 		}
 	}
 	if nextIsMandatory {
-		return nil, ErrOption
+		return nil, fmt.Errorf("mandatory option at end of options: %w", ErrOption)
 	}
 
 	return r[0:j], nil
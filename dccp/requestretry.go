@@ -0,0 +1,100 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RequestRetry controls how a client in REQUEST state retransmits its Request while no
+// Response arrives, Section 8.1.1. Successive waits double, starting from First and never
+// exceeding Max, and each wait is randomized by up to ±Jitter of its length so that clients
+// started together do not retransmit in lock step. The client gives up after Attempts
+// retransmissions or after Timeout in REQUEST state, whichever comes first, and the
+// connection fails with ErrTimeout. A zero Attempts or Timeout means no such limit, but at
+// least one of them must be set.
+type RequestRetry struct {
+	First    time.Duration // Wait before the first retransmission
+	Max      time.Duration // Largest wait between retransmissions
+	Jitter   float64       // Fraction of each wait, in [0,1), by which it is randomized
+	Attempts int           // Maximum number of retransmissions, or zero for no limit
+	Timeout  time.Duration // Maximum time spent in REQUEST state, or zero for no limit
+}
+
+// DefaultRequestRetry is the RequestRetry configuration that new client connections start with
+var DefaultRequestRetry = RequestRetry{
+	First:    REQUEST_BACKOFF_FIRST,
+	Max:      REQUEST_BACKOFF_MAX,
+	Jitter:   REQUEST_BACKOFF_JITTER,
+	Attempts: 0,
+	Timeout:  REQUEST_BACKOFF_TIMEOUT,
+}
+
+// Valid returns true if r describes a retransmission schedule that eventually gives up
+func (r RequestRetry) Valid() bool {
+	if r.First < BackoffMin || r.Max < r.First {
+		return false
+	}
+	if r.Jitter < 0 || r.Jitter >= 1 {
+		return false
+	}
+	if r.Attempts < 0 || r.Timeout < 0 {
+		return false
+	}
+	return r.Attempts > 0 || r.Timeout > 0
+}
+
+// SetRequestRetry changes the Request retransmission schedule of a client connection. It
+// takes effect from the next retransmission on, so it is best called right after
+// NewConnClient. SetRequestRetry returns ErrInvalid if r is not valid.
+func (c *Conn) SetRequestRetry(r RequestRetry) error {
+	if !r.Valid() {
+		return ErrInvalid
+	}
+	c.do(func() { c.requestRetry = r })
+	return nil
+}
+
+// requestBackOff keeps the state of the Request retransmission schedule
+type requestBackOff struct {
+	attempts int            // Retransmissions so far
+	wait     int64          // Un-jittered length of the next wait, or zero before the first one
+	start    int64          // Time when the connection entered REQUEST state
+	random   func() float64 // Source of the jitter, or nil for that of math/rand
+}
+
+// Next returns the time to wait, starting at time now, for a Response to the last Request sent.
+// If resend is true, the Request is to be retransmitted when the wait is over. Otherwise the
+// schedule r is exhausted and the client should give up once the wait is over.
+func (b *requestBackOff) Next(r RequestRetry, now int64) (wait int64, resend bool) {
+	if b.wait == 0 {
+		b.wait = int64(r.First)
+	} else {
+		b.wait = min64(2*b.wait, int64(r.Max))
+	}
+	wait = b.wait
+	if r.Jitter > 0 {
+		random := b.random
+		if random == nil {
+			random = rand.Float64
+		}
+		wait += int64(float64(wait) * r.Jitter * (2*random() - 1))
+	}
+	wait = max64(BackoffMin, wait)
+	resend = true
+	if r.Attempts > 0 && b.attempts >= r.Attempts {
+		resend = false
+	}
+	if r.Timeout > 0 {
+		if left := b.start + int64(r.Timeout) - now; wait >= left {
+			wait, resend = max64(0, left), false
+		}
+	}
+	if resend {
+		b.attempts++
+	}
+	return wait, resend
+}
@@ -0,0 +1,62 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"testing"
+)
+
+func TestRequestBackOff(t *testing.T) {
+	// Without jitter, waits double up to Max and the last wait is cut at the timeout
+	r := RequestRetry{First: 1e9, Max: 4e9, Timeout: 20e9}
+	b := &requestBackOff{}
+	var now int64
+	expect := []int64{1e9, 2e9, 4e9, 4e9, 4e9, 4e9, 1e9}
+	for i, e := range expect {
+		wait, resend := b.Next(r, now)
+		if wait != e {
+			t.Errorf("wait %d: expecting %d, got %d", i, e, wait)
+		}
+		if last := i == len(expect)-1; resend == last {
+			t.Errorf("wait %d: expecting resend=%v", i, !last)
+		}
+		now += wait
+	}
+
+	// The attempt limit leaves one more wait for a Response to the last retransmission
+	r = RequestRetry{First: 1e9, Max: 1e9, Attempts: 2}
+	b = &requestBackOff{}
+	for i := 0; i < 3; i++ {
+		if _, resend := b.Next(r, 0); resend != (i < 2) {
+			t.Errorf("attempt %d: expecting resend=%v", i, i < 2)
+		}
+	}
+
+	// Jitter stays within bounds
+	r = RequestRetry{First: 1e9, Max: 1e9, Jitter: 0.5, Attempts: 1}
+	for i := 0; i < 100; i++ {
+		b = &requestBackOff{}
+		if wait, _ := b.Next(r, 0); wait < 5e8 || wait > 15e8 {
+			t.Fatalf("jittered wait %d out of bounds", wait)
+		}
+	}
+}
+
+func TestRequestRetryValid(t *testing.T) {
+	if !DefaultRequestRetry.Valid() {
+		t.Errorf("default Request retry is not valid")
+	}
+	invalid := []RequestRetry{
+		{First: 1e9, Max: 8e9},
+		{First: 1e9, Max: 5e8, Timeout: 1e9},
+		{First: 1e9, Max: 8e9, Jitter: 1, Timeout: 1e9},
+		{First: 1e6, Max: 8e9, Timeout: 1e9},
+	}
+	for i, r := range invalid {
+		if r.Valid() {
+			t.Errorf("%d: expecting invalid", i)
+		}
+	}
+}
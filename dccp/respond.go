@@ -0,0 +1,99 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "time"
+
+// Backlog bounds the number of server connections that are half-open, i.e. in RESPOND state,
+// at the same time. A server connection whose Backlog is full answers Requests with a Reset
+// with Reset Code "Too Busy" and stays in LISTEN, Section 8.1.3. A single Backlog is usually
+// shared by all server connections of an application.
+type Backlog struct {
+	Mutex
+	limit int
+	n     int
+}
+
+// NewBacklog creates a Backlog that admits up to limit half-open connections
+func NewBacklog(limit int) *Backlog {
+	if limit <= 0 {
+		panic("backlog limit must be positive")
+	}
+	return &Backlog{limit: limit}
+}
+
+// Len returns the number of currently half-open connections
+func (b *Backlog) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.n
+}
+
+func (b *Backlog) acquire() bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.n >= b.limit {
+		return false
+	}
+	b.n++
+	return true
+}
+
+func (b *Backlog) release() {
+	b.Lock()
+	defer b.Unlock()
+	if b.n <= 0 {
+		panic("backlog underflow")
+	}
+	b.n--
+}
+
+// SetBacklog makes a server connection count against the half-open limit of b. It must be
+// called before the connection receives its first Request.
+func (c *Conn) SetBacklog(b *Backlog) {
+	var halfOpen bool
+	c.do(func() {
+		if halfOpen = c.halfOpen; !halfOpen {
+			c.backlog = b
+		}
+	})
+	if halfOpen {
+		panic("connection already half-open")
+	}
+}
+
+// SetRespondTimeout bounds the time that a server connection waits in RESPOND state, after
+// receiving a Request, for the client to acknowledge its Response. When the timeout expires,
+// the connection is aborted. The default is RESPOND_TIMEOUT.
+func (c *Conn) SetRespondTimeout(d time.Duration) error {
+	if d < EXPIRE_INTERVAL {
+		return ErrInvalid
+	}
+	c.do(func() { c.respondTimeout = int64(d) })
+	return nil
+}
+
+// admitHalfOpen reserves a place in the backlog for a connection entering RESPOND. It returns
+// false if the backlog is full.
+func (c *Conn) admitHalfOpen() bool {
+	if c.backlog == nil {
+		return true
+	}
+	if !c.backlog.acquire() {
+		return false
+	}
+	c.halfOpen = true
+	return true
+}
+
+// leaveHalfOpen frees the place held in the backlog by a connection leaving RESPOND. It
+// MUST be idempotent.
+func (c *Conn) leaveHalfOpen() {
+	if !c.halfOpen {
+		return
+	}
+	c.backlog.release()
+	c.halfOpen = false
+}
@@ -0,0 +1,152 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RotateConfig configures a RotatingTraceWriter. A zero limit is no limit.
+type RotateConfig struct {
+	MaxBytes int64         // Size, in bytes, that a file may reach before it is rotated
+	MaxAge   time.Duration // Span of trace time that a file may cover before it is rotated
+	Keep     int           // Number of rotated files kept, besides the current one; the oldest are removed
+
+	// NewWriter returns the TraceWriter that formats the traces of a new file. Each file starts
+	// afresh, so that it can be read on its own. If nil, NewJSONTraceWriter is used.
+	NewWriter func(io.Writer) TraceWriter
+}
+
+// RotatingTraceWriter is a TraceWriter that saves traces to a file, which it rotates once the
+// file grows over a size or covers a span of time. The current file keeps its name, and rotated
+// files take the same name with a suffix of .1 for the newest, .2 for the one before, and so on,
+// as logrotate does. Services can leave tracing on for postmortems, within a bound on disk.
+type RotatingTraceWriter struct {
+	sync.Mutex
+	filename string
+	config   RotateConfig
+	f        *countingFile
+	w        TraceWriter
+	start    int64 // Time of the first trace in the current file
+	empty    bool  // Whether the current file has no traces yet
+	err      error // First error of the file system
+}
+
+// NewRotatingTraceWriter creates a RotatingTraceWriter that saves traces to filename
+func NewRotatingTraceWriter(filename string, config RotateConfig) (*RotatingTraceWriter, error) {
+	if config.NewWriter == nil {
+		config.NewWriter = func(w io.Writer) TraceWriter { return NewJSONTraceWriter(w) }
+	}
+	t := &RotatingTraceWriter{filename: filename, config: config}
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// countingFile is an *os.File that counts the bytes written to it
+type countingFile struct {
+	*os.File
+	n int64
+}
+
+func (f *countingFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.n += int64(n)
+	return n, err
+}
+
+// open starts a new current file
+func (t *RotatingTraceWriter) open() error {
+	f, err := os.OpenFile(t.filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	t.f = &countingFile{File: f}
+	t.w = t.config.NewWriter(t.f)
+	t.empty = true
+	return nil
+}
+
+// rotatedName returns the name of the i-th newest rotated file
+func (t *RotatingTraceWriter) rotatedName(i int) string {
+	return t.filename + "." + strconv.Itoa(i)
+}
+
+// rotate closes the current file, shifts the rotated files and opens a new current file
+func (t *RotatingTraceWriter) rotate() error {
+	if err := t.w.Close(); err != nil {
+		return err
+	}
+	n := 1
+	for ; ; n++ {
+		if _, err := os.Stat(t.rotatedName(n)); err != nil {
+			break
+		}
+	}
+	// n is the first free index; files from Keep on are removed, the others move up by one
+	for i := n - 1; i >= 1; i-- {
+		if t.config.Keep > 0 && i >= t.config.Keep {
+			if err := os.Remove(t.rotatedName(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Rename(t.rotatedName(i), t.rotatedName(i+1)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(t.filename, t.rotatedName(1)); err != nil {
+		return err
+	}
+	return t.open()
+}
+
+// Write implements TraceWriter.Write. Once the file system fails, traces are dropped and the
+// error is reported by Sync and Close.
+func (t *RotatingTraceWriter) Write(r *Trace) {
+	t.Lock()
+	defer t.Unlock()
+	if t.err != nil {
+		return
+	}
+	if !t.empty && t.config.MaxAge > 0 && time.Duration(r.Time-t.start) >= t.config.MaxAge {
+		if t.err = t.rotate(); t.err != nil {
+			return
+		}
+	}
+	if t.empty {
+		t.start = r.Time
+		t.empty = false
+	}
+	t.w.Write(r)
+	if t.config.MaxBytes > 0 && t.f.n >= t.config.MaxBytes {
+		t.err = t.rotate()
+	}
+}
+
+// Sync implements TraceWriter.Sync
+func (t *RotatingTraceWriter) Sync() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.err == nil {
+		t.err = t.w.Sync()
+	}
+	return t.err
+}
+
+// Close implements TraceWriter.Close
+func (t *RotatingTraceWriter) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	if err := t.w.Close(); t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
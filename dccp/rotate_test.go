@@ -0,0 +1,69 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingTraceWriter(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "conn.trace")
+
+	// Rotated by size, in the binary format
+	w, err := NewRotatingTraceWriter(name, RotateConfig{
+		MaxBytes:  1000,
+		Keep:      2,
+		NewWriter: func(w io.Writer) TraceWriter { return NewBinaryTraceWriter(w) },
+	})
+	if err != nil {
+		t.Fatalf("create (%s)", err)
+	}
+	for i := int64(0); i < 2000; i++ {
+		w.Write(&Trace{Time: i, Labels: []string{"client", "conn"}, Comment: "Write to header link"})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close (%s)", err)
+	}
+	files, _ := filepath.Glob(name + "*")
+	if len(files) != 3 {
+		t.Errorf("expecting the current and 2 rotated files, encountered %v", files)
+	}
+	// Each file reads on its own, and the newest rotated file precedes the current one
+	var last int64 = -1
+	for _, f := range []string{name + ".2", name + ".1", name} {
+		r, err := os.Open(f)
+		if err != nil {
+			t.Fatalf("open (%s)", err)
+		}
+		traces, err := NewTraceReader(r).ReadAll()
+		r.Close()
+		if err != nil || len(traces) == 0 {
+			t.Fatalf("%s: %d traces (%v)", f, len(traces), err)
+		}
+		if traces[0].Time <= last {
+			t.Errorf("%s starts at %d, after %d", f, traces[0].Time, last)
+		}
+		last = traces[len(traces)-1].Time
+	}
+	if last != 1999 {
+		t.Errorf("last trace at %d", last)
+	}
+
+	// Rotated by trace time
+	name = filepath.Join(dir, "age.json")
+	w, _ = NewRotatingTraceWriter(name, RotateConfig{MaxAge: 10 * time.Second})
+	for i := int64(0); i < 35; i++ {
+		w.Write(&Trace{Time: i * 1e9})
+	}
+	w.Close()
+	if files, _ = filepath.Glob(name + "*"); len(files) != 4 {
+		t.Errorf("expecting 4 files of 10 seconds or less, encountered %v", files)
+	}
+}
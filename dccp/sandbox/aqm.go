@@ -0,0 +1,142 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// AQMVerdict is the decision of an AQM on a packet arriving at the queue of a pipe
+type AQMVerdict int
+
+const (
+	AQMPass AQMVerdict = iota // The packet is queued
+	AQMMark                   // The packet is marked CE if it is ECN-capable, and dropped otherwise
+	AQMDrop                   // The packet is dropped
+)
+
+// AQM is an active queue management discipline of the queue of a pipe, see SetWriteAQM
+type AQM interface {
+	// Admit decides, drawing from rnd if need be, the fate of a packet that arrives at the
+	// queue at time arrival and would leave it at time departure, with qlen packets of qbytes
+	// bytes in the queue ahead of it
+	Admit(rnd *rand.Rand, arrival, departure int64, qlen int, qbytes int64) AQMVerdict
+}
+
+// RED is the Random Early Detection of Floyd and Jacobson. It keeps a moving average of the
+// queue length in packets, with weight Weight for each arriving packet. Packets pass while
+// the average is below MinThresh, and are dropped once it reaches MaxThresh. In between, they
+// are marked with a probability that grows linearly up to MaxP, with the marks spread out
+// evenly. The average is not adjusted for the time that the queue is idle. RED keeps the
+// state of the queue, so each direction of a pipe needs one of its own.
+type RED struct {
+	MinThresh float64
+	MaxThresh float64
+	MaxP      float64
+	Weight    float64 // Zero stands for 0.002
+
+	avg   float64
+	count int // Packets since the last mark
+}
+
+// Admit implements AQM.Admit
+func (r *RED) Admit(rnd *rand.Rand, arrival, departure int64, qlen int, qbytes int64) AQMVerdict {
+	w := r.Weight
+	if w == 0 {
+		w = 0.002
+	}
+	r.avg += w * (float64(qlen) - r.avg)
+	switch {
+	case r.avg < r.MinThresh:
+		r.count = 0
+		return AQMPass
+	case r.avg >= r.MaxThresh:
+		r.count = 0
+		return AQMDrop
+	}
+	r.count++
+	pb := r.MaxP * (r.avg - r.MinThresh) / (r.MaxThresh - r.MinThresh)
+	// The probability grows with the packets since the last mark, so marks are spread out
+	pa := 1.0
+	if d := 1 - float64(r.count-1)*pb; d > pb {
+		pa = pb / d
+	}
+	if rnd.Float64() < pa {
+		r.count = 0
+		return AQMMark
+	}
+	return AQMPass
+}
+
+// CoDel is the Controlled Delay of RFC 8289. Once packets have been leaving the queue after
+// more than Target in it for Interval, CoDel marks one, and then marks more and more often, at
+// intervals of Interval divided by the square root of the number of marks so far, until packets
+// leave in less than Target again. Unlike RFC 8289, a packet leaving an almost empty queue is
+// not exempt. CoDel keeps the state of the queue, so each direction of a pipe needs one of its
+// own.
+type CoDel struct {
+	Target   time.Duration // Zero stands for 5 ms
+	Interval time.Duration // Zero stands for 100 ms
+
+	firstAbove int64 // Time when the delay will have been above Target for Interval
+	markNext   int64 // Time of the next mark, while marking
+	count      int   // Marks since marking started
+	lastCount  int   // Value of count when marking last started
+	marking    bool
+}
+
+// Admit implements AQM.Admit. Since the queue of a pipe is first-in first-out, the decision
+// that CoDel makes when a packet leaves can be made when it arrives.
+func (c *CoDel) Admit(rnd *rand.Rand, arrival, departure int64, qlen int, qbytes int64) AQMVerdict {
+	target, interval := int64(c.Target), int64(c.Interval)
+	if target == 0 {
+		target = 5e6
+	}
+	if interval == 0 {
+		interval = 100e6
+	}
+	now := departure
+	var ok bool // True if the delay has been above target for an interval
+	switch {
+	case departure-arrival < target:
+		c.firstAbove = 0
+	case c.firstAbove == 0:
+		c.firstAbove = now + interval
+	case now >= c.firstAbove:
+		ok = true
+	}
+	if c.marking {
+		if !ok {
+			c.marking = false
+			return AQMPass
+		}
+		if now < c.markNext {
+			return AQMPass
+		}
+		c.count++
+		c.markNext = codelControlLaw(c.markNext, interval, c.count)
+		return AQMMark
+	}
+	if !ok {
+		return AQMPass
+	}
+	// Resume at about the previous rate if marking stopped only a short while ago
+	c.marking = true
+	delta := c.count - c.lastCount
+	c.count = 1
+	if delta > 1 && now-c.markNext < 16*interval {
+		c.count = delta
+	}
+	c.markNext = codelControlLaw(now, interval, c.count)
+	c.lastCount = c.count
+	return AQMMark
+}
+
+// codelControlLaw returns the time of the mark that follows the count-th mark, made at time t
+func codelControlLaw(t, interval int64, count int) int64 {
+	return t + int64(float64(interval)/math.Sqrt(float64(count)))
+}
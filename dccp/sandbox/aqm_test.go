@@ -0,0 +1,170 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestRED checks that RED passes packets below its lower threshold, marks a fraction of them
+// between its thresholds, and drops them above its upper threshold
+func TestRED(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	red := &RED{MinThresh: 5, MaxThresh: 15, MaxP: 0.1, Weight: 1}
+	count := func(qlen int) (marked, dropped int) {
+		for i := 0; i < 1000; i++ {
+			switch red.Admit(rnd, 0, 0, qlen, 0) {
+			case AQMMark:
+				marked++
+			case AQMDrop:
+				dropped++
+			}
+		}
+		return marked, dropped
+	}
+	if marked, dropped := count(2); marked != 0 || dropped != 0 {
+		t.Errorf("below thresholds: %d marked, %d dropped", marked, dropped)
+	}
+	// Half way between the thresholds, marks come every 2/MaxP packets on average
+	if marked, dropped := count(10); marked < 70 || marked > 130 || dropped != 0 {
+		t.Errorf("between thresholds: %d marked, %d dropped, expected about 100 marked", marked, dropped)
+	}
+	if marked, dropped := count(20); marked != 0 || dropped != 1000 {
+		t.Errorf("above thresholds: %d marked, %d dropped", marked, dropped)
+	}
+}
+
+// TestCoDel checks that CoDel lets a standing queue last for an interval, then marks at a
+// growing rate, and stops once the delay is back below its target
+func TestCoDel(t *testing.T) {
+	codel := &CoDel{Target: 5 * time.Millisecond, Interval: 100 * time.Millisecond}
+	var marks []int64
+	// Packets leave every millisecond after 10 ms in the queue, for a second
+	for now := int64(0); now < 1e9; now += 1e6 {
+		if codel.Admit(nil, now-10e6, now, 10, 0) == AQMMark {
+			marks = append(marks, now)
+		}
+	}
+	if len(marks) < 3 {
+		t.Fatalf("%d marks", len(marks))
+	}
+	if marks[0] < 100e6 || marks[0] > 102e6 {
+		t.Errorf("first mark at %d ms, expected at 100 ms", marks[0]/1e6)
+	}
+	for i := 2; i < len(marks); i++ {
+		if marks[i]-marks[i-1] > marks[i-1]-marks[i-2] {
+			t.Errorf("marks %d ms apart after %d ms", (marks[i]-marks[i-1])/1e6, (marks[i-1]-marks[i-2])/1e6)
+		}
+	}
+	for now := int64(1e9); now < 2e9; now += 1e6 {
+		if codel.Admit(nil, now-1e6, now, 1, 0) == AQMMark {
+			t.Fatalf("mark at %d ms, below target", now/1e6)
+		}
+	}
+}
+
+// TestAQM checks that an active queue management discipline on the bottleneck of a CCID2
+// connection marks ECN-capable packets rather than dropping them, and keeps the delay of the
+// path well below what the same queue without it would build up, see TestQueue
+func TestAQM(t *testing.T) {
+	tests := []struct {
+		name string
+		aqm  AQM
+	}{
+		{"codel", &CoDel{Target: 5 * time.Millisecond, Interval: 100 * time.Millisecond}},
+		{"red", &RED{MinThresh: 5, MaxThresh: 30, MaxP: 0.1, Weight: 0.05}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := &aqmCounter{}
+			delay := &pipeDelay{written: make(map[int64]int64)}
+			env, _ := NewEnv("aqm-"+test.name, counter, delay)
+			clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+			const latency = 10 * time.Millisecond
+			clientToServer.SetWriteLatency(latency)
+			serverToClient.SetWriteLatency(latency)
+			// 200 packets per second into a queue of 100 packets, or up to 500 ms of delay
+			clientToServer.SetWriteRate(5e6, 1)
+			clientToServer.SetWriteQueue(100, 0)
+			clientToServer.SetWriteAQM(test.aqm)
+
+			const duration = 5 * time.Second
+			env.Go(func() {
+				buf := make([]byte, 100)
+				t0 := env.Now()
+				for env.Now().Sub(t0) < duration {
+					if err := clientConn.WriteSegment(buf); err != nil {
+						break
+					}
+				}
+				clientConn.Close()
+			}, "test client")
+			serverConn.SetReadDeadline(time.Now().Add(duration + 2*time.Second))
+			var received int
+			for {
+				if _, err := serverConn.ReadSegment(); err != nil {
+					break
+				}
+				received++
+			}
+
+			clientConn.Abort()
+			serverConn.Abort()
+			env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+			if err := env.Close(); err != nil {
+				t.Errorf("error closing runtime (%s)", err)
+			}
+			marked, dropped, full := counter.counts()
+			if marked == 0 || dropped > marked/10 || full > 0 {
+				t.Errorf("%d marked, %d dropped by AQM, %d dropped by full queue", marked, dropped, full)
+			}
+			// Without AQM, the delay would grow to 500 ms
+			if max := time.Duration(delay.max()); max > latency+250*time.Millisecond {
+				t.Errorf("maximum delay %d ms, expected well below %d ms", max/time.Millisecond, (latency+500*time.Millisecond)/time.Millisecond)
+			}
+			if received < int(duration/5e6/2) {
+				t.Errorf("received %d segments, expected about %d", received, duration/5e6)
+			}
+		})
+	}
+}
+
+// aqmCounter is a dccp.TraceWriter that counts the packets that the client's side of the pipe
+// marks, drops by AQM and drops for a full queue
+type aqmCounter struct {
+	sync.Mutex
+	marked, dropped, full int
+}
+
+func (x *aqmCounter) Write(r *dccp.Trace) {
+	if len(r.Labels) != 2 || r.Labels[0] != "line" || r.Labels[1] != "client" {
+		return
+	}
+	x.Lock()
+	defer x.Unlock()
+	switch {
+	case r.Event == dccp.EventInfo && r.Comment == "Mark":
+		x.marked++
+	case r.Event == dccp.EventDrop && r.Comment == "AQM":
+		x.dropped++
+	case r.Event == dccp.EventDrop && r.Comment == "Queue full":
+		x.full++
+	}
+}
+
+func (x *aqmCounter) counts() (marked, dropped, full int) {
+	x.Lock()
+	defer x.Unlock()
+	return x.marked, x.dropped, x.full
+}
+
+func (x *aqmCounter) Sync() error  { return nil }
+func (x *aqmCounter) Close() error { return nil }
@@ -0,0 +1,68 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestBacklog checks that a server with a full backlog turns clients away, and that
+// half-open connections are dropped after the RESPOND timeout
+func TestBacklog(t *testing.T) {
+	env, _ := NewEnv("backlog")
+	ccid := ccid3.CCID3{}
+	backlog := dccp.NewBacklog(1)
+
+	// The Responses of server A never reach client A in time, so server A stays half-open
+	llog := dccp.NewAmb("line", env)
+	hca, hsa, _ := NewPipe(env, llog, "clientA", "serverA")
+	hsa.SetWriteLatency(100e9)
+	slogA := dccp.NewAmb("serverA", env)
+	serverA := dccp.NewConnServer(env, slogA, hsa, ccid.NewSender(env, slogA), ccid.NewReceiver(env, slogA))
+	serverA.SetBacklog(backlog)
+	if err := serverA.SetRespondTimeout(5e9); err != nil {
+		t.Fatalf("set respond timeout (%s)", err)
+	}
+	clogA := dccp.NewAmb("clientA", env)
+	clientA := dccp.NewConnClient(env, clogA, hca, ccid.NewSender(env, clogA), ccid.NewReceiver(env, clogA))
+	clientA.SetRequestRetry(dccp.RequestRetry{First: 1e9, Max: 1e9, Timeout: 8e9})
+
+	env.Sleep(1e9)
+	if n := backlog.Len(); n != 1 {
+		t.Errorf("expecting 1 half-open connection, found %d", n)
+	}
+
+	// Server B shares the full backlog, so client B is reset
+	hcb, hsb, _ := NewPipe(env, llog, "clientB", "serverB")
+	slogB := dccp.NewAmb("serverB", env)
+	serverB := dccp.NewConnServer(env, slogB, hsb, ccid.NewSender(env, slogB), ccid.NewReceiver(env, slogB))
+	serverB.SetBacklog(backlog)
+	clogB := dccp.NewAmb("clientB", env)
+	clientB := dccp.NewConnClient(env, clogB, hcb, ccid.NewSender(env, clogB), ccid.NewReceiver(env, clogB))
+	tooBusy := &dccp.ResetError{Code: dccp.ResetTooBusy}
+	if _, err := clientB.ReadSegment(); !errors.Is(err, tooBusy) {
+		t.Errorf("client B: expecting %s, encountered %v", tooBusy, err)
+	}
+
+	env.Sleep(5e9)
+	if n := backlog.Len(); n != 0 {
+		t.Errorf("expecting no half-open connections after RESPOND timeout, found %d", n)
+	}
+	if err := serverA.Error(); !errors.Is(err, dccp.ErrAbort) {
+		t.Errorf("server A: expecting %s, encountered %v", dccp.ErrAbort, err)
+	}
+
+	clientA.Abort()
+	clientB.Abort()
+	serverB.Abort()
+	env.NewGoJoin("end-of-test", clientA.Joiner(), clientB.Joiner(), serverA.Joiner(), serverB.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// Bottleneck is the link that the packets written from one side of a pipe go through: its
+// transmission rate and the queue ahead of it. Each side of a pipe has a bottleneck of its
+// own, unless it shares one with other pipes, as in a Topology. The packets of all the pipes
+// that share a bottleneck are sent one after another at its rate, and wait in its queue.
+type Bottleneck struct {
+	sync.Mutex
+
+	// Time is partitioned into equal units of rateInterval nanoseconds. Within each unit only
+	// ratePacketsPerInterval packets are delivered; the rest are dropped
+	rateInterval           int64
+	ratePacketsPerInterval uint32
+
+	// rateIntervalCounter is the consequtive number of the current rateInterval interval
+	rateIntervalCounter int64
+
+	// rateIntervalFill is the number of packets having been transmitted already during the
+	// rateIntervalCounter-th time interval
+	rateIntervalFill uint32
+
+	// If rateBytesPerInterval is positive, it replaces the packet limit above: the link sends
+	// that many bytes per rateInterval, one packet after another, and rateBusyUntil is the
+	// time when it is done sending the packets written so far
+	rateBytesPerInterval int64
+	rateBusyUntil        int64
+
+	// If queuePackets or queueBytes is positive, the link has a drop-tail queue ahead of the
+	// rate limit, which holds that many packets or bytes; queued are the packets in it, along
+	// with the one being sent. Set by SetQueue.
+	queuePackets int
+	queueBytes   int64
+	queued       []queuedHeader
+
+	// aqm, if not nil, manages the queue, which is then unlimited unless set otherwise. It
+	// draws from rnd, which is seeded from the Env, so that simulations repeat.
+	aqm AQM
+	rnd *rand.Rand
+}
+
+// queuedHeader is a packet of n bytes in the queue of a bottleneck, which is sent at time sent
+type queuedHeader struct {
+	sent int64
+	n    int
+}
+
+// NewBottleneck returns a bottleneck with the default rate of DefaultRatePacketsPerInterval
+// packets for each DefaultRateInterval, and no queue. Its random choices are seeded from env.
+func NewBottleneck(env *dccp.Env) *Bottleneck {
+	b := &Bottleneck{rnd: rand.New(rand.NewSource(env.Int63n(math.MaxInt64)))}
+	b.SetRate(DefaultRateInterval, DefaultRatePacketsPerInterval)
+	return b
+}
+
+// SetRate sets the transmission rate of the link to ratePacketsPerInterval packets for each
+// interval of rateInterval
+func (b *Bottleneck) SetRate(rateInterval time.Duration, ratePacketsPerInterval uint32) {
+	b.Lock()
+	defer b.Unlock()
+	b.rateInterval = int64(rateInterval)
+	b.ratePacketsPerInterval = ratePacketsPerInterval
+	b.rateIntervalCounter = 0
+	b.rateIntervalFill = 0
+	b.rateBytesPerInterval = 0
+	b.queued = nil
+}
+
+// SetRateBytes sets the transmission rate of the link to bytesPerInterval bytes, counting the
+// wire-format footprint of packets, for each interval of rateInterval, in place of
+// the packet rate of SetRate. Packets take their share of an interval, and may end in the
+// next one. Each is delivered once its last byte is sent, and the link queues up to
+// rateInterval worth of bytes behind the packet being sent; packets written while the queue is
+// full are dropped.
+func (b *Bottleneck) SetRateBytes(rateInterval time.Duration, bytesPerInterval int64) {
+	b.Lock()
+	defer b.Unlock()
+	b.rateInterval = int64(rateInterval)
+	b.rateBytesPerInterval = bytesPerInterval
+	b.rateBusyUntil = 0
+	b.queued = nil
+}
+
+// SetQueue places a drop-tail queue of up to packets packets or bytes bytes, whichever is
+// positive, or both, ahead of the rate limit of the link, as in the buffer of a bottleneck
+// router. Packets written faster than the rate wait in the queue, and the time they wait adds
+// to their delivery time. Packets written while the queue is full are dropped. The packet
+// being sent counts towards the queue under SetRateBytes. Zero packets and bytes restore the
+// default: a packet rate drops the packets beyond the limit of each interval, and a byte rate
+// queues up to one interval's worth of bytes.
+func (b *Bottleneck) SetQueue(packets int, bytes int64) {
+	b.Lock()
+	defer b.Unlock()
+	b.queuePackets, b.queueBytes = packets, bytes
+	b.queued = nil
+}
+
+// SetAQM makes aqm decide which packets arriving at the queue of the link are queued, marked
+// Congestion Experienced or dropped, on top of the limits of SetQueue, if any. Marking drops
+// packets that are not ECN-capable. A nil aqm turns it off.
+func (b *Bottleneck) SetAQM(aqm AQM) {
+	b.Lock()
+	defer b.Unlock()
+	b.aqm = aqm
+	b.queued = nil
+}
+
+// filter returns the time when the link is done sending another packet, of n bytes, written
+// at time now, without violating the rate limit and the queue, and whether the packet is to
+// be marked Congestion Experienced, or else the reason for dropping the packet. ect tells if
+// the packet is ECN-capable.
+func (b *Bottleneck) filter(now int64, n int, ect bool) (sent int64, mark bool, drop string) {
+	b.Lock()
+	defer b.Unlock()
+
+	queue := b.queuePackets > 0 || b.queueBytes > 0 || b.aqm != nil
+	var qbytes int64
+	if queue {
+		queued := b.queued[:0]
+		for _, q := range b.queued {
+			if q.sent > now {
+				queued = append(queued, q)
+				qbytes += int64(q.n)
+			}
+		}
+		b.queued = queued
+		if b.queuePackets > 0 && len(b.queued) >= b.queuePackets ||
+			b.queueBytes > 0 && qbytes+int64(n) > b.queueBytes {
+			return 0, false, "Queue full"
+		}
+	}
+
+	// Find when the packet leaves the queue, and when it is sent
+	var departure int64
+	ctr, fill := b.rateIntervalCounter, b.rateIntervalFill
+	if b.rateBytesPerInterval > 0 {
+		departure = max64(now, b.rateBusyUntil)
+		if !queue && departure-now > b.rateInterval {
+			return 0, false, "Fast writer"
+		}
+		sent = departure + int64(n)*b.rateInterval/b.rateBytesPerInterval
+	} else {
+		// With a queue, the interval of the packet may lie ahead of the current one
+		if gctr := now / b.rateInterval; gctr > ctr {
+			ctr, fill = gctr, 0
+		}
+		if fill >= b.ratePacketsPerInterval {
+			if !queue {
+				return 0, false, "Fast writer"
+			}
+			ctr, fill = ctr+1, 0
+		}
+		departure = max64(now, ctr*b.rateInterval)
+		sent = departure
+	}
+
+	if b.aqm != nil {
+		switch verdict := b.aqm.Admit(b.rnd, now, departure, len(b.queued), qbytes); {
+		case verdict == AQMDrop, verdict == AQMMark && !ect:
+			return 0, false, "AQM"
+		case verdict == AQMMark:
+			mark = true
+		}
+	}
+
+	if b.rateBytesPerInterval > 0 {
+		b.rateBusyUntil = sent
+	} else {
+		b.rateIntervalCounter, b.rateIntervalFill = ctr, fill+1
+	}
+	if queue {
+		b.queued = append(b.queued, queuedHeader{sent: sent, n: n})
+	}
+	return sent, mark, ""
+}
@@ -5,34 +5,145 @@
 package sandbox
 
 import (
+	"fmt"
 	"os"
 	"path"
+	"strconv"
+	"time"
+
 	"github.com/petar/GoDCCP/dccp"
 	"github.com/petar/GoDCCP/dccp/ccid3"
 )
 
+// DefaultSeed is the seed of the random choices of the Envs of NewEnv, unless DCCPSEED is set
+const DefaultSeed = 1
+
 // NewEnv creates a dccp.Env for test purposes, whose dccp.TraceWriter writes to a file
 // and duplicates all emits to any number of additional guzzles, which are usually used to check
 // test conditions. The TraceWriterPlex is returned to facilitate adding further guzzles.
+// If the environment variable DCCPPCAP is set, the packets of the connections of the Env are
+// also saved to a pcapng file next to the emit file, for Wireshark.
+//
+// The random choices of the Env, and of the pipes created in it, are seeded from the
+// environment variable DCCPSEED, if it is set, and are DefaultSeed otherwise, so that tests
+// repeat. The seed is logged to the emit file, so that a failing simulation can be run again
+// with the same random choices.
+//
+// If the environment variable DCCPBINTRACE is set, the traces are saved in the compact binary
+// format of dccp.BinaryTraceWriter, to a .trace file in place of the emit file. If DCCPCHROME
+// is set, the traces are also saved to a .chrome.json file in the Chrome trace-event format of
+// dccp.ChromeTraceWriter, for chrome://tracing and Perfetto.
+//
+// If the environment variable DCCPFILTER is set, only the traces that it selects are emitted,
+// see dccp.ParseTraceFilter. Tests whose guzzles check traces that it drops will fail.
 func NewEnv(guzzleFilename string, guzzles ...dccp.TraceWriter) (env *dccp.Env, plex *TraceWriterPlex) {
-	fileTraceWriter := dccp.NewFileTraceWriter(path.Join(os.Getenv("DCCPLOG"), guzzleFilename + ".emit"))
+	return newEnv(dccp.NewEnv, guzzleFilename, guzzles...)
+}
+
+// NewVirtualEnv is like NewEnv, except that the Env runs on virtual time, see
+// dccp.NewVirtualEnv. Simulations that last seconds, or hours, run in as long as it takes to
+// process their packets.
+func NewVirtualEnv(guzzleFilename string, guzzles ...dccp.TraceWriter) (env *dccp.Env, plex *TraceWriterPlex) {
+	return newEnv(dccp.NewVirtualEnv, guzzleFilename, guzzles...)
+}
+
+// newEnv is NewEnv with the Env created by newDCCPEnv
+func newEnv(newDCCPEnv func(dccp.TraceWriter) *dccp.Env, guzzleFilename string, guzzles ...dccp.TraceWriter) (env *dccp.Env, plex *TraceWriterPlex) {
+	var fileTraceWriter dccp.TraceWriter
+	if os.Getenv("DCCPBINTRACE") != "" {
+		f, err := os.Create(path.Join(os.Getenv("DCCPLOG"), guzzleFilename+".trace"))
+		if err != nil {
+			panic(fmt.Sprintf("cannot create trace file (%s)", err))
+		}
+		fileTraceWriter = dccp.NewBinaryTraceWriter(f)
+	} else {
+		fileTraceWriter = dccp.NewFileTraceWriter(path.Join(os.Getenv("DCCPLOG"), guzzleFilename + ".emit"))
+	}
 	plex = NewTraceWriterPlex(append(guzzles, fileTraceWriter)...)
-	return dccp.NewEnv(plex), plex
+	if os.Getenv("DCCPCHROME") != "" {
+		f, err := os.Create(path.Join(os.Getenv("DCCPLOG"), guzzleFilename+".chrome.json"))
+		if err != nil {
+			panic(fmt.Sprintf("cannot create chrome trace file (%s)", err))
+		}
+		plex.Add(dccp.NewChromeTraceWriter(f))
+	}
+	env = newDCCPEnv(plex)
+	env.SetSeed(DefaultSeed)
+	if s := os.Getenv("DCCPSEED"); s != "" {
+		seed, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("invalid DCCPSEED (%s)", err))
+		}
+		env.SetSeed(seed)
+	}
+	if s := os.Getenv("DCCPFILTER"); s != "" {
+		env.SetTraceFilter(dccp.ParseTraceFilter(s))
+	}
+	dccp.NewAmb("env", env).E(dccp.EventInfo, fmt.Sprintf("DCCPSEED=%d", env.Seed()))
+	if os.Getenv("DCCPPCAP") != "" {
+		w, err := dccp.CreatePcapFile(path.Join(os.Getenv("DCCPLOG"), guzzleFilename + ".pcapng"))
+		if err != nil {
+			panic(fmt.Sprintf("cannot create pcap file (%s)", err))
+		}
+		env.SetPcap(w)
+	}
+	return env, plex
 }
 
+// SandboxTimewait is the TIMEWAIT duration of connections created by NewClientServerPipe
+const SandboxTimewait = 2 * time.Second
+
 // NewClientServerPipe creates a sandbox communication pipe and attaches a DCCP client and a DCCP
 // server to its endpoints. In addition to sending all emits to a standard DCCP log file, it sends a
-// copy of all emits to the dup TraceWriter.
+// copy of all emits to the dup TraceWriter. Both endpoints use CCID3.
 func NewClientServerPipe(env *dccp.Env) (clientConn, serverConn *dccp.Conn, clientToServer, serverToClient *headerHalfPipe) {
+	return NewClientServerPipeCCID(env, ccid3.CCID3{})
+}
+
+// NewClientServerPipeCCID is like NewClientServerPipe, except that both endpoints use ccid
+func NewClientServerPipeCCID(env *dccp.Env, ccid dccp.CCID) (clientConn, serverConn *dccp.Conn, clientToServer, serverToClient *headerHalfPipe) {
+	return newClientServerPipe(env, ccid, "client", "server")
+}
+
+// newClientServerPipe is NewClientServerPipeCCID with the endpoints named client and server
+func newClientServerPipe(env *dccp.Env, ccid dccp.CCID, client, server string) (clientConn, serverConn *dccp.Conn, clientToServer, serverToClient *headerHalfPipe) {
+	return newClientServer(env, ccid, client, server, clientServerSetup{})
+}
+
+// clientServerSetup configures the pipe and the connections of newClientServer before the
+// client sends its Request. Any of its fields may be left out.
+type clientServerSetup struct {
+	pipe        func(clientToServer, serverToClient *headerHalfPipe)            // Called before the connections are created
+	server      func(serverConn *dccp.Conn)                                     // Called before the client is created
+	sender      func(dccp.SenderCongestionControl) dccp.SenderCongestionControl // Replaces the sender CCID of the client
+	clientCodes []dccp.ServiceCode                                              // Service codes that the client offers
+	serverCodes []dccp.ServiceCode                                              // Service codes that the server provides
+}
+
+// newClientServer is newClientServerPipe, with the pipe and the connections configured by
+// setup. The server is created first, so that it is set up by the time the Request arrives.
+func newClientServer(env *dccp.Env, ccid dccp.CCID, client, server string, setup clientServerSetup) (clientConn, serverConn *dccp.Conn, clientToServer, serverToClient *headerHalfPipe) {
 	llog := dccp.NewAmb("line", env)
-	hca, hcb, _ := NewPipe(env, llog, "client", "server")
-	ccid := ccid3.CCID3{}
+	hca, hcb, _ := NewPipe(env, llog, client, server)
+	if setup.pipe != nil {
+		setup.pipe(hca, hcb)
+	}
 
-	clog := dccp.NewAmb("client", env)
-	clientConn = dccp.NewConnClient(env, clog, hca, ccid.NewSender(env, clog), ccid.NewReceiver(env, clog), 0)
+	// Tests need not wait out a realistic quiet period after the connection closes
+	slog := dccp.NewAmb(server, env)
+	serverConn = dccp.NewConnServer(env, slog, hcb, ccid.NewSender(env, slog), ccid.NewReceiver(env, slog), setup.serverCodes...)
+	serverConn.SetTimewait(SandboxTimewait)
+	if setup.server != nil {
+		setup.server(serverConn)
+	}
 
-	slog := dccp.NewAmb("server", env)
-	serverConn = dccp.NewConnServer(env, slog, hcb, ccid.NewSender(env, slog), ccid.NewReceiver(env, slog))
+	clog := dccp.NewAmb(client, env)
+	scc := ccid.NewSender(env, clog)
+	if setup.sender != nil {
+		scc = setup.sender(scc)
+	}
+	clientConn = dccp.NewConnClient(env, clog, hca, scc, ccid.NewReceiver(env, clog), setup.clientCodes...)
+	clientConn.SetTimewait(SandboxTimewait)
 
 	return clientConn, serverConn, hca, hcb
 }
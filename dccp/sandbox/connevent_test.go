@@ -0,0 +1,69 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestConnEvent checks that OnEvent reports the state transitions of a server connection, from
+// the handshake to CLOSED, along with the Reset that the client tears the connection down with
+func TestConnEvent(t *testing.T) {
+	env, _ := NewVirtualEnv("connevent")
+	events := make(chan dccp.ConnEvent, 10)
+	clientConn, serverConn, _, _ := newClientServer(env, ccid2.CCID2{}, "client", "server", clientServerSetup{
+		server: func(c *dccp.Conn) { c.OnEvent(func(e dccp.ConnEvent) { events <- e }) },
+	})
+
+	if err := clientConn.WriteSegment([]byte{1}); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+	if err := clientConn.Reset(200, "going away"); err != nil {
+		t.Fatalf("reset (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err == nil {
+		t.Errorf("server read from a reset connection")
+	}
+	// Cut TIMEWAIT short
+	serverConn.Abort()
+
+	expect := []dccp.ConnEvent{
+		{Kind: dccp.ConnStateChange, State: dccp.RESPOND},
+		{Kind: dccp.ConnStateChange, State: dccp.OPEN},
+		{Kind: dccp.ConnReset, State: dccp.OPEN},
+		{Kind: dccp.ConnStateChange, State: dccp.TIMEWAIT},
+		{Kind: dccp.ConnStateChange, State: dccp.CLOSED},
+	}
+	for i, x := range expect {
+		var e dccp.ConnEvent
+		select {
+		case e = <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("missing event %d, %s", i, dccp.StateString(x.State))
+		}
+		if e.Kind != x.Kind || e.State != x.State {
+			t.Errorf("event %d: kind %d in %s, expected kind %d in %s",
+				i, e.Kind, dccp.StateString(e.State), x.Kind, dccp.StateString(x.State))
+		}
+		if e.Kind == dccp.ConnReset && (e.Reset == nil || e.Reset.Code != 200) {
+			t.Errorf("event %d: reset %v, expected code 200", i, e.Reset)
+		}
+		if e.State == dccp.CLOSED && e.Err == nil {
+			t.Errorf("event %d: closed without an error", i)
+		}
+	}
+
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestPipeCorrupt checks that the checksum catches the bits that a pipe flips, except in the
+// application data outside the checksum coverage, which is delivered with one bit flipped
+func TestPipeCorrupt(t *testing.T) {
+	tests := []struct {
+		name      string
+		where     int
+		csCov     byte
+		delivered bool
+	}{
+		{"header", CorruptHeader, dccp.CsCovAllData, false},
+		{"payload", CorruptPayload, dccp.CsCovAllData, false},
+		{"uncovered", CorruptPayload, dccp.CsCovNoData, true},
+		{"any", CorruptHeader | CorruptPayload, dccp.CsCovAllData, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env, _ := NewEnv("corrupt-pipe-" + test.name)
+			llog := dccp.NewAmb("line", env)
+			hca, hcb, _ := NewPipe(env, llog, "client", "server")
+			hca.SetWriteRate(1e9, 10000)
+			hca.SetWriteCorrupt(1, test.where)
+
+			const n = 50
+			data := []byte("0123456789abcdef")
+			headers := make(chan *dccp.Header, n)
+			env.Go(func() {
+				defer close(headers)
+				for {
+					hcb.SetReadExpire(1e9)
+					h, err := hcb.Read()
+					if err != nil {
+						return
+					}
+					headers <- h
+				}
+			}, "test reader")
+			for i := 0; i < n; i++ {
+				h := &dccp.Header{Type: dccp.Data, X: true, SeqNo: int64(i), CsCov: test.csCov}
+				h.Data = append([]byte(nil), data...)
+				hca.Write(h)
+				env.Sleep(1e6)
+			}
+			hca.Close()
+
+			var received int
+			for h := range headers {
+				received++
+				if !test.delivered {
+					t.Errorf("corrupt packet %d delivered", h.SeqNo)
+					continue
+				}
+				if flipped := bitDiff(h.Data, data); flipped != 1 {
+					t.Errorf("packet %d has %d bits flipped, expected 1", h.SeqNo, flipped)
+				}
+			}
+			if test.delivered && received != n {
+				t.Errorf("%d packets of %d delivered", received, n)
+			}
+			hcb.Close()
+			if err := env.Close(); err != nil {
+				t.Errorf("error closing runtime (%s)", err)
+			}
+		})
+	}
+}
+
+// bitDiff returns the number of bits in which a and b differ, which are equally long
+func bitDiff(a, b []byte) int {
+	var n int
+	for i := range a {
+		for d := a[i] ^ b[i]; d != 0; d &= d - 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// TestCorrupt checks that a server that checks Data Checksums drops the data that a pipe
+// corrupts outside the checksum coverage, and passes on only intact data
+func TestCorrupt(t *testing.T) {
+	env, _ := NewEnv("corrupt")
+	clientConn, serverConn, clientToServer, _ := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	clientConn.SetChecksumCoverage(dccp.CsCovNoData)
+	serverConn.SetMinChecksumCoverage(dccp.CsCovNoData)
+	serverConn.SetCheckDataChecksum(true)
+	clientToServer.SetWriteCorrupt(0.3, CorruptPayload)
+
+	const n = 100
+	segment := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 32)
+	}
+	env.Go(func() {
+		// Leave time for the features to be negotiated
+		env.Sleep(1e9)
+		for i := 0; i < n; i++ {
+			if err := clientConn.WriteSegment(segment(i)); err != nil {
+				t.Errorf("client write (%s)", err)
+				return
+			}
+			env.Sleep(10e6)
+		}
+	}, "test client")
+	count := make(map[byte]int)
+	serverConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(count) < n {
+		b, err := serverConn.ReadSegment()
+		if err != nil {
+			break
+		}
+		// A flipped bit leaves the bytes of a segment unequal
+		if len(b) == 0 || !bytes.Equal(b, segment(int(b[0]))) {
+			t.Errorf("corrupt segment delivered: %x", b)
+			continue
+		}
+		count[b[0]]++
+	}
+	if len(count) < n/2 || len(count) == n {
+		t.Errorf("server received %d segments of %d, expected some to be dropped", len(count), n)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
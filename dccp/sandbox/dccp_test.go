@@ -5,6 +5,7 @@
 package sandbox
 
 import (
+	"errors"
 	"testing"
 	"github.com/petar/GoDCCP/dccp"
 )
@@ -26,8 +27,8 @@ func TestOpenClose(t *testing.T) {
 	cchan := make(chan int, 1)
 	env.Go(func() {
 		env.Sleep(2e9)
-		_, err := clientConn.Read()
-		if err != dccp.ErrEOF {
+		_, err := clientConn.ReadSegment()
+		if !errors.Is(err, dccp.ErrEOF) {
 			t.Errorf("client read error (%s), expected EBADF", err)
 		}
 		cchan <- 1
@@ -70,11 +71,11 @@ func TestIdle(t *testing.T) {
 
 	cchan := make(chan int, 1)
 	env.Go(func() {
-		if err := clientConn.Write(payload); err != nil {
+		if err := clientConn.WriteSegment(payload); err != nil {
 			t.Errorf("client write (%s)", err)
 		}
 		env.Sleep(10e9) // Stay idle for 10 sec
-		if err := clientConn.Close(); err != nil && err != dccp.ErrEOF {
+		if err := clientConn.Close(); err != nil && !errors.Is(err, dccp.ErrEOF) {
 			t.Errorf("client close (%s)", err)
 		}
 		cchan <- 1
@@ -83,11 +84,11 @@ func TestIdle(t *testing.T) {
 
 	schan := make(chan int, 1)
 	env.Go(func() {
-		if err := serverConn.Write(payload); err != nil {
+		if err := serverConn.WriteSegment(payload); err != nil {
 			t.Errorf("server write (%s)", err)
 		}
 		env.Sleep(10e9) // Stay idle for 10 sec
-		if err := serverConn.Close(); err != nil && err != dccp.ErrEOF {
+		if err := serverConn.Close(); err != nil && !errors.Is(err, dccp.ErrEOF) {
 			// XXX why not EOF
 			t.Logf("server close (%s)", err)
 		}
@@ -0,0 +1,59 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestPeerProbe checks that probes answered by a live peer keep the connection up, and that a
+// read pending on a connection whose peer stops answering fails with ErrPeerUnreachable once
+// the probes run out
+func TestPeerProbe(t *testing.T) {
+	env, _ := NewVirtualEnv("peer-probe")
+	clientConn, serverConn, _, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+
+	if err := clientConn.SetPeerProbe(dccp.PeerProbe{Silence: 2e9, Probes: 3}); !errors.Is(err, dccp.ErrInvalid) {
+		t.Errorf("probing without an interval accepted")
+	}
+	if err := clientConn.SetPeerProbe(dccp.PeerProbe{Silence: 2e9, Interval: 1e9, Probes: 3}); err != nil {
+		t.Fatalf("set peer probe (%s)", err)
+	}
+	if err := clientConn.WriteSegment([]byte("hello")); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+
+	env.Sleep(10e9) // Idle, with probes answered by the server
+	if err := clientConn.Error(); err != nil {
+		t.Fatalf("client gave up on a live server (%s)", err)
+	}
+
+	serverToClient.SetWriteBlackhole(true)
+	start := env.Now()
+	if _, err := clientConn.ReadSegment(); !errors.Is(err, dccp.ErrPeerUnreachable) {
+		t.Errorf("client read: expecting %s, encountered %v", dccp.ErrPeerUnreachable, err)
+	}
+	// What remains of two seconds of silence, then three probes a second apart and a second for
+	// the last answer
+	if d := env.Now().Sub(start); d < 3e9 || d > 6e9 {
+		t.Errorf("client gave up after %s, expected 3 to 5 sec", d)
+	}
+	if err := clientConn.WriteSegment([]byte("world")); !errors.Is(err, dccp.ErrPeerUnreachable) {
+		t.Errorf("client write: expecting %s, encountered %v", dccp.ErrPeerUnreachable, err)
+	}
+
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
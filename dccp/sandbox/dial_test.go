@@ -0,0 +1,192 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestDialTimeout checks that a client whose Requests go unanswered gives up with ErrTimeout
+func TestDialTimeout(t *testing.T) {
+	env, _ := NewEnv("dialtimeout")
+	llog := dccp.NewAmb("line", env)
+	hca, _, _ := NewPipe(env, llog, "client", "server")
+	ccid := ccid3.CCID3{}
+
+	clog := dccp.NewAmb("client", env)
+	clientConn := dccp.NewConnClient(env, clog, hca, ccid.NewSender(env, clog), ccid.NewReceiver(env, clog))
+	retry := dccp.RequestRetry{First: 1e9, Max: 4e9, Jitter: 0.25, Attempts: 3}
+	if err := clientConn.SetRequestRetry(retry); err != nil {
+		t.Fatalf("set request retry (%s)", err)
+	}
+
+	t0 := env.Now()
+	if _, err := clientConn.ReadSegment(); !errors.Is(err, dccp.ErrTimeout) {
+		t.Errorf("expecting %s, encountered %v", dccp.ErrTimeout, err)
+	}
+	// Waits of about 1, 2, 4 and 4 seconds
+	if d := env.Now().Sub(t0); d < 8e9 || d > 14e9 {
+		t.Errorf("gave up after %s", d)
+	}
+
+	env.NewGoJoin("end-of-test", clientConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestDialListen checks that Dial and Listen establish a connection over UDP on the loopback
+// interface
+func TestDialListen(t *testing.T) {
+	testDialListen(t, "udp4")
+	if _, err := dccp.Dial("sctp", "127.0.0.1:1", 9); err == nil {
+		t.Errorf("dialed an unknown network")
+	}
+}
+
+// TestDialListenEncap checks that Dial and Listen establish a connection with the DCCP-UDP
+// encapsulation, in which the UDP addresses are the addresses of the connection
+func TestDialListenEncap(t *testing.T) {
+	c, s := testDialListen(t, "dccp-udp4")
+	if c == nil || s == nil {
+		return
+	}
+	if c.LocalAddr().String() != s.RemoteAddr().String() || c.RemoteAddr().String() != s.LocalAddr().String() {
+		t.Errorf("client %s--%s, server %s--%s", c.LocalAddr(), c.RemoteAddr(), s.LocalAddr(), s.RemoteAddr())
+	}
+}
+
+// TestDialListenRaw checks that Dial and Listen establish a connection with native DCCP
+// packets over raw IP sockets, if the test may open them
+func TestDialListenRaw(t *testing.T) {
+	r, err := dccp.ListenRawIP("dccp4", nil)
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("no raw sockets (%s)", err)
+	}
+	if err != nil {
+		t.Fatalf("raw socket (%s)", err)
+	}
+	r.Close()
+	c, s := testDialListen(t, "dccp4")
+	if c == nil || s == nil {
+		return
+	}
+	if c.LocalAddr().String() != s.RemoteAddr().String() {
+		t.Errorf("client %s, server sees %s", c.LocalAddr(), s.RemoteAddr())
+	}
+}
+
+// testDialListen connects a client and a server on network and passes data from one to the
+// other. It returns both connections, which are aborted when the test ends.
+func testDialListen(t *testing.T, network string) (c, s *dccp.Conn) {
+	l, err := dccp.Listen(network, "127.0.0.1:0", 9)
+	if err != nil {
+		t.Fatalf("listen (%s)", err)
+	}
+	defer l.Close()
+	accepted := make(chan *dccp.Conn, 1)
+	go func() {
+		c, err := l.AcceptDCCP()
+		if err != nil {
+			t.Errorf("accept (%s)", err)
+		}
+		accepted <- c
+	}()
+
+	c, err = dccp.Dial(network, l.Addr().String(), 9)
+	if err != nil {
+		t.Fatalf("dial (%s)", err)
+	}
+	t.Cleanup(func() { c.Abort() })
+	if c.ServiceCode() != 9 {
+		t.Errorf("expecting service code 9, encountered %d", c.ServiceCode())
+	}
+	s = <-accepted
+	if s == nil {
+		return c, nil
+	}
+	t.Cleanup(func() { s.Abort() })
+	payload := []byte("hello")
+	if _, err := c.Write(payload); err != nil {
+		t.Fatalf("write (%s)", err)
+	}
+	b := make([]byte, 16)
+	n, err := s.Read(b)
+	if err != nil {
+		t.Fatalf("read (%s)", err)
+	}
+	if !bytes.Equal(b[:n], payload) {
+		t.Errorf("expecting %q, encountered %q", payload, b[:n])
+	}
+	return c, s
+}
+
+// TestDialContext checks that a dial to a silent address gives up when its context expires,
+// and that an Accept gives up when its context is cancelled
+func TestDialContext(t *testing.T) {
+	// A bound UDP socket that never answers
+	silent, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("bind (%s)", err)
+	}
+	defer silent.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	t0 := time.Now()
+	if _, err := dccp.DialContext(ctx, "udp4", silent.LocalAddr().String(), 1); err != context.DeadlineExceeded {
+		t.Errorf("expecting %s, encountered %v", context.DeadlineExceeded, err)
+	}
+	if d := time.Since(t0); d > 2*time.Second {
+		t.Errorf("dial gave up after %s", d)
+	}
+
+	l, err := dccp.Listen("udp4", "127.0.0.1:0", 1)
+	if err != nil {
+		t.Fatalf("listen (%s)", err)
+	}
+	defer l.Close()
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.AcceptContext(ctx); err != context.Canceled {
+		t.Errorf("expecting %s, encountered %v", context.Canceled, err)
+	}
+}
+
+// TestDialUnreachable checks that a DCCP-UDP dial to a closed UDP port fails as soon as the
+// ICMP Port Unreachable arrives, rather than after the Request retransmissions
+func TestDialUnreachable(t *testing.T) {
+	// Find a UDP port that nothing listens on
+	closed, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("bind (%s)", err)
+	}
+	addr := closed.LocalAddr().String()
+	closed.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	t0 := time.Now()
+	_, err = dccp.DialContext(ctx, "dccp-udp4", addr, 1)
+	if err == context.DeadlineExceeded && runtime.GOOS != "linux" {
+		t.Skipf("no ICMP errors on %s", runtime.GOOS)
+	}
+	if !errors.Is(err, dccp.ErrUnreachable) {
+		t.Errorf("expecting %s, encountered %v", dccp.ErrUnreachable, err)
+	}
+	if d := time.Since(t0); d > time.Second {
+		t.Errorf("dial gave up after %s", d)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// TestDualStack checks that Listeners with an unspecified address accept connections over
+// both IPv4 and IPv6, with the DCCP-UDP encapsulation and, if the test may open raw sockets,
+// with native DCCP packets
+func TestDualStack(t *testing.T) {
+	probe, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("no IPv6 loopback (%s)", err)
+	}
+	probe.Close()
+
+	testDualStack(t, "dccp-udp")
+	if r, err := dccp.ListenRawIP("dccp", nil); errors.Is(err, os.ErrPermission) {
+		t.Logf("no raw sockets (%s)", err)
+	} else if err != nil {
+		t.Errorf("raw socket (%s)", err)
+	} else {
+		r.Close()
+		testDualStack(t, "dccp")
+	}
+}
+
+func testDualStack(t *testing.T, network string) {
+	l, err := dccp.Listen(network, ":0", 3)
+	if err != nil {
+		t.Fatalf("%s: listen (%s)", network, err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		family := "4"
+		if host == "::1" {
+			family = "6"
+		}
+		c, err := dccp.Dial(network+family, net.JoinHostPort(host, port), 3)
+		if err != nil {
+			t.Errorf("%s%s: dial (%s)", network, family, err)
+			continue
+		}
+		s, err := l.AcceptDCCP()
+		if err != nil {
+			t.Fatalf("%s%s: accept (%s)", network, family, err)
+		}
+		if h, p, _ := net.SplitHostPort(c.RemoteAddr().String()); !net.ParseIP(h).Equal(net.ParseIP(host)) || p != port {
+			t.Errorf("%s%s: remote address %s", network, family, c.RemoteAddr())
+		}
+		if c.LocalAddr().String() != s.RemoteAddr().String() {
+			t.Errorf("%s%s: client %s, server sees %s", network, family, c.LocalAddr(), s.RemoteAddr())
+		}
+		c.Abort()
+		s.Abort()
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestPipeDuplicate checks that a pipe that duplicates packets delivers each copy after the
+// original, at about the requested rate
+func TestPipeDuplicate(t *testing.T) {
+	env, _ := NewEnv("duplicate-pipe")
+	llog := dccp.NewAmb("line", env)
+	hca, hcb, _ := NewPipe(env, llog, "client", "server")
+	hca.SetWriteRate(1e9, 10000)
+	hca.SetWriteDuplicate(0.3, 5e6)
+
+	const n = 200
+	seqNos := make(chan int64, 2*n)
+	env.Go(func() {
+		defer close(seqNos)
+		for {
+			hcb.SetReadExpire(1e9)
+			h, err := hcb.Read()
+			if err != nil {
+				return
+			}
+			seqNos <- h.SeqNo
+		}
+	}, "test reader")
+	for i := 0; i < n; i++ {
+		hca.Write(&dccp.Header{Type: dccp.Data, X: true, SeqNo: int64(i)})
+		env.Sleep(1e6)
+	}
+	hca.Close()
+
+	count := make(map[int64]int)
+	for s := range seqNos {
+		if count[s] == 0 && s > 0 && count[s-1] == 0 {
+			t.Errorf("packet %d before packet %d", s, s-1)
+		}
+		count[s]++
+	}
+	var dups int
+	for i := int64(0); i < n; i++ {
+		switch count[i] {
+		case 1:
+		case 2:
+			dups++
+		default:
+			t.Errorf("packet %d delivered %d times", i, count[i])
+		}
+	}
+	if dups < n*0.2 || dups > n*0.4 {
+		t.Errorf("%d packets of %d duplicated, expected about %d", dups, n, n*3/10)
+	}
+	hcb.Close()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestDuplicate checks that a connection whose packets are duplicated in both directions
+// carries on, with the Ack Vectors of CCID2 in use. Like UDP, DCCP may deliver the data of a
+// duplicate to the application, so duplicates are counted once.
+func TestDuplicate(t *testing.T) {
+	env, _ := NewEnv("duplicate")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	clientToServer.SetWriteDuplicate(0.3, 10e6)
+	serverToClient.SetWriteDuplicate(0.3, 10e6)
+
+	const n = 100
+	env.Go(func() {
+		for i := 0; i < n; i++ {
+			if err := clientConn.WriteSegment([]byte{byte(i)}); err != nil {
+				t.Errorf("client write (%s)", err)
+				return
+			}
+		}
+	}, "test client")
+	count := make(map[byte]int)
+	serverConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(count) < n {
+		b, err := serverConn.ReadSegment()
+		if err != nil {
+			break
+		}
+		count[b[0]]++
+	}
+	if len(count) < n/2 {
+		t.Errorf("server received %d segments of %d", len(count), n)
+	}
+	if err := clientConn.Error(); err != nil {
+		t.Errorf("client connection failed (%s)", err)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
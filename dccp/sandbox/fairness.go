@@ -0,0 +1,126 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// JainIndex returns Jain's fairness index of the throughputs x, (Σx)² / (n Σx²). It is 1 if
+// all throughputs are equal, and 1/n if one flow has all of it. Without throughput, it is 1.
+func JainIndex(x ...float64) float64 {
+	var sum, sumSq float64
+	for _, v := range x {
+		sum += v
+		sumSq += v * v
+	}
+	if sumSq == 0 {
+		return 1
+	}
+	return sum * sum / (float64(len(x)) * sumSq)
+}
+
+// FlowMeter is a dccp.TraceWriter that records when the pipes of the sandbox deliver Data
+// and DataAck packets, by the label of the receiving side of the pipe, such as "server", or
+// "server1" for the second flow of a Topology. It measures the throughput of flows in packets
+// per second, at times since the start of the Env, like those of dccp.Trace.
+type FlowMeter struct {
+	sync.Mutex
+	arrivals map[string][]time.Duration // Delivery times of the packets of each flow, in order
+}
+
+// NewFlowMeter returns a FlowMeter that has seen no packets
+func NewFlowMeter() *FlowMeter {
+	return &FlowMeter{arrivals: make(map[string][]time.Duration)}
+}
+
+// Write implements dccp.TraceWriter.Write
+func (m *FlowMeter) Write(r *dccp.Trace) {
+	if r.Event != dccp.EventRead || len(r.Labels) != 2 || r.Labels[0] != "line" {
+		return
+	}
+	if r.Type != "Data" && r.Type != "DataAck" {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.arrivals[r.Labels[1]] = append(m.arrivals[r.Labels[1]], time.Duration(r.Time))
+}
+
+// Sync implements dccp.TraceWriter.Sync
+func (m *FlowMeter) Sync() error {
+	return nil
+}
+
+// Close implements dccp.TraceWriter.Close
+func (m *FlowMeter) Close() error {
+	return nil
+}
+
+// Throughput returns the average throughput of the flow to receiver between times from and
+// to, in packets per second
+func (m *FlowMeter) Throughput(receiver string, from, to time.Duration) float64 {
+	if to <= from {
+		return 0
+	}
+	m.Lock()
+	defer m.Unlock()
+	return float64(m.count(receiver, from, to)) / (to - from).Seconds()
+}
+
+// count returns the number of packets delivered to receiver at times in [from, to)
+func (m *FlowMeter) count(receiver string, from, to time.Duration) int {
+	a := m.arrivals[receiver]
+	i := sort.Search(len(a), func(i int) bool { return a[i] >= from })
+	j := sort.Search(len(a), func(i int) bool { return a[i] >= to })
+	return j - i
+}
+
+// Window returns the throughput of the flow to receiver over sliding windows of length window,
+// the first of which starts at from, and each of which starts step after the one before, up to
+// the last one that ends by to. It returns nil unless window and step are positive.
+func (m *FlowMeter) Window(receiver string, from, to, window, step time.Duration) []float64 {
+	if window <= 0 || step <= 0 {
+		return nil
+	}
+	var w []float64
+	for t := from; t+window <= to; t += step {
+		w = append(w, m.Throughput(receiver, t, t+window))
+	}
+	return w
+}
+
+// Fairness returns the Jain index of the throughputs of the flows to receivers between times
+// from and to
+func (m *FlowMeter) Fairness(from, to time.Duration, receivers ...string) float64 {
+	x := make([]float64, len(receivers))
+	for i, r := range receivers {
+		x[i] = m.Throughput(r, from, to)
+	}
+	return JainIndex(x...)
+}
+
+// Convergence returns the time when the flows to receivers converge: the end of the first of
+// the sliding windows, as in Window, from which on the Jain index of the throughputs over each
+// window is at least threshold. It returns false if the flows do not converge by time to, or
+// unless window and step are positive.
+func (m *FlowMeter) Convergence(from, to, window, step time.Duration, threshold float64, receivers ...string) (time.Duration, bool) {
+	if window <= 0 || step <= 0 {
+		return -1, false
+	}
+	var converged time.Duration = -1
+	for t := from; t+window <= to; t += step {
+		if m.Fairness(t, t+window, receivers...) < threshold {
+			converged = -1
+		} else if converged < 0 {
+			converged = t + window
+		}
+	}
+	return converged, converged >= 0
+}
@@ -0,0 +1,82 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"math"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+func TestJainIndex(t *testing.T) {
+	tests := []struct {
+		x        []float64
+		expected float64
+	}{
+		{[]float64{10, 10, 10}, 1},
+		{[]float64{10, 0, 0, 0}, 0.25},
+		{[]float64{30, 10}, 0.8},
+		{nil, 1},
+	}
+	for _, test := range tests {
+		if j := JainIndex(test.x...); math.Abs(j-test.expected) > 1e-9 {
+			t.Errorf("JainIndex(%v) = %v, expected %v", test.x, j, test.expected)
+		}
+	}
+}
+
+// TestFlowMeter checks the throughputs and convergence that a FlowMeter computes from the
+// deliveries of two flows, one of which starts at full rate and the other of which ramps up
+func TestFlowMeter(t *testing.T) {
+	m := NewFlowMeter()
+	deliver := func(receiver string, at int64, typ string) {
+		m.Write(&dccp.Trace{Time: at, Labels: []string{"line", receiver}, Event: dccp.EventRead, Type: typ})
+	}
+	// For 10 seconds, flow a gets 100 packets per second, down to 50 in the 5th second, and
+	// flow b gets 10 packets per second more each second, up to 50
+	for s := int64(0); s < 10; s++ {
+		na, nb := int64(100), 10*(s+1)
+		if s >= 4 {
+			na, nb = 50, 50
+		}
+		for i := int64(0); i < na; i++ {
+			deliver("server0", s*1e9+i*1e9/na, "Data")
+		}
+		for i := int64(0); i < nb; i++ {
+			deliver("server1", s*1e9+i*1e9/nb, "DataAck")
+		}
+	}
+	// Acknowledgements, and packets elsewhere, do not count
+	deliver("server0", 5e8, "Ack")
+	m.Write(&dccp.Trace{Time: 5e8, Labels: []string{"server0"}, Event: dccp.EventRead, Type: "Data"})
+
+	if x := m.Throughput("server0", 0, 2e9); x != 100 {
+		t.Errorf("throughput %v, expected 100", x)
+	}
+	if w := m.Window("server1", 0, 4e9, 2e9, 1e9); len(w) != 3 || w[0] != 15 || w[1] != 25 || w[2] != 35 {
+		t.Errorf("windows %v, expected [15 25 35]", w)
+	}
+	if j := m.Fairness(4e9, 10e9, "server0", "server1"); j != 1 {
+		t.Errorf("fairness %v after convergence, expected 1", j)
+	}
+	at, ok := m.Convergence(0, 10e9, 1e9, 1e9, 0.99, "server0", "server1")
+	if !ok || at != 5e9 {
+		t.Errorf("converged at %s (%v), expected at 5s", at, ok)
+	}
+	if _, ok := m.Convergence(0, 4e9, 1e9, 1e9, 0.99, "server0", "server1"); ok {
+		t.Errorf("converged before flows are equal")
+	}
+	// Windows that do not advance, or have no length, are refused rather than looped over
+	if w := m.Window("server1", 0, 4e9, 2e9, 0); w != nil {
+		t.Errorf("windows %v with zero step, expected nil", w)
+	}
+	if w := m.Window("server1", 0, 4e9, 0, 1e9); w != nil {
+		t.Errorf("windows %v with zero length, expected nil", w)
+	}
+	if at, ok := m.Convergence(0, 10e9, 1e9, -1, 0.99, "server0", "server1"); ok || at != -1 {
+		t.Errorf("converged at %s (%v) with negative step", at, ok)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// A pipe can fail in ways that no side is told about. SetWriteBlackhole makes one direction
+// of the pipe lose every packet, while the other still delivers them. Crash makes the endpoint
+// on one side vanish without tearing anything down, leaving the other side half-open: the
+// packets written to it are lost, or answered with a Reset, as by a host that has rebooted.
+
+// SetWriteBlackhole makes the pipe silently drop every packet written from this side, if on
+// is set, until it is called again with on unset
+func (x *headerHalfPipe) SetWriteBlackhole(on bool) {
+	x.faultLk.Lock()
+	defer x.faultLk.Unlock()
+	x.blackhole = on
+	if on {
+		x.amb.E(dccp.EventInfo, "Blackhole on")
+	} else {
+		x.amb.E(dccp.EventInfo, "Blackhole off")
+	}
+}
+
+func (x *headerHalfPipe) isBlackhole() bool {
+	x.faultLk.Lock()
+	defer x.faultLk.Unlock()
+	return x.blackhole
+}
+
+// Crash makes the endpoint on this side vanish, without a Reset or any other packet. The
+// packets that it writes afterwards are dropped, as are the packets for it, and closing this
+// side does not signal EOF to the other. If reset is set, the packets for it are answered with
+// a Reset(NoConnection), Section 8.3.1, as a host that has lost the state of the connection
+// answers them. A crash cannot be undone.
+func (x *headerHalfPipe) Crash(reset bool) {
+	x.faultLk.Lock()
+	defer x.faultLk.Unlock()
+	x.crashed, x.crashReset = true, reset
+	x.amb.E(dccp.EventWarn, "Crash")
+}
+
+func (x *headerHalfPipe) isCrashed() bool {
+	x.faultLk.Lock()
+	defer x.faultLk.Unlock()
+	return x.crashed
+}
+
+// answer writes a Reset(NoConnection) from this crashed side in response to h, if the crash
+// calls for it and h is not a Reset itself. Its sequence and acknowledgement numbers follow
+// Section 8.3.1. It is called under the writeLk of the other side.
+func (x *headerHalfPipe) answer(h *dccp.Header) {
+	x.faultLk.Lock()
+	reset := x.crashReset
+	x.faultLk.Unlock()
+	if !reset || h.Type == dccp.Reset {
+		return
+	}
+	r := &dccp.Header{}
+	r.InitResetHeader(dccp.ResetNoConnection)
+	if h.HasAckNo() {
+		r.SeqNo = h.AckNo + 1
+	}
+	r.AckNo = h.SeqNo
+	r.SourcePort, r.DestPort = h.DestPort, h.SourcePort
+
+	x.writeLk.Lock()
+	defer x.writeLk.Unlock()
+	if x.wire == nil {
+		return
+	}
+	x.amb.E(dccp.EventInfo, "Answer for crashed endpoint", r)
+	x.transmit(r, true)
+}
@@ -0,0 +1,82 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestBlackhole checks that a client whose Requests reach the server, but which never hears
+// the Responses, gives up after its retransmissions, and that the server gives up as well
+func TestBlackhole(t *testing.T) {
+	env, _ := NewVirtualEnv("blackhole")
+	clientConn, serverConn, clientToServer, serverToClient := newClientServer(env, ccid2.CCID2{}, "client", "server", clientServerSetup{
+		pipe:   func(_, serverToClient *headerHalfPipe) { serverToClient.SetWriteBlackhole(true) },
+		server: func(c *dccp.Conn) { c.SetRespondTimeout(5e9) },
+	})
+	if err := clientConn.SetRequestRetry(dccp.RequestRetry{First: 1e9, Max: 1e9, Attempts: 3}); err != nil {
+		t.Fatalf("set request retry (%s)", err)
+	}
+
+	if _, err := clientConn.ReadSegment(); !errors.Is(err, dccp.ErrTimeout) {
+		t.Errorf("client: expecting %s, encountered %v", dccp.ErrTimeout, err)
+	}
+	if _, err := serverConn.ReadSegment(); err == nil {
+		t.Errorf("server: read from a connection that never opened")
+	}
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+
+	// Four Requests and the Reset of the client that gives up
+	ab, ba := clientToServer.Stats(), serverToClient.Stats()
+	if ab.Offered != 5 || ab.Delivered != 5 {
+		t.Errorf("client: offered %d, delivered %d packets, expected 5", ab.Offered, ab.Delivered)
+	}
+	if ba.Offered == 0 || ba.Dropped["Blackhole"] != ba.Offered || ba.Delivered != 0 {
+		t.Errorf("server: offered %d, dropped %v, delivered %d", ba.Offered, ba.Dropped, ba.Delivered)
+	}
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestCrash checks that a client whose server crashes learns of it from the Reset(NoConnection)
+// that answers its next packet, and that nothing the crashed server writes gets through
+func TestCrash(t *testing.T) {
+	env, _ := NewVirtualEnv("crash")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+
+	if err := clientConn.WriteSegment([]byte{1, 2, 3}); err != nil {
+		t.Fatalf("writing (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("reading (%s)", err)
+	}
+	serverToClient.Crash(true)
+	// The server vanishes without a trace: its Reset is lost
+	serverConn.Abort()
+
+	clientConn.WriteSegment([]byte{4, 5, 6})
+	_, err := clientConn.ReadSegment()
+	var re *dccp.ResetError
+	if !errors.As(err, &re) || re.Code != dccp.ResetNoConnection {
+		t.Errorf("client: expecting a no connection reset, encountered %v", err)
+	}
+	if s := serverToClient.Stats(); s.Dropped["Crashed"] == 0 {
+		t.Errorf("server: dropped %v, expected its reset", s.Dropped)
+	}
+	if s := clientToServer.Stats(); s.Dropped["Crashed"] == 0 {
+		t.Errorf("client: dropped %v, expected packets for the crashed server", s.Dropped)
+	}
+
+	clientConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestChangeFeature checks that features set by the application are negotiated with the other
+// side and reported by GetFeature at both ends, and that features that cannot change are refused
+func TestChangeFeature(t *testing.T) {
+	env, _ := NewVirtualEnv("feature")
+	clientConn, serverConn, _, _ := NewClientServerPipeCCID(env, ccid3.CCID3{})
+
+	if err := clientConn.ChangeFeature(dccp.FeatureSequenceWindow, true, 300); err != nil {
+		t.Fatalf("change sequence window (%s)", err)
+	}
+	if err := clientConn.SetAckRatio(3); err != nil {
+		t.Fatalf("set ack ratio (%s)", err)
+	}
+	if err := clientConn.ChangeFeature(dccp.FeatureCCID, true, dccp.CCID2); !errors.Is(err, dccp.ErrUnsupported) {
+		t.Errorf("CCID change: expecting %s, encountered %v", dccp.ErrUnsupported, err)
+	}
+	if err := clientConn.ChangeFeature(dccp.FeatureAllowShortSeqNos, false, 1); !errors.Is(err, dccp.ErrUnsupported) {
+		t.Errorf("short seqnos: expecting %s, encountered %v", dccp.ErrUnsupported, err)
+	}
+	if err := clientConn.ChangeFeature(dccp.FeatureSequenceWindow, true, 1); !errors.Is(err, dccp.ErrInvalid) {
+		t.Errorf("tiny sequence window: expecting %s, encountered %v", dccp.ErrInvalid, err)
+	}
+	if _, err := clientConn.GetFeature(200); !errors.Is(err, dccp.ErrUnsupported) {
+		t.Errorf("unknown feature: expecting %s, encountered %v", dccp.ErrUnsupported, err)
+	}
+
+	if err := clientConn.WriteSegment([]byte("hello")); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+	env.Sleep(2e9) // Leave time for the Confirms
+
+	cf, _ := clientConn.GetFeature(dccp.FeatureSequenceWindow)
+	sf, _ := serverConn.GetFeature(dccp.FeatureSequenceWindow)
+	if cf.Local != 300 || cf.LocalPending || sf.Remote != 300 {
+		t.Errorf("client %v, server %v", cf, sf)
+	}
+	cf, _ = clientConn.GetFeature(dccp.FeatureAckRatio)
+	sf, _ = serverConn.GetFeature(dccp.FeatureAckRatio)
+	if cf.Local != 3 || sf.Remote != 3 {
+		t.Errorf("client %v, server %v", cf, sf)
+	}
+	var dump []string
+	for _, f := range clientConn.Features() {
+		dump = append(dump, f.String())
+	}
+	if s := strings.Join(dump, "\n"); !strings.Contains(s, "Sequence Window: local 300") ||
+		!strings.Contains(s, "CCID: local 3, remote 3") {
+		t.Errorf("client features:\n%s", s)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
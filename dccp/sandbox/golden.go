@@ -0,0 +1,207 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// GoldenTrace is a dccp.TraceWriter that reduces the traces of a sandbox run to the canonical
+// record of its protocol events, which CheckGolden compares to a golden file. It keeps, for
+// each endpoint:
+//
+//	(1) the path of states that the endpoint takes,
+//	(2) the handshake and teardown packets that it writes and reads, in order,
+//	(3) the last value of each feature that it confirms or sees confirmed, and
+//	(4) the kinds of reactions of its CCIDs to loss.
+//
+// The order of packets that depends on timing, like whether an Ack or a DataAck completes the
+// handshake, and numbers like times and sequence numbers, are left out, as are events that
+// repeat, like a retransmitted Request, and feature values that are passed through on the way
+// to the last one, so that the record changes only if the protocol does.
+type GoldenTrace struct {
+	sync.Mutex
+	endpoints []string
+	records   map[string]*goldenRecord
+}
+
+type goldenRecord struct {
+	states   []string
+	packets  []string
+	confirms map[string]string
+	losses   map[string]bool
+}
+
+// NewGoldenTrace creates an empty GoldenTrace
+func NewGoldenTrace() *GoldenTrace {
+	return &GoldenTrace{records: make(map[string]*goldenRecord)}
+}
+
+// goldenPackets are the packet types of the handshake and teardown
+var goldenPackets = map[string]bool{"Request": true, "Response": true, "CloseReq": true, "Close": true, "Reset": true}
+
+// goldenLosses are the comments of the traces of CCIDs reacting to loss. Timeouts are left out,
+// since whether a timer fires before feedback arrives depends on timing.
+var goldenLosses = []string{"Congestion", "Loss event rate up"}
+
+func (g *GoldenTrace) Write(r *dccp.Trace) {
+	if len(r.Labels) == 0 || r.Labels[0] == "line" || r.Labels[0] == "env" {
+		return
+	}
+	g.Lock()
+	defer g.Unlock()
+	x := g.record(r.Labels[0])
+	if r.State != "" && (len(x.states) == 0 || x.states[len(x.states)-1] != r.State) {
+		x.states = append(x.states, r.State)
+	}
+	if r.Type != "" && (r.Event == dccp.EventWrite || r.Event == dccp.EventRead) {
+		if goldenPackets[r.Type] {
+			p := r.Event.String() + " " + r.Type
+			if len(x.packets) == 0 || x.packets[len(x.packets)-1] != p {
+				x.packets = append(x.packets, p)
+			}
+		}
+		if info, ok := r.HeaderInfo(); ok {
+			for _, o := range info.Options {
+				if (o.Type == dccp.OptionConfirmL || o.Type == dccp.OptionConfirmR) && len(o.Data) > 0 {
+					x.confirms[fmt.Sprintf("%s %s(%s", r.Event, o.Name, dccp.FeatureString(o.Data[0]))] = fmt.Sprintf("%x", o.Data[1:])
+				}
+			}
+		}
+		return
+	}
+	if len(r.Labels) > 1 {
+		for _, loss := range goldenLosses {
+			if strings.HasPrefix(r.Comment, loss) {
+				x.losses[strings.Join(r.Labels[1:], "/")+" "+loss] = true
+			}
+		}
+	}
+}
+
+// record returns the record of endpoint, creating it on first use
+func (g *GoldenTrace) record(endpoint string) *goldenRecord {
+	x, ok := g.records[endpoint]
+	if !ok {
+		x = &goldenRecord{confirms: make(map[string]string), losses: make(map[string]bool)}
+		g.records[endpoint] = x
+		g.endpoints = append(g.endpoints, endpoint)
+	}
+	return x
+}
+
+func (g *GoldenTrace) Sync() error  { return nil }
+func (g *GoldenTrace) Close() error { return nil }
+
+// String returns the canonical record, one event per line, under the name of each endpoint
+func (g *GoldenTrace) String() string {
+	g.Lock()
+	defer g.Unlock()
+	endpoints := append([]string(nil), g.endpoints...)
+	sort.Strings(endpoints)
+	var w bytes.Buffer
+	for _, endpoint := range endpoints {
+		x := g.records[endpoint]
+		fmt.Fprintf(&w, "%s\n\tstates %s\n", endpoint, strings.Join(x.states, " "))
+		for _, p := range x.packets {
+			fmt.Fprintf(&w, "\t%s\n", p)
+		}
+		confirms := make([]string, 0, len(x.confirms))
+		for c := range x.confirms {
+			confirms = append(confirms, c)
+		}
+		sort.Strings(confirms)
+		for _, c := range confirms {
+			fmt.Fprintf(&w, "\t%s %s)\n", c, x.confirms[c])
+		}
+		for _, l := range sortedSet(x.losses) {
+			fmt.Fprintf(&w, "\tloss %s\n", l)
+		}
+	}
+	return w.String()
+}
+
+func sortedSet(m map[string]bool) []string {
+	s := make([]string, 0, len(m))
+	for k := range m {
+		s = append(s, k)
+	}
+	sort.Strings(s)
+	return s
+}
+
+// CheckGolden compares the canonical events of g to the golden file testdata/name.golden, and
+// reports the lines that differ as a test error. If the environment variable DCCPGOLDEN is set,
+// it saves the events to the golden file instead, after a change to the protocol that is meant.
+func CheckGolden(t *testing.T, name string, g *GoldenTrace) {
+	filename := filepath.Join("testdata", name+".golden")
+	got := g.String()
+	if os.Getenv("DCCPGOLDEN") != "" {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatalf("cannot create testdata (%s)", err)
+		}
+		if err := os.WriteFile(filename, []byte(got), 0644); err != nil {
+			t.Fatalf("cannot write golden file (%s)", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("cannot read golden file (%s); set DCCPGOLDEN to create it", err)
+	}
+	if d := diffLines(string(want), got); d != "" {
+		t.Errorf("events differ from %s (-golden +run); set DCCPGOLDEN to accept them:\n%s", filename, d)
+	}
+}
+
+// diffLines returns the lines that are removed from a, with a leading '-', and added to it, with
+// a leading '+', to make b, along with the lines that both keep, or "" if a and b are the same
+func diffLines(a, b string) string {
+	if a == b {
+		return ""
+	}
+	x, y := strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var w bytes.Buffer
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			w.WriteString(" " + x[i])
+			i, j = i+1, j+1
+		case j < len(y) && (i == len(x) || lcs[i][j+1] > lcs[i+1][j]):
+			w.WriteString("+" + y[j])
+			j++
+		default:
+			w.WriteString("-" + x[i])
+			i++
+		}
+	}
+	return w.String()
+}
@@ -0,0 +1,57 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestGolden checks the protocol events of a handshake, a transfer and a close against the
+// golden files in testdata, for CCID3 on a clean pipe and for CCID2 on a congested one. Run
+// with DCCPGOLDEN=1 to update the golden files after a change to the protocol.
+func TestGolden(t *testing.T) {
+	runGolden(t, "golden-ccid3", ccid3.CCID3{}, false)
+	runGolden(t, "golden-ccid2-congestion", ccid2.CCID2{}, true)
+}
+
+func runGolden(t *testing.T, name string, ccid dccp.CCID, congested bool) {
+	golden := NewGoldenTrace()
+	env, _ := NewVirtualEnv(name, golden)
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid)
+	clientToServer.SetWriteLatency(10e6)
+	serverToClient.SetWriteLatency(10e6)
+	if congested {
+		// 100 packets per second, with a queue of 5 packets
+		clientToServer.SetWriteRate(10e6, 1)
+		clientToServer.SetWriteQueue(5, 0)
+	}
+
+	t0 := env.Now()
+	env.Go(func() {
+		buf := make([]byte, 100)
+		for env.Now().Sub(t0) < 3e9 {
+			if err := clientConn.WriteSegment(buf); err != nil {
+				break
+			}
+		}
+		clientConn.Close()
+	}, "test client")
+	for {
+		if _, err := serverConn.ReadSegment(); err != nil {
+			break
+		}
+	}
+	serverConn.Close()
+
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+	CheckGolden(t, name, golden)
+}
@@ -0,0 +1,62 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Jitter is the random part of the one-way delay of a pipe, see SetWriteJitter
+type Jitter interface {
+	// Delay draws the jitter of a packet from rnd. It is added to the latency of the pipe,
+	// and may be negative.
+	Delay(rnd *rand.Rand) time.Duration
+}
+
+// UniformJitter draws jitter uniformly from the interval from -Max to Max
+type UniformJitter struct {
+	Max time.Duration
+}
+
+// Delay implements Jitter.Delay
+func (j UniformJitter) Delay(rnd *rand.Rand) time.Duration {
+	if j.Max <= 0 {
+		return 0
+	}
+	return time.Duration(rnd.Int63n(2*int64(j.Max)+1)) - j.Max
+}
+
+// NormalJitter draws jitter from a normal distribution with mean zero and standard deviation
+// StdDev, as netem does
+type NormalJitter struct {
+	StdDev time.Duration
+}
+
+// Delay implements Jitter.Delay
+func (j NormalJitter) Delay(rnd *rand.Rand) time.Duration {
+	return time.Duration(rnd.NormFloat64() * float64(j.StdDev))
+}
+
+// ParetoJitter draws jitter from a Pareto distribution with the given Shape, shifted to start
+// at zero and scaled to a mean of Mean. It makes most packets a little late and a few very
+// late, like queues on a busy path. Shape must be greater than one; the smaller it is, the
+// heavier the tail.
+type ParetoJitter struct {
+	Mean  time.Duration
+	Shape float64
+}
+
+// Delay implements Jitter.Delay
+func (j ParetoJitter) Delay(rnd *rand.Rand) time.Duration {
+	if j.Shape <= 1 || j.Mean <= 0 {
+		return 0
+	}
+	// A Pareto variable of scale xm has mean xm*Shape/(Shape-1), or xm/(Shape-1) once shifted
+	xm := float64(j.Mean) * (j.Shape - 1)
+	u := 1 - rnd.Float64() // In (0, 1]
+	return time.Duration(xm/math.Pow(u, 1/j.Shape) - xm)
+}
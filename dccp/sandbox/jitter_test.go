@@ -0,0 +1,47 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TestJitter checks the mean and spread of the jitter distributions
+func TestJitter(t *testing.T) {
+	tests := []struct {
+		jitter   Jitter
+		mean     float64
+		stdDev   float64 // Or zero if not checked
+		min, max time.Duration
+	}{
+		{UniformJitter{Max: 10 * time.Millisecond}, 0, 10e6 / math.Sqrt(3), -10e6, 10e6},
+		{NormalJitter{StdDev: 5 * time.Millisecond}, 0, 5e6, math.MinInt64, math.MaxInt64},
+		{ParetoJitter{Mean: 2 * time.Millisecond, Shape: 3}, 2e6, 0, 0, math.MaxInt64},
+	}
+	rnd := rand.New(rand.NewSource(1))
+	const n = 100000
+	for _, test := range tests {
+		var sum, sum2 float64
+		for i := 0; i < n; i++ {
+			d := test.jitter.Delay(rnd)
+			if d < test.min || d > test.max {
+				t.Errorf("%T delay %d out of range", test.jitter, d)
+			}
+			sum += float64(d)
+			sum2 += float64(d) * float64(d)
+		}
+		mean := sum / n
+		stdDev := math.Sqrt(sum2/n - mean*mean)
+		if math.Abs(mean-test.mean) > 0.05e6 {
+			t.Errorf("%T mean %0.0f, expected %0.0f", test.jitter, mean, test.mean)
+		}
+		if test.stdDev > 0 && math.Abs(stdDev-test.stdDev) > 0.02*test.stdDev {
+			t.Errorf("%T standard deviation %0.0f, expected %0.0f", test.jitter, stdDev, test.stdDev)
+		}
+	}
+}
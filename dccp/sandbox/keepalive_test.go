@@ -0,0 +1,141 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// keepaliveCounter is a dccp.TraceWriter that counts the Syncs that each side writes
+type keepaliveCounter struct {
+	sync.Mutex
+	syncs map[string]int
+}
+
+func (x *keepaliveCounter) Write(r *dccp.Trace) {
+	if r.Event != dccp.EventWrite || r.Type != "Sync" || len(r.Labels) == 0 {
+		return
+	}
+	x.Lock()
+	defer x.Unlock()
+	x.syncs[r.Labels[0]]++
+}
+
+func (x *keepaliveCounter) Sync() error  { return nil }
+func (x *keepaliveCounter) Close() error { return nil }
+
+func (x *keepaliveCounter) count(side string) int {
+	x.Lock()
+	defer x.Unlock()
+	return x.syncs[side]
+}
+
+// TestKeepalive checks that an idle connection with keepalives on sends a Sync once per
+// keepalive interval, and that keepalives are off by default on pipes
+func TestKeepalive(t *testing.T) {
+	counter := &keepaliveCounter{syncs: make(map[string]int)}
+	env, _ := NewVirtualEnv("keepalive", counter)
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipe(env)
+	// Some latency keeps the round-trip time, and with it the polls of the idle connections
+	// on the virtual clock, from shrinking to microseconds
+	clientToServer.SetWriteLatency(10e6)
+	serverToClient.SetWriteLatency(10e6)
+
+	if err := clientConn.SetKeepalive(-1); !errors.Is(err, dccp.ErrInvalid) {
+		t.Errorf("negative keepalive interval accepted")
+	}
+	if err := clientConn.SetKeepalive(1e9); err != nil {
+		t.Fatalf("set keepalive (%s)", err)
+	}
+	if err := clientConn.WriteSegment([]byte("hello")); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+	if err := serverConn.WriteSegment([]byte("world")); err != nil {
+		t.Fatalf("server write (%s)", err)
+	}
+	if _, err := clientConn.ReadSegment(); err != nil {
+		t.Fatalf("client read (%s)", err)
+	}
+
+	start := counter.count("client")
+	env.Sleep(5e9) // Stay idle for 5 sec
+	if n := counter.count("client") - start; n < 3 || n > 6 {
+		t.Errorf("client sent %d keepalives in 5 sec, expected about 5", n)
+	}
+	if n := counter.count("server"); n != 0 {
+		t.Errorf("server sent %d Syncs without keepalives", n)
+	}
+	if err := clientConn.Error(); err != nil {
+		t.Errorf("idle connection failed (%s)", err)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestIdleTimeout checks that a connection outlives its idle timeout while the other side sends
+// keepalives, and that it is reset once the other side falls silent
+func TestIdleTimeout(t *testing.T) {
+	env, _ := NewEnv("idle-timeout")
+	clientConn, serverConn, _, _ := NewClientServerPipe(env)
+
+	if err := serverConn.SetIdleTimeout(-1); !errors.Is(err, dccp.ErrInvalid) {
+		t.Errorf("negative idle timeout accepted")
+	}
+	if err := serverConn.SetIdleTimeout(3e9); err != nil {
+		t.Fatalf("set idle timeout (%s)", err)
+	}
+	if err := clientConn.SetKeepalive(1e9); err != nil {
+		t.Fatalf("set keepalive (%s)", err)
+	}
+	if err := clientConn.WriteSegment([]byte("hello")); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+
+	env.Sleep(6e9) // Idle, but for the keepalives of the client
+	if err := serverConn.Error(); err != nil {
+		t.Fatalf("server timed out despite keepalives (%s)", err)
+	}
+
+	clientConn.SetKeepalive(0)
+	env.Sleep(5e9) // Silent for longer than the idle timeout
+	if err := serverConn.Error(); !errors.Is(err, dccp.ErrIdleTimeout) {
+		t.Errorf("server: expecting %s, encountered %v", dccp.ErrIdleTimeout, err)
+	}
+	if _, err := serverConn.ReadSegment(); !errors.Is(err, dccp.ErrIdleTimeout) {
+		t.Errorf("server read: expecting %s, encountered %v", dccp.ErrIdleTimeout, err)
+	}
+	if err := serverConn.WriteSegment([]byte("late")); !errors.Is(err, dccp.ErrIdleTimeout) {
+		t.Errorf("server write: expecting %s, encountered %v", dccp.ErrIdleTimeout, err)
+	}
+	var ne net.Error
+	if _, err := serverConn.Read(make([]byte, 10)); errors.As(err, &ne) && (ne.Timeout() || ne.Temporary()) {
+		t.Errorf("server read: idle timeout %v looks like a deadline", err)
+	}
+	if err := clientConn.Error(); !errors.Is(err, &dccp.ResetError{Code: dccp.ResetAborted}) {
+		t.Errorf("client: expecting an Aborted reset, encountered %v", err)
+	}
+
+	clientConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
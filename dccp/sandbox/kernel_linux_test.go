@@ -0,0 +1,372 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build linux && kerneldccp
+
+package sandbox
+
+// Interoperability tests against the DCCP implementation of the Linux kernel, which Linux
+// dropped in version 6.16. They need root, the ip and iptables commands, and the kerneldccp
+// build tag:
+//
+//	go test -tags kerneldccp -run Kernel -v ./dccp/sandbox/
+//
+// GoDCCP and the kernel peer run in network namespaces of their own, joined by a veth pair. The
+// test binary runs itself inside each namespace, once for the GoDCCP side and once for the
+// kernel peer, which TestMain dispatches on. The kernel DCCP stack of the GoDCCP namespace
+// answers every packet for a port without a kernel socket with a Reset; a firewall rule drops
+// those Resets, while letting through the Resets that GoDCCP sends itself.
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	_ "github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+const (
+	kernelSelfNS  = "godccp-self"
+	kernelPeerNS  = "godccp-peer"
+	kernelSelfIP  = "10.65.11.1"
+	kernelPeerIP  = "10.65.11.2"
+	kernelPort    = 5001
+	kernelService = 0x47444343 // "GDCC"
+	kernelPackets = 100
+	kernelRoleEnv = "GODCCP_KERNEL_ROLE"
+	kernelTimeout = 60 * time.Second
+)
+
+// Socket constants of the kernel DCCP implementation, see linux/dccp.h
+const (
+	dccpProtoNo        = 33
+	sockDCCP           = 6
+	solDCCP            = 269
+	dccpSockoptService = 2
+	dccpSockoptTxCCID  = 14
+	dccpSockoptRxCCID  = 15
+)
+
+func TestMain(m *testing.M) {
+	switch role := os.Getenv(kernelRoleEnv); role {
+	case "server", "client":
+		if err := kernelPeer(role); err != nil {
+			fmt.Printf("error %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// TestKernel connects GoDCCP to the kernel in both directions. In each, the client sends
+// kernelPackets packets, one at a time, which the server echoes, and then closes the
+// connection. Both sides use CCID 2, whose senders rely on the Ack Vectors of the receivers.
+func TestKernel(t *testing.T) {
+	if os.Getenv(kernelRoleEnv) == "self" {
+		dccp.DefaultCCID = dccp.CCID2
+		t.Run("KernelServer", testKernelServer)
+		t.Run("KernelClient", testKernelClient)
+		return
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("needs root")
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, sockDCCP, dccpProtoNo)
+	if err != nil {
+		t.Skipf("no DCCP in the kernel (%s)", err)
+	}
+	syscall.Close(fd)
+	for _, cmd := range []string{"ip", "iptables"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("no %s command (%s)", cmd, err)
+		}
+	}
+	setupKernelNetns(t)
+
+	cmd := netnsCommand(kernelSelfNS, "self", "-test.run=^TestKernel$", "-test.v")
+	out, err := cmd.CombinedOutput()
+	t.Logf("GoDCCP side:\n%s", out)
+	if err != nil {
+		t.Errorf("GoDCCP side failed (%s)", err)
+	}
+}
+
+// setupKernelNetns creates the two network namespaces and the veth pair between them, and
+// removes them when the test ends
+func setupKernelNetns(t *testing.T) {
+	ip := func(args ...string) {
+		if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			t.Fatalf("ip %s (%s): %s", strings.Join(args, " "), err, out)
+		}
+	}
+	for _, ns := range []string{kernelSelfNS, kernelPeerNS} {
+		exec.Command("ip", "netns", "del", ns).Run() // Left over from an earlier run
+		ip("netns", "add", ns)
+		ns := ns
+		t.Cleanup(func() { exec.Command("ip", "netns", "del", ns).Run() })
+		ip("-n", ns, "link", "set", "lo", "up")
+	}
+	ip("link", "add", "gdccp0", "type", "veth", "peer", "name", "gdccp1")
+	ip("link", "set", "gdccp0", "netns", kernelSelfNS)
+	ip("link", "set", "gdccp1", "netns", kernelPeerNS)
+	ip("-n", kernelSelfNS, "addr", "add", kernelSelfIP+"/24", "dev", "gdccp0")
+	ip("-n", kernelPeerNS, "addr", "add", kernelPeerIP+"/24", "dev", "gdccp1")
+	ip("-n", kernelSelfNS, "link", "set", "gdccp0", "up")
+	ip("-n", kernelPeerNS, "link", "set", "gdccp1", "up")
+
+	// Resets of the kernel come from a socket without an owner, those of GoDCCP from the raw
+	// socket of the test, which root owns
+	rule := []string{"netns", "exec", kernelSelfNS, "iptables", "-A", "OUTPUT", "-p", "dccp",
+		"--dccp-types", "RESET", "-m", "owner", "!", "--uid-owner", "0", "-j", "DROP"}
+	if out, err := exec.Command("ip", rule...).CombinedOutput(); err != nil {
+		t.Skipf("iptables (%s): %s", err, out)
+	}
+}
+
+// netnsCommand returns the command that runs the test binary, in the given role, inside the
+// network namespace ns
+func netnsCommand(ns, role string, args ...string) *exec.Cmd {
+	cmd := exec.Command("ip", append([]string{"netns", "exec", ns, os.Args[0]}, args...)...)
+	cmd.Env = append(os.Environ(), kernelRoleEnv+"="+role)
+	return cmd
+}
+
+// kernelPeerProc is a kernel peer, running in the peer namespace, and the lines it prints
+type kernelPeerProc struct {
+	cmd   *exec.Cmd
+	lines chan string
+}
+
+func startKernelPeer(t *testing.T, role string) *kernelPeerProc {
+	p := &kernelPeerProc{
+		cmd:   netnsCommand(kernelPeerNS, role),
+		lines: make(chan string, 16),
+	}
+	out, err := p.cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("peer pipe (%s)", err)
+	}
+	p.cmd.Stderr = os.Stderr
+	if err := p.cmd.Start(); err != nil {
+		t.Fatalf("start peer (%s)", err)
+	}
+	t.Cleanup(func() { p.cmd.Process.Kill() })
+	go func() {
+		defer close(p.lines)
+		s := bufio.NewScanner(out)
+		for s.Scan() {
+			p.lines <- s.Text()
+		}
+	}()
+	return p
+}
+
+// expect fails the test unless the next line that the peer prints is want
+func (p *kernelPeerProc) expect(t *testing.T, want string) {
+	select {
+	case line, ok := <-p.lines:
+		if !ok {
+			t.Fatalf("peer exited, expecting %q", want)
+		}
+		if line != want {
+			t.Fatalf("peer says %q, expecting %q", line, want)
+		}
+	case <-time.After(kernelTimeout):
+		t.Fatalf("peer silent, expecting %q", want)
+	}
+}
+
+// wait fails the test unless the peer exits successfully
+func (p *kernelPeerProc) wait(t *testing.T) {
+	done := make(chan error, 1)
+	go func() { done <- p.cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("peer failed (%s)", err)
+		}
+	case <-time.After(kernelTimeout):
+		t.Errorf("peer did not exit")
+	}
+}
+
+// testKernelServer connects a GoDCCP client to a kernel server
+func testKernelServer(t *testing.T) {
+	peer := startKernelPeer(t, "server")
+	peer.expect(t, "ready")
+
+	c, err := dccp.Dial("dccp4", net.JoinHostPort(kernelPeerIP, strconv.Itoa(kernelPort)), kernelService)
+	if err != nil {
+		t.Fatalf("dial (%s)", err)
+	}
+	defer c.Abort()
+	if c.ServiceCode() != kernelService {
+		t.Errorf("service code %d, expecting %d", c.ServiceCode(), kernelService)
+	}
+	for i := 0; i < kernelPackets; i++ {
+		payload := kernelPayload(i)
+		if err := c.WriteSegment(payload); err != nil {
+			t.Fatalf("write %d (%s)", i, err)
+		}
+		b, err := c.ReadSegment()
+		if err != nil {
+			t.Fatalf("read %d (%s)", i, err)
+		}
+		if !bytes.Equal(b, payload) {
+			t.Fatalf("echo %d is %q", i, b)
+		}
+	}
+	peer.expect(t, "ccid 2 2")
+	if err := c.Close(); err != nil {
+		t.Errorf("close (%s)", err)
+	}
+	peer.expect(t, "eof")
+	peer.wait(t)
+}
+
+// testKernelClient connects a kernel client to a GoDCCP server
+func testKernelClient(t *testing.T) {
+	l, err := dccp.Listen("dccp4", net.JoinHostPort(kernelSelfIP, strconv.Itoa(kernelPort)), kernelService)
+	if err != nil {
+		t.Fatalf("listen (%s)", err)
+	}
+	defer l.Close()
+	peer := startKernelPeer(t, "client")
+
+	s, err := l.AcceptDCCP()
+	if err != nil {
+		t.Fatalf("accept (%s)", err)
+	}
+	defer s.Abort()
+	s.SetReadDeadline(time.Now().Add(kernelTimeout))
+	n := 0
+	for {
+		b, err := s.ReadSegment()
+		if errors.Is(err, dccp.ErrEOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read %d (%s)", n, err)
+		}
+		if err := s.WriteSegment(b); err != nil {
+			t.Fatalf("write %d (%s)", n, err)
+		}
+		n++
+	}
+	if n != kernelPackets {
+		t.Errorf("echoed %d packets, expecting %d", n, kernelPackets)
+	}
+	peer.expect(t, "ccid 2 2")
+	peer.expect(t, "done")
+	peer.wait(t)
+}
+
+func kernelPayload(i int) []byte {
+	return []byte(fmt.Sprintf("packet %d", i))
+}
+
+// kernelPeer runs the kernel side of a test, as a server or a client, and prints its progress
+// on stdout
+func kernelPeer(role string) error {
+	fd, err := syscall.Socket(syscall.AF_INET, sockDCCP, dccpProtoNo)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	var sc [4]byte
+	dccp.EncodeUint32(kernelService, sc[:])
+	if err := syscall.SetsockoptString(fd, solDCCP, dccpSockoptService, string(sc[:])); err != nil {
+		return err
+	}
+	switch role {
+	case "server":
+		if err := syscall.Bind(fd, kernelSockaddr(kernelPeerIP)); err != nil {
+			return err
+		}
+		if err := syscall.Listen(fd, 1); err != nil {
+			return err
+		}
+		fmt.Println("ready")
+		nfd, _, err := syscall.Accept(fd)
+		if err != nil {
+			return err
+		}
+		defer syscall.Close(nfd)
+		if err := printCCIDs(nfd); err != nil {
+			return err
+		}
+		buf := make([]byte, 2048)
+		for {
+			n, err := syscall.Read(nfd, buf)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+			if _, err := syscall.Write(nfd, buf[:n]); err != nil {
+				return err
+			}
+		}
+		fmt.Println("eof")
+	case "client":
+		if err := syscall.Connect(fd, kernelSockaddr(kernelSelfIP)); err != nil {
+			return err
+		}
+		if err := printCCIDs(fd); err != nil {
+			return err
+		}
+		buf := make([]byte, 2048)
+		for i := 0; i < kernelPackets; i++ {
+			payload := kernelPayload(i)
+			if _, err := syscall.Write(fd, payload); err != nil {
+				return err
+			}
+			n, err := syscall.Read(fd, buf)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(buf[:n], payload) {
+				return fmt.Errorf("echo %d is %q", i, buf[:n])
+			}
+		}
+		// The close of the deferred call sends the Close, which GoDCCP takes for the end of
+		// the data
+		fmt.Println("done")
+	default:
+		return errors.New("unknown role " + role)
+	}
+	return nil
+}
+
+// printCCIDs prints the CCIDs that the kernel socket fd negotiated for its half-connections
+func printCCIDs(fd int) error {
+	tx, err := syscall.GetsockoptInt(fd, solDCCP, dccpSockoptTxCCID)
+	if err != nil {
+		return err
+	}
+	rx, err := syscall.GetsockoptInt(fd, solDCCP, dccpSockoptRxCCID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ccid %d %d\n", tx, rx)
+	return nil
+}
+
+func kernelSockaddr(ip string) *syscall.SockaddrInet4 {
+	sa := &syscall.SockaddrInet4{Port: kernelPort}
+	copy(sa.Addr[:], net.ParseIP(ip).To4())
+	return sa
+}
@@ -22,10 +22,10 @@ func (x *latencyQueue) Init(env *dccp.Env, amb *dccp.Amb) {
 	x.queue = make([]*pipeHeader, 0)
 }
 
-// Add adds a new item to the queue
+// Add adds a new item to the queue, after the items with the same timestamp
 func (x *latencyQueue) Add(ph *pipeHeader) {
 	x.queue = append(x.queue, ph)
-	sort.Sort(pipeHeaderTimeSort(x.queue))
+	sort.Stable(pipeHeaderTimeSort(x.queue))
 }
 
 // DeleteMin removes the item with lowest timestamp from the queue
@@ -44,7 +44,7 @@ func (x *latencyQueue) TimeToMin() (dur int64, present bool) {
 	if len(x.queue) == 0 {
 		return 0, false
 	}
-	now := x.env.Now()
+	now := x.env.Now().UnixNano()
 	return max64(0, x.queue[0].DeliverTime - now), true
 }
 
@@ -0,0 +1,128 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestListener checks that a Listener completes concurrent handshakes and returns a separate
+// connection for each of them
+func TestListener(t *testing.T) {
+	const n = 3
+	alink, dlink := dccp.NewChanPipe()
+	l := dccp.NewListener(alink, ccid3.CCID3{}, 7)
+	s := dccp.NewStack(dlink, ccid3.CCID3{})
+
+	// Dial n connections at once, so that their handshakes overlap
+	dialed := make(chan *dccp.Conn, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			c, err := s.Dial(nil, 7)
+			if err != nil {
+				t.Errorf("dial #%d (%s)", i, err)
+				dialed <- nil
+				return
+			}
+			if err := c.WriteSegment([]byte{byte(i)}); err != nil {
+				t.Errorf("write #%d (%s)", i, err)
+			}
+			dialed <- c
+		}(i)
+	}
+
+	seen := make(map[byte]bool)
+	for i := 0; i < n; i++ {
+		c, err := l.AcceptDCCP()
+		if err != nil {
+			t.Fatalf("accept #%d (%s)", i, err)
+		}
+		if c.ServiceCode() != 7 {
+			t.Errorf("accepted service code %d", c.ServiceCode())
+		}
+		b, err := c.ReadSegment()
+		if err != nil || len(b) != 1 {
+			t.Fatalf("read #%d %v (%v)", i, b, err)
+		}
+		seen[b[0]] = true
+		defer c.Abort()
+	}
+	if len(seen) != n {
+		t.Errorf("expecting %d distinct connections, accepted %d", n, len(seen))
+	}
+	for i := 0; i < n; i++ {
+		if c := <-dialed; c != nil {
+			defer c.Abort()
+		}
+	}
+
+	if err := l.Close(); err != nil {
+		t.Errorf("close (%s)", err)
+	}
+	if _, err := l.AcceptDCCP(); !errors.Is(err, dccp.ErrBad) {
+		t.Errorf("accept after close: expecting %s, encountered %v", dccp.ErrBad, err)
+	}
+	if err := l.Close(); !errors.Is(err, dccp.ErrBad) {
+		t.Errorf("closed twice")
+	}
+}
+
+// TestListenerFilter checks that a request filter can accept, drop or reject Requests
+func TestListenerFilter(t *testing.T) {
+	alink, dlink := dccp.NewChanPipe()
+	l := dccp.NewListener(alink, ccid3.CCID3{})
+	droppedRequests := make(chan int, 10)
+	l.SetRequestFilter(func(r *dccp.RequestInfo) (dccp.FilterAction, byte) {
+		if r.RemoteAddr == nil {
+			t.Errorf("request without remote address")
+		}
+		switch r.ServiceCode {
+		case 2:
+			return dccp.FilterReject, dccp.ResetConnectionRefused
+		case 3:
+			select {
+			case droppedRequests <- 1:
+			default:
+			}
+			return dccp.FilterDrop, 0
+		}
+		return dccp.FilterAccept, 0
+	})
+	s := dccp.NewStack(dlink, ccid3.CCID3{})
+
+	accepted, _ := s.Dial(nil, 1)
+	rejected, _ := s.Dial(nil, 2)
+	dropped, _ := s.Dial(nil, 3)
+	defer accepted.Abort()
+	defer dropped.Abort()
+
+	if err := accepted.WaitOpen(); err != nil {
+		t.Errorf("accepted: %s", err)
+	}
+	refused := &dccp.ResetError{Code: dccp.ResetConnectionRefused}
+	if err := rejected.WaitOpen(); !errors.Is(err, refused) {
+		t.Errorf("rejected: expecting %s, encountered %v", refused, err)
+	}
+	// The client retransmits a Request that goes unanswered, and the filter sees it again
+	for i := 0; i < 2; i++ {
+		select {
+		case <-droppedRequests:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("dropped: %d requests filtered, expected a retransmission", i)
+		}
+	}
+	if err := dropped.Error(); err != nil {
+		t.Errorf("dropped: encountered %s", err)
+	}
+	if c, err := l.AcceptDCCP(); err != nil || c.ServiceCode() != 1 {
+		t.Errorf("accept: service code %d (%v)", c.ServiceCode(), err)
+	}
+	l.Close()
+}
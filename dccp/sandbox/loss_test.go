@@ -6,6 +6,8 @@ package sandbox
 
 import (
 	"fmt"
+	"math"
+	"sync"
 	"testing"
 	"github.com/petar/GoDCCP/dccp"
 	"github.com/petar/GoDCCP/dccp/ccid3"
@@ -39,8 +41,8 @@ func TestLoss(t *testing.T) {
 	cchan := make(chan int, 1)
 	env.Go(func() {
 		t0 := env.Now()
-		for env.Now() - t0 < lossDuration {
-			err := clientConn.Write(buf)
+		for env.Now().Sub(t0) < lossDuration {
+			err := clientConn.WriteSegment(buf)
 			if err != nil {
 				break
 			}
@@ -52,7 +54,7 @@ func TestLoss(t *testing.T) {
 	schan := make(chan int, 1)
 	env.Go(func() {
 		for {
-			_, err := serverConn.Read()
+			_, err := serverConn.ReadSegment()
 			if err != nil {
 				break
 			}
@@ -74,3 +76,94 @@ func TestLoss(t *testing.T) {
 		t.Errorf("error closing runtime (%s)", err)
 	}
 }
+
+// lossEstimate is a dccp.TraceWriter that keeps the loss event rate estimates of the
+// server's receiver, and counts the packets that the client's side of the pipe delivers and
+// loses
+type lossEstimate struct {
+	sync.Mutex
+	rates           []float64
+	delivered, lost int
+}
+
+func (x *lossEstimate) Write(r *dccp.Trace) {
+	x.Lock()
+	defer x.Unlock()
+	if len(r.Labels) == 2 && r.Labels[0] == "line" && r.Labels[1] == "client" {
+		switch {
+		case r.Event == dccp.EventWrite:
+			x.delivered++
+		case r.Event == dccp.EventDrop && r.Comment == "Lost":
+			x.lost++
+		}
+		return
+	}
+	reading, ok := r.Sample()
+	if !ok || reading.Series != ccid3.LossReceiverEstimateSample || len(r.Labels) == 0 || r.Labels[0] != "server" {
+		return
+	}
+	x.rates = append(x.rates, reading.Value)
+}
+
+// mean returns the mean of the later half of the estimates, once they have settled
+func (x *lossEstimate) mean() float64 {
+	x.Lock()
+	defer x.Unlock()
+	later := x.rates[len(x.rates)/2:]
+	var sum float64
+	for _, r := range later {
+		sum += r
+	}
+	return sum / float64(len(later))
+}
+
+func (x *lossEstimate) Sync() error  { return nil }
+func (x *lossEstimate) Close() error { return nil }
+
+// TestLossBernoulli checks that the receiver estimates the loss event rate of a path that
+// loses packets independently at random. With a round-trip time much shorter than the time
+// between packets, every loss is a loss event of its own.
+func TestLossBernoulli(t *testing.T) {
+	estimate := &lossEstimate{}
+	env, _ := NewEnv("loss-bernoulli", estimate)
+	clientConn, serverConn, clientToServer, _ := NewClientServerPipe(env)
+	clientConn.Amb().Flags().SetUint32("FixRate", lossSendRate)
+	serverConn.Amb().Flags().SetUint32("FixRate", lossSendRate)
+	clientToServer.SetWriteRate(1e9, 1000)
+	clientToServer.SetWriteLoss(BernoulliLoss{P: 0.1})
+
+	cchan := make(chan int, 1)
+	env.Go(func() {
+		buf := []byte{1, 2, 3}
+		t0 := env.Now()
+		for env.Now().Sub(t0) < lossDuration {
+			if err := clientConn.WriteSegment(buf); err != nil {
+				break
+			}
+		}
+		clientConn.Close()
+		close(cchan)
+	}, "test client")
+	env.Go(func() {
+		for {
+			if _, err := serverConn.ReadSegment(); err != nil {
+				break
+			}
+		}
+	}, "test server")
+	_, _ = <-cchan
+
+	estimate.Lock()
+	actual := 100 * float64(estimate.lost) / float64(estimate.lost+estimate.delivered)
+	estimate.Unlock()
+	if rate := estimate.mean(); actual < 5 || actual > 20 || math.Abs(rate-actual) > 0.25*actual {
+		t.Errorf("receiver estimates a loss event rate of %0.2f%%, %0.2f%% of packets were lost", rate, actual)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
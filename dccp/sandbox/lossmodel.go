@@ -0,0 +1,50 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import "math/rand"
+
+// LossModel decides which of the packets written to a pipe are lost, see SetWriteLoss
+type LossModel interface {
+	// Lose draws from rnd whether the next packet is lost
+	Lose(rnd *rand.Rand) bool
+}
+
+// BernoulliLoss loses each packet independently, with probability P
+type BernoulliLoss struct {
+	P float64
+}
+
+// Lose implements LossModel.Lose
+func (l BernoulliLoss) Lose(rnd *rand.Rand) bool {
+	return rnd.Float64() < l.P
+}
+
+// GilbertElliottLoss is the two-state model of bursty loss of Gilbert and Elliott. The path is
+// in either a good or a bad state, and before each packet, it goes from good to bad with
+// probability P, and from bad to good with probability R. Packets are lost with probability
+// LossGood in the good state and LossBad in the bad state. In the long run, the path is bad
+// P/(P+R) of the time, and bad periods last 1/R packets on average. The model keeps the state
+// of the path, so each direction of a pipe needs one of its own.
+type GilbertElliottLoss struct {
+	P, R     float64
+	LossGood float64
+	LossBad  float64
+
+	bad bool
+}
+
+// Lose implements LossModel.Lose
+func (l *GilbertElliottLoss) Lose(rnd *rand.Rand) bool {
+	if l.bad {
+		l.bad = rnd.Float64() >= l.R
+	} else {
+		l.bad = rnd.Float64() < l.P
+	}
+	if l.bad {
+		return rnd.Float64() < l.LossBad
+	}
+	return rnd.Float64() < l.LossGood
+}
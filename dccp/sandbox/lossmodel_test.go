@@ -0,0 +1,45 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestLossModels checks the loss rate and burst length of the loss models
+func TestLossModels(t *testing.T) {
+	tests := []struct {
+		model LossModel
+		rate  float64 // Fraction of packets lost
+		burst float64 // Mean length of runs of lost packets
+	}{
+		{BernoulliLoss{P: 0.1}, 0.1, 1 / 0.9},
+		// Bad one fifth of the time, for four packets on average, and lossy only then
+		{&GilbertElliottLoss{P: 0.0625, R: 0.25, LossBad: 1}, 0.2, 4},
+	}
+	rnd := rand.New(rand.NewSource(1))
+	const n = 200000
+	for _, test := range tests {
+		var lost, bursts int
+		var prev bool
+		for i := 0; i < n; i++ {
+			l := test.model.Lose(rnd)
+			if l {
+				lost++
+				if !prev {
+					bursts++
+				}
+			}
+			prev = l
+		}
+		rate, burst := float64(lost)/n, float64(lost)/float64(bursts)
+		if math.Abs(rate-test.rate) > 0.05*test.rate || math.Abs(burst-test.burst) > 0.05*test.burst {
+			t.Errorf("%T loss rate %0.3f, bursts of %0.2f, expected %0.3f and %0.2f",
+				test.model, rate, burst, test.rate, test.burst)
+		}
+	}
+}
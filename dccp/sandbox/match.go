@@ -0,0 +1,104 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// MatchTrace is a dccp.TraceWriter that keeps the Match traces of a simulation, those emitted
+// with dccp.EventMatch, to check assertions on them once the simulation is over. Each trace
+// is matched against regular expressions in the form "labels: comment", with the labels joined
+// by slashes, as in "client/sender/senderLossTracker: Loss rate inv = 0.01", so that a pattern
+// can pick out an endpoint or a subsystem, or leave them out to match any. A failed assertion is reported as an error of the test.
+type MatchTrace struct {
+	sync.Mutex
+	t       testing.TB
+	matches []string
+}
+
+// NewMatchTrace creates a MatchTrace that reports failed assertions to t
+func NewMatchTrace(t testing.TB) *MatchTrace {
+	return &MatchTrace{t: t}
+}
+
+func (x *MatchTrace) Write(r *dccp.Trace) {
+	if r.Event != dccp.EventMatch {
+		return
+	}
+	x.Lock()
+	defer x.Unlock()
+	x.matches = append(x.matches, strings.Join(r.Labels, "/")+": "+r.Comment)
+}
+
+func (x *MatchTrace) Sync() error  { return nil }
+func (x *MatchTrace) Close() error { return nil }
+
+// find returns the positions, in the order of emission, of the Match traces that expr matches
+func (x *MatchTrace) find(expr string) []int {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		x.t.Fatalf("invalid match pattern %q (%s)", expr, err)
+	}
+	x.Lock()
+	defer x.Unlock()
+	var found []int
+	for i, m := range x.matches {
+		if re.MatchString(m) {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// Count returns the number of Match traces that expr matches
+func (x *MatchTrace) Count(expr string) int {
+	return len(x.find(expr))
+}
+
+// Expect asserts that expr matches at least one Match trace
+func (x *MatchTrace) Expect(expr string) {
+	if len(x.find(expr)) == 0 {
+		x.t.Errorf("no match trace matches %q", expr)
+	}
+}
+
+// ExpectCount asserts that expr matches exactly n Match traces
+func (x *MatchTrace) ExpectCount(expr string, n int) {
+	if k := len(x.find(expr)); k != n {
+		x.t.Errorf("%d match traces match %q, expected %d", k, expr, n)
+	}
+}
+
+// ExpectNone asserts that expr matches no Match trace
+func (x *MatchTrace) ExpectNone(expr string) {
+	found := x.find(expr)
+	if len(found) == 0 {
+		return
+	}
+	x.Lock()
+	first := x.matches[found[0]]
+	x.Unlock()
+	x.t.Errorf("%d match traces match %q, expected none, first is %q", len(found), expr, first)
+}
+
+// ExpectOrder asserts that both before and after match some Match trace, and that the first
+// trace that before matches comes ahead of the first trace that after matches
+func (x *MatchTrace) ExpectOrder(before, after string) {
+	b, a := x.find(before), x.find(after)
+	switch {
+	case len(b) == 0:
+		x.t.Errorf("no match trace matches %q, expected before %q", before, after)
+	case len(a) == 0:
+		x.t.Errorf("no match trace matches %q, expected after %q", after, before)
+	case b[0] > a[0]:
+		x.t.Errorf("first match of %q comes after first match of %q", before, after)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// failRecorder is a testing.TB that records the errors it is given instead of failing
+type failRecorder struct {
+	testing.TB
+	errors []string
+}
+
+func (x *failRecorder) Errorf(format string, args ...interface{}) {
+	x.errors = append(x.errors, fmt.Sprintf(format, args...))
+}
+
+func TestMatchTrace(t *testing.T) {
+	rec := &failRecorder{TB: t}
+	m := NewMatchTrace(rec)
+	m.Write(&dccp.Trace{Labels: []string{"client"}, Event: dccp.EventMatch, Comment: "CCID open"})
+	m.Write(&dccp.Trace{Labels: []string{"server"}, Event: dccp.EventMatch, Comment: "CCID open"})
+	m.Write(&dccp.Trace{Labels: []string{"client"}, Event: dccp.EventInfo, Comment: "CCID open"})
+	m.Write(&dccp.Trace{Labels: []string{"client", "sender"}, Event: dccp.EventMatch, Comment: "Loss rate inv = 0.01"})
+	m.Write(&dccp.Trace{Labels: []string{"client"}, Event: dccp.EventMatch, Comment: "CCID close"})
+
+	if n := m.Count(`CCID open$`); n != 2 {
+		t.Errorf("counted %d opens, expected 2", n)
+	}
+	// Assertions that hold
+	m.Expect(`^client/sender: Loss rate inv = [0-9.]+$`)
+	m.ExpectCount(`^client: CCID open$`, 1)
+	m.ExpectNone(`^server: CCID close$`)
+	m.ExpectOrder(`^client: CCID open$`, `^client: CCID close$`)
+	if len(rec.errors) != 0 {
+		t.Fatalf("assertions that hold failed: %v", rec.errors)
+	}
+	// Assertions that fail
+	m.Expect(`Timeout`)
+	m.ExpectCount(`CCID open`, 3)
+	m.ExpectNone(`^client: CCID`)
+	m.ExpectOrder(`CCID close`, `CCID open`)
+	m.ExpectOrder(`CCID open`, `Reset`)
+	if len(rec.errors) != 5 {
+		t.Errorf("expected 5 failed assertions, got %d: %v", len(rec.errors), rec.errors)
+	}
+}
@@ -43,7 +43,7 @@ func NewMeasure(env *dccp.Env, t *testing.T) *Measure {
 }
 
 func (x *Measure) Write(r *dccp.Trace) {
-	now := x.env.Now()
+	now := x.env.Now().UnixNano()
 	switch r.Event {
 	case dccp.EventWrite:
 		switch r.Labels[0] {
@@ -0,0 +1,393 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestMsgConn checks that a MsgConn keeps message boundaries, refuses messages that do not fit
+// in a packet, and reports an MTU that does not grow
+func TestMsgConn(t *testing.T) {
+	env, _ := NewEnv("msgconn")
+	clientConn, serverConn, _, _ := NewClientServerPipe(env)
+	client, server := dccp.NewMsgConn(clientConn), dccp.NewMsgConn(serverConn)
+
+	mtu := client.MTU()
+	if _, err := client.Write(make([]byte, mtu+1)); !errors.Is(err, dccp.ErrTooBig) {
+		t.Errorf("message beyond MTU: expecting %s, encountered %v", dccp.ErrTooBig, err)
+	}
+	msgs := [][]byte{[]byte("one"), []byte("two"), bytes.Repeat([]byte{3}, mtu)}
+	for _, msg := range msgs {
+		if n, err := client.Write(msg); err != nil || n != len(msg) {
+			t.Fatalf("write %d bytes (%v)", n, err)
+		}
+	}
+	buf := make([]byte, mtu)
+	for _, msg := range msgs[:2] {
+		n, addr, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read (%s)", err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Errorf("read %q, expecting %q", buf[:n], msg)
+		}
+		if addr.String() != server.RemoteAddr().String() {
+			t.Errorf("read from %s, expecting %s", addr, server.RemoteAddr())
+		}
+	}
+	if n, err := server.Read(buf[:10]); !errors.Is(err, dccp.ErrOverflow) || n != 10 {
+		t.Errorf("short read: %d bytes (%v)", n, err)
+	}
+
+	// The MTU follows GetMTU down, but not back up
+	clientConn.SetMTU(mtu - 100)
+	if client.MTU() != mtu-100 {
+		t.Errorf("MTU %d, expecting %d", client.MTU(), mtu-100)
+	}
+	clientConn.SetMTU(0)
+	if client.MTU() != mtu-100 || clientConn.GetMTU() != mtu {
+		t.Errorf("MTU %d, GetMTU %d", client.MTU(), clientConn.GetMTU())
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestReadMsg checks that ReadMsg returns the metadata of the packets that carry messages,
+// including that of a packet that Read has left part of
+func TestReadMsg(t *testing.T) {
+	env, _ := NewVirtualEnv("readmsg")
+	clientConn, serverConn, _, _ := NewClientServerPipeCCID(env, ccid3.CCID3{})
+
+	t0 := env.Now()
+	for i := 0; i < 3; i++ {
+		if err := clientConn.WriteSegment([]byte("message")); err != nil {
+			t.Fatalf("write (%s)", err)
+		}
+	}
+	buf := make([]byte, 100)
+	var last int64
+	for i := 0; i < 2; i++ {
+		n, info, err := serverConn.ReadMsg(buf)
+		if err != nil || string(buf[:n]) != "message" {
+			t.Fatalf("read %q (%v)", buf[:n], err)
+		}
+		if info.Type != dccp.Data && info.Type != dccp.DataAck || info.SeqNo <= last {
+			t.Errorf("packet type %d, SeqNo %d after %d", info.Type, info.SeqNo, last)
+		}
+		if info.ECN != dccp.ECNECT0 || info.CCVal < 0 || info.CCVal > 15 {
+			t.Errorf("ECN %d, CCVal %d", info.ECN, info.CCVal)
+		}
+		if info.Time.Before(t0) || info.Time.After(env.Now()) {
+			t.Errorf("received at %s, read between %s and %s", info.Time, t0, env.Now())
+		}
+		last = info.SeqNo
+	}
+	if n, err := serverConn.Read(buf[:3]); n != 3 || err != nil {
+		t.Fatalf("read %d bytes (%v)", n, err)
+	}
+	n, info, err := serverConn.ReadMsg(buf)
+	if err != nil || string(buf[:n]) != "sage" || info == nil || info.SeqNo <= last {
+		t.Errorf("read rest %q, info %+v (%v)", buf[:n], info, err)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestWriteMsgPriority checks that, when the application writes faster than the CCID allows,
+// the send queue sheds droppable messages and delivers the firm ones of higher priority in order
+func TestWriteMsgPriority(t *testing.T) {
+	env, _ := NewVirtualEnv("writemsg-priority")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	clientToServer.SetWriteLatency(50e6)
+	serverToClient.SetWriteLatency(50e6)
+
+	var firm, droppable []int
+	reader := env.Go(func() {
+		for {
+			b, err := serverConn.ReadSegment()
+			if err != nil {
+				return
+			}
+			if b[0] == 1 {
+				firm = append(firm, int(b[1]))
+			} else {
+				droppable = append(droppable, int(b[1]))
+			}
+		}
+	}, "test reader")
+	const n = 100
+	for i := 0; i < n; i++ {
+		for j := 0; j < 4; j++ {
+			opts := &dccp.MsgOptions{Droppable: true}
+			if j == 0 {
+				opts = &dccp.MsgOptions{Priority: 1}
+			}
+			if _, err := clientConn.WriteMsg([]byte{byte(opts.Priority), byte(i)}, opts); err != nil {
+				t.Fatalf("writing (%s)", err)
+			}
+		}
+	}
+	env.Sleep(5e9)
+	clientConn.Abort()
+	reader.Join()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+
+	for i := 1; i < len(firm); i++ {
+		if firm[i] <= firm[i-1] {
+			t.Errorf("firm message %d received after %d", firm[i], firm[i-1])
+		}
+	}
+	// The pipe drops some packets of the bursts of CCID2, but few of them
+	if len(firm) < n*3/4 || len(droppable) >= len(firm) {
+		t.Errorf("received %d of %d firm and %d of %d droppable messages", len(firm), n, len(droppable), 3*n)
+	}
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestWriteMsgTTL checks that messages that wait for the CCID past their time to live are
+// discarded, so that a real-time stream on a slow path stays fresh
+func TestWriteMsgTTL(t *testing.T) {
+	env, _ := NewVirtualEnv("writemsg-ttl")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	// 1000-byte packets at 50 KB/sec, or 20 ms a packet, with at most 100 ms of queueing
+	clientToServer.SetWriteRateBytes(1e9, 50000)
+	clientToServer.SetWriteQueue(5, 0)
+	clientToServer.SetWriteLatency(20e6)
+	serverToClient.SetWriteLatency(20e6)
+
+	const ttl = 100 * time.Millisecond
+	var delays []time.Duration
+	reader := env.Go(func() {
+		buf := make([]byte, 1000)
+		for {
+			n, info, err := serverConn.ReadMsg(buf)
+			if err != nil {
+				return
+			}
+			if n == len(buf) {
+				delays = append(delays, info.Time.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(buf)))))
+			}
+		}
+	}, "test reader")
+	// A message every 10 ms, twice as fast as the path allows
+	const n = 300
+	for i := 0; i < n; i++ {
+		msg := make([]byte, 1000)
+		binary.BigEndian.PutUint64(msg, uint64(env.Now().UnixNano()))
+		if _, err := clientConn.WriteMsg(msg, &dccp.MsgOptions{TTL: ttl}); err != nil {
+			t.Fatalf("writing (%s)", err)
+		}
+		env.Sleep(10e6)
+	}
+	env.Sleep(1e9)
+	clientConn.Abort()
+	reader.Join()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+
+	if len(delays) < n/4 || len(delays) > n*3/4 {
+		t.Errorf("received %d of %d messages", len(delays), n)
+	}
+	// A message waits at most its time to live to be sent, then up to 120 ms on the path
+	for _, d := range delays {
+		if d > ttl+150*time.Millisecond {
+			t.Errorf("message received after %d ms", d/time.Millisecond)
+			break
+		}
+	}
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestSendPolicy checks that a connection whose send queue fails writes once it is full lets
+// the application know, and sends the writes that succeed
+func TestSendPolicy(t *testing.T) {
+	env, _ := NewVirtualEnv("send-policy")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	clientToServer.SetWriteLatency(50e6)
+	serverToClient.SetWriteLatency(50e6)
+	if err := clientConn.SetSendQueue(-1, 0, dccp.SendBlock); !errors.Is(err, dccp.ErrInvalid) {
+		t.Errorf("negative queue length: %v", err)
+	}
+	if err := clientConn.SetSendQueue(4, 4000, dccp.SendError); err != nil {
+		t.Fatalf("set send queue (%s)", err)
+	}
+
+	var received int
+	reader := env.Go(func() {
+		for {
+			if _, err := serverConn.ReadSegment(); err != nil {
+				return
+			}
+			received++
+		}
+	}, "test reader")
+	var sent, full int
+	for i := 0; i < 500; i++ {
+		switch err := clientConn.WriteSegment(make([]byte, 1000)); err {
+		case nil:
+			sent++
+		case dccp.ErrFull:
+			full++
+			env.Sleep(1e6)
+		default:
+			t.Fatalf("writing (%s)", err)
+		}
+	}
+	env.Sleep(2e9)
+	clientConn.Abort()
+	reader.Join()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+
+	if full == 0 || sent < 8 || received > sent || received < sent*3/4 {
+		t.Errorf("%d writes failed, %d sent, %d received", full, sent, received)
+	}
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestTryWrite checks that an event loop that writes with TryWrite, and waits on Writable
+// when the send queue is full, gets all of its messages sent without ever blocking in a write
+func TestTryWrite(t *testing.T) {
+	env, _ := NewVirtualEnv("trywrite")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	clientToServer.SetWriteLatency(50e6)
+	serverToClient.SetWriteLatency(50e6)
+
+	var received int
+	reader := env.Go(func() {
+		for {
+			if _, err := serverConn.ReadSegment(); err != nil {
+				return
+			}
+			received++
+		}
+	}, "test reader")
+	const n = 200
+	var wouldBlock int
+	for i := 0; i < n; {
+		_, err := clientConn.TryWrite(make([]byte, 100), nil)
+		switch err {
+		case nil:
+			i++
+		case dccp.ErrWouldBlock:
+			wouldBlock++
+			<-clientConn.Writable()
+		default:
+			t.Fatalf("writing (%s)", err)
+		}
+	}
+	env.Sleep(2e9)
+	clientConn.Abort()
+	reader.Join()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+
+	// The pipe drops some packets of the bursts of CCID2, but few of them
+	if wouldBlock == 0 || received < n*3/4 {
+		t.Errorf("%d writes would block, received %d of %d", wouldBlock, received, n)
+	}
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestWriteBuffers checks that a message written in fragments arrives in one piece, and passes
+// the Data Checksum check of the other side
+func TestWriteBuffers(t *testing.T) {
+	env, _ := NewVirtualEnv("writebuffers")
+	clientConn, serverConn, _, _ := NewClientServerPipeCCID(env, ccid3.CCID3{})
+	if err := serverConn.SetCheckDataChecksum(true); err != nil {
+		t.Fatalf("check data checksum (%s)", err)
+	}
+
+	bufs := net.Buffers{[]byte("frag"), nil, []byte("mented "), []byte("message")}
+	if n, err := clientConn.WriteBuffers(bufs, nil); n != 18 || err != nil {
+		t.Fatalf("write %d bytes (%v)", n, err)
+	}
+	if len(bufs) != 4 {
+		t.Errorf("buffers consumed")
+	}
+	big := net.Buffers{make([]byte, clientConn.GetMTU()), []byte{1}}
+	if _, err := clientConn.WriteBuffers(big, nil); !errors.Is(err, dccp.ErrTooBig) {
+		t.Errorf("message beyond MTU: expecting %s, encountered %v", dccp.ErrTooBig, err)
+	}
+	if b, err := serverConn.ReadSegment(); err != nil || string(b) != "fragmented message" {
+		t.Errorf("read %q (%v)", b, err)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestReadBorrow checks that ReadBorrow lends the data of each packet over the flows of a Mux,
+// after whatever Read left of the previous one
+func TestReadBorrow(t *testing.T) {
+	alink, dlink := dccp.NewChanPipe()
+	l := dccp.NewListener(alink, ccid3.CCID3{})
+	defer l.Close()
+	clientConn, err := dccp.NewStack(dlink, ccid3.CCID3{}).Dial(nil, 0)
+	if err != nil {
+		t.Fatalf("dial (%s)", err)
+	}
+	defer clientConn.Abort()
+	for i := 0; i < 3; i++ {
+		if err := clientConn.WriteSegment([]byte("message")); err != nil {
+			t.Fatalf("write (%s)", err)
+		}
+	}
+	serverConn, err := l.AcceptDCCP()
+	if err != nil {
+		t.Fatalf("accept (%s)", err)
+	}
+	defer serverConn.Abort()
+
+	buf := make([]byte, 3)
+	if n, err := serverConn.Read(buf); n != 3 || err != nil {
+		t.Fatalf("read %d bytes (%v)", n, err)
+	}
+	expect := []string{"sage", "message", "message"}
+	for _, e := range expect {
+		b, err := serverConn.ReadBorrow()
+		if err != nil || string(b.Data) != e || b.Info == nil {
+			t.Fatalf("borrowed %v (%v), expecting %q", b, err, e)
+		}
+		b.Release()
+		b.Release()
+		if b.Data != nil {
+			t.Errorf("released data %q", b.Data)
+		}
+	}
+}
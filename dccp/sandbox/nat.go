@@ -0,0 +1,118 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// Each side of a pipe has a DCCP address, which its connection reports through LocalAddr, and
+// an apparent address, which the packets written from it carry as their source. The two are
+// the same, unless the side is behind a NAT, see Rebind and SetNATTimeout. Each side takes
+// the source of the last packet it reads as the address of the other side, as the peer of a
+// connection behind a NAT must, and writes its packets to that address. Packets whose
+// destination is no longer the apparent address of the side that reads them are dropped, as a
+// NAT drops packets for mappings it has recycled.
+
+// Ports of the sides of a pipe
+const (
+	pipeSourcePort = 5001
+	pipeDestPort   = 5002
+)
+
+// initAddr sets the address of this side, which is not behind a NAT, and that of the other
+func (x *headerHalfPipe) initAddr(local, remote *dccp.IPAddr) {
+	x.addrLk.Lock()
+	defer x.addrLk.Unlock()
+	x.local, x.apparent, x.remote = local, local, remote
+	x.natTimeout = 0
+}
+
+// LocalAddr returns the address of this side of the pipe. It implements the addrConn
+// interface of dccp, so that Conn.LocalAddr reports it.
+func (x *headerHalfPipe) LocalAddr() net.Addr {
+	x.addrLk.Lock()
+	defer x.addrLk.Unlock()
+	return x.local
+}
+
+// RemoteAddr returns the address that this side of the pipe writes its packets to: the
+// source of the last packet read from it, or the address of the other side if none
+func (x *headerHalfPipe) RemoteAddr() net.Addr {
+	x.addrLk.Lock()
+	defer x.addrLk.Unlock()
+	return x.remote
+}
+
+// Rebind changes the apparent address of this side to addr, as when the NAT in front of it
+// recycles its mapping. The other side keeps writing to the old address, and its packets are
+// dropped, until it reads a packet written from this side after the change. A nil addr picks
+// the next port of the current apparent address.
+func (x *headerHalfPipe) Rebind(addr *dccp.IPAddr) {
+	x.addrLk.Lock()
+	defer x.addrLk.Unlock()
+	x.rebind(addr)
+}
+
+// rebind is Rebind under addrLk
+func (x *headerHalfPipe) rebind(addr *dccp.IPAddr) {
+	if addr == nil {
+		addr = &dccp.IPAddr{IP: x.apparent.IP, Port: x.apparent.Port + 1}
+	}
+	x.apparent = addr
+	x.amb.E(dccp.EventInfo, fmt.Sprintf("Rebind %s", addr))
+}
+
+// SetNATTimeout puts this side behind a NAT whose mapping expires once idle passes
+// without a packet written from this side. Packets for this side that arrive while the mapping
+// is expired are dropped, and the next packet written from it gets a new mapping, on the next
+// port, see Rebind. Keepalives that are more frequent than idle keep the mapping. Zero idle
+// turns expiry off.
+func (x *headerHalfPipe) SetNATTimeout(idle time.Duration) {
+	x.addrLk.Lock()
+	defer x.addrLk.Unlock()
+	x.natTimeout = int64(idle)
+	x.natLast = x.env.Now().UnixNano()
+}
+
+// natExpired returns true if the NAT mapping of this side has expired at time now
+func (x *headerHalfPipe) natExpired(now int64) bool {
+	return x.natTimeout > 0 && now-x.natLast > x.natTimeout
+}
+
+// natOutbound returns the source and the destination of a packet written from this side at
+// time now, renewing the NAT mapping of this side
+func (x *headerHalfPipe) natOutbound(now int64) (source, dest *dccp.IPAddr) {
+	x.addrLk.Lock()
+	defer x.addrLk.Unlock()
+	if x.natExpired(now) {
+		x.rebind(nil)
+	}
+	x.natLast = now
+	return x.apparent, x.remote
+}
+
+// natInbound returns true if a packet from source to dest, read from this side at time now,
+// gets through the NAT of this side. If so, source becomes the address of the other side.
+func (x *headerHalfPipe) natInbound(now int64, source, dest *dccp.IPAddr) bool {
+	x.addrLk.Lock()
+	defer x.addrLk.Unlock()
+	if dest != nil && (!sameIPAddr(dest, x.apparent) || x.natExpired(now)) {
+		return false
+	}
+	if source != nil && !sameIPAddr(source, x.remote) {
+		x.remote = source
+		x.amb.E(dccp.EventInfo, fmt.Sprintf("Remote %s", source))
+	}
+	return true
+}
+
+func sameIPAddr(a, b *dccp.IPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
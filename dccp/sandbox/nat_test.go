@@ -0,0 +1,106 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestPipeRebind checks that once a side of a pipe rebinds, the packets written to its old
+// address are dropped, until the other side reads a packet from its new address
+func TestPipeRebind(t *testing.T) {
+	env, _ := NewEnv("rebind-pipe")
+	llog := dccp.NewAmb("line", env)
+	hca, hcb, line := NewPipe(env, llog, "client", "server")
+	old := hca.LocalAddr().String()
+	if hcb.RemoteAddr().String() != old {
+		t.Errorf("server writes to %s, expected %s", hcb.RemoteAddr(), old)
+	}
+
+	// exchange writes a packet to side from, and returns whether side to reads it
+	exchange := func(from, to *headerHalfPipe) bool {
+		from.Write(&dccp.Header{Type: dccp.Data, X: true})
+		to.SetReadExpire(100e6)
+		_, err := to.Read()
+		return err == nil
+	}
+	if !exchange(hca, hcb) || !exchange(hcb, hca) {
+		t.Fatalf("packets lost before rebinding")
+	}
+	hca.Rebind(nil)
+	if exchange(hcb, hca) {
+		t.Errorf("packet for the old address delivered")
+	}
+	if !exchange(hca, hcb) {
+		t.Errorf("packet from the new address lost")
+	}
+	if hcb.RemoteAddr().String() == old {
+		t.Errorf("server writes to the old address %s", old)
+	}
+	if !exchange(hcb, hca) {
+		t.Errorf("packet for the new address lost")
+	}
+	if _, ba := line.Stats(); ba.Dropped["NAT"] != 1 {
+		t.Errorf("dropped %v, expected one packet for the old address", ba.Dropped)
+	}
+	if hca.LocalAddr().String() != old {
+		t.Errorf("local address changed to %s", hca.LocalAddr())
+	}
+
+	hca.Close()
+	hcb.Close()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestNATTimeout checks that a connection whose client is idle for longer than the timeout of
+// its NAT carries on from a new address, which the server learns, and that keepalives keep the
+// address of the client
+func TestNATTimeout(t *testing.T) {
+	for _, keepalive := range []time.Duration{0, 300 * time.Millisecond} {
+		name := "nat-timeout"
+		if keepalive > 0 {
+			name = "nat-keepalive"
+		}
+		t.Run(name, func(t *testing.T) {
+			env, _ := NewVirtualEnv(name)
+			clientConn, serverConn, clientToServer, _ := NewClientServerPipeCCID(env, ccid2.CCID2{})
+			clientToServer.SetNATTimeout(1e9)
+			clientConn.SetKeepalive(keepalive)
+
+			exchange := func() {
+				if err := clientConn.WriteSegment([]byte{1, 2, 3}); err != nil {
+					t.Fatalf("writing (%s)", err)
+				}
+				if _, err := serverConn.ReadSegment(); err != nil {
+					t.Fatalf("reading (%s)", err)
+				}
+			}
+			exchange()
+			before := serverConn.RemoteAddr().String()
+			env.Sleep(3e9)
+			exchange()
+			after := serverConn.RemoteAddr().String()
+			if keepalive == 0 && before == after {
+				t.Errorf("the client kept its address %s after its mapping expired", before)
+			}
+			if keepalive > 0 && before != after {
+				t.Errorf("the client moved from %s to %s despite keepalives", before, after)
+			}
+
+			clientConn.Abort()
+			serverConn.Abort()
+			env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+			if err := env.Close(); err != nil {
+				t.Errorf("error closing runtime (%s)", err)
+			}
+		})
+	}
+}
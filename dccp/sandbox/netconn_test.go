@@ -0,0 +1,65 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// TestNetConn checks that Conn behaves as a net.Conn: writes larger than the MTU arrive
+// intact, read deadlines produce timeout errors, and a normal close reads as io.EOF
+func TestNetConn(t *testing.T) {
+	env, _ := NewEnv("netconn")
+	clientConn, server, hca, _ := NewClientServerPipe(env)
+	var serverConn net.Conn = server
+
+	// The pipe gives its sides network addresses, which the connection reports
+	if addr, ok := clientConn.LocalAddr().(*dccp.IPAddr); !ok || addr.String() != hca.LocalAddr().String() {
+		t.Errorf("local address %v, expected the address of the pipe %v", clientConn.LocalAddr(), hca.LocalAddr())
+	}
+
+	payload := make([]byte, 2*clientConn.GetMTU()+10)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	n, err := clientConn.Write(payload)
+	if err != nil || n != len(payload) {
+		t.Fatalf("client write %d bytes (%v)", n, err)
+	}
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(serverConn, got); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("payload corrupted")
+	}
+
+	serverConn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = serverConn.Read(got)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("expecting timeout, encountered %v", err)
+	}
+	serverConn.SetReadDeadline(time.Time{})
+
+	if err := clientConn.Close(); err != nil {
+		t.Errorf("client close (%s)", err)
+	}
+	if _, err := serverConn.Read(got); err != io.EOF {
+		t.Errorf("expecting %s, encountered %v", io.EOF, err)
+	}
+
+	clientConn.Abort()
+	serverConn.(*dccp.Conn).Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.(*dccp.Conn).Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
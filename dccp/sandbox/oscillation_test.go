@@ -0,0 +1,77 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestReduceOscillations checks that a CCID3 sender with oscillation reduction on sends slower
+// than its allowed rate while the RTT grows, and that a plain one sends at its allowed rate
+func TestReduceOscillations(t *testing.T) {
+	// R_sample outgrows R_sqmean, which lags behind it, by a good fraction
+	if r := runOscillations(t, ccid3.CCID3{ReduceOscillations: true}); r > 0.9 {
+		t.Errorf("sent %.2f of the allowed rate with oscillation reduction, expected less", r)
+	}
+	if r := runOscillations(t, ccid3.CCID3{}); r < 0.95 || r > 1.05 {
+		t.Errorf("sent %.2f of the allowed rate without oscillation reduction, expected all of it", r)
+	}
+}
+
+// runOscillations sends data from the client to the server of a CCID3 connection as fast as
+// the sender allows, and then raises the latency of the path. It returns the number of packets
+// that the sender sends in the two seconds after the RTT samples start to grow, as a fraction
+// of the number that its allowed sending rate permits.
+func runOscillations(t *testing.T, ccid ccid3.CCID3) float64 {
+	env, _ := NewVirtualEnv("oscillations")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid)
+	clientToServer.SetWriteLatency(50e6)
+	serverToClient.SetWriteLatency(50e6)
+	// Few enough packets in flight that the sender matches all feedback to its send times
+	clientToServer.SetWriteRate(100*time.Millisecond, 5)
+	// Packets of a full segment let the rate in bytes grow along with the rate in packets
+	clientToServer.SetMTU(ccid3.FixedSegmentSize + 100)
+
+	reader := env.Go(func() {
+		for {
+			if _, err := serverConn.ReadSegment(); err != nil {
+				return
+			}
+		}
+	}, "test reader")
+	buf := make([]byte, clientConn.GetMTU())
+	writer := env.Go(func() {
+		for {
+			if err := clientConn.WriteSegment(buf); err != nil {
+				return
+			}
+		}
+	}, "test writer")
+	env.Sleep(5e9)
+
+	clientToServer.SetWriteLatency(200e6)
+	env.Sleep(200e6)
+	offered := clientToServer.Stats().Offered
+	var allowed float64
+	for i := 0; i < 200; i++ {
+		ss, _ := ccid3.GetSenderState(clientConn)
+		allowed += float64(ss.X) / float64(ss.SS) / 100
+		env.Sleep(10e6)
+	}
+	sent := clientToServer.Stats().Offered - offered
+
+	clientConn.Abort()
+	reader.Join()
+	writer.Join()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+	return float64(sent) / allowed
+}
@@ -0,0 +1,48 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestInitCookie checks that the client echoes the server's Init Cookie in PARTOPEN, so that
+// the handshake completes and data flows
+func TestInitCookie(t *testing.T) {
+	env, _ := NewEnv("initcookie")
+	clientConn, serverConn, _, _ := newClientServer(env, ccid3.CCID3{}, "client", "server", clientServerSetup{
+		server: func(c *dccp.Conn) {
+			if err := c.SetInitCookie([]byte("cookie")); err != nil {
+				t.Fatalf("set init cookie (%s)", err)
+			}
+		},
+	})
+	if clientConn.SetInitCookie([]byte("cookie")) == nil {
+		t.Errorf("client accepted an init cookie")
+	}
+
+	payload := []byte{1, 2, 3}
+	if err := clientConn.WriteSegment(payload); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	b, err := serverConn.ReadSegment()
+	if err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+	if !bytes.Equal(b, payload) {
+		t.Errorf("expecting %v, encountered %v", payload, b)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
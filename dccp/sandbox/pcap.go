@@ -0,0 +1,305 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// PcapPacket is a DCCP packet read from a packet capture
+type PcapPacket struct {
+	Time      int64 // Capture time in nanoseconds since the epoch
+	Interface int   // Interface of a pcapng file that the packet was captured on, or zero
+	SrcIP     net.IP
+	DstIP     net.IP
+	Header    *dccp.Header // The ports of DCCP-UDP packets are those of the UDP header
+}
+
+// ErrPcap is returned by ReadPcap for files that are neither pcap nor pcapng files
+var ErrPcap = errors.New("not a pcap or pcapng file")
+
+// Link types of packet captures that ReadPcap understands, see www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLoop     = 108
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+)
+
+// ReadPcapFile reads the DCCP packets of the pcap or pcapng file filename, see ReadPcap
+func ReadPcapFile(filename string, udpPorts ...int) ([]*PcapPacket, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPcap(f, udpPorts...)
+}
+
+// ReadPcap reads the DCCP packets of a capture in the pcap or pcapng format, like those of
+// tcpdump, Wireshark or dccp.PcapWriter, in the order of the file. Packets over IP protocol
+// DCCP are read, as well as DCCP-UDP packets from or to one of udpPorts, or dccp.EncapPort if
+// none are given. Packets that are not DCCP, are truncated or fail to parse are skipped.
+// Checksums are not verified, since captures of outgoing packets often lack them.
+func ReadPcap(r io.Reader, udpPorts ...int) ([]*PcapPacket, error) {
+	if len(udpPorts) == 0 {
+		udpPorts = []int{dccp.EncapPort}
+	}
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, ErrPcap
+	}
+	x := &pcapReader{r: br, udpPorts: udpPorts}
+	if binary.LittleEndian.Uint32(magic) == 0x0a0d0d0a {
+		err = x.readNg()
+	} else {
+		err = x.readClassic()
+	}
+	return x.packets, err
+}
+
+type pcapReader struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	udpPorts []int
+	packets  []*PcapPacket
+}
+
+// readClassic reads a pcap file, whose timestamps are in micro- or nanoseconds
+func (x *pcapReader) readClassic() error {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(x.r, hdr); err != nil {
+		return ErrPcap
+	}
+	var nano bool
+	switch {
+	case binary.LittleEndian.Uint32(hdr) == 0xa1b2c3d4:
+		x.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr) == 0xa1b2c3d4:
+		x.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(hdr) == 0xa1b23c4d:
+		x.order, nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(hdr) == 0xa1b23c4d:
+		x.order, nano = binary.BigEndian, true
+	default:
+		return ErrPcap
+	}
+	linkType := int(x.order.Uint32(hdr[20:24]) & 0xffff)
+	rec := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(x.r, rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		t := int64(x.order.Uint32(rec[0:4])) * 1e9
+		if nano {
+			t += int64(x.order.Uint32(rec[4:8]))
+		} else {
+			t += int64(x.order.Uint32(rec[4:8])) * 1e3
+		}
+		data := make([]byte, x.order.Uint32(rec[8:12]))
+		if _, err := io.ReadFull(x.r, data); err != nil {
+			return err
+		}
+		x.addFrame(t, 0, linkType, data)
+	}
+}
+
+// ngInterface is an interface of a pcapng section
+type ngInterface struct {
+	linkType int
+	tsUnit   float64 // Nanoseconds per timestamp unit
+}
+
+// readNg reads a pcapng file, made of one or more sections
+func (x *pcapReader) readNg() error {
+	var ifaces []ngInterface
+	for {
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(x.r, hdr); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(hdr[0:4]) == 0x0a0d0d0a {
+			// A section header sets the byte order of the section
+			magic, err := x.r.Peek(4)
+			if err != nil {
+				return err
+			}
+			if binary.LittleEndian.Uint32(magic) == 0x1a2b3c4d {
+				x.order = binary.LittleEndian
+			} else {
+				x.order = binary.BigEndian
+			}
+			ifaces = nil
+		}
+		if x.order == nil {
+			return ErrPcap
+		}
+		n := int(x.order.Uint32(hdr[4:8]))
+		if n < 12 || n%4 != 0 {
+			return ErrPcap
+		}
+		body := make([]byte, n-8)
+		if _, err := io.ReadFull(x.r, body); err != nil {
+			return err
+		}
+		body = body[:len(body)-4]
+		switch x.order.Uint32(hdr[0:4]) {
+		case 1: // Interface description
+			if len(body) < 8 {
+				return ErrPcap
+			}
+			iface := ngInterface{linkType: int(x.order.Uint16(body[0:2])), tsUnit: 1e3}
+			for opts := body[8:]; len(opts) >= 4; {
+				code, l := x.order.Uint16(opts[0:2]), int(x.order.Uint16(opts[2:4]))
+				if code == 0 || 4+l > len(opts) {
+					break
+				}
+				if code == 9 && l == 1 { // if_tsresol
+					iface.tsUnit = tsUnit(opts[4])
+				}
+				opts = opts[4+(l+3)&^3:]
+			}
+			ifaces = append(ifaces, iface)
+		case 6: // Enhanced packet
+			if len(body) < 20 {
+				return ErrPcap
+			}
+			i := int(x.order.Uint32(body[0:4]))
+			if i >= len(ifaces) {
+				return ErrPcap
+			}
+			ts := uint64(x.order.Uint32(body[4:8]))<<32 | uint64(x.order.Uint32(body[8:12]))
+			caplen := int(x.order.Uint32(body[12:16]))
+			if 20+caplen > len(body) {
+				return ErrPcap
+			}
+			x.addFrame(int64(float64(ts)*ifaces[i].tsUnit), i, ifaces[i].linkType, body[20:20+caplen])
+		}
+	}
+}
+
+// tsUnit returns the nanoseconds per timestamp unit of the pcapng if_tsresol option v
+func tsUnit(v byte) float64 {
+	unit := 1e9
+	for i := 0; i < int(v&0x7f); i++ {
+		if v&0x80 != 0 {
+			unit /= 2
+		} else {
+			unit /= 10
+		}
+	}
+	return unit
+}
+
+// addFrame adds the DCCP packet in the link layer frame data, if there is one
+func (x *pcapReader) addFrame(t int64, iface, linkType int, data []byte) {
+	switch linkType {
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return
+		}
+		data = data[4:]
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		if etherType == 0x8100 && len(data) >= 4 { // 802.1Q tag
+			data = data[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return
+		}
+		data = data[16:]
+	default:
+		return
+	}
+	x.addIP(t, iface, data)
+}
+
+// addIP adds the DCCP packet carried by the IP packet data, if there is one
+func (x *pcapReader) addIP(t int64, iface int, data []byte) {
+	var src, dst net.IP
+	var proto, ecn byte
+	switch {
+	case len(data) >= 20 && data[0]>>4 == 4:
+		ihl := int(data[0]&0x0f) * 4
+		if ihl < 20 || ihl > len(data) || binary.BigEndian.Uint16(data[6:8])&0x1fff != 0 {
+			return // Bad header, or not the first fragment
+		}
+		src, dst, proto, ecn = net.IP(data[12:16]), net.IP(data[16:20]), data[9], data[1]&3
+		if l := int(binary.BigEndian.Uint16(data[2:4])); l >= ihl && l < len(data) {
+			data = data[:l] // Drop Ethernet padding
+		}
+		data = data[ihl:]
+	case len(data) >= 40 && data[0]>>4 == 6:
+		src, dst, proto, ecn = net.IP(data[8:24]), net.IP(data[24:40]), data[6], data[1]>>4&3
+		if l := 40 + int(binary.BigEndian.Uint16(data[4:6])); l < len(data) {
+			data = data[:l]
+		}
+		data = data[40:]
+	default:
+		return
+	}
+
+	var h *dccp.Header
+	var err error
+	switch proto {
+	case 33:
+		h, err = dccp.ParseHeader(data)
+	case 17:
+		if len(data) < 8+4 || !x.isDCCPUDP(data) {
+			return
+		}
+		// Restore the RFC 4340 header, with the UDP ports in place of the UDP header, RFC 6773
+		buf := make([]byte, 4+len(data)-8)
+		copy(buf[0:4], data[0:4])
+		copy(buf[4:], data[8:])
+		buf[4]--
+		h, err = dccp.ParseHeader(buf)
+	default:
+		return
+	}
+	if err != nil {
+		return
+	}
+	h.ECN = ecn
+	x.packets = append(x.packets, &PcapPacket{
+		Time:      t,
+		Interface: iface,
+		SrcIP:     append(net.IP(nil), src...),
+		DstIP:     append(net.IP(nil), dst...),
+		Header:    h,
+	})
+}
+
+// isDCCPUDP returns true if the UDP datagram data is from or to one of the DCCP-UDP ports
+func (x *pcapReader) isDCCPUDP(data []byte) bool {
+	sport, dport := int(binary.BigEndian.Uint16(data[0:2])), int(binary.BigEndian.Uint16(data[2:4]))
+	for _, port := range x.udpPorts {
+		if sport == port || dport == port {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// TestPcap checks that the connections of an Env save the packets they send and receive, each
+// on an interface of its own, starting with the Request
+func TestPcap(t *testing.T) {
+	env, _ := NewEnv("pcap")
+	var buf bytes.Buffer
+	w, err := dccp.NewPcapWriter(&buf)
+	if err != nil {
+		t.Fatalf("pcap writer (%s)", err)
+	}
+	env.SetPcap(w)
+	clientConn, serverConn, _, _ := NewClientServerPipe(env)
+
+	if err := clientConn.WriteSegment([]byte("hello")); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+
+	// Collect the DCCP packet types of the enhanced packet blocks of each interface
+	types := make(map[uint32][]byte)
+	b := buf.Bytes()
+	for len(b) >= 12 {
+		n := int(binary.LittleEndian.Uint32(b[4:8]))
+		if binary.LittleEndian.Uint32(b[0:4]) == 6 {
+			iface := binary.LittleEndian.Uint32(b[8:12])
+			pkt := b[28:]
+			// Raw IPv4 packets with a 20-byte header, followed by the DCCP header
+			types[iface] = append(types[iface], (pkt[20+8]>>1)&0x0f)
+		}
+		b = b[n:]
+	}
+	if len(types) != 2 {
+		t.Fatalf("packets on %d interfaces, expected 2", len(types))
+	}
+	for iface, tt := range types {
+		if len(tt) < 3 || tt[0] != dccp.Request {
+			t.Errorf("interface %d saw packet types %v", iface, tt)
+		}
+	}
+}
@@ -6,13 +6,19 @@ package sandbox
 
 import (
 	"fmt"
+	"math"
+	"math/rand"
+	"net"
 	"sync"
+	"time"
 	"github.com/petar/GoDCCP/dccp"
 )
 
 // Pipe is an in-process commincation channel, whose two ends implement dccp.HeaderConn.
-// It supports rate limiting, latency emulation and receive buffer emulation (in order to
-// capture slow readers).
+// It supports rate limiting with drop-tail or active queue management, latency, jitter,
+// loss, reordering, duplication and corruption emulation, receive buffer emulation (in
+// order to capture slow readers), path MTU limits, and blackholes and endpoint crashes, see
+// fault.go. Stats counts what it does with the packets written to it.
 type Pipe struct {
 	amb *dccp.Amb
 	ha, hb headerHalfPipe
@@ -26,11 +32,24 @@ func NewPipe(env *dccp.Env, amb *dccp.Amb, namea, nameb string) (a, b *headerHal
 	line.amb = amb
 	line.ha.Init(env, line.amb.Refine(namea), ba, ab)
 	line.hb.Init(env, line.amb.Refine(nameb), ab, ba)
+	line.ha.readStats, line.hb.readStats = line.hb.stats, line.ha.stats
+	line.ha.peer, line.hb.peer = &line.hb, &line.ha
+	addra := &dccp.IPAddr{IP: net.IP(pipeSourceIP), Port: pipeSourcePort}
+	addrb := &dccp.IPAddr{IP: net.IP(pipeDestIP), Port: pipeDestPort}
+	line.ha.initAddr(addra, addrb)
+	line.hb.initAddr(addrb, addra)
 	return &line.ha, &line.hb, line
 }
 
+// Stats returns the counts of the packets written to either side of the pipe, and of how the
+// pipe treated them: ab for the side named namea in NewPipe, whose packets go to the other
+// side, and ba for the other side
+func (line *Pipe) Stats() (ab, ba PipeStats) {
+	return line.ha.Stats(), line.hb.Stats()
+}
+
 const (
-	DefaultRateInterval           = 1e9
+	DefaultRateInterval           = time.Second
 	DefaultRatePacketsPerInterval = 100
 )
 
@@ -42,75 +61,344 @@ type headerHalfPipe struct {
 	amb                    *dccp.Amb
 
 	// read, writeLk and write pertain to the communication mechanism of the pipe
+	// wire is the channel to the other side, which write is until this side is closed.
+	// readT accounts for read on virtual time, and the readT of the peer for wire.
 	read                   <-chan *pipeHeader
+	readT                  dccp.Track
 	writeLk                sync.Mutex
 	write                  chan<- *pipeHeader
-
-	// rateLk is used to lock on all rate* variables below as well as readDeadline
-	rateLk                 sync.Mutex
-
-	// Time is partitioned into equal units of rateInterval nanoseconds. Within each unit only
-	// ratePacketsPerInterval packets are delivered; the rest are dropped
-	rateInterval           int64      
-	ratePacketsPerInterval uint32
-
-	// rateIntervalCounter is the consequtive number of the current rateInterval interval
-	rateIntervalCounter    int64
-
-	// rateIntervalFill is the number of packets having been transmitted already during the
-	// rateIntervalCounter-th time interval
-	rateIntervalFill       uint32
+	wire                   chan<- *pipeHeader
+
+	// peer is the other side of the pipe. faultLk protects blackhole, crashed and crashReset,
+	// see fault.go.
+	peer                   *headerHalfPipe
+	faultLk                sync.Mutex
+	blackhole              bool
+	crashed                bool
+	crashReset             bool
+
+	// reorder is set by SetWriteReorder, and held are the packets that it holds back;
+	// duplicate and duplicateLag are set by SetWriteDuplicate. All are protected by writeLk.
+	reorder                Reorder
+	held                   []*heldHeader
+	duplicate              float64
+	duplicateLag           int64
+
+	// link is the bottleneck that packets written from this endpoint go through, which other
+	// pipes may share
+	link                   *Bottleneck
 
 	// readDeadline is the absolute time deadline for the reads on this side of the connection
 	readDeadlineLk         sync.Mutex
 	readDeadline           int64
 
-	// writeLatency is the delay imposed on packets written from this endpoint before they are delivered
+	// writeLatency is the delay imposed on packets written from this endpoint before they are
+	// delivered, to which writeJitter, if not nil, adds a random amount drawn from writeRand.
+	// writeLoss, if not nil, decides which packets are lost, drawing from writeRand as well.
+	// writeCorrupt and writeCorruptWhere are set by SetWriteCorrupt.
 	writeLatencyLk         sync.Mutex
 	writeLatency           int64
+	writeJitter            Jitter
+	writeLoss              LossModel
+	writeCorrupt           float64
+	writeCorruptWhere      int
+	writeRand              *rand.Rand
 
 	latencyQueueLk         sync.Mutex
 	latencyQueue
+
+	// mtu is the MTU that GetMTU reports; packets longer than pathMTU, if positive, are dropped,
+	// and answered with an ICMP error on icmp if pathMTUICMP is set
+	mtuLk                  sync.Mutex
+	mtu                    int
+	pathMTU                int
+	pathMTUICMP            bool
+	icmp                   chan *dccp.ICMPError
+	icmpT                  dccp.Track
+
+	// stats are the counts of the packets written to this side, and readStats those of the
+	// other side, whose packets are read from this side
+	stats                  *pipeStats
+	readStats              *pipeStats
+
+	// local is the address of this side, and apparent the address that its packets come from,
+	// behind a NAT whose mappings expire after natTimeout without a packet written since
+	// natLast. remote is the address of the other side, as this side knows it. See nat.go.
+	addrLk                 sync.Mutex
+	local                  *dccp.IPAddr
+	apparent               *dccp.IPAddr
+	remote                 *dccp.IPAddr
+	natTimeout             int64
+	natLast                int64
 }
 
 type pipeHeader struct {
 	Header      *dccp.Header
 	DeliverTime int64
+	WriteTime   int64        // Time when the packet was written
+	Size        int          // Footprint of the packet
+	Source      *dccp.IPAddr // Apparent address of the side that wrote the packet
+	Dest        *dccp.IPAddr // Address that the packet is written to
+}
+
+// heldHeader is a packet held back for reordering, until left more packets are written
+type heldHeader struct {
+	ph   *pipeHeader
+	left int
+}
+
+// Reorder describes the reordering of packets by a pipe, see SetWriteReorder
+type Reorder struct {
+	Fraction float64       // Fraction of the packets that are reordered
+	Packets  int           // Number of later packets that a reordered packet is delivered after
+	Delay    time.Duration // Delay of reordered packets, if Packets is zero
 }
 
+// Parts of packets that SetWriteCorrupt flips bits in
+const (
+	CorruptHeader  = 1 << iota // The header, options included
+	CorruptPayload             // The application data
+)
+
+// Addresses of the checksum pseudo-header of the packets that a pipe corrupts
+var (
+	pipeSourceIP = []byte{127, 0, 0, 1}
+	pipeDestIP   = []byte{127, 0, 0, 2}
+)
+
+const pipeProtoNo = 33
+
 // Init resets a half pipe for initial use, using amb (without making a copy of it)
 func (x *headerHalfPipe) Init(env *dccp.Env, amb *dccp.Amb, r <-chan *pipeHeader, w chan<- *pipeHeader) {
 	x.env = env
 	x.amb = amb
 	x.read = r
 	x.write = w
-	x.SetWriteRate(DefaultRateInterval, DefaultRatePacketsPerInterval)
-	x.readDeadline = x.env.Now() - 1e9
+	x.wire = w
+	x.link = NewBottleneck(env)
+	x.readDeadline = x.env.Now().UnixNano() - 1e9
 	x.writeLatency = 0
+	x.writeRand = rand.New(rand.NewSource(env.Int63n(math.MaxInt64)))
 	x.latencyQueue.Init(env, amb)
+	x.mtu = 1500
+	x.icmp = make(chan *dccp.ICMPError, 1)
+	x.stats = newPipeStats()
+}
+
+// SetWriteLatency sets the write packet latency
+func (x *headerHalfPipe) SetWriteLatency(latency time.Duration) {
+	x.writeLatencyLk.Lock()
+	defer x.writeLatencyLk.Unlock()
+	x.writeLatency = int64(latency)
+}
+
+// SetWriteJitter makes the delay of each packet written from this endpoint vary by a random
+// amount, drawn from jitter, around the latency set by SetWriteLatency. Packets whose delay
+// would be negative are delivered without delay. As on real paths, jitter reorders packets
+// that are written closer together than it spreads them. A nil jitter turns it off. The
+// random numbers come from a source seeded from the Env, so that simulations repeat.
+func (x *headerHalfPipe) SetWriteJitter(jitter Jitter) {
+	x.writeLatencyLk.Lock()
+	defer x.writeLatencyLk.Unlock()
+	x.writeJitter = jitter
+}
+
+// SetWriteLoss makes the packets written from this endpoint get lost as model decides, after
+// the rate limit lets them through. A nil model turns losses off.
+func (x *headerHalfPipe) SetWriteLoss(model LossModel) {
+	x.writeLatencyLk.Lock()
+	defer x.writeLatencyLk.Unlock()
+	x.writeLoss = model
+}
+
+// SetWriteCorrupt makes the pipe flip one random bit, in the parts of the packet given by
+// where, of each packet written from this endpoint with probability p. The bit is flipped in
+// the wire format, checksum included, and the packet is checked as the receiving stack would.
+// Packets that fail the check are dropped, and the others are delivered with the flipped bit,
+// which can only be in application data outside the checksum coverage. Packets without
+// application data are not corrupted if where is CorruptPayload. A zero p turns corruption off.
+func (x *headerHalfPipe) SetWriteCorrupt(p float64, where int) {
+	x.writeLatencyLk.Lock()
+	defer x.writeLatencyLk.Unlock()
+	x.writeCorrupt, x.writeCorruptWhere = p, where
 }
 
-// SetWriteLatency sets the write packet latency and it is given in nanoseconds
-func (x *headerHalfPipe) SetWriteLatency(latency int64) {
+// writeCorrupted returns h, or a corrupted copy of it if the next packet written from this
+// endpoint is to be corrupted, along with false if the checksum of the corrupted packet fails
+func (x *headerHalfPipe) writeCorrupted(h *dccp.Header) (*dccp.Header, bool) {
 	x.writeLatencyLk.Lock()
 	defer x.writeLatencyLk.Unlock()
-	x.writeLatency = latency
+	if x.writeCorrupt <= 0 || x.writeRand.Float64() >= x.writeCorrupt {
+		return h, true
+	}
+	buf, err := h.Write(pipeSourceIP, pipeDestIP, pipeProtoNo, false)
+	if err != nil {
+		return h, true
+	}
+	dataOffset := int(buf[4]) * 4
+	lo, hi := 0, len(buf)
+	switch x.writeCorruptWhere {
+	case CorruptHeader:
+		hi = dataOffset
+	case CorruptPayload:
+		lo = dataOffset
+	}
+	if lo >= hi {
+		return h, true
+	}
+	bit := lo*8 + x.writeRand.Intn((hi-lo)*8)
+	buf[bit/8] ^= 1 << uint(bit%8)
+	hh, err := dccp.ReadHeader(buf, pipeSourceIP, pipeDestIP, pipeProtoNo, false)
+	if err != nil {
+		return h, false
+	}
+	hh.ECN = h.ECN
+	return hh, true
+}
+
+// SetWriteReorder makes the pipe deliver a random fraction of the packets written from this
+// endpoint out of order. Each of them is held back until r.Packets more packets have been
+// written, or, if r.Packets is zero, delayed by r.Delay on top of the latency. Packets held
+// back for later packets wait for as long as it takes. A zero Reorder turns reordering off.
+func (x *headerHalfPipe) SetWriteReorder(r Reorder) {
+	x.writeLk.Lock()
+	defer x.writeLk.Unlock()
+	x.reorder = r
+}
+
+// SetWriteDuplicate makes the pipe deliver a copy of each packet written from this endpoint
+// with probability p, lag after the packet itself. A zero p turns duplication off.
+func (x *headerHalfPipe) SetWriteDuplicate(p float64, lag time.Duration) {
+	x.writeLk.Lock()
+	defer x.writeLk.Unlock()
+	x.duplicate, x.duplicateLag = p, int64(lag)
+}
+
+// writeDuplicated returns true if the next packet written from this endpoint is to be
+// delivered twice
+func (x *headerHalfPipe) writeDuplicated() bool {
+	x.writeLatencyLk.Lock()
+	defer x.writeLatencyLk.Unlock()
+	return x.duplicate > 0 && x.writeRand.Float64() < x.duplicate
+}
+
+// writeReordered returns true if the next packet written from this endpoint is to be
+// reordered
+func (x *headerHalfPipe) writeReordered() bool {
+	x.writeLatencyLk.Lock()
+	defer x.writeLatencyLk.Unlock()
+	return x.reorder.Fraction > 0 && x.writeRand.Float64() < x.reorder.Fraction
+}
+
+// writeLost returns true if the next packet written from this endpoint is lost
+func (x *headerHalfPipe) writeLost() bool {
+	x.writeLatencyLk.Lock()
+	defer x.writeLatencyLk.Unlock()
+	return x.writeLoss != nil && x.writeLoss.Lose(x.writeRand)
+}
+
+// writeDelay returns the delay of the next packet written from this endpoint
+func (x *headerHalfPipe) writeDelay() int64 {
+	x.writeLatencyLk.Lock()
+	defer x.writeLatencyLk.Unlock()
+	if x.writeJitter == nil {
+		return x.writeLatency
+	}
+	return max64(0, x.writeLatency+int64(x.writeJitter.Delay(x.writeRand)))
 }
 
 // SetWriteRate sets the transmission rate of this side of the pipe to ratePacketsPerInterval packets for each
-// interval of rateInterval nanoseconds
-func (x *headerHalfPipe) SetWriteRate(rateInterval int64, ratePacketsPerInterval uint32) {
-	x.rateLk.Lock()
-	defer x.rateLk.Unlock()
-	x.rateInterval = rateInterval
-	x.ratePacketsPerInterval = ratePacketsPerInterval
-	x.rateIntervalCounter = 0
-	x.rateIntervalFill = 0
+// interval of rateInterval, see Bottleneck.SetRate. Pipes that share the
+// bottleneck get the same rate, as they do with the other settings of the bottleneck.
+func (x *headerHalfPipe) SetWriteRate(rateInterval time.Duration, ratePacketsPerInterval uint32) {
+	x.Bottleneck().SetRate(rateInterval, ratePacketsPerInterval)
+}
+
+// SetWriteRateBytes sets the transmission rate of this side of the pipe in bytes, see
+// Bottleneck.SetRateBytes
+func (x *headerHalfPipe) SetWriteRateBytes(rateInterval time.Duration, bytesPerInterval int64) {
+	x.Bottleneck().SetRateBytes(rateInterval, bytesPerInterval)
+}
+
+// SetWriteQueue sets the drop-tail queue of this side of the pipe, see Bottleneck.SetQueue
+func (x *headerHalfPipe) SetWriteQueue(packets int, bytes int64) {
+	x.Bottleneck().SetQueue(packets, bytes)
+}
+
+// SetWriteAQM sets the queue management of this side of the pipe, see Bottleneck.SetAQM
+func (x *headerHalfPipe) SetWriteAQM(aqm AQM) {
+	x.Bottleneck().SetAQM(aqm)
+}
+
+// Bottleneck returns the bottleneck that the packets written from this endpoint go through
+func (x *headerHalfPipe) Bottleneck() *Bottleneck {
+	x.writeLk.Lock()
+	defer x.writeLk.Unlock()
+	return x.link
+}
+
+// SetBottleneck makes the packets written from this endpoint go through b, which other pipes
+// may share, in place of the bottleneck of their own
+func (x *headerHalfPipe) SetBottleneck(b *Bottleneck) {
+	x.writeLk.Lock()
+	defer x.writeLk.Unlock()
+	x.link = b
+}
+
+// SetMTU sets the MTU that GetMTU reports, as path MTU discovery would on a real network.
+// Headers are delivered whatever their size.
+func (x *headerHalfPipe) SetMTU(mtu int) {
+	x.mtuLk.Lock()
+	defer x.mtuLk.Unlock()
+	x.mtu = mtu
+}
+
+// SetPathMTU makes the pipe drop the packets written to it that are longer than mtu, as a
+// path that sends no ICMP messages would. A zero mtu lets packets of any size through.
+func (x *headerHalfPipe) SetPathMTU(mtu int) {
+	x.mtuLk.Lock()
+	defer x.mtuLk.Unlock()
+	x.pathMTU, x.pathMTUICMP = mtu, false
+}
+
+// SetPathMTUICMP is like SetPathMTU, except that the path answers each packet that it drops
+// with a Fragmentation Needed message, as a router would. The message lowers the MTU that
+// GetMTU reports to mtu and is returned by the next Read from this side, as a dccp.ICMPError
+// whose MTU is in the units of GetMTU rather than those of IP.
+func (x *headerHalfPipe) SetPathMTUICMP(mtu int) {
+	x.mtuLk.Lock()
+	defer x.mtuLk.Unlock()
+	x.pathMTU, x.pathMTUICMP = mtu, mtu > 0
+}
+
+// rejectMTU answers a packet longer than the path MTU mtu with a Fragmentation Needed message.
+// A message is dropped if the reader has not yet taken the previous one.
+func (x *headerHalfPipe) rejectMTU(mtu int) {
+	x.mtuLk.Lock()
+	if mtu < x.mtu {
+		x.mtu = mtu
+	}
+	x.mtuLk.Unlock()
+	// Destination Unreachable, Fragmentation Needed
+	e := &dccp.ICMPError{Type: 3, Code: 4, MTU: mtu}
+	select {
+	case x.icmp <- e:
+		x.env.Sent(&x.icmpT)
+	default:
+	}
 }
 
 // GetMTU implements dccp.HeaderConn.GetMTU
 func (x *headerHalfPipe) GetMTU() int {
-	return 1500
+	x.mtuLk.Lock()
+	defer x.mtuLk.Unlock()
+	return x.mtu
+}
+
+// CarriesECN implements dccp.ECNCarrier.CarriesECN. Headers, including their ECN
+// codepoint, are delivered to the other side as they are.
+func (x *headerHalfPipe) CarriesECN() bool {
+	return true
 }
 
 // Read implements dccp.HeaderConn.Read
@@ -128,12 +416,29 @@ func (x *headerHalfPipe) Read() (h *dccp.Header, err error) {
 			x.latencyQueueLk.Lock()
 			ph := x.latencyQueue.DeleteMin()
 			x.latencyQueueLk.Unlock()
+			now := x.env.Now().UnixNano()
+			if !x.natInbound(now, ph.Source, ph.Dest) {
+				x.amb.E(dccp.EventDrop, "NAT", ph.Header)
+				x.readStats.drop("NAT")
+				continue
+			}
+			if x.isCrashed() {
+				x.amb.E(dccp.EventDrop, "Crashed", ph.Header)
+				x.readStats.drop("Crashed")
+				continue
+			}
+			x.readStats.deliver(ph.Size, time.Duration(now-ph.WriteTime))
 			x.amb.E(dccp.EventRead, fmt.Sprintf("SeqNo=%d", ph.Header.SeqNo), ph.Header)
 			return ph.Header, nil
 		}
 		
 		// Calculate time to wait until either queued packet is available or read timeout is reached
-		timeout := readDeadline - x.env.Now()
+		var timeout int64 // Zero stands for no timeout
+		if readDeadline > 0 {
+			if timeout = readDeadline - x.env.Now().UnixNano(); timeout <= 0 {
+				return nil, dccp.ErrTimeout
+			}
+		}
 		if existQueued {
 			if timeout == 0 {
 				timeout = timeToQueued
@@ -142,20 +447,41 @@ func (x *headerHalfPipe) Read() (h *dccp.Header, err error) {
 			}
 		}
 
-		timeoutChan := x.makeTimeoutChan(timeout)
+		timeoutChan, timeoutT := x.makeTimeoutChan(timeout)
 
-		// Either timeout or receive a new packet which goes to the latency queue
+		// Once the other side has closed the pipe, the packets still queued are delivered
+		// before EOF
+		if x.read == nil && !existQueued {
+			x.amb.E(dccp.EventWarn, "Read EOF")
+			return nil, dccp.ErrEOF
+		}
+
+		// Either timeout or receive a new packet which goes to the latency queue. The timeout
+		// may be that of the queued packet, which the next iteration delivers.
+		// A closed read channel is nil from then on, and no longer waited on
+		readT := &x.readT
+		if x.read == nil {
+			readT = nil
+		}
+		x.env.Wait(readT, &x.icmpT, timeoutT)
 		select {
 		case ph, ok := <-x.read:
+			x.env.Woken(readT, &x.icmpT, timeoutT)
 			if !ok {
-				x.amb.E(dccp.EventWarn, "Read EOF", ph.Header)
-				return nil, dccp.ErrEOF
+				x.read = nil
+				continue
 			}
+			x.env.Received(&x.readT)
 			x.latencyQueueLk.Lock()
 			x.latencyQueue.Add(ph)
 			x.latencyQueueLk.Unlock()
+		case e := <-x.icmp:
+			x.env.Woken(readT, &x.icmpT, timeoutT)
+			x.env.Received(&x.icmpT)
+			x.amb.E(dccp.EventInfo, "ICMP: "+e.Error())
+			return nil, e
 		case <-timeoutChan:
-			return nil, dccp.ErrTimeout
+			x.env.Woken(readT, &x.icmpT, timeoutT)
 		}
 	}
 	panic("un")
@@ -163,18 +489,19 @@ func (x *headerHalfPipe) Read() (h *dccp.Header, err error) {
 
 var sleepingChan = make(chan int64)
 
-func (x *headerHalfPipe) makeTimeoutChan(timeout int64) (<-chan int64) {
-	var ch chan int64
-	if timeout > 0 {
-		ch = make(chan int64)
-		x.env.Go(func() {
-			x.env.Sleep(timeout)
-			close(ch)
-		}, "pipe timeout")
-	} else {
-		ch = sleepingChan
+// makeTimeoutChan returns a channel that is closed once timeout has passed, and the Track that
+// accounts for it, or a channel that is never closed if timeout is zero
+func (x *headerHalfPipe) makeTimeoutChan(timeout int64) (<-chan int64, *dccp.Track) {
+	if timeout <= 0 {
+		return sleepingChan, nil
 	}
-	return ch
+	ch, k := make(chan int64), &dccp.Track{}
+	x.env.Go(func() {
+		x.env.Sleep(time.Duration(timeout))
+		close(ch)
+		x.env.Closed(k)
+	}, "pipe timeout")
+	return ch, k
 }
 
 // Write implements dccp.HeaderConn.Write
@@ -186,41 +513,143 @@ func (x *headerHalfPipe) Write(h *dccp.Header) (err error) {
 		x.amb.E(dccp.EventDrop, fmt.Sprintf("ErrBad"), h)
 		return dccp.ErrBad
 	}
+	// The pipe carries headers as they are, so it gathers vectored data as the wire would
+	h.GatherData()
+	x.transmit(h, false)
+	return nil
+}
 
-	if x.rateFilter() {
-		if len(x.write) >= cap(x.write) {
-			x.amb.E(dccp.EventDrop, "Slow reader", h)
-		} else {
-			x.amb.E(dccp.EventWrite, "", h)
-			x.writeLatencyLk.Lock()
-			latency := x.writeLatency
-			x.writeLatencyLk.Unlock()
-			now := x.env.Now()
-			x.write <- &pipeHeader{ Header: h, DeliverTime: now + latency }
+// transmit puts h through the faults of the pipe on its way to the other side. Packets that an
+// endpoint writes after it crashes are dropped, unless they are the Resets that answer for it.
+// It is called under writeLk.
+func (x *headerHalfPipe) transmit(h *dccp.Header, answer bool) {
+	x.mtuLk.Lock()
+	pathMTU, icmp := x.pathMTU, x.pathMTUICMP
+	x.mtuLk.Unlock()
+	n, err := h.Footprint()
+	now := x.env.Now().UnixNano()
+	x.stats.offer(n)
+	source, dest := x.natOutbound(now)
+	if x.isCrashed() && !answer {
+		x.drop("Crashed", h)
+		return
+	}
+	if x.isBlackhole() {
+		x.drop("Blackhole", h)
+		return
+	}
+	if err == nil && pathMTU > 0 && n > pathMTU {
+		x.drop("Beyond path MTU", h)
+		if icmp {
+			x.rejectMTU(pathMTU)
 		}
-	} else {
-		x.amb.E(dccp.EventDrop, "Fast writer", h)
+		return
 	}
-	return nil
+
+	sent, mark, drop := x.link.filter(now, n, h.ECN != dccp.ECNNotECT)
+	if drop != "" {
+		x.drop(drop, h)
+		return
+	}
+	if x.writeLost() {
+		x.drop("Lost", h)
+		return
+	}
+	if mark {
+		marked := *h
+		marked.ECN = dccp.ECNCE
+		h = &marked
+		x.amb.E(dccp.EventInfo, "Mark", h)
+		x.stats.count(&x.stats.s.Marked)
+	}
+	hh, ok := x.writeCorrupted(h)
+	if !ok {
+		x.drop("Corrupt", h)
+		return
+	}
+	if hh != h {
+		x.amb.E(dccp.EventInfo, "Corrupt", hh)
+		x.stats.count(&x.stats.s.Corrupted)
+	}
+	if x.peer != nil && x.peer.isCrashed() {
+		x.drop("Crashed", h)
+		if !answer {
+			x.peer.answer(hh)
+		}
+		return
+	}
+	x.deliver(&pipeHeader{
+		Header:      hh,
+		DeliverTime: sent + x.writeDelay(),
+		WriteTime:   now,
+		Size:        n,
+		Source:      source,
+		Dest:        dest,
+	})
+}
+
+// drop logs that h is dropped for cause, and counts it in the stats
+func (x *headerHalfPipe) drop(cause string, h *dccp.Header) {
+	x.amb.E(dccp.EventDrop, cause, h)
+	x.stats.drop(cause)
+}
+
+// Stats returns the counts of the packets written to this side of the pipe, and of how the
+// pipe treated them, since it was created
+func (x *headerHalfPipe) Stats() PipeStats {
+	return x.stats.get()
 }
 
-// rateFilter returns true if another packet can be sent now without violating the rate
-// limit set by SetWriteRate
-func (x *headerHalfPipe) rateFilter() bool {
-	x.rateLk.Lock()
-	defer x.rateLk.Unlock()
-
-	now := x.env.Now()
-	gctr := now / x.rateInterval
-	if gctr != x.rateIntervalCounter {
-		x.rateIntervalCounter = gctr
-		x.rateIntervalFill = 1
-		return true
-	} else if x.rateIntervalFill < x.ratePacketsPerInterval {
-		x.rateIntervalFill++
-		return true
+// deliver sends ph to the other side of the pipe, unless it is to be held back for
+// reordering, and then the held packets whose turn it is. It is called under writeLk.
+func (x *headerHalfPipe) deliver(ph *pipeHeader) {
+	reordered := x.writeReordered()
+	if reordered && x.reorder.Packets <= 0 {
+		ph.DeliverTime += int64(x.reorder.Delay)
+		x.amb.E(dccp.EventInfo, "Reorder", ph.Header)
+		x.stats.count(&x.stats.s.Reordered)
+	}
+	if !reordered || x.reorder.Packets <= 0 {
+		x.send(ph)
+	}
+	held := x.held[:0]
+	for _, hh := range x.held {
+		if hh.left--; hh.left > 0 {
+			held = append(held, hh)
+			continue
+		}
+		// Released packets go right after ph, which the latency queue keeps in order
+		hh.ph.DeliverTime = max64(hh.ph.DeliverTime, ph.DeliverTime)
+		x.send(hh.ph)
+	}
+	x.held = held
+	if reordered && x.reorder.Packets > 0 {
+		x.amb.E(dccp.EventInfo, "Reorder", ph.Header)
+		x.stats.count(&x.stats.s.Reordered)
+		x.held = append(x.held, &heldHeader{ ph: ph, left: x.reorder.Packets })
 	}
-	return false
+}
+
+// send puts ph on the pipe, along with a later copy if it is to be duplicated, or drops it if
+// the reader is too slow to make room for it
+func (x *headerHalfPipe) send(ph *pipeHeader) {
+	if len(x.wire) >= cap(x.wire) {
+		x.drop("Slow reader", ph.Header)
+		return
+	}
+	x.amb.E(dccp.EventWrite, "", ph.Header)
+	x.wire <- ph
+	x.env.Sent(&x.peer.readT)
+	if !x.writeDuplicated() || len(x.wire) >= cap(x.wire) {
+		return
+	}
+	dup := *ph.Header
+	x.amb.E(dccp.EventInfo, "Duplicate", &dup)
+	x.stats.count(&x.stats.s.Duplicated)
+	dph := *ph
+	dph.Header, dph.DeliverTime = &dup, ph.DeliverTime+x.duplicateLag
+	x.wire <- &dph
+	x.env.Sent(&x.peer.readT)
 }
 
 // Close implements dccp.HeaderConn.Close
@@ -232,8 +661,17 @@ func (x *headerHalfPipe) Close() error {
 		x.amb.E(dccp.EventWarn, "Close EBADF")
 		return dccp.ErrBad
 	}
-	close(x.write)
+	for _, hh := range x.held {
+		x.send(hh.ph)
+	}
+	x.held = nil
 	x.write = nil
+	// A crashed endpoint tears nothing down, so the other side reads no EOF
+	if !x.isCrashed() {
+		close(x.wire)
+		x.env.Closed(&x.peer.readT)
+		x.wire = nil
+	}
 
 	x.amb.E(dccp.EventInfo, "Close")
 	return nil
@@ -250,12 +688,12 @@ func (x *headerHalfPipe) RemoteLabel() dccp.Bytes {
 }
 
 // SetReadExpire implements dccp.HeaderConn.SetReadExpire
-func (x *headerHalfPipe) SetReadExpire(nsec int64) error {
+func (x *headerHalfPipe) SetReadExpire(d time.Duration) error {
 	x.readDeadlineLk.Lock()
 	defer x.readDeadlineLk.Unlock()
-	if nsec < 0 {
+	if d < 0 {
 		panic("invalid timeout")
 	}
-	x.readDeadline = x.env.Now() + nsec
+	x.readDeadline = x.env.Now().UnixNano() + int64(d)
 	return nil
 }
@@ -0,0 +1,215 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestPathMTU checks that a connection notices when the MTU of its link shrinks mid-connection,
+// and tells the application through its MTU handler
+func TestPathMTU(t *testing.T) {
+	env, _ := NewEnv("pmtu")
+	clientConn, serverConn, hca, _ := NewClientServerPipe(env)
+
+	mtus := make(chan int, 1)
+	clientConn.SetMTUHandler(func(mtu int) { mtus <- mtu })
+	before := clientConn.GetMTU()
+	if err := clientConn.WriteSegment([]byte("hello")); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+
+	// The client learns of the smaller MTU with the next packet it receives
+	hca.SetMTU(1200)
+	if err := serverConn.WriteSegment([]byte("world")); err != nil {
+		t.Fatalf("server write (%s)", err)
+	}
+	if _, err := clientConn.ReadSegment(); err != nil {
+		t.Fatalf("client read (%s)", err)
+	}
+	select {
+	case mtu := <-mtus:
+		if mtu != before-300 || mtu != clientConn.GetMTU() {
+			t.Errorf("MTU %d, was %d, GetMTU %d", mtu, before, clientConn.GetMTU())
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("MTU handler not called")
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestMTUProbe checks that MTU probing finds the MTU of a path that drops larger packets
+// without a word, and that SetMTU overrides it
+func TestMTUProbe(t *testing.T) {
+	env, _ := NewEnv("mtuprobe")
+	// CCID3 takes the lost probes for congestion and slows down for a minute or more
+	clientConn, serverConn, _, _ := newClientServer(env, ccid2.CCID2{}, "client", "server", clientServerSetup{
+		pipe: func(clientToServer, _ *headerHalfPipe) { clientToServer.SetPathMTU(1400) },
+	})
+
+	full := clientConn.GetMTU()
+	clientConn.SetMTUProbing(true)
+	base := clientConn.GetMTU()
+	if base != full-(1500-dccp.MTUProbeBase) {
+		t.Errorf("MTU %d when probing starts, %d before", base, full)
+	}
+	if err := clientConn.WriteSegment([]byte("hello")); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+	// Probing starts once the client is in OPEN state
+	if err := serverConn.WriteSegment([]byte("world")); err != nil {
+		t.Fatalf("server write (%s)", err)
+	}
+	if _, err := clientConn.ReadSegment(); err != nil {
+		t.Fatalf("client read (%s)", err)
+	}
+
+	// Probes of 1350 bytes, of 1425 bytes three times, and of 1387 bytes bring the MTU within
+	// the search step of the path MTU in a few seconds
+	go func() {
+		for {
+			if _, err := serverConn.ReadSegment(); err != nil {
+				return
+			}
+		}
+	}()
+	var probed int
+	for t0 := env.Now(); env.Now().Sub(t0) < 60e9; {
+		clientConn.WriteSegment(make([]byte, 100))
+		env.Sleep(50e6)
+		if probed = clientConn.GetMTU(); probed > full-100-32 {
+			break
+		}
+	}
+	if probed <= base || probed > full-100 || probed < full-100-32 {
+		t.Errorf("MTU %d after probing, between %d and %d", probed, base, full)
+	}
+
+	if err := clientConn.SetMTU(1000); err != nil || clientConn.GetMTU() != 1000 {
+		t.Errorf("MTU %d after SetMTU (%v)", clientConn.GetMTU(), err)
+	}
+	clientConn.SetMTU(0)
+	if mtu := clientConn.GetMTU(); mtu != probed {
+		t.Errorf("MTU %d after SetMTU(0), expecting %d", mtu, probed)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestPathMTUICMP checks that a connection whose path MTU shrinks mid-connection, and whose
+// path answers the packets that no longer fit with Fragmentation Needed, shrinks its MTU and
+// the segment size of its CCID to match, and carries on with segments of the new size
+func TestPathMTUICMP(t *testing.T) {
+	env, _ := NewVirtualEnv("pmtu-icmp")
+	var sender *mpsSender
+	clientConn, serverConn, hca, _ := newClientServer(env, ccid2.CCID2{}, "client", "server", clientServerSetup{
+		pipe: func(clientToServer, serverToClient *headerHalfPipe) {
+			clientToServer.SetWriteLatency(20e6)
+			serverToClient.SetWriteLatency(20e6)
+		},
+		sender: func(scc dccp.SenderCongestionControl) dccp.SenderCongestionControl {
+			sender = &mpsSender{SenderCongestionControl: scc}
+			return sender
+		},
+	})
+
+	reads := make(chan int, 10000)
+	reader := env.Go(func() {
+		defer close(reads)
+		for {
+			b, err := serverConn.ReadSegment()
+			if err != nil {
+				return
+			}
+			reads <- len(b)
+		}
+	}, "test reader")
+
+	before := clientConn.GetMTU()
+	scenario := NewScenario(env)
+	scenario.At(5e9, "shrink path MTU", func() { hca.SetPathMTUICMP(1000) })
+	for scenario.Elapsed() < 10e9 {
+		if err := clientConn.WriteSegment(make([]byte, clientConn.GetMTU())); err != nil {
+			t.Fatalf("client write (%s)", err)
+		}
+	}
+	scenario.Stop()
+
+	after := clientConn.GetMTU()
+	if after != before-(1500-1000) {
+		t.Errorf("MTU %d after the path MTU shrank, was %d", after, before)
+	}
+	if mps := sender.get(); mps == 0 || mps > 1000 {
+		t.Errorf("CCID maximum packet size %d", mps)
+	}
+	if err := clientConn.SoftError(); err == nil {
+		t.Errorf("no soft error for the ICMP message")
+	}
+	if s := hca.Stats(); s.Dropped["Beyond path MTU"] == 0 {
+		t.Errorf("dropped %v", s.Dropped)
+	}
+
+	// Segments of the new size get through, rather than being dropped for good
+	clientConn.Close()
+	reader.Join()
+	var small, last int
+	for n := range reads {
+		if n == after {
+			small++
+		}
+		last = n
+	}
+	if small < 10 || last != after {
+		t.Errorf("%d segments of %d bytes, last of %d bytes", small, after, last)
+	}
+
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// mpsSender records the maximum packet size that the connection passes to its sender
+type mpsSender struct {
+	dccp.SenderCongestionControl
+	sync.Mutex
+	mps int32
+}
+
+// SetMPS implements dccp.MPSSender
+func (s *mpsSender) SetMPS(mps int32) {
+	s.Lock()
+	defer s.Unlock()
+	s.mps = mps
+}
+
+func (s *mpsSender) get() int32 {
+	s.Lock()
+	defer s.Unlock()
+	return s.mps
+}
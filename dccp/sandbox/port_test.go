@@ -0,0 +1,180 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestPort checks that the Listeners of a Port receive the connections to their service codes
+// and that Requests for other service codes are reset
+func TestPort(t *testing.T) {
+	alink, dlink := dccp.NewChanPipe()
+	p := dccp.NewPort(alink, ccid3.CCID3{})
+	l1, err := p.Listen(1)
+	if err != nil {
+		t.Fatalf("listen 1 (%s)", err)
+	}
+	l2, err := p.Listen(2, 4)
+	if err != nil {
+		t.Fatalf("listen 2 (%s)", err)
+	}
+	if _, err := p.Listen(4); !errors.Is(err, dccp.ErrInUse) {
+		t.Errorf("listen 4: expecting %s, encountered %v", dccp.ErrInUse, err)
+	}
+	s := dccp.NewStack(dlink, ccid3.CCID3{})
+
+	for _, x := range []struct {
+		sc dccp.ServiceCode
+		l  *dccp.Listener
+	}{{1, l1}, {4, l2}} {
+		d, _ := s.Dial(nil, x.sc)
+		defer d.Abort()
+		c, err := x.l.AcceptDCCP()
+		if err != nil {
+			t.Fatalf("accept %d (%s)", x.sc, err)
+		}
+		defer c.Abort()
+		if c.ServiceCode() != x.sc {
+			t.Errorf("expecting service code %d, accepted %d", x.sc, c.ServiceCode())
+		}
+	}
+
+	d, _ := s.Dial(nil, 3)
+	bad := &dccp.ResetError{Code: dccp.ResetBadServiceCode}
+	if err := d.WaitOpen(); !errors.Is(err, bad) {
+		t.Errorf("unknown service code: expecting %s, encountered %v", bad, err)
+	}
+	// Cut TIMEWAIT short
+	d.Abort()
+
+	// A closed Listener gives its service codes back to the Port
+	if err := l1.Close(); err != nil {
+		t.Errorf("close 1 (%s)", err)
+	}
+	if _, err := p.Listen(1); err != nil {
+		t.Errorf("listen 1 again (%s)", err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Errorf("close (%s)", err)
+	}
+	if _, err := l2.AcceptDCCP(); !errors.Is(err, dccp.ErrBad) {
+		t.Errorf("accept after close: expecting %s, encountered %v", dccp.ErrBad, err)
+	}
+	if _, err := p.Listen(5); !errors.Is(err, dccp.ErrBad) {
+		t.Errorf("listen after close: expecting %s, encountered %v", dccp.ErrBad, err)
+	}
+}
+
+// TestPortDial checks that a Port starts many connections over its one socket, and that the
+// connections share a remote Port without mixing up their packets
+func TestPortDial(t *testing.T) {
+	for _, x := range []struct {
+		network string
+		n       int
+	}{{"udp4", 20}, {"dccp-udp4", 1}, {"dccp4", 20}} {
+		t.Run(x.network, func(t *testing.T) { testPortDial(t, x.network, x.n) })
+	}
+}
+
+func testPortDial(t *testing.T, network string, n int) {
+	server, err := dccp.ListenPort(network, "127.0.0.1:0")
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("no raw sockets (%s)", err)
+	}
+	if err != nil {
+		t.Fatalf("server port (%s)", err)
+	}
+	defer server.Close()
+	l, err := server.Listen(9)
+	if err != nil {
+		t.Fatalf("listen (%s)", err)
+	}
+	client, err := dccp.ListenPort(network, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("client port (%s)", err)
+	}
+	defer client.Close()
+
+	accepted := make(chan *dccp.Conn, n)
+	go func() {
+		for i := 0; i < n; i++ {
+			s, err := l.AcceptDCCP()
+			if err != nil {
+				t.Errorf("accept (%s)", err)
+				close(accepted)
+				return
+			}
+			accepted <- s
+		}
+	}()
+	// The handshakes go on at the same time
+	conns := make([]*dccp.Conn, n)
+	errs := make(chan error, n)
+	for i := range conns {
+		go func(i int) {
+			c, err := client.Dial(server.Addr(), 9)
+			conns[i] = c
+			errs <- err
+		}(i)
+	}
+	for range conns {
+		if err := <-errs; err != nil {
+			t.Errorf("dial (%s)", err)
+		}
+	}
+	for _, c := range conns {
+		if c != nil {
+			defer c.Abort()
+		}
+	}
+	if t.Failed() {
+		return
+	}
+	if network == "dccp-udp4" {
+		if _, err := client.Dial(server.Addr(), 9); !errors.Is(err, dccp.ErrInUse) {
+			t.Errorf("second DCCP-UDP dial: expecting %s, encountered %v", dccp.ErrInUse, err)
+		}
+	}
+
+	// Each server connection echoes what it reads, which must be what its client wrote
+	for i := 0; i < n; i++ {
+		s, ok := <-accepted
+		if !ok {
+			return
+		}
+		defer s.Abort()
+		go func() {
+			if b, err := s.ReadSegment(); err == nil {
+				s.WriteSegment(b)
+			}
+		}()
+	}
+	for i, c := range conns {
+		go func(i int, c *dccp.Conn) {
+			if err := c.WriteSegment([]byte{byte(i)}); err != nil {
+				errs <- fmt.Errorf("write %d (%s)", i, err)
+				return
+			}
+			b, err := c.ReadSegment()
+			if err == nil && (len(b) != 1 || b[0] != byte(i)) {
+				err = fmt.Errorf("connection %d reads %v", i, b)
+			}
+			errs <- err
+		}(i, c)
+	}
+	for range conns {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestPipeQueue checks that a pipe with a drop-tail queue delivers the packets of a burst one
+// after another at the rate of the pipe, as many as the queue holds, and drops the rest
+func TestPipeQueue(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytes    bool  // Rate in bytes, rather than packets
+		packets  int   // Queue limit in packets
+		qbytes   int64 // Queue limit in bytes
+		expected int   // Number of packets delivered
+	}{
+		{"packet-rate", false, 10, 0, 10 + 1},
+		{"byte-rate-packets", true, 10, 0, 10},
+		{"byte-rate-bytes", true, 0, 5000, 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env, _ := NewVirtualEnv("queue-pipe-" + test.name)
+			llog := dccp.NewAmb("line", env)
+			hca, hcb, _ := NewPipe(env, llog, "client", "server")
+			// 100 packets per second, or 1000-byte packets at 100 KB/sec, so 10 ms a packet
+			if test.bytes {
+				hca.SetWriteRateBytes(1e9, 100000)
+			} else {
+				hca.SetWriteRate(10e6, 1)
+			}
+			hca.SetWriteQueue(test.packets, test.qbytes)
+
+			arrivals := make(chan time.Time, 100)
+			reader := env.Go(func() {
+				defer close(arrivals)
+				for {
+					hcb.SetReadExpire(1e9)
+					if _, err := hcb.Read(); err != nil {
+						return
+					}
+					arrivals <- env.Now()
+				}
+			}, "test reader")
+
+			// Write a burst of 1000-byte packets
+			t0 := env.Now()
+			for i := 0; i < 30; i++ {
+				h := &dccp.Header{Type: dccp.Data, X: true, SeqNo: int64(i)}
+				n, _ := h.Footprint()
+				h.Data = make([]byte, 1000-n)
+				hca.Write(h)
+				// Let the reader take the packet off the pipe, without letting time pass
+				for len(hca.write) > 0 {
+					env.Now()
+					runtime.Gosched()
+				}
+			}
+			hca.Close()
+			reader.Join()
+
+			var received int
+			var last time.Duration
+			for a := range arrivals {
+				received++
+				last = a.Sub(t0)
+			}
+			// Under a packet rate, the burst may straddle two intervals and fit one more
+			if received < test.expected || received > test.expected+1 {
+				t.Errorf("received %d packets, expected %d", received, test.expected)
+			}
+			// The queue holds the packets back, to within the interval of a packet rate
+			due := time.Duration(test.expected-1) * 10 * time.Millisecond
+			if test.bytes {
+				due += 10 * time.Millisecond
+			}
+			if last < due-10*time.Millisecond || last > due+time.Millisecond {
+				t.Errorf("last packet in %d ms, expected in %d ms", last/time.Millisecond, due/time.Millisecond)
+			}
+			hcb.Close()
+			if err := env.Close(); err != nil {
+				t.Errorf("error closing runtime (%s)", err)
+			}
+		})
+	}
+}
+
+// TestQueue checks that a congestion controller filling the queue of a bottleneck sees the
+// delay of the path grow well beyond its latency, as in bufferbloat, while the queue bounds it
+func TestQueue(t *testing.T) {
+	delay := &pipeDelay{written: make(map[int64]int64)}
+	env, _ := NewVirtualEnv("queue", delay)
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	const latency = 10 * time.Millisecond
+	clientToServer.SetWriteLatency(latency)
+	serverToClient.SetWriteLatency(latency)
+	// 200 packets per second into a queue of 40 packets, or up to 200 ms of queueing delay
+	clientToServer.SetWriteRate(5e6, 1)
+	clientToServer.SetWriteQueue(40, 0)
+
+	const duration = 5 * time.Second
+	env.Go(func() {
+		buf := make([]byte, 100)
+		t0 := env.Now()
+		for env.Now().Sub(t0) < duration {
+			if err := clientConn.WriteSegment(buf); err != nil {
+				break
+			}
+		}
+		clientConn.Close()
+	}, "test client")
+	for {
+		if _, err := serverConn.ReadSegment(); err != nil {
+			break
+		}
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+	if max := time.Duration(delay.max()); max < latency+100*time.Millisecond || max > latency+200*time.Millisecond {
+		t.Errorf("maximum delay %d ms, expected between %d and %d ms",
+			max/time.Millisecond, (latency+100*time.Millisecond)/time.Millisecond, (latency+200*time.Millisecond)/time.Millisecond)
+	}
+}
+
+// pipeDelay is a dccp.TraceWriter that keeps the longest time that a packet takes from the
+// client's side of the pipe to the server's
+type pipeDelay struct {
+	sync.Mutex
+	written  map[int64]int64 // Time when each packet was written, by sequence number
+	maxDelay int64
+}
+
+func (x *pipeDelay) Write(r *dccp.Trace) {
+	if len(r.Labels) != 2 || r.Labels[0] != "line" {
+		return
+	}
+	x.Lock()
+	defer x.Unlock()
+	switch {
+	case r.Labels[1] == "client" && r.Event == dccp.EventWrite:
+		x.written[r.SeqNo] = r.Time
+	case r.Labels[1] == "server" && r.Event == dccp.EventRead:
+		if w, ok := x.written[r.SeqNo]; ok && r.Time-w > x.maxDelay {
+			x.maxDelay = r.Time - w
+		}
+	}
+}
+
+func (x *pipeDelay) max() int64 {
+	x.Lock()
+	defer x.Unlock()
+	return x.maxDelay
+}
+
+func (x *pipeDelay) Sync() error  { return nil }
+func (x *pipeDelay) Close() error { return nil }
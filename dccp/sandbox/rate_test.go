@@ -5,15 +5,21 @@
 package sandbox
 
 import (
+	"errors"
 	//"fmt"
 	"testing"
+	"time"
+
 	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+	"github.com/petar/GoDCCP/dccp/ccid3"
 )
 
 const (
-	rateDuration           = 10e9   // Duration of rate test
-	rateInterval           = 1e9
+	rateDuration           = 10 * time.Second // Duration of rate test
+	rateInterval           = time.Second
 	ratePacketsPerInterval = 50
+	rateMaxDropRate        = 0.5 // Most packets that the limit may drop, for condition (2.b)
 )
 
 // TestRate tests whether a single connection's one-way client-to-server rate converges to
@@ -24,12 +30,26 @@ const (
 //		(2.b) or be closely above the connection limit (and maintain a drop rate below some threshold)
 // A two-way test is not necessary as the congestion mechanisms in either direction are completely independent.
 //
-// NOTE: Pipe currently supports rate simulation in packets per time interval. If we want to test behavior
-// under variable packet sizes, we need to implement rate simulation in bytes per interval.
+// The limit is in packets per time interval. TestRateBytes limits the rate in bytes per interval instead.
 func TestRate(t *testing.T) {
+	testRate(t, "rate", ccid3.CCID3{})
+}
+
+// TestRateCCID2 runs the rate test with the window-based CCID2 in place of the rate-based CCID3
+func TestRateCCID2(t *testing.T) {
+	testRate(t, "rate-ccid2", ccid2.CCID2{})
+}
 
-	env, _ := NewEnv("rate")
-	clientConn, serverConn, clientToServer, _ := NewClientServerPipe(env)
+// TestRateCCID4 runs the rate test with CCID4, whose sending rate is capped at 100 packets per second
+func TestRateCCID4(t *testing.T) {
+	testRate(t, "rate-ccid4", ccid3.CCID4{})
+}
+
+func testRate(t *testing.T, name string, ccid dccp.CCID) {
+
+	matches := NewMatchTrace(t)
+	env, _ := NewEnv(name, matches)
+	clientConn, serverConn, clientToServer, _ := NewClientServerPipeCCID(env, ccid)
 
 	// Set rate limit on client-to-server connection
 	clientToServer.SetWriteRate(rateInterval, ratePacketsPerInterval)
@@ -39,8 +59,8 @@ func TestRate(t *testing.T) {
 	buf := make([]byte, mtu)
 	env.Go(func() {
 		t0 := env.Now()
-		for env.Now() - t0 < rateDuration {
-			err := clientConn.Write(buf)
+		for env.Now().Sub(t0) < rateDuration {
+			err := clientConn.WriteSegment(buf)
 			if err != nil {
 				t.Errorf("error writing (%s)", err)
 				break
@@ -55,8 +75,8 @@ func TestRate(t *testing.T) {
 	schan := make(chan int, 1)
 	env.Go(func() {
 		for {
-			_, err := serverConn.Read()
-			if err == dccp.ErrEOF {
+			_, err := serverConn.ReadSegment()
+			if errors.Is(err, dccp.ErrEOF) {
 				break 
 			} else if err != nil {
 				t.Errorf("error reading (%s)", err)
@@ -74,8 +94,89 @@ func TestRate(t *testing.T) {
 	serverConn.Abort()
 
 	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if stats := clientToServer.Stats(); stats.DropRate() > rateMaxDropRate {
+		t.Errorf("the rate limit dropped %d of %d packets (%v)", stats.DroppedTotal(), stats.Offered, stats.Dropped)
+	}
 	dccp.NewAmb("line", env).E(dccp.EventMatch, "Server and client done.")
 	if err := env.Close(); err != nil {
 		t.Errorf("error closing runtime (%s)", err)
 	}
+
+	// Each side opens its CCID once, and closes it only after, before the test is done
+	matches.ExpectCount(`^line: Server and client done\.$`, 1)
+	for _, side := range []string{"client", "server"} {
+		matches.ExpectCount(`^`+side+`: CCID open$`, 1)
+		matches.ExpectOrder(`^`+side+`: CCID open$`, `^`+side+`: CCID close$`)
+		matches.ExpectOrder(`^`+side+`: CCID close$`, `^line: Server and client done\.$`)
+	}
+}
+
+// TestRateBytes checks that a pipe limited in bytes per interval delivers each packet once its
+// last byte is sent, whatever the packet sizes, and drops packets beyond an interval's worth
+func TestRateBytes(t *testing.T) {
+	env, _ := NewVirtualEnv("rate-bytes")
+	llog := dccp.NewAmb("line", env)
+	hca, hcb, _ := NewPipe(env, llog, "client", "server")
+	// 100 KB/sec, or 10 ms for every 1000 bytes
+	hca.SetWriteRateBytes(100e6, 10000)
+
+	type arrival struct {
+		n int
+		t time.Time
+	}
+	arrivals := make(chan arrival, 100)
+	reader := env.Go(func() {
+		hcb.SetReadExpire(1e9)
+		for {
+			h, err := hcb.Read()
+			if err != nil {
+				close(arrivals)
+				return
+			}
+			n, _ := h.Footprint()
+			arrivals <- arrival{n, env.Now()}
+		}
+	}, "test reader")
+
+	// Write packets of alternating sizes, 30 KB in all, at once
+	t0 := env.Now()
+	var written int
+	for i := 0; i < 40; i++ {
+		h := &dccp.Header{Type: dccp.Data, X: true, SeqNo: int64(i), Data: make([]byte, 250+i%2*1000)}
+		n, _ := h.Footprint()
+		written += n
+		if err := hca.Write(h); err != nil {
+			t.Fatalf("write (%s)", err)
+		}
+		// Give the reader time to take the packet off the pipe
+		env.Sleep(1e5)
+	}
+	// The pipe sends this much while the packets are written
+	sending := int64(env.Now().Sub(t0)) * 10000 / 100e6
+	reader.Join()
+
+	// Packets arrive back to back at the byte rate, until the queue of 100 ms worth is full.
+	// None arrives early, or late.
+	var received int
+	var last, lastDue time.Duration
+	for a := range arrivals {
+		received += a.n
+		at, due := a.t.Sub(t0), time.Duration(received)*100*time.Millisecond/10000
+		if at < due-time.Millisecond {
+			t.Errorf("%d bytes in %d ms, expected in %d ms", received, at/time.Millisecond, due/time.Millisecond)
+		}
+		last, lastDue = at, due
+	}
+	if last > lastDue+time.Millisecond {
+		t.Errorf("last packet in %d ms, expected in %d ms", last/time.Millisecond, lastDue/time.Millisecond)
+	}
+	if received < 10000 || int64(received) > 10000+1250+sending || received == written {
+		t.Errorf("received %d bytes of %d, expected about %d", received, written, 10000+sending)
+	}
+
+	hca.Close()
+	hcb.Close()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
 }
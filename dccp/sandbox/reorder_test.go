@@ -0,0 +1,127 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestPipeReorder checks that a pipe that reorders packets delivers all of them, each no more
+// than the requested distance out of order
+func TestPipeReorder(t *testing.T) {
+	tests := []struct {
+		name    string
+		reorder Reorder
+		maxLate int // Most later packets that a packet may be delivered after
+	}{
+		{"packets", Reorder{Fraction: 0.2, Packets: 3}, 3},
+		// Packets are written 2 ms apart
+		{"delay", Reorder{Fraction: 0.1, Delay: 20e6}, 12},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env, _ := NewVirtualEnv("reorder-" + test.name)
+			llog := dccp.NewAmb("line", env)
+			hca, hcb, _ := NewPipe(env, llog, "client", "server")
+			hca.SetWriteRate(1e9, 10000)
+			hca.SetWriteReorder(test.reorder)
+
+			const n = 200
+			seqNos := make(chan int64, n)
+			reader := env.Go(func() {
+				defer close(seqNos)
+				for {
+					hcb.SetReadExpire(1e9)
+					h, err := hcb.Read()
+					if err != nil {
+						return
+					}
+					seqNos <- h.SeqNo
+				}
+			}, "test reader")
+			for i := 0; i < n; i++ {
+				hca.Write(&dccp.Header{Type: dccp.Data, X: true, SeqNo: int64(i)})
+				env.Sleep(2e6)
+			}
+			hca.Close()
+			reader.Join()
+
+			var got []int64
+			for s := range seqNos {
+				got = append(got, s)
+			}
+			if len(got) != n {
+				t.Fatalf("received %d packets, expected %d", len(got), n)
+			}
+			var reordered int
+			for i, s := range got {
+				var late int
+				for _, r := range got[:i] {
+					if r > s {
+						late++
+					}
+				}
+				if late > test.maxLate {
+					t.Errorf("packet %d delivered after %d later ones", s, late)
+				}
+				if late > 0 {
+					reordered++
+				}
+			}
+			if float64(reordered) < n*test.reorder.Fraction/2 {
+				t.Errorf("%d packets reordered", reordered)
+			}
+			hcb.Close()
+			if err := env.Close(); err != nil {
+				t.Errorf("error closing runtime (%s)", err)
+			}
+		})
+	}
+}
+
+// TestReorder checks that a connection whose packets are reordered in both directions
+// keeps delivering data, with the Ack Vectors of CCID2 in use
+func TestReorder(t *testing.T) {
+	env, _ := NewEnv("reorder")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	clientToServer.SetWriteReorder(Reorder{Fraction: 0.1, Packets: 2})
+	serverToClient.SetWriteReorder(Reorder{Fraction: 0.1, Packets: 2})
+
+	// The pipe drops some packets, when the server falls behind reading them
+	const n = 100
+	env.Go(func() {
+		for i := 0; i < n; i++ {
+			if err := clientConn.WriteSegment([]byte{byte(i)}); err != nil {
+				t.Errorf("client write (%s)", err)
+				return
+			}
+		}
+	}, "test client")
+	var received int
+	serverConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for received < n {
+		if _, err := serverConn.ReadSegment(); err != nil {
+			break
+		}
+		received++
+	}
+	if received < n/2 {
+		t.Errorf("server received %d segments of %d", received, n)
+	}
+	if err := clientConn.Error(); err != nil {
+		t.Errorf("client connection failed (%s)", err)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
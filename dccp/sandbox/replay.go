@@ -0,0 +1,219 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// Replay implements dccp.HeaderConn. It plays back the packets that one side of a captured
+// DCCP session sent, with their original timing, to the connection under test, which takes
+// the place of the other side. Replays turn captures of interoperability bugs into
+// regression tests.
+//
+// The connection under test picks its own initial sequence number, so the acknowledgement
+// numbers of the captured packets are off. Replay shifts them by the difference between the
+// first sequence number that the connection writes and the first one that the other side
+// sent in the capture. Packets played before the connection writes anything are unchanged.
+type Replay struct {
+	env   *dccp.Env
+	amb   *dccp.Amb
+	done  chan struct{}
+	doneT dccp.Track // Accounts for done on virtual time
+
+	sync.Mutex
+	play         []*PcapPacket // Packets of the replayed side, in order
+	next         int           // Index in play of the next packet to deliver
+	t0           int64         // Capture time of the first packet of the session
+	start        int64         // Time of the start of the replay
+	otherSeqNo   int64         // First sequence number that the other side sent in the capture
+	offset       int64         // Shift of acknowledgement numbers, once it is known
+	offsetKnown  bool
+	readDeadline int64
+	written      []*dccp.Header // Headers written by the connection under test
+}
+
+// ErrNoRequest is returned by NewReplay for captures without a Request
+var ErrNoRequest = errors.New("capture has no Request")
+
+// NewReplay returns a Replay of the session in packets, which starts at once. If client is
+// true, the packets of the side that sent the first Request are played back, and the
+// connection under test is a server; otherwise, the packets of the other side are played
+// back to a client. Packets that belong to neither side of the first Request are ignored.
+// Captures that save each packet twice, like those of dccp.PcapWriter with both sides in
+// one Env, should be narrowed to one Interface first.
+func NewReplay(env *dccp.Env, amb *dccp.Amb, packets []*PcapPacket, client bool) (*Replay, error) {
+	var clientIP, serverIP net.IP
+	var clientPort, serverPort uint16
+	for _, p := range packets {
+		if p.Header.Type == dccp.Request {
+			clientIP, clientPort = p.SrcIP, p.Header.SourcePort
+			serverIP, serverPort = p.DstIP, p.Header.DestPort
+			break
+		}
+	}
+	if clientIP == nil {
+		return nil, ErrNoRequest
+	}
+	r := &Replay{
+		env:          env,
+		amb:          amb,
+		done:         make(chan struct{}),
+		start:        env.Now().UnixNano(),
+		readDeadline: env.Now().UnixNano() - 1e9,
+	}
+	r.t0 = -1
+	other := false
+	for _, p := range packets {
+		fromClient := p.SrcIP.Equal(clientIP) && p.Header.SourcePort == clientPort &&
+			p.DstIP.Equal(serverIP) && p.Header.DestPort == serverPort
+		fromServer := p.SrcIP.Equal(serverIP) && p.Header.SourcePort == serverPort &&
+			p.DstIP.Equal(clientIP) && p.Header.DestPort == clientPort
+		if !fromClient && !fromServer {
+			continue
+		}
+		if r.t0 < 0 {
+			r.t0 = p.Time
+		}
+		if fromClient == client {
+			r.play = append(r.play, p)
+		} else if !other {
+			r.otherSeqNo, other = p.Header.SeqNo, true
+		}
+	}
+	return r, nil
+}
+
+// Written returns the headers that the connection under test has written so far
+func (r *Replay) Written() []*dccp.Header {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*dccp.Header(nil), r.written...)
+}
+
+// Remaining returns the number of packets that are yet to be played back
+func (r *Replay) Remaining() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.play) - r.next
+}
+
+// GetMTU implements dccp.HeaderConn.GetMTU
+func (r *Replay) GetMTU() int {
+	return 1500
+}
+
+// Read implements dccp.HeaderConn.Read. It returns the next packet when its time comes.
+func (r *Replay) Read() (*dccp.Header, error) {
+	for {
+		r.Lock()
+		select {
+		case <-r.done:
+			r.Unlock()
+			return nil, dccp.ErrEOF
+		default:
+		}
+		now := r.env.Now().UnixNano()
+		var wait int64 = -1 // Negative stands for no packet to wait for
+		if r.next < len(r.play) {
+			p := r.play[r.next]
+			if wait = r.start + p.Time - r.t0 - now; wait <= 0 {
+				r.next++
+				h := r.shift(p.Header)
+				r.Unlock()
+				r.amb.E(dccp.EventRead, fmt.Sprintf("Replay SeqNo=%d", h.SeqNo), h)
+				return h, nil
+			}
+		}
+		if r.readDeadline > 0 {
+			timeout := r.readDeadline - now
+			if timeout <= 0 {
+				r.Unlock()
+				return nil, dccp.ErrTimeout
+			}
+			if wait < 0 || timeout < wait {
+				wait = timeout
+			}
+		}
+		r.Unlock()
+
+		if wait < 0 {
+			r.env.Wait(&r.doneT)
+			<-r.done
+			r.env.Woken(&r.doneT)
+			continue
+		}
+		r.env.SleepOrDone(time.Duration(wait), r.done, &r.doneT)
+	}
+}
+
+// shift returns a copy of the captured header h, with its acknowledgement number shifted to
+// the sequence numbers of the connection under test
+func (r *Replay) shift(h *dccp.Header) *dccp.Header {
+	hh := *h
+	if r.offsetKnown && h.Type != dccp.Request && h.Type != dccp.Data {
+		hh.AckNo += r.offset
+	}
+	return &hh
+}
+
+// Write implements dccp.HeaderConn.Write. It keeps h for Written.
+func (r *Replay) Write(h *dccp.Header) error {
+	r.Lock()
+	defer r.Unlock()
+	select {
+	case <-r.done:
+		return dccp.ErrBad
+	default:
+	}
+	if !r.offsetKnown {
+		r.offset, r.offsetKnown = h.SeqNo-r.otherSeqNo, true
+	}
+	r.written = append(r.written, h)
+	r.amb.E(dccp.EventWrite, "", h)
+	return nil
+}
+
+// LocalLabel implements dccp.HeaderConn.LocalLabel
+func (r *Replay) LocalLabel() dccp.Bytes {
+	return &dccp.Label{}
+}
+
+// RemoteLabel implements dccp.HeaderConn.RemoteLabel
+func (r *Replay) RemoteLabel() dccp.Bytes {
+	return &dccp.Label{}
+}
+
+// SetReadExpire implements dccp.HeaderConn.SetReadExpire
+func (r *Replay) SetReadExpire(d time.Duration) error {
+	if d < 0 {
+		return dccp.ErrInvalid
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.readDeadline = r.env.Now().UnixNano() + int64(d)
+	return nil
+}
+
+// Close implements dccp.HeaderConn.Close
+func (r *Replay) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	select {
+	case <-r.done:
+		return dccp.ErrBad
+	default:
+	}
+	close(r.done)
+	r.env.Closed(&r.doneT)
+	r.amb.E(dccp.EventInfo, "Close")
+	return nil
+}
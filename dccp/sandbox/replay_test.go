@@ -0,0 +1,79 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestReplay records a session to a pcapng capture, and plays the client side of it back to
+// a new server, which must accept the connection and read the data of the client
+func TestReplay(t *testing.T) {
+	// Record
+	env, _ := NewEnv("replay-record")
+	var buf bytes.Buffer
+	w, err := dccp.NewPcapWriter(&buf)
+	if err != nil {
+		t.Fatalf("pcap writer (%s)", err)
+	}
+	env.SetPcap(w)
+	clientConn, serverConn, _, _ := NewClientServerPipe(env)
+	if err := clientConn.WriteSegment([]byte("hello")); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+	clientConn.Close()
+	serverConn.Close()
+	env.NewGoJoin("end-of-record", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+
+	packets, err := ReadPcap(&buf)
+	if err != nil {
+		t.Fatalf("read pcap (%s)", err)
+	}
+	// Both sides saved the packets they sent and received, on an interface of their own
+	var client []*PcapPacket
+	for _, p := range packets {
+		if p.Interface == 0 {
+			client = append(client, p)
+		}
+	}
+	if len(client) < 4 || len(client) == len(packets) {
+		t.Fatalf("%d packets on the client interface, %d in all", len(client), len(packets))
+	}
+
+	// Replay
+	env, _ = NewEnv("replay")
+	amb := dccp.NewAmb("replay", env)
+	replay, err := NewReplay(env, amb, client, true)
+	if err != nil {
+		t.Fatalf("replay (%s)", err)
+	}
+	ccid := ccid3.CCID3{}
+	slog := dccp.NewAmb("server", env)
+	serverConn = dccp.NewConnServer(env, slog, replay, ccid.NewSender(env, slog), ccid.NewReceiver(env, slog))
+	serverConn.SetTimewait(SandboxTimewait)
+	b, err := serverConn.ReadSegment()
+	if err != nil || string(b) != "hello" {
+		t.Errorf("server read %q (%v)", b, err)
+	}
+	if w := replay.Written(); len(w) == 0 || w[0].Type != dccp.Response {
+		t.Errorf("server wrote %d headers, not starting with a Response", len(w))
+	}
+
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
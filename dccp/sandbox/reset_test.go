@@ -0,0 +1,59 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// TestReset checks that an application can reset a connection with a Reset Code and reason of
+// its choice, and that the other side sees both in its connection error
+func TestReset(t *testing.T) {
+	env, _ := NewEnv("reset")
+	clientConn, serverConn, _, _ := NewClientServerPipe(env)
+
+	if err := clientConn.WriteSegment([]byte{1}); err != nil {
+		t.Fatalf("client write (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); err != nil {
+		t.Fatalf("server read (%s)", err)
+	}
+
+	if err := clientConn.Reset(12, ""); !errors.Is(err, dccp.ErrInvalid) {
+		t.Errorf("accepted a reserved reset code")
+	}
+	if err := clientConn.Reset(dccp.ResetAborted, "\xff"); !errors.Is(err, dccp.ErrInvalid) {
+		t.Errorf("accepted a reason that is not UTF-8")
+	}
+	if err := clientConn.Reset(200, "going away"); err != nil {
+		t.Fatalf("reset (%s)", err)
+	}
+	if err := clientConn.Reset(200, "again"); !errors.Is(err, dccp.ErrBad) {
+		t.Errorf("reset a closed connection")
+	}
+	if _, err := clientConn.ReadSegment(); !errors.Is(err, dccp.ErrAbort) {
+		t.Errorf("client: expecting %s, encountered %v", dccp.ErrAbort, err)
+	}
+	_, err := serverConn.ReadSegment()
+	var re *dccp.ResetError
+	if !errors.As(err, &re) {
+		t.Fatalf("server: expecting a reset error, encountered %v", err)
+	}
+	if re.Code != 200 || re.Reason != "going away" {
+		t.Errorf("server: expecting reset 200 \"going away\", encountered %d %q", re.Code, re.Reason)
+	}
+	if !errors.Is(err, dccp.ErrAbort) {
+		t.Errorf("server: reset error is not an abort")
+	}
+
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
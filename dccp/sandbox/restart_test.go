@@ -0,0 +1,82 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestFasterRestart checks that a CCID3 sender with Faster Restart on regains the rate it had
+// before an idle period faster than a plain one, once the application resumes sending
+func TestFasterRestart(t *testing.T) {
+	_, plain := runRestart(t, ccid3.CCID3{})
+	active, faster := runRestart(t, ccid3.CCID3{FasterRestart: true})
+	// While the sender is data-limited, the allowed rate is held to twice the receive rate, or
+	// to four times the receive rate with Faster Restart, up to the rate before the idle period
+	if plain.X > 2*plain.XRecv {
+		t.Errorf("allowed rate %d bytes/sec for receive rate %d without Faster Restart", plain.X, plain.XRecv)
+	}
+	if faster.X < 3*faster.XRecv || faster.X > active.X {
+		t.Errorf("allowed rate %d bytes/sec with Faster Restart, expected between %d and %d",
+			faster.X, 3*faster.XRecv, active.X)
+	}
+	if faster.X <= plain.X {
+		t.Errorf("allowed rate %d bytes/sec with Faster Restart, %d without", faster.X, plain.X)
+	}
+}
+
+// runRestart sends data from the client to the server of a CCID3 connection over a pipe that
+// drops packets beyond a limit, keeps the connection idle for a few seconds and then resumes
+// sending at a rate below the one the sender allows. It returns the state of the sender before
+// the idle period and a second into the resumed sending.
+func runRestart(t *testing.T, ccid ccid3.CCID3) (active, restarted ccid3.SenderState) {
+	env, _ := NewVirtualEnv("restart")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid)
+	clientToServer.SetWriteLatency(50e6)
+	serverToClient.SetWriteLatency(50e6)
+	clientToServer.SetWriteRate(100*time.Millisecond, 20)
+	// Packets of a full segment let the rate in bytes grow along with the rate in packets
+	clientToServer.SetMTU(ccid3.FixedSegmentSize + 100)
+
+	reader := env.Go(func() {
+		for {
+			if _, err := serverConn.ReadSegment(); err != nil {
+				return
+			}
+		}
+	}, "test reader")
+	buf := make([]byte, clientConn.GetMTU())
+	t0 := env.Now()
+	for env.Now().Sub(t0) < 5*time.Second {
+		if err := clientConn.WriteSegment(buf); err != nil {
+			t.Fatalf("writing (%s)", err)
+		}
+	}
+	active, _ = ccid3.GetSenderState(clientConn)
+	if active.LossEvents == 0 {
+		t.Errorf("no loss events before the idle period")
+	}
+
+	env.Sleep(3e9)
+	for i := 0; i < 10; i++ {
+		if err := clientConn.WriteSegment(buf); err != nil {
+			t.Fatalf("writing (%s)", err)
+		}
+		env.Sleep(100e6)
+	}
+	restarted, _ = ccid3.GetSenderState(clientConn)
+
+	clientConn.Abort()
+	reader.Join()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+	return active, restarted
+}
@@ -9,6 +9,7 @@ import (
 	"math"
 	"testing"
 	"os"
+	"time"
 	"github.com/petar/GoDCCP/dccp"
 	"github.com/petar/GoDCCP/dccp/ccid3"
 )
@@ -57,8 +58,8 @@ func TestRoundtripEstimation(t *testing.T) {
 	cchan := make(chan int, 1)
 	env.Go(func() {
 		t0 := env.Now()
-		for env.Now() - t0 < roundtripDuration {
-			err := clientConn.Write(buf)
+		for env.Now().Sub(t0) < roundtripDuration {
+			err := clientConn.WriteSegment(buf)
 			if err != nil {
 				break
 			}
@@ -72,7 +73,7 @@ func TestRoundtripEstimation(t *testing.T) {
 	schan := make(chan int, 1)
 	env.Go(func() {
 		for {
-			_, err := serverConn.Read()
+			_, err := serverConn.ReadSegment()
 			if err != nil {
 				break
 			}
@@ -93,6 +94,62 @@ func TestRoundtripEstimation(t *testing.T) {
 	}
 }
 
+// TestRoundtripJitter checks that round-trip times are estimated accurately on a path whose
+// delay varies from packet to packet, with 25 ms of latency and 5 ms of jitter each way
+func TestRoundtripJitter(t *testing.T) {
+	env, plex := NewVirtualEnv("rtt-jitter")
+	const duration = 5e9
+	checkpoint := &roundtripCheckpoint{
+		env:           env,
+		t:             t,
+		checkTimes:    []int64{duration},
+		expected:      []float64{NanoToMilli(2 * 25e6)},
+		tolerance:     []float64{0.25},
+		clientElapsed: make([]float64, 1),
+		clientReport:  make([]float64, 1),
+		serverElapsed: make([]float64, 1),
+		serverReport:  make([]float64, 1),
+	}
+	plex.Add(checkpoint)
+
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipe(env)
+	for _, hp := range []*headerHalfPipe{clientToServer, serverToClient} {
+		hp.SetWriteLatency(25e6)
+		hp.SetWriteJitter(NormalJitter{StdDev: 5 * time.Millisecond})
+	}
+	clientConn.Amb().Flags().SetUint32("FixRate", roundtripRate)
+	serverConn.Amb().Flags().SetUint32("FixRate", roundtripRate)
+
+	client := env.Go(func() {
+		buf := []byte{1, 2, 3}
+		t0 := env.Now()
+		for env.Now().Sub(t0) < duration {
+			if err := clientConn.WriteSegment(buf); err != nil {
+				break
+			}
+		}
+		clientConn.Close()
+	}, "test client")
+
+	server := env.Go(func() {
+		for {
+			if _, err := serverConn.ReadSegment(); err != nil {
+				break
+			}
+		}
+	}, "test server")
+
+	client.Join()
+	server.Join()
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
 // roundtripCheckpoint verifies that roundtrip estimates are within expected at
 // different point in time in the Roundtrip test.
 type roundtripCheckpoint struct {
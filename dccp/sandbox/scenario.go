@@ -0,0 +1,88 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"sync"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// Scenario is a script of changes to the conditions of a simulation, such as the rate,
+// latency or loss of its pipes and bottlenecks, each made at a given time. Times count from
+// the creation of the Scenario, in the time of its Env, so scenarios play out alike on real
+// and on virtual time. Each change is logged to the Env under the label "scenario", with
+// the name it is given.
+//
+//	s := NewScenario(env)
+//	s.At(5*time.Second, "halve rate", func() { clientToServer.SetWriteRate(time.Second, 50) })
+//	s.At(8*time.Second, "add delay", func() { clientToServer.SetWriteLatency(100 * time.Millisecond) })
+//	s.Between(12*time.Second, 13*time.Second, "loss burst",
+//		func() { clientToServer.SetWriteLoss(BernoulliLoss{P: 1}) },
+//		func() { clientToServer.SetWriteLoss(nil) })
+type Scenario struct {
+	env   *dccp.Env
+	amb   *dccp.Amb
+	t0    time.Time
+	done  chan struct{}
+	doneT dccp.Track // Accounts for done on virtual time
+
+	sync.Mutex
+	stopped bool
+}
+
+// NewScenario returns a Scenario without changes, whose time starts now
+func NewScenario(env *dccp.Env) *Scenario {
+	return &Scenario{
+		env:  env,
+		amb:  dccp.NewAmb("scenario", env),
+		t0:   env.Now(),
+		done: make(chan struct{}),
+	}
+}
+
+// Elapsed returns the time since the start of the scenario
+func (s *Scenario) Elapsed() time.Duration {
+	return s.env.Now().Sub(s.t0)
+}
+
+// At schedules change to be made at time t of the scenario. A change scheduled for a time
+// that has passed is made at once. Changes scheduled for the same time are made in no
+// particular order.
+func (s *Scenario) At(t time.Duration, name string, change func()) {
+	s.env.Go(func() {
+		if wait := t - s.Elapsed(); wait > 0 && !s.env.SleepOrDone(wait, s.done, &s.doneT) {
+			return
+		}
+		s.Lock()
+		stopped := s.stopped
+		s.Unlock()
+		if stopped {
+			return
+		}
+		s.amb.E(dccp.EventInfo, name)
+		change()
+	}, "scenario %s", name)
+}
+
+// Between schedules start to be made at time from of the scenario, and end at time to, such
+// as the start and the end of a burst of loss or an outage
+func (s *Scenario) Between(from, to time.Duration, name string, start, end func()) {
+	s.At(from, name+" start", start)
+	s.At(to, name+" end", end)
+}
+
+// Stop cancels the changes that have not been made yet. It is safe to stop a scenario more
+// than once.
+func (s *Scenario) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.done)
+		s.env.Closed(&s.doneT)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestScenario checks that a CCID2 connection adapts to a bottleneck whose rate halves in the
+// middle of the connection, and that the changes of a scenario are made at their times
+func TestScenario(t *testing.T) {
+	meter := NewFlowMeter()
+	changes := &scenarioLog{}
+	env, _ := NewVirtualEnv("scenario", meter, changes)
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	clientToServer.SetWriteLatency(10e6)
+	serverToClient.SetWriteLatency(10e6)
+	// 200 packets per second, with a queue of 40 packets, then 100 packets per second
+	clientToServer.SetWriteRate(5e6, 1)
+	clientToServer.SetWriteQueue(40, 0)
+
+	const duration = 12 * time.Second
+	s := NewScenario(env)
+	s.At(6e9, "halve rate", func() {
+		clientToServer.SetWriteRate(10e6, 1)
+		clientToServer.SetWriteQueue(40, 0)
+	})
+	s.At(2*duration, "too late", func() {})
+
+	t0 := env.Now()
+	env.Go(func() {
+		buf := make([]byte, 100)
+		for env.Now().Sub(t0) < duration {
+			if err := clientConn.WriteSegment(buf); err != nil {
+				break
+			}
+		}
+		clientConn.Close()
+	}, "test client")
+	for {
+		if _, err := serverConn.ReadSegment(); err != nil {
+			break
+		}
+	}
+	s.Stop()
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+
+	made := changes.get()
+	if len(made) != 1 || made[0].Comment != "halve rate" || made[0].Time < 6e9 || made[0].Time > 6e9+10e6 {
+		t.Errorf("changes made: %v", made)
+	}
+	// Leave out a second after each change of rate, for the connection to adapt
+	if x := meter.Throughput("server", 1e9, 6e9); x < 200*0.8 || x > 200*1.1 {
+		t.Errorf("%0.1f packets per second before the change, expected 200", x)
+	}
+	if x := meter.Throughput("server", 7e9, duration); x < 100*0.8 || x > 100*1.1 {
+		t.Errorf("%0.1f packets per second after the change, expected 100", x)
+	}
+}
+
+// scenarioLog is a dccp.TraceWriter that keeps the changes that scenarios make
+type scenarioLog struct {
+	sync.Mutex
+	made []*dccp.Trace
+}
+
+func (x *scenarioLog) Write(r *dccp.Trace) {
+	if len(r.Labels) != 1 || r.Labels[0] != "scenario" {
+		return
+	}
+	x.Lock()
+	defer x.Unlock()
+	x.made = append(x.made, r)
+}
+
+func (x *scenarioLog) get() []*dccp.Trace {
+	x.Lock()
+	defer x.Unlock()
+	return append([]*dccp.Trace(nil), x.made...)
+}
+
+func (x *scenarioLog) Sync() error  { return nil }
+func (x *scenarioLog) Close() error { return nil }
@@ -0,0 +1,71 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// TestSeed checks that simulations with the same seed make the same random choices: the
+// initial sequence number of the client and the packets that a lossy pipe loses
+func TestSeed(t *testing.T) {
+	run := func(seed int64) []int64 {
+		choices := &seedChoices{}
+		env, _ := NewEnv("seed", choices)
+		env.SetSeed(seed)
+		clientConn, serverConn, clientToServer, _ := NewClientServerPipe(env)
+		clientToServer.SetWriteLoss(BernoulliLoss{P: 0.3})
+		// The client sends its Request with the loss in place, and resends it
+		env.Sleep(2e9)
+		clientConn.Abort()
+		serverConn.Abort()
+		env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+		if err := env.Close(); err != nil {
+			t.Errorf("error closing runtime (%s)", err)
+		}
+		return choices.get()
+	}
+	a, b, c := run(7), run(7), run(8)
+	if len(a) == 0 || !reflect.DeepEqual(a, b) {
+		t.Errorf("runs with the same seed differ: %v and %v", a, b)
+	}
+	if reflect.DeepEqual(a, c) {
+		t.Errorf("runs with different seeds agree: %v", a)
+	}
+}
+
+// seedChoices is a dccp.TraceWriter that keeps the sequence numbers of the Requests that the
+// client's side of the pipe delivers, and of those that it loses, negated
+type seedChoices struct {
+	sync.Mutex
+	choices []int64
+}
+
+func (x *seedChoices) Write(r *dccp.Trace) {
+	if len(r.Labels) != 2 || r.Labels[0] != "line" || r.Labels[1] != "client" || r.Type != "Request" {
+		return
+	}
+	x.Lock()
+	defer x.Unlock()
+	switch {
+	case r.Event == dccp.EventWrite:
+		x.choices = append(x.choices, r.SeqNo)
+	case r.Event == dccp.EventDrop && r.Comment == "Lost":
+		x.choices = append(x.choices, -r.SeqNo)
+	}
+}
+
+func (x *seedChoices) get() []int64 {
+	x.Lock()
+	defer x.Unlock()
+	return append([]int64(nil), x.choices...)
+}
+
+func (x *seedChoices) Sync() error  { return nil }
+func (x *seedChoices) Close() error { return nil }
@@ -0,0 +1,39 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestServiceCodes checks that the server picks the first of the client's service codes that it
+// provides, once the ServiceCodesOption is turned on
+func TestServiceCodes(t *testing.T) {
+	dccp.EnableServiceCodesOption(true)
+	t.Cleanup(func() { dccp.EnableServiceCodesOption(false) })
+	env, _ := NewEnv("servicecodes")
+	clientConn, serverConn, _, _ := newClientServer(env, ccid3.CCID3{}, "client", "server", clientServerSetup{
+		clientCodes: []dccp.ServiceCode{1, 2, 3},
+		serverCodes: []dccp.ServiceCode{5, 3, 2},
+	})
+
+	env.Sleep(2e9)
+	if sc := clientConn.ServiceCode(); sc != 2 {
+		t.Errorf("client: expecting service code 2, encountered %d", sc)
+	}
+	if sc := serverConn.ServiceCode(); sc != 2 {
+		t.Errorf("server: expecting service code 2, encountered %d", sc)
+	}
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
@@ -0,0 +1,410 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// Simulation describes a simulation of flows through a Topology, as read from a JSON file by
+// ReadSimulation, so that experiments can be run and shared without writing Go code. For
+// instance, two CCID2 flows through a bottleneck whose rate halves after ten seconds:
+//
+//	{
+//		"Name": "halve",
+//		"Duration": "20s",
+//		"Virtual": true,
+//		"CCID": "ccid2",
+//		"Forward": {"Rate": 200, "QueuePackets": 40},
+//		"Flows": [{"Latency": "10ms"}, {"Latency": "10ms", "Start": "2s"}],
+//		"Events": [{"At": "10s", "Name": "halve rate", "Forward": {"Rate": 100, "QueuePackets": 40}}],
+//		"Assert": {"MinThroughput": 40, "MinFairness": 0.8}
+//	}
+//
+// Durations are strings, such as "10ms", or numbers of nanoseconds.
+type Simulation struct {
+	Name     string       // Name of the emit file of the simulation
+	Duration Duration     // How long the clients send for
+	Virtual  bool         // Whether the simulation runs on virtual time, see NewVirtualEnv
+	Seed     int64        // Seed of the random choices, if not zero, see dccp.Env.SetSeed
+	CCID     string       // "ccid2", "ccid3" or "ccid4"; "ccid3" if empty
+	Forward  LinkConfig   // Bottleneck of the packets from the clients to the servers
+	Reverse  *LinkConfig  // Bottleneck of the packets back, shared by the flows if set
+	Flows    []FlowConfig // Flows through the bottleneck
+	Events   []EventConfig
+	Assert   AssertConfig
+}
+
+// LinkConfig describes a bottleneck. Zero values leave the defaults of NewBottleneck.
+type LinkConfig struct {
+	Rate         float64 // Packets per second
+	RateBytes    float64 // Bytes per second, in place of Rate
+	QueuePackets int     // Limit of the queue in packets, see Bottleneck.SetQueue
+	QueueBytes   int64   // Limit of the queue in bytes
+	AQM          string  // "red" or "codel", with the parameters of RED and CoDel in the tests
+}
+
+// PathConfig describes the impairments of the pipe of a flow, apart from the bottleneck
+type PathConfig struct {
+	Latency Duration // One-way latency, in each direction
+	Loss    float64  // Probability of losing a packet from the client to the server
+	MTU     int      // Largest packet that gets through, in each direction, or zero for any
+	ICMP    bool     // Whether packets beyond MTU are answered with ICMP, see SetPathMTUICMP
+}
+
+// FlowConfig describes a flow and the traffic that its client sends
+type FlowConfig struct {
+	PathConfig
+	Start   Duration // When the client starts sending, from the start of the simulation
+	Segment int      // Size of the segments, 100 bytes if zero
+	Rate    float64  // Segments per second, or as fast as the connection takes them if zero
+}
+
+// EventConfig is a change to the simulation at time At, see Scenario. Forward and Reverse
+// replace the configuration of the bottlenecks, and Path that of the path of flow Flow, or of
+// all flows if Flow is negative.
+type EventConfig struct {
+	At      Duration
+	Name    string
+	Forward *LinkConfig
+	Reverse *LinkConfig
+	Flow    int
+	Path    *PathConfig
+}
+
+// AssertConfig are conditions that the outcome of a simulation must meet. Throughputs are
+// measured from the second second of the simulation to its end. Zero values are not checked.
+type AssertConfig struct {
+	MinThroughput float64  // Least throughput of each flow, in packets per second
+	MaxThroughput float64  // Most throughput of all flows together, in packets per second
+	MinFairness   float64  // Least Jain index of the throughputs of the flows
+	ConvergeBy    Duration // Time by which the flows converge, see FlowMeter.Convergence
+}
+
+// Duration is a duration in nanoseconds, which reads from JSON as a string in the format of
+// time.ParseDuration, or as a number of nanoseconds
+type Duration int64
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var ns int64
+		if err := json.Unmarshal(b, &ns); err != nil {
+			return fmt.Errorf("duration %s is neither a string nor a number", b)
+		}
+		*d = Duration(ns)
+		return nil
+	}
+	t, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(t)
+	return nil
+}
+
+// String returns the duration in the format of time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// ReadSimulation decodes a Simulation in JSON from r and checks it
+func ReadSimulation(r io.Reader) (*Simulation, error) {
+	s := &Simulation{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(s); err != nil {
+		return nil, err
+	}
+	if s.Name == "" {
+		s.Name = "simulation"
+	}
+	if s.Duration <= 0 {
+		return nil, fmt.Errorf("no duration")
+	}
+	if len(s.Flows) == 0 {
+		return nil, fmt.Errorf("no flows")
+	}
+	if _, err := s.ccid(); err != nil {
+		return nil, err
+	}
+	for _, l := range s.links() {
+		if l.AQM != "" && l.AQM != "red" && l.AQM != "codel" {
+			return nil, fmt.Errorf("unknown AQM %q", l.AQM)
+		}
+	}
+	for i, f := range s.Flows {
+		if f.MTU < 0 {
+			return nil, fmt.Errorf("flow %d has a negative MTU", i)
+		}
+	}
+	for _, e := range s.Events {
+		if e.Path != nil && e.Path.MTU < 0 {
+			return nil, fmt.Errorf("event %q sets a negative MTU", e.Name)
+		}
+		if e.Reverse != nil && s.Reverse == nil {
+			return nil, fmt.Errorf("event %q changes the reverse bottleneck, which is not set", e.Name)
+		}
+		if e.Path != nil && e.Flow >= len(s.Flows) {
+			return nil, fmt.Errorf("event %q changes flow %d of %d", e.Name, e.Flow, len(s.Flows))
+		}
+	}
+	return s, nil
+}
+
+// links returns the configurations of bottlenecks in s
+func (s *Simulation) links() []*LinkConfig {
+	l := []*LinkConfig{&s.Forward, s.Reverse}
+	for _, e := range s.Events {
+		l = append(l, e.Forward, e.Reverse)
+	}
+	var r []*LinkConfig
+	for _, c := range l {
+		if c != nil {
+			r = append(r, c)
+		}
+	}
+	return r
+}
+
+func (s *Simulation) ccid() (dccp.CCID, error) {
+	switch strings.ToLower(s.CCID) {
+	case "", "ccid3":
+		return ccid3.CCID3{}, nil
+	case "ccid2":
+		return ccid2.CCID2{}, nil
+	case "ccid4":
+		return ccid3.CCID4{}, nil
+	}
+	return nil, fmt.Errorf("unknown CCID %q", s.CCID)
+}
+
+// Report is the outcome of a simulation
+type Report struct {
+	Name        string
+	Seed        int64
+	Throughput  []float64 // Throughput of each flow, in packets per second
+	Total       float64   // Throughput of all flows together
+	Fairness    float64   // Jain index of the throughputs
+	Converged   bool      // Whether the flows converge, see FlowMeter.Convergence
+	Convergence Duration  // When the flows converge
+	Failures    []string  // Assertions that the simulation does not meet
+}
+
+// simulationWindow and simulationStep are the sliding windows over which simulations measure
+// convergence, and simulationThreshold is the Jain index at which flows converge
+const (
+	simulationWindow    = 1e9
+	simulationStep      = 250e6
+	simulationThreshold = 0.9
+)
+
+// simulationGrace is how long the servers of a simulation keep reading after its end, for the
+// packets on their way, before their connections are aborted
+const simulationGrace = 10e9
+
+// Run runs the simulation, which writes its trace to the emit file named after it, as
+// NewEnv does, and reports its outcome
+func (s *Simulation) Run() *Report {
+	meter := NewFlowMeter()
+	var env *dccp.Env
+	if s.Virtual {
+		env, _ = NewVirtualEnv(s.Name, meter)
+	} else {
+		env, _ = NewEnv(s.Name, meter)
+	}
+	if s.Seed != 0 {
+		env.SetSeed(s.Seed)
+		dccp.NewAmb("env", env).E(dccp.EventInfo, fmt.Sprintf("DCCPSEED=%d", s.Seed))
+	}
+	ccid, _ := s.ccid()
+
+	top := NewTopology(env)
+	top.Forward = s.Forward.bottleneck(env)
+	if s.Reverse != nil {
+		top.Reverse = s.Reverse.bottleneck(env)
+	}
+	var flows []*Flow
+	for _, fc := range s.Flows {
+		f := top.AddFlow(ccid)
+		fc.PathConfig.apply(f)
+		flows = append(flows, f)
+	}
+
+	scenario := NewScenario(env)
+	for _, e := range s.Events {
+		e := e
+		scenario.At(time.Duration(e.At), e.Name, func() {
+			if e.Forward != nil {
+				e.Forward.apply(top.Forward)
+			}
+			if e.Reverse != nil {
+				e.Reverse.apply(top.Reverse)
+			}
+			if e.Path != nil {
+				for i, f := range flows {
+					if e.Flow < 0 || e.Flow == i {
+						e.Path.apply(f)
+					}
+				}
+			}
+		})
+	}
+
+	t0 := env.Now()
+	servers := make([]*dccp.GoRoutine, len(flows))
+	for i, f := range flows {
+		f, fc := f, s.Flows[i]
+		env.Go(func() {
+			env.Sleep(time.Duration(fc.Start))
+			segment := fc.Segment
+			if segment <= 0 {
+				segment = 100
+			}
+			buf := make([]byte, segment)
+			for env.Now().Sub(t0) < time.Duration(s.Duration) {
+				if err := f.Client.WriteSegment(buf); err != nil {
+					break
+				}
+				if fc.Rate > 0 {
+					env.Sleep(time.Duration(1e9 / fc.Rate))
+				}
+			}
+			f.Client.Close()
+		}, "simulation client %d", i)
+		servers[i] = env.Go(func() {
+			for {
+				if _, err := f.Server.ReadSegment(); err != nil {
+					break
+				}
+			}
+		}, "simulation server %d", i)
+	}
+	expired := make(chan struct{})
+	var expiredT dccp.Track
+	env.Go(func() {
+		if env.SleepOrDone(time.Duration(s.Duration)+simulationGrace, expired, &expiredT) {
+			top.Abort()
+		}
+	}, "simulation expiry")
+	for _, g := range servers {
+		g.Join()
+	}
+	close(expired)
+	env.Closed(&expiredT)
+	scenario.Stop()
+	top.Abort()
+	env.Close()
+
+	return s.report(env.Seed(), meter)
+}
+
+// report returns the outcome of the simulation as measured by meter
+func (s *Simulation) report(seed int64, meter *FlowMeter) *Report {
+	r := &Report{Name: s.Name, Seed: seed}
+	from, to := time.Second, time.Duration(s.Duration)
+	var receivers []string
+	for i := range s.Flows {
+		receiver := fmt.Sprintf("server%d", i)
+		receivers = append(receivers, receiver)
+		x := meter.Throughput(receiver, from, to)
+		r.Throughput = append(r.Throughput, x)
+		r.Total += x
+	}
+	r.Fairness = JainIndex(r.Throughput...)
+	conv, ok := meter.Convergence(0, to, simulationWindow, simulationStep, simulationThreshold, receivers...)
+	r.Converged, r.Convergence = ok, Duration(conv)
+
+	a := &s.Assert
+	for i, x := range r.Throughput {
+		if a.MinThroughput > 0 && x < a.MinThroughput {
+			r.Failures = append(r.Failures, fmt.Sprintf("flow %d: %0.1f packets per second, expected at least %0.1f", i, x, a.MinThroughput))
+		}
+	}
+	if a.MaxThroughput > 0 && r.Total > a.MaxThroughput {
+		r.Failures = append(r.Failures, fmt.Sprintf("%0.1f packets per second in all, expected at most %0.1f", r.Total, a.MaxThroughput))
+	}
+	if a.MinFairness > 0 && r.Fairness < a.MinFairness {
+		r.Failures = append(r.Failures, fmt.Sprintf("Jain index %0.3f, expected at least %0.3f", r.Fairness, a.MinFairness))
+	}
+	if a.ConvergeBy > 0 && (!r.Converged || r.Convergence > a.ConvergeBy) {
+		r.Failures = append(r.Failures, fmt.Sprintf("flows do not converge by %s", a.ConvergeBy))
+	}
+	return r
+}
+
+// Print writes a summary of the report to w
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "%s: seed %d\n", r.Name, r.Seed)
+	for i, x := range r.Throughput {
+		fmt.Fprintf(w, "flow %d: %0.1f packets per second\n", i, x)
+	}
+	fmt.Fprintf(w, "total: %0.1f packets per second\n", r.Total)
+	fmt.Fprintf(w, "Jain index: %0.3f\n", r.Fairness)
+	if r.Converged {
+		fmt.Fprintf(w, "converged by: %s\n", r.Convergence)
+	} else {
+		fmt.Fprintf(w, "converged by: never\n")
+	}
+	for _, f := range r.Failures {
+		fmt.Fprintf(w, "FAIL: %s\n", f)
+	}
+}
+
+// bottleneck returns a new bottleneck configured after c
+func (c *LinkConfig) bottleneck(env *dccp.Env) *Bottleneck {
+	b := NewBottleneck(env)
+	c.apply(b)
+	return b
+}
+
+// apply configures b after c
+func (c *LinkConfig) apply(b *Bottleneck) {
+	switch {
+	case c.RateBytes > 0:
+		// Rates are set per 10 ms, so that byte rates queue up to 10 ms by default
+		b.SetRateBytes(10e6, int64(c.RateBytes/100))
+	case c.Rate > 0:
+		b.SetRate(time.Duration(1e9/c.Rate), 1)
+	default:
+		b.SetRate(DefaultRateInterval, DefaultRatePacketsPerInterval)
+	}
+	b.SetQueue(c.QueuePackets, c.QueueBytes)
+	switch c.AQM {
+	case "red":
+		b.SetAQM(&RED{MinThresh: 5, MaxThresh: 30, MaxP: 0.1, Weight: 0.05})
+	case "codel":
+		b.SetAQM(&CoDel{Target: 5 * time.Millisecond, Interval: 100 * time.Millisecond})
+	default:
+		b.SetAQM(nil)
+	}
+}
+
+// apply configures the pipe of f after c
+func (c *PathConfig) apply(f *Flow) {
+	f.ClientToServer.SetWriteLatency(time.Duration(c.Latency))
+	f.ServerToClient.SetWriteLatency(time.Duration(c.Latency))
+	if c.Loss > 0 {
+		f.ClientToServer.SetWriteLoss(BernoulliLoss{P: c.Loss})
+	} else {
+		f.ClientToServer.SetWriteLoss(nil)
+	}
+	for _, x := range []*headerHalfPipe{f.ClientToServer, f.ServerToClient} {
+		if c.ICMP {
+			x.SetPathMTUICMP(c.MTU)
+		} else {
+			x.SetPathMTU(c.MTU)
+		}
+	}
+}
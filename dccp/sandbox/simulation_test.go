@@ -0,0 +1,68 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestReadSimulation checks that simulations are read from JSON, and that malformed ones
+// are rejected
+func TestReadSimulation(t *testing.T) {
+	s, err := ReadSimulation(strings.NewReader(`{
+		"Duration": "1.5s",
+		"Forward": {"Rate": 100, "AQM": "codel"},
+		"Flows": [{"Latency": 10000000, "Loss": 0.01}],
+		"Events": [{"At": "1s", "Flow": -1, "Path": {"Latency": "50ms"}}]
+	}`))
+	if err != nil {
+		t.Fatalf("reading simulation (%s)", err)
+	}
+	if s.Name != "simulation" || s.Duration != 1500e6 || s.Flows[0].Latency != 10e6 || s.Events[0].Path.Latency != 50e6 {
+		t.Errorf("read %+v", s)
+	}
+	for _, bad := range []string{
+		`{"Flows": [{}]}`,
+		`{"Duration": "1s"}`,
+		`{"Duration": "1s", "Flows": [{}], "CCID": "ccid9"}`,
+		`{"Duration": "1s", "Flows": [{}], "Forward": {"AQM": "blue"}}`,
+		`{"Duration": "1s", "Flows": [{}], "Events": [{"Flow": 1, "Path": {}}]}`,
+		`{"Duration": "1s", "Flows": [{}], "Events": [{"Reverse": {}}]}`,
+		`{"Duration": "1 second", "Flows": [{}]}`,
+		`{"Duration": "1s", "Flows": [{}], "Latency": "1s"}`,
+		`{"Duration": "1s", "Flows": [{"MTU": -1}]}`,
+	} {
+		if _, err := ReadSimulation(strings.NewReader(bad)); err == nil {
+			t.Errorf("read %s", bad)
+		}
+	}
+}
+
+// TestSimulation runs the simulation in the documentation of Simulation: two CCID2 flows,
+// which share a bottleneck whose rate halves half way
+func TestSimulation(t *testing.T) {
+	s, err := ReadSimulation(strings.NewReader(`{
+		"Name": "simulation",
+		"Duration": "20s",
+		"Virtual": true,
+		"CCID": "ccid2",
+		"Forward": {"Rate": 200, "QueuePackets": 40},
+		"Flows": [{"Latency": "10ms"}, {"Latency": "10ms", "Start": "2s"}],
+		"Events": [{"At": "10s", "Name": "halve rate", "Forward": {"Rate": 100, "QueuePackets": 40}}],
+		"Assert": {"MinThroughput": 40, "MaxThroughput": 165, "MinFairness": 0.8}
+	}`))
+	if err != nil {
+		t.Fatalf("reading simulation (%s)", err)
+	}
+	r := s.Run()
+	if len(r.Throughput) != 2 || len(r.Failures) > 0 {
+		t.Errorf("report %+v", r)
+	}
+	// 200 packets per second for 9 seconds, and 100 for 10, over 19 seconds
+	if r.Total < 150*0.8 {
+		t.Errorf("%0.1f packets per second in all, expected 150", r.Total)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"sync"
+	"time"
+)
+
+// PipeStats are the counts of the packets written to one side of a pipe, and of how the pipe
+// treated them, as returned by Stats. Bytes count the wire-format footprint of packets.
+type PipeStats struct {
+	Offered        int64            // Packets written
+	OfferedBytes   int64            // Bytes written
+	Delivered      int64            // Packets read from the other side, duplicates included
+	DeliveredBytes int64            // Bytes read from the other side
+	Dropped        map[string]int64 // Packets dropped, by the comment of their EventDrop trace
+	Marked         int64            // Packets marked Congestion Experienced
+	Corrupted      int64            // Packets delivered with a flipped bit
+	Duplicated     int64            // Packets duplicated
+	Reordered      int64            // Packets reordered
+	Delay          DelayHistogram   // Time from write to read of the packets delivered
+}
+
+// DroppedTotal returns the number of packets dropped, whatever the cause
+func (s *PipeStats) DroppedTotal() int64 {
+	var n int64
+	for _, k := range s.Dropped {
+		n += k
+	}
+	return n
+}
+
+// DropRate returns the fraction of the packets written that are dropped, or zero if no
+// packets are written
+func (s *PipeStats) DropRate() float64 {
+	if s.Offered == 0 {
+		return 0
+	}
+	return float64(s.DroppedTotal()) / float64(s.Offered)
+}
+
+// DelayBuckets are the upper bounds of the buckets of a DelayHistogram
+var DelayBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second,
+}
+
+// DelayHistogram counts delays in the buckets of DelayBuckets: Counts[i] is the number of
+// delays below DelayBuckets[i] and at least the bound before it. The last count, one past the
+// buckets, is of the delays of DelayBuckets[len(DelayBuckets)-1] or more.
+type DelayHistogram struct {
+	Counts []int64
+	N      int64         // Number of delays
+	Sum    time.Duration // Sum of delays
+	Min    time.Duration // Least delay
+	Max    time.Duration // Greatest delay
+}
+
+// Add counts delay d
+func (h *DelayHistogram) Add(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]int64, len(DelayBuckets)+1)
+	}
+	i := 0
+	for i < len(DelayBuckets) && d >= DelayBuckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	if h.N == 0 || d < h.Min {
+		h.Min = d
+	}
+	if d > h.Max {
+		h.Max = d
+	}
+	h.N++
+	h.Sum += d
+}
+
+// Mean returns the average delay, or zero if there are no delays
+func (h *DelayHistogram) Mean() time.Duration {
+	if h.N == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.N)
+}
+
+// Quantile returns an upper bound of the q-quantile of the delays: the bound of the bucket in
+// which it falls, or Max if that is lower. It returns zero if there are no delays.
+func (h *DelayHistogram) Quantile(q float64) time.Duration {
+	if h.N == 0 {
+		return 0
+	}
+	var n int64
+	for i, k := range h.Counts {
+		if n += k; float64(n) >= q*float64(h.N) {
+			if i < len(DelayBuckets) && DelayBuckets[i] < h.Max {
+				return DelayBuckets[i]
+			}
+			break
+		}
+	}
+	return h.Max
+}
+
+// pipeStats are the PipeStats of one side of a pipe, which both sides update
+type pipeStats struct {
+	sync.Mutex
+	s PipeStats
+}
+
+func newPipeStats() *pipeStats {
+	return &pipeStats{s: PipeStats{Dropped: make(map[string]int64)}}
+}
+
+func (x *pipeStats) offer(n int) {
+	x.Lock()
+	defer x.Unlock()
+	x.s.Offered++
+	x.s.OfferedBytes += int64(n)
+}
+
+func (x *pipeStats) deliver(n int, delay time.Duration) {
+	x.Lock()
+	defer x.Unlock()
+	x.s.Delivered++
+	x.s.DeliveredBytes += int64(n)
+	x.s.Delay.Add(delay)
+}
+
+func (x *pipeStats) drop(cause string) {
+	x.Lock()
+	defer x.Unlock()
+	x.s.Dropped[cause]++
+}
+
+// count adds one to the counter c, under the lock
+func (x *pipeStats) count(c *int64) {
+	x.Lock()
+	defer x.Unlock()
+	*c++
+}
+
+// get returns a copy of the stats
+func (x *pipeStats) get() PipeStats {
+	x.Lock()
+	defer x.Unlock()
+	s := x.s
+	s.Dropped = make(map[string]int64, len(x.s.Dropped))
+	for k, v := range x.s.Dropped {
+		s.Dropped[k] = v
+	}
+	s.Delay.Counts = append([]int64(nil), x.s.Delay.Counts...)
+	return s
+}
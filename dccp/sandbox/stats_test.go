@@ -0,0 +1,288 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+	"github.com/petar/GoDCCP/dccp/ccid3"
+)
+
+// TestDelayHistogram checks that delays are counted in their buckets
+func TestDelayHistogram(t *testing.T) {
+	var h DelayHistogram
+	if h.Mean() != 0 || h.Quantile(0.5) != 0 {
+		t.Errorf("empty histogram has mean %s, median %s", h.Mean(), h.Quantile(0.5))
+	}
+	for _, d := range []time.Duration{0, 1500 * time.Microsecond, 3 * time.Millisecond, 3 * time.Millisecond, 30 * time.Millisecond, 10 * time.Second} {
+		h.Add(d)
+	}
+	expected := []int64{1, 1, 2, 0, 0, 1, 0, 0, 0, 0, 0, 0, 1}
+	for i, k := range expected {
+		if h.Counts[i] != k {
+			t.Errorf("bucket %d has %d delays, expected %d", i, h.Counts[i], k)
+		}
+	}
+	if h.N != 6 || h.Min != 0 || h.Max != 10*time.Second || h.Mean() != time.Duration(1.5e6+3e6+3e6+30e6+10e9)/6 {
+		t.Errorf("%d delays, min %s, max %s, mean %s", h.N, h.Min, h.Max, h.Mean())
+	}
+	if q := h.Quantile(0.5); q != 5*time.Millisecond {
+		t.Errorf("median below %s, expected below %s", q, 5*time.Millisecond)
+	}
+	if q := h.Quantile(1); q != 10*time.Second {
+		t.Errorf("maximum %s, expected %s", q, 10*time.Second)
+	}
+}
+
+// TestPipeStats checks that a pipe counts the packets of a burst that it delivers, those that
+// it duplicates and those that it drops for a full queue, and the delays of the packets
+func TestPipeStats(t *testing.T) {
+	env, _ := NewEnv("stats-pipe")
+	llog := dccp.NewAmb("line", env)
+	hca, hcb, line := NewPipe(env, llog, "client", "server")
+	// 1000-byte packets at 100 KB/sec, or 10 ms a packet, into a queue of 10 packets
+	hca.SetWriteRateBytes(1e9, 100000)
+	hca.SetWriteQueue(10, 0)
+	hca.SetWriteLatency(20e6)
+	hca.SetWriteDuplicate(1, 0)
+
+	reader := env.Go(func() {
+		for {
+			hcb.SetReadExpire(1e9)
+			if _, err := hcb.Read(); err != nil {
+				return
+			}
+		}
+	}, "test reader")
+
+	for i := 0; i < 30; i++ {
+		h := &dccp.Header{Type: dccp.Data, X: true, SeqNo: int64(i)}
+		n, _ := h.Footprint()
+		h.Data = make([]byte, 1000-n)
+		hca.Write(h)
+		// Let the reader take the packets off the pipe, without letting time pass
+		for len(hca.write) > 0 {
+			runtime.Gosched()
+		}
+	}
+	hca.Close()
+	reader.Join()
+
+	ab, ba := line.Stats()
+	if ab.Offered != 30 || ab.OfferedBytes != 30000 || ab.Delivered != 20 || ab.DeliveredBytes != 20000 {
+		t.Errorf("offered %d packets, %d bytes, delivered %d packets, %d bytes",
+			ab.Offered, ab.OfferedBytes, ab.Delivered, ab.DeliveredBytes)
+	}
+	if ab.Dropped["Queue full"] != 20 || ab.DroppedTotal() != 20 || ab.Duplicated != 10 {
+		t.Errorf("dropped %v, duplicated %d", ab.Dropped, ab.Duplicated)
+	}
+	if rate := ab.DropRate(); rate < 0.66 || rate > 0.67 {
+		t.Errorf("drop rate %0.2f, expected 2/3", rate)
+	}
+	// Packets take the latency and 10 to 100 ms in the queue, and timers on a busy machine may
+	// make them late
+	if d := ab.Delay; d.N != 20 || d.Min < 30e6-1e6 || d.Max > 120e6+100e6 {
+		t.Errorf("%d delays from %d ms to %d ms, expected from 30 ms to 120 ms", d.N, d.Min/1e6, d.Max/1e6)
+	}
+	if ba.Offered != 0 || ba.Delivered != 0 {
+		t.Errorf("reverse direction offered %d, delivered %d packets", ba.Offered, ba.Delivered)
+	}
+	hcb.Close()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestConnStats checks that the statistics of a connection count the packets that it writes
+// and reads as its pipe does, report the congestion state of its CCID, and the Reset that
+// closes it
+func TestConnStats(t *testing.T) {
+	env, _ := NewVirtualEnv("stats-conn")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	clientToServer.SetWriteLatency(50e6)
+	serverToClient.SetWriteLatency(50e6)
+	clientToServer.SetWriteLoss(BernoulliLoss{P: 0.05})
+
+	reader := env.Go(func() {
+		for {
+			if _, err := serverConn.ReadSegment(); err != nil {
+				return
+			}
+		}
+	}, "test reader")
+	for i := 0; i < 500; i++ {
+		if err := clientConn.WriteSegment(make([]byte, 100)); err != nil {
+			t.Fatalf("writing (%s)", err)
+		}
+	}
+	env.Sleep(1e9)
+
+	cs, ab := clientConn.Stats(), clientToServer.Stats()
+	if cs.State != "OPEN" || cs.PacketsSent != ab.Offered || cs.BytesSent != ab.OfferedBytes {
+		t.Errorf("client in %s sent %d packets, %d bytes, pipe offered %d, %d",
+			cs.State, cs.PacketsSent, cs.BytesSent, ab.Offered, ab.OfferedBytes)
+	}
+	ss, ba := serverConn.Stats(), serverToClient.Stats()
+	if ss.PacketsReceived != ab.Delivered || cs.PacketsReceived != ba.Delivered {
+		t.Errorf("server received %d packets, pipe delivered %d; client received %d, pipe delivered %d",
+			ss.PacketsReceived, ab.Delivered, cs.PacketsReceived, ba.Delivered)
+	}
+	if cs.RTT < 100e6 || cs.RTT > 150e6 || cs.RTTVar == 0 {
+		t.Errorf("client RTT %s, variation %d ns", cs.RTT, cs.RTTVar)
+	}
+	if cs.Cwnd < 1 || cs.LossEvents == 0 || cs.Retransmits != 0 {
+		t.Errorf("client window %d, %d loss events, %d retransmits", cs.Cwnd, cs.LossEvents, cs.Retransmits)
+	}
+	if cs.Reset != nil || cs.Err != nil {
+		t.Errorf("open client has reset %v, error %v", cs.Reset, cs.Err)
+	}
+
+	clientConn.Abort()
+	reader.Join()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	cs, ss = clientConn.Stats(), serverConn.Stats()
+	if cs.State != "CLOSED" || cs.Reset == nil || cs.Reset.Code != dccp.ResetAborted || !cs.ResetSent {
+		t.Errorf("client in %s, reset %v, sent %v", cs.State, cs.Reset, cs.ResetSent)
+	}
+	if ss.Reset == nil || ss.Reset.Code != dccp.ResetAborted || ss.ResetSent || ss.Err == nil {
+		t.Errorf("server reset %v, sent %v, error %v", ss.Reset, ss.ResetSent, ss.Err)
+	}
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestAllowedRate checks that the allowed rate of a CCID2 connection is its congestion window
+// per round-trip time, that the rate handler hears of its changes, and that a closed
+// connection is allowed nothing
+func TestAllowedRate(t *testing.T) {
+	env, _ := NewVirtualEnv("allowed-rate")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	clientToServer.SetWriteLatency(50e6)
+	serverToClient.SetWriteLatency(50e6)
+
+	if err := clientConn.SetRateHandler(nil, -1); !errors.Is(err, dccp.ErrInvalid) {
+		t.Errorf("negative rate change accepted")
+	}
+	rates := make(chan int64, 100)
+	var inside, overlaps int32
+	clientConn.SetRateHandler(func(rate int64) {
+		// Calls are made one at a time
+		if atomic.AddInt32(&inside, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		runtime.Gosched()
+		atomic.AddInt32(&inside, -1)
+		select {
+		case rates <- rate:
+		default:
+		}
+	}, 0.1)
+
+	reader := env.Go(func() {
+		for {
+			if _, err := serverConn.ReadSegment(); err != nil {
+				return
+			}
+		}
+	}, "test reader")
+	for i := 0; i < 500; i++ {
+		if err := clientConn.WriteSegment(make([]byte, 100)); err != nil {
+			t.Fatalf("writing (%s)", err)
+		}
+	}
+	env.Sleep(1e9)
+
+	rate, cs := clientConn.AllowedRate(), clientConn.Stats()
+	if expect := cs.Cwnd * int64(clientConn.GetMTU()) * 1e9 / int64(cs.RTT); rate < expect {
+		t.Errorf("allowed rate %d bytes/sec, expected at least %d for window %d and RTT %s",
+			rate, expect, cs.Cwnd, cs.RTT)
+	}
+	if len(rates) == 0 {
+		t.Errorf("rate handler not called")
+	}
+	if n := atomic.LoadInt32(&overlaps); n > 0 {
+		t.Errorf("rate handler called concurrently %d times", n)
+	}
+
+	clientConn.Abort()
+	reader.Join()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if rate := clientConn.AllowedRate(); rate != 0 {
+		t.Errorf("closed connection allowed %d bytes/sec", rate)
+	}
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestCCIDState checks that CCID2 and CCID3 connections expose the internals of their
+// congestion controls, and that the typed accessors refuse the state of the other CCID
+func TestCCIDState(t *testing.T) {
+	runCCIDState(t, ccid2.CCID2{}, func(client, server *dccp.Conn) {
+		ss, ok := ccid2.GetSenderState(client)
+		if !ok || !ss.Open || ss.Cwnd < 1 || ss.Ssthresh < ccid2.MinSSThresh || ss.RTT <= 0 {
+			t.Errorf("CCID2 sender state %+v", ss)
+		}
+		if rs, ok := ccid2.GetReceiverState(server); !ok || !rs.Open {
+			t.Errorf("CCID2 receiver state %+v", rs)
+		}
+		if _, ok := ccid3.GetSenderState(client); ok {
+			t.Errorf("CCID3 state of a CCID2 connection")
+		}
+	})
+	runCCIDState(t, ccid3.CCID3{}, func(client, server *dccp.Conn) {
+		ss, ok := ccid3.GetSenderState(client)
+		if !ok || !ss.Open || !ss.HasFeedback || ss.X == 0 || ss.RTT <= 0 || ss.P() != 0 {
+			t.Errorf("CCID3 sender state %+v", ss)
+		}
+		if rs, ok := ccid3.GetReceiverState(server); !ok || !rs.Open || rs.RTT <= 0 {
+			t.Errorf("CCID3 receiver state %+v", rs)
+		}
+		if _, ok := ccid2.GetReceiverState(server); ok {
+			t.Errorf("CCID2 state of a CCID3 connection")
+		}
+	})
+}
+
+// runCCIDState sends data from the client to the server of a connection of the given CCID,
+// and calls check on the open connection
+func runCCIDState(t *testing.T, ccid dccp.CCID, check func(client, server *dccp.Conn)) {
+	env, _ := NewVirtualEnv("ccid-state")
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid)
+	clientToServer.SetWriteLatency(50e6)
+	serverToClient.SetWriteLatency(50e6)
+
+	reader := env.Go(func() {
+		for {
+			if _, err := serverConn.ReadSegment(); err != nil {
+				return
+			}
+		}
+	}, "test reader")
+	for i := 0; i < 20; i++ {
+		if err := clientConn.WriteSegment(make([]byte, 100)); err != nil {
+			t.Fatalf("writing (%s)", err)
+		}
+	}
+	env.Sleep(2e9)
+	check(clientConn, serverConn)
+
+	clientConn.Abort()
+	reader.Join()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
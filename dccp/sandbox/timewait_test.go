@@ -0,0 +1,64 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// TestTimewait checks that the endpoint that closes the connection holds TIMEWAIT for the
+// configured duration, rather than the default 2MSL
+func TestTimewait(t *testing.T) {
+	env, _ := NewEnv("timewait")
+	clientConn, serverConn, _, _ := NewClientServerPipe(env)
+	if err := clientConn.SetTimewait(3e9); err != nil {
+		t.Fatalf("set timewait (%s)", err)
+	}
+
+	env.Sleep(3e9)
+	t0 := env.Now()
+	if err := clientConn.Close(); err != nil {
+		t.Fatalf("client close (%s)", err)
+	}
+	if _, err := serverConn.ReadSegment(); !errors.Is(err, dccp.ErrEOF) {
+		t.Errorf("server read: expecting %s, encountered %v", dccp.ErrEOF, err)
+	}
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	// If the pipe drops the server's Reset, the client reaches TIMEWAIT only after CLOSING times out
+	if d := env.Now().Sub(t0); d < 3e9 || d > dccp.CLOSING_BACKOFF_TIMEOUT+6e9 {
+		t.Errorf("TIMEWAIT lasted %s", d)
+	}
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestCloseReq checks that a server that closes the connection makes the client hold TIMEWAIT
+func TestCloseReq(t *testing.T) {
+	env, _ := NewEnv("closereq")
+	clientConn, serverConn, _, _ := NewClientServerPipe(env)
+	// The test would take a minute if the server held TIMEWAIT
+	serverConn.SetTimewait(60e9)
+
+	env.Sleep(3e9)
+	t0 := env.Now()
+	if err := serverConn.Close(); err != nil {
+		t.Fatalf("server close (%s)", err)
+	}
+	if _, err := clientConn.ReadSegment(); !errors.Is(err, dccp.ErrEOF) {
+		t.Errorf("client read: expecting %s, encountered %v", dccp.ErrEOF, err)
+	}
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if d := env.Now().Sub(t0); d < SandboxTimewait || d > SandboxTimewait+5*time.Second {
+		t.Errorf("connection took %s to wind down", d)
+	}
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
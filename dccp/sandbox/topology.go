@@ -0,0 +1,78 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/petar/GoDCCP/dccp"
+)
+
+// Topology is a set of client-server connections, or flows, each over a pipe of its own,
+// whose packets from the clients to the servers share one bottleneck, Forward. The packets
+// from the servers to the clients share Reverse, if it is set before flows are added, and
+// otherwise go through bottlenecks of their own. The latency, loss and other impairments of
+// each pipe stay its own, so flows may differ in round-trip time while they compete for the
+// capacity of the bottleneck. Topologies are for testing the fairness and convergence of
+// congestion control between flows.
+type Topology struct {
+	env     *dccp.Env
+	Forward *Bottleneck
+	Reverse *Bottleneck
+
+	sync.Mutex
+	flows []*Flow
+}
+
+// Flow is a connection of a Topology
+type Flow struct {
+	Client         *dccp.Conn
+	Server         *dccp.Conn
+	ClientToServer *headerHalfPipe
+	ServerToClient *headerHalfPipe
+}
+
+// NewTopology returns a Topology without flows, whose forward bottleneck has the default rate
+// of a pipe and no queue
+func NewTopology(env *dccp.Env) *Topology {
+	return &Topology{env: env, Forward: NewBottleneck(env)}
+}
+
+// AddFlow adds a flow whose endpoints use ccid. The ambs of the i-th flow added, counting
+// from zero, are labeled "clienti" and "serveri", and the sides of its pipe are labeled the
+// same under "line".
+func (t *Topology) AddFlow(ccid dccp.CCID) *Flow {
+	t.Lock()
+	defer t.Unlock()
+	i := len(t.flows)
+	f := &Flow{}
+	f.Client, f.Server, f.ClientToServer, f.ServerToClient =
+		newClientServerPipe(t.env, ccid, fmt.Sprintf("client%d", i), fmt.Sprintf("server%d", i))
+	f.ClientToServer.SetBottleneck(t.Forward)
+	if t.Reverse != nil {
+		f.ServerToClient.SetBottleneck(t.Reverse)
+	}
+	t.flows = append(t.flows, f)
+	return f
+}
+
+// Flows returns the flows of the topology, in the order they were added
+func (t *Topology) Flows() []*Flow {
+	t.Lock()
+	defer t.Unlock()
+	return append([]*Flow(nil), t.flows...)
+}
+
+// Abort aborts the connections of all flows and waits for them to finish
+func (t *Topology) Abort() {
+	var joiners []dccp.Joiner
+	for _, f := range t.Flows() {
+		f.Client.Abort()
+		f.Server.Abort()
+		joiners = append(joiners, f.Client.Joiner(), f.Server.Joiner())
+	}
+	t.env.NewGoJoin("topology", joiners...).Join()
+}
@@ -0,0 +1,133 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp"
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestPipeBottleneck checks that the packets of two pipes that share a bottleneck are sent
+// one after another at its rate
+func TestPipeBottleneck(t *testing.T) {
+	env, _ := NewEnv("bottleneck-pipe")
+	llog := dccp.NewAmb("line", env)
+	a1, b1, _ := NewPipe(env, llog, "client0", "server0")
+	a2, b2, _ := NewPipe(env, llog, "client1", "server1")
+	link := NewBottleneck(env)
+	link.SetRate(10e6, 1)
+	link.SetQueue(100, 0)
+	a1.SetBottleneck(link)
+	a2.SetBottleneck(link)
+
+	arrivals := make(chan time.Time, 100)
+	read := func(hc *headerHalfPipe) {
+		for {
+			hc.SetReadExpire(1e9)
+			if _, err := hc.Read(); err != nil {
+				arrivals <- time.Time{}
+				return
+			}
+			arrivals <- env.Now()
+		}
+	}
+	env.Go(func() { read(b1) }, "test reader 0")
+	env.Go(func() { read(b2) }, "test reader 1")
+
+	// Write ten packets to each pipe at once
+	t0 := env.Now()
+	for i := 0; i < 10; i++ {
+		for _, a := range []*headerHalfPipe{a1, a2} {
+			a.Write(&dccp.Header{Type: dccp.Data, X: true, SeqNo: int64(i)})
+			// Let the reader take the packet off the pipe, without letting time pass
+			for len(a.write) > 0 {
+				runtime.Gosched()
+			}
+		}
+	}
+	a1.Close()
+	a2.Close()
+
+	var received, done int
+	var last time.Duration
+	for done < 2 {
+		a := <-arrivals
+		if a.IsZero() {
+			done++
+			continue
+		}
+		received++
+		last = a.Sub(t0)
+	}
+	if received != 20 {
+		t.Errorf("received %d packets, expected 20", received)
+	}
+	// The last packet waits for the other 19, to within the interval of the rate
+	if last < 180*time.Millisecond || last > 290*time.Millisecond {
+		t.Errorf("last packet in %d ms, expected in 190 ms", last/time.Millisecond)
+	}
+	b1.Close()
+	b2.Close()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+}
+
+// TestTopology checks that two CCID2 flows, which share a bottleneck, use up its capacity
+// between them, and that neither is starved
+func TestTopology(t *testing.T) {
+	meter := NewFlowMeter()
+	env, _ := NewEnv("topology", meter)
+	top := NewTopology(env)
+	// 200 packets per second, with a queue of 40 packets
+	top.Forward.SetRate(5e6, 1)
+	top.Forward.SetQueue(40, 0)
+
+	const duration = 6 * time.Second
+	const capacity = 200
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		f := top.AddFlow(ccid2.CCID2{})
+		f.ClientToServer.SetWriteLatency(10e6)
+		f.ServerToClient.SetWriteLatency(10e6)
+		env.Go(func() {
+			buf := make([]byte, 100)
+			t0 := env.Now()
+			for env.Now().Sub(t0) < duration {
+				if err := f.Client.WriteSegment(buf); err != nil {
+					break
+				}
+			}
+			f.Client.Close()
+		}, "test client")
+		env.Go(func() {
+			f.Server.SetReadDeadline(time.Now().Add(duration + 2*time.Second))
+			for {
+				if _, err := f.Server.ReadSegment(); err != nil {
+					break
+				}
+			}
+			done <- 1
+		}, "test server")
+	}
+	<-done
+	<-done
+
+	top.Abort()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+	x0, x1 := meter.Throughput("server0", 0, duration), meter.Throughput("server1", 0, duration)
+	if x0+x1 < capacity*0.7 || x0+x1 > capacity*1.1 {
+		t.Errorf("flows got %0.1f and %0.1f packets per second, expected %d in all", x0, x1, capacity)
+	}
+	if jain := JainIndex(x0, x1); jain < 0.8 {
+		t.Errorf("flows got %0.1f and %0.1f packets per second, Jain's index %0.2f", x0, x1, jain)
+	}
+}
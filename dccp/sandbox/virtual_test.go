@@ -0,0 +1,59 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petar/GoDCCP/dccp/ccid2"
+)
+
+// TestVirtual checks that a CCID2 connection through a rate-limited pipe, on virtual time,
+// fills the pipe as it does on real time, in a fraction of the time
+func TestVirtual(t *testing.T) {
+	meter := NewFlowMeter()
+	env, _ := NewVirtualEnv("virtual", meter)
+	clientConn, serverConn, clientToServer, serverToClient := NewClientServerPipeCCID(env, ccid2.CCID2{})
+	clientToServer.SetWriteLatency(10e6)
+	serverToClient.SetWriteLatency(10e6)
+	// 100 packets per second, with a queue of 20 packets
+	clientToServer.SetWriteRate(10e6, 1)
+	clientToServer.SetWriteQueue(20, 0)
+
+	const duration = 30 * time.Second
+	const capacity = 100
+	start := time.Now()
+	t0 := env.Now()
+	env.Go(func() {
+		buf := make([]byte, 100)
+		for env.Now().Sub(t0) < duration {
+			if err := clientConn.WriteSegment(buf); err != nil {
+				break
+			}
+		}
+		clientConn.Close()
+	}, "test client")
+	for {
+		if _, err := serverConn.ReadSegment(); err != nil {
+			break
+		}
+	}
+	elapsed := time.Since(start)
+
+	clientConn.Abort()
+	serverConn.Abort()
+	env.NewGoJoin("end-of-test", clientConn.Joiner(), serverConn.Joiner()).Join()
+	if err := env.Close(); err != nil {
+		t.Errorf("error closing runtime (%s)", err)
+	}
+	if elapsed > duration/3 {
+		t.Errorf("%s of virtual time took %s", duration, elapsed)
+	}
+	// Leave out the first second, for slow start
+	if x := meter.Throughput("server", 1e9, duration); x < capacity*0.8 || x > capacity*1.1 {
+		t.Errorf("%0.1f packets per second, expected %d", x, capacity)
+	}
+}
@@ -4,7 +4,10 @@
 
 package dccp
 
-import "net"
+import (
+	"net"
+	"time"
+)
 
 // Bytes is a type that has an equivalent representation as a byte slice
 // We use it for addresses, since net.Addr does not require such representation
@@ -23,7 +26,7 @@ type SegmentConn interface {
 	Read() (block []byte, err error)
 
 	// If the user attempts to write a block that is too big, an ErrTooBig is returned
-	// and the block is not sent.
+	// and the block is not sent. Write must not retain block once it returns.
 	Write(block []byte) (err error)
 
 	LocalLabel() Bytes
@@ -31,11 +34,11 @@ type SegmentConn interface {
 	RemoteLabel() Bytes
 
 	// SetReadExpire sets the expiration time for any blocked calls to Read
-	// as a time represented in nanoseconds from now. It's semantics are similar to that
+	// as a duration from now. It's semantics are similar to that
 	// of net.Conn.SetReadDeadline except that the deadline is specified in time from now,
 	// rather than absolute time. Also note that Read is expected to return 
 	// an ErrTimeout in the event of timeouts.
-	SetReadExpire(nsec int64) error
+	SetReadExpire(d time.Duration) error
 
 	Close() error
 }
@@ -65,7 +68,7 @@ type HeaderConn interface {
 	RemoteLabel() Bytes
 
 	// SetReadExpire behaves similarly to SegmentConn.SetReadExpire
-	SetReadExpire(nsec int64) error
+	SetReadExpire(d time.Duration) error
 
 	Close() error
 }
@@ -89,21 +92,63 @@ func (hc *headerConn) GetMTU() int {
 // to the DCCP header's read and write functions.
 
 func (hc *headerConn) Read() (h *Header, err error) {
-	p, err := hc.bc.Read()
+	var p []byte
+	var ecn byte
+	var rb *packetBuf
+	if bc, ok := hc.bc.(bufSegmentConn); ok {
+		p, ecn, rb, err = bc.readBuf()
+	} else if ec, ok := hc.bc.(ecnSegmentConn); ok {
+		p, ecn, err = ec.ReadECN()
+	} else {
+		p, err = hc.bc.Read()
+	}
 	if err != nil {
 		return nil, err
 	}
-	return ReadHeader(p, LabelZero.Bytes(), LabelZero.Bytes(), AnyProto, false)
+	h, err = ReadHeader(p, LabelZero.Bytes(), LabelZero.Bytes(), AnyProto, false)
+	if err != nil {
+		rb.release()
+		return nil, err
+	}
+	h.ECN = ecn
+	h.buf = rb
+	return h, nil
 }
 
 func (hc *headerConn) Write(h *Header) (err error) {
-	p, err := h.Write(LabelZero.Bytes(), LabelZero.Bytes(), AnyProto, false)
+	pb := newPacketBuf(hc.GetMTU())
+	defer pb.release()
+	p, err := h.Append(pb.b[:0], LabelZero.Bytes(), LabelZero.Bytes(), AnyProto, false)
 	if err != nil {
 		return err
 	}
+	if ec, ok := hc.bc.(ecnSegmentConn); ok {
+		return ec.WriteECN(p, h.ECN)
+	}
 	return hc.bc.Write(p)
 }
 
+// encodesHeaders implements headerEncoder.encodesHeaders
+func (hc *headerConn) encodesHeaders() {}
+
+// CarriesECN implements ECNCarrier.CarriesECN
+func (hc *headerConn) CarriesECN() bool {
+	return carriesECN(hc.bc)
+}
+
+// CarriesDSCP implements DSCPCarrier.CarriesDSCP
+func (hc *headerConn) CarriesDSCP() bool {
+	return carriesDSCP(hc.bc)
+}
+
+// SetDSCP implements dscpMarker.SetDSCP
+func (hc *headerConn) SetDSCP(dscp byte) error {
+	if dm, ok := hc.bc.(dscpMarker); ok {
+		return dm.SetDSCP(dscp)
+	}
+	return ErrUnsupported
+}
+
 func (hc *headerConn) LocalLabel() Bytes {
 	return hc.bc.LocalLabel()
 }
@@ -112,10 +157,19 @@ func (hc *headerConn) RemoteLabel() Bytes {
 	return hc.bc.RemoteLabel()
 }
 
-func (hc *headerConn) SetReadExpire(nsec int64) error {
-	return hc.bc.SetReadExpire(nsec)
+func (hc *headerConn) SetReadExpire(d time.Duration) error {
+	return hc.bc.SetReadExpire(d)
 }
 
 func (hc *headerConn) Close() error {
 	return hc.bc.Close()
 }
+
+// Reclaim implements Reclaimer.Reclaim. If the underlying SegmentConn is not a Reclaimer,
+// Reclaim merely closes it.
+func (hc *headerConn) Reclaim() error {
+	if r, ok := hc.bc.(Reclaimer); ok {
+		return r.Reclaim()
+	}
+	return hc.bc.Close()
+}
@@ -0,0 +1,319 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "fmt"
+
+// sendQueueLen is the number of messages that the send queue holds by default. Once it is
+// full, the application is writing faster than the CCID allows.
+const sendQueueLen = 8
+
+// SendPolicy decides what a write does when the send queue is full, after the queue has
+// discarded its messages past their deadline and shed droppable ones, see MsgOptions
+type SendPolicy int
+
+const (
+	SendBlock      SendPolicy = iota // Wait for room in the queue, the default
+	SendDropNewest                   // Discard the message being written
+	SendDropOldest                   // Discard the oldest queued messages to make room
+	SendError                        // Return ErrFull
+)
+
+// SetSendQueue bounds the send queue of the connection to the given number of packets and
+// bytes of application data, and sets what writes do once it is full. Zero packets restores
+// the default of 8, and zero bytes means no byte limit. A message larger than the byte limit
+// is only queued into an empty queue. Blocking favours reliability, while dropping or failing
+// writes keeps the latency of the data that does get sent low.
+func (c *Conn) SetSendQueue(packets int, bytes int64, policy SendPolicy) error {
+	if packets < 0 || bytes < 0 || policy < SendBlock || policy > SendError {
+		return ErrInvalid
+	}
+	if packets == 0 {
+		packets = sendQueueLen
+	}
+	q := c.writeData
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return ErrBad
+	}
+	q.maxPackets, q.maxBytes, q.policy = packets, bytes, policy
+	q.signalRoom()
+	return nil
+}
+
+// Writable returns a channel that is closed once the send queue has room for another message,
+// right away if it has room now or the connection is closed. Event loops that write with
+// TryWrite wait on it after ErrWouldBlock, and then try again, as other writers may take the
+// room first. On virtual time, the caller is taken to wait on the channel from the call on.
+func (c *Conn) Writable() <-chan struct{} {
+	return c.writeData.Writable()
+}
+
+// closedChan is a channel that is always closed
+var closedChan = make(chan struct{})
+
+func init() { close(closedChan) }
+
+// outMsg is a block of application data on its way from the application to writeLoop
+type outMsg struct {
+	data   []byte
+	vec    [][]byte // Fragments that follow data, see Header.DataVec
+	opts   MsgOptions
+	mtu    int   // GetMTU when the message was written
+	expire int64 // Time after which the message is discarded, or zero for never
+}
+
+// size returns the length of the application data of m
+func (m *outMsg) size() int {
+	n := len(m.data)
+	for _, f := range m.vec {
+		n += len(f)
+	}
+	return n
+}
+
+// expired returns true if m is past its deadline at time now
+func (m *outMsg) expired(now int64) bool {
+	return m.expire != 0 && now > m.expire
+}
+
+// sendQueue holds the application data that waits for the CCID to allow its sending.
+// writeLoop takes the message of highest priority first, and the oldest among equals.
+type sendQueue struct {
+	Mutex
+	env   *Env
+	amb   *Amb
+	msgs  []*outMsg // Messages in the order they were written
+	bytes int64     // Bytes of application data in msgs
+
+	maxPackets int        // Limit on len(msgs)
+	maxBytes   int64      // Limit on bytes, or zero for none
+	policy     SendPolicy // What writes do when the queue is full
+
+	ready    chan struct{} // Holds a token while msgs may not be empty
+	space    chan struct{} // Holds a token while msgs may have room
+	writable chan struct{} // Closed when msgs has room, or nil if nobody waits, see Writable
+	done     chan struct{} // Closed by Close
+	closed   bool
+
+	// Account for the channels above on virtual time
+	readyT, spaceT, writableT, doneT Track
+}
+
+func newSendQueue(env *Env, amb *Amb) *sendQueue {
+	q := &sendQueue{
+		env:        env,
+		amb:        amb,
+		maxPackets: sendQueueLen,
+		ready:      make(chan struct{}, 1),
+		space:      make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	env.wakeup(q.space, &q.spaceT)
+	return q
+}
+
+// push adds m to the queue. If the queue is full, it discards the messages past their
+// deadline, sheds droppable messages, and then follows the policy of the queue. It returns
+// ErrTimeout if deadline, which dk accounts for, fires while it blocks, ErrWouldBlock if it
+// would block and block is false, ErrFull under SendError, and ErrBad if the queue is closed.
+// A message discarded in favour of the queued ones counts as written.
+func (q *sendQueue) push(m *outMsg, deadline <-chan struct{}, dk *Track, block bool) error {
+	for {
+		q.Lock()
+		if q.closed {
+			q.Unlock()
+			return ErrBad
+		}
+		if !q.fits(m) {
+			q.purge()
+		}
+		for !q.fits(m) {
+			victim := q.victim(m)
+			if victim < 0 {
+				break
+			}
+			if victim == len(q.msgs) {
+				q.amb.E(EventDrop, fmt.Sprintf("Shed new message of priority %d", m.opts.Priority))
+				q.Unlock()
+				return nil
+			}
+			q.remove(victim, "Shed")
+		}
+		if !q.fits(m) {
+			switch q.policy {
+			case SendDropNewest:
+				q.amb.E(EventDrop, fmt.Sprintf("Full queue drops new message of priority %d", m.opts.Priority))
+				q.Unlock()
+				return nil
+			case SendDropOldest:
+				for !q.fits(m) {
+					q.remove(0, "Full queue drops")
+				}
+			case SendError:
+				q.Unlock()
+				return ErrFull
+			default:
+				if !block {
+					q.Unlock()
+					return ErrWouldBlock
+				}
+			}
+		}
+		if q.fits(m) {
+			q.msgs = append(q.msgs, m)
+			q.bytes += int64(m.size())
+			q.env.wakeup(q.ready, &q.readyT)
+			q.signalRoom()
+			q.Unlock()
+			return nil
+		}
+		q.Unlock()
+		q.env.Wait(&q.spaceT, &q.doneT, dk)
+		select {
+		case <-q.space:
+			q.env.Woken(&q.spaceT, &q.doneT, dk)
+			q.env.Received(&q.spaceT)
+		case <-q.done:
+			q.env.Woken(&q.spaceT, &q.doneT, dk)
+		case <-deadline:
+			q.env.Woken(&q.spaceT, &q.doneT, dk)
+			return ErrTimeout
+		}
+	}
+}
+
+// hasRoom returns true if the queue is below its limits
+func (q *sendQueue) hasRoom() bool {
+	q.AssertLocked()
+	return len(q.msgs) < q.maxPackets && (q.maxBytes == 0 || q.bytes < q.maxBytes)
+}
+
+// signalRoom wakes up the writers that wait for room, if there is any
+func (q *sendQueue) signalRoom() {
+	q.AssertLocked()
+	if !q.hasRoom() {
+		return
+	}
+	q.env.wakeup(q.space, &q.spaceT)
+	if q.writable != nil {
+		close(q.writable)
+		q.env.release(&q.writableT)
+		q.writable = nil
+	}
+}
+
+// Writable returns a channel that is closed once the queue has room, see Conn.Writable. On
+// virtual time, the caller waits on the Env from then on, as it is to wait on the channel.
+func (q *sendQueue) Writable() <-chan struct{} {
+	q.Lock()
+	defer q.Unlock()
+	if q.closed || q.hasRoom() {
+		return closedChan
+	}
+	if q.writable == nil {
+		q.writable, q.writableT = make(chan struct{}), Track{}
+	}
+	q.env.Wait(&q.writableT)
+	return q.writable
+}
+
+// fits returns true if m fits into the queue. An empty queue takes a message of any size.
+func (q *sendQueue) fits(m *outMsg) bool {
+	q.AssertLocked()
+	if len(q.msgs) == 0 {
+		return true
+	}
+	return len(q.msgs) < q.maxPackets && (q.maxBytes == 0 || q.bytes+int64(m.size()) <= q.maxBytes)
+}
+
+// victim returns the index of the droppable message of lowest priority, the oldest one among
+// equals, in the queue followed by the incoming message m, or -1 if no message is droppable
+func (q *sendQueue) victim(m *outMsg) int {
+	q.AssertLocked()
+	victim, prio := -1, 0
+	for i, g := range q.msgs {
+		if g.opts.Droppable && (victim < 0 || g.opts.Priority < prio) {
+			victim, prio = i, g.opts.Priority
+		}
+	}
+	if m.opts.Droppable && (victim < 0 || m.opts.Priority < prio) {
+		victim = len(q.msgs)
+	}
+	return victim
+}
+
+// remove discards the i-th queued message, logging cause
+func (q *sendQueue) remove(i int, cause string) {
+	q.AssertLocked()
+	g := q.msgs[i]
+	q.amb.E(EventDrop, fmt.Sprintf("%s message of priority %d", cause, g.opts.Priority))
+	q.bytes -= int64(g.size())
+	q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+}
+
+// purge discards the messages that are past their deadline
+func (q *sendQueue) purge() {
+	q.AssertLocked()
+	now := q.env.nowNano()
+	msgs := q.msgs[:0]
+	for _, m := range q.msgs {
+		if m.expired(now) {
+			q.amb.E(EventDrop, fmt.Sprintf("Expired message of priority %d", m.opts.Priority))
+			q.bytes -= int64(m.size())
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	for i := len(msgs); i < len(q.msgs); i++ {
+		q.msgs[i] = nil
+	}
+	q.msgs = msgs
+}
+
+// pop removes and returns the next message to send, or nil if the queue is empty. Messages
+// past their deadline are discarded on the way.
+func (q *sendQueue) pop() *outMsg {
+	q.Lock()
+	defer q.Unlock()
+	// Both purging and popping make room for blocked writers
+	defer q.signalRoom()
+	q.purge()
+	next := -1
+	for i, g := range q.msgs {
+		if next < 0 || g.opts.Priority > q.msgs[next].opts.Priority {
+			next = i
+		}
+	}
+	if next < 0 {
+		return nil
+	}
+	m := q.msgs[next]
+	q.msgs = append(q.msgs[:next], q.msgs[next+1:]...)
+	q.bytes -= int64(m.size())
+	if len(q.msgs) > 0 {
+		q.env.wakeup(q.ready, &q.readyT)
+	}
+	return m
+}
+
+// Close discards the queued messages and fails pending and future pushes. It is idempotent.
+func (q *sendQueue) Close() {
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.msgs, q.bytes = nil, 0
+	close(q.done)
+	q.env.Closed(&q.doneT)
+	if q.writable != nil {
+		close(q.writable)
+		q.env.release(&q.writableT)
+		q.writable = nil
+	}
+}
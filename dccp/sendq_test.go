@@ -0,0 +1,168 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendQueue(t *testing.T) {
+	env := NewEnv(nullTraceWriter{})
+	q := newSendQueue(env, NewAmb("test", env))
+	expire := make(chan struct{})
+	push := func(b byte, prio int, droppable bool) error {
+		return q.push(&outMsg{data: []byte{b}, opts: MsgOptions{Priority: prio, Droppable: droppable}}, expire, nil, true)
+	}
+	// Fill the queue: droppable messages 0 to 3 at priorities 0 and 1, then firm messages
+	for i := 0; i < sendQueueLen; i++ {
+		if err := push(byte(i), i%2, i < 4); err != nil {
+			t.Fatalf("push %d (%s)", i, err)
+		}
+	}
+	// A firm message sheds the oldest droppable message of lowest priority, 0
+	if err := push(100, 0, false); err != nil {
+		t.Fatalf("push (%s)", err)
+	}
+	// A droppable message of lower priority than all queued ones is shed itself
+	if err := push(101, -1, true); err != nil {
+		t.Fatalf("push (%s)", err)
+	}
+	// Firm messages shed the remaining droppable ones, and then block until they expire
+	for _, b := range []byte{102, 103, 104} {
+		if err := push(b, 2, false); err != nil {
+			t.Fatalf("push %d (%s)", b, err)
+		}
+	}
+	close(expire)
+	if err := push(105, 0, false); err != ErrTimeout {
+		t.Errorf("push into full queue: expecting %s, encountered %v", ErrTimeout, err)
+	}
+
+	// Messages come out by priority, and in order of writing among equals
+	want := []byte{102, 103, 104, 5, 7, 4, 6, 100}
+	for i, b := range want {
+		m := q.pop()
+		if m == nil || m.data[0] != b {
+			t.Fatalf("pop %d: expecting %d, got %v", i, b, m)
+		}
+	}
+	if m := q.pop(); m != nil {
+		t.Errorf("pop from empty queue: %v", m)
+	}
+
+	q.Close()
+	if err := push(0, 0, false); err != ErrBad {
+		t.Errorf("push after close: expecting %s, encountered %v", ErrBad, err)
+	}
+}
+
+func TestSendQueueExpire(t *testing.T) {
+	env := NewEnv(nullTraceWriter{})
+	q := newSendQueue(env, NewAmb("test", env))
+	now := env.Now()
+	opts := []MsgOptions{
+		{Deadline: now.Add(-1)},
+		{TTL: 1000 * time.Second},
+		{Deadline: now.Add(1000 * time.Second), TTL: -1},
+		{Deadline: now.Add(1000 * time.Second), TTL: 1},
+	}
+	for i := 0; i < sendQueueLen; i++ {
+		o := opts[i%len(opts)]
+		if err := q.push(&outMsg{data: []byte{byte(i)}, opts: o, expire: o.expire(now.UnixNano())}, nil, nil, true); err != nil {
+			t.Fatalf("push %d (%s)", i, err)
+		}
+	}
+	env.Sleep(time.Microsecond)
+	// A firm message takes the place of the expired ones in the full queue
+	if err := q.push(&outMsg{data: []byte{100}}, nil, nil, true); err != nil {
+		t.Fatalf("push (%s)", err)
+	}
+	for i, b := range []byte{1, 2, 5, 6, 100} {
+		m := q.pop()
+		if m == nil || m.data[0] != b {
+			t.Fatalf("pop %d: expecting %d, got %v", i, b, m)
+		}
+	}
+	if m := q.pop(); m != nil {
+		t.Errorf("pop from empty queue: %v", m)
+	}
+}
+
+func TestSendQueuePolicy(t *testing.T) {
+	env := NewEnv(nullTraceWriter{})
+	msg := func(b byte, n int) *outMsg { return &outMsg{data: append([]byte{b}, make([]byte, n-1)...)} }
+	for _, policy := range []SendPolicy{SendBlock, SendDropNewest, SendDropOldest, SendError} {
+		q := newSendQueue(env, NewAmb("test", env))
+		q.maxPackets, q.maxBytes, q.policy = 3, 100, policy
+		// The byte limit fills the queue first, then the packet limit
+		for i, n := range []int{60, 30} {
+			if err := q.push(msg(byte(i), n), nil, nil, true); err != nil {
+				t.Fatalf("policy %d: push %d (%s)", policy, i, err)
+			}
+		}
+		deadline := make(chan struct{})
+		close(deadline)
+		err := q.push(msg(2, 20), deadline, nil, true)
+		var want []byte
+		switch policy {
+		case SendBlock:
+			want = []byte{0, 1}
+			if err != ErrTimeout {
+				t.Errorf("blocking push: %v", err)
+			}
+		case SendDropNewest:
+			want = []byte{0, 1}
+		case SendDropOldest:
+			want = []byte{1, 2}
+		case SendError:
+			want = []byte{0, 1}
+			if err != ErrFull {
+				t.Errorf("failing push: %v", err)
+			}
+		}
+		if policy != SendBlock && policy != SendError && err != nil {
+			t.Errorf("policy %d: push (%s)", policy, err)
+		}
+		for i, b := range want {
+			if m := q.pop(); m == nil || m.data[0] != b {
+				t.Fatalf("policy %d: pop %d: expecting %d, got %v", policy, i, b, m)
+			}
+		}
+		if m := q.pop(); m != nil || q.bytes != 0 {
+			t.Errorf("policy %d: pop from empty queue: %v, %d bytes", policy, m, q.bytes)
+		}
+		// A message beyond the byte limit goes into an empty queue
+		if err := q.push(msg(3, 200), nil, nil, true); err != nil || q.pop() == nil {
+			t.Errorf("policy %d: large message (%v)", policy, err)
+		}
+	}
+}
+
+func TestSendQueueWritable(t *testing.T) {
+	env := NewEnv(nullTraceWriter{})
+	q := newSendQueue(env, NewAmb("test", env))
+	q.maxPackets = 1
+	if err := q.push(&outMsg{data: []byte{0}}, nil, nil, false); err != nil {
+		t.Fatalf("push (%s)", err)
+	}
+	writable := q.Writable()
+	if isClosed(writable) {
+		t.Errorf("full queue is writable")
+	}
+	if err := q.push(&outMsg{data: []byte{1}}, nil, nil, false); err != ErrWouldBlock {
+		t.Errorf("push into full queue: expecting %s, encountered %v", ErrWouldBlock, err)
+	}
+	q.pop()
+	if !isClosed(writable) || !isClosed(q.Writable()) {
+		t.Errorf("queue with room is not writable")
+	}
+	q.push(&outMsg{data: []byte{2}}, nil, nil, false)
+	writable = q.Writable()
+	q.Close()
+	if !isClosed(writable) {
+		t.Errorf("closed queue is not writable")
+	}
+}
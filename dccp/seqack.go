@@ -7,7 +7,6 @@ package dccp
 // PlaceSeqAck() updates the socket registers upon
 // receiving a header from the other side.
 func (c *Conn) PlaceSeqAck(h *Header) {
-	c.AssertLocked()
 
 	// Update GSR
 	gsr := c.socket.GetGSR()
@@ -24,10 +23,10 @@ const (
 	seqAckNormal = iota + 1
 	seqAckAbnormal
 	seqAckSyncAck
+	seqAckSync
 )
 
 func (c *Conn) WriteSeqAck(h *writeHeader) {
-	c.AssertLocked()
 	switch h.SeqAckType {
 	case seqAckNormal:
 		c.takeSeqAck(&h.Header)
@@ -39,13 +38,17 @@ func (c *Conn) WriteSeqAck(h *writeHeader) {
 			panic("SyncAck without a Sync")
 		}
 		h.Header.AckNo = h.InResponseTo.SeqNo
+	case seqAckSync:
+		c.takeSeqAck(&h.Header)
+		if h.InResponseTo.Type != Reset {
+			h.Header.AckNo = h.InResponseTo.SeqNo
+		}
 	default:
 		panic("missing seq ack type")
 	}
 }
 
 func (c *Conn) takeSeqAck(h *Header) *Header {
-	c.AssertLocked()
 
 	h.SeqNo = max64(c.socket.GetISS(), c.socket.GetGSS() + 1)
 	c.socket.SetGSS(h.SeqNo)
@@ -55,8 +58,12 @@ func (c *Conn) takeSeqAck(h *Header) *Header {
 }
 
 func (c *Conn) takeAbnormalSeqAck(h, inResponseTo *Header) *Header {
-	c.AssertLocked()
+	return abnormalSeqAck(h, inResponseTo)
+}
 
+// abnormalSeqAck fills in the sequence and acknowledgement numbers of h, a Reset in response
+// to inResponseTo from a connection that has no sequence numbers of its own, Section 8.3.1
+func abnormalSeqAck(h, inResponseTo *Header) *Header {
 	if inResponseTo.HasAckNo() {
 		h.SeqNo = inResponseTo.AckNo + 1
 	} else {
@@ -65,3 +72,17 @@ func (c *Conn) takeAbnormalSeqAck(h, inResponseTo *Header) *Header {
 	h.AckNo = inResponseTo.SeqNo
 	return h
 }
+
+// allowSync returns true if a Sync can be sent in response to a sequence-invalid packet without
+// exceeding SYNC_RATE_LIMIT Syncs per second, Section 7.5.4
+func (c *Conn) allowSync() bool {
+	now := c.env.nowNano()
+	if now-c.syncTime >= 1e9 {
+		c.syncTime, c.syncCount = now, 0
+	}
+	if c.syncCount >= SYNC_RATE_LIMIT {
+		return false
+	}
+	c.syncCount++
+	return true
+}
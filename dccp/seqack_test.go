@@ -0,0 +1,79 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"testing"
+)
+
+type nullTraceWriter struct{}
+
+func (nullTraceWriter) Write(*Trace) {}
+func (nullTraceWriter) Sync() error  { return nil }
+func (nullTraceWriter) Close() error { return nil }
+
+// newSeqAckConn returns an OPEN connection, with no link or congestion control, whose
+// sequence state is suitable for testing the sequence number checks of Section 7.5
+func newSeqAckConn() *Conn {
+	env := NewEnv(nullTraceWriter{})
+	c := &Conn{env: env, amb: NewAmb("test", env)}
+	c.socket.SetState(OPEN)
+	c.socket.SetSWAF(100)
+	c.socket.SetSWBF(100)
+	c.socket.ISS, c.socket.ISR = 1000, 5000
+	c.socket.SetGSS(1500)
+	c.socket.SetGAR(1490)
+	c.socket.SetGSR(5500)
+	return c
+}
+
+func TestSyncFor(t *testing.T) {
+	c := newSeqAckConn()
+	data := &Header{Type: DataAck, X: true, SeqNo: 9000, AckNo: 1200}
+	g := c.generateSyncFor(data)
+	c.WriteSeqAck(g)
+	if g.AckNo != 9000 {
+		t.Errorf("sync for data: expecting ack of 9000, encountered %d", g.AckNo)
+	}
+	if g.SeqNo != 1501 {
+		t.Errorf("sync for data: expecting seqno 1501, encountered %d", g.SeqNo)
+	}
+	reset := &Header{Type: Reset, X: true, SeqNo: 9001, AckNo: 1200}
+	g = c.generateSyncFor(reset)
+	c.WriteSeqAck(g)
+	if g.AckNo != 5500 {
+		t.Errorf("sync for reset: expecting ack of GSR 5500, encountered %d", g.AckNo)
+	}
+}
+
+func TestSyncResynchronizes(t *testing.T) {
+	c := newSeqAckConn()
+	// The other side has moved far beyond our Sequence Window
+	data := &Header{Type: DataAck, X: true, SeqNo: 9000, AckNo: 1500}
+	if c.step6_CheckSeqNo(data) == nil {
+		t.Fatalf("accepted out-of-window packet")
+	}
+	// Its SyncAck, acknowledging our Sync, brings GSR up to date
+	syncAck := &Header{Type: SyncAck, X: true, SeqNo: 9001, AckNo: 1501}
+	c.socket.SetGSS(1501)
+	if err := c.step5_PrepSeqNoForSync(syncAck); err != nil {
+		t.Fatalf("dropped SyncAck (%s)", err)
+	}
+	if err := c.step6_CheckSeqNo(syncAck); err != nil {
+		t.Fatalf("SyncAck out of window (%s)", err)
+	}
+	if gsr := c.socket.GetGSR(); gsr != 9001 {
+		t.Errorf("expecting GSR 9001, encountered %d", gsr)
+	}
+	data = &Header{Type: DataAck, X: true, SeqNo: 9002, AckNo: 1501}
+	if err := c.step6_CheckSeqNo(data); err != nil {
+		t.Errorf("dropped packet after resync (%s)", err)
+	}
+	// A SyncAck that does not acknowledge a packet we sent is ignored
+	syncAck = &Header{Type: SyncAck, X: true, SeqNo: 20000, AckNo: 3000}
+	if c.step5_PrepSeqNoForSync(syncAck) == nil {
+		t.Errorf("accepted SyncAck outside the Ack Window")
+	}
+}
@@ -4,9 +4,21 @@
 
 package dccp
 
-// After '8.1.2. Service Codes'
+import (
+	"bytes"
+	"strconv"
+	"sync/atomic"
+)
 
-func isValidServiceCode(sc uint32) bool { return sc != 4294967295 }
+// ServiceCode identifies the application-level service that a client asks for in its Request,
+// Section 8.1.2. Service codes are registered with IANA, and are usually written in their
+// textual form, e.g. SC:fdpz, see String and ParseServiceCode.
+type ServiceCode uint32
+
+// InvalidServiceCode is reserved; no service may use it, Section 8.1.2
+const InvalidServiceCode ServiceCode = 4294967295
+
+func isValidServiceCode(sc ServiceCode) bool { return sc != InvalidServiceCode }
 
 // isASCIIServiceCodeChar() returns true if c@ is a ServiceCode character
 // that can be displayed in ASCII
@@ -32,39 +44,173 @@ func isASCIIServiceCodeChar(c byte) bool {
 	return false
 }
 
-func serviceCodeToSlice(u uint32) []byte {
+// String returns the textual form of sc, Section 8.1.2. If the four bytes of sc, in network
+// order, are all displayable characters, this is "SC:" followed by the characters, with
+// trailing spaces omitted, as in "SC:fdpz". Otherwise, it is "SC=" followed by the decimal
+// value of sc, as in "SC=1".
+func (sc ServiceCode) String() string {
 	p := make([]byte, 4)
-	p[0] = byte(u >> 3 * 8)
-	p[1] = byte((u >> 2 * 8) & 0xff)
-	p[2] = byte((u >> 1 * 8) & 0xff)
-	p[3] = byte(u & 0xff)
-	return p
+	EncodeUint32(uint32(sc), p)
+	p = bytes.TrimRight(p, " ")
+	if len(p) == 0 {
+		return "SC=" + strconv.FormatUint(uint64(sc), 10)
+	}
+	for _, c := range p {
+		if !isASCIIServiceCodeChar(c) {
+			return "SC=" + strconv.FormatUint(uint64(sc), 10)
+		}
+	}
+	return "SC:" + string(p)
+}
+
+// ParseServiceCode parses the textual form of a service code, as returned by String. Both
+// "SC:" followed by one to four characters, which are padded with spaces, and "SC=" followed
+// by a decimal number are accepted. The invalid service code is rejected.
+func ParseServiceCode(s string) (ServiceCode, error) {
+	if len(s) < 4 {
+		return 0, ErrSyntax
+	}
+	var sc ServiceCode
+	switch s[:3] {
+	case "SC:":
+		chars := s[3:]
+		if len(chars) > 4 {
+			return 0, ErrSyntax
+		}
+		p := []byte("    ")
+		for i := 0; i < len(chars); i++ {
+			if !isASCIIServiceCodeChar(chars[i]) {
+				return 0, ErrSyntax
+			}
+			p[i] = chars[i]
+		}
+		sc = ServiceCode(DecodeUint32(p))
+	case "SC=":
+		u, err := strconv.ParseUint(s[3:], 10, 32)
+		if err != nil {
+			return 0, ErrSyntax
+		}
+		sc = ServiceCode(u)
+	default:
+		return 0, ErrSyntax
+	}
+	if !isValidServiceCode(sc) {
+		return 0, ErrInvalid
+	}
+	return sc, nil
 }
 
-func sliceToServiceCode(p []byte) uint32 {
-	var s uint32
-	s = uint32(p[0])
-	s <<= 8
-	s |= uint32(p[1])
-	s <<= 8
-	s |= uint32(p[2])
-	s <<= 8
-	s |= uint32(p[3])
-	return s
+// MarshalText implements encoding.TextMarshaler
+func (sc ServiceCode) MarshalText() ([]byte, error) {
+	return []byte(sc.String()), nil
 }
 
-// TODO: Implement additional string representations according to '8.1.2. Service Codes'
+// UnmarshalText implements encoding.TextUnmarshaler
+func (sc *ServiceCode) UnmarshalText(text []byte) error {
+	x, err := ParseServiceCode(string(text))
+	if err != nil {
+		return err
+	}
+	*sc = x
+	return nil
+}
 
-func ServiceCodeString(code uint32) string {
-	return "SC:" + string(serviceCodeToSlice(code))
+// ServiceCodesOption carries the candidate service codes of a Request beyond the first one, which
+// is placed in the Service Code field, in order of preference. It allows a server to pick the
+// first service it provides among several acceptable ones, in the spirit of RFC 5595, Section
+// 2, instead of resetting with Bad Service Code. The option is a non-standard extension, under
+// the reserved option number OptionServiceCodes, which a future assignment may give another
+// meaning. It is therefore off unless EnableServiceCodesOption turns it on at both endpoints.
+type ServiceCodesOption struct {
+	ServiceCodes []ServiceCode
 }
 
-func ParseServiceCode(p []byte) (uint32, error) {
-	if len(p) != 7 {
-		return 0, ErrSyntax
+func (opt *ServiceCodesOption) Encode() (*Option, error) {
+	if len(opt.ServiceCodes) == 0 || 4*len(opt.ServiceCodes) > 255-2 {
+		return nil, ErrOverflow
 	}
-	if string(p[:3]) != "SC:" {
-		return 0, ErrSyntax
+	d := make([]byte, 4*len(opt.ServiceCodes))
+	for i, sc := range opt.ServiceCodes {
+		EncodeUint32(uint32(sc), d[4*i:4*i+4])
 	}
-	return sliceToServiceCode(p[3:7]), nil
+	return &Option{
+		Type:      OptionServiceCodes,
+		Data:      d,
+		Mandatory: false,
+	}, nil
+}
+
+func DecodeServiceCodesOption(opt *Option) *ServiceCodesOption {
+	if opt.Type != OptionServiceCodes || len(opt.Data) == 0 || len(opt.Data)%4 != 0 {
+		return nil
+	}
+	codes := make([]ServiceCode, len(opt.Data)/4)
+	for i := range codes {
+		codes[i] = ServiceCode(DecodeUint32(opt.Data[4*i : 4*i+4]))
+	}
+	return &ServiceCodesOption{ServiceCodes: codes}
+}
+
+// serviceCodesOption is non-zero if EnableServiceCodesOption turned the ServiceCodesOption on
+var serviceCodesOption int32
+
+// EnableServiceCodesOption turns the non-standard ServiceCodesOption on or off for the whole
+// process. While it is off, the default, a client that offers several service codes sends only
+// the first one, and a server ignores the option in Requests. It should only be turned on when
+// all peers are known to speak this extension.
+func EnableServiceCodesOption(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&serviceCodesOption, v)
+}
+
+// serviceCodesOptionEnabled returns true if EnableServiceCodesOption turned the option on
+func serviceCodesOptionEnabled() bool {
+	return atomic.LoadInt32(&serviceCodesOption) != 0
+}
+
+// requestServiceCodes returns the candidate service codes of the Request h, in order of preference
+func requestServiceCodes(h *Header) []ServiceCode {
+	codes := []ServiceCode{h.ServiceCode}
+	if !serviceCodesOptionEnabled() {
+		return codes
+	}
+	for _, o := range h.Options {
+		if sco := DecodeServiceCodesOption(o); sco != nil {
+			return append(codes, sco.ServiceCodes...)
+		}
+	}
+	return codes
+}
+
+// chooseServiceCode returns the first candidate service code of the Request h that this server
+// provides. If the server was not given any service codes, it accepts the first candidate.
+func (c *Conn) chooseServiceCode(h *Header) (ServiceCode, bool) {
+	return chooseServiceCode(c.serviceCodes, h)
+}
+
+// chooseServiceCode returns the first candidate service code of the Request h among codes, or
+// the first candidate if codes is empty
+func chooseServiceCode(codes []ServiceCode, h *Header) (ServiceCode, bool) {
+	candidates := requestServiceCodes(h)
+	if len(codes) == 0 {
+		return candidates[0], true
+	}
+	for _, sc := range candidates {
+		if hasServiceCode(codes, sc) {
+			return sc, true
+		}
+	}
+	return 0, false
+}
+
+func hasServiceCode(codes []ServiceCode, sc ServiceCode) bool {
+	for _, x := range codes {
+		if x == sc {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,71 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "testing"
+
+func TestServiceCodeString(t *testing.T) {
+	for _, x := range []struct {
+		sc ServiceCode
+		s  string
+	}{
+		{1717858426, "SC:fdpz"},
+		{1920233504, "SC:rtp"},
+		{1, "SC=1"},
+		{0, "SC=0"},
+		{0x20202020, "SC=538976288"},
+	} {
+		if s := x.sc.String(); s != x.s {
+			t.Errorf("%d: expecting %s, got %s", uint32(x.sc), x.s, s)
+		}
+		sc, err := ParseServiceCode(x.s)
+		if err != nil || sc != x.sc {
+			t.Errorf("parsing %s: expecting %d, got %d (%v)", x.s, uint32(x.sc), uint32(sc), err)
+		}
+	}
+}
+
+func TestParseServiceCode(t *testing.T) {
+	if sc, err := ParseServiceCode("SC:rtp "); err != nil || sc != 1920233504 {
+		t.Errorf("padded code: got %d (%v)", uint32(sc), err)
+	}
+	for _, s := range []string{"", "SC:", "SC=", "SC:toolong", "SC:a~b", "SC=-1", "SC=4294967296", "sc:fdpz", "1717858426"} {
+		if _, err := ParseServiceCode(s); err != ErrSyntax {
+			t.Errorf("%q: expecting %s, got %v", s, ErrSyntax, err)
+		}
+	}
+	if _, err := ParseServiceCode("SC=4294967295"); err != ErrInvalid {
+		t.Errorf("invalid code: expecting %s, got %v", ErrInvalid, err)
+	}
+	var sc ServiceCode
+	if err := sc.UnmarshalText([]byte("SC:fdpz")); err != nil || sc != 1717858426 {
+		t.Errorf("unmarshal: got %d (%v)", uint32(sc), err)
+	}
+}
+
+// TestServiceCodesOptIn checks that the ServiceCodesOption is neither sent nor read unless it
+// is turned on
+func TestServiceCodesOptIn(t *testing.T) {
+	c := &Conn{}
+	codes := []ServiceCode{1, 2, 3}
+	if h := c.generateRequest(codes); len(h.Header.Options) != 0 {
+		t.Errorf("option sent while off: %v", h.Header.Options)
+	}
+	EnableServiceCodesOption(true)
+	h := c.generateRequest(codes)
+	EnableServiceCodesOption(false)
+	if len(h.Header.Options) != 1 || h.Header.Options[0].Type != OptionServiceCodes {
+		t.Fatalf("option not sent while on: %v", h.Header.Options)
+	}
+	if got := requestServiceCodes(&h.Header); len(got) != 1 || got[0] != 1 {
+		t.Errorf("option read while off: %v", got)
+	}
+	EnableServiceCodesOption(true)
+	got := requestServiceCodes(&h.Header)
+	EnableServiceCodesOption(false)
+	if len(got) != 3 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("option not read while on: %v", got)
+	}
+}
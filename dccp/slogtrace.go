@@ -0,0 +1,81 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// SlogTraceWriter is a TraceWriter that forwards traces to a *slog.Logger, so that DCCP traces
+// join the logs of an application and go through its handlers. Error and Warn events log at the
+// levels of the same name, Info events at LevelInfo, and all others at LevelDebug. The comment
+// of a trace is the message, and the rest of it are attributes:
+//
+//	labels   label stack of the Amb, joined by "/", like "client/conn"
+//	event    kind of event, like "Write"
+//	dccp.t   DCCP runtime time of the event, as a time.Duration
+//	state    state of the connection, if known
+//	type, seqno, ackno
+//	         header fields, if the event is about a packet
+//	sample   series, value and unit of a time series data point, if any
+type SlogTraceWriter struct {
+	logger *slog.Logger
+}
+
+// NewSlogTraceWriter creates a SlogTraceWriter that logs to logger
+func NewSlogTraceWriter(logger *slog.Logger) *SlogTraceWriter {
+	return &SlogTraceWriter{logger: logger}
+}
+
+// SlogLevel returns the slog level that SlogTraceWriter logs events of kind e at
+func SlogLevel(e Event) slog.Level {
+	switch e {
+	case EventError:
+		return slog.LevelError
+	case EventWarn:
+		return slog.LevelWarn
+	case EventInfo:
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug
+}
+
+// Write implements TraceWriter.Write
+func (t *SlogTraceWriter) Write(r *Trace) {
+	ctx := context.Background()
+	level := SlogLevel(r.Event)
+	if !t.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("labels", strings.Join(r.Labels, "/")),
+		slog.String("event", r.Event.String()),
+		slog.Duration("dccp.t", time.Duration(r.Time)),
+	}
+	if r.State != "" {
+		attrs = append(attrs, slog.String("state", r.State))
+	}
+	if r.Type != "" {
+		attrs = append(attrs, slog.String("type", r.Type), slog.Int64("seqno", r.SeqNo), slog.Int64("ackno", r.AckNo))
+	}
+	if s, ok := r.Sample(); ok {
+		attrs = append(attrs, slog.Group("sample",
+			slog.String("series", s.Series), slog.Float64("value", s.Value), slog.String("unit", s.Unit)))
+	}
+	msg := r.Comment
+	if msg == "" {
+		msg = r.Event.String()
+	}
+	t.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// Sync implements TraceWriter.Sync. Handlers of slog write through, so it does nothing.
+func (t *SlogTraceWriter) Sync() error { return nil }
+
+// Close implements TraceWriter.Close. The logger stays open, as it belongs to the application.
+func (t *SlogTraceWriter) Close() error { return nil }
@@ -0,0 +1,35 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogTraceWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	env := NewEnv(NewSlogTraceWriter(logger))
+	amb := NewAmb("server", env).Refine("conn")
+	amb.E(EventWrite, "Write to header link", &Header{Type: Ack, SeqNo: 9, AckNo: 8})
+	amb.E(EventWarn, "Idle timeout", &Header{Type: Reset, SeqNo: 10, AckNo: 8})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expecting only the Warn event above LevelInfo, encountered:\n%s", buf.String())
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatalf("line %q is not JSON (%s)", lines[0], err)
+	}
+	if m["level"] != "WARN" || m["msg"] != "Idle timeout" || m["labels"] != "server/conn" ||
+		m["event"] != "Warn" || m["type"] != "Reset" || m["seqno"] != 10.0 || m["ackno"] != 8.0 {
+		t.Errorf("unexpected record %s", lines[0])
+	}
+}
@@ -7,7 +7,6 @@ package dccp
 import (
 	"bytes"
 	"fmt"
-	"math/rand"
 )
 
 // socket is a data structure, maintaining the DCCP socket variables.
@@ -52,7 +51,7 @@ type socket struct {
 
 	State       int
 	Server      bool   // True if the endpoint is a server, false if it is a client
-	ServiceCode uint32 // The service code of this connection
+	ServiceCode ServiceCode // The service code of this connection
 
 	PMTU  int32 // Path Maximum Transmission Unit
 	CCMPS int32 // Congestion Control Maximum Packet Size
@@ -63,15 +62,17 @@ type socket struct {
 func (s *socket) String() string {
 	var w bytes.Buffer
 	fmt.Fprintf(&w, "State=%s:%s %s, ISS=%d, ISR=%d, OSR=%d, GSS=%d, GSR=%d, GAR=%d, SWAF=%d, SWBF=%d, RTT=%d",
-		StateString(s.State), ServerString(s.Server), ServiceCodeString(s.ServiceCode),
+		StateString(s.State), ServerString(s.Server), s.ServiceCode.String(),
 		s.ISS, s.ISR, s.OSR, s.GSS, s.GSR, s.GAR, s.SWAF, s.SWBF, s.RTT)
 	return string(w.Bytes())
 }
 
 const (
 	SEQWIN_INIT             = 100      // Initial value for SWAF and SWBF, Section 7.5.2
-	SEQWIN_FIXED            = 700      // Large enough constant for SWAF/SWBF until the feature is implemented
-	SEQWIN_MAX              = 2^46 - 1 // Maximum acceptable SWAF and SWBF value
+	SEQWIN_FIXED            = 700      // Sequence Window announced by each endpoint upon connection start
+	SEQWIN_MIN              = 32       // Minimum acceptable SWAF and SWBF value, Section 7.5.2
+	SEQWIN_MAX              = 1<<46 - 1 // Maximum acceptable SWAF and SWBF value
+	SYNC_RATE_LIMIT         = 8        // Maximum Syncs per second sent in response to sequence-invalid packets
 	RoundtripDefault        = 2e8      // 0.2 sec, default Round-Trip Time when no measurement is available
 	RoundtripMin                 = 2e6      // ...
 	MSL                     = 2 * 60e9 // 2 mins in nanoseconds, Maximum Segment Lifetime, Section 3.4
@@ -142,17 +143,18 @@ func (s *socket) IsServer() bool   { return s.Server }
 func (s *socket) GetState() int  { return s.State }
 func (s *socket) SetState(v int) { s.State = v }
 
-func (s *socket) SetServiceCode(v uint32) { s.ServiceCode = v }
-func (s *socket) GetServiceCode() uint32  { return s.ServiceCode }
+func (s *socket) SetServiceCode(v ServiceCode) { s.ServiceCode = v }
+func (s *socket) GetServiceCode() ServiceCode { return s.ServiceCode }
 
-// ChooseISS chooses a safe Initial Sequence Number
-func (s *socket) ChooseISS() int64 {
-	iss := rand.Int63n(0xffffff-1) + 1
+// ChooseISS chooses a safe Initial Sequence Number, at random from the source of env
+func (s *socket) ChooseISS(env *Env) int64 {
+	iss := env.Int63n(0xffffff-1) + 1
 	s.ISS = iss
 	return iss
 }
 func (s *socket) GetISS() int64 { return s.ISS }
 
+func (s *socket) GetISR() int64  { return s.ISR }
 func (s *socket) SetISR(v int64) { s.ISR = v }
 
 func (s *socket) GetOSR() int64  { return s.OSR }
@@ -169,17 +171,19 @@ func (s *socket) GetGAR() int64     { return s.GAR }
 func (s *socket) SetGAR(v int64)    { s.GAR = v }
 func (s *socket) UpdateGAR(v int64) { s.GAR = max64(s.GAR, v) }
 
-// TODO: Address the last paragraph of Section 7.5.1 regarding SWL,AWL calculation
-
+func (s *socket) GetSWAF() int64  { return s.SWAF }
 func (s *socket) SetSWAF(v int64) { s.SWAF = v }
+func (s *socket) GetSWBF() int64  { return s.SWBF }
 func (s *socket) SetSWBF(v int64) { s.SWBF = v }
 
-// GetSWLH() computes SWL and SWH, see Section 7.5.1
+// GetSWLH() computes SWL and SWH, see Section 7.5.1. Per the last paragraph of Section 7.5.1,
+// SWL is never less than the initial sequence number received.
 func (s *socket) GetSWLH() (SWL int64, SWH int64) {
 	return max64(s.GSR+1-s.SWBF/4, s.ISR), s.GSR + (3*s.SWBF)/4
 }
 
-// GetAWLH() computes AWL and AWH, see Section 7.5.1
+// GetAWLH() computes AWL and AWH, see Section 7.5.1. Per the last paragraph of Section 7.5.1,
+// AWL is never less than the initial sequence number sent.
 func (s *socket) GetAWLH() (AWL int64, AWH int64) {
 	return max64(s.GSS+1-s.SWAF, s.ISS), s.GSS
 }
@@ -0,0 +1,83 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "time"
+
+// ConnStats is a snapshot of the state of a connection and of its counters, as returned by
+// Conn.Stats. Bytes count the wire-format footprint of packets, headers included.
+type ConnStats struct {
+	State           string        // State of the connection, see StateString
+	RTT             time.Duration // Smoothed round-trip time, or RoundtripDefault before a sample
+	PacketsSent     int64         // Packets written to the HeaderConn
+	BytesSent       int64         // Bytes written to the HeaderConn
+	PacketsReceived int64         // Packets read from the HeaderConn
+	BytesReceived   int64         // Bytes read from the HeaderConn
+	Retransmits     int64         // Requests, Responses and PARTOPEN Acks sent again in the handshake
+	SenderStats                   // Congestion state of the sender CCID, if it is a StatsSender
+	Reset           *ResetError   // The Reset that closed the connection, sent or received, or nil
+	ResetSent       bool          // Whether this side sent Reset
+	Err             error         // Reason for the tear down of the connection, or nil while it is up
+}
+
+// Stats returns a snapshot of the state of the connection and of its counters since it was
+// created. It is cheap enough to be polled while the connection runs, and needs no tracing.
+func (c *Conn) Stats() ConnStats {
+	var s ConnStats
+	c.do(func() {
+		s = c.stats
+		s.State = StateString(c.socket.GetState())
+		s.RTT = time.Duration(c.socket.GetRTT())
+		s.Err = c.err
+	})
+	if ss, ok := c.scc.(StatsSender); ok {
+		s.SenderStats = ss.GetStats()
+	}
+	return s
+}
+
+// CCIDState returns snapshots of the internals of the sender and the receiver CCID of the
+// connection, one for each half-connection, or nil for a CCID that is not a StateReporter. The
+// packages of the CCIDs define the types of the snapshots, and typed accessors for them.
+func (c *Conn) CCIDState() (sender, receiver interface{}) {
+	if sr, ok := c.scc.(StateReporter); ok {
+		sender = sr.CCIDState()
+	}
+	if sr, ok := c.rcc.(StateReporter); ok {
+		receiver = sr.CCIDState()
+	}
+	return sender, receiver
+}
+
+// countWrite counts the packet h, which is about to be written to the HeaderConn
+func (c *Conn) countWrite(h *writeHeader) {
+	n, _ := h.Footprint()
+	c.stats.PacketsSent++
+	c.stats.BytesSent += int64(n)
+	countPacket(h.Type, true)
+	if h.Type == Reset {
+		countResetCode(h.ResetCode, true)
+	}
+	if h.last && h.Type == Reset {
+		c.countReset(&h.Header, true)
+	}
+}
+
+// countRead counts the packet h, just read from the HeaderConn
+func (c *Conn) countRead(h *Header) {
+	n, _ := h.Footprint()
+	c.stats.PacketsReceived++
+	c.stats.BytesReceived += int64(n)
+	countPacket(h.Type, false)
+}
+
+// countReset records h as the Reset that closed the connection, unless one already has
+func (c *Conn) countReset(h *Header, sent bool) {
+	if c.stats.Reset != nil {
+		return
+	}
+	c.stats.Reset = newResetError(h)
+	c.stats.ResetSent = sent
+}
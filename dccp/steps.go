@@ -4,7 +4,10 @@
 
 package dccp
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // Step 2, Section 8.5: Check ports and process TIMEWAIT state
 func (c *Conn) step2_ProcessTIMEWAIT(h *Header) error {
@@ -25,7 +28,21 @@ func (c *Conn) step3_ProcessLISTEN(h *Header) error {
 		return nil
 	}
 	if h.Type == Request {
-		c.gotoRESPOND(h.ServiceCode, h.SeqNo)
+		serviceCode, ok := c.chooseServiceCode(h)
+		if !ok {
+			c.amb.E(EventWarn, "No service code provided", h)
+			c.inject(c.generateAbnormalReset(ResetBadServiceCode, h))
+			return ErrDrop
+		}
+		if err := c.filterRequest(h, serviceCode); err != nil {
+			return err
+		}
+		if !c.admitHalfOpen() {
+			c.amb.E(EventWarn, "Backlog full", h)
+			c.inject(c.generateAbnormalReset(ResetTooBusy, h))
+			return ErrDrop
+		}
+		c.gotoRESPOND(serviceCode, h.SeqNo)
 		return nil
 	}
 	// For forward compatibility, if we receive a non-Request packet
@@ -60,11 +77,17 @@ func (c *Conn) step5_PrepSeqNoForSync(h *Header) error {
 	if h.Type != Sync && h.Type != SyncAck {
 		return nil
 	}
-	swl, _ := c.socket.GetSWLH()
+	swl, swh := c.socket.GetSWLH()
 	if c.socket.InAckWindow(h.AckNo) && h.SeqNo >= swl {
+		// A Sync or SyncAck beyond the Sequence Window resynchronizes us with the other side,
+		// whose sequence numbers moved ahead while its packets were lost, Section 7.5.4
+		if h.SeqNo > swh {
+			c.amb.E(EventInfo, fmt.Sprintf("Resync, GSR %d to %d", c.socket.GetGSR(), h.SeqNo), h)
+		}
 		c.socket.UpdateGSR(h.SeqNo)
 		return nil
 	}
+	c.amb.E(EventDrop, "Sync out of ack window", h)
 	return ErrDrop
 }
 
@@ -74,6 +97,11 @@ func (c *Conn) step6_CheckSeqNo(h *Header) error {
 	if !h.X {
 		return ErrDrop
 	}
+	// A Reset received in REQUEST has already been checked against the Ack Window in Step 4.
+	// Its sequence number is arbitrary, e.g. zero if it answers our Request, Section 8.1.1.
+	if h.Type == Reset && c.socket.GetState() == REQUEST {
+		return nil
+	}
 
 	swl, swh := c.socket.GetSWLH()
 	awl, awh := c.socket.GetAWLH()
@@ -95,15 +123,13 @@ func (c *Conn) step6_CheckSeqNo(h *Header) error {
 		}
 		return nil
 	} else {
-		var g *writeHeader = c.generateSync()
-		if h.Type == Reset {
-			// Send Sync packet acknowledging S.GSR
-			g.AckNo = gsr
-		} else {
-			// Send Sync packet acknowledging P.seqno
-			g.AckNo = h.SeqNo
+		if !c.allowSync() {
+			c.amb.E(EventDrop, "Out-of-window, Sync rate limit", h)
+			return ErrDrop
 		}
-		c.inject(g)
+		// Send Sync packet acknowledging P.seqno, or S.GSR if P is a Reset
+		c.amb.E(EventWarn, fmt.Sprintf("Out-of-window, SWL=%d SWH=%d AWL=%d AWH=%d", lswl, swh, lawl, awh), h)
+		c.inject(c.generateSyncFor(h))
 		return ErrDrop
 	}
 	panic("unreach")
@@ -120,9 +146,7 @@ func (c *Conn) step7_CheckUnexpectedTypes(h *Header) error {
 		(state >= OPEN && h.Type == Request && h.SeqNo >= osr) ||
 		(state >= OPEN && h.Type == Response && h.SeqNo >= osr) ||
 		(state == RESPOND && h.Type == Data) {
-		g := c.generateSync()
-		g.AckNo = h.SeqNo
-		c.inject(g)
+		c.inject(c.generateSyncFor(h))
 		return ErrDrop
 	}
 	return nil
@@ -132,8 +156,24 @@ func (c *Conn) step7_CheckUnexpectedTypes(h *Header) error {
 // Section 7.4: A received packet becomes acknowledgeable when Step 8 is reached.
 func (c *Conn) step8_OptionsAndMarkAckbl(h *Header) error {
 
+	// Process feature negotiation options, Section 6
+	if err := c.features.OnRead(h.Options, c.socket.IsServer()); err != nil {
+		c.amb.E(EventWarn, "Feature negotiation error", h)
+		c.reset(ResetOptionError, ErrAbort)
+		return ErrDrop
+	}
+	c.syncWithFeatures()
+	if !c.agreesOnCCID() {
+		c.amb.E(EventWarn, "CCID negotiation failed", h)
+		c.reset(ResetOptionError, ErrAbort)
+		return ErrDrop
+	}
+	if c.features.Local(FeatureSendAckVector) != 0 {
+		c.ackVector.Record(h.SeqNo, h.ECN)
+	}
+
 	defer c.syncWithCongestionControl()
-	now := c.env.Now()
+	now := c.env.nowNano()
 	rsopts := filterCCIDReceiverToSenderOptions(h.Options)
 	if err := c.scc.OnRead(&FeedbackHeader{
 		Type:    h.Type, 
@@ -143,14 +183,15 @@ func (c *Conn) step8_OptionsAndMarkAckbl(h *Header) error {
 		AckNo:   h.AckNo, 
 		Time:    now,
 	}); err != nil {
-		if re, ok := err.(CongestionReset); ok {
+		var re CongestionReset
+		if errors.As(err, &re) {
 			c.reset(re.ResetCode(), ErrAbort)
 			return ErrDrop
 		}
-		if err == ErrDrop {
+		if errors.Is(err, ErrDrop) {
 			return ErrDrop
 		}
-		if err == CongestionAck {
+		if errors.Is(err, CongestionAck) {
 			c.inject(c.generateAck())
 		} else {
 			c.amb.E(EventError, fmt.Sprintf("S·CC read error (%s)", err), h)
@@ -158,27 +199,31 @@ func (c *Conn) step8_OptionsAndMarkAckbl(h *Header) error {
 	}
 	sropts := filterCCIDSenderToReceiverOptions(h.Options)
 	if err := c.rcc.OnRead(&FeedforwardHeader{
-		Type:    h.Type, 
-		X:       h.X, 
-		SeqNo:   h.SeqNo, 
-		CCVal:   h.CCVal, 
-		Options: sropts, 
-		Time:    now, 
-		DataLen: len(h.Data),
+		Type:     h.Type, 
+		X:        h.X, 
+		SeqNo:    h.SeqNo, 
+		CCVal:    h.CCVal, 
+		Options:  sropts, 
+		Time:     now, 
+		DataLen:  len(h.Data),
+		ECN:      h.ECN,
+		NDPCount: findNDPCount(h.Options),
 	}); err != nil {
-		if re, ok := err.(CongestionReset); ok {
+		var re CongestionReset
+		if errors.As(err, &re) {
 			c.reset(re.ResetCode(), ErrAbort)
 			return ErrDrop
 		}
-		if err == ErrDrop {
+		if errors.Is(err, ErrDrop) {
 			return ErrDrop
 		}
-		if err == CongestionAck {
+		if errors.Is(err, CongestionAck) {
 			c.inject(c.generateAck())
 		} else {
 			c.amb.E(EventError, fmt.Sprintf("R·CC read error (%s)", err), h)
 		}
 	}
+	c.countAckRatio(h)
 	return nil
 }
 
@@ -187,7 +232,15 @@ func (c *Conn) step9_ProcessReset(h *Header) error {
 	if h.Type != Reset {
 		return nil
 	}
-	c.setError(ErrAbort) 
+	c.readCCIDReset(h)
+	if len(h.Data) > 0 {
+		c.amb.E(EventInfo, fmt.Sprintf("Reset %d: %q", h.ResetCode, h.Data), h)
+	}
+	re := newResetError(h)
+	c.setError(re)
+	c.countReset(h, false)
+	countResetCode(h.ResetCode, false)
+	c.postEvent(ConnEvent{Kind: ConnReset, State: c.socket.GetState(), Reset: re})
 	c.teardownUser()
 	c.gotoTIMEWAIT()
 	return ErrDrop
@@ -198,6 +251,14 @@ func (c *Conn) step10_ProcessREQUEST2(h *Header) error {
 	if c.socket.GetState() != REQUEST {
 		return nil
 	}
+	// The server must have chosen one of the offered service codes
+	if !hasServiceCode(c.serviceCodes, h.ServiceCode) {
+		c.amb.E(EventWarn, "Response with unexpected service code", h)
+		c.reset(ResetBadServiceCode, ErrAbort)
+		return ErrDrop
+	}
+	c.socket.SetServiceCode(h.ServiceCode)
+	c.readInitCookies(h)
 	c.gotoPARTOPEN()
 
 	return nil
@@ -213,9 +274,14 @@ func (c *Conn) step11_ProcessRESPOND(h *Header) error {
 			panic("GSR != h.SeqNo")
 		}
 		serviceCode := c.socket.GetServiceCode()
-		if h.ServiceCode != serviceCode {
+		if sc, ok := c.chooseServiceCode(h); !ok || sc != serviceCode {
 			return ErrDrop
 		}
+		// The first Request and its duplicates are each answered with a Response, Section 8.1.3
+		if h.SeqNo != c.socket.GetISR() {
+			c.amb.E(EventTurn, "Response resend", h)
+			c.countRetransmit()
+		}
 		c.inject(c.generateResponse(serviceCode))
 	} else {
 		if h.Type != Ack && h.Type != DataAck {
@@ -224,6 +290,11 @@ func (c *Conn) step11_ProcessRESPOND(h *Header) error {
 			// dropped, the server will enter OPEN on a SyncAck.
 			c.amb.E(EventWarn, "Entering OPEN on non-Ack packet", h)
 		}
+		if !c.checkInitCookies(h) {
+			c.amb.E(EventWarn, "Bad Init Cookie echo", h)
+			c.reset(ResetBadInitCookie, ErrAbort)
+			return ErrDrop
+		}
 		c.gotoOPEN(h.SeqNo)
 	}
 	return nil
@@ -265,9 +336,8 @@ func (c *Conn) step14_ProcessClose(h *Header) error {
 	}
 	c.setError(ErrEOF) 
 	c.teardownUser()
+	c.injectLast(c.generateReset(ResetClosed))
 	c.gotoCLOSED()
-	c.inject(c.generateReset(ResetClosed))
-	c.teardownWriteLoop()
 	return ErrDrop
 }
 
@@ -276,6 +346,9 @@ func (c *Conn) step15_ProcessSync(h *Header) error {
 	if h.Type == Sync {
 		c.inject(c.generateSyncAck(h))
 	}
+	if h.Type == SyncAck {
+		c.readMTUProbe(h)
+	}
 	return nil
 }
 
@@ -293,16 +366,29 @@ func (c *Conn) step16_ProcessData(h *Header) error {
 	// DCCP-Data, DCCP-DataAck, and DCCP-Ack packets received in CLOSEREQ or
 	// CLOSING states MAY be either processed or ignored.
 
+	// Drop data with insufficient checksum coverage, Section 9.2.1
+	if !c.acceptCsCov(h) {
+		c.amb.E(EventDrop, "Checksum coverage", h)
+		c.dataDropped.Record(h.SeqNo, DropProtocolConstraints)
+		return nil
+	}
+	// Drop data that fails the Data Checksum check, Section 9.3
+	if code, drop := c.checkDataChecksum(h); drop {
+		c.amb.E(EventDrop, "Data checksum", h)
+		c.dataDropped.Record(h.SeqNo, code)
+		return nil
+	}
+
 	// Drop data packets if application does not read them fast enough
-	c.readAppLk.Lock()
-	if c.readApp != nil {
+	if !isClosed(c.readDone) {
 		if len(c.readApp) < cap(c.readApp) {
-			c.readApp <- h.Data
+			c.readApp <- &appMsg{data: h.Data, info: newMsgInfo(h, c.env.nowNano()), buf: h.buf}
+			c.env.Sent(&c.readAppT)
 		} else {
 			c.amb.E(EventDrop, "Slow app", h)
+			c.dataDropped.Record(h.SeqNo, DropReceiveBuffer)
 		}
 	}
-	c.readAppLk.Unlock()
 
 	return nil
 }
@@ -28,14 +28,14 @@ func (h *Header) String() string {
 	case Data:
 		fmt.Fprintf(&w, "T:%s X:%d SeqNo:%d ··· #D:%d",
 			typeString(h.Type), x, h.SeqNo,
-			len(h.Data))
+			h.DataLen())
 	case Ack:
 		fmt.Fprintf(&w, "T:%s X:%d SeqNo:%d AckNo:%d",
 			typeString(h.Type), x, h.SeqNo, h.AckNo)
 	case DataAck:
 		fmt.Fprintf(&w, "T:%s X:%d SeqNo:%d AckNo:%d ··· #D:%d",
 			typeString(h.Type), x, h.SeqNo, h.AckNo,
-			len(h.Data))
+			h.DataLen())
 	case CloseReq:
 		fmt.Fprintf(&w, "T:%s X:%d SeqNo:%d AckNo:%d",
 			typeString(h.Type), x, h.SeqNo, h.AckNo)
@@ -58,6 +58,14 @@ func (h *Header) String() string {
 	return string(w.Bytes())
 }
 
+// TypeString returns the name of the packet type typ, like "DataAck"
+func TypeString(typ byte) string {
+	if typ >= NumTypes {
+		return "Reserved " + strconv.Itoa(int(typ))
+	}
+	return typeString(typ)
+}
+
 func typeString(typ byte) string {
 	switch typ {
 	case Request:
@@ -84,6 +92,9 @@ func typeString(typ byte) string {
 	panic("un")
 }
 
+// ResetCodeString returns the name of the Reset Code resetCode, like "Connection Refused"
+func ResetCodeString(resetCode byte) string { return resetCodeString(resetCode) }
+
 func resetCodeString(resetCode byte) string {
 	switch resetCode {
 	case ResetUnspecified:
@@ -111,5 +122,12 @@ func resetCodeString(resetCode byte) string {
 	case ResetAgressionPenalty:
 		return "Agression Penalty"
 	}
+	// CCID-specific Reset Codes, Section 10.3
+	if resetCode >= 192 {
+		return "CCID Receiver " + strconv.Itoa(int(resetCode))
+	}
+	if resetCode >= 128 {
+		return "CCID Sender " + strconv.Itoa(int(resetCode))
+	}
 	return strconv.Itoa(int(resetCode))
 }
@@ -4,22 +4,56 @@
 
 package dccp
 
+import "unicode/utf8"
+
+// MaxResetReasonLen is the maximum length, in bytes, of the reason text carried by a Reset
+const MaxResetReasonLen = 256
+
+// Reset aborts the connection with a Reset with Reset Code code, Section 5.6. Codes 128 to 255
+// are CCID-specific and are meant for libraries that implement a CCID. The reason, if not
+// empty, travels as UTF-8 text in the application data of the Reset, to help the other side
+// diagnose the failure. Reset returns ErrInvalid if the code is reserved or the reason is not
+// valid UTF-8, ErrTooBig if the reason exceeds MaxResetReasonLen, and ErrBad if the connection
+// is already closed.
+func (c *Conn) Reset(code byte, reason string) error {
+	if code > ResetAgressionPenalty && code < 128 {
+		return ErrInvalid
+	}
+	if !utf8.ValidString(reason) {
+		return ErrInvalid
+	}
+	if len(reason) > MaxResetReasonLen {
+		return ErrTooBig
+	}
+	var err error
+	c.do(func() {
+		if c.socket.GetState() == CLOSED {
+			err = ErrBad
+			return
+		}
+		c.setError(ErrAbort)
+		h := c.generateReset(code)
+		if reason != "" {
+			h.Data = []byte(reason)
+		}
+		c.injectLast(h)
+		c.gotoCLOSED()
+	})
+	return err
+}
+
 // abortWith() resets the connection with Reset Code resetCode
 func (c *Conn) abortWith(resetCode byte) {
-	c.Lock()
 	c.setError(ErrAbort)
+	// The Reset must be queued before gotoCLOSED tears down the write loop
+	c.injectLast(c.generateReset(resetCode))
 	c.gotoCLOSED()
-	c.inject(c.generateReset(resetCode))
-	c.Unlock()
-	c.teardownUser()
-	c.teardownWriteLoop()
 }
 
 // abort() resets the connection with Reset Code 2, "Aborted"
 func (c *Conn) abort() { c.abortWith(ResetAborted) }
 
 func (c *Conn) setError(err error) {
-	c.AssertLocked()
 	if c.err != nil {
 		return
 	}
@@ -27,47 +61,33 @@ func (c *Conn) setError(err error) {
 }
 
 func (c *Conn) reset(resetCode byte, err error) {
-	c.AssertLocked()
 	c.setError(err)
+	c.injectLast(c.generateReset(resetCode))
 	c.gotoCLOSED()
-	c.inject(c.generateReset(resetCode))
-	c.teardownUser()
-	c.teardownWriteLoop()
 }
 
 // abortQuietly() aborts the connection immediately without sending Reset packets
 func (c *Conn) abortQuietly() {
-	c.Lock()
 	c.setError(ErrAbort)
 	c.gotoCLOSED()
-	c.Unlock()
-	c.teardownUser()
-	c.teardownWriteLoop()
 }
 
-// teardownUser MUST be idempotent. It may be called with or without lock on c.
+// teardownUser stops the delivery of data to the application and the queueing of data from
+// it. It MUST be idempotent.
 func (c *Conn) teardownUser() {
-	c.readAppLk.Lock()
-	if c.readApp != nil {
-		close(c.readApp)
-		c.readApp = nil
-	}
-	c.readAppLk.Unlock()
-	c.writeDataLk.Lock()
-	if c.writeData != nil {
-		close(c.writeData)
-		c.writeData = nil
+	if !isClosed(c.readDone) {
+		close(c.readDone)
+		c.env.Closed(&c.readDoneT)
 	}
-	c.writeDataLk.Unlock()
+	c.writeData.Close()
 }
 
-// teardownWriteLoop MUST be idempotent. It may be called with or without lock on c.
+// teardownWriteLoop MUST be idempotent
 func (c *Conn) teardownWriteLoop() {
-	c.writeNonDataLk.Lock()
-	defer c.writeNonDataLk.Unlock()
-	if c.writeNonData != nil {
+	if !c.writeNonDataClosed {
 		close(c.writeNonData)
-		c.writeNonData = nil
+		c.env.Closed(&c.nonDataT)
+		c.writeNonDataClosed = true
 	}
 	c.scc.Close()
 	c.rcc.Close()
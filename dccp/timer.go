@@ -0,0 +1,58 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+// The protocol timers of a connection, like the retransmission of Requests and the end of
+// TIMEWAIT, and the idle polls of the CCID, keepalives and MTU probes are kept on the timer
+// wheel of its Env, see Env.timerWheel. When a timer fires, the wheel posts its function to
+// the event loop of the connection, see post, so timers act on the connection like arriving
+// packets and application calls do. A connection runs three goroutines, loop, readLoop and
+// writeLoop, from start until it is CLOSED; no goroutines or runtime timers come and go with
+// its state transitions.
+
+// setTimer arranges for fire to be called on loop once wait nanoseconds have passed, and
+// returns the timer. A connection has one protocol timer at a time: the timer set on a state
+// transition replaces that of the previous state. As a timer may have fired, and its function
+// be waiting to run on loop, while it is being replaced, fire checks that it is still due,
+// typically by the state of the connection. No timer is set once the connection is CLOSED.
+func (c *Conn) setTimer(wait int64, fire func()) *wheelTimer {
+	if c.timer != nil {
+		c.wheel.cancel(c.timer)
+		c.timer = nil
+	}
+	if c.socket.GetState() == CLOSED {
+		return nil
+	}
+	c.timer = &wheelTimer{fire: func() { c.post(fire) }}
+	c.wheel.schedule(c.timer, c.env.nowNano()+wait)
+	return c.timer
+}
+
+// setBackOff calls tick on loop at the intervals of b for as long as tick returns true. Once b has
+// run its course, tick is called one last time with timeout true.
+func (c *Conn) setBackOff(b *backOff, tick func(timeout bool) bool) {
+	wait, err := b.Next()
+	var t *wheelTimer
+	t = c.setTimer(wait, func() {
+		// A timer set in the meantime, by a state transition, supersedes the back-off
+		if tick(err != nil) && err == nil && c.timer == t {
+			c.setBackOff(b, tick)
+		}
+	})
+}
+
+// startIdle starts the idle polls of the connection, see idle
+func (c *Conn) startIdle() {
+	c.wheel.schedule(&c.idleTimer, c.env.nowNano())
+}
+
+// stopTimers cancels the protocol timer and the idle polls of a CLOSED connection
+func (c *Conn) stopTimers() {
+	if c.timer != nil {
+		c.wheel.cancel(c.timer)
+		c.timer = nil
+	}
+	c.wheel.cancel(&c.idleTimer)
+}
@@ -0,0 +1,114 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestTimerWheel checks that timers at all levels of the wheel, and beyond its reach, fire in
+// the order of their times and not before them, and that cancelled timers do not fire
+func TestTimerWheel(t *testing.T) {
+	env := NewVirtualEnv(nullTraceWriter{})
+	defer env.Close()
+	w := env.timerWheel()
+	start := env.nowNano()
+
+	var lk sync.Mutex
+	var fired []int64
+	waits := []int64{5e9, 3e6, 0, 300e9, 70e6, 5 * 3600e9, 1e6 + 1, 4e6}
+	timers := make([]*wheelTimer, len(waits))
+	for i, wait := range waits {
+		i := i
+		timers[i] = &wheelTimer{fire: func() {
+			lk.Lock()
+			defer lk.Unlock()
+			if now := env.nowNano(); now < start+waits[i] {
+				t.Errorf("timer of %d ns fired after %d ns", waits[i], now-start)
+			}
+			fired = append(fired, waits[i])
+		}}
+		w.schedule(timers[i], start+wait)
+	}
+	// Cancelled and moved timers
+	w.cancel(timers[7])
+	lk.Lock()
+	waits[1] = 2e6
+	lk.Unlock()
+	w.schedule(timers[1], start+2e6)
+	env.Joiner().Join()
+
+	want := []int64{0, 1e6 + 1, 2e6, 70e6, 5e9, 300e9, 5 * 3600e9}
+	if len(fired) != len(want) {
+		t.Fatalf("fired %v, expecting %v", fired, want)
+	}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Errorf("fired %v, expecting %v", fired, want)
+			break
+		}
+	}
+	if late := env.nowNano() - start - 5*3600e9; late > wheelTick {
+		t.Errorf("last timer fired %d ns late", late)
+	}
+}
+
+// TestConnTimers checks that a timer set on a state transition replaces the previous one, and
+// that a back-off timer ticks, on the loop of the connection, until its back-off runs out
+func TestConnTimers(t *testing.T) {
+	env := NewVirtualEnv(nullTraceWriter{})
+	defer env.Close()
+	c := &Conn{env: env, amb: NewAmb("test", env), wheel: env.timerWheel(), dueReady: make(chan struct{}, 1), loopDone: make(chan struct{})}
+	c.socket.SetState(REQUEST)
+
+	var fired []string
+	c.setTimer(1e9, func() { fired = append(fired, "replaced") })
+	c.setTimer(2e9, func() { fired = append(fired, "timer") })
+
+	// Intervals of 100 ms for at least 250 ms make three ticks. The last one closes the
+	// connection, which ends its loop.
+	var ticks []bool
+	c.setBackOff(newBackOff(100e6, 250e6, 1e9), func(timeout bool) bool {
+		ticks = append(ticks, timeout)
+		if timeout {
+			c.socket.SetState(CLOSED)
+		}
+		return true
+	})
+	env.Go(func() { c.loop(nil) }, "loop")
+	env.Joiner().Join()
+	if len(fired) != 0 {
+		t.Errorf("fired %v", fired)
+	}
+	if len(ticks) != 4 || ticks[0] || ticks[2] || !ticks[3] {
+		t.Errorf("ticks %v", ticks)
+	}
+
+	// No timers are set once the connection is CLOSED
+	if c.setTimer(1e9, func() {}) != nil || c.timer != nil {
+		t.Errorf("timer set on CLOSED connection")
+	}
+}
+
+// BenchmarkTimerWheel moves the timers of many idle connections, as their idle polls do
+func BenchmarkTimerWheel(b *testing.B) {
+	w := newTimerWheel(nil)
+	timers := make([]wheelTimer, 50000)
+	now := w.now()
+	for i := range timers {
+		timers[i].fire = func() {}
+		w.schedule(&timers[i], now+int64(i%200)*1e6+1e9)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.schedule(&timers[i%len(timers)], now+int64(i%200)*1e6+2e9)
+	}
+	b.StopTimer()
+	for i := range timers {
+		w.cancel(&timers[i])
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "time"
+
+// Reclaimer is implemented by SegmentConns and HeaderConns whose endpoint labels, the
+// analogue of a (local port, remote port) pair, are reserved for a while after they are closed,
+// so that stray packets of the old connection are not mistaken for a new one. Reclaim closes
+// the connection, if it is still open, and releases its labels right away. A Conn reclaims its
+// HeaderConn when it leaves TIMEWAIT, since TIMEWAIT already served as the quiet period.
+type Reclaimer interface {
+	Reclaim() error
+}
+
+// SetTimewait sets the time that the connection stays in TIMEWAIT after it closes, Section 8.3.
+// The default is TIMEWAIT_TIMEOUT, i.e. 2MSL. A zero value skips TIMEWAIT altogether, which
+// is only safe if the application never reconnects between the same endpoints while old
+// packets may still be in flight.
+func (c *Conn) SetTimewait(d time.Duration) error {
+	if d < 0 {
+		return ErrInvalid
+	}
+	c.do(func() { c.timewait = int64(d) })
+	return nil
+}
+
+// expireTIMEWAIT closes the connection at the end of TIMEWAIT and releases its labels
+func (c *Conn) expireTIMEWAIT() {
+	c.abortQuietly()
+	if r, ok := c.hc.(Reclaimer); ok {
+		if err := r.Reclaim(); err != nil {
+			c.amb.E(EventWarn, "Reclaim: "+err.Error())
+		} else {
+			c.amb.E(EventInfo, "Reclaimed")
+		}
+	}
+}
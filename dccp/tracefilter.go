@@ -0,0 +1,91 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"strings"
+)
+
+// TraceFilter selects the traces that an Env passes to its TraceWriter, see Env.SetTraceFilter.
+// The top of the label stack of an Amb names the endpoint, like "client" or "server", and the
+// labels below it name the subsystem, like "conn", or "sender" and "strober" of a sender CCID.
+// A TraceFilter must not be changed once it is in use; a new one takes its place instead.
+type TraceFilter struct {
+	// If not empty, only traces of Ambs with one of these labels below the top of the stack
+	// pass, like "conn" for the traces of Conn, or "sender" for all of the sender CCID
+	Subsystems []string
+
+	// If not empty, only traces of Ambs whose top label is one of these pass
+	Labels []string
+}
+
+// ParseTraceFilter parses a TraceFilter from s, which lists subsystems, then optionally an @
+// and endpoint labels, all separated by commas. For example, "conn,sender@client" passes the
+// traces of the Conn and of the sender CCID of the client, and "@server" all the traces of the
+// server. An empty s gives a filter that passes all traces.
+func ParseTraceFilter(s string) *TraceFilter {
+	subs, labels := s, ""
+	if i := strings.Index(s, "@"); i >= 0 {
+		subs, labels = s[:i], s[i+1:]
+	}
+	return &TraceFilter{Subsystems: splitList(subs), Labels: splitList(labels)}
+}
+
+func splitList(s string) []string {
+	var r []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// Allow returns true if the traces of an Amb with the label stack labels pass the filter
+func (f *TraceFilter) Allow(labels []string) bool {
+	if len(f.Labels) > 0 && (len(labels) == 0 || !contains(f.Labels, labels[0])) {
+		return false
+	}
+	if len(f.Subsystems) == 0 {
+		return true
+	}
+	for i := 1; i < len(labels); i++ {
+		if contains(f.Subsystems, labels[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(set []string, s string) bool {
+	for _, e := range set {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTraceFilter makes the Env pass only the traces that f allows to its TraceWriter, from
+// then on. The Ambs of the Env drop the other traces before they are built, so that filtered
+// traces cost next to nothing. A nil f passes all traces.
+func (t *Env) SetTraceFilter(f *TraceFilter) {
+	t.traceFilter.Store(&f)
+}
+
+// TraceFilter returns the filter set by SetTraceFilter, or nil
+func (t *Env) TraceFilter() *TraceFilter {
+	f, _ := t.traceFilter.Load().(**TraceFilter)
+	if f == nil {
+		return nil
+	}
+	return *f
+}
+
+// traceAllowed returns true if the trace filter of the Env allows the traces of labels
+func (t *Env) traceAllowed(labels []string) bool {
+	f := t.TraceFilter()
+	return f == nil || f.Allow(labels)
+}
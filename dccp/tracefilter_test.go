@@ -0,0 +1,50 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"testing"
+)
+
+type traceCounter struct {
+	nullTraceWriter
+	n int
+}
+
+func (x *traceCounter) Write(*Trace) { x.n++ }
+
+func TestTraceFilter(t *testing.T) {
+	f := ParseTraceFilter(" conn, sender @client")
+	for _, c := range []struct {
+		labels []string
+		allow  bool
+	}{
+		{[]string{"client", "conn"}, true},
+		{[]string{"client", "sender", "strober"}, true},
+		{[]string{"client", "receiver"}, false},
+		{[]string{"client"}, false},
+		{[]string{"server", "conn"}, false},
+		{nil, false},
+	} {
+		if f.Allow(c.labels) != c.allow {
+			t.Errorf("%v: expecting %v", c.labels, c.allow)
+		}
+	}
+	if !ParseTraceFilter("").Allow([]string{"line"}) || !ParseTraceFilter("@server").Allow([]string{"server", "conn"}) {
+		t.Errorf("filter drops traces it should pass")
+	}
+
+	w := &traceCounter{}
+	env := NewEnv(w)
+	client, server := NewAmb("client", env).Refine("conn"), NewAmb("server", env).Refine("conn")
+	env.SetTraceFilter(ParseTraceFilter("@server"))
+	client.E(EventInfo, "dropped")
+	server.E(EventInfo, "passed")
+	env.SetTraceFilter(nil)
+	client.E(EventInfo, "passed")
+	if w.n != 2 || env.TraceFilter() != nil {
+		t.Errorf("expecting 2 traces, encountered %d", w.n)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"sync"
+)
+
+// TraceSampler is a TraceWriter that passes the traces of one in every n Data, DataAck and Ack
+// packets to another TraceWriter, and all other traces. Packets are picked by sequence number,
+// so that the traces of a sampled packet at both endpoints, and in the pipes between them,
+// are kept together. Handshake, Sync, Close and Reset packets, which are few, are all kept.
+type TraceSampler struct {
+	dst TraceWriter
+	n   int64
+}
+
+// NewTraceSampler creates a TraceSampler that passes one in every n packets to dst
+func NewTraceSampler(dst TraceWriter, n int) *TraceSampler {
+	if n < 1 {
+		n = 1
+	}
+	return &TraceSampler{dst: dst, n: int64(n)}
+}
+
+// Write implements TraceWriter.Write
+func (t *TraceSampler) Write(r *Trace) {
+	switch r.Type {
+	case "Data", "DataAck", "Ack":
+		if r.SeqNo%t.n != 0 {
+			return
+		}
+	}
+	t.dst.Write(r)
+}
+
+// Sync implements TraceWriter.Sync
+func (t *TraceSampler) Sync() error { return t.dst.Sync() }
+
+// Close implements TraceWriter.Close
+func (t *TraceSampler) Close() error { return t.dst.Close() }
+
+// TraceRing is a TraceWriter that keeps the latest traces in memory, within a limit on their
+// number and on their size, evicting the oldest traces to make room for new ones. Close writes
+// the traces that remain to another TraceWriter, so that a long run leaves a trace of its end
+// of bounded size. The size of a trace is an estimate of its footprint in memory.
+type TraceRing struct {
+	sync.Mutex
+	dst      TraceWriter
+	max      int      // Maximum number of traces kept, or zero for no limit
+	maxBytes int64    // Maximum total size of the traces kept, or zero for no limit
+	ring     []*Trace // Traces kept, oldest first from index start, wrapping around
+	start    int
+	n        int   // Number of traces kept
+	bytes    int64 // Total size of the traces kept
+	evicted  int64 // Number of traces evicted
+}
+
+// NewTraceRing creates a TraceRing that keeps at most max traces, of a total size of at most
+// maxBytes, and writes them to dst on Close. A zero limit is no limit, but max and maxBytes
+// cannot both be zero.
+func NewTraceRing(dst TraceWriter, max int, maxBytes int64) *TraceRing {
+	if max <= 0 && maxBytes <= 0 {
+		panic("trace ring without limit")
+	}
+	return &TraceRing{dst: dst, max: max, maxBytes: maxBytes}
+}
+
+// traceSize estimates the memory footprint of r, in bytes
+func traceSize(r *Trace) int64 {
+	n := 128 + len(r.Comment) + len(r.State) + len(r.Type) + len(r.SourceFile) + len(r.Trace)
+	for _, l := range r.Labels {
+		n += 16 + len(l)
+	}
+	return int64(n + 32*len(r.Args))
+}
+
+// Write implements TraceWriter.Write
+func (t *TraceRing) Write(r *Trace) {
+	t.Lock()
+	defer t.Unlock()
+	size := traceSize(r)
+	if t.maxBytes > 0 && size > t.maxBytes {
+		t.evicted++
+		return
+	}
+	for t.n > 0 && ((t.max > 0 && t.n >= t.max) || (t.maxBytes > 0 && t.bytes+size > t.maxBytes)) {
+		t.evict()
+	}
+	if t.n == len(t.ring) {
+		t.grow()
+	}
+	t.ring[(t.start+t.n)%len(t.ring)] = r
+	t.n++
+	t.bytes += size
+}
+
+// evict drops the oldest trace kept
+func (t *TraceRing) evict() {
+	r := t.ring[t.start]
+	t.ring[t.start] = nil
+	t.start = (t.start + 1) % len(t.ring)
+	t.n--
+	t.bytes -= traceSize(r)
+	t.evicted++
+}
+
+// grow makes room for more traces in the ring
+func (t *TraceRing) grow() {
+	k := 2*len(t.ring) + 16
+	if t.max > 0 && k > t.max {
+		k = t.max
+	}
+	ring := make([]*Trace, k)
+	for i := 0; i < t.n; i++ {
+		ring[i] = t.ring[(t.start+i)%len(t.ring)]
+	}
+	t.ring, t.start = ring, 0
+}
+
+// Traces returns the traces kept, oldest first
+func (t *TraceRing) Traces() []*Trace {
+	t.Lock()
+	defer t.Unlock()
+	r := make([]*Trace, t.n)
+	for i := range r {
+		r[i] = t.ring[(t.start+i)%len(t.ring)]
+	}
+	return r
+}
+
+// Evicted returns the number of traces evicted so far
+func (t *TraceRing) Evicted() int64 {
+	t.Lock()
+	defer t.Unlock()
+	return t.evicted
+}
+
+// Sync implements TraceWriter.Sync. The traces kept stay in memory until Close.
+func (t *TraceRing) Sync() error { return t.dst.Sync() }
+
+// Close implements TraceWriter.Close. It writes the traces kept to the destination, and closes it.
+func (t *TraceRing) Close() error {
+	for _, r := range t.Traces() {
+		t.dst.Write(r)
+	}
+	return t.dst.Close()
+}
+
+// HeadTailTraceWriter is a TraceWriter that passes the first head traces of a run to another
+// TraceWriter as they come, and keeps the last tail traces in a TraceRing until Close, dropping
+// those in between. It captures how a run starts, and how it ends, at a bounded cost.
+type HeadTailTraceWriter struct {
+	sync.Mutex
+	dst  TraceWriter
+	head int // Traces still to pass as they come
+	tail *TraceRing
+}
+
+// NewHeadTailTraceWriter creates a HeadTailTraceWriter that writes the first head and the last
+// tail traces to dst
+func NewHeadTailTraceWriter(dst TraceWriter, head, tail int) *HeadTailTraceWriter {
+	t := &HeadTailTraceWriter{dst: dst, head: head}
+	if tail > 0 {
+		t.tail = NewTraceRing(dst, tail, 0)
+	}
+	return t
+}
+
+// Write implements TraceWriter.Write
+func (t *HeadTailTraceWriter) Write(r *Trace) {
+	t.Lock()
+	if t.head > 0 {
+		t.head--
+		t.Unlock()
+		t.dst.Write(r)
+		return
+	}
+	t.Unlock()
+	if t.tail != nil {
+		t.tail.Write(r)
+	}
+}
+
+// Sync implements TraceWriter.Sync
+func (t *HeadTailTraceWriter) Sync() error { return t.dst.Sync() }
+
+// Close implements TraceWriter.Close. It writes the tail to the destination, and closes it.
+func (t *HeadTailTraceWriter) Close() error {
+	if t.tail != nil {
+		return t.tail.Close()
+	}
+	return t.dst.Close()
+}
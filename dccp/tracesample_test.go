@@ -0,0 +1,84 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"testing"
+)
+
+type traceList struct {
+	nullTraceWriter
+	traces []*Trace
+	closed bool
+}
+
+func (x *traceList) Write(r *Trace) { x.traces = append(x.traces, r) }
+func (x *traceList) Close() error   { x.closed = true; return nil }
+
+func TestTraceSampler(t *testing.T) {
+	l := &traceList{}
+	s := NewTraceSampler(l, 4)
+	for seqNo := int64(0); seqNo < 100; seqNo++ {
+		s.Write(&Trace{Type: "Data", SeqNo: seqNo})
+	}
+	s.Write(&Trace{Type: "Reset", SeqNo: 101})
+	s.Write(&Trace{Comment: "no packet"})
+	if len(l.traces) != 25+2 {
+		t.Errorf("expecting %d traces, encountered %d", 25+2, len(l.traces))
+	}
+}
+
+func TestTraceRing(t *testing.T) {
+	l := &traceList{}
+	ring := NewTraceRing(l, 10, 0)
+	for i := int64(0); i < 100; i++ {
+		ring.Write(&Trace{Time: i})
+	}
+	kept := ring.Traces()
+	if len(kept) != 10 || kept[0].Time != 90 || kept[9].Time != 99 || ring.Evicted() != 90 {
+		t.Fatalf("kept %d traces from %d, evicted %d", len(kept), kept[0].Time, ring.Evicted())
+	}
+	if len(l.traces) != 0 {
+		t.Errorf("traces written before Close")
+	}
+	ring.Close()
+	if len(l.traces) != 10 || !l.closed {
+		t.Errorf("%d traces written on Close", len(l.traces))
+	}
+
+	// Bounded by size, traces with long comments leave room for fewer of them
+	small := traceSize(&Trace{})
+	ring = NewTraceRing(&traceList{}, 0, 10*small)
+	for i := int64(0); i < 100; i++ {
+		ring.Write(&Trace{Time: i})
+	}
+	if n := len(ring.Traces()); n != 10 {
+		t.Errorf("kept %d small traces", n)
+	}
+	ring.Write(&Trace{Comment: string(make([]byte, 4*small))})
+	if n := len(ring.Traces()); n != 6 {
+		t.Errorf("kept %d traces after a large one", n)
+	}
+	ring.Write(&Trace{Comment: string(make([]byte, 20*small))})
+	if n := len(ring.Traces()); n != 6 {
+		t.Errorf("a trace over the limit evicted others")
+	}
+}
+
+func TestHeadTailTraceWriter(t *testing.T) {
+	l := &traceList{}
+	w := NewHeadTailTraceWriter(l, 3, 2)
+	for i := int64(0); i < 10; i++ {
+		w.Write(&Trace{Time: i})
+	}
+	w.Close()
+	var times []int64
+	for _, r := range l.traces {
+		times = append(times, r.Time)
+	}
+	if len(times) != 5 || times[0] != 0 || times[2] != 2 || times[3] != 8 || times[4] != 9 {
+		t.Errorf("unexpected traces at %v", times)
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import "net"
+
+// EncapPort is the UDP port assigned to DCCP-UDP. Servers listen on it by default, RFC 6773.
+const EncapPort = 6511
+
+const (
+	udpHeaderLen = 8
+	udpProtoNo   = 17
+)
+
+// UDPEncap carries DCCP packets inside UDP datagrams, following the DCCP-UDP encapsulation of
+// RFC 6773. The UDP header takes the place of the DCCP ports, so a connection is identified by
+// its pair of UDP addresses, and NATs that know UDP pass it through. Unlike a Mux over a
+// UDPLink, a UDPEncap interoperates with other DCCP-UDP implementations.
+type UDPEncap struct {
+	c      *net.UDPConn
+	b      *udpBatch
+	w      *batchWriter     // Combines the datagrams that flows send at the same time
+	accept chan *packetFlow // Flows started by Requests from unknown addresses
+
+	Mutex
+	closed bool
+	device string                 // Network interface that the socket is bound to, if any
+	flows  map[string]*packetFlow // Flows by remote UDP address
+}
+
+// BindUDPEncap creates a UDPEncap on a UDP socket bound to laddr, which may be nil
+func BindUDPEncap(network string, laddr *net.UDPAddr) (*UDPEncap, error) {
+	c, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	b := newUDPBatch(c)
+	e := &UDPEncap{
+		c:      c,
+		b:      b,
+		w:      newBatchWriter(b.write),
+		accept: make(chan *packetFlow, DefaultListenBacklog),
+		flows:  make(map[string]*packetFlow),
+	}
+	// Where ICMP errors are not available, connections just go without them
+	enableICMP(c)
+	go e.readLoop()
+	return e, nil
+}
+
+// Dial returns a HeaderConn for a connection to the DCCP-UDP endpoint at raddr
+func (e *UDPEncap) Dial(raddr *net.UDPAddr) (HeaderConn, error) {
+	e.Lock()
+	defer e.Unlock()
+	if e.closed {
+		return nil, ErrBad
+	}
+	remote := endpoint{raddr.IP, raddr.Port, raddr.Zone}
+	if e.flows[remote.String()] != nil {
+		return nil, ErrInUse
+	}
+	f := e.newFlow(remote)
+	e.flows[remote.String()] = f
+	return f, nil
+}
+
+// Accept returns a HeaderConn for the next connection that a Request from a new remote
+// address starts. If Accept is not called promptly, Requests beyond DefaultListenBacklog are
+// dropped; their clients retransmit them.
+func (e *UDPEncap) Accept() (HeaderConn, error) {
+	f, ok := <-e.accept
+	if !ok {
+		return nil, ErrBad
+	}
+	return f, nil
+}
+
+// acceptHeaderConn implements flowAcceptor.acceptHeaderConn
+func (e *UDPEncap) acceptHeaderConn() (HeaderConn, error) { return e.Accept() }
+
+// dialHeaderConn implements flowAcceptor.dialHeaderConn
+func (e *UDPEncap) dialHeaderConn(raddr net.Addr) (HeaderConn, error) {
+	ua, ok := raddr.(*net.UDPAddr)
+	if !ok {
+		return nil, ErrInvalid
+	}
+	return e.Dial(ua)
+}
+
+// LocalAddr returns the local UDP address that the UDPEncap is bound to
+func (e *UDPEncap) LocalAddr() net.Addr { return e.c.LocalAddr() }
+
+// SetReadBuffer sets the size of the receive buffer of the UDP socket, SO_RCVBUF. Flows of
+// high packet rates may overflow the default size of the operating system between reads.
+func (e *UDPEncap) SetReadBuffer(bytes int) error { return e.c.SetReadBuffer(bytes) }
+
+// SetWriteBuffer sets the size of the send buffer of the UDP socket, SO_SNDBUF
+func (e *UDPEncap) SetWriteBuffer(bytes int) error { return e.c.SetWriteBuffer(bytes) }
+
+// BindToDevice binds the UDP socket to the network interface ifname, so that datagrams are
+// sent and received through it alone, and flows take its addresses, see Dialer.Interface
+func (e *UDPEncap) BindToDevice(ifname string) error {
+	if err := bindConnToDevice(e.c, ifname); err != nil {
+		return err
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.device = ifname
+	return nil
+}
+
+// Close closes the UDP socket and all connections on it
+func (e *UDPEncap) Close() error {
+	e.Lock()
+	if e.closed {
+		e.Unlock()
+		return ErrBad
+	}
+	e.closed = true
+	for _, f := range e.flows {
+		f.shut()
+	}
+	e.Unlock()
+	return e.c.Close()
+}
+
+func (e *UDPEncap) readLoop() {
+	defer close(e.accept)
+	var pbs [batchLen]*packetBuf
+	var msgs [batchLen]LinkMsg
+	defer func() {
+		for _, pb := range pbs {
+			pb.release()
+		}
+	}()
+	for {
+		for i := range msgs {
+			if pbs[i] == nil {
+				pbs[i] = newPacketBuf(64 * 1024)
+			}
+			msgs[i] = LinkMsg{Buf: pbs[i].b}
+		}
+		n, err := e.b.read(msgs[:])
+		if err != nil {
+			e.Lock()
+			closed := e.closed
+			e.Unlock()
+			if closed {
+				return
+			}
+			if reports, ok := readICMP(e.c, err); ok {
+				for _, r := range reports {
+					e.processICMP(r)
+				}
+				continue
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			e.Close()
+			return
+		}
+		for i := 0; i < n; i++ {
+			pb, addr := pbs[i], msgs[i].Addr.(*net.UDPAddr)
+			pbs[i] = nil
+			pb.b, pb.ecn = pb.b[:msgs[i].N], msgs[i].ECN
+			e.process(pb, endpoint{addr.IP, addr.Port, addr.Zone})
+		}
+	}
+}
+
+// process passes the datagram in pb from remote to its flow, or starts a new flow if the
+// datagram is a Request
+func (e *UDPEncap) process(pb *packetBuf, remote endpoint) {
+	p := pb.b
+	e.Lock()
+	defer e.Unlock()
+	if e.closed {
+		return
+	}
+	f := e.flows[remote.String()]
+	if f == nil {
+		if len(p) < 5 || (p[4]>>1)&0x0f != Request {
+			return
+		}
+		f = e.newFlow(remote)
+		select {
+		case e.accept <- f:
+		default:
+			return
+		}
+		e.flows[remote.String()] = f
+	}
+	f.deliver(pb)
+}
+
+// processICMP passes the ICMP error r on to the flow that it is about
+func (e *UDPEncap) processICMP(r icmpReport) {
+	e.Lock()
+	f := e.flows[r.dst.String()]
+	e.Unlock()
+	if f != nil {
+		f.deliverICMP(r.err)
+	}
+}
+
+// newFlow creates a flow to remote. If the socket is bound to a wildcard address, the route to
+// remote decides the local IP address.
+func (e *UDPEncap) newFlow(remote endpoint) *packetFlow {
+	la := e.c.LocalAddr().(*net.UDPAddr)
+	local := endpoint{la.IP, la.Port, la.Zone}
+	if la.IP == nil || la.IP.IsUnspecified() {
+		if ip := routeIP(remote, e.device); ip != nil {
+			local.IP = ip
+		}
+	}
+	return newPacketFlow(e, local, remote)
+}
+
+// forget implements packetSocket.forget
+func (e *UDPEncap) forget(f *packetFlow) {
+	e.Lock()
+	defer e.Unlock()
+	if e.flows[f.remote.String()] == f {
+		delete(e.flows, f.remote.String())
+	}
+}
+
+// send implements packetSocket.send
+func (e *UDPEncap) send(p []byte, remote endpoint, dscp, ecn byte) error {
+	return sendError(e.w.write(p, &net.UDPAddr{IP: remote.IP, Port: remote.Port, Zone: remote.Zone}, dscp, ecn))
+}
+
+// carriesDSCP implements packetSocket.carriesDSCP
+func (e *UDPEncap) carriesDSCP() bool { return e.b.carriesDSCP() }
+
+// carriesECN implements packetSocket.carriesECN
+func (e *UDPEncap) carriesECN() bool { return e.b.carriesECN() }
+
+// addr implements packetSocket.addr
+func (e *UDPEncap) addr(x endpoint) net.Addr { return &net.UDPAddr{IP: x.IP, Port: x.Port, Zone: x.Zone} }
+
+// overhead implements packetSocket.overhead. The UDP header takes the place of the DCCP ports.
+func (e *UDPEncap) overhead() int { return udpHeaderLen - 4 }
+
+// encode implements packetSocket.encode. The DCCP-UDP wire format is the DCCP header without
+// the ports, which the UDP header replaces, RFC 6773, Section 3. Data Offset counts from the
+// start of the UDP header, and the checksum covers the UDP header, with the UDP checksum taken
+// as zero, and a pseudo-header for protocol UDP.
+func (e *UDPEncap) encode(h *Header, local, remote endpoint, scratch []byte) ([]byte, error) {
+	return encapWrite(h, local, remote, scratch)
+}
+
+// decode implements packetSocket.decode
+func (e *UDPEncap) decode(p []byte, local, remote endpoint) (*Header, error) {
+	return encapRead(p, remote, local)
+}
+
+// encapWrite returns the DCCP-UDP wire format of h, for the payload of a UDP datagram from
+// src to dst, placing it in scratch if scratch has the capacity for it
+func encapWrite(h *Header, src, dst endpoint, scratch []byte) ([]byte, error) {
+	srcIP, dstIP := csumIPs(src.IP, dst.IP)
+	p, err := h.Append(scratch[:0], srcIP, dstIP, udpProtoNo, false)
+	if err != nil {
+		return nil, err
+	}
+	p = p[4:]
+	if p[0] == 0xff {
+		return nil, ErrOversize
+	}
+	p[0]++ // The UDP header is one word longer than the ports
+	p[2], p[3] = 0, 0
+	csum, err := encapChecksum(p, src, dst)
+	if err != nil {
+		return nil, err
+	}
+	csumUint16ToBytes(csum, p[2:4])
+	return p, nil
+}
+
+// encapRead parses the payload p of a DCCP-UDP datagram from src to dst
+func encapRead(p []byte, src, dst endpoint) (*Header, error) {
+	if len(p) < 12-4 {
+		return nil, ErrSize
+	}
+	if dataOffset := int(p[0])<<2 - udpHeaderLen; dataOffset < 0 || dataOffset > len(p) {
+		return nil, ErrNumeric
+	}
+	csum, err := encapChecksum(p, src, dst)
+	if err != nil {
+		return nil, err
+	}
+	if csum != 0 {
+		countChecksumFailure()
+		return nil, ErrChecksum
+	}
+	// Restore the RFC 4340 header, with the UDP ports as DCCP ports
+	pb := newPacketBuf(4 + len(p))
+	buf := pb.b
+	EncodeUint16(uint16(src.Port), buf[0:2])
+	EncodeUint16(uint16(dst.Port), buf[2:4])
+	copy(buf[4:], p)
+	buf[4]--
+	h, err := readHeader(buf, nil, nil, udpProtoNo, false, false)
+	if err != nil {
+		pb.release()
+		return nil, err
+	}
+	h.buf = pb
+	return h, nil
+}
+
+// encapChecksum returns the checksum of the DCCP-UDP payload p from src to dst, including
+// the value in its Checksum field. It is zero for a correct payload.
+func encapChecksum(p []byte, src, dst endpoint) (uint16, error) {
+	dataOffset := int(p[0])<<2 - udpHeaderLen
+	appCov, err := getChecksumAppCoverage(p[1]&0x0f, len(p)-dataOffset)
+	if err != nil {
+		return 0, err
+	}
+	udpLen := udpHeaderLen + len(p)
+	udp := make([]byte, udpHeaderLen)
+	EncodeUint16(uint16(src.Port), udp[0:2])
+	EncodeUint16(uint16(dst.Port), udp[2:4])
+	EncodeUint16(uint16(udpLen), udp[4:6])
+	srcIP, dstIP := csumIPs(src.IP, dst.IP)
+	csum := csumSum(udp)
+	csum = csumAdd(csum, csumPseudoIP(srcIP, dstIP, udpProtoNo, udpLen))
+	csum = csumAdd(csum, csumSum(p[:dataOffset]))
+	csum = csumAdd(csum, csumSum(p[dataOffset:dataOffset+appCov]))
+	return csumDone(csum), nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestEncap(t *testing.T) {
+	testEncap(t, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3))
+	testEncap(t, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::3"))
+}
+
+func testEncap(t *testing.T, srcIP, dstIP, otherIP net.IP) {
+	src := endpoint{srcIP, 40000, ""}
+	dst := endpoint{dstIP, EncapPort, ""}
+	h := &Header{
+		Type:        Request,
+		X:           true,
+		SeqNo:       0x112233445566,
+		ServiceCode: 1717858426,
+		Data:        []byte("odd"),
+	}
+	p, err := encapWrite(h, src, dst, nil)
+	if err != nil {
+		t.Fatalf("write (%s)", err)
+	}
+	q, err := h.Write(make([]byte, 4), make([]byte, 4), AnyProto, false)
+	if err != nil {
+		t.Fatalf("write (%s)", err)
+	}
+	if len(p) != len(q)-4 || int(p[0]) != int(q[4])+1 || !bytes.Equal(p[4:], q[8:]) {
+		t.Errorf("expecting the DCCP header without ports")
+	}
+
+	g, err := encapRead(p, src, dst)
+	if err != nil {
+		t.Fatalf("read (%s)", err)
+	}
+	if g.SourcePort != 40000 || g.DestPort != EncapPort || g.Type != Request ||
+		g.SeqNo != h.SeqNo || g.ServiceCode != h.ServiceCode || string(g.Data) != "odd" {
+		t.Errorf("read %s", g)
+	}
+
+	// The checksum covers the addresses and ports, as well as the payload
+	if _, err := encapRead(p, endpoint{src.IP, 40001, ""}, dst); err != ErrChecksum {
+		t.Errorf("other port: expecting %s, got %v", ErrChecksum, err)
+	}
+	if _, err := encapRead(p, src, endpoint{otherIP, EncapPort, ""}); err != ErrChecksum {
+		t.Errorf("other address: expecting %s, got %v", ErrChecksum, err)
+	}
+	p[len(p)-1] ^= 1
+	if _, err := encapRead(p, src, dst); err != ErrChecksum {
+		t.Errorf("corrupt data: expecting %s, got %v", ErrChecksum, err)
+	}
+}
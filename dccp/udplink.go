@@ -9,9 +9,10 @@ import (
 	"time"
 )
 
-// UDPLink binds to a UDP port and acts as a Link.
+// UDPLink binds to a UDP port and acts as a Link. It is a BatchLink as well.
 type UDPLink struct {
 	c *net.UDPConn
+	b *udpBatch
 }
 
 func BindUDPLink(netw string, laddr *net.UDPAddr) (link *UDPLink, err error) {
@@ -19,7 +20,7 @@ func BindUDPLink(netw string, laddr *net.UDPAddr) (link *UDPLink, err error) {
 	if err != nil {
 		return nil, err
 	}
-	return &UDPLink{c}, nil
+	return &UDPLink{c, newUDPBatch(c)}, nil
 }
 
 func (u *UDPLink) GetMTU() int { return 1500 }
@@ -36,6 +37,49 @@ func (u *UDPLink) WriteTo(buf []byte, addr net.Addr) (n int, err error) {
 	return u.c.WriteTo(buf, addr)
 }
 
+// ReadBatch implements BatchLink.ReadBatch
+func (u *UDPLink) ReadBatch(msgs []LinkMsg) (n int, err error) {
+	return u.b.read(msgs)
+}
+
+// WriteBatch implements BatchLink.WriteBatch
+func (u *UDPLink) WriteBatch(msgs []LinkMsg) (n int, err error) {
+	return u.b.write(msgs)
+}
+
+// CarriesDSCP implements DSCPCarrier.CarriesDSCP
+func (u *UDPLink) CarriesDSCP() bool {
+	return u.b.carriesDSCP()
+}
+
+// CarriesECN implements ECNCarrier.CarriesECN. The ECN codepoints are passed in LinkMsg.ECN
+// of the batches that the link reads and writes.
+func (u *UDPLink) CarriesECN() bool {
+	return u.b.carriesECN()
+}
+
+// SetReadBuffer sets the size of the receive buffer of the socket, SO_RCVBUF. Flows of high
+// packet rates may overflow the default size of the operating system between reads.
+func (u *UDPLink) SetReadBuffer(bytes int) error {
+	return u.c.SetReadBuffer(bytes)
+}
+
+// SetWriteBuffer sets the size of the send buffer of the socket, SO_SNDBUF
+func (u *UDPLink) SetWriteBuffer(bytes int) error {
+	return u.c.SetWriteBuffer(bytes)
+}
+
+// BindToDevice binds the socket to the network interface ifname, so that packets are sent and
+// received through it alone, see Dialer.Interface
+func (u *UDPLink) BindToDevice(ifname string) error {
+	return bindConnToDevice(u.c, ifname)
+}
+
 func (u *UDPLink) Close() error {
 	return u.c.Close()
 }
+
+// LocalAddr returns the local UDP address that the link is bound to
+func (u *UDPLink) LocalAddr() net.Addr {
+	return u.c.LocalAddr()
+}
@@ -5,7 +5,10 @@
 package dccp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 )
 
 // This is an approximate upper bound on the size of options that are
@@ -16,63 +19,141 @@ const maxDataOptionSize = 24
 // to Write This is an informative number. Packets are sent anyway, but they may be
 // dropped by the link layer or a router.
 func (c *Conn) GetMTU() int {
-	c.Lock()
-	defer c.Unlock()
-	c.syncWithLink()
+	var mtu int
+	c.do(func() {
+		c.syncWithLink()
+		mtu = c.getMTU()
+	})
+	return mtu
+}
+
+func (c *Conn) getMTU() int {
 	return int(c.socket.GetMPS()) - maxDataOptionSize - getFixedHeaderSize(DataAck, true)
 }
 
-// Write blocks until the slice b is sent.
-func (c *Conn) Write(data []byte) error {
+// SetMTUHandler sets a function that is called, in a goroutine of its own, with the new value
+// of GetMTU whenever it changes during the connection, as it does when path MTU discovery
+// finds a smaller path MTU. Applications that size their writes by GetMTU should write
+// smaller blocks from then on. A nil f removes the handler.
+func (c *Conn) SetMTUHandler(f func(mtu int)) {
+	c.do(func() { c.mtuHandler = f })
+}
 
-	//?
+// WriteSegment blocks until the block of application data is queued for sending in a packet
+// of its own. It returns ErrTimeout if the write deadline passes first. SetSendQueue can make
+// it drop data or fail with ErrFull instead of blocking.
+func (c *Conn) WriteSegment(block []byte) error {
+	return c.writeSegment(&outMsg{data: block}, nil, true)
+}
 
-	c.writeDataLk.Lock()
-	defer c.writeDataLk.Unlock()
-	if c.writeData == nil {
-		return ErrBad
+// writeSegment is WriteSegment, queueing the message m with the options of WriteMsg. Unless
+// block is true, it returns ErrWouldBlock rather than block.
+func (c *Conn) writeSegment(m *outMsg, opts *MsgOptions, block bool) error {
+	m.mtu = c.GetMTU()
+	if opts != nil {
+		m.opts = *opts
+		m.expire = opts.expire(c.env.nowNano())
 	}
-	c.writeData <- data
-	return nil
+	deadline, dk := c.writeDeadline.Wait()
+	if err := c.writeData.push(m, deadline, dk, block); !errors.Is(err, ErrBad) {
+		return err
+	}
+	return c.writeError()
 }
 
-// Read blocks until the next packet of application data is received. Successfuly read data
-// is returned in a slice. The error returned by Read behaves according to io.Reader. If the
-// connection was never established or was aborted, Read returns ErrIO. If the connection
-// was closed normally, Read returns io.EOF. In the event of a non-nil error, successive
-// calls to Read return the same error.
-func (c *Conn) Read() (b []byte, err error) {
-	c.readAppLk.Lock()
-	readApp := c.readApp
-	c.readAppLk.Unlock()
-	if readApp == nil {
-		if c.Error() == nil {
-			panic("torn connection missing error")
-		}
-		return nil, c.Error()
+// writeError returns the error of a write to a torn down connection: ErrPeerUnreachable if
+// the other side stopped answering, see SetPeerProbe, ErrIdleTimeout if it fell silent, see
+// SetIdleTimeout, and ErrBad otherwise
+func (c *Conn) writeError() error {
+	if err := c.Error(); errors.Is(err, ErrPeerUnreachable) || errors.Is(err, ErrIdleTimeout) {
+		return err
 	}
-	b, ok := <-readApp
-	if !ok {
-		if c.Error() == nil {
-			panic("torn connection missing error")
+	return ErrBad
+}
+
+// ReadSegment blocks until the next packet of application data is received. Successfuly
+// read data is returned in a slice. If the connection was closed normally, ReadSegment
+// returns ErrEOF. If it was reset by the other side, it returns a *ResetError. If the read
+// deadline passes, it returns ErrTimeout. In the event of any other non-nil error,
+// successive calls to ReadSegment return the same error. The returned slice belongs to the
+// caller. Read and ReadMsg copy the data into a buffer of the caller instead, and ReadBorrow
+// lends it without a copy.
+func (c *Conn) ReadSegment() (b []byte, err error) {
+	m, err := c.readSegment()
+	if err != nil {
+		return nil, err
+	}
+	return m.data, nil
+}
+
+// readSegment is ReadSegment, returning the metadata of the packet along with its data
+func (c *Conn) readSegment() (*appMsg, error) {
+	if !isClosed(c.readDone) {
+		deadline, dk := c.readDeadline.Wait()
+		c.env.Wait(&c.readAppT, &c.readDoneT, dk)
+		select {
+		case m := <-c.readApp:
+			c.env.Woken(&c.readAppT, &c.readDoneT, dk)
+			c.env.Received(&c.readAppT)
+			return m, nil
+		case <-c.readDone:
+			c.env.Woken(&c.readAppT, &c.readDoneT, dk)
+		case <-deadline:
+			c.env.Woken(&c.readAppT, &c.readDoneT, dk)
+			return nil, ErrTimeout
 		}
-		// The connection has been closed
-		return nil, c.Error()
 	}
-	return b, nil
+	// The connection has been closed
+	err := c.Error()
+	if err == nil {
+		panic("torn connection missing error")
+	}
+	return nil, err
+}
+
+// WaitOpen blocks until the handshake of the connection is over. It returns nil if the
+// connection reached OPEN state, and the connection error otherwise.
+func (c *Conn) WaitOpen() error {
+	return c.waitOpen(context.Background())
+}
+
+// waitOpen is like WaitOpen, except that it returns the error of ctx if ctx is done before
+// the handshake is over
+func (c *Conn) waitOpen(ctx context.Context) error {
+	c.env.Wait(&c.handshakeT)
+	select {
+	case <-c.handshake:
+		c.env.Woken(&c.handshakeT)
+	case <-ctx.Done():
+		c.env.Woken(&c.handshakeT)
+		return ctx.Err()
+	}
+	var err error
+	c.do(func() {
+		if !c.open {
+			err = c.err
+		}
+	})
+	return err
 }
 
 func (c *Conn) Error() error {
-	c.Lock()
-	defer c.Unlock()
-	return c.err
+	var err error
+	c.do(func() { err = c.err })
+	return err
 }
 
-// Close implements SegmentConn.Close.
-// It closes the connection, Section 8.3.
+// Close implements net.Conn.Close.
+// It closes the connection, Section 8.3. An open server connection sends a CloseReq, so that
+// the client closes the connection and holds TIMEWAIT.
 func (c *Conn) Close() error {
-	c.Lock()
-	defer c.Unlock()
+	var err error
+	c.do(func() { err = c.close() })
+	return err
+}
+
+// close is Close, on loop
+func (c *Conn) close() error {
 	state := c.socket.GetState()
 	switch state {
 	case LISTEN:
@@ -83,7 +164,17 @@ func (c *Conn) Close() error {
 		return nil
 	case RESPOND:
 		c.reset(ResetClosed, ErrEOF)
-	case PARTOPEN, OPEN:
+	case OPEN:
+		// A server asks the client to close, so that it does not accumulate TIMEWAIT state
+		if c.socket.IsServer() {
+			c.inject(c.generateCloseReq())
+			c.gotoCLOSEREQ()
+			return nil
+		}
+		c.inject(c.generateClose())
+		c.gotoCLOSING()
+		return nil
+	case PARTOPEN:
 		c.inject(c.generateClose())
 		c.gotoCLOSING()
 		return nil
@@ -96,17 +187,78 @@ func (c *Conn) Close() error {
 	panic("unknown state")
 }
 
+// ServiceCode returns the service code of the connection. On the client, this is the service
+// code chosen by the server among the offered ones, once the Response has been received.
+func (c *Conn) ServiceCode() ServiceCode {
+	var sc ServiceCode
+	c.do(func() { sc = c.socket.GetServiceCode() })
+	return sc
+}
+
+// SetSequenceWindow changes the Sequence Window of this endpoint, Section 7.5.2. A good
+// guideline is about five times the maximum number of packets expected to be sent in one
+// round-trip time. The new value takes effect once it is confirmed by the other side.
+func (c *Conn) SetSequenceWindow(w int64) error {
+	if w < SEQWIN_MIN || w > SEQWIN_MAX {
+		return ErrInvalid
+	}
+	var err error
+	c.do(func() { err = c.features.ChangeLocal(FeatureSequenceWindow, uint64(w)) })
+	return err
+}
+
+// SetChecksumCoverage sets the checksum coverage of outgoing data packets, Section 9.2. A
+// value of CsCovAllData, the default, covers the whole packet. A value of n > 0 covers the
+// header and the first (n-1)*4 bytes of data. Partial coverage is only used if the other side
+// accepts it, and is raised to its Minimum Checksum Coverage if necessary.
+func (c *Conn) SetChecksumCoverage(cscov byte) error {
+	if cscov > 15 {
+		return ErrInvalid
+	}
+	c.do(func() { c.csCov = cscov })
+	return nil
+}
+
+// SetMinChecksumCoverage sets the least checksum coverage of incoming data packets that this
+// endpoint accepts, Section 9.2.1. Data of packets with insufficient coverage is dropped and
+// reported to the other side with a Data Dropped option.
+func (c *Conn) SetMinChecksumCoverage(min byte) error {
+	if min > 15 {
+		return ErrInvalid
+	}
+	var err error
+	c.do(func() { err = c.features.ChangeLocal(FeatureMinimumChecksumCoverage, uint64(min)) })
+	return err
+}
+
+// SetCheckDataChecksum asks the other side to place Data Checksum options on all packets that
+// carry application data, which this endpoint then checks, Section 9.3.1. Data that arrives
+// without a Data Checksum option, or with an incorrect one, is dropped.
+func (c *Conn) SetCheckDataChecksum(check bool) error {
+	var v uint64
+	if check {
+		v = 1
+	}
+	var err error
+	c.do(func() { err = c.features.ChangeLocal(FeatureCheckDataChecksum, v) })
+	return err
+}
+
 func (c *Conn) Abort() {
-	c.abortWith(ResetAborted)
+	c.do(c.abort)
 }
 
-// LocalLabel implements SegmentConn.LocalLabel
+// LocalLabel returns the label of the local end of the underlying link
 func (c *Conn) LocalLabel() Bytes { return c.hc.LocalLabel() }
 
-// RemoteLabel implements SegmentConn.RemoteLabel
+// RemoteLabel returns the label of the remote end of the underlying link
 func (c *Conn) RemoteLabel() Bytes { return c.hc.RemoteLabel() }
 
-// SetReadExpire implements SegmentConn.SetReadExpire
-func (c *Conn) SetReadExpire(nsec int64) error {
-	panic("not implemented")
+// SetReadExpire sets the read deadline to d from now, in the manner of
+// SegmentConn.SetReadExpire
+func (c *Conn) SetReadExpire(d time.Duration) error {
+	if d < 0 {
+		return ErrInvalid
+	}
+	return c.SetReadDeadline(c.env.Now().Add(d))
 }
@@ -0,0 +1,245 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// virtualClock is the time of an Env created by NewVirtualEnv. It only moves when all the
+// goroutines of the Env are waiting on it: it then advances at once to the earliest time that
+// one of them sleeps until, and wakes the ones whose sleep is over.
+//
+// The goroutines of the Env are those started by Env.Go, and the goroutine that created the
+// Env. The clock counts those that are running: a goroutine stops running when it waits, through
+// Wait, on the channels that Tracks account for, or sleeps, and runs again once Woken. A
+// goroutine that waits on a channel with a message, or a closed one, is about to run, so the
+// clock also counts the waits on such channels. The clock advances when neither count is above
+// zero. A goroutine that blocks in any other way, on a lock or a channel that no Track accounts
+// for, still counts as running, so it holds the clock still rather than let it skip ahead.
+type virtualClock struct {
+	sync.Mutex
+	now      int64         // Current virtual time
+	running  int           // Number of goroutines of the Env that are not waiting
+	ready    int           // Number of waits on Tracks with a message or closed
+	sleepers sleeperHeap   // Goroutines asleep, by time of wake-up
+	idle     chan struct{} // Signalled when the counts drop to zero
+	done     chan struct{} // Closed by stop
+}
+
+// Track accounts, on the clock of a virtual Env, for the messages on a channel and its close,
+// see Env.Wait. Its zero value is ready to use. The fields are guarded by the lock of the clock.
+type Track struct {
+	n       int  // Number of messages sent and not yet received
+	closed  bool // Whether the channel is closed
+	waiters int  // Number of goroutines waiting on the channel
+}
+
+// waking returns the number of waits on k that the channel of k is about to end
+func (k *Track) waking() int {
+	if k.n > 0 || k.closed {
+		return k.waiters
+	}
+	return 0
+}
+
+// sleeper is a goroutine asleep until time wake, which is woken by closing ch
+type sleeper struct {
+	wake  int64
+	ch    chan struct{}
+	track Track // Accounts for ch
+	index int   // Index in the heap, or -1 once removed
+}
+
+// newVirtualClock returns a clock at time now, with one goroutine running, that which calls it
+func newVirtualClock(now int64) *virtualClock {
+	c := &virtualClock{now: now, running: 1, idle: make(chan struct{}, 1), done: make(chan struct{})}
+	go c.loop()
+	return c
+}
+
+// Now returns the virtual time
+func (c *virtualClock) Now() int64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+// Start counts a goroutine that is about to start
+func (c *virtualClock) Start() {
+	c.Lock()
+	defer c.Unlock()
+	c.running++
+}
+
+// Exit stops counting a goroutine that has finished
+func (c *virtualClock) Exit() {
+	c.Lock()
+	defer c.Unlock()
+	c.running--
+	c.check()
+}
+
+// Sleep returns a sleeper that is woken ns nanoseconds of virtual time from now. The caller
+// waits on its track.
+func (c *virtualClock) Sleep(ns int64) *sleeper {
+	c.Lock()
+	defer c.Unlock()
+	s := &sleeper{wake: c.now + ns, ch: make(chan struct{})}
+	heap.Push(&c.sleepers, s)
+	return s
+}
+
+// Cancel removes s from the sleepers, unless it has been woken already
+func (c *virtualClock) Cancel(s *sleeper) {
+	c.Lock()
+	defer c.Unlock()
+	if s.index >= 0 {
+		heap.Remove(&c.sleepers, s.index)
+	}
+}
+
+// Sent records a message sent on the channel of k
+func (c *virtualClock) Sent(k *Track) {
+	c.Lock()
+	defer c.Unlock()
+	c.ready -= k.waking()
+	k.n++
+	c.ready += k.waking()
+}
+
+// Received records a message received from the channel of k
+func (c *virtualClock) Received(k *Track) {
+	c.Lock()
+	defer c.Unlock()
+	c.ready -= k.waking()
+	k.n--
+	c.ready += k.waking()
+}
+
+// Closed records the close of the channel of k
+func (c *virtualClock) Closed(k *Track) {
+	c.Lock()
+	defer c.Unlock()
+	c.close(k)
+}
+
+// Release records the close of the channel of k, and counts the goroutines that wait on it as
+// running, for they do not call Woken
+func (c *virtualClock) Release(k *Track) {
+	c.Lock()
+	defer c.Unlock()
+	c.close(k)
+	c.ready -= k.waking()
+	c.running += k.waiters
+	k.waiters = 0
+}
+
+// close is Closed with the clock locked
+func (c *virtualClock) close(k *Track) {
+	c.ready -= k.waking()
+	k.closed = true
+	c.ready += k.waking()
+}
+
+// Wait stops counting the calling goroutine as running, while it waits on the channels of ks
+func (c *virtualClock) Wait(ks []*Track) {
+	c.Lock()
+	defer c.Unlock()
+	for _, k := range ks {
+		if k != nil {
+			k.waiters++
+			if k.n > 0 || k.closed {
+				c.ready++
+			}
+		}
+	}
+	c.running--
+	c.check()
+}
+
+// Woken counts the calling goroutine as running again, once its wait on the channels of ks is
+// over
+func (c *virtualClock) Woken(ks []*Track) {
+	c.Lock()
+	defer c.Unlock()
+	for _, k := range ks {
+		if k != nil {
+			if k.n > 0 || k.closed {
+				c.ready--
+			}
+			k.waiters--
+		}
+	}
+	c.running++
+}
+
+// check signals the loop of the clock if all goroutines are waiting. The clock must be locked.
+func (c *virtualClock) check() {
+	if c.running == 0 && c.ready == 0 {
+		wakeup(c.idle)
+	}
+}
+
+// stop ends the loop of the clock. Sleepers are no longer woken.
+func (c *virtualClock) stop() {
+	close(c.done)
+}
+
+// loop advances the clock whenever all goroutines of the Env are waiting, see virtualClock
+func (c *virtualClock) loop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.idle:
+		}
+		c.Lock()
+		if c.running == 0 && c.ready == 0 && len(c.sleepers) > 0 {
+			c.now = max64(c.now, c.sleepers[0].wake)
+			for len(c.sleepers) > 0 && c.sleepers[0].wake <= c.now {
+				s := heap.Pop(&c.sleepers).(*sleeper)
+				c.close(&s.track)
+				close(s.ch)
+			}
+		}
+		c.Unlock()
+	}
+}
+
+// wakeup places a token on ch, unless one is there already
+func wakeup(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// sleeperHeap implements heap.Interface, ordering sleepers by time of wake-up
+type sleeperHeap []*sleeper
+
+func (h sleeperHeap) Len() int { return len(h) }
+
+func (h sleeperHeap) Less(i, j int) bool { return h[i].wake < h[j].wake }
+
+func (h sleeperHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *sleeperHeap) Push(x interface{}) {
+	s := x.(*sleeper)
+	s.index = len(*h)
+	*h = append(*h, s)
+}
+
+func (h *sleeperHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	s.index = -1
+	*h = old[:len(old)-1]
+	return s
+}
@@ -0,0 +1,260 @@
+// Copyright 2011-2013 GoDCCP Authors. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package dccp
+
+import (
+	"sync"
+	"time"
+)
+
+// A server with tens of thousands of idle connections cannot afford a goroutine, or a runtime
+// timer, for every timer of every connection. The protocol timers and the idle polls of the
+// connections are kept in a hierarchical timer wheel instead, whose single goroutine sleeps
+// until the earliest of them and fires them in turn. The wheel rounds times up to ticks of
+// wheelTick, and adds, moves and cancels timers in constant time.
+//
+// Level 0 of the wheel has a slot for each of the next wheelSlots ticks. A slot of level l
+// covers wheelSlots^l ticks, and its timers are cascaded to the level below when the wheel
+// reaches the start of the slot. Timers beyond the reach of the top level wait in its last
+// slot and are placed again once they come round.
+
+const (
+	wheelTick   = 1e6 // Length of a tick of the wheel, 1 ms in ns
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits // Number of slots at each level
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4
+	wheelSpan   = 1 << (wheelBits * wheelLevels) // Reach of the wheel in ticks, about 4.6 hours
+)
+
+// wheelTimer is a timer of a timerWheel. Its fire function is called by the goroutine of the
+// wheel, without locks held, so it must not block for long.
+type wheelTimer struct {
+	fire       func()
+	at         int64       // Tick at which the timer fires
+	prev, next *wheelTimer // Neighbours in the list of a slot, or nil if the timer is not pending
+}
+
+// pending returns true if t is scheduled and has not fired yet. The wheel must be locked.
+func (t *wheelTimer) pending() bool {
+	return t.next != nil
+}
+
+// timerWheel fires wheelTimers on the time of an Env, see Env.timerWheel
+type timerWheel struct {
+	env *Env // Clock of the wheel, or nil for real time
+
+	sync.Mutex
+	tick    int64 // Next tick to process
+	n       int   // Number of pending timers
+	running bool  // True while the goroutine of the wheel runs
+	until   int64 // Tick that the goroutine sleeps until
+	wake    chan struct{}
+	wakeT   Track                               // Accounts for wake on the clock of env
+	slots   [wheelLevels][wheelSlots]wheelTimer // Heads of the circular lists of the slots
+}
+
+// realWheel is the timer wheel of all Envs of real time
+var realWheel = newTimerWheel(nil)
+
+// newTimerWheel creates a timer wheel on the time of env, or on real time if env is nil. The
+// goroutine of the wheel only runs while timers are pending.
+func newTimerWheel(env *Env) *timerWheel {
+	w := &timerWheel{env: env, wake: make(chan struct{}, 1)}
+	for l := range w.slots {
+		for i := range w.slots[l] {
+			head := &w.slots[l][i]
+			head.prev, head.next = head, head
+		}
+	}
+	return w
+}
+
+// now returns the current time of the wheel in nanoseconds
+func (w *timerWheel) now() int64 {
+	if w.env != nil {
+		return w.env.nowNano()
+	}
+	return time.Now().UnixNano()
+}
+
+// sleep sleeps for ns nanoseconds, or until the wheel is woken up
+func (w *timerWheel) sleep(ns int64) {
+	if w.env != nil {
+		if !w.env.SleepOrDone(time.Duration(ns), w.wake, &w.wakeT) {
+			w.env.Received(&w.wakeT)
+		}
+		return
+	}
+	timer := time.NewTimer(time.Duration(ns))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-w.wake:
+	}
+}
+
+// schedule arranges for t to fire at time at, in nanoseconds, or right away if at has passed.
+// A pending t is moved.
+func (w *timerWheel) schedule(t *wheelTimer, at int64) {
+	w.Lock()
+	defer w.Unlock()
+	if t.pending() {
+		w.unlink(t)
+		w.n--
+	}
+	if !w.running {
+		// The wheel stood still while it had nothing to do
+		w.running = true
+		w.tick = w.now() / wheelTick
+		w.until = w.tick
+		if w.env != nil {
+			w.env.Go(w.loop, "timerWheel·loop")
+		} else {
+			go w.loop()
+		}
+	}
+	t.at = (at + wheelTick - 1) / wheelTick
+	w.insert(t)
+	w.n++
+	// A timer earlier than the sleep of the goroutine cuts it short
+	if t.at < w.until {
+		w.env.wakeup(w.wake, &w.wakeT)
+	}
+}
+
+// cancel stops t from firing, if it is pending. A t that is firing already may still fire.
+func (w *timerWheel) cancel(t *wheelTimer) {
+	w.Lock()
+	defer w.Unlock()
+	if t.pending() {
+		w.unlink(t)
+		w.n--
+	}
+}
+
+// insert places t in the slot of its tick. The wheel must be locked.
+func (w *timerWheel) insert(t *wheelTimer) {
+	at := max64(t.at, w.tick)
+	d := at - w.tick
+	if d >= wheelSpan {
+		at, d = w.tick+wheelSpan-1, wheelSpan-1
+	}
+	l := 0
+	for d >= 1<<(wheelBits*(l+1)) {
+		l++
+	}
+	head := &w.slots[l][(at>>(wheelBits*l))&wheelMask]
+	t.prev, t.next = head.prev, head
+	head.prev.next = t
+	head.prev = t
+}
+
+// unlink removes t from its slot. The wheel must be locked.
+func (w *timerWheel) unlink(t *wheelTimer) {
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev, t.next = nil, nil
+}
+
+// takeSlot empties the slot i of level l and returns its first timer, or nil. The timers of
+// the slot remain linked to each other, and the last of them to the head of the slot.
+func (w *timerWheel) takeSlot(l int, i int64) (first, head *wheelTimer) {
+	head = &w.slots[l][i]
+	if head.next == head {
+		return nil, head
+	}
+	first = head.next
+	head.prev, head.next = head, head
+	return first, head
+}
+
+// advance processes the ticks up to and including now, and returns the timers that have come
+// due, in the order they fire. The wheel must be locked.
+func (w *timerWheel) advance(now int64, due []*wheelTimer) []*wheelTimer {
+	for ; w.tick <= now; w.tick++ {
+		i := w.tick & wheelMask
+		if i == 0 {
+			for l := 1; l < wheelLevels; l++ {
+				j := (w.tick >> (wheelBits * l)) & wheelMask
+				t, head := w.takeSlot(l, j)
+				for t != nil && t != head {
+					next := t.next
+					w.insert(t)
+					t = next
+				}
+				if j != 0 {
+					break
+				}
+			}
+		}
+		t, head := w.takeSlot(0, i)
+		for t != nil && t != head {
+			next := t.next
+			if t.at > w.tick {
+				// Placed in the last slot of the wheel for lack of reach
+				w.insert(t)
+			} else {
+				t.prev, t.next = nil, nil
+				w.n--
+				due = append(due, t)
+			}
+			t = next
+		}
+	}
+	return due
+}
+
+// nextTick returns the tick that the goroutine must wake up at: that of the earliest timer at
+// level 0, or that at which the earliest of the slots above with timers is cascaded, whichever
+// comes first. At least one timer must be pending. The wheel must be locked.
+func (w *timerWheel) nextTick() int64 {
+	next := w.tick + wheelSpan
+	for t := w.tick; t < w.tick+wheelSlots; t++ {
+		if head := &w.slots[0][t&wheelMask]; head.next != head {
+			next = t
+			break
+		}
+	}
+	for l := 1; l < wheelLevels; l++ {
+		// The slots of level l are cascaded at the ticks that are multiples of their length
+		span := int64(1) << (wheelBits * l)
+		for t := (w.tick + span - 1) &^ (span - 1); t < next; t += span {
+			if head := &w.slots[l][(t>>(wheelBits*l))&wheelMask]; head.next != head {
+				next = t
+				break
+			}
+		}
+	}
+	return next
+}
+
+// loop fires the timers of the wheel as they come due, until none is pending
+func (w *timerWheel) loop() {
+	var due []*wheelTimer
+	for {
+		w.Lock()
+		due = w.advance(w.now()/wheelTick, due[:0])
+		if len(due) == 0 && w.n == 0 {
+			w.running = false
+			w.Unlock()
+			return
+		}
+		if len(due) > 0 {
+			w.until = w.tick
+			w.Unlock()
+			for i, t := range due {
+				due[i] = nil
+				t.fire()
+			}
+			continue
+		}
+		w.until = w.nextTick()
+		w.Unlock()
+		if wait := w.until*wheelTick - w.now(); wait > 0 {
+			w.sleep(wait)
+		}
+	}
+}
@@ -86,13 +86,22 @@ func (gh *Header) getHeaderFootprint(allowShortSeqNoFeature bool) (int, error) {
 	return r, nil
 }
 
-// Write() writes the DCCP header to two return buffers.
-// The first one is the header part, and the second one is the data
-// part which simply equals the slice Header.Data
+// Write() returns the wire format of the DCCP packet, header and application data, the
+// latter gathered from Data and DataVec
 func (gh *Header) Write(sourceIP, destIP []byte,
 	protoNo byte,
 	allowShortSeqNoFeature bool) (header []byte, err error) {
 
+	return gh.Append(nil, sourceIP, destIP, protoNo, allowShortSeqNoFeature)
+}
+
+// Append appends the wire format of the DCCP packet to dst and returns the extended slice, as
+// Write does. If dst has the capacity for the packet, Append does not allocate, so that a
+// sender can encode packet after packet into one preallocated buffer.
+func (gh *Header) Append(dst []byte, sourceIP, destIP []byte,
+	protoNo byte,
+	allowShortSeqNoFeature bool) (packet []byte, err error) {
+
 	err = verifyIPAndProto(sourceIP, destIP, protoNo)
 	if err != nil {
 		return nil, err
@@ -102,7 +111,14 @@ func (gh *Header) Write(sourceIP, destIP []byte,
 	if err != nil {
 		return nil, err
 	}
-	buf := make([]byte, dataOffset+len(gh.Data))
+	dlen := gh.DataLen()
+	m, n := len(dst), dataOffset+dlen
+	if cap(dst)-m < n {
+		grown := make([]byte, m, m+n)
+		copy(grown, dst)
+		dst = grown
+	}
+	buf := dst[m : m+n]
 
 	k := 0
 
@@ -176,7 +192,7 @@ func (gh *Header) Write(sourceIP, destIP []byte,
 	// Write (1c) Code Subheader: Service Code, or Reset Code and Reset Data fields
 	switch gh.Type {
 	case Request, Response:
-		EncodeUint32(gh.ServiceCode, buf[k:k+4])
+		EncodeUint32(uint32(gh.ServiceCode), buf[k:k+4])
 		k += 4
 	case Reset:
 		buf[k] = gh.ResetCode
@@ -190,8 +206,13 @@ func (gh *Header) Write(sourceIP, destIP []byte,
 	// Write (2) Options and Padding
 	writeOptions(gh.Options, buf[k:dataOffset], gh.Type)
 
+	// Write data
+	k = dataOffset + copy(buf[dataOffset:], gh.Data)
+	for _, f := range gh.DataVec {
+		k += copy(buf[k:], f)
+	}
+
 	// Write checksum
-	dlen := len(gh.Data)
 	appCov, err := getChecksumAppCoverage(gh.CsCov, dlen)
 	if err != nil {
 		return nil, err
@@ -199,15 +220,12 @@ func (gh *Header) Write(sourceIP, destIP []byte,
 	csum := csumSum(buf[0:dataOffset])
 	csum = csumAdd(csum, csumPseudoIP(sourceIP, destIP, protoNo, len(buf)))
 	if appCov > 0 {
-		csum = csumAdd(csum, csumSum(gh.Data[0:appCov]))
+		csum = csumAdd(csum, csumSum(buf[dataOffset:dataOffset+appCov]))
 	}
 	csum = csumDone(csum)
 	csumUint16ToBytes(csum, buf[6:8])
 
-	// Write data
-	copy(buf[dataOffset:], gh.Data)
-
-	return buf, nil
+	return dst[:m+n], nil
 }
 
 func writeOptions(opts []*Option, buf []byte, Type byte) {